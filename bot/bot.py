@@ -10,6 +10,10 @@ from text_to_audio.edge_tts import EdgeTTSService
 import logging
 from config import Greetings,PauseText,StopResponseText
 from rag.neo4j import Neo4jQueryEngine
+from context_window.builder import (ContextBuilder, ConversationSession, UserMemory,
+                                    transcript_source, make_kg_source, make_memory_source, flow_source)
+from verification.flow import VerificationFlow
+from fallback.breaker import CircuitBreaker
 
 # Configure logging
 logging.basicConfig(
@@ -31,6 +35,16 @@ app = FastAPI()
 
 Neo4jQueryEngine.setup_llm()
 query_engine = Neo4jQueryEngine()
+user_memory = UserMemory()
+llm_breaker = CircuitBreaker.from_env("llm")
+kg_breaker = CircuitBreaker.from_env("kg")
+context_builder = ContextBuilder.from_env({
+    "flow": flow_source,
+    "memory": make_memory_source(user_memory),
+    "kg": make_kg_source(query_engine, kg_breaker),
+    "transcript": transcript_source,
+})
+verification = VerificationFlow.from_env()
 
 app.add_middleware(
     CORSMiddleware,
@@ -43,10 +57,88 @@ app.add_middleware(
 
 # Stores: {bot_id: {"task": asyncio.Task, "manager": SocketManager}}
 active_bots = {}
+# Stores: {bot_id: ConversationSession}, kept after leaving so the last context can still be inspected
+sessions = {}
+MAX_SESSIONS = 200
 SOCKET_URL="localhost:8080"
+ANSWER_CACHE = os.getenv("ANSWER_CACHE", "").lower() in ("1", "true", "on")
+
+async def say(socket_manager: SocketManager, session: ConversationSession, text: str):
+    audio = await tts_service.text_to_audio_bytes(text)
+    await socket_manager.send_message(msg_type="bot_message", data={"text": text})
+    await socket_manager.send_message(raw_audio=audio)
+    session.add_turn("assistant", text)
+
+async def report_integration(socket_manager: SocketManager, session: ConversationSession, integration: str, error):
+    """Tell the server when an integration goes down or comes back for this call, returns True if it changed"""
+    if error:
+        if integration in session.degraded:
+            return False
+        session.degraded[integration] = error
+        await socket_manager.send_message(msg_type="integration_status",
+                                          data={"integration": integration, "state": "open", "error": error})
+        return True
+    if session.degraded.pop(integration, None) is None:
+        return False
+    await socket_manager.send_message(msg_type="integration_status", data={"integration": integration, "state": "closed"})
+    return True
+
+async def answer_question(socket_manager: SocketManager, session: ConversationSession, question: str, lookup: bool = True):
+    if session.handed_off:
+        return
+    # Common questions may have a cached answer the server plays at once, answer_cached says
+    if lookup and ANSWER_CACHE:
+        session.pending_question = question
+        await socket_manager.send_message(msg_type="answer_lookup", data={"question": question, "speak": True})
+        return
+    pause=pause_text.pick_random_pause()
+    pause_audio=await tts_service.text_to_audio_bytes(pause)
+    await socket_manager.send_message(
+                msg_type="bot_message", 
+                 data={"text": pause}
+             )
+    await socket_manager.send_message(raw_audio=pause_audio)
+    
+    session.add_turn("user", question)
+    session.flow_state["stage"] = "answering"
+    window = context_builder.build(question, session)
+    # Without the knowledge graph the LLM still answers, from the rest of the context
+    if "kg" in context_builder.sources:
+        await report_integration(socket_manager, session, "kg", window.trace.errors.get("kg"))
+    response = None
+    if llm_breaker.allow():
+        try:
+            response=query_engine.answer(question, window.render())
+            llm_breaker.success()
+        except Exception as e:
+            logger.error(f"LLM answer failed: {e}")
+            llm_breaker.failure(e)
+    if response is None:
+        changed = await report_integration(socket_manager, session, "llm", llm_breaker.last_error or "circuit open")
+        # The server's fallback answers the first time, its canned prompt stands in after that
+        fallback = session.fallbacks.get("llm", {})
+        if not changed and fallback.get("action") == "canned" and fallback.get("text"):
+            await say(socket_manager, session, fallback["text"])
+        session.flow_state["stage"] = "llm unavailable"
+        return
+    await report_integration(socket_manager, session, "llm", None)
+    session.add_turn("assistant", response)
+    session.flow_state["stage"] = "answered"
+    user_memory.remember(session.memory_key(), f"Asked: {question}")
+    response_audio=await tts_service.text_to_audio_bytes(response)
+    # Nothing about this caller went into the answer, so other callers may get it too
+    cacheable = session.customer_id is None and session.verification is None and not any(
+        entry.included and entry.source == "memory" for entry in window.trace.entries)
+    await socket_manager.send_message(
+                msg_type="assistant_final", 
+                 data={"text": response, "citations": window.citations[:20], "question": question, "cache": cacheable,
+                       "kgVersion": session.kg_snapshot}
+             )
+    await socket_manager.send_message(raw_audio=response_audio)
 
 async def on_receive(from_bot, data, message_type, socket_manager:SocketManager):
     """Example callback for handling received messages"""
+    session = sessions.get(from_bot) or ConversationSession(from_bot, socket_manager.call_id)
     if message_type == "processed_audio":
         print(f"[Example] Received processed audio from {from_bot}:")
         print(f"  - Duration: {data['duration_seconds']:.2f}s")
@@ -91,35 +183,93 @@ async def on_receive(from_bot, data, message_type, socket_manager:SocketManager)
                     msg_type="cancel_audio"
                 )
         
-        pause=pause_text.pick_random_pause()
-        pause_audio=await tts_service.text_to_audio_bytes(pause)
-        await socket_manager.send_message(
-                    msg_type="bot_message", 
-                     data={"text": pause}
-                 )
-        await socket_manager.send_message(raw_audio=pause_audio)
+        # Answers to security questions go to the server only, never into the history the LLM sees
+        if session.verification is not None and session.verification.collecting():
+            prompt, answer = verification.on_answer(session, transcribed_text)
+            if answer:
+                await socket_manager.send_message(msg_type="verify_answer", data=answer)
+            else:
+                await say(socket_manager, session, prompt)
+            return
+        if verification.needs_verification(session, transcribed_text):
+            await socket_manager.send_message(msg_type="verify_start", data=verification.start(session, transcribed_text))
+            await say(socket_manager, session, "Before I can help with that, I need to verify your identity.")
+            return
         
-        response=query_engine.query(transcribed_text)
-        response_audio=await tts_service.text_to_audio_bytes(response)
-        await socket_manager.send_message(
-                    msg_type="bot_message", 
-                     data={"text": response}
-                 )
-        await socket_manager.send_message(raw_audio=response_audio)
+        await answer_question(socket_manager, session, transcribed_text)
         # Optionally, send back the audio chunk (remove if not needed)
 
         
     elif message_type == "json":
         print(f"[Example] Received JSON from {from_bot}: {data}")
+        if isinstance(data, dict) and data.get("type") in ("welcome", "room_config"):
+            # Retrieval stays within the knowledge graph partition the room is bound to, and on its pinned snapshot.
+            # room_config carries the same room config when an operator refreshes it mid-call
+            room = data.get("data", {}).get("room") or {}
+            session.kg_partition = room.get("kgPartition") or None
+            session.kg_snapshot = room.get("kgSnapshot") or None
+        elif isinstance(data, dict) and data.get("type") == "client_joined":
+            joined = data.get("data", {})
+            if joined.get("clientType") == "user":
+                session.user_id = joined.get("clientId")
+        elif isinstance(data, dict) and data.get("type") == "verify_challenge":
+            prompt = verification.on_challenge(session, data.get("data", {}))
+            if prompt:
+                await say(socket_manager, session, prompt)
+        elif isinstance(data, dict) and data.get("type") == "verify_result":
+            text, question = verification.on_result(session, data.get("data", {}))
+            if text:
+                await say(socket_manager, session, text)
+            if question:
+                await answer_question(socket_manager, session, question)
+        elif isinstance(data, dict) and data.get("type") == "fallback":
+            fallback = data.get("data", {})
+            session.fallbacks[fallback.get("integration")] = fallback
+            # The server plays the prompt itself when it can
+            if fallback.get("text") and not fallback.get("audio"):
+                await say(socket_manager, session, fallback["text"])
+            if fallback.get("action") in ("human", "callback"):
+                session.handed_off = True
+        elif isinstance(data, dict) and data.get("type") == "answer_cached":
+            answer = data.get("data", {})
+            question, session.pending_question = session.pending_question, None
+            if question is None:
+                return
+            if not answer.get("hit"):
+                await answer_question(socket_manager, session, question, lookup=False)
+                return
+            session.add_turn("user", question)
+            session.add_turn("assistant", answer.get("text", ""))
+            session.flow_state["stage"] = "answered from cache"
+            # The server has posted the answer, it only needs speaking
+            if not answer.get("spoken"):
+                await socket_manager.send_message(raw_audio=await tts_service.text_to_audio_bytes(answer.get("text", "")))
+        elif isinstance(data, dict) and data.get("type") == "fallback_cleared":
+            session.fallbacks.pop(data.get("data", {}).get("integration"), None)
+        elif isinstance(data, dict) and data.get("type") == "speaker_recognized":
+            # A returning caller: remember under their customer id so last call's facts come back
+            recognized = data.get("data", {})
+            if recognized.get("userId") in (None, session.user_id):
+                session.customer_id = recognized.get("customerId")
+                session.flow_state["caller"] = f"recognized by voice as customer {session.customer_id}"
         
 async def on_send(message):
     logger.info(f"[Received] {message}")
-async def bot_join_call(call_id, bot_id):
+async def bot_join_call(call_id, bot_id, greeting=None, voicemail=None):
     logger.info(f"[Received] {call_id}")
     manager = SocketManager(base_url=SOCKET_URL,call_id=call_id,bot_id=bot_id)  # pass call_id here
     try:
         await manager.connect( on_receive=on_receive, on_send=on_send)
-        text_greetings=greetings.pick_random_greeting()
+        if voicemail:
+            # An answering machine took an outbound call: leave the message, then hang up
+            await asyncio.sleep(1)
+            await say(manager, sessions[bot_id], voicemail)
+            await asyncio.sleep(1 + 0.4 * len(voicemail.split()))  # Roughly the time to play it
+            sessions[bot_id].flow_state["stage"] = "voicemail left"
+            await manager.disconnect()
+            active_bots.pop(bot_id, None)
+            return
+        text_greetings=greeting or greetings.pick_random_greeting()
         greeting_audio=await tts_service.text_to_audio_bytes(text_greetings)
         await asyncio.sleep(1)
         await manager.send_message(
@@ -127,6 +277,8 @@ async def bot_join_call(call_id, bot_id):
                      data={"text": text_greetings}
                  )
         await manager.send_message(raw_audio=greeting_audio)
+        sessions[bot_id].add_turn("assistant", text_greetings)
+        sessions[bot_id].flow_state["stage"] = "greeted"
         while True:
             await asyncio.sleep(1)
     except asyncio.CancelledError:
@@ -150,7 +302,14 @@ async def join_call(request: Request):
     if bot_id in active_bots:
         return {"status": "bot already active", "bot_id": bot_id}
     
-    task = asyncio.create_task(bot_join_call(call_id, bot_id))
+    sessions[bot_id] = ConversationSession(bot_id, call_id)
+    # Outbound calls name the flow (the room's template) and campaign they belong to
+    for key in ("flow", "campaign"):
+        if data.get(key):
+            sessions[bot_id].flow_state[key] = data[key]
+    while len(sessions) > MAX_SESSIONS:
+        sessions.pop(next(iter(sessions)))
+    task = asyncio.create_task(bot_join_call(call_id, bot_id, data.get("greeting"), data.get("voicemail")))
     active_bots[bot_id] = {"task": task, "call_id": call_id}
 
     return {"status": "bot joining", "bot_id": bot_id, "call_id": call_id}
@@ -175,3 +334,13 @@ async def leave_call(request: Request):
         return {"status": "bot left"}
 
     return {"status": "bot not found"}
+
+@app.get("/context/{bot_id}")
+async def last_context(bot_id: str):
+    """What went into the bot's last answer: every candidate item, kept or dropped, and why"""
+    session = sessions.get(bot_id)
+    if not session:
+        return {"error": "bot not found"}
+    if not session.last_trace:
+        return {"bot_id": bot_id, "call_id": session.call_id, "trace": None}
+    return {"bot_id": bot_id, "call_id": session.call_id, "trace": session.last_trace.to_dict()}
@@ -0,0 +1,295 @@
+import os
+import time
+import hashlib
+import logging
+from collections import deque
+from dataclasses import dataclass, field, asdict
+from typing import Callable, Deque, Dict, List, Optional, Tuple
+
+logger = logging.getLogger(__name__)
+
+# Assembles the LLM context for each virtual-agent turn. Every source turns the
+# current question into a list of ContextItems; the builder keeps the highest
+# priority items that fit the token budget and records what it kept, dropped
+# and why, so an answer can be traced back to what the model actually saw.
+
+
+def _token_counter() -> Callable[[str], int]:
+    """Return a tiktoken based counter, or a ~4 characters per token estimate"""
+    try:
+        import tiktoken
+        encoding = tiktoken.get_encoding("cl100k_base")
+        return lambda text: len(encoding.encode(text))
+    except Exception as e:
+        logger.warning(f"[ContextBuilder] tiktoken unavailable ({e}), estimating tokens from length")
+        return lambda text: (len(text) + 3) // 4
+
+
+@dataclass
+class ContextItem:
+    source: str
+    text: str
+    priority: float = 0.0  # Higher is kept first when the budget is tight
+    label: str = ""        # Short description for the trace, e.g. "user turn 3"
+    tokens: int = 0
+    citations: List[dict] = field(default_factory=list)  # Sent with the answer when the item is kept
+
+
+@dataclass
+class TraceEntry:
+    source: str
+    label: str
+    tokens: int
+    included: bool
+    reason: str = ""
+    preview: str = ""
+
+
+@dataclass
+class ContextTrace:
+    bot_id: str
+    call_id: str
+    question: str
+    budget: int
+    used: int = 0
+    created_at: float = field(default_factory=time.time)
+    entries: List[TraceEntry] = field(default_factory=list)
+    errors: Dict[str, str] = field(default_factory=dict)
+
+    def to_dict(self) -> dict:
+        return asdict(self)
+
+    def summary(self) -> str:
+        kept = [e for e in self.entries if e.included]
+        by_source: Dict[str, int] = {}
+        for entry in kept:
+            by_source[entry.source] = by_source.get(entry.source, 0) + entry.tokens
+        parts = ", ".join(f"{source}={tokens}" for source, tokens in by_source.items())
+        return (f"{len(kept)}/{len(self.entries)} items, {self.used}/{self.budget} tokens"
+                f" ({parts or 'none'})")
+
+
+@dataclass
+class ContextWindow:
+    sections: Dict[str, List[str]]
+    trace: ContextTrace
+    citations: List[dict] = field(default_factory=list)
+
+    def render(self) -> str:
+        """Render the kept items as prompt sections, in source order"""
+        titles = {
+            "flow": "Conversation state",
+            "memory": "What we know about the caller",
+            "kg": "Knowledge graph results",
+            "transcript": "Recent conversation",
+        }
+        blocks = []
+        for source, texts in self.sections.items():
+            if texts:
+                blocks.append(f"{titles.get(source, source)}:\n" + "\n".join(texts))
+        return "\n\n".join(blocks)
+
+
+class ConversationSession:
+    """Per-call state the context sources read from"""
+
+    def __init__(self, bot_id: str, call_id: str, max_turns: int = 50):
+        self.bot_id = bot_id
+        self.call_id = call_id
+        self.user_id: Optional[str] = None
+        self.customer_id: Optional[str] = None  # Set when the server recognizes the caller's voice
+        self.history: Deque[Tuple[str, str]] = deque(maxlen=max_turns)  # (speaker, text)
+        self.flow_state: Dict[str, str] = {}
+        self.last_trace: Optional[ContextTrace] = None
+        self.verification = None  # verification.flow.VerificationState once the caller is asked to verify
+        self.degraded: Dict[str, str] = {}      # Integration to the error last reported with integration_status
+        self.fallbacks: Dict[str, dict] = {}    # Integration to the server's fallback message data
+        self.handed_off = False                 # A human or a callback takes over, the bot stops answering
+        self.pending_question: Optional[str] = None  # Asked while its answer_lookup is out
+        self.kg_partition: Optional[str] = None      # Graph partition the server bound the room to, see welcome
+        self.kg_snapshot: Optional[str] = None       # Graph snapshot the call is pinned to, likewise
+
+    def memory_key(self) -> Optional[str]:
+        """Who facts are remembered under: the customer once known, else this call's user"""
+        return self.customer_id or self.user_id
+
+    def add_turn(self, speaker: str, text: str):
+        if text and text.strip():
+            self.history.append((speaker, text.strip()))
+
+
+class UserMemory:
+    """Facts remembered about a caller across calls, kept in process"""
+
+    def __init__(self, max_facts: int = 20):
+        self.max_facts = max_facts
+        self.facts: Dict[str, Deque[str]] = {}
+
+    def remember(self, user_id: str, fact: str):
+        if not user_id or not fact.strip():
+            return
+        facts = self.facts.setdefault(user_id, deque(maxlen=self.max_facts))
+        if fact.strip() not in facts:
+            facts.append(fact.strip())
+
+    def recall(self, user_id: Optional[str]) -> List[str]:
+        return list(self.facts.get(user_id or "", []))
+
+
+# Sources take (question, session) and return items. They may raise; the
+# builder records the error in the trace and carries on without them.
+
+def transcript_source(question: str, session: ConversationSession) -> List[ContextItem]:
+    items = []
+    turns = list(session.history)
+    # The current question is the last user turn and goes into the prompt itself
+    if turns and turns[-1] == ("user", question.strip()):
+        turns = turns[:-1]
+    for age, (speaker, text) in enumerate(reversed(turns)):
+        items.append(ContextItem(
+            source="transcript",
+            text=f"{speaker}: {text}",
+            priority=1.0 / (age + 1),
+            label=f"{speaker} turn -{age + 1}",
+        ))
+    return items
+
+
+def make_kg_source(query_engine, breaker=None) -> Callable[[str, ConversationSession], List[ContextItem]]:
+    def kg_source(question: str, session: ConversationSession) -> List[ContextItem]:
+        if breaker is not None and not breaker.allow():
+            raise RuntimeError(f"circuit open after: {breaker.last_error}")
+        try:
+            cypher, results, sources = query_engine.retrieve(question, session.kg_partition, session.kg_snapshot)
+        except Exception as e:
+            if breaker is not None:
+                breaker.failure(e)
+            raise
+        if breaker is not None:
+            breaker.success()
+        query_citation = {
+            "type": "kg_query",
+            "id": hashlib.sha1(cypher.encode()).hexdigest()[:12],
+            "ref": cypher[:1024],
+        }
+        items = [ContextItem(source="kg", text=f"Cypher: {cypher}", priority=2.0, label="cypher",
+                             citations=[query_citation])]
+        if not results:
+            items.append(ContextItem(source="kg", text="(no matching records)", priority=2.0, label="no results"))
+        # Earlier rows are what the query ordered first, keep them first
+        for i, row in enumerate(results):
+            citations = [dict(source, snippet=str(row)[:1024]) for source in sources[i]]
+            items.append(ContextItem(source="kg", text=str(row), priority=1.5 - i / (len(results) + 1),
+                                     label=f"row {i + 1}", citations=citations))
+        return items
+    return kg_source
+
+
+def make_memory_source(memory: UserMemory) -> Callable[[str, ConversationSession], List[ContextItem]]:
+    def memory_source(question: str, session: ConversationSession) -> List[ContextItem]:
+        facts = memory.recall(session.memory_key())
+        return [ContextItem(source="memory", text=fact, priority=1.2, label=f"fact {i + 1}")
+                for i, fact in enumerate(facts)]
+    return memory_source
+
+
+def flow_source(question: str, session: ConversationSession) -> List[ContextItem]:
+    return [ContextItem(source="flow", text=f"{key}: {value}", priority=3.0, label=key)
+            for key, value in session.flow_state.items()]
+
+
+class ContextBuilder:
+    def __init__(self,
+                 sources: Dict[str, Callable[[str, ConversationSession], List[ContextItem]]],
+                 token_budget: int = 3000,
+                 source_budgets: Optional[Dict[str, int]] = None,
+                 counter: Optional[Callable[[str], int]] = None):
+        self.sources = sources  # Insertion order is the order sections are rendered
+        self.token_budget = token_budget
+        self.source_budgets = source_budgets or {}
+        self.count_tokens = counter or _token_counter()
+
+        logger.info(f"[ContextBuilder] Sources: {', '.join(sources) or 'none'}, budget: {token_budget} tokens")
+
+    @classmethod
+    def from_env(cls, available: Dict[str, Callable[[str, ConversationSession], List[ContextItem]]]):
+        """
+        Configure from the environment:
+            CONTEXT_SOURCES        comma separated, in render order (default: every available source)
+            CONTEXT_TOKEN_BUDGET   total tokens for context items (default 3000)
+            CONTEXT_SOURCE_BUDGETS e.g. "transcript=1200,kg=1500" caps per source
+        """
+        names = [n.strip() for n in os.getenv("CONTEXT_SOURCES", ",".join(available)).split(",") if n.strip()]
+        unknown = [n for n in names if n not in available]
+        if unknown:
+            raise ValueError(f"Unknown context sources {unknown}, available: {list(available)}")
+
+        source_budgets = {}
+        for entry in os.getenv("CONTEXT_SOURCE_BUDGETS", "").split(","):
+            if "=" in entry:
+                name, value = entry.split("=", 1)
+                source_budgets[name.strip()] = int(value)
+
+        return cls(
+            sources={name: available[name] for name in names},
+            token_budget=int(os.getenv("CONTEXT_TOKEN_BUDGET", "3000")),
+            source_budgets=source_budgets,
+        )
+
+    def build(self, question: str, session: ConversationSession) -> ContextWindow:
+        trace = ContextTrace(bot_id=session.bot_id, call_id=session.call_id,
+                             question=question, budget=self.token_budget)
+
+        candidates: List[ContextItem] = []
+        for name, source in self.sources.items():
+            try:
+                items = source(question, session)
+            except Exception as e:
+                logger.error(f"[ContextBuilder] Source {name} failed: {e}")
+                trace.errors[name] = str(e)
+                continue
+            for item in items:
+                item.tokens = self.count_tokens(item.text) + 1  # Plus the newline joining it
+                candidates.append(item)
+
+        # Greedy by priority: a tight budget drops the least useful items, not the last source
+        kept = set()
+        used_by_source: Dict[str, int] = {}
+        for index in sorted(range(len(candidates)), key=lambda i: -candidates[i].priority):
+            item = candidates[index]
+            cap = self.source_budgets.get(item.source)
+            if cap is not None and used_by_source.get(item.source, 0) + item.tokens > cap:
+                reason = "source budget"
+            elif trace.used + item.tokens > self.token_budget:
+                reason = "token budget"
+            else:
+                kept.add(index)
+                trace.used += item.tokens
+                used_by_source[item.source] = used_by_source.get(item.source, 0) + item.tokens
+                reason = ""
+            trace.entries.append(TraceEntry(
+                source=item.source,
+                label=item.label,
+                tokens=item.tokens,
+                included=not reason,
+                reason=reason,
+                preview=item.text[:80],
+            ))
+
+        # Keep each source's items in the order the source gave them
+        sections: Dict[str, List[str]] = {name: [] for name in self.sources}
+        citations: Dict[Tuple[str, str], dict] = {}
+        for index, item in enumerate(candidates):
+            if index in kept:
+                sections[item.source].append(item.text)
+                for citation in item.citations:
+                    citations.setdefault((citation["type"], citation["id"]), citation)
+        # Transcript items come newest first, the model reads them oldest first
+        if "transcript" in sections:
+            sections["transcript"].reverse()
+
+        session.last_trace = trace
+        logger.info(f"[ContextBuilder] Bot {session.bot_id} context: {trace.summary()}")
+        for name, error in trace.errors.items():
+            logger.info(f"[ContextBuilder] Bot {session.bot_id} skipped {name}: {error}")
+        return ContextWindow(sections=sections, trace=trace, citations=list(citations.values()))
@@ -0,0 +1,73 @@
+import os
+import time
+import logging
+import threading
+from typing import Optional
+
+logger = logging.getLogger(__name__)
+
+# Circuit breakers for the integrations the bot calls itself, the LLM and the
+# knowledge graph. After BREAKER_THRESHOLD failures in a row a breaker opens
+# and calls are skipped for BREAKER_COOLDOWN seconds, then one probe decides
+# whether it closes again. The bot tells the server with integration_status,
+# and the server's fallback policy decides what the caller hears.
+
+CLOSED = "closed"
+OPEN = "open"
+HALF_OPEN = "half_open"
+
+
+class CircuitBreaker:
+    def __init__(self, name: str, threshold: int = 5, cooldown: float = 30.0):
+        self.name = name
+        self.threshold = max(1, threshold)
+        self.cooldown = cooldown
+        self.state = CLOSED
+        self.failures = 0
+        self.last_error = ""
+        self._opened_at = 0.0
+        self._probe_at: Optional[float] = None
+        self._lock = threading.Lock()
+
+    @classmethod
+    def from_env(cls, name: str):
+        return cls(name,
+                   threshold=int(os.getenv("BREAKER_THRESHOLD", "5")),
+                   cooldown=float(os.getenv("BREAKER_COOLDOWN", "30")))
+
+    def allow(self) -> bool:
+        """Whether a call may go ahead; allowed calls report success() or failure()"""
+        with self._lock:
+            now = time.monotonic()
+            if self.state == CLOSED:
+                return True
+            if self.state == OPEN:
+                if now - self._opened_at < self.cooldown:
+                    return False
+                self._change(HALF_OPEN)
+            # One probe at a time, unless the last one never reported back
+            if self._probe_at is not None and now - self._probe_at < self.cooldown:
+                return False
+            self._probe_at = now
+            return True
+
+    def success(self):
+        with self._lock:
+            if self.state != CLOSED:
+                self._change(CLOSED)
+            self.failures = 0
+            self.last_error = ""
+            self._probe_at = None
+
+    def failure(self, error: Exception):
+        with self._lock:
+            self.failures += 1
+            self.last_error = str(error)
+            self._probe_at = None
+            if self.state == HALF_OPEN or (self.state == CLOSED and self.failures >= self.threshold):
+                self._opened_at = time.monotonic()
+                self._change(OPEN)
+
+    def _change(self, state: str):
+        logger.warning(f"[Breaker] {self.name} {self.state} -> {state} ({self.failures} failures, last: {self.last_error or 'none'})")
+        self.state = state
@@ -4,6 +4,7 @@ from dotenv import load_dotenv
 from llama_index.core import Settings
 from llama_index.llms.azure_openai import AzureOpenAI
 from neo4j import GraphDatabase
+from neo4j.graph import Node, Relationship
 from pathlib import Path
 
 # Load environment variables - try multiple paths
@@ -30,6 +31,11 @@ load_env_variables()
 
 logging.basicConfig(level=logging.INFO)
 
+# Node property listing the partitions a node belongs to, for rooms the server binds to one
+PARTITION_PROPERTY = os.getenv("KG_PARTITION_PROPERTY", "partitions")
+# Database holding each published graph snapshot, e.g. "kg-{snapshot}", so a call reads the one it is pinned to
+SNAPSHOT_DATABASE = os.getenv("NEO4J_SNAPSHOT_DATABASE", "")
+
 
 class Neo4jQueryEngine:
 
@@ -193,9 +199,12 @@ class Neo4jQueryEngine:
             logging.error(f"❌ Error executing Cypher query: {str(e)}")
             return f"❌ Error executing query: {str(e)}"
 
-    def natural_language_to_cypher(self, question):
+    def natural_language_to_cypher(self, question, partition=None):
         """Convert natural language question to Cypher query using Azure OpenAI"""
         schema = self.get_schema()
+        scope = ""
+        if partition:
+            scope = f"5. Every node must be in the caller's partition: add $partition IN n.{PARTITION_PROPERTY} to the WHERE clause for each node variable n, using the $partition parameter, never its value\n"
 
         prompt = f"""
 You are a Neo4j Cypher query expert. Convert the following natural language question into a Cypher query.
@@ -210,7 +219,7 @@ Rules:
 2. Use LIMIT 10 for queries that might return many results
 3. Make sure the query is syntactically correct
 4. Use MATCH, RETURN, WHERE clauses appropriately
-
+{scope}
 Cypher Query:
 """
 
@@ -230,6 +239,81 @@ Cypher Query:
             logging.error(f"❌ Error generating Cypher query: {str(e)}")
             raise
 
+    def retrieve(self, question, partition=None, snapshot=None):
+        """
+        Generate and run the Cypher for a question
+
+        With a partition the query must filter on the $partition parameter, and rows that
+        return a node or relationship tagged outside it are dropped, so a room bound to a
+        partition never sees the rest of the graph. With a snapshot the query runs on that
+        snapshot's database, when NEO4J_SNAPSHOT_DATABASE names them, so the graph can't
+        change under a call
+
+        Returns:
+            (cypher_query, results, sources): sources[i] lists the nodes and relationships
+            row i came from as {"type", "id", "label"}, for citing them in the answer
+        """
+        cypher_query = self.natural_language_to_cypher(question, partition)
+        logging.info(f"📝 Generated Cypher: {cypher_query}")
+        if partition and "$partition" not in cypher_query:
+            logging.warning(f"⚠️ Cypher not scoped to partition {partition}, not running it")
+            return cypher_query, [], []
+
+        database = self.snapshot_database(snapshot)
+        results, sources = [], []
+        with self.driver.session(database=database) as session:
+            for record in session.run(cypher_query, partition=partition):
+                values = [value for value in record.values() if isinstance(value, (Node, Relationship))]
+                if partition and not all(self._in_partition(value, partition) for value in values):
+                    logging.warning(f"⚠️ Dropped a row outside partition {partition}")
+                    continue
+                results.append(record.data())
+                sources.append([self._graph_source(value) for value in values])
+        return cypher_query, results, sources
+
+    @staticmethod
+    def snapshot_database(snapshot=None):
+        """The database a snapshot is served from, the live one without either"""
+        if snapshot and SNAPSHOT_DATABASE:
+            return SNAPSHOT_DATABASE.format(snapshot=snapshot).lower()
+        return os.getenv("NEO4J_DATABASE", "neo4j")
+
+    @staticmethod
+    def _in_partition(value, partition):
+        """Nodes must list the partition, relationships only when they are tagged at all"""
+        tags = value.get(PARTITION_PROPERTY)
+        if tags is None:
+            return isinstance(value, Relationship)
+        if isinstance(tags, str):
+            return tags == partition
+        return partition in tags
+
+    @staticmethod
+    def _graph_source(value):
+        if isinstance(value, Node):
+            name = value.get("name")
+            label = ":".join(sorted(value.labels))
+            return {"type": "kg_node", "id": value.element_id, "label": (f"{label}: {name}" if name else label)[:256]}
+        return {"type": "kg_relationship", "id": value.element_id, "label": value.type}
+
+    def answer(self, question, context):
+        """Answer a question from a context window assembled by the ContextBuilder"""
+        prompt = f"""
+You are a helpful voice assistant. Answer the caller's question using the context below.
+Keep the answer short and conversational, it will be spoken aloud.
+If the context does not contain the answer, say so clearly.
+
+{context}
+
+Question: {question}
+"""
+        try:
+            response = self.llm.complete(prompt)
+            return response.text
+        except Exception as e:
+            logging.error(f"❌ Error in answer method: {str(e)}")
+            return f"❌ Error processing query: {str(e)}"
+
     def query(self, question):
         """Main query method"""
         print(f"🤖 LLM Type: {type(self.llm)}")
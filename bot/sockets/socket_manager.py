@@ -1,5 +1,6 @@
 import asyncio
 import json
+import os
 import websockets
 import numpy as np
 from scipy.signal import resample_poly
@@ -26,9 +27,22 @@ class SocketManager:
     async def connect(self, on_receive: Optional[Callable] = None, on_send: Optional[Callable] = None):
         print("Connecting...")
         uri = f"ws://{self.base_url}/ws?room={self.call_id}&clientId={self.bot_id}&type=agent"
+        # The server routes waiting rooms to this bot while it is under AGENT_CAPACITY rooms
+        agent_id = os.getenv("AGENT_ID", "")
+        if agent_id:
+            uri += f"&agentId={agent_id}&capacity={os.getenv('AGENT_CAPACITY', '20')}"
+        # A shadow answers beside the production bot, with nothing reaching the caller, see server/shadow.go
+        candidate = os.getenv("SHADOW_CANDIDATE", "")
+        if candidate:
+            uri += f"&role=shadow&candidate={candidate}"
         print(f"[SocketManager] Connecting bot {self.bot_id} to {uri}")
         
-        self.websocket = await websockets.connect(uri)
+        # Agents and shadows join only with the server's admin token, see server/permissions.go
+        headers = {"Authorization": f"Bearer {os.getenv('SERVER_ADMIN_TOKEN', '')}"}
+        try:
+            self.websocket = await websockets.connect(uri, additional_headers=headers)
+        except TypeError:  # websockets before 14
+            self.websocket = await websockets.connect(uri, extra_headers=headers)
         print(f"[SocketManager] Bot {self.bot_id} connected to call {self.call_id}")
         
         await self.send_message(
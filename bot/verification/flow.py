@@ -0,0 +1,117 @@
+import os
+import logging
+from dataclasses import dataclass, field
+from typing import Dict, List, Optional, Tuple
+
+logger = logging.getLogger(__name__)
+
+# Caller verification from the bot's side. When a question touches something
+# sensitive the bot asks the server to verify the caller (verify_start), puts
+# the challenge prompts to the caller one turn at a time, sends the answers
+# back (verify_answer) and picks the held back question up again once the
+# verify_result arrives. The server decides; the bot never sees the answers
+# it is checked against.
+
+NUMBER_WORDS = {
+    "zero": "0", "oh": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+    "five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+}
+
+
+def spoken_digits(text: str) -> str:
+    """Turn "one two three" or "1, 2, 3" into "123" for passcodes"""
+    digits = []
+    for word in text.lower().replace(",", " ").replace("-", " ").split():
+        word = word.strip(".!?")
+        if word in NUMBER_WORDS:
+            digits.append(NUMBER_WORDS[word])
+        elif word.isdigit():
+            digits.append(word)
+    return "".join(digits)
+
+
+@dataclass
+class VerificationState:
+    question: str                       # What the caller asked before being verified
+    verification_id: Optional[str] = None
+    prompts: List[dict] = field(default_factory=list)
+    answers: Dict[str, str] = field(default_factory=dict)
+    verified: bool = False
+
+    def collecting(self) -> bool:
+        return self.verification_id is not None and len(self.answers) < len(self.prompts)
+
+    def next_prompt(self) -> Optional[str]:
+        for prompt in self.prompts:
+            if prompt["id"] not in self.answers:
+                return prompt["text"]
+        return None
+
+
+class VerificationFlow:
+    def __init__(self, method: str = "", keywords: Optional[List[str]] = None):
+        self.method = method
+        self.keywords = [k.lower() for k in (keywords or [])]
+
+        if method:
+            logger.info(f"[Verification] Method: {method}, sensitive keywords: {', '.join(self.keywords) or 'none'}")
+
+    @classmethod
+    def from_env(cls):
+        """
+        Configure from the environment:
+            VERIFY_METHOD    kba, otp or voice, as enabled on the server (empty disables)
+            VERIFY_KEYWORDS  comma separated words that make a question sensitive
+        """
+        keywords = [k.strip() for k in os.getenv("VERIFY_KEYWORDS", "").split(",") if k.strip()]
+        return cls(method=os.getenv("VERIFY_METHOD", "").strip(), keywords=keywords)
+
+    def needs_verification(self, session, text: str) -> bool:
+        if not self.method or (session.verification is not None and session.verification.verified):
+            return False
+        lowered = text.lower()
+        return any(keyword in lowered for keyword in self.keywords)
+
+    def start(self, session, question: str) -> dict:
+        """Hold the question back and return the verify_start payload"""
+        session.verification = VerificationState(question=question)
+        session.flow_state["stage"] = "verifying"
+        data = {"method": self.method}
+        if session.user_id:
+            data["userId"] = session.user_id
+        return data
+
+    def on_challenge(self, session, data: dict) -> Optional[str]:
+        """Take the server's challenge, returning the first prompt to say"""
+        state = session.verification
+        if state is None or data.get("userId") not in (None, session.user_id):
+            return None
+        state.verification_id = data.get("verificationId")
+        state.prompts = data.get("prompts") or []
+        state.answers = {}
+        return state.next_prompt()
+
+    def on_answer(self, session, text: str) -> Tuple[Optional[str], Optional[dict]]:
+        """Record the caller's answer: the next prompt to say, or the verify_answer payload once all are in"""
+        state = session.verification
+        prompt = next(p for p in state.prompts if p["id"] not in state.answers)
+        state.answers[prompt["id"]] = spoken_digits(text) if prompt["id"] == "code" else text
+        if state.collecting():
+            return state.next_prompt(), None
+        return None, {"verificationId": state.verification_id, "answers": state.answers}
+
+    def on_result(self, session, data: dict) -> Tuple[str, Optional[str]]:
+        """What to tell the caller, and the held back question to answer now if they passed"""
+        state = session.verification
+        # Voice verification has no challenge, its result is the first we hear of it
+        if state is None or state.verification_id not in (None, data.get("verificationId")):
+            return "", None
+        state.verification_id = None
+        if data.get("verified"):
+            state.verified = True
+            session.flow_state["stage"] = "verified"
+            return "Thank you, you're verified.", state.question
+        session.flow_state["stage"] = "verification failed"
+        if data.get("attemptsLeft", 0) > 0:
+            return "Sorry, I couldn't verify your identity. You can ask again to retry.", None
+        return "Sorry, I couldn't verify your identity, so I can't help with that on this call.", None
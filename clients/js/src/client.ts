@@ -0,0 +1,291 @@
+import {
+  CLOSE_CODES,
+  ClientMessages,
+  ClientMessageType,
+  IncomingMessage,
+  PROTOCOL_VERSION,
+  RETRY_CLOSE_CODES,
+  ServerMessageType,
+  WelcomeData,
+} from "./protocol";
+
+export interface IvaClientOptions {
+  /** The server's base URL, e.g. https://voice.example.com. Its WebSocket is at /ws. */
+  url: string;
+  room: string;
+  clientId: string;
+  type?: "user" | "agent";
+  tenant?: string;
+  template?: string;
+  role?: string;
+  displayName?: string;
+  avatar?: string;
+  metadata?: Record<string, unknown>;
+  /** A signed ticket, or a function fetching one before each connect. With allocate, tickets come from GET /allocate. */
+  ticket?: string | (() => string | Promise<string>);
+  /** Ask GET /allocate on url for a server (and ticket) before each connect. */
+  allocate?: boolean;
+  /** The audio this client sends, declared so the server can frame and analyse it. */
+  audio?: { sampleRate: number; channels?: number };
+  /** Reconnect after unexpected closes, resuming from the last message seen. Default true. */
+  reconnect?: boolean;
+  /** First reconnect delay in ms, doubled per attempt up to maxBackoffMs. Default 500. */
+  backoffMs?: number;
+  /** Default 15000. */
+  maxBackoffMs?: number;
+  /** Give up after this many consecutive failed attempts. Default unlimited. */
+  maxAttempts?: number;
+  /** The WebSocket implementation, for Node or tests. Defaults to the global WebSocket. */
+  WebSocket?: typeof WebSocket;
+  fetch?: typeof fetch;
+}
+
+export type ConnectionState = "idle" | "connecting" | "open" | "reconnecting" | "closed";
+
+type Handler<T> = (value: T) => void;
+
+interface LifecycleEvents {
+  state: ConnectionState;
+  /** Every JSON message, whatever its type. */
+  message: IncomingMessage;
+  /** Binary frames: the room's audio, pcm16. */
+  audio: ArrayBuffer;
+  /** Replayed messages were delivered after a resume. */
+  resumed: { fromSeq?: number; toSeq?: number; replayed?: number };
+  /** The server refused the connection or sent an error, or a frame did not parse. */
+  error: Error;
+}
+
+type EventMap = LifecycleEvents & { [T in ServerMessageType]: IncomingMessage<T> };
+
+/** Whether reconnecting after a close can help: not after 1000 or a close code the server means as final, e.g. a kick or the room ending. */
+function retryable(code: number): boolean {
+  return code !== 1000 && (!(code in CLOSE_CODES) || RETRY_CLOSE_CODES.has(code));
+}
+
+/**
+ * A participant's connection to a room. It joins as clientId, reconnects
+ * with backoff when the socket drops, and rejoins with lastSeq and its
+ * resume token so the server replays what was missed and the room sees
+ * session_resumed rather than a leave and a join.
+ *
+ *   const client = new IvaClient({ url: "https://voice.example.com", room: "r1", clientId: "u1" });
+ *   client.on("transcript", (msg) => console.log(msg.data.text));
+ *   await client.connect();
+ *   client.send("typing", { state: "start" });
+ */
+export class IvaClient {
+  readonly options: IvaClientOptions;
+  state: ConnectionState = "idle";
+  /** The last welcome, with the room's participants and this client's resume token. */
+  welcome?: WelcomeData;
+  /** The seq of the last message received, sent as lastSeq when reconnecting. */
+  lastSeq?: number;
+
+  private socket?: WebSocket;
+  private handlers = new Map<keyof EventMap, Set<Handler<any>>>();
+  private attempts = 0;
+  private timer?: ReturnType<typeof setTimeout>;
+  private closing = false;
+  /** The server a migrated room moved to, connected to in place of url. */
+  private redirect?: string;
+
+  constructor(options: IvaClientOptions) {
+    this.options = { type: "user", reconnect: true, backoffMs: 500, maxBackoffMs: 15000, ...options };
+  }
+
+  on<K extends keyof EventMap>(event: K, handler: Handler<EventMap[K]>): () => void {
+    let set = this.handlers.get(event);
+    if (!set) {
+      set = new Set();
+      this.handlers.set(event, set);
+    }
+    set.add(handler);
+    return () => this.off(event, handler);
+  }
+
+  off<K extends keyof EventMap>(event: K, handler: Handler<EventMap[K]>): void {
+    this.handlers.get(event)?.delete(handler);
+  }
+
+  /** Resolves once welcomed into the room. */
+  connect(): Promise<WelcomeData> {
+    this.closing = false;
+    this.attempts = 0;
+    return this.open("connecting");
+  }
+
+  /** Sends a typed message; to limits it to some participants. */
+  send<T extends ClientMessageType>(type: T, data: ClientMessages[T], to?: string[]): void {
+    const message: Record<string, unknown> = { type, data, timestamp: Date.now() };
+    if (to) {
+      message.to = to;
+    }
+    this.raw(JSON.stringify(message));
+  }
+
+  /** Sends one frame of audio, pcm16 as declared with options.audio. */
+  sendAudio(frame: ArrayBuffer | ArrayBufferView): void {
+    this.raw(frame);
+  }
+
+  close(): void {
+    this.closing = true;
+    clearTimeout(this.timer);
+    this.socket?.close(1000);
+    this.setState("closed");
+  }
+
+  private raw(frame: string | ArrayBuffer | ArrayBufferView): void {
+    if (!this.socket || this.socket.readyState !== 1) {
+      throw new Error("not connected");
+    }
+    this.socket.send(frame);
+  }
+
+  private emit<K extends keyof EventMap>(event: K, value: EventMap[K]): void {
+    this.handlers.get(event)?.forEach((handler) => handler(value));
+  }
+
+  private setState(state: ConnectionState): void {
+    if (this.state !== state) {
+      this.state = state;
+      this.emit("state", state);
+    }
+  }
+
+  private async open(state: ConnectionState): Promise<WelcomeData> {
+    this.setState(state);
+    const url = await this.socketURL();
+    const Socket = this.options.WebSocket ?? WebSocket;
+    const socket = new Socket(url);
+    socket.binaryType = "arraybuffer";
+    this.socket = socket;
+
+    return new Promise<WelcomeData>((resolve, reject) => {
+      let welcomed = false;
+      let redirected = false;
+      socket.onmessage = (event) => {
+        if (typeof event.data !== "string") {
+          this.emit("audio", event.data as ArrayBuffer);
+          return;
+        }
+        let message: IncomingMessage;
+        try {
+          message = JSON.parse(event.data);
+        } catch (err) {
+          this.emit("error", new Error(`unparsable frame: ${err}`));
+          return;
+        }
+        if (typeof message.seq === "number") {
+          this.lastSeq = message.seq;
+        }
+        if (message.type === "welcome") {
+          this.welcome = message.data;
+          if (message.data.protocolVersion !== undefined && message.data.protocolVersion !== PROTOCOL_VERSION) {
+            this.emit("error", new Error(`server speaks protocol ${message.data.protocolVersion}, this SDK ${PROTOCOL_VERSION}`));
+          }
+          welcomed = true;
+          this.attempts = 0;
+          this.setState("open");
+          resolve(message.data);
+        } else if (message.type === "replay_complete") {
+          this.emit("resumed", message.data);
+        } else if (message.type === "redirect") {
+          // The room moved to another server, reconnect there with the resume token
+          this.redirect = message.data.url;
+          redirected = true;
+          socket.close(4000, "redirect");
+        } else if (message.type === "error") {
+          this.emit("error", new Error(message.data.message ?? message.data.code ?? "server error"));
+        }
+        this.emit("message", message);
+        this.emit(message.type, message as never);
+      };
+      socket.onclose = (event) => {
+        if (this.socket !== socket) {
+          return;
+        }
+        this.socket = undefined;
+        if (!welcomed && redirected) {
+          resolve(this.open(state));
+          return;
+        }
+        if (!welcomed) {
+          this.redirect = undefined;
+          reject(new Error(`connection closed before welcome (${event.code}${event.reason ? " " + event.reason : ""})`));
+        }
+        if (this.closing || !this.options.reconnect || !retryable(event.code)) {
+          this.setState("closed");
+          return;
+        }
+        this.scheduleReconnect();
+      };
+    });
+  }
+
+  private scheduleReconnect(): void {
+    const { backoffMs = 500, maxBackoffMs = 15000, maxAttempts } = this.options;
+    if (maxAttempts !== undefined && this.attempts >= maxAttempts) {
+      this.setState("closed");
+      return;
+    }
+    const delay = Math.min(maxBackoffMs, backoffMs * 2 ** this.attempts) * (0.5 + Math.random() / 2);
+    this.attempts++;
+    this.setState("reconnecting");
+    this.timer = setTimeout(() => {
+      this.open("reconnecting").catch((err) => this.emit("error", err));
+    }, delay);
+  }
+
+  private async socketURL(): Promise<string> {
+    const o = this.options;
+    let base = (this.redirect ?? o.url).replace(/\/+$/, "");
+    let ticket = typeof o.ticket === "function" ? await o.ticket() : o.ticket;
+    if (o.allocate && !this.redirect) {
+      const allocation = await this.allocate();
+      const scheme = allocation.tls || base.startsWith("https") ? "https" : "http";
+      base = `${scheme}://${allocation.address}:${allocation.port}`;
+      ticket = allocation.ticket ?? ticket;
+    }
+    const query = new URLSearchParams({ room: o.room, clientId: o.clientId, type: o.type ?? "user" });
+    const optional: Record<string, string | undefined> = {
+      tenant: o.tenant,
+      template: o.template,
+      role: o.role,
+      displayName: o.displayName,
+      avatar: o.avatar,
+      ticket,
+      metadata: o.metadata && JSON.stringify(o.metadata),
+    };
+    for (const [key, value] of Object.entries(optional)) {
+      if (value) {
+        query.set(key, value);
+      }
+    }
+    if (o.audio) {
+      query.set("audioFormat", "pcm16");
+      query.set("sampleRate", String(o.audio.sampleRate));
+      query.set("channels", String(o.audio.channels ?? 1));
+    }
+    if (this.lastSeq !== undefined) {
+      query.set("lastSeq", String(this.lastSeq));
+    }
+    // Within the server's resume grace this takes the old place in the room back
+    if (this.welcome?.resumeToken) {
+      query.set("resumeToken", this.welcome.resumeToken);
+    }
+    return `${base.replace(/^http/, "ws")}/ws?${query}`;
+  }
+
+  private async allocate(): Promise<{ address: string; port: number; tls?: boolean; ticket?: string }> {
+    const o = this.options;
+    // The ticket is bound to the client type; tenant and role claims take a backend's credentials
+    const query = new URLSearchParams({ room: o.room, clientId: o.clientId, type: o.type ?? "user" });
+    const response = await (o.fetch ?? fetch)(`${o.url.replace(/\/+$/, "")}/allocate?${query}`);
+    if (!response.ok) {
+      throw new Error(`allocate: ${response.status} ${await response.text()}`);
+    }
+    return response.json();
+  }
+}
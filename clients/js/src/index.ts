@@ -0,0 +1,5 @@
+export { IvaClient } from "./client";
+export type { ConnectionState, IvaClientOptions } from "./client";
+export { Microphone, Player } from "./microphone";
+export type { MicrophoneOptions } from "./microphone";
+export * from "./protocol";
@@ -0,0 +1,137 @@
+import { IvaClient } from "./client";
+
+export interface MicrophoneOptions {
+  /** The rate sent to the server, which the client must declare with options.audio. Default 16000. */
+  sampleRate?: number;
+  /** Frame length in ms. Default 20. */
+  frameMs?: number;
+  constraints?: MediaTrackConstraints;
+}
+
+// Runs on the audio thread: mixes to mono, resamples to the target rate and
+// posts pcm16 frames of the target length.
+const worklet = `
+class IvaCapture extends AudioWorkletProcessor {
+  constructor(options) {
+    super();
+    const { sampleRate: target, frameMs } = options.processorOptions;
+    this.step = sampleRate / target;
+    this.frame = new Int16Array(Math.round(target * frameMs / 1000));
+    this.filled = 0;
+    this.position = 0;
+  }
+  process(inputs) {
+    const input = inputs[0];
+    if (!input || input.length === 0) return true;
+    const length = input[0].length;
+    for (; this.position < length; this.position += this.step) {
+      const i = Math.floor(this.position);
+      let sample = 0;
+      for (const channel of input) sample += channel[i];
+      sample = Math.max(-1, Math.min(1, sample / input.length));
+      this.frame[this.filled++] = sample < 0 ? sample * 0x8000 : sample * 0x7fff;
+      if (this.filled === this.frame.length) {
+        this.port.postMessage(this.frame.buffer.slice(0));
+        this.filled = 0;
+      }
+    }
+    this.position -= length;
+    return true;
+  }
+}
+registerProcessor("iva-capture", IvaCapture);
+`;
+
+/**
+ * Captures the microphone and streams it to the room as pcm16 frames.
+ *
+ *   const client = new IvaClient({ ..., audio: { sampleRate: 16000 } });
+ *   await client.connect();
+ *   const mic = await Microphone.start(client);
+ *   mic.muted = true; // frames stop until unmuted
+ *   mic.stop();
+ */
+export class Microphone {
+  muted = false;
+
+  private constructor(
+    private readonly stream: MediaStream,
+    private readonly context: AudioContext,
+    private readonly node: AudioWorkletNode,
+  ) {}
+
+  static async start(client: IvaClient, options: MicrophoneOptions = {}): Promise<Microphone> {
+    const sampleRate = options.sampleRate ?? client.options.audio?.sampleRate ?? 16000;
+    const stream = await navigator.mediaDevices.getUserMedia({
+      audio: { echoCancellation: true, noiseSuppression: true, ...options.constraints },
+    });
+    const context = new AudioContext();
+    const url = URL.createObjectURL(new Blob([worklet], { type: "application/javascript" }));
+    try {
+      await context.audioWorklet.addModule(url);
+    } finally {
+      URL.revokeObjectURL(url);
+    }
+    const node = new AudioWorkletNode(context, "iva-capture", {
+      processorOptions: { sampleRate, frameMs: options.frameMs ?? 20 },
+    });
+    const mic = new Microphone(stream, context, node);
+    node.port.onmessage = (event: MessageEvent<ArrayBuffer>) => {
+      if (!mic.muted && client.state === "open") {
+        client.sendAudio(event.data);
+      }
+    };
+    context.createMediaStreamSource(stream).connect(node);
+    return mic;
+  }
+
+  stop(): void {
+    this.node.port.onmessage = null;
+    this.node.disconnect();
+    this.stream.getTracks().forEach((track) => track.stop());
+    void this.context.close();
+  }
+}
+
+/**
+ * Plays the room's pcm16 audio as it arrives, queued back to back.
+ *
+ *   const player = new Player(16000);
+ *   client.on("audio", (frame) => player.play(frame));
+ */
+export class Player {
+  private readonly context: AudioContext;
+  private next = 0;
+  private playing = new Set<AudioBufferSourceNode>();
+
+  constructor(private readonly sampleRate: number, context?: AudioContext) {
+    this.context = context ?? new AudioContext();
+  }
+
+  play(frame: ArrayBuffer): void {
+    const pcm = new Int16Array(frame);
+    if (pcm.length === 0) {
+      return;
+    }
+    const buffer = this.context.createBuffer(1, pcm.length, this.sampleRate);
+    const samples = buffer.getChannelData(0);
+    for (let i = 0; i < pcm.length; i++) {
+      samples[i] = pcm[i] / 0x8000;
+    }
+    const source = this.context.createBufferSource();
+    source.buffer = buffer;
+    source.connect(this.context.destination);
+    source.onended = () => this.playing.delete(source);
+    this.playing.add(source);
+    this.next = Math.max(this.next, this.context.currentTime);
+    source.start(this.next);
+    this.next += buffer.duration;
+  }
+
+  /** Drops what is queued, e.g. when the caller barges in. */
+  flush(): void {
+    this.playing.forEach((source) => source.stop());
+    this.playing.clear();
+    this.next = 0;
+  }
+}
@@ -0,0 +1,841 @@
+// Code generated by protocolgen from the server's /protocol. DO NOT EDIT.
+
+export const PROTOCOL_VERSION = 1;
+
+export interface Consult {
+  agentId: string;
+  consultId: string;
+  peerRoomId: string;
+  peers: string[];
+  roomId: string;
+  startedAt: number;
+}
+
+export interface DeliveryReport {
+  delivered: string[];
+  failed: string[];
+  offline: string[];
+  queued: string[];
+  requestId?: string;
+  unknown: string[];
+}
+
+export interface Message {
+  channel?: string;
+  data: unknown;
+  from: string;
+  id?: string;
+  metadata?: Record<string, unknown>;
+  seq?: number;
+  timestamp: number;
+  to?: string[];
+  type: string;
+}
+
+export interface SharedFile {
+  expiresAt: number;
+  fileId: string;
+  mimeType: string;
+  name: string;
+  owner: string;
+  roomId: string;
+  sha256: string;
+  size: number;
+  url: string;
+}
+
+/** Chat to the room's agents */
+export type AgentOnlyData = {
+  text?: string;
+};
+
+/** Whether an outbound call reached a person or a machine */
+export type AmdResultData = {
+  answeredBy?: string;
+  callId?: string;
+  clientId?: string;
+  elapsedMs?: number;
+  source?: string;
+};
+
+/** An operator announcement, see POST /broadcast */
+export type AnnouncementData = {
+  data?: Record<string, unknown>;
+  text?: string;
+};
+
+/** The answer cache's reply to answer_lookup */
+export type AnswerCachedData = {
+  answerId?: string;
+  citations?: unknown[];
+  hit?: boolean;
+  question?: string;
+  text?: string;
+};
+
+/** Look a question up in the answer cache, answered with answer_cached */
+export type AnswerLookupData = {
+  kgVersion?: string;
+  question?: string;
+  speak?: boolean;
+};
+
+/** A virtual agent's final answer, with its knowledge graph citations */
+export type AssistantFinalData = {
+  cache?: boolean;
+  citations?: unknown[];
+  grounded?: boolean;
+  kgVersion?: string;
+  question?: string;
+  text?: string;
+};
+
+/** Chat to everyone else in the room */
+export type BroadcastData = {
+  text?: string;
+};
+
+/** A callback was queued */
+export type CallbackQueuedData = {
+  callbackId?: string;
+  phone?: string;
+  requestId?: string;
+  userId?: string;
+};
+
+/** Queue a callback to the caller */
+export type CallbackRequestData = {
+  notBefore?: number;
+  note?: string;
+  phone?: string;
+  userId?: string;
+};
+
+/** Divert the sender's audio into a private channel or back */
+export type ChannelAudioData = {
+  channelId?: string;
+  enabled?: boolean;
+};
+
+/** A member's audio moved into or out of a private channel */
+export type ChannelAudioChangedData = {
+  channelId?: string;
+  clientId?: string;
+  enabled?: boolean;
+};
+
+/** Close a private channel */
+export type ChannelCloseData = {
+  channelId?: string;
+};
+
+/** A private channel closed */
+export type ChannelClosedData = {
+  channelId?: string;
+  closedBy?: string;
+};
+
+/** Open a private channel to a peer */
+export type ChannelOpenData = {
+  audio?: boolean;
+  peer?: string;
+};
+
+/** A private channel opened */
+export type ChannelOpenedData = {
+  audio?: boolean;
+  channelId?: string;
+  members?: string[];
+};
+
+/** A participant joined */
+export type ClientJoinedData = {
+  avatar?: string;
+  clientId?: string;
+  clientType?: string;
+  displayName?: string;
+  metadata?: Record<string, unknown>;
+  role?: string;
+};
+
+/** A participant left, reason closed, timeout or replaced */
+export type ClientLeftData = {
+  clientId?: string;
+  clientType?: string;
+  reason?: string;
+};
+
+/** End the consult and take the caller off hold */
+export type ConsultEndData = {
+  consultId?: string;
+};
+
+/** A consult ended; after a transfer each peer gets the caller's room and a ticket to join it */
+export type ConsultEndedData = {
+  consultId?: string;
+  endedBy?: string;
+  reason?: string;
+  roomId?: string;
+  ticket?: string;
+};
+
+/** Text to the other side of the consult */
+export type ConsultMessageData = {
+  consultId?: string;
+  text?: string;
+};
+
+/** Hold the caller and bridge the sending agent to participants of another room */
+export type ConsultStartData = {
+  holdPrompt?: string;
+  peers?: string[];
+  roomId?: string;
+};
+
+/** A consult bridge opened, sent to its agent and peers */
+export type ConsultStartedData = Consult;
+
+/** End the consult by handing the caller to its peers */
+export type ConsultTransferData = {
+  consultId?: string;
+};
+
+/** The call's estimated spend crossed a -cost-alerts threshold */
+export type CostAlertData = {
+  cost?: Record<string, unknown>;
+  threshold?: number;
+};
+
+/** Who a selective message reached */
+export type DeliveryReportData = DeliveryReport;
+
+/** Dispose the call's wrap-up */
+export type DispositionData = {
+  code?: string;
+  notes?: string;
+  wrapUpId?: string;
+};
+
+/** Keypad digits from a caller */
+export type DtmfData = {
+  digits?: string;
+};
+
+/** A message was refused */
+export type ErrorData = {
+  code?: string;
+  message?: string;
+};
+
+/** An integration failed and a fallback applies */
+export type FallbackData = {
+  action?: string;
+  integration?: string;
+  reason?: string;
+};
+
+/** An integration recovered */
+export type FallbackClearedData = {
+  integration?: string;
+};
+
+/** Acknowledge a shared file */
+export type FileReceiptData = {
+  fileId?: string;
+};
+
+/** A file was shared with the room, see POST /room/{roomId}/files */
+export type FileSharedData = SharedFile;
+
+/** Hand the call to another agent or queue */
+export type HandoffData = Record<string, unknown>;
+
+/** Report an integration's health, for fallbacks */
+export type IntegrationStatusData = {
+  error?: string;
+  integration?: string;
+  state?: string;
+};
+
+/** Submit facts extracted from the conversation for the knowledge graph, answered with kg_facts_staged */
+export type KgFactsData = {
+  facts?: unknown[];
+  messageId?: string;
+};
+
+/** Which submitted facts were approved and which wait for review */
+export type KgFactsStagedData = {
+  approved?: unknown[];
+  pending?: unknown[];
+  requestId?: string;
+};
+
+/** Disconnect a participant */
+export type KickData = {
+  clientId?: string;
+  reason?: string;
+};
+
+/** The receiver was disconnected */
+export type KickedData = {
+  by?: string;
+  reason?: string;
+};
+
+/** The room's replies are over or back under the latency budget */
+export type LatencyBudgetData = {
+  maxContextTurns?: number;
+  model?: string;
+  p95Ms?: number;
+  state?: string;
+  targetMs?: number;
+};
+
+/** Change the sender's metadata, null values remove keys */
+export type MetadataData = Record<string, unknown>;
+
+/** A participant's metadata changed */
+export type MetadataUpdatedData = {
+  changed?: Record<string, unknown>;
+  clientId?: string;
+  clientType?: string;
+};
+
+/** A message was flagged or blocked by moderation */
+export type ModerationEventData = {
+  action?: string;
+  clientId?: string;
+  messageId?: string;
+  source?: string;
+};
+
+/** Profanity was masked */
+export type ProfanityAlertData = {
+  clientId?: string;
+  clientType?: string;
+  count?: number;
+  messageId?: string;
+  source?: string;
+};
+
+/** Change the sender's display name or avatar, omitted fields are unchanged */
+export type ProfileUpdateData = {
+  avatar?: string;
+  displayName?: string;
+};
+
+/** A participant's profile changed */
+export type ProfileUpdatedData = {
+  avatar?: string;
+  changed?: string[];
+  clientId?: string;
+  displayName?: string;
+};
+
+/** The room is past a quota and closing */
+export type QuotaExceededData = {
+  closingInMs?: number;
+  limit?: number;
+  quota?: string;
+  unit?: string;
+  used?: number;
+};
+
+/** The room is nearing a quota */
+export type QuotaWarningData = {
+  limit?: number;
+  quota?: string;
+  unit?: string;
+  used?: number;
+};
+
+/** Reaction to a message, not kept in history */
+export type ReactionData = {
+  emoji?: string;
+  messageId?: string;
+};
+
+/** Read receipt, not kept in history */
+export type ReadData = {
+  messageId?: string;
+};
+
+/** Pause the recording, e.g. while payment details are read out */
+export type RecordingPauseData = {
+  reason?: string;
+};
+
+/** The recording was paused */
+export type RecordingPausedData = {
+  pausedBy?: string;
+  reason?: string;
+  recordingId?: string;
+};
+
+/** Resume a paused recording */
+export type RecordingResumeData = {
+  reason?: string;
+};
+
+/** The recording was resumed */
+export type RecordingResumedData = {
+  durationMs?: number;
+  pausedAt?: number;
+  reason?: string;
+  resumedAt?: number;
+  resumedBy?: string;
+};
+
+/** Start recording the room */
+export type RecordingStartData = Record<string, unknown>;
+
+/** Stop recording the room */
+export type RecordingStopData = Record<string, unknown>;
+
+/** The room moved to another server: reconnect to url with resumeToken, see POST /admin/rooms/migrate */
+export type RedirectData = {
+  reason?: string;
+  resumeToken?: string;
+  roomId?: string;
+  url?: string;
+};
+
+/** Missed messages were replayed on resume */
+export type ReplayCompleteData = {
+  complete?: boolean;
+  fromSeq?: number;
+  replayed?: number;
+  toSeq?: number;
+};
+
+/** The room's config changed, as it would be in welcome now, see POST /admin/rooms/refresh */
+export type RoomConfigData = {
+  reason?: string;
+  room?: Record<string, unknown>;
+  ttsVoice?: string;
+};
+
+/** The secure capture ended; only the agent that started it gets the digits */
+export type SecureCaptureEndedData = {
+  captureId?: string;
+  digits?: string;
+  length?: number;
+  purpose?: string;
+  reason?: string;
+};
+
+/** Capture the caller's keypad digits away from everyone else */
+export type SecureCaptureStartData = {
+  maxDigits?: number;
+  purpose?: string;
+  terminator?: string;
+};
+
+/** The caller's keypad is being captured */
+export type SecureCaptureStartedData = {
+  captureId?: string;
+  maxDigits?: number;
+  purpose?: string;
+  startedBy?: string;
+  terminator?: string;
+};
+
+/** Cancel the room's secure capture */
+export type SecureCaptureStopData = Record<string, unknown>;
+
+/** Chat to the client IDs in to, answered with a delivery_report */
+export type SelectiveData = {
+  text?: string;
+};
+
+/** A dropped participant reconnected within the resume grace, in place of client_left and client_joined */
+export type SessionResumedData = {
+  avatar?: string;
+  awayMs?: number;
+  clientId?: string;
+  clientType?: string;
+  displayName?: string;
+  metadata?: Record<string, unknown>;
+  role?: string;
+};
+
+/** A participant's send queue overflowed (lagging true), or it caught up having lost dropped frames */
+export type SlowConsumerData = {
+  clientId?: string;
+  clientType?: string;
+  dropped?: number;
+  lagging?: boolean;
+  queue?: string;
+};
+
+/** Synthesize text, or an SSML <speak> document, and play it into the room */
+export type SpeakData = {
+  ssml?: string;
+  text?: string;
+  voice?: string;
+};
+
+/** Stop the sender's speech */
+export type SpeakStopData = Record<string, unknown>;
+
+/** Stream LLM text into speech as it is generated */
+export type SpeakStreamData = {
+  final?: boolean;
+  streamId?: string;
+  text?: string;
+};
+
+/** Record a caller's voiceprint consent */
+export type SpeakerConsentData = {
+  customerId?: string;
+  granted?: boolean;
+  userId?: string;
+};
+
+/** A caller's voiceprint consent changed */
+export type SpeakerConsentUpdatedData = {
+  customerId?: string;
+  granted?: boolean;
+  userId?: string;
+};
+
+/** Enroll a verified caller's voiceprint */
+export type SpeakerEnrollData = {
+  userId?: string;
+};
+
+/** A caller's voiceprint was enrolled */
+export type SpeakerEnrolledData = {
+  customerId?: string;
+  seconds?: number;
+  userId?: string;
+};
+
+/** A caller's voice matched an enrolled voiceprint */
+export type SpeakerRecognizedData = {
+  customerId?: string;
+  score?: number;
+  userId?: string;
+};
+
+/** Whether a sensitive tool may run */
+export type ToolAuthorizationData = {
+  allowed?: boolean;
+  reason?: string;
+  requestId?: string;
+  tool?: string;
+  userId?: string;
+};
+
+/** Ask whether a sensitive tool may run, answered with tool_authorization */
+export type ToolAuthorizeData = {
+  requestId?: string;
+  tool?: string;
+  userId?: string;
+};
+
+/** A final transcript of a participant's speech */
+export type TranscriptData = {
+  clientId?: string;
+  clipUrl?: string;
+  confidence?: number;
+  endMs?: number;
+  final?: boolean;
+  language?: string;
+  provider?: string;
+  startMs?: number;
+  text?: string;
+};
+
+/** An interim transcript, superseded by the next */
+export type TranscriptPartialData = {
+  clientId?: string;
+  final?: boolean;
+  text?: string;
+};
+
+/** Speech finished playing */
+export type TtsFinishedData = {
+  clientId?: string;
+  interrupted?: boolean;
+  requestId?: string;
+};
+
+/** Speech started playing */
+export type TtsStartedData = {
+  cached?: boolean;
+  clientId?: string;
+  format?: string;
+  requestId?: string;
+  sampleRate?: number;
+};
+
+/** Typing indicator, not kept in history */
+export type TypingData = {
+  state?: string;
+};
+
+/** Report the LLM tokens a completion cost, not kept in history */
+export type UsageData = {
+  completionTokens?: number;
+  model?: string;
+  promptTokens?: number;
+  totalTokens?: number;
+};
+
+/** Chat to the room's users */
+export type UserOnlyData = {
+  text?: string;
+};
+
+/** Answer a verification challenge */
+export type VerifyAnswerData = {
+  answers?: Record<string, unknown>;
+  verificationId?: string;
+};
+
+/** Questions or a passcode prompt for the caller */
+export type VerifyChallengeData = {
+  method?: string;
+  prompts?: unknown[];
+  userId?: string;
+  verificationId?: string;
+};
+
+/** Whether the caller was verified */
+export type VerifyResultData = {
+  attemptsLeft?: number;
+  customerId?: string;
+  method?: string;
+  score?: number;
+  userId?: string;
+  verificationId?: string;
+  verified?: boolean;
+};
+
+/** Start verifying a caller's identity */
+export type VerifyStartData = {
+  customerId?: string;
+  method?: string;
+  userId?: string;
+};
+
+/** Sent on joining: the room, its participants, its config and the server's capabilities */
+export type WelcomeData = {
+  agents?: string[];
+  avatar?: string;
+  capabilities?: Record<string, unknown>;
+  clientId?: string;
+  clientType?: string;
+  displayName?: string;
+  metadata?: Record<string, unknown>;
+  participants?: unknown[];
+  protocolVersion?: number;
+  recording?: Record<string, unknown>;
+  resumeToken?: string;
+  role?: string;
+  room?: Record<string, unknown>;
+  roomId?: string;
+  seq?: number;
+  ttsVoice?: string;
+  users?: string[];
+};
+
+/** The wrap-up was disposed or lapsed */
+export type WrapUpEndedData = {
+  agentId?: string;
+  clientId?: string;
+  code?: string;
+  lapsed?: boolean;
+  notes?: string;
+  wrapUpId?: string;
+};
+
+/** The agent's wrap-up window opened */
+export type WrapUpStartedData = {
+  agentId?: string;
+  clientId?: string;
+  codes?: string[];
+  deadline?: number;
+  roomId?: string;
+  wrapUpId?: string;
+};
+
+/** ClientMessages clients may send, by type */
+export interface ClientMessages {
+  agent_only: AgentOnlyData;
+  answer_lookup: AnswerLookupData;
+  assistant_final: AssistantFinalData;
+  broadcast: BroadcastData;
+  callback_request: CallbackRequestData;
+  channel_audio: ChannelAudioData;
+  channel_close: ChannelCloseData;
+  channel_open: ChannelOpenData;
+  consult_end: ConsultEndData;
+  consult_message: ConsultMessageData;
+  consult_start: ConsultStartData;
+  consult_transfer: ConsultTransferData;
+  disposition: DispositionData;
+  dtmf: DtmfData;
+  file_receipt: FileReceiptData;
+  handoff: HandoffData;
+  integration_status: IntegrationStatusData;
+  kg_facts: KgFactsData;
+  kick: KickData;
+  metadata: MetadataData;
+  profile_update: ProfileUpdateData;
+  reaction: ReactionData;
+  read: ReadData;
+  recording_pause: RecordingPauseData;
+  recording_resume: RecordingResumeData;
+  recording_start: RecordingStartData;
+  recording_stop: RecordingStopData;
+  secure_capture_start: SecureCaptureStartData;
+  secure_capture_stop: SecureCaptureStopData;
+  selective: SelectiveData;
+  speak: SpeakData;
+  speak_stop: SpeakStopData;
+  speak_stream: SpeakStreamData;
+  speaker_consent: SpeakerConsentData;
+  speaker_enroll: SpeakerEnrollData;
+  tool_authorize: ToolAuthorizeData;
+  typing: TypingData;
+  usage: UsageData;
+  user_only: UserOnlyData;
+  verify_answer: VerifyAnswerData;
+  verify_start: VerifyStartData;
+}
+
+/** ServerMessages clients may receive, by type: the server's own and those relayed from other participants */
+export interface ServerMessages {
+  agent_only: AgentOnlyData;
+  amd_result: AmdResultData;
+  announcement: AnnouncementData;
+  answer_cached: AnswerCachedData;
+  assistant_final: AssistantFinalData;
+  broadcast: BroadcastData;
+  callback_queued: CallbackQueuedData;
+  channel_audio_changed: ChannelAudioChangedData;
+  channel_closed: ChannelClosedData;
+  channel_opened: ChannelOpenedData;
+  client_joined: ClientJoinedData;
+  client_left: ClientLeftData;
+  consult_ended: ConsultEndedData;
+  consult_message: ConsultMessageData;
+  consult_started: ConsultStartedData;
+  cost_alert: CostAlertData;
+  delivery_report: DeliveryReportData;
+  error: ErrorData;
+  fallback: FallbackData;
+  fallback_cleared: FallbackClearedData;
+  file_receipt: FileReceiptData;
+  file_shared: FileSharedData;
+  handoff: HandoffData;
+  kg_facts_staged: KgFactsStagedData;
+  kicked: KickedData;
+  latency_budget: LatencyBudgetData;
+  metadata_updated: MetadataUpdatedData;
+  moderation_event: ModerationEventData;
+  profanity_alert: ProfanityAlertData;
+  profile_updated: ProfileUpdatedData;
+  quota_exceeded: QuotaExceededData;
+  quota_warning: QuotaWarningData;
+  reaction: ReactionData;
+  read: ReadData;
+  recording_paused: RecordingPausedData;
+  recording_resumed: RecordingResumedData;
+  recording_start: RecordingStartData;
+  recording_stop: RecordingStopData;
+  redirect: RedirectData;
+  replay_complete: ReplayCompleteData;
+  room_config: RoomConfigData;
+  secure_capture_ended: SecureCaptureEndedData;
+  secure_capture_started: SecureCaptureStartedData;
+  selective: SelectiveData;
+  session_resumed: SessionResumedData;
+  slow_consumer: SlowConsumerData;
+  speaker_consent_updated: SpeakerConsentUpdatedData;
+  speaker_enrolled: SpeakerEnrolledData;
+  speaker_recognized: SpeakerRecognizedData;
+  tool_authorization: ToolAuthorizationData;
+  transcript: TranscriptData;
+  transcript_partial: TranscriptPartialData;
+  tts_finished: TtsFinishedData;
+  tts_started: TtsStartedData;
+  typing: TypingData;
+  user_only: UserOnlyData;
+  verify_challenge: VerifyChallengeData;
+  verify_result: VerifyResultData;
+  welcome: WelcomeData;
+  wrap_up_ended: WrapUpEndedData;
+  wrap_up_started: WrapUpStartedData;
+}
+
+export type ClientMessageType = keyof ClientMessages;
+export type ServerMessageType = keyof ServerMessages;
+
+/** The envelope every JSON frame carries, its data typed by its type. */
+export type TypedMessage<M, T extends keyof M> = Omit<Message, "type" | "data"> & { type: T; data: M[T] };
+export type IncomingMessage<T extends ServerMessageType = ServerMessageType> = { [K in T]: TypedMessage<ServerMessages, K> }[T];
+export type OutgoingMessage<T extends ClientMessageType = ClientMessageType> = { [K in T]: TypedMessage<ClientMessages, K> }[T];
+
+/** The permission sending each message type takes, see the server's roles. */
+export const MESSAGE_PERMISSIONS: Partial<Record<ClientMessageType, string>> = {
+  agent_only: "send_message",
+  answer_lookup: "send_message",
+  assistant_final: "send_message",
+  broadcast: "send_message",
+  callback_request: "send_message",
+  channel_audio: "private_channel",
+  channel_close: "private_channel",
+  channel_open: "private_channel",
+  consult_end: "send_message",
+  consult_message: "send_message",
+  consult_start: "handoff",
+  consult_transfer: "handoff",
+  disposition: "handoff",
+  dtmf: "send_message",
+  handoff: "handoff",
+  integration_status: "handoff",
+  kg_facts: "send_message",
+  kick: "kick",
+  metadata: "change_metadata",
+  profile_update: "change_metadata",
+  recording_pause: "record",
+  recording_resume: "record",
+  recording_start: "record",
+  recording_stop: "record",
+  secure_capture_start: "verify_caller",
+  secure_capture_stop: "verify_caller",
+  selective: "send_message",
+  speak: "broadcast_audio",
+  speak_stop: "broadcast_audio",
+  speak_stream: "broadcast_audio",
+  speaker_consent: "send_message",
+  speaker_enroll: "verify_caller",
+  tool_authorize: "verify_caller",
+  usage: "handoff",
+  user_only: "send_message",
+  verify_answer: "send_message",
+  verify_start: "verify_caller",
+};
+
+/** The codes the server closes connections with, the reason starting with the name. */
+export const CLOSE_CODES: Record<number, string> = {
+  4001: "room_full", // The room has no place left for the client's type; one may free up
+  4002: "kicked", // A moderator removed the client and revoked its resume token
+  4003: "auth_failed", // The ticket was missing, invalid or expired, or the role needs admin credentials
+  4004: "duplicate_id", // Another connection with the same clientId replaced this one, or this one was refused for it
+  4005: "idle_timeout", // Nothing arrived within -pong-timeout; the resume token still holds the place
+  4006: "server_shutdown", // The server is restarting or draining; reconnect with the resume token
+  4007: "room_closed", // The room ended
+  4008: "room_moved", // An admin moved the room; rejoining places it on another server
+  4009: "migrated", // The room migrated; follow the redirect sent before
+  4010: "rate_limited", // The client went over its rate limits too often
+  4011: "quota_exceeded", // The tenant used up its quota
+  4012: "slow_consumer", // The client fell behind its send queue; resume with lastSeq to have the messages replayed
+  4013: "rejected", // The join was refused, see the error sent before
+};
+
+/** The close codes after which reconnecting can help. */
+export const RETRY_CLOSE_CODES: ReadonlySet<number> = new Set([4001, 4005, 4006, 4008, 4009, 4012]);
@@ -0,0 +1,8 @@
+"""Python SDK for building agents on the IVA voice server."""
+
+from . import audio, protocol
+from .agent import Agent, Worker
+from .client import LIFECYCLE_EVENTS, Client
+from .protocol import PROTOCOL_VERSION
+
+__all__ = ["Agent", "Client", "LIFECYCLE_EVENTS", "PROTOCOL_VERSION", "Worker", "audio", "protocol"]
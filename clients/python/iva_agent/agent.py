@@ -0,0 +1,198 @@
+"""A base class for custom agents, and a worker that starts one per room."""
+
+import asyncio
+import logging
+import uuid
+from typing import Any, Callable, Dict, List, Optional, Set
+
+from . import audio
+from .client import Client
+from .protocol import WelcomeData
+
+logger = logging.getLogger(__name__)
+
+
+class Agent:
+    """One agent in one room. Subclass it and override the on_ hooks:
+
+        class Echo(Agent):
+            display_name = "Echo"
+            capabilities = ["echo"]
+
+            async def on_transcript(self, text, msg):
+                await self.reply(f"You said: {text}")
+
+        asyncio.run(Echo("ws://localhost:8080", room="r1").run())
+
+    The agent joins as an agent connection. With agent_id and capacity it
+    declares itself a worker, so the server routes waiting rooms to it (see
+    server/routing.go); capabilities are published in its metadata, which
+    other participants see in welcome and client_joined. It leaves once the
+    last user has, unless stay is set.
+    """
+
+    display_name = "Agent"
+    capabilities: List[str] = []
+    sample_rate = 16000
+    stay = False
+
+    def __init__(
+        self,
+        url: str,
+        room: str,
+        *,
+        client_id: Optional[str] = None,
+        agent_id: Optional[str] = None,
+        capacity: Optional[int] = None,
+        metadata: Optional[Dict[str, Any]] = None,
+        **options: Any,
+    ):
+        self.room = room
+        self.users: Set[str] = set()
+        self.client = Client(
+            url,
+            room,
+            client_id or f"agent-{uuid.uuid4().hex[:8]}",
+            client_type="agent",
+            agent_id=agent_id,
+            capacity=capacity,
+            display_name=self.display_name,
+            metadata={"capabilities": list(self.capabilities), **(metadata or {})},
+            sample_rate=self.sample_rate,
+            **options,
+        )
+        self.client.on("connected", self._connected)
+        self.client.on("transcript", self._transcript)
+        self.client.on("transcript_partial", self._partial)
+        self.client.on("client_joined", self._joined)
+        self.client.on("client_left", self._left)
+        self.client.on("audio", self.on_audio)
+        self.client.on("message", self.on_message)
+
+    async def run(self) -> None:
+        """Joins the room and serves it until the agent leaves or is removed."""
+        try:
+            await self.client.run()
+        finally:
+            await self.on_stop()
+
+    # Hooks
+
+    async def on_start(self, welcome: WelcomeData) -> None:
+        """Called on joining, and again on each reconnect."""
+
+    async def on_transcript(self, text: str, msg: Dict[str, Any]) -> None:
+        """Called with each final transcript of a user's speech."""
+
+    async def on_partial(self, text: str, msg: Dict[str, Any]) -> None:
+        """Called with interim transcripts, superseded by the next."""
+
+    async def on_audio(self, pcm: bytes) -> None:
+        """Called with each frame of the room's audio."""
+
+    async def on_message(self, msg: Dict[str, Any]) -> None:
+        """Called with every message, whatever its type."""
+
+    async def on_user_joined(self, user: Dict[str, Any]) -> None:
+        pass
+
+    async def on_user_left(self, user: Dict[str, Any]) -> None:
+        pass
+
+    async def on_stop(self) -> None:
+        """Called once the agent has left the room."""
+
+    # Actions
+
+    async def reply(self, text: str, speak: bool = True, ssml: Optional[str] = None) -> None:
+        """Shows text to the room and, with speak, has the server synthesize and play it,
+        or the ssml <speak> document given for it, for pauses, emphasis and pronunciation."""
+        await self.client.send("bot_message", {"text": text})
+        if speak and ssml:
+            await self.client.send("speak", {"ssml": ssml})
+        elif speak:
+            await self.client.send("speak", {"text": text})
+
+    async def play(self, pcm: bytes) -> None:
+        """Plays pcm16 at sample_rate into the room, in frame sized chunks the server paces."""
+        for frame in audio.frames(pcm, self.sample_rate):
+            await self.client.send_audio(frame)
+
+    async def stop_speaking(self) -> None:
+        await self.client.send("speak_stop", {})
+
+    async def report_usage(self, prompt_tokens: int, completion_tokens: int, model: Optional[str] = None) -> None:
+        """Reports the LLM tokens a completion cost, for quotas and the call's spend."""
+        data: Dict[str, Any] = {"promptTokens": prompt_tokens, "completionTokens": completion_tokens}
+        if model:
+            data["model"] = model
+        await self.client.send("usage", data)
+
+    async def leave(self) -> None:
+        await self.client.close()
+
+    # Dispatch
+
+    async def _connected(self, welcome: WelcomeData) -> None:
+        self.users = set(welcome.get("users") or [])
+        await self.on_start(welcome)
+
+    async def _transcript(self, msg: Dict[str, Any]) -> None:
+        text = (msg.get("data") or {}).get("text", "")
+        if text:
+            await self.on_transcript(text, msg)
+
+    async def _partial(self, msg: Dict[str, Any]) -> None:
+        await self.on_partial((msg.get("data") or {}).get("text", ""), msg)
+
+    async def _joined(self, msg: Dict[str, Any]) -> None:
+        user = msg.get("data") or {}
+        if user.get("clientType") == "user":
+            self.users.add(user.get("clientId"))
+            await self.on_user_joined(user)
+
+    async def _left(self, msg: Dict[str, Any]) -> None:
+        user = msg.get("data") or {}
+        if user.get("clientType") != "user":
+            return
+        self.users.discard(user.get("clientId"))
+        await self.on_user_left(user)
+        if not self.users and not self.stay:
+            await self.leave()
+
+
+class Worker:
+    """Starts an agent in each room the server routes here.
+
+    Register it as a worker with a join URL (-agent-workers
+    ID=http://host:9000/join@CAPACITY) and the server POSTs
+    {"room_id", "tenant", "flow"} to it, the bot's /join. join takes that
+    payload, so it can back any web framework's handler:
+
+        worker = Worker(lambda room, join: Echo("ws://localhost:8080", room=room))
+
+        @app.post("/join")
+        async def join(request: Request):
+            return await worker.join(await request.json())
+    """
+
+    def __init__(self, factory: Callable[[str, Dict[str, Any]], Agent]):
+        self.factory = factory
+        self.agents: Dict[str, asyncio.Task] = {}
+
+    async def join(self, request: Dict[str, Any]) -> Dict[str, Any]:
+        room = request.get("room_id")
+        if not room:
+            return {"error": "room_id required"}
+        if room in self.agents:
+            return {"status": "agent already active", "room_id": room}
+        agent = self.factory(room, request)
+        task = asyncio.create_task(agent.run())
+        self.agents[room] = task
+        task.add_done_callback(lambda _: self.agents.pop(room, None))
+        return {"status": "agent joining", "room_id": room, "client_id": agent.client.client_id}
+
+    async def leave(self, room: str) -> None:
+        task = self.agents.pop(room, None)
+        if task:
+            task.cancel()
@@ -0,0 +1,108 @@
+"""Helpers for the pcm16 audio the server carries in binary frames.
+
+Audio is little-endian signed 16-bit PCM, mono unless a client declares
+channels. Sources that declare their sample rate are regrouped by the server
+into -audio-frame-ms frames and paced, so senders may write chunks of any
+size as fast as they have them.
+"""
+
+import math
+import sys
+from array import array
+from typing import Iterator, List
+
+
+def frame_bytes(sample_rate: int, frame_ms: int = 20, channels: int = 1) -> int:
+    """The size of one frame_ms frame."""
+    return sample_rate * channels * 2 * frame_ms // 1000
+
+
+def _samples(pcm: bytes) -> array:
+    samples = array("h")
+    samples.frombytes(pcm[: len(pcm) // 2 * 2])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    return samples
+
+
+def _pcm(samples: array) -> bytes:
+    if sys.byteorder == "big":
+        samples = array("h", samples)
+        samples.byteswap()
+    return samples.tobytes()
+
+
+def frames(pcm: bytes, sample_rate: int, frame_ms: int = 20, channels: int = 1) -> Iterator[bytes]:
+    """Splits audio into frame_ms frames, the last one possibly short."""
+    size = frame_bytes(sample_rate, frame_ms, channels)
+    for offset in range(0, len(pcm), size):
+        yield pcm[offset : offset + size]
+
+
+class FrameBuffer:
+    """Regroups a stream of arbitrary chunks into whole frames.
+
+        buffer = FrameBuffer(16000)
+        for frame in buffer.push(chunk):
+            vad.feed(frame)
+    """
+
+    def __init__(self, sample_rate: int, frame_ms: int = 20, channels: int = 1):
+        self.size = frame_bytes(sample_rate, frame_ms, channels)
+        self._buf = bytearray()
+
+    def push(self, chunk: bytes) -> List[bytes]:
+        """Returns every complete frame, keeping the remainder for the next chunk."""
+        self._buf += chunk
+        whole = len(self._buf) // self.size * self.size
+        out = [bytes(self._buf[i : i + self.size]) for i in range(0, whole, self.size)]
+        del self._buf[:whole]
+        return out
+
+    def flush(self) -> bytes:
+        """Returns and drops the partial frame left over."""
+        rest, self._buf = bytes(self._buf), bytearray()
+        return rest
+
+
+def to_mono(pcm: bytes, channels: int) -> bytes:
+    """Averages interleaved channels into one."""
+    if channels == 1:
+        return pcm
+    samples = _samples(pcm)
+    mono = array("h", (sum(samples[i : i + channels]) // channels for i in range(0, len(samples) - channels + 1, channels)))
+    return _pcm(mono)
+
+
+def resample(pcm: bytes, from_rate: int, to_rate: int) -> bytes:
+    """Resamples mono audio by linear interpolation, e.g. 8k telephony to 16k for STT."""
+    if from_rate == to_rate or not pcm:
+        return pcm
+    samples = _samples(pcm)
+    count = int(len(samples) * to_rate / from_rate)
+    step = from_rate / to_rate
+    out = array("h", bytes(count * 2))
+    last = len(samples) - 1
+    for i in range(count):
+        position = i * step
+        j = int(position)
+        frac = position - j
+        nxt = samples[j + 1] if j < last else samples[last]
+        out[i] = int(samples[j] + (nxt - samples[j]) * frac)
+    return _pcm(out)
+
+
+def level(pcm: bytes) -> float:
+    """The RMS of a frame, 0..1, the same measure the server's VAD uses."""
+    samples = _samples(pcm)
+    if not samples:
+        return 0.0
+    return math.sqrt(sum((s / 32767) ** 2 for s in samples) / len(samples))
+
+
+def is_silent(pcm: bytes, threshold: float = 0.01) -> bool:
+    return level(pcm) < threshold
+
+
+def silence(sample_rate: int, ms: int, channels: int = 1) -> bytes:
+    return bytes(frame_bytes(sample_rate, ms, channels))
@@ -0,0 +1,289 @@
+"""An asyncio connection to a room over the server's WebSocket."""
+
+import asyncio
+import inspect
+import json
+import logging
+import random
+import time
+from typing import Any, Awaitable, Callable, Dict, List, Optional, Set, Union
+from urllib.parse import urlencode
+
+import websockets
+
+from .protocol import CLOSE_CODES, PROTOCOL_VERSION, RETRY_CLOSE_CODES, WelcomeData
+
+logger = logging.getLogger(__name__)
+
+Handler = Callable[[Any], Union[None, Awaitable[None]]]
+
+# Events that are not message types
+LIFECYCLE_EVENTS = ("message", "audio", "resumed", "connected", "disconnected", "error")
+
+
+def retryable(code: Optional[int]) -> bool:
+    """Whether reconnecting after a close can help: not after 1000 or a close
+    code the server means as final, e.g. a kick or the room ending."""
+    return code != 1000 and (code not in CLOSE_CODES or code in RETRY_CLOSE_CODES)
+
+
+def _close_code(err: websockets.ConnectionClosed) -> Optional[int]:
+    rcvd = getattr(err, "rcvd", None)
+    if rcvd is not None:
+        return rcvd.code
+    return getattr(err, "code", None)  # websockets before 10
+
+
+class Client:
+    """A participant's connection to a room.
+
+    It joins as client_id, reconnects with backoff when the socket drops and
+    rejoins with lastSeq and its resume token, so the server replays what was
+    missed and the room sees session_resumed rather than a leave and a join.
+    Handlers are registered per message type, or for one of LIFECYCLE_EVENTS,
+    and may be plain functions or coroutines; coroutines run as tasks, so a
+    slow one does not hold up the messages and audio behind it.
+
+        client = Client("ws://localhost:8080", room="r1", client_id="bot-1", client_type="agent")
+
+        @client.on("transcript")
+        async def transcript(msg):
+            print(msg["data"]["text"])
+
+        await client.connect()
+        await client.run()
+    """
+
+    def __init__(
+        self,
+        url: str,
+        room: str,
+        client_id: str,
+        *,
+        client_type: str = "user",
+        agent_id: Optional[str] = None,
+        capacity: Optional[int] = None,
+        tenant: Optional[str] = None,
+        template: Optional[str] = None,
+        role: Optional[str] = None,
+        display_name: Optional[str] = None,
+        avatar: Optional[str] = None,
+        metadata: Optional[Dict[str, Any]] = None,
+        ticket: Optional[str] = None,
+        admin_token: Optional[str] = None,
+        sample_rate: Optional[int] = None,
+        channels: int = 1,
+        extra_query: Optional[Dict[str, str]] = None,
+        reconnect: bool = True,
+        backoff: float = 0.5,
+        max_backoff: float = 15.0,
+        max_attempts: Optional[int] = None,
+    ):
+        self.url = url.rstrip("/").replace("http://", "ws://", 1).replace("https://", "wss://", 1)
+        self.room = room
+        self.client_id = client_id
+        self.client_type = client_type
+        self.agent_id = agent_id
+        self.capacity = capacity
+        self.tenant = tenant
+        self.template = template
+        self.role = role
+        self.display_name = display_name
+        self.avatar = avatar
+        self.metadata = metadata
+        self.ticket = ticket
+        self.admin_token = admin_token
+        self.sample_rate = sample_rate
+        self.channels = channels
+        self.extra_query = extra_query or {}
+        self.reconnect = reconnect
+        self.backoff = backoff
+        self.max_backoff = max_backoff
+        self.max_attempts = max_attempts
+
+        self.welcome: Optional[WelcomeData] = None
+        self.last_seq: Optional[int] = None
+        self._ws = None
+        self._handlers: Dict[str, List[Handler]] = {}
+        self._tasks: Set[asyncio.Task] = set()
+        self._closing = False
+        self._redirect: Optional[str] = None  # The server a migrated room moved to
+
+    def on(self, event: str, handler: Optional[Handler] = None):
+        """Registers handler for a message type or lifecycle event; a decorator without handler."""
+        def register(fn: Handler) -> Handler:
+            self._handlers.setdefault(event, []).append(fn)
+            return fn
+        return register(handler) if handler else register
+
+    @property
+    def connected(self) -> bool:
+        return self._ws is not None
+
+    def socket_url(self) -> str:
+        query: Dict[str, str] = {"room": self.room, "clientId": self.client_id, "type": self.client_type}
+        optional = {
+            "agentId": self.agent_id,
+            "capacity": str(self.capacity) if self.capacity else None,
+            "tenant": self.tenant,
+            "template": self.template,
+            "role": self.role,
+            "displayName": self.display_name,
+            "avatar": self.avatar,
+            "ticket": self.ticket,
+            "metadata": json.dumps(self.metadata) if self.metadata else None,
+        }
+        query.update({key: value for key, value in optional.items() if value})
+        if self.sample_rate:
+            query.update({"audioFormat": "pcm16", "sampleRate": str(self.sample_rate), "channels": str(self.channels)})
+        if self.last_seq is not None:
+            query["lastSeq"] = str(self.last_seq)
+        # Within the server's resume grace this takes the old place in the room back
+        if self.welcome and self.welcome.get("resumeToken"):
+            query["resumeToken"] = self.welcome["resumeToken"]
+        query.update(self.extra_query)
+        return f"{self._redirect or self.url}/ws?{urlencode(query)}"
+
+    async def connect(self) -> WelcomeData:
+        """Joins the room, returning the server's welcome."""
+        self._closing = False
+        headers = {"Authorization": f"Bearer {self.admin_token}"} if self.admin_token else None
+        try:
+            ws = await websockets.connect(self.socket_url(), additional_headers=headers, max_size=None)
+        except TypeError:  # websockets before 14
+            ws = await websockets.connect(self.socket_url(), extra_headers=headers, max_size=None)
+        # The server's first message is the welcome; replayed messages follow it
+        while True:
+            raw = await ws.recv()
+            if isinstance(raw, bytes):
+                continue
+            msg = json.loads(raw)
+            self._track(msg)
+            if msg.get("type") == "welcome":
+                break
+            if msg.get("type") == "redirect":
+                self._follow(msg)
+                await ws.close()
+                return await self.connect()
+            await self._dispatch(msg)
+        self._ws = ws
+        self.welcome = msg.get("data", {})
+        version = self.welcome.get("protocolVersion")
+        if version is not None and version != PROTOCOL_VERSION:
+            logger.warning("Server speaks protocol %s, this SDK %s", version, PROTOCOL_VERSION)
+        await self._emit("connected", self.welcome)
+        await self._dispatch(msg)
+        return self.welcome
+
+    async def run(self) -> None:
+        """Delivers messages until the connection closes for good, reconnecting on the way."""
+        attempts = 0
+        while True:
+            if self._ws is None:
+                try:
+                    await self.connect()
+                    attempts = 0
+                except Exception as err:
+                    self._redirect = None
+                    attempts += 1
+                    if not self.reconnect or (self.max_attempts is not None and attempts >= self.max_attempts):
+                        raise
+                    if isinstance(err, websockets.ConnectionClosed) and not retryable(_close_code(err)):
+                        raise
+                    await self._emit("error", err)
+                    await asyncio.sleep(self._delay(attempts))
+                    continue
+            code = await self._receive()
+            self._ws = None
+            await self._emit("disconnected", code)
+            if self._closing or not self.reconnect or not retryable(code):
+                return
+            attempts += 1
+            await asyncio.sleep(self._delay(attempts))
+
+    async def _receive(self) -> Optional[int]:
+        ws = self._ws
+        try:
+            async for raw in ws:
+                if isinstance(raw, bytes):
+                    await self._emit("audio", raw)
+                    continue
+                try:
+                    msg = json.loads(raw)
+                except ValueError as err:
+                    await self._emit("error", err)
+                    continue
+                self._track(msg)
+                await self._dispatch(msg)
+                if msg.get("type") == "redirect":
+                    # The room moved to another server, reconnect there with the resume token
+                    self._follow(msg)
+                    await ws.close(4000, "redirect")
+        except websockets.ConnectionClosed:
+            pass
+        return ws.close_code
+
+    def _delay(self, attempts: int) -> float:
+        return min(self.max_backoff, self.backoff * 2 ** (attempts - 1)) * (0.5 + random.random() / 2)
+
+    def _follow(self, msg: Dict[str, Any]) -> None:
+        url = msg.get("data", {}).get("url", "")
+        self._redirect = url.rstrip("/").replace("http://", "ws://", 1).replace("https://", "wss://", 1) or None
+
+    def _track(self, msg: Dict[str, Any]) -> None:
+        if isinstance(msg.get("seq"), int):
+            self.last_seq = msg["seq"]
+
+    async def _dispatch(self, msg: Dict[str, Any]) -> None:
+        kind = msg.get("type")
+        if kind == "replay_complete":
+            await self._emit("resumed", msg.get("data", {}))
+        elif kind == "error":
+            logger.warning("Server error: %s", msg.get("data"))
+        await self._emit("message", msg)
+        await self._emit(kind, msg)
+
+    async def _emit(self, event: str, value: Any) -> None:
+        for handler in self._handlers.get(event, ()):
+            try:
+                result = handler(value)
+                if inspect.isawaitable(result):
+                    task = asyncio.ensure_future(result)
+                    self._tasks.add(task)
+                    task.add_done_callback(self._finished)
+            except Exception:
+                logger.exception("Handler for %s failed", event)
+
+    def _finished(self, task: asyncio.Task) -> None:
+        self._tasks.discard(task)
+        if not task.cancelled() and task.exception():
+            logger.error("Handler failed", exc_info=task.exception())
+
+    async def send(self, msg_type: str, data: Optional[Dict[str, Any]] = None, to: Optional[List[str]] = None) -> None:
+        """Sends a message; to limits it to some participants."""
+        if self._ws is None:
+            raise ConnectionError("not connected")
+        message: Dict[str, Any] = {"type": msg_type, "data": data or {}, "timestamp": int(time.time() * 1000)}
+        if to:
+            message["to"] = to
+        await self._ws.send(json.dumps(message))
+
+    async def send_audio(self, pcm: bytes) -> None:
+        """Sends audio, pcm16 at the declared sample_rate."""
+        if self._ws is None:
+            raise ConnectionError("not connected")
+        await self._ws.send(pcm)
+
+    async def close(self) -> None:
+        self._closing = True
+        if self._ws is not None:
+            await self._ws.close()
+        for task in list(self._tasks):
+            task.cancel()
+
+    async def __aenter__(self) -> "Client":
+        await self.connect()
+        return self
+
+    async def __aexit__(self, *exc) -> None:
+        await self.close()
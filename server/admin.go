@@ -0,0 +1,31 @@
+package main
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "strings"
+)
+
+func isAdminRequest(r *http.Request) bool {
+    if cfg.AdminToken == "" {
+        return false
+    }
+    token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+// requireAdmin checks the bearer token on admin endpoints and writes the
+// error response itself, so handlers can just return when it fails.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+    if cfg.AdminToken == "" {
+        http.Error(w, "Admin API disabled", http.StatusForbidden)
+        return false
+    }
+    
+    if !isAdminRequest(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return false
+    }
+    
+    return true
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Agent performance rollups, by agent worker ID (routing.go) so a person or
+// bot reconnecting counts as one:
+//
+//   handle time  time in the call while callers were there, plus wrap-up
+//   talk ratio   the agent's share of the words in their calls, chat and
+//                spoken, transcripts counting for callers
+//   sentiment    of the calls they took, from message metadata
+//   handoffs     calls a later agent took over, e.g. the virtual agent
+//                handing off to a person, and calls taken over
+
+// agentCall is one agent's part in the current call. Guarded by roomsMu.
+type agentCall struct {
+    connections int   // Open, an agent may join from more than one
+    since       int64 // Start of the handle time being counted, 0 for none
+    handleMs    int64
+    words       int64
+    handedOff   bool
+    tookOver    bool
+}
+
+var pendingAgentRollups = make(map[string]*storage.AgentRollup) // Guarded by pendingRollupsMu
+
+func agentRollupId(r *storage.AgentRollup) string {
+    return fmt.Sprintf("%s|%d|%s|%s", r.Granularity, r.BucketStart, r.Tenant, r.AgentId)
+}
+
+// noteAgentPresence follows joins and leaves, counting handle time while an
+// agent and callers are both there. Callers hold roomsMu, after updating the
+// room's maps.
+func noteAgentPresence(room *RoomInfo, client *Client, joined bool) {
+    stats := &room.stats
+    if client.clientType == ClientTypeAgent {
+        if stats.agents == nil {
+            stats.agents = make(map[string]*agentCall)
+        }
+        call := stats.agents[client.agentId]
+        if call == nil {
+            call = &agentCall{}
+            if len(room.Users) > 0 {
+                for _, earlier := range stats.agents {
+                    earlier.handedOff = true
+                    call.tookOver = true
+                }
+            }
+            stats.agents[client.agentId] = call
+        }
+        if joined {
+            call.connections++
+        } else {
+            call.connections--
+        }
+    }
+    
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    for _, call := range stats.agents {
+        counting := call.connections > 0 && len(room.Users) > 0
+        if counting && call.since == 0 {
+            call.since = now
+        } else if !counting && call.since != 0 {
+            call.handleMs += now - call.since
+            call.since = 0
+        }
+    }
+}
+
+// countWords adds a message or final transcript to the call's talk. Callers
+// hold roomsMu.
+func countWords(room *RoomInfo, client *Client, text string) {
+    words := int64(len(strings.Fields(text)))
+    room.stats.words += words
+    if call := room.stats.agents[client.agentId]; call != nil && client.clientType == ClientTypeAgent {
+        call.words += words
+    }
+}
+
+// noteTranscriptWords counts a caller's final transcript.
+func noteTranscriptWords(client *Client, text string) {
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    if room := rooms[client.room]; room != nil {
+        countWords(room, client, text)
+    }
+}
+
+// rollupAgents adds each agent's part in a closed room to the pending
+// rollups. Callers hold pendingRollupsMu, the room being out of rooms.
+func rollupAgents(room *RoomInfo, endedAt int64) {
+    stats := &room.stats
+    for agentId, call := range stats.agents {
+        if call.since != 0 {
+            call.handleMs += endedAt - call.since
+        }
+        if call.handleMs == 0 && call.words == 0 {
+            // Never met a caller
+            continue
+        }
+        delta := storage.AgentRollup{
+            Tenant:         room.Tenant,
+            AgentId:        agentId,
+            Calls:          1,
+            HandleMs:       call.handleMs,
+            Words:          call.words,
+            CallWords:      stats.words,
+            SentimentSum:   stats.sentimentSum,
+            SentimentCount: stats.sentimentCount,
+        }
+        if call.handedOff {
+            delta.HandedOff = 1
+        }
+        if call.tookOver {
+            delta.TookOver = 1
+        }
+        mergeAgentDelta(delta, room.CreatedAt)
+    }
+}
+
+// rollupWrapUp adds wrap-up time to the bucket of the call it followed.
+func rollupWrapUp(wrap *wrapUp, endedAt int64) {
+    if wrap.AgentId == "" {
+        return
+    }
+    pendingRollupsMu.Lock()
+    defer pendingRollupsMu.Unlock()
+    mergeAgentDelta(storage.AgentRollup{Tenant: wrap.Tenant, AgentId: wrap.AgentId, WrapUpMs: endedAt - wrap.StartedAt}, wrap.callStart)
+}
+
+// mergeAgentDelta adds a call's delta to the hourly and daily rows of its
+// start. Callers hold pendingRollupsMu.
+func mergeAgentDelta(delta storage.AgentRollup, startedAt int64) {
+    for _, granularity := range rollupGranularities {
+        delta.Granularity = granularity
+        delta.BucketStart = bucketStart(granularity, startedAt)
+        mergeAgentPending(delta)
+    }
+}
+
+// mergeAgentPending must be called with pendingRollupsMu held.
+func mergeAgentPending(delta storage.AgentRollup) {
+    id := agentRollupId(&delta)
+    if pending := pendingAgentRollups[id]; pending != nil {
+        pending.Merge(delta)
+        return
+    }
+    pendingAgentRollups[id] = &delta
+}
+
+// flushAgentRollups is flushRollups for agent rows.
+func flushAgentRollups() {
+    pendingRollupsMu.Lock()
+    batch := pendingAgentRollups
+    pendingAgentRollups = make(map[string]*storage.AgentRollup)
+    pendingRollupsMu.Unlock()
+    
+    for _, delta := range batch {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        err := store.Analytics().AddAgent(ctx, *delta)
+        cancel()
+        if err != nil {
+            log.Printf("Agent analytics flush for %s failed: %v", agentRollupId(delta), err)
+            pendingRollupsMu.Lock()
+            mergeAgentPending(*delta)
+            pendingRollupsMu.Unlock()
+        }
+    }
+}
+
+// GET /analytics/agents?granularity=hour|day&from=&to=&tenant=&agentId=&groupBy=tenant,bucket&format=json|csv (admin)
+// One row per agent over the range unless grouped further, busiest first.
+func handleAgentAnalytics(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    query := r.URL.Query()
+    granularity := query.Get("granularity")
+    if granularity == "" {
+        granularity = "hour"
+    }
+    if granularity != "hour" && granularity != "day" {
+        http.Error(w, "granularity must be hour or day", http.StatusBadRequest)
+        return
+    }
+    now := time.Now()
+    from, err := parseAnalyticsTime(query.Get("from"), now.Add(-24*time.Hour))
+    if err != nil {
+        http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseAnalyticsTime(query.Get("to"), now)
+    if err != nil {
+        http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    byTenant, byBucket := false, false
+    for _, field := range splitList(query.Get("groupBy")) {
+        switch field {
+        case "tenant":
+            byTenant = true
+        case "bucket":
+            byBucket = true
+        default:
+            http.Error(w, "groupBy accepts tenant and bucket", http.StatusBadRequest)
+            return
+        }
+    }
+    
+    rows, err := store.Analytics().QueryAgents(r.Context(), granularity, bucketStart(granularity, from), to, query.Get("tenant"), query.Get("agentId"))
+    if err != nil {
+        log.Printf("Agent analytics query failed: %v", err)
+        http.Error(w, "Analytics unavailable", http.StatusInternalServerError)
+        return
+    }
+    groups := make(map[string]*storage.AgentRollup)
+    var order []*storage.AgentRollup
+    for _, row := range rows {
+        group := storage.AgentRollup{Granularity: granularity, AgentId: row.AgentId}
+        if byTenant {
+            group.Tenant = row.Tenant
+        }
+        if byBucket {
+            group.BucketStart = row.BucketStart
+        }
+        id := agentRollupId(&group)
+        if groups[id] == nil {
+            groups[id] = &group
+            order = append(order, &group)
+        }
+        groups[id].Merge(row)
+    }
+    sort.Slice(order, func(i, j int) bool {
+        if order[i].BucketStart != order[j].BucketStart {
+            return order[i].BucketStart < order[j].BucketStart
+        }
+        if order[i].Calls != order[j].Calls {
+            return order[i].Calls > order[j].Calls
+        }
+        return order[i].AgentId < order[j].AgentId
+    })
+    
+    results := make([]map[string]interface{}, 0, len(order))
+    for _, group := range order {
+        results = append(results, agentAnalyticsRow(group, byTenant, byBucket))
+    }
+    if query.Get("format") == "csv" {
+        writeAgentAnalyticsCSV(w, results, byTenant, byBucket)
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "granularity": granularity,
+        "agents":      results,
+    })
+}
+
+func agentAnalyticsRow(r *storage.AgentRollup, byTenant bool, byBucket bool) map[string]interface{} {
+    row := map[string]interface{}{
+        "agentId":         r.AgentId,
+        "calls":           r.Calls,
+        "avgHandleTimeMs": ratio(float64(r.HandleMs+r.WrapUpMs), r.Calls),
+        "avgTalkTimeMs":   ratio(float64(r.HandleMs), r.Calls),
+        "avgWrapUpMs":     ratio(float64(r.WrapUpMs), r.Calls),
+        "talkRatio":       ratio(float64(r.Words), r.CallWords),
+        "avgSentiment":    ratio(r.SentimentSum, r.SentimentCount),
+        "handedOff":       r.HandedOff,
+        "handoffRate":     ratio(float64(r.HandedOff), r.Calls),
+        "tookOver":        r.TookOver,
+    }
+    if byTenant {
+        row["tenant"] = r.Tenant
+    }
+    if byBucket {
+        row["bucket"] = time.Unix(0, r.BucketStart*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+    }
+    return row
+}
+
+func writeAgentAnalyticsCSV(w http.ResponseWriter, rows []map[string]interface{}, byTenant bool, byBucket bool) {
+    var columns []string
+    if byBucket {
+        columns = append(columns, "bucket")
+    }
+    if byTenant {
+        columns = append(columns, "tenant")
+    }
+    columns = append(columns, "agentId", "calls", "avgHandleTimeMs", "avgTalkTimeMs", "avgWrapUpMs", "talkRatio", "avgSentiment", "handedOff", "handoffRate", "tookOver")
+    
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", `attachment; filename="agents.csv"`)
+    out := csv.NewWriter(w)
+    out.Write(columns)
+    for _, row := range rows {
+        record := make([]string, len(columns))
+        for i, column := range columns {
+            switch value := row[column].(type) {
+            case float64:
+                record[i] = strconv.FormatFloat(value, 'f', 2, 64)
+            default:
+                record[i] = fmt.Sprint(value)
+            }
+        }
+        out.Write(record)
+    }
+    out.Flush()
+}
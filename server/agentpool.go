@@ -0,0 +1,217 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Agent pool: workers this server starts and stops itself, so the agent
+// capacity the router (routing.go) hands rooms to follows demand. Every
+// -pool-interval the rooms waiting for an agent are weighed against the free
+// capacity of all workers; -pool-queue-depth rooms or more beyond it spawn
+// enough -pool-worker-capacity workers to take them, up to -pool-max-workers.
+// A pool worker with no rooms for -pool-idle-timeout is taken out of routing
+// and terminated, down to -pool-min-workers.
+//
+// Spawning and terminating go to -pool-webhook as
+// {"action": "spawn"|"terminate", "workerId", "capacity", "joinUrl"}, or run
+// -pool-spawn-command and -pool-terminate-command, with {id} and {capacity}
+// replaced in each argument:
+//
+//   -pool-spawn-command 'docker run -d --name {id} -e AGENT_ID={id} iva-bot'
+//   -pool-terminate-command 'docker rm -f {id}'
+//   -pool-join-url 'http://{id}:8000/join'
+//
+// The worker is registered as it is spawned, with -pool-join-url if set.
+// A worker still starting fails its first assignments; they are routed again
+// on the next sweep rather than counted as demand for yet more workers.
+
+var (
+    pool = make(map[string]time.Time) // Worker ID to when it went idle, zero while it has rooms. Guarded by routerMu
+    
+    poolTotal = newCounterVec("iva_agent_pool_total", "Agent pool workers spawned and terminated, and hooks that failed.", "action")
+)
+
+func init() {
+    metricSeries = append(metricSeries, poolTotal)
+}
+
+func poolEnabled() bool {
+    return cfg.PoolWebhook != "" || cfg.PoolSpawnCommand != ""
+}
+
+// loadPool starts the pool, spawning -pool-min-workers right away.
+func loadPool() error {
+    if !poolEnabled() {
+        return nil
+    }
+    if cfg.PoolWorkerCapacity < 1 || cfg.PoolMaxWorkers < cfg.PoolMinWorkers {
+        return fmt.Errorf("-pool-worker-capacity must be at least 1 and -pool-max-workers at least -pool-min-workers")
+    }
+    startRouter()
+    go func() {
+        scalePool()
+        ticker := time.NewTicker(cfg.PoolInterval)
+        defer ticker.Stop()
+        for range ticker.C {
+            scalePool()
+        }
+    }()
+    return nil
+}
+
+// poolDemand counts the waiting rooms no assignment covers. Callers hold
+// routerMu.
+func poolDemand() int {
+    waiting := 0
+    roomsMu.RLock()
+    for roomId, room := range rooms {
+        if _, pending := assignments[roomId]; !pending && room.waitingSince != 0 && len(room.Agents) == 0 && len(room.Users) > 0 {
+            waiting++
+        }
+    }
+    roomsMu.RUnlock()
+    return waiting
+}
+
+// scalePool spawns workers for the queue beyond free capacity and
+// terminates idle ones.
+func scalePool() {
+    now := time.Now()
+    var spawn int
+    var terminate []string
+    routerMu.Lock()
+    loads := workerLoads()
+    free := 0
+    for _, worker := range workers {
+        if load := loads[worker.Id]; load < worker.Capacity {
+            free += worker.Capacity - load
+        }
+    }
+    for id, idleSince := range pool {
+        switch {
+        case workers[id] == nil:
+            // Removed through /admin/agents
+            delete(pool, id)
+            terminate = append(terminate, id)
+        case loads[id] > 0:
+            pool[id] = time.Time{}
+        case idleSince.IsZero():
+            pool[id] = now
+        }
+    }
+    if excess := poolDemand() - free; excess >= cfg.PoolQueueDepth && excess > 0 {
+        spawn = (excess + cfg.PoolWorkerCapacity - 1) / cfg.PoolWorkerCapacity
+    }
+    if short := cfg.PoolMinWorkers - len(pool); short > spawn {
+        spawn = short
+    }
+    if room := cfg.PoolMaxWorkers - len(pool); spawn > room {
+        spawn = room
+    }
+    if spawn == 0 {
+        for id, idleSince := range pool {
+            if len(pool) <= cfg.PoolMinWorkers {
+                break
+            }
+            if !idleSince.IsZero() && now.Sub(idleSince) >= cfg.PoolIdleTimeout && loads[id] == 0 {
+                // Out of routing first, so nothing is assigned to it while it stops
+                delete(workers, id)
+                delete(pool, id)
+                terminate = append(terminate, id)
+            }
+        }
+    }
+    spawned := make([]string, 0, spawn)
+    for i := 0; i < spawn; i++ {
+        id := "pool-" + newRandomId()[:8]
+        pool[id] = now
+        workers[id] = &agentWorker{
+            Id:       id,
+            JoinURL:  poolTemplate(cfg.PoolJoinURL, id),
+            Capacity: cfg.PoolWorkerCapacity,
+            Pooled:   true,
+        }
+        spawned = append(spawned, id)
+    }
+    routerMu.Unlock()
+    
+    for _, id := range spawned {
+        if err := poolHook("spawn", id, cfg.PoolSpawnCommand); err != nil {
+            log.Printf("level=warn Spawning agent worker %s failed: %v", id, err)
+            poolTotal.inc(nil, "failed")
+            routerMu.Lock()
+            delete(workers, id)
+            delete(pool, id)
+            routerMu.Unlock()
+            continue
+        }
+        log.Printf("Spawned agent worker %s", id)
+        poolTotal.inc(nil, "spawn")
+    }
+    for _, id := range terminate {
+        if err := poolHook("terminate", id, cfg.PoolTerminateCommand); err != nil {
+            log.Printf("level=warn Terminating agent worker %s failed: %v", id, err)
+            poolTotal.inc(nil, "failed")
+            continue
+        }
+        log.Printf("Terminated idle agent worker %s", id)
+        poolTotal.inc(nil, "terminate")
+    }
+    if len(spawned) > 0 {
+        go routeWaiting()
+    }
+}
+
+func poolTemplate(template string, id string) string {
+    return strings.NewReplacer("{id}", id, "{capacity}", strconv.Itoa(cfg.PoolWorkerCapacity)).Replace(template)
+}
+
+// poolHook runs a spawn or terminate through the webhook, or else the
+// command.
+func poolHook(action string, id string, command string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    if cfg.PoolWebhook != "" {
+        body, _ := json.Marshal(map[string]interface{}{
+            "action":   action,
+            "workerId": id,
+            "capacity": cfg.PoolWorkerCapacity,
+            "joinUrl":  poolTemplate(cfg.PoolJoinURL, id),
+        })
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.PoolWebhook, bytes.NewReader(body))
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            return err
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+            return fmt.Errorf("%s", resp.Status)
+        }
+        return nil
+    }
+    args := strings.Fields(command)
+    if len(args) == 0 {
+        return nil
+    }
+    for i := range args {
+        args[i] = poolTemplate(args[i], id)
+    }
+    output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
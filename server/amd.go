@@ -0,0 +1,162 @@
+package main
+
+import (
+    "net/url"
+    "strconv"
+    "time"
+    
+    "github.com/yourusername/my-go-project/amd"
+    "github.com/yourusername/my-go-project/telephony"
+)
+
+// Answering machine detection on outbound calls, -amd-mode:
+//
+//   auto     the gateway's answeredBy when it reports human or machine, else
+//            the audio heuristics of package amd on the callee's first seconds
+//   gateway  the gateway's answeredBy only
+//   audio    the audio heuristics only
+//   off      every answered call goes to the agent
+//
+// The verdict goes out as an amd_result event and as a message to the room's
+// agents, and decides what happens next: a person gets the virtual agent, a
+// machine is hung up on or left the campaign's message (outbound.go).
+
+const (
+    AMDAuto    = "auto"
+    AMDGateway = "gateway"
+    AMDAudio   = "audio"
+    AMDOff     = "off"
+)
+
+// amdVerdict is how an outbound call's answer was classified.
+type amdVerdict struct {
+    AnsweredBy string `json:"answeredBy"`
+    Reason     string `json:"reason"`
+    Source     string `json:"source"` // gateway or audio
+    ElapsedMs  int64  `json:"elapsedMs,omitempty"`
+}
+
+var amdResultsTotal = newCounterVec("iva_amd_results_total", "Answering machine detection verdicts by result and source.", "result", "source")
+
+func init() {
+    metricSeries = append(metricSeries, amdResultsTotal)
+}
+
+func amdListensToAudio() bool {
+    return cfg.AMDMode == AMDAuto || cfg.AMDMode == AMDAudio
+}
+
+// startMachineDetection starts analysing an outbound callee's audio as it
+// joins, i.e. as the call is answered. Only pcm16 audio can be analysed.
+func startMachineDetection(client *Client, query url.Values) {
+    if !amdListensToAudio() || client.clientType != ClientTypeUser || query.Get("audioFormat") != "pcm16" {
+        return
+    }
+    rate, err := strconv.Atoi(query.Get("sampleRate"))
+    if err != nil || rate <= 0 || !isOutboundCallee(client) {
+        return
+    }
+    if channels := query.Get("channels"); channels != "" && channels != "1" {
+        return
+    }
+    config := amd.DefaultConfig
+    config.TotalAnalysis = cfg.AMDAnalysisTime
+    config.SilenceThreshold = cfg.AMDSilenceThreshold
+    client.mu.Lock()
+    client.machineDetector = amd.New(config, rate)
+    client.mu.Unlock()
+}
+
+func detectMachine(client *Client, data []byte) {
+    client.mu.Lock()
+    detector := client.machineDetector
+    if detector == nil {
+        client.mu.Unlock()
+        return
+    }
+    result, done := detector.Feed(data)
+    if done {
+        client.machineDetector = nil
+    }
+    client.mu.Unlock()
+    if !done {
+        return
+    }
+    if call, ok := outboundCallOf(client); ok {
+        go amdDecided(call.Id, result, AMDAudio)
+    }
+}
+
+// callAnswered picks the verdict source once the gateway says the call was
+// answered, waiting for the audio analysis when that decides.
+func callAnswered(call outboundCall, gatewayVerdict string) {
+    known := gatewayVerdict == telephony.Human || gatewayVerdict == telephony.Machine
+    switch {
+    case cfg.AMDMode == AMDOff:
+        answerCall(call)
+    case known && cfg.AMDMode != AMDAudio:
+        amdDecided(call.Id, amd.Result{AnsweredBy: gatewayVerdict, Reason: "reported by the gateway"}, AMDGateway)
+    case cfg.AMDMode == AMDGateway:
+        amdDecided(call.Id, amd.Result{AnsweredBy: amd.Unknown, Reason: "the gateway gave no verdict"}, AMDGateway)
+    default:
+        // Bounded for callees whose audio never arrives or is not pcm16
+        timer := time.AfterFunc(cfg.AMDAnalysisTime+time.Second, func() {
+            amdDecided(call.Id, amd.Result{AnsweredBy: amd.Unknown, Reason: "no audio to analyse"}, AMDAudio)
+        })
+        outboundMu.Lock()
+        if live := outboundCalls[call.Id]; live != nil && live.AMD == nil {
+            live.amdTimeout = timer
+        } else {
+            timer.Stop()
+        }
+        outboundMu.Unlock()
+    }
+}
+
+// amdDecided records the first verdict on a call and acts on it.
+func amdDecided(id string, result amd.Result, source string) {
+    outboundMu.Lock()
+    call := outboundCalls[id]
+    if call == nil || call.AMD != nil {
+        outboundMu.Unlock()
+        return
+    }
+    call.AMD = &amdVerdict{
+        AnsweredBy: result.AnsweredBy,
+        Reason:     result.Reason,
+        Source:     source,
+        ElapsedMs:  int64(result.Elapsed / time.Millisecond),
+    }
+    call.AnsweredBy = result.AnsweredBy
+    if call.AnsweredAt == 0 {
+        // The audio beat the gateway's answered report
+        call.AnsweredAt = time.Now().UnixNano() / int64(time.Millisecond)
+        call.ring.Stop()
+    }
+    if call.amdTimeout != nil {
+        call.amdTimeout.Stop()
+    }
+    snapshot := *call
+    outboundMu.Unlock()
+    
+    amdResultsTotal.inc(metricLabelsFor(snapshot.Tenant, snapshot.Template), result.AnsweredBy, source)
+    logAt("info", snapshot.RoomId, snapshot.ClientId, "Answered by %s (%s, %s)", result.AnsweredBy, source, result.Reason)
+    data := map[string]interface{}{
+        "callId":     snapshot.Id,
+        "clientId":   snapshot.ClientId,
+        "campaignId": snapshot.CampaignId,
+        "answeredBy": result.AnsweredBy,
+        "reason":     result.Reason,
+        "source":     source,
+        "elapsedMs":  snapshot.AMD.ElapsedMs,
+    }
+    emitEvent("amd_result", &RoomInfo{RoomId: snapshot.RoomId, Tenant: snapshot.Tenant}, data)
+    sendToAgents(snapshot.RoomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "amd_result",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    answerCall(snapshot)
+}
@@ -0,0 +1,148 @@
+// Package amd tells answering machines from people picking up an outbound
+// call by the shape of the first seconds of audio, the way Asterisk's AMD
+// does: people answer with a word or two ("Hello?") and wait, machines
+// start with a long silence or talk on through a greeting.
+package amd
+
+import (
+    "encoding/binary"
+    "math"
+    "time"
+)
+
+const (
+    Human   = "human"
+    Machine = "machine"
+    Unknown = "unknown" // Analysis ran out of time
+)
+
+type Config struct {
+    InitialSilence       time.Duration // Silence before any speech that means a machine
+    Greeting             time.Duration // Speech longer than this is a machine's greeting
+    AfterGreetingSilence time.Duration // Silence after a short greeting that means a person
+    TotalAnalysis        time.Duration // Give up undecided after this much audio
+    MinWordLength        time.Duration // Shorter bursts of sound are noise, not words
+    BetweenWordsSilence  time.Duration // Silence that separates words
+    MaxWords             int           // More words than this is a machine
+    SilenceThreshold     float64       // RMS of a frame below which it is silent, 0 to 32767
+}
+
+var DefaultConfig = Config{
+    InitialSilence:       2500 * time.Millisecond,
+    Greeting:             1500 * time.Millisecond,
+    AfterGreetingSilence: 800 * time.Millisecond,
+    TotalAnalysis:        5000 * time.Millisecond,
+    MinWordLength:        100 * time.Millisecond,
+    BetweenWordsSilence:  50 * time.Millisecond,
+    MaxWords:             2,
+    SilenceThreshold:     256,
+}
+
+type Result struct {
+    AnsweredBy string        `json:"answeredBy"`
+    Reason     string        `json:"reason"`
+    Elapsed    time.Duration `json:"-"`
+}
+
+const frameLength = 20 * time.Millisecond
+
+// Detector analyses one call's mono pcm16 audio from the moment it was
+// answered. It is not safe for concurrent use.
+type Detector struct {
+    config     Config
+    frameBytes int
+    buf        []byte
+    
+    elapsed  time.Duration
+    silence  time.Duration // Current run of silence
+    voice    time.Duration // Current run of sound
+    greeting time.Duration // Sound since the greeting started
+    words    int
+    inWord   bool
+    result   *Result
+}
+
+func New(config Config, sampleRate int) *Detector {
+    return &Detector{config: config, frameBytes: sampleRate * 2 * int(frameLength/time.Millisecond) / 1000}
+}
+
+// Feed analyses more audio and reports the verdict once there is one. Audio
+// fed after that is ignored.
+func (d *Detector) Feed(pcm []byte) (Result, bool) {
+    if d.result != nil {
+        return *d.result, true
+    }
+    if d.frameBytes == 0 {
+        return d.decide(Unknown, "no sample rate")
+    }
+    d.buf = append(d.buf, pcm...)
+    for len(d.buf) >= d.frameBytes {
+        frame := d.buf[:d.frameBytes]
+        d.buf = d.buf[d.frameBytes:]
+        if result, done := d.frame(rms(frame) < d.config.SilenceThreshold); done {
+            d.buf = nil
+            return result, true
+        }
+    }
+    
+    // Compact so the backing array doesn't grow with the stream
+    d.buf = append([]byte(nil), d.buf...)
+    return Result{}, false
+}
+
+func (d *Detector) frame(silent bool) (Result, bool) {
+    d.elapsed += frameLength
+    if silent {
+        d.silence += frameLength
+        if d.silence >= d.config.BetweenWordsSilence {
+            d.inWord, d.voice = false, 0
+        }
+        switch {
+        case d.words == 0 && d.silence >= d.config.InitialSilence:
+            return d.decide(Machine, "long silence before speaking")
+        case d.words > 0 && d.silence >= d.config.AfterGreetingSilence:
+            return d.decide(Human, "short greeting then silence")
+        }
+    } else {
+        d.silence = 0
+        d.voice += frameLength
+        if d.words > 0 || d.voice >= d.config.MinWordLength {
+            d.greeting += frameLength
+        }
+        if !d.inWord && d.voice >= d.config.MinWordLength {
+            d.inWord = true
+            d.words++
+            if d.words == 1 {
+                d.greeting = d.voice
+            }
+        }
+        switch {
+        case d.words > d.config.MaxWords:
+            return d.decide(Machine, "too many words")
+        case d.greeting > d.config.Greeting:
+            return d.decide(Machine, "long greeting")
+        }
+    }
+    if d.elapsed >= d.config.TotalAnalysis {
+        return d.decide(Unknown, "undecided after analysis time")
+    }
+    return Result{}, false
+}
+
+func (d *Detector) decide(answeredBy string, reason string) (Result, bool) {
+    d.result = &Result{AnsweredBy: answeredBy, Reason: reason, Elapsed: d.elapsed}
+    return *d.result, true
+}
+
+func rms(frame []byte) float64 {
+    var sum float64
+    n := len(frame) / 2
+    for i := 0; i < n; i++ {
+        sample := float64(int16(binary.LittleEndian.Uint16(frame[2*i:])))
+        sum += sample * sample
+    }
+    if n == 0 {
+        return 0
+    }
+    return math.Sqrt(sum / float64(n))
+}
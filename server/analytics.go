@@ -0,0 +1,384 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// callStats collects the per-call facts rolled up when the room closes.
+// Guarded by roomsMu like the rest of RoomInfo.
+type callStats struct {
+    firstUserAt    int64 // First user chat message
+    firstReplyAt   int64 // First agent chat message after it
+    intents        map[string]int64
+    sentimentSum   float64
+    sentimentCount int64
+    profanity      int64 // Words caught by the profanity filter
+    words          int64 // Everyone's, see countWords
+    agents         map[string]*agentCall // By agent worker ID, see agentanalytics.go
+}
+
+var (
+    pendingRollups   = make(map[string]*storage.Rollup) // Not yet flushed, keyed by rollupId
+    pendingRollupsMu sync.Mutex
+)
+
+var rollupGranularities = []string{"hour", "day"}
+
+// bucketStart aligns a Unix millisecond timestamp to its UTC hour or day.
+func bucketStart(granularity string, ms int64) int64 {
+    t := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+    if granularity == "day" {
+        t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+    } else {
+        t = t.Truncate(time.Hour)
+    }
+    return t.UnixNano() / int64(time.Millisecond)
+}
+
+func rollupId(r *storage.Rollup) string {
+    return fmt.Sprintf("%s|%d|%s|%s|%s", r.Granularity, r.BucketStart, r.Tenant, r.Template, r.Variant)
+}
+
+// observeForAnalytics tracks first response time and the intent and
+// sentiment agents attach to their messages as metadata.
+func observeForAnalytics(roomId string, sender *Client, msg *Message) {
+    if unrecordedTypes[msg.Type] || isEphemeralType(msg.Type) {
+        return
+    }
+    
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    
+    room := rooms[roomId]
+    if room == nil {
+        return
+    }
+    stats := &room.stats
+    
+    switch msg.Type {
+    case "broadcast", "selective", "user_only", "assistant_final", "speak":
+        if data, ok := msg.Data.(map[string]interface{}); ok {
+            text, _ := data["text"].(string)
+            countWords(room, sender, text)
+            observeShadowTurn(room, sender, msg.Type, text, msg.Timestamp)
+        }
+    }
+    
+    if sender.clientType == ClientTypeUser {
+        if stats.firstUserAt == 0 {
+            stats.firstUserAt = msg.Timestamp
+        }
+        return
+    }
+    
+    if stats.firstUserAt != 0 && stats.firstReplyAt == 0 {
+        stats.firstReplyAt = msg.Timestamp
+    }
+    if intent, ok := msg.Metadata["intent"].(string); ok && intent != "" {
+        if stats.intents == nil {
+            stats.intents = make(map[string]int64)
+        }
+        stats.intents[intent]++
+    }
+    if sentiment, ok := msg.Metadata["sentiment"].(float64); ok {
+        stats.sentimentSum += sentiment
+        stats.sentimentCount++
+    }
+}
+
+// rollupCall adds a closed room to the pending hourly and daily rollups. The
+// room must be out of rooms, so nothing changes its stats meanwhile.
+func rollupCall(room *RoomInfo, endedAt int64) {
+    stats := &room.stats
+    call := storage.Rollup{
+        Tenant:         room.Tenant,
+        Template:       room.Template,
+        Variant:        variantTag(room.Variants),
+        Calls:          1,
+        DurationMs:     endedAt - room.CreatedAt,
+        MaxDurationMs:  endedAt - room.CreatedAt,
+        Intents:        stats.intents,
+        SentimentSum:   stats.sentimentSum,
+        SentimentCount: stats.sentimentCount,
+        Profanity:      stats.profanity,
+    }
+    if stats.profanity > 0 {
+        call.ProfaneCalls = 1
+    }
+    if stats.firstUserAt != 0 {
+        if stats.firstReplyAt != 0 {
+            call.Responded = 1
+            call.FirstResponseMs = stats.firstReplyAt - stats.firstUserAt
+        }
+        if stats.firstReplyAt != 0 && time.Duration(call.FirstResponseMs)*time.Millisecond <= cfg.SLAFirstResponse {
+            call.SLAMet = 1
+        } else {
+            call.SLAMissed = 1
+        }
+    }
+    
+    pendingRollupsMu.Lock()
+    defer pendingRollupsMu.Unlock()
+    for _, granularity := range rollupGranularities {
+        delta := call
+        delta.Granularity = granularity
+        delta.BucketStart = bucketStart(granularity, room.CreatedAt)
+        mergePending(delta)
+    }
+    rollupAgents(room, endedAt)
+}
+
+// mergePending must be called with pendingRollupsMu held.
+func mergePending(delta storage.Rollup) {
+    id := rollupId(&delta)
+    if pending := pendingRollups[id]; pending != nil {
+        pending.Merge(delta)
+        return
+    }
+    row := storage.Rollup{Granularity: delta.Granularity, BucketStart: delta.BucketStart, Tenant: delta.Tenant, Template: delta.Template, Variant: delta.Variant}
+    row.Merge(delta)
+    pendingRollups[id] = &row
+}
+
+// flushRollups writes pending deltas to the analytics tables. Failed writes
+// go back into the pending set for the next flush.
+func flushRollups() {
+    pendingRollupsMu.Lock()
+    batch := pendingRollups
+    pendingRollups = make(map[string]*storage.Rollup)
+    pendingRollupsMu.Unlock()
+    
+    for _, delta := range batch {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        err := store.Analytics().Add(ctx, *delta)
+        cancel()
+        if err != nil {
+            log.Printf("Analytics flush for %s failed: %v", rollupId(delta), err)
+            pendingRollupsMu.Lock()
+            mergePending(*delta)
+            pendingRollupsMu.Unlock()
+        }
+    }
+}
+
+func startAnalyticsJob() {
+    go func() {
+        for range time.Tick(cfg.AnalyticsFlush) {
+            flushRollups()
+            flushAgentRollups()
+        }
+    }()
+}
+
+// parseAnalyticsTime accepts RFC 3339 or Unix milliseconds.
+func parseAnalyticsTime(value string, fallback time.Time) (int64, error) {
+    if value == "" {
+        return fallback.UnixNano() / int64(time.Millisecond), nil
+    }
+    if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+        return ms, nil
+    }
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return 0, fmt.Errorf("%q is neither RFC 3339 nor Unix milliseconds", value)
+    }
+    return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// GET /analytics?granularity=hour|day&from=&to=&tenant=&groupBy=tenant,template,variant&format=json|csv (admin or observer)
+// Rows lag live traffic by up to one flush interval. See experiments.go for
+// the variant, and observer.go for the tenants an observer token sees.
+func handleAnalytics(w http.ResponseWriter, r *http.Request) {
+    scope, ok := readScope(w, r, "/analytics", false)
+    if !ok {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    query := r.URL.Query()
+    granularity := query.Get("granularity")
+    if granularity == "" {
+        granularity = "hour"
+    }
+    if granularity != "hour" && granularity != "day" {
+        http.Error(w, "granularity must be hour or day", http.StatusBadRequest)
+        return
+    }
+    
+    now := time.Now()
+    from, err := parseAnalyticsTime(query.Get("from"), now.Add(-24*time.Hour))
+    if err != nil {
+        http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseAnalyticsTime(query.Get("to"), now)
+    if err != nil {
+        http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    byTenant, byTemplate, byVariant := false, false, false
+    for _, field := range splitList(query.Get("groupBy")) {
+        switch field {
+        case "tenant":
+            byTenant = true
+        case "template":
+            byTemplate = true
+        case "variant":
+            byVariant = true
+        default:
+            http.Error(w, "groupBy accepts tenant, template and variant", http.StatusBadRequest)
+            return
+        }
+    }
+    
+    if tenant := query.Get("tenant"); tenant != "" && !scope.allows(tenant) {
+        http.Error(w, "Tenant not visible to this observer token", http.StatusForbidden)
+        return
+    }
+    rows, err := store.Analytics().Query(r.Context(), granularity, bucketStart(granularity, from), to, query.Get("tenant"))
+    if err != nil {
+        log.Printf("Analytics query failed: %v", err)
+        http.Error(w, "Analytics unavailable", http.StatusInternalServerError)
+        return
+    }
+    
+    // Collapse stored rows to the requested grouping
+    groups := make(map[string]*storage.Rollup)
+    var order []string
+    for _, row := range rows {
+        if !scope.allows(row.Tenant) {
+            continue
+        }
+        group := storage.Rollup{Granularity: row.Granularity, BucketStart: row.BucketStart}
+        if byTenant {
+            group.Tenant = row.Tenant
+        }
+        if byTemplate {
+            group.Template = row.Template
+        }
+        if byVariant {
+            group.Variant = row.Variant
+        }
+        id := rollupId(&group)
+        if groups[id] == nil {
+            groups[id] = &group
+            order = append(order, id)
+        }
+        groups[id].Merge(row)
+    }
+    sort.Strings(order)
+    
+    results := make([]map[string]interface{}, 0, len(order))
+    for _, id := range order {
+        results = append(results, analyticsRow(groups[id], byTenant, byTemplate, byVariant))
+    }
+    
+    if query.Get("format") == "csv" {
+        writeAnalyticsCSV(w, results, byTenant, byTemplate, byVariant)
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "granularity": granularity,
+        "rows":        results,
+    })
+}
+
+func analyticsRow(r *storage.Rollup, byTenant bool, byTemplate bool, byVariant bool) map[string]interface{} {
+    row := map[string]interface{}{
+        "bucket":             time.Unix(0, r.BucketStart*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+        "calls":              r.Calls,
+        "avgDurationMs":      ratio(float64(r.DurationMs), r.Calls),
+        "maxDurationMs":      r.MaxDurationMs,
+        "avgSentiment":       ratio(r.SentimentSum, r.SentimentCount),
+        "avgFirstResponseMs": ratio(float64(r.FirstResponseMs), r.Responded),
+        "slaMet":             r.SLAMet,
+        "slaMissed":          r.SLAMissed,
+        "slaPct":             100 * ratio(float64(r.SLAMet), r.SLAMet+r.SLAMissed),
+        "profanity":          r.Profanity,
+        "profaneCalls":       r.ProfaneCalls,
+        "intents":            r.Intents,
+        "scored":             r.Scored,
+        "avgResolution":      ratio(r.ResolutionSum, r.Scored),
+        "avgAccuracy":        ratio(r.AccuracySum, r.AccuracyCount),
+        "avgTone":            ratio(r.ToneSum, r.Scored),
+        "avgQuality":         ratio(r.OverallSum, r.Scored),
+        "lowQuality":         r.LowQuality,
+    }
+    if byTenant {
+        row["tenant"] = r.Tenant
+    }
+    if byTemplate {
+        row["template"] = r.Template
+    }
+    if byVariant {
+        row["variant"] = r.Variant
+    }
+    return row
+}
+
+func ratio(sum float64, n int64) float64 {
+    if n == 0 {
+        return 0
+    }
+    return sum / float64(n)
+}
+
+func writeAnalyticsCSV(w http.ResponseWriter, rows []map[string]interface{}, byTenant bool, byTemplate bool, byVariant bool) {
+    columns := []string{"bucket"}
+    if byTenant {
+        columns = append(columns, "tenant")
+    }
+    if byTemplate {
+        columns = append(columns, "template")
+    }
+    if byVariant {
+        columns = append(columns, "variant")
+    }
+    columns = append(columns, "calls", "avgDurationMs", "maxDurationMs", "avgSentiment", "avgFirstResponseMs", "slaMet", "slaMissed", "slaPct", "profanity", "profaneCalls",
+        "scored", "avgResolution", "avgAccuracy", "avgTone", "avgQuality", "lowQuality", "intents")
+    
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", `attachment; filename="analytics.csv"`)
+    out := csv.NewWriter(w)
+    out.Write(columns)
+    for _, row := range rows {
+        record := make([]string, len(columns))
+        for i, column := range columns {
+            switch value := row[column].(type) {
+            case map[string]int64:
+                // intent:count pairs, most frequent first
+                intents := make([]string, 0, len(value))
+                for intent := range value {
+                    intents = append(intents, intent)
+                }
+                sort.Slice(intents, func(a, b int) bool { return value[intents[a]] > value[intents[b]] })
+                for j, intent := range intents {
+                    intents[j] = fmt.Sprintf("%s:%d", intent, value[intent])
+                }
+                record[i] = strings.Join(intents, ";")
+            case float64:
+                record[i] = strconv.FormatFloat(value, 'f', 2, 64)
+            default:
+                record[i] = fmt.Sprint(value)
+            }
+        }
+        out.Write(record)
+    }
+    out.Flush()
+}
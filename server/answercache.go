@@ -0,0 +1,352 @@
+package main
+
+import (
+    "bytes"
+    "container/list"
+    "context"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+    
+    "github.com/yourusername/my-go-project/tts"
+)
+
+// Answer cache for the virtual agent's common questions, on with
+// -answer-cache-size. An assistant_final the agent marks as cacheable, as
+// nothing in it is particular to the caller,
+//
+//   {"type": "assistant_final", "data": {"text": TEXT, "citations": [...], "question": TEXT, "kgVersion": "v42", "cache": true}}
+//
+// is kept under its tenant, template, normalized question and knowledge graph
+// version, with its speech synthesized in the room's voice when the server
+// has the TTS. Before asking its LLM the agent looks the question up:
+//
+//   {"type": "answer_lookup", "data": {"question": TEXT, "kgVersion": "v42", "speak": true}}
+//
+// and gets answer_cached {"hit", "answerId", "text", "citations", "spoken"}
+// back. On a hit with "speak" the server answers for it: the room gets the
+// assistant_final ("cached": true) and, "spoken" being true, its audio.
+// Entries live for -answer-cache-ttl; the graph's ingest drops those that
+// relied on changed nodes or an older graph version with
+//
+//   POST /admin/answers/invalidate   {"nodeIds": [...], "kgVersion": "v43"}
+//
+// and GET /admin/answers lists them, DELETE /admin/answers clears them.
+
+type cachedAnswer struct {
+    Id        string     `json:"id"`
+    Tenant    string     `json:"tenant"`
+    Template  string     `json:"template"`
+    Question  string     `json:"question"` // Normalized
+    KGVersion string     `json:"kgVersion,omitempty"`
+    Text      string     `json:"text"`
+    Citations []Citation `json:"citations"`
+    CachedAt  int64      `json:"cachedAt"`
+    Hits      int        `json:"hits"`
+    Voice     string     `json:"voice,omitempty"` // Of the audio, when synthesized
+    Audio     bool       `json:"audio"`
+    key        string
+    provider   string
+    pcm        []byte
+    sampleRate int
+    element    *list.Element
+}
+
+var (
+    answers     = make(map[string]*cachedAnswer) // By answerKey
+    answerOrder = list.New()                      // Most recently used first
+    answerMu    sync.Mutex                        // Never held while taking roomsMu
+    
+    answerCacheTotal = newCounterVec("iva_answer_cache_total", "Answer cache lookups by result, stored and invalidated answers.", "result")
+)
+
+func init() {
+    metricSeries = append(metricSeries, answerCacheTotal)
+}
+
+// Words that say nothing about what was asked.
+var questionFillers = map[string]bool{"um": true, "uh": true, "er": true, "hmm": true, "please": true, "so": true, "well": true}
+
+// normalizeQuestion is the question as cached: lower case, without
+// punctuation and fillers.
+func normalizeQuestion(question string) string {
+    words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+    })
+    kept := words[:0]
+    for _, word := range words {
+        if !questionFillers[word] {
+            kept = append(kept, word)
+        }
+    }
+    return strings.Join(kept, " ")
+}
+
+func answerKey(tenant, template, question, kgVersion string) string {
+    return strings.Join([]string{tenant, template, question, kgVersion}, "\x00")
+}
+
+// cacheAnswer keeps an approved assistant_final the agent marked cacheable.
+func cacheAnswer(roomId string, sender *Client, msg *Message) {
+    if cfg.AnswerCacheSize <= 0 {
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    cacheable, _ := data["cache"].(bool)
+    question, _ := data["question"].(string)
+    if question = normalizeQuestion(question); !cacheable || question == "" {
+        return
+    }
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    text, _ := data["text"].(string)
+    citations, _ := data["citations"].([]Citation)
+    kgVersion, _ := data["kgVersion"].(string)
+    answer := &cachedAnswer{
+        Id:        newRandomId(),
+        Tenant:    room.Tenant,
+        Template:  room.Template,
+        Question:  question,
+        KGVersion: kgVersion,
+        Text:      text,
+        Citations: citations,
+        CachedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    answer.key = answerKey(answer.Tenant, answer.Template, question, kgVersion)
+    
+    answerMu.Lock()
+    if old := answers[answer.key]; old != nil {
+        answerOrder.Remove(old.element)
+    }
+    answer.element = answerOrder.PushFront(answer)
+    answers[answer.key] = answer
+    for answerOrder.Len() > cfg.AnswerCacheSize {
+        oldest := answerOrder.Remove(answerOrder.Back()).(*cachedAnswer)
+        delete(answers, oldest.key)
+    }
+    answerMu.Unlock()
+    answerCacheTotal.inc(sender.labels, "stored")
+    logAt("debug", roomId, sender.clientId, "Cached answer %s to %q", answer.Id, question)
+    
+    settings := ttsSettingsFor(room)
+    if provider := ttsProviders[settings.Provider]; provider != nil {
+        req := tts.Request{Text: text, Voice: settings.Voice, Style: settings.Style, Speed: settings.Speed, SampleRate: cfg.TTSSampleRate}
+        go synthesizeAnswer(answer, provider, req)
+    }
+}
+
+// synthesizeAnswer keeps the cached answer's speech, so hits play at once.
+func synthesizeAnswer(answer *cachedAnswer, provider tts.Provider, req tts.Request) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    audio, err := provider.Synthesize(ctx, pronounced(answer.Tenant, provider, req))
+    if err != nil {
+        logAt("warn", "", "", "Speech for cached answer %s failed: %v", answer.Id, err)
+        return
+    }
+    defer audio.Body.Close()
+    pcm, err := io.ReadAll(io.LimitReader(audio.Body, int64(cfg.TTSCacheMaxEntry)+1))
+    if err != nil || len(pcm) > cfg.TTSCacheMaxEntry {
+        return
+    }
+    answerMu.Lock()
+    answer.provider, answer.Voice = provider.Name(), req.Voice
+    answer.pcm, answer.sampleRate, answer.Audio = pcm, audio.SampleRate, true
+    answerMu.Unlock()
+}
+
+// lookupAnswer finds a live entry, counting the hit.
+func lookupAnswer(key string) (cachedAnswer, bool) {
+    answerMu.Lock()
+    defer answerMu.Unlock()
+    answer := answers[key]
+    if answer == nil {
+        return cachedAnswer{}, false
+    }
+    age := time.Duration(time.Now().UnixNano()/int64(time.Millisecond)-answer.CachedAt) * time.Millisecond
+    if cfg.AnswerCacheTTL > 0 && age > cfg.AnswerCacheTTL {
+        answerOrder.Remove(answer.element)
+        delete(answers, key)
+        return cachedAnswer{}, false
+    }
+    answer.Hits++
+    answerOrder.MoveToFront(answer.element)
+    return *answer, true
+}
+
+// answer_lookup: {"question": TEXT, "kgVersion": VERSION, "speak": BOOL}
+func handleAnswerLookup(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents can look up answers")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    question, _ := data["question"].(string)
+    kgVersion, _ := data["kgVersion"].(string)
+    speakIt, _ := data["speak"].(bool)
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    if kgVersion == "" {
+        kgVersion = room.KGSnapshot // See kgsnapshots.go
+    }
+    question = normalizeQuestion(question)
+    
+    reply := map[string]interface{}{"hit": false, "question": question}
+    answer, hit := cachedAnswer{}, false
+    if cfg.AnswerCacheSize > 0 && question != "" {
+        answer, hit = lookupAnswer(answerKey(room.Tenant, room.Template, question, kgVersion))
+    }
+    if hit {
+        answerCacheTotal.inc(sender.labels, "hit")
+        reply["hit"] = true
+        reply["answerId"] = answer.Id
+        reply["text"] = answer.Text
+        reply["citations"] = answer.Citations
+        reply["kgVersion"] = answer.KGVersion
+        reply["spoken"] = speakIt && answerFromCache(roomId, sender, room, answer)
+    } else {
+        answerCacheTotal.inc(sender.labels, "miss")
+    }
+    sendMessageToClient(sender, &Message{
+        Id:        newMessageId(),
+        Type:      "answer_cached",
+        From:      SystemSender,
+        Data:      reply,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// answerFromCache gives the room a cached answer as the agent's, reporting
+// whether the server also speaks it.
+func answerFromCache(roomId string, sender *Client, room *RoomInfo, answer cachedAnswer) bool {
+    final := &Message{
+        Id:   newMessageId(),
+        Type: "assistant_final",
+        From: sender.clientId,
+        Data: map[string]interface{}{
+            "text":      answer.Text,
+            "citations": answer.Citations,
+            "cached":    true,
+            "answerId":  answer.Id,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    recordHistory(roomId, final)
+    observeForAnalytics(roomId, sender, final)
+    handleAssistantFinal(roomId, sender, final)
+    
+    settings := ttsSettingsFor(room)
+    provider := ttsProviders[settings.Provider]
+    if provider == nil {
+        return false
+    }
+    ctx, cancel := context.WithCancelCause(context.Background())
+    sender.mu.Lock()
+    stopSpeaking(sender, nil)
+    sender.stopSpeech = cancel
+    sender.mu.Unlock()
+    if answer.Audio && answer.provider == provider.Name() && answer.Voice == settings.Voice {
+        speech := &utterance{
+            Audio:     &tts.Audio{SampleRate: answer.sampleRate, Body: io.NopCloser(bytes.NewReader(answer.pcm)), Cached: true},
+            provider:  answer.provider,
+            requested: time.Now(),
+        }
+        go playSpeech(ctx, roomId, sender, final, speech, answer.Voice)
+        return true
+    }
+    req := tts.Request{Text: answer.Text, Voice: settings.Voice, Style: settings.Style, Speed: settings.Speed, SampleRate: cfg.TTSSampleRate}
+    go speak(ctx, roomId, sender, final, provider, req)
+    return true
+}
+
+// GET /admin/answers[?tenant=], DELETE /admin/answers[?tenant=],
+// POST /admin/answers/invalidate (admin)
+func handleAnswers(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    tenant := r.URL.Query().Get("tenant")
+    switch {
+    case r.URL.Path == "/admin/answers" && r.Method == http.MethodGet:
+        entries := []cachedAnswer{}
+        answerMu.Lock()
+        for e := answerOrder.Front(); e != nil; e = e.Next() {
+            if answer := e.Value.(*cachedAnswer); tenant == "" || answer.Tenant == tenant {
+                entries = append(entries, *answer)
+            }
+        }
+        answerMu.Unlock()
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"answers": entries})
+    
+    case r.URL.Path == "/admin/answers" && r.Method == http.MethodDelete:
+        n := invalidateAnswers(func(answer *cachedAnswer) bool { return tenant == "" || answer.Tenant == tenant })
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"invalidated": n})
+    
+    case r.URL.Path == "/admin/answers/invalidate" && r.Method == http.MethodPost:
+        var req struct {
+            NodeIds   []string `json:"nodeIds"`
+            KGVersion string   `json:"kgVersion"`
+        }
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if len(req.NodeIds) == 0 && req.KGVersion == "" {
+            http.Error(w, "nodeIds or kgVersion required", http.StatusBadRequest)
+            return
+        }
+        changed := make(map[string]bool, len(req.NodeIds))
+        for _, id := range req.NodeIds {
+            changed[id] = true
+        }
+        n := invalidateAnswers(func(answer *cachedAnswer) bool {
+            if tenant != "" && answer.Tenant != tenant {
+                return false
+            }
+            if req.KGVersion != "" && answer.KGVersion != req.KGVersion {
+                return true
+            }
+            for _, c := range answer.Citations {
+                if (c.Type == "kg_node" || c.Type == "kg_relationship") && changed[c.Id] {
+                    return true
+                }
+            }
+            return false
+        })
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"invalidated": n})
+    
+    default:
+        http.Error(w, "Not found", http.StatusNotFound)
+    }
+}
+
+// invalidateAnswers drops the entries stale reports true for.
+func invalidateAnswers(stale func(*cachedAnswer) bool) int {
+    answerMu.Lock()
+    n := 0
+    for key, answer := range answers {
+        if stale(answer) {
+            answerOrder.Remove(answer.element)
+            delete(answers, key)
+            n++
+        }
+    }
+    answerMu.Unlock()
+    if n > 0 {
+        answerCacheTotal.add(float64(n), nil, "invalidated")
+        log.Printf("Invalidated %d cached answers", n)
+    }
+    return n
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "log"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// audioFramer regroups a PCM stream into fixed duration frames. Only sources
+// that declare audioFormat=pcm16 with a sample rate are framed, encoded
+// payloads (the bot's TTS output) are forwarded untouched.
+type audioFramer struct {
+    frameBytes int
+    buf        []byte
+}
+
+func newAudioFramer(query url.Values) *audioFramer {
+    if cfg.AudioFrameMs <= 0 || query.Get("audioFormat") != "pcm16" {
+        return nil
+    }
+    
+    rate, err := strconv.Atoi(query.Get("sampleRate"))
+    if err != nil || rate <= 0 {
+        return nil
+    }
+    channels, err := strconv.Atoi(query.Get("channels"))
+    if err != nil || channels <= 0 {
+        channels = 1
+    }
+    
+    frameBytes := rate * channels * 2 * cfg.AudioFrameMs / 1000
+    if frameBytes == 0 {
+        return nil
+    }
+    return &audioFramer{frameBytes: frameBytes}
+}
+
+// push returns every complete frame, keeping the remainder for the next chunk.
+func (f *audioFramer) push(data []byte) [][]byte {
+    f.buf = append(f.buf, data...)
+    
+    var frames [][]byte
+    for len(f.buf) >= f.frameBytes {
+        frame := make([]byte, f.frameBytes)
+        copy(frame, f.buf)
+        frames = append(frames, frame)
+        f.buf = f.buf[f.frameBytes:]
+    }
+    
+    // Compact so the backing array doesn't grow with the stream
+    f.buf = append([]byte(nil), f.buf...)
+    return frames
+}
+
+// audioPacer writes frames to one subscriber at most one per frame duration,
+// smoothing bursts from the network into a steady stream.
+type audioPacer struct {
+    frames  chan []byte
+    dropped int
+}
+
+func (c *Client) runAudioPacer(p *audioPacer) {
+    interval := time.Duration(cfg.AudioFrameMs) * time.Millisecond
+    next := time.Now()
+    
+    for frame := range p.frames {
+        if wait := time.Until(next); wait > 0 {
+            time.Sleep(wait)
+        }
+        if err := writeAudio(c, frame); err != nil {
+            log.Printf("Paced audio write error to %s: %v", c.clientId, err)
+        }
+        
+        // After a gap start a fresh schedule rather than bursting to catch up
+        now := time.Now()
+        if next.Before(now.Add(-interval)) {
+            next = now
+        }
+        next = next.Add(interval)
+    }
+}
+
+func (c *Client) stopAudioPacer() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    c.pacerStopped = true
+    if c.pacer != nil {
+        close(c.pacer.frames)
+        c.pacer = nil
+    }
+}
+
+// sendAudio writes directly, or hands framed audio to the subscriber's pacer.
+func sendAudio(client *Client, audioData []byte, paced bool) error {
+    if !paced {
+        return writeAudio(client, audioData)
+    }
+    
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    if client.pacerStopped || !client.acceptsWrites() {
+        return nil
+    }
+    if client.pacer == nil {
+        client.pacer = &audioPacer{frames: make(chan []byte, cfg.AudioPacerFrames)}
+        go client.runAudioPacer(client.pacer)
+    }
+    
+    select {
+    case client.pacer.frames <- audioData:
+    default:
+        client.pacer.dropped++
+        if client.pacer.dropped%50 == 1 {
+            log.Printf("Pacer for %s full, %d frames dropped so far", client.clientId, client.pacer.dropped)
+        }
+    }
+    return nil
+}
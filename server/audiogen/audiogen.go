@@ -0,0 +1,173 @@
+// Package audiogen produces deterministic pcm16 audio for test clients: pure
+// tones, silence, speech-like phrases and WAV fixtures, cut into fixed frames
+// and played at real-time pace. The same input always yields the same bytes,
+// so forwarding, VAD and STT tests can assert on exact results.
+package audiogen
+
+import (
+    "context"
+    "encoding/binary"
+    "math"
+    "strings"
+    "time"
+)
+
+// Format describes little-endian signed 16-bit PCM, the server's pcm16 codec.
+type Format struct {
+    SampleRate int
+    Channels   int
+}
+
+var Default = Format{SampleRate: 16000, Channels: 1}
+
+func (f Format) bytesPerSample() int {
+    return 2 * f.Channels
+}
+
+// Bytes is the size of d worth of audio in this format.
+func (f Format) Bytes(d time.Duration) int {
+    return int(int64(f.SampleRate)*int64(d)/int64(time.Second)) * f.bytesPerSample()
+}
+
+// Duration is how long n bytes of audio in this format play for.
+func (f Format) Duration(n int) time.Duration {
+    return time.Duration(int64(n/f.bytesPerSample()) * int64(time.Second) / int64(f.SampleRate))
+}
+
+// render writes one sample per frame to every channel.
+func render(f Format, samples int, sample func(i int) float64) []byte {
+    out := make([]byte, samples*f.bytesPerSample())
+    for i := 0; i < samples; i++ {
+        v := int16(math.Max(-1, math.Min(1, sample(i))) * math.MaxInt16)
+        for c := 0; c < f.Channels; c++ {
+            binary.LittleEndian.PutUint16(out[(i*f.Channels+c)*2:], uint16(v))
+        }
+    }
+    return out
+}
+
+// Tone is a sine wave at freq Hz. Amplitude is 0..1 of full scale; a 5ms
+// ramp at each end avoids clicks that would trip a VAD.
+func Tone(f Format, freq float64, d time.Duration, amplitude float64) []byte {
+    samples := f.Bytes(d) / f.bytesPerSample()
+    ramp := f.SampleRate / 200
+    return render(f, samples, func(i int) float64 {
+        gain := amplitude
+        if i < ramp {
+            gain *= float64(i) / float64(ramp)
+        } else if samples-i < ramp {
+            gain *= float64(samples-i) / float64(ramp)
+        }
+        return gain * math.Sin(2*math.Pi*freq*float64(i)/float64(f.SampleRate))
+    })
+}
+
+func Silence(f Format, d time.Duration) []byte {
+    return make([]byte, f.Bytes(d))
+}
+
+// Phrase approximates speech for text without a TTS engine: every word is a
+// run of voiced syllables (a pitch with two formant-like harmonics under a
+// syllable envelope) and words are separated by short pauses. It is not
+// intelligible, but energy, pitch and timing look like speech to a VAD and
+// are identical on every run. Sentence punctuation adds a longer pause.
+func Phrase(f Format, text string) []byte {
+    const (
+        syllable  = 180 * time.Millisecond
+        wordGap   = 70 * time.Millisecond
+        pauseGap  = 350 * time.Millisecond
+        pitch     = 140.0
+    )
+    
+    var parts [][]byte
+    for i, word := range strings.Fields(text) {
+        if i > 0 {
+            parts = append(parts, Silence(f, wordGap))
+        }
+        for s := 0; s < syllables(word); s++ {
+            // Vary pitch per syllable so consecutive syllables differ
+            hz := pitch * (1 + 0.08*float64((i+s)%3))
+            parts = append(parts, voiced(f, hz, syllable))
+        }
+        if strings.ContainsAny(word[len(word)-1:], ".,;:!?") {
+            parts = append(parts, Silence(f, pauseGap))
+        }
+    }
+    return Concat(parts...)
+}
+
+// syllables counts vowel groups, at least one per word.
+func syllables(word string) int {
+    count, inVowel := 0, false
+    for _, r := range strings.ToLower(word) {
+        vowel := strings.ContainsRune("aeiouy", r)
+        if vowel && !inVowel {
+            count++
+        }
+        inVowel = vowel
+    }
+    if count == 0 {
+        return 1
+    }
+    return count
+}
+
+func voiced(f Format, pitch float64, d time.Duration) []byte {
+    samples := f.Bytes(d) / f.bytesPerSample()
+    rate := float64(f.SampleRate)
+    return render(f, samples, func(i int) float64 {
+        t := float64(i) / rate
+        envelope := math.Sin(math.Pi * float64(i) / float64(samples))
+        v := 0.5*math.Sin(2*math.Pi*pitch*t) +
+            0.3*math.Sin(2*math.Pi*pitch*5*t) +
+            0.2*math.Sin(2*math.Pi*pitch*17*t)
+        return 0.6 * envelope * v
+    })
+}
+
+func Concat(clips ...[]byte) []byte {
+    var out []byte
+    for _, clip := range clips {
+        out = append(out, clip...)
+    }
+    return out
+}
+
+// Frames cuts a clip into frameMs frames, zero padding the last one the way
+// the server's framer expects full frames.
+func Frames(f Format, clip []byte, frameMs int) [][]byte {
+    size := f.Bytes(time.Duration(frameMs) * time.Millisecond)
+    if size == 0 {
+        return nil
+    }
+    
+    var frames [][]byte
+    for offset := 0; offset < len(clip); offset += size {
+        frame := make([]byte, size)
+        copy(frame, clip[offset:])
+        frames = append(frames, frame)
+    }
+    return frames
+}
+
+// Play hands frames to send one frame duration apart. Deadlines are computed
+// from the start time, so slow sends don't accumulate drift.
+func Play(ctx context.Context, frames [][]byte, frameMs int, send func([]byte) error) error {
+    interval := time.Duration(frameMs) * time.Millisecond
+    start := time.Now()
+    for i, frame := range frames {
+        if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+            timer := time.NewTimer(wait)
+            select {
+            case <-ctx.Done():
+                timer.Stop()
+                return ctx.Err()
+            case <-timer.C:
+            }
+        }
+        if err := send(frame); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,117 @@
+package audiogen
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math"
+    "os"
+)
+
+// LoadWAV reads a PCM16 WAV fixture and converts it to f, mixing down or
+// duplicating channels and resampling linearly when the file differs.
+func LoadWAV(path string, f Format) ([]byte, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return DecodeWAV(data, f)
+}
+
+func DecodeWAV(data []byte, f Format) ([]byte, error) {
+    pcm, src, err := ReadWAV(data)
+    if err != nil {
+        return nil, err
+    }
+    return Convert(pcm, src, f), nil
+}
+
+// ReadWAV returns a PCM16 WAV file's audio as it was recorded.
+func ReadWAV(data []byte) ([]byte, Format, error) {
+    if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+        return nil, Format{}, fmt.Errorf("audiogen: not a WAV file")
+    }
+    
+    var src Format
+    var pcm []byte
+    for offset := 12; offset+8 <= len(data); {
+        id := string(data[offset : offset+4])
+        size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+        body := data[offset+8:]
+        if size > len(body) {
+            size = len(body)
+        }
+        body = body[:size]
+        
+        switch id {
+        case "fmt ":
+            if size < 16 {
+                return nil, Format{}, fmt.Errorf("audiogen: short fmt chunk")
+            }
+            codec := binary.LittleEndian.Uint16(body[0:])
+            bits := binary.LittleEndian.Uint16(body[14:])
+            if (codec != 1 && codec != 0xFFFE) || bits != 16 {
+                return nil, Format{}, fmt.Errorf("audiogen: only 16-bit PCM WAV is supported")
+            }
+            src.Channels = int(binary.LittleEndian.Uint16(body[2:]))
+            src.SampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+        case "data":
+            pcm = body
+        }
+        offset += 8 + size + size%2 // Chunks are word aligned
+    }
+    if src.SampleRate == 0 || src.Channels == 0 {
+        return nil, Format{}, fmt.Errorf("audiogen: WAV has no fmt chunk")
+    }
+    if pcm == nil {
+        return nil, Format{}, fmt.Errorf("audiogen: WAV has no data chunk")
+    }
+    return pcm, src, nil
+}
+
+// EncodeWAV wraps pcm in a WAV header, handy for dumping what a test received.
+func EncodeWAV(pcm []byte, f Format) []byte {
+    header := make([]byte, 44)
+    copy(header[0:], "RIFF")
+    binary.LittleEndian.PutUint32(header[4:], uint32(36+len(pcm)))
+    copy(header[8:], "WAVEfmt ")
+    binary.LittleEndian.PutUint32(header[16:], 16)
+    binary.LittleEndian.PutUint16(header[20:], 1)
+    binary.LittleEndian.PutUint16(header[22:], uint16(f.Channels))
+    binary.LittleEndian.PutUint32(header[24:], uint32(f.SampleRate))
+    binary.LittleEndian.PutUint32(header[28:], uint32(f.SampleRate*f.bytesPerSample()))
+    binary.LittleEndian.PutUint16(header[32:], uint16(f.bytesPerSample()))
+    binary.LittleEndian.PutUint16(header[34:], 16)
+    copy(header[36:], "data")
+    binary.LittleEndian.PutUint32(header[40:], uint32(len(pcm)))
+    return append(header, pcm...)
+}
+
+// Convert changes the channel count and sample rate of pcm16 audio.
+func Convert(pcm []byte, from Format, to Format) []byte {
+    if from == to {
+        return pcm
+    }
+    
+    // Mix every frame down to mono first
+    frames := len(pcm) / from.bytesPerSample()
+    mono := make([]float64, frames)
+    for i := range mono {
+        sum := 0.0
+        for c := 0; c < from.Channels; c++ {
+            sum += float64(int16(binary.LittleEndian.Uint16(pcm[(i*from.Channels+c)*2:])))
+        }
+        mono[i] = sum / float64(from.Channels) / math.MaxInt16
+    }
+    
+    ratio := float64(from.SampleRate) / float64(to.SampleRate)
+    samples := int(float64(frames) / ratio)
+    return render(to, samples, func(i int) float64 {
+        pos := float64(i) * ratio
+        j := int(pos)
+        if j+1 >= len(mono) {
+            return mono[len(mono)-1]
+        }
+        frac := pos - float64(j)
+        return mono[j]*(1-frac) + mono[j+1]*frac
+    })
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+const maxAuditPage = 1000
+
+// audit records a security relevant decision in the durable audit log.
+func audit(room *RoomInfo, actor string, action string, subject string, outcome string, detail map[string]interface{}) {
+    entry := storage.AuditEntry{
+        Id:      newRandomId(),
+        At:      time.Now().UnixNano() / int64(time.Millisecond),
+        Actor:   actor,
+        Action:  action,
+        Subject: subject,
+        Outcome: outcome,
+    }
+    if room != nil {
+        entry.Tenant = room.Tenant
+        entry.RoomId = room.RoomId
+    }
+    if detail != nil {
+        entry.Detail, _ = json.Marshal(detail)
+    }
+    persist(entry.RoomId, func(ctx context.Context) error {
+        return store.Audit().Add(ctx, entry)
+    })
+}
+
+// GET /admin/audit?tenant=&roomId=&action=&subject=&from=&to=&limit= (admin)
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    query := r.URL.Query()
+    from, err := parseAnalyticsTime(query.Get("from"), time.Unix(0, 0))
+    if err != nil {
+        http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    var to int64
+    if query.Get("to") != "" {
+        if to, err = parseAnalyticsTime(query.Get("to"), time.Now()); err != nil {
+            http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    limit := 100
+    if value := query.Get("limit"); value != "" {
+        if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxAuditPage {
+            http.Error(w, "limit must be 1 to "+strconv.Itoa(maxAuditPage), http.StatusBadRequest)
+            return
+        }
+    }
+    
+    entries, err := store.Audit().List(r.Context(), storage.AuditFilter{
+        Tenant:  query.Get("tenant"),
+        RoomId:  query.Get("roomId"),
+        Action:  query.Get("action"),
+        Subject: query.Get("subject"),
+        Since:   from,
+        Until:   to,
+        Limit:   limit,
+    })
+    if err != nil {
+        log.Printf("Audit query failed: %v", err)
+        http.Error(w, "Audit log unavailable", http.StatusInternalServerError)
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "entries": entries,
+        "count":   len(entries),
+    })
+}
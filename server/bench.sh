@@ -0,0 +1,9 @@
+#!/bin/sh
+# Runs the fan-out and audio benchmarks with fixed settings so results from
+# different commits are comparable, e.g. with benchstat:
+#   ./bench.sh > old.txt; git checkout NEW; ./bench.sh > new.txt; benchstat old.txt new.txt
+set -e
+cd "$(dirname "$0")"
+COUNT=${COUNT:-6}
+BENCHTIME=${BENCHTIME:-1s}
+go test -run '^$' -bench "${BENCH:-.}" -benchmem -count "$COUNT" -benchtime "$BENCHTIME" -cpu 1,4 . | tee ../bench_output.txt
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+func TestMain(m *testing.M) {
+    log.SetOutput(io.Discard)
+    os.Exit(m.Run())
+}
+
+// newBenchClient builds a client whose send queue is drained without a
+// network connection, isolating routing cost from socket I/O.
+func newBenchClient(id string, clientType ClientType) *Client {
+    c := &Client{
+        clientId:      id,
+        clientType:    clientType,
+        role:          string(clientType),
+        metadata:      map[string]interface{}{"role": string(clientType)},
+        lastEphemeral: make(map[string]time.Time),
+        state:         ClientActive,
+        send:          newSendQueue(),
+    }
+    go func() {
+        for {
+            if _, ok := c.send.next(); !ok {
+                return
+            }
+        }
+    }()
+    return c
+}
+
+func benchRoom(b *testing.B, roomId string, users int, agents int) []*Client {
+    clients := make([]*Client, 0, users+agents)
+    for i := 0; i < users; i++ {
+        clients = append(clients, newBenchClient(fmt.Sprintf("user-%d", i), ClientTypeUser))
+    }
+    for i := 0; i < agents; i++ {
+        clients = append(clients, newBenchClient(fmt.Sprintf("agent-%d", i), ClientTypeAgent))
+    }
+    for _, c := range clients {
+        c.room = roomId
+        addClientToRoom(roomId, c, "")
+    }
+    
+    b.Cleanup(func() {
+        for _, c := range clients {
+            removeClientFromRoom(roomId, c)
+            c.stopWriter()
+        }
+    })
+    return clients
+}
+
+var benchRoomSizes = []int{2, 10, 50, 200}
+
+func BenchmarkBroadcastFanout(b *testing.B) {
+    for _, size := range benchRoomSizes {
+        b.Run(fmt.Sprintf("participants=%d", size), func(b *testing.B) {
+            roomId := fmt.Sprintf("bench-broadcast-%d", size)
+            clients := benchRoom(b, roomId, size-1, 1)
+            msg := &Message{
+                Type:      "broadcast",
+                From:      clients[0].clientId,
+                Data:      map[string]interface{}{"text": strings.Repeat("transcript ", 20)},
+                Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+            }
+            
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                broadcastToRoom(roomId, clients[0], msg)
+            }
+        })
+    }
+}
+
+func BenchmarkAudioForward(b *testing.B) {
+    frame := make([]byte, 640) // 20ms of 16kHz mono PCM16
+    
+    for _, size := range benchRoomSizes {
+        b.Run(fmt.Sprintf("agents=%d", size), func(b *testing.B) {
+            roomId := fmt.Sprintf("bench-audio-%d", size)
+            clients := benchRoom(b, roomId, 1, size)
+            
+            b.SetBytes(int64(len(frame) * size))
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                routeAudio(roomId, clients[0], frame, false)
+            }
+        })
+    }
+}
+
+func BenchmarkAudioFramer(b *testing.B) {
+    framer := &audioFramer{frameBytes: 640}
+    chunk := make([]byte, 8192) // One browser ScriptProcessor buffer
+    
+    b.SetBytes(int64(len(chunk)))
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        framer.push(chunk)
+    }
+}
+
+// Many rooms routing at once, measuring contention on roomsMu.
+func BenchmarkRoomLockContention(b *testing.B) {
+    const roomCount = 64
+    senders := make([]*Client, roomCount)
+    for i := range senders {
+        senders[i] = benchRoom(b, fmt.Sprintf("bench-contention-%d", i), 2, 1)[0]
+    }
+    msg := &Message{Type: "broadcast", Data: map[string]interface{}{"text": "hello"}}
+    
+    b.ReportAllocs()
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        i := 0
+        for pb.Next() {
+            sender := senders[i%roomCount]
+            broadcastToRoom(sender.room, sender, msg)
+            findClient(sender.room, "agent-0")
+            i++
+        }
+    })
+}
+
+// Full path through real WebSocket connections: user audio in, agent audio out.
+func BenchmarkWebSocketAudioRoundTrip(b *testing.B) {
+    saved := cfg
+    cfg.ConnRatePerIP = 0
+    cfg.MaxConnsPerIP = 0
+    cfg.AudioByteRate = 0
+    cfg.AdminToken = "bench-admin-token" // The agent joins with it
+    b.Cleanup(func() { cfg = saved })
+    
+    server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+    defer server.Close()
+    wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+    
+    dial := func(query string) *websocket.Conn {
+        conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?"+query, http.Header{"Authorization": {"Bearer " + cfg.AdminToken}})
+        if err != nil {
+            b.Fatal(err)
+        }
+        return conn
+    }
+    
+    agent := dial("room=bench-e2e&clientId=agent&type=agent")
+    defer agent.Close()
+    agent.ReadMessage() // welcome
+    
+    user := dial("room=bench-e2e&clientId=user&type=user")
+    defer user.Close()
+    user.ReadMessage() // welcome
+    agent.ReadMessage() // client_joined
+    
+    frame := make([]byte, 640)
+    
+    b.SetBytes(int64(len(frame)))
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if err := user.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+            b.Fatal(err)
+        }
+        if _, _, err := agent.ReadMessage(); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
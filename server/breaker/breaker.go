@@ -0,0 +1,137 @@
+// Package breaker is a consecutive-failure circuit breaker for calls to
+// upstream integrations. After Threshold failures in a row the circuit opens
+// and calls are refused for Cooldown; then a single probe is let through,
+// closing the circuit on success and reopening it on failure.
+package breaker
+
+import (
+    "sync"
+    "time"
+)
+
+type State string
+
+const (
+    Closed   State = "closed"
+    Open     State = "open"
+    HalfOpen State = "half_open"
+)
+
+// Status is a snapshot of a breaker for admin views.
+type Status struct {
+    Name      string `json:"name"`
+    State     State  `json:"state"`
+    Failures  int    `json:"failures"`            // Consecutive, reset by a success
+    OpenedAt  int64  `json:"openedAt,omitempty"`  // Unix ms
+    LastError string `json:"lastError,omitempty"`
+}
+
+type Breaker struct {
+    Name      string
+    Threshold int           // Consecutive failures that open the circuit, at least 1
+    Cooldown  time.Duration // Time open before a probe is allowed
+    
+    // OnChange is called without the lock held on every transition.
+    OnChange func(b *Breaker, from State, to State)
+    
+    mu        sync.Mutex
+    state     State
+    failures  int
+    openedAt  time.Time
+    probing   bool
+    probeAt   time.Time
+    lastError string
+}
+
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+    if threshold < 1 {
+        threshold = 1
+    }
+    return &Breaker{Name: name, Threshold: threshold, Cooldown: cooldown, state: Closed}
+}
+
+// Allow reports whether a call may go ahead. Callers that are allowed must
+// report the outcome with Success or Failure.
+func (b *Breaker) Allow() bool {
+    b.mu.Lock()
+    switch b.state {
+    case Closed:
+        b.mu.Unlock()
+        return true
+    case Open:
+        if time.Since(b.openedAt) < b.Cooldown {
+            b.mu.Unlock()
+            return false
+        }
+        b.state, b.probing, b.probeAt = HalfOpen, true, time.Now()
+        b.mu.Unlock()
+        b.change(Open, HalfOpen)
+        return true
+    default:
+        // One probe at a time while half open, unless the last one was
+        // abandoned without an outcome
+        if b.probing && time.Since(b.probeAt) < b.Cooldown {
+            b.mu.Unlock()
+            return false
+        }
+        b.probing, b.probeAt = true, time.Now()
+        b.mu.Unlock()
+        return true
+    }
+}
+
+func (b *Breaker) Success() {
+    b.mu.Lock()
+    from := b.state
+    b.state, b.failures, b.probing, b.lastError = Closed, 0, false, ""
+    b.mu.Unlock()
+    if from != Closed {
+        b.change(from, Closed)
+    }
+}
+
+func (b *Breaker) Failure(err error) {
+    b.mu.Lock()
+    from := b.state
+    b.failures++
+    b.probing = false
+    if err != nil {
+        b.lastError = err.Error()
+    }
+    if from == HalfOpen || (from == Closed && b.failures >= b.Threshold) {
+        b.state = Open
+        b.openedAt = time.Now()
+    }
+    to := b.state
+    b.mu.Unlock()
+    if from != to {
+        b.change(from, to)
+    }
+}
+
+// Reset closes the circuit, e.g. after an operator fixed the integration.
+func (b *Breaker) Reset() {
+    b.Success()
+}
+
+func (b *Breaker) State() State {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.state
+}
+
+func (b *Breaker) Status() Status {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    status := Status{Name: b.Name, State: b.state, Failures: b.failures, LastError: b.lastError}
+    if b.state != Closed {
+        status.OpenedAt = b.openedAt.UnixNano() / int64(time.Millisecond)
+    }
+    return status
+}
+
+func (b *Breaker) change(from State, to State) {
+    if b.OnChange != nil {
+        b.OnChange(b, from, to)
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+)
+
+type BroadcastRequest struct {
+    Text     string      `json:"text"`
+    Data     interface{} `json:"data,omitempty"`
+    Tenant   string      `json:"tenant,omitempty"`
+    Template string      `json:"template,omitempty"`
+    Rooms    []string    `json:"rooms,omitempty"`    // Empty means every room matching tenant/template
+    Audience string      `json:"audience,omitempty"` // all (default), users or agents
+    TTS      bool        `json:"tts,omitempty"`      // Ask agents in the room to speak the text
+    Audio    []byte      `json:"audio,omitempty"`    // Base64 encoded, forwarded to users as a binary frame
+}
+
+func (b *BroadcastRequest) matches(room *RoomInfo) bool {
+    if b.Tenant != "" && room.Tenant != b.Tenant {
+        return false
+    }
+    if b.Template != "" && room.Template != b.Template {
+        return false
+    }
+    if len(b.Rooms) == 0 {
+        return true
+    }
+    for _, id := range b.Rooms {
+        if id == room.RoomId {
+            return true
+        }
+    }
+    return false
+}
+
+func handleBroadcast(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    var req BroadcastRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        return
+    }
+    
+    if req.Text == "" && len(req.Audio) == 0 {
+        http.Error(w, "text or audio required", http.StatusBadRequest)
+        return
+    }
+    
+    switch req.Audience {
+    case "":
+        req.Audience = "all"
+    case "all", "users", "agents":
+    default:
+        http.Error(w, "audience must be all, users or agents", http.StatusBadRequest)
+        return
+    }
+    
+    roomsMu.RLock()
+    targets := make([]string, 0, len(rooms))
+    for roomId, room := range rooms {
+        if req.matches(room) {
+            targets = append(targets, roomId)
+        }
+    }
+    roomsMu.RUnlock()
+    
+    msg := &Message{
+        Type: "announcement",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "text":     req.Text,
+            "data":     req.Data,
+            "tts":      req.TTS,
+            "audience": req.Audience,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    
+    for _, roomId := range targets {
+        switch req.Audience {
+        case "users":
+            sendToUsers(roomId, nil, msg)
+        case "agents":
+            sendToAgents(roomId, nil, msg)
+        default:
+            broadcastToRoom(roomId, nil, msg)
+        }
+        
+        // Agents do the speaking, so they need the text even for user-only announcements
+        if req.TTS && req.Audience == "users" {
+            sendToAgents(roomId, nil, msg)
+        }
+        
+        if len(req.Audio) > 0 && req.Audience != "agents" {
+            forwardAudioToUsers(roomId, SystemSender, req.Audio, false)
+        }
+    }
+    
+    log.Printf("Announcement sent to %d rooms", len(targets))
+    
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "rooms": targets,
+        "count": len(targets),
+    })
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Bulk room operations, for incident response across many calls at once:
+//
+//   POST /admin/rooms/close     end the matching calls
+//   POST /admin/rooms/move      send the matching calls' participants to
+//                               reconnect elsewhere, to empty a server
+//                               being drained
+//   POST /admin/rooms/refresh   re-pin the matching rooms to the current
+//                               knowledge graph snapshot and push their
+//                               config again as room_config
+//   POST /admin/rooms/migrate   hand the matching calls, live, to the server
+//                               at "target" (see migration.go)
+//
+// all with a body of
+//
+//   {"tenant", "template", "rooms": [ID...], "createdBefore": MS, "all": BOOL,
+//    "reason": TEXT, "dryRun": BOOL, "target": URL}
+//
+// A room matches every filter given. A body with none matches nothing unless
+// it sets "all", so a mistyped field can't end every call on the server.
+// Closing sends close code room_closed, which the SDKs take as final, and
+// revokes the participants' resume tokens, so held sessions leave at once
+// too. Moving sends room_moved: the SDKs reconnect, through
+// /allocate when they use it, and join the room afresh wherever they land.
+// Take the server out of the registry first or they may land back on it.
+// Each answer is a report of what was done, or with "dryRun" what would be:
+//
+//   {"operation", "dryRun", "matched",
+//    "rooms": [{"roomId", "tenant", "template", "participants", "outcome", "error"}]}
+//
+// and every room acted on gets a rooms_close, rooms_move, rooms_refresh or
+// rooms_migrate entry in the audit log.
+
+type BulkRoomRequest struct {
+    Tenant        string   `json:"tenant,omitempty"`
+    Template      string   `json:"template,omitempty"`
+    Rooms         []string `json:"rooms,omitempty"`
+    CreatedBefore int64    `json:"createdBefore,omitempty"` // Unix ms
+    All           bool     `json:"all,omitempty"`           // Match every room when no other filter is given
+    Reason        string   `json:"reason,omitempty"`
+    DryRun        bool     `json:"dryRun,omitempty"`
+    Target        string   `json:"target,omitempty"` // The base URL of the server to migrate to
+}
+
+type BulkRoomResult struct {
+    RoomId       string `json:"roomId"`
+    Tenant       string `json:"tenant,omitempty"`
+    Template     string `json:"template,omitempty"`
+    Participants int    `json:"participants"`
+    Outcome      string `json:"outcome"` // closed, moved, refreshed, migrated, failed, or matched on a dry run
+    Error        string `json:"error,omitempty"`
+}
+
+type BulkRoomReport struct {
+    Operation string           `json:"operation"`
+    DryRun    bool             `json:"dryRun"`
+    Matched   int              `json:"matched"`
+    Rooms     []BulkRoomResult `json:"rooms"`
+}
+
+func (b *BulkRoomRequest) filtered() bool {
+    return b.Tenant != "" || b.Template != "" || len(b.Rooms) > 0 || b.CreatedBefore > 0
+}
+
+func (b *BulkRoomRequest) matches(room *RoomInfo) bool {
+    if !b.filtered() && !b.All {
+        return false
+    }
+    if b.CreatedBefore > 0 && room.CreatedAt >= b.CreatedBefore {
+        return false
+    }
+    // The tenant, template and room list filters are the broadcast's
+    broadcast := BroadcastRequest{Tenant: b.Tenant, Template: b.Template, Rooms: b.Rooms}
+    return broadcast.matches(room)
+}
+
+var bulkRoomOperations = newCounterVec("iva_bulk_room_operations_total", "Rooms acted on by the bulk room admin API, by operation.", "operation")
+
+func init() {
+    metricSeries = append(metricSeries, bulkRoomOperations)
+}
+
+// bulkTarget is a matched room and who was in it at the time.
+type bulkTarget struct {
+    room    *RoomInfo
+    clients []*Client
+    held    []*Client
+}
+
+// POST /admin/rooms/{close,move,refresh,migrate,import} (admin)
+func handleBulkRooms(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    operation := strings.TrimPrefix(r.URL.Path, "/admin/rooms/")
+    switch operation {
+    case "close", "move", "refresh", "migrate":
+    case "import":
+        handleRoomImport(w, r)
+        return
+    default:
+        http.NotFound(w, r)
+        return
+    }
+    
+    var req BulkRoomRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        return
+    }
+    req.Target = strings.TrimSuffix(req.Target, "/")
+    if operation == "migrate" && !strings.HasPrefix(req.Target, "http://") && !strings.HasPrefix(req.Target, "https://") {
+        http.Error(w, "target must be the http(s) URL of the server to migrate to", http.StatusBadRequest)
+        return
+    }
+    
+    targets := bulkTargets(&req)
+    report := BulkRoomReport{Operation: operation, DryRun: req.DryRun, Matched: len(targets), Rooms: []BulkRoomResult{}}
+    for _, target := range targets {
+        result := BulkRoomResult{
+            RoomId:       target.room.RoomId,
+            Tenant:       target.room.Tenant,
+            Template:     target.room.Template,
+            Participants: len(target.clients) + len(target.held),
+            Outcome:      "matched",
+        }
+        if !req.DryRun {
+            switch operation {
+            case "close":
+                closeBulkTarget(target, CloseRoomClosed, req.Reason)
+                result.Outcome = "closed"
+            case "move":
+                closeBulkTarget(target, CloseRoomMoved, req.Reason)
+                result.Outcome = "moved"
+            case "refresh":
+                refreshRoomConfig(target.room.RoomId, req.Reason)
+                result.Outcome = "refreshed"
+            case "migrate":
+                result.Outcome = "migrated"
+                if err := migrateRoom(target.room.RoomId, req.Target, req.Reason); err != nil {
+                    result.Outcome, result.Error = "failed", err.Error()
+                    logAt("warn", target.room.RoomId, "", "Room migration to %s failed: %v", req.Target, err)
+                }
+            }
+            bulkRoomOperations.inc(target.room.labels, operation)
+            detail := map[string]interface{}{
+                "reason":       req.Reason,
+                "participants": result.Participants,
+            }
+            if operation == "migrate" {
+                detail["target"] = req.Target
+            }
+            audit(target.room, "admin", "rooms_"+operation, target.room.RoomId, result.Outcome, detail)
+        }
+        report.Rooms = append(report.Rooms, result)
+    }
+    
+    if !req.DryRun {
+        log.Printf("Bulk %s of %d rooms: %s", operation, len(targets), req.Reason)
+    }
+    
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
+
+// bulkTargets snapshots the rooms req matches.
+func bulkTargets(req *BulkRoomRequest) []bulkTarget {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    var targets []bulkTarget
+    for _, room := range rooms {
+        if !req.matches(room) {
+            continue
+        }
+        target := bulkTarget{room: room}
+        for _, client := range room.Users {
+            target.clients = append(target.clients, client)
+        }
+        for _, client := range room.Agents {
+            target.clients = append(target.clients, client)
+        }
+        for _, held := range room.held {
+            target.held = append(target.held, held.client)
+        }
+        targets = append(targets, target)
+    }
+    return targets
+}
+
+// closeBulkTarget disconnects everyone in a room, with their resume tokens
+// revoked so nobody is held. Each read loop runs the usual leave cleanup,
+// the room closing with the last of them.
+func closeBulkTarget(target bulkTarget, code CloseCode, reason string) {
+    for _, client := range target.clients {
+        client.mu.Lock()
+        client.resumeToken = ""
+        client.mu.Unlock()
+        closeConnection(client.conn, code, reason)
+    }
+    for _, client := range target.held {
+        expireSession(target.room.RoomId, client)
+    }
+}
+
+// refreshRoomConfig re-pins a live room to the current knowledge graph
+// snapshot and sends everyone in it the config as welcome would have it now.
+func refreshRoomConfig(roomId string, reason string) {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return
+    }
+    previous := room.KGSnapshot
+    room.KGSnapshot = currentKGSnapshot()
+    config, voice := roomConfig(room), ttsVoiceFor(room)
+    roomsMu.Unlock()
+    
+    if previous != config["kgSnapshot"] {
+        logAt("info", roomId, "", "Room re-pinned from snapshot %s to %s", previous, config["kgSnapshot"])
+    }
+    broadcastToRoom(roomId, nil, &Message{
+        Type: "room_config",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "room":     config,
+            "ttsVoice": voice,
+            "reason":   reason,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
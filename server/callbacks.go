@@ -0,0 +1,364 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Callback queue. A caller gets a callback request when they hang up after
+// waiting -callback-abandon-after for an agent, when an outage's callback
+// fallback ends their call, or when an agent promises one:
+//
+//   {"type": "callback_request", "data": {"userId": ID, "phone": "+15550100", "note": "...", "notBefore": UNIX_MS}}
+//
+// The request holds who to call (customer and phone from verification or
+// metadata), why, and a snapshot of the call. Agents and the outbound dialer
+// work through the queue over /admin/callbacks: claim the next due request,
+// call, report the result. A claim is a lease, a request nobody reports on
+// within -callback-lease is handed out again. Failed calls are retried after
+// -callback-retry-delay until -callback-max-attempts.
+
+const maxCallbackPage = 1000
+
+var callbacksTotal = newCounterVec("iva_callbacks_total", "Callback requests captured by reason.", "reason")
+
+func init() {
+    metricSeries = append(metricSeries, callbacksTotal)
+}
+
+// noteQueueJoin starts or stops a room's wait for an agent as a client
+// joins, routing the room to an agent worker when the wait starts. Callers
+// hold roomsMu.
+func noteQueueJoin(room *RoomInfo, client *Client) {
+    if client.clientType == ClientTypeAgent {
+        room.waitingSince = 0
+        go noteAgentJoin(room.RoomId)
+    } else if len(room.Agents) == 0 && room.waitingSince == 0 && !isOutboundCallee(client) {
+        room.waitingSince = time.Now().UnixNano() / int64(time.Millisecond)
+        go routeWaiting()
+    }
+}
+
+// noteQueueLeave restarts the wait when the last agent leaves, hands the
+// agent's freed capacity to waiting rooms and captures a callback for a
+// caller who gave up waiting. Callers hold roomsMu.
+func noteQueueLeave(room *RoomInfo, client *Client) {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    if client.clientType == ClientTypeAgent {
+        if len(room.Agents) == 0 && len(room.Users) > 0 {
+            room.waitingSince = now
+        }
+        go noteAgentLeave(client)
+        return
+    }
+    waited := time.Duration(now-room.waitingSince) * time.Millisecond
+    if room.waitingSince != 0 && cfg.CallbackAbandonAfter > 0 && waited >= cfg.CallbackAbandonAfter && !isOutboundCallee(client) {
+        go captureCallback(room, client, "abandoned", fmt.Sprintf("waited %s for an agent", waited.Round(time.Second)), "", 0)
+    }
+    if len(room.Users) == 0 {
+        room.waitingSince = 0
+    }
+}
+
+// captureCallback queues a callback for a caller, once per caller and call.
+func captureCallback(room *RoomInfo, user *Client, reason string, detail string, phone string, notBefore int64) (storage.Callback, bool) {
+    roomsMu.Lock()
+    if _, queued := room.callbacks[user.clientId]; queued {
+        roomsMu.Unlock()
+        return storage.Callback{}, false
+    }
+    if room.callbacks == nil {
+        room.callbacks = make(map[string]string)
+    }
+    id := newRandomId()
+    room.callbacks[user.clientId] = id
+    roomsMu.Unlock()
+    
+    metadata := snapshotMetadata(user)
+    if phone == "" {
+        phone, _ = metadata["phone"].(string)
+    }
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    callback := storage.Callback{
+        Id:         id,
+        Tenant:     room.Tenant,
+        RoomId:     room.RoomId,
+        UserId:     user.clientId,
+        CustomerId: customerOf(room, user),
+        Phone:      phone,
+        Reason:     reason,
+        Detail:     detail,
+        Context:    callbackContext(room, metadata),
+        Status:     storage.CallbackPending,
+        NotBefore:  notBefore,
+        CreatedAt:  now,
+        UpdatedAt:  now,
+    }
+    persist(room.RoomId, func(ctx context.Context) error {
+        return store.Callbacks().Add(ctx, callback)
+    })
+    
+    callbacksTotal.inc(room.labels, reason)
+    logAt("info", room.RoomId, user.clientId, "Callback %s queued (%s)", id, reason)
+    emitEvent("callback_requested", room, map[string]interface{}{
+        "callbackId": id,
+        "userId":     user.clientId,
+        "customerId": callback.CustomerId,
+        "phone":      phone,
+        "reason":     reason,
+        "detail":     detail,
+    })
+    return callback, true
+}
+
+// callbackContext is what the agent returning the call should know: the
+// caller's metadata, what failed and the end of the conversation.
+func callbackContext(room *RoomInfo, metadata map[string]interface{}) json.RawMessage {
+    snapshot := map[string]interface{}{
+        "metadata": metadata,
+        "template": room.Template,
+    }
+    if fallbacks := roomFallbacks(room); fallbacks != nil {
+        snapshot["fallbacks"] = fallbacks
+    }
+    if cfg.CallbackHistory > 0 {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        entries, err := store.Transcripts().List(ctx, room.RoomId, "", cfg.CallbackHistory)
+        cancel()
+        if err != nil {
+            logAt("warn", room.RoomId, "", "Callback context without history: %v", err)
+        }
+        // Newest first from the store, oldest first for a reader
+        for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+            entries[i], entries[j] = entries[j], entries[i]
+        }
+        snapshot["recent"] = entries
+    }
+    data, _ := json.Marshal(snapshot)
+    return data
+}
+
+// callback_request: {"userId": ID, "phone": NUMBER, "note": TEXT, "notBefore": UNIX_MS}
+func handleCallbackRequest(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    room, user, err := verificationTarget(roomId, data)
+    if err == nil && sender.clientType == ClientTypeUser && user != sender {
+        err = newCodedError(ErrNotPermitted, "callers can only ask for their own callback")
+    }
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    phone, _ := data["phone"].(string)
+    note, _ := data["note"].(string)
+    notBefore, _ := data["notBefore"].(float64)
+    
+    go func() {
+        callback, queued := captureCallback(room, user, "requested", note, phone, int64(notBefore))
+        if !queued {
+            sendError(sender, ErrInvalidMessage, msg, "a callback is already queued for %s", user.clientId)
+            return
+        }
+        sendMessageToClient(sender, &Message{
+            Id:   newMessageId(),
+            Type: "callback_queued",
+            From: SystemSender,
+            Data: map[string]interface{}{
+                "requestId":  msg.Id,
+                "callbackId": callback.Id,
+                "userId":     user.clientId,
+                "phone":      callback.Phone,
+            },
+            Timestamp: callback.CreatedAt,
+        })
+    }()
+}
+
+// /admin/callbacks (admin):
+//
+//   GET  /admin/callbacks[?tenant=&status=&customerId=&limit=]  the queue, oldest first
+//   POST /admin/callbacks                                       queue one from elsewhere
+//   POST /admin/callbacks/claim?worker=NAME[&tenant=]           lease the next due request, 204 when none
+//   GET  /admin/callbacks/ID
+//   POST /admin/callbacks/ID/result                             {"status": "completed"|"failed"|"cancelled", "outcome": TEXT, "retry": BOOL}
+func handleCallbacks(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/callbacks"), "/")
+    id, action, _ := strings.Cut(path, "/")
+    
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        listCallbacks(w, r)
+    case id == "" && r.Method == http.MethodPost:
+        addCallback(w, r)
+    case id == "claim" && action == "" && r.Method == http.MethodPost:
+        claimCallback(w, r)
+    case id != "" && action == "" && r.Method == http.MethodGet:
+        callback, err := store.Callbacks().Get(r.Context(), id)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Callback not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(callback)
+    case id != "" && action == "result" && r.Method == http.MethodPost:
+        callbackResult(w, r, id)
+    case id == "" || action == "" || action == "result":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func listCallbacks(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    limit := 100
+    if value := query.Get("limit"); value != "" {
+        var err error
+        if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxCallbackPage {
+            http.Error(w, "limit must be 1 to "+strconv.Itoa(maxCallbackPage), http.StatusBadRequest)
+            return
+        }
+    }
+    callbacks, err := store.Callbacks().List(r.Context(), storage.CallbackFilter{
+        Tenant:     query.Get("tenant"),
+        Status:     query.Get("status"),
+        CustomerId: query.Get("customerId"),
+        Limit:      limit,
+    })
+    if err != nil {
+        log.Printf("Callback query failed: %v", err)
+        http.Error(w, "Callback queue unavailable", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "callbacks": callbacks,
+        "count":     len(callbacks),
+    })
+}
+
+func addCallback(w http.ResponseWriter, r *http.Request) {
+    var callback storage.Callback
+    if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    if callback.Phone == "" && callback.CustomerId == "" {
+        http.Error(w, "phone or customerId required", http.StatusBadRequest)
+        return
+    }
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    callback.Id = newRandomId()
+    if callback.Reason == "" {
+        callback.Reason = "requested"
+    }
+    callback.Status, callback.Attempts, callback.ClaimedBy, callback.ClaimedUntil, callback.Outcome = storage.CallbackPending, 0, "", 0, ""
+    callback.CreatedAt, callback.UpdatedAt = now, now
+    if err := store.Callbacks().Add(r.Context(), callback); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    
+    callbacksTotal.inc(metricLabelsFor(callback.Tenant, ""), callback.Reason)
+    emitEvent("callback_requested", &RoomInfo{RoomId: callback.RoomId, Tenant: callback.Tenant}, map[string]interface{}{
+        "callbackId": callback.Id,
+        "customerId": callback.CustomerId,
+        "phone":      callback.Phone,
+        "reason":     callback.Reason,
+        "detail":     callback.Detail,
+    })
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(callback)
+}
+
+func claimCallback(w http.ResponseWriter, r *http.Request) {
+    worker := r.URL.Query().Get("worker")
+    if worker == "" {
+        http.Error(w, "worker required", http.StatusBadRequest)
+        return
+    }
+    now := time.Now()
+    callback, err := store.Callbacks().Claim(r.Context(), r.URL.Query().Get("tenant"), worker,
+        now.UnixNano()/int64(time.Millisecond), now.Add(cfg.CallbackLease).UnixNano()/int64(time.Millisecond))
+    if err == storage.ErrNotFound {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    if err != nil {
+        log.Printf("Claiming a callback failed: %v", err)
+        http.Error(w, "Callback queue unavailable", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(callback)
+}
+
+func callbackResult(w http.ResponseWriter, r *http.Request, id string) {
+    var result struct {
+        Status  string `json:"status"`
+        Outcome string `json:"outcome"`
+        Retry   *bool  `json:"retry"` // Failed calls are retried unless false
+    }
+    if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    switch result.Status {
+    case storage.CallbackCompleted, storage.CallbackFailed, storage.CallbackCancelled:
+    default:
+        http.Error(w, "status must be completed, failed or cancelled", http.StatusBadRequest)
+        return
+    }
+    
+    callback, err := store.Callbacks().Get(r.Context(), id)
+    if err == storage.ErrNotFound {
+        http.Error(w, "Callback not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    switch callback.Status {
+    case storage.CallbackCompleted, storage.CallbackFailed, storage.CallbackCancelled:
+        http.Error(w, "Callback already "+callback.Status, http.StatusConflict)
+        return
+    }
+    
+    settleCallback(&callback, result.Status, result.Outcome, result.Retry == nil || *result.Retry, time.Now())
+    if err := store.Callbacks().Update(r.Context(), callback); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(callback)
+}
+
+// settleCallback records the result of a call attempt. A failed callback
+// goes back in the queue after -callback-retry-delay while it has attempts
+// left, unless retry is false.
+func settleCallback(callback *storage.Callback, status string, outcome string, retry bool, now time.Time) {
+    callback.Status, callback.Outcome = status, outcome
+    callback.ClaimedUntil = 0
+    callback.UpdatedAt = now.UnixNano() / int64(time.Millisecond)
+    if status == storage.CallbackFailed && retry && callback.Attempts < cfg.CallbackMaxAttempts {
+        callback.Status = storage.CallbackPending
+        callback.NotBefore = now.Add(cfg.CallbackRetryDelay).UnixNano() / int64(time.Millisecond)
+    }
+}
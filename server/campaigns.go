@@ -0,0 +1,572 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Outbound campaigns. A campaign works through a list of targets at
+// callsPerMinute, never with more than maxConcurrent calls up, placing each
+// call through the outbound call API (outbound.go) with the campaign's
+// template as the virtual agent's flow. Every attempt's outcome is recorded
+// on its target; busy, unanswered and failed calls are retried after
+// retryDelaySeconds until maxAttempts. A campaign with callbacks set also
+// claims due requests from the tenant's callback queue once its own list is
+// exhausted, and keeps running to serve it.
+
+const (
+    defaultCallsPerMinute    = 10
+    defaultMaxConcurrent     = 5
+    defaultMaxAttempts       = 3
+    defaultRetryDelaySeconds = 600
+    maxTargetPage            = 1000
+)
+
+var (
+    campaignRunners = make(map[string]chan struct{}) // Running campaign ID to the channel that stops it
+    campaignsMu     sync.Mutex
+)
+
+// resumeCampaigns restarts the campaigns that were running when the server
+// stopped. Calls they had up are gone, so those targets are dialled again.
+func resumeCampaigns() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    campaigns, err := store.Campaigns().List(ctx, "")
+    if err != nil {
+        log.Printf("Resuming campaigns failed: %v", err)
+        return
+    }
+    for _, campaign := range campaigns {
+        if campaign.Status != storage.CampaignRunning {
+            continue
+        }
+        for _, status := range []string{storage.TargetDialing, storage.TargetConnected} {
+            targets, err := store.Campaigns().Targets(ctx, campaign.Id, status, 0)
+            if err != nil {
+                log.Printf("Resuming campaign %s failed: %v", campaign.Id, err)
+                continue
+            }
+            for _, target := range targets {
+                target.Status, target.Detail = storage.TargetPending, "interrupted by a restart"
+                target.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+                if err := store.Campaigns().UpdateTarget(ctx, target); err != nil {
+                    log.Printf("Resuming campaign %s target %s failed: %v", campaign.Id, target.Id, err)
+                }
+            }
+        }
+        startCampaignRunner(campaign)
+    }
+}
+
+func startCampaignRunner(campaign storage.Campaign) {
+    campaignsMu.Lock()
+    defer campaignsMu.Unlock()
+    if campaignRunners[campaign.Id] != nil {
+        return
+    }
+    stop := make(chan struct{})
+    campaignRunners[campaign.Id] = stop
+    go runCampaign(campaign, stop)
+}
+
+func stopCampaignRunner(id string) {
+    campaignsMu.Lock()
+    defer campaignsMu.Unlock()
+    if stop := campaignRunners[id]; stop != nil {
+        close(stop)
+        delete(campaignRunners, id)
+    }
+}
+
+func runCampaign(campaign storage.Campaign, stop chan struct{}) {
+    log.Printf("Campaign %s (%s) running at %g calls a minute", campaign.Id, campaign.Name, campaign.CallsPerMinute)
+    ticker := time.NewTicker(time.Duration(float64(time.Minute) / campaign.CallsPerMinute))
+    defer ticker.Stop()
+    for {
+        if dialNext(campaign) {
+            completeCampaign(campaign, stop)
+            return
+        }
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// dialNext places the campaign's next call if it has capacity, and reports
+// whether the campaign has nothing left to do.
+func dialNext(campaign storage.Campaign) bool {
+    if len(liveCalls(campaign.Id)) >= campaign.MaxConcurrent {
+        return false
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    target, err := store.Campaigns().NextTarget(ctx, campaign.Id, now)
+    if err == storage.ErrNotFound && campaign.Callbacks {
+        target, err = targetFromCallback(ctx, campaign)
+        if err == storage.ErrNotFound {
+            return false
+        }
+    }
+    if err == storage.ErrNotFound {
+        tally, err := store.Campaigns().Tally(ctx, campaign.Id)
+        if err != nil {
+            log.Printf("Campaign %s tally failed: %v", campaign.Id, err)
+            return false
+        }
+        left := tally[storage.TargetPending] + tally[storage.TargetDialing] + tally[storage.TargetConnected]
+        return left == 0 && len(liveCalls(campaign.Id)) == 0
+    }
+    if err != nil {
+        log.Printf("Campaign %s could not pick a target: %v", campaign.Id, err)
+        return false
+    }
+    dialTarget(campaign, target)
+    return false
+}
+
+// targetFromCallback turns the tenant's next due callback request into a
+// target of the campaign.
+func targetFromCallback(ctx context.Context, campaign storage.Campaign) (storage.CampaignTarget, error) {
+    now := time.Now()
+    callback, err := store.Callbacks().Claim(ctx, campaign.Tenant, "campaign:"+campaign.Id,
+        now.UnixNano()/int64(time.Millisecond), now.Add(cfg.CallbackLease).UnixNano()/int64(time.Millisecond))
+    if err != nil {
+        return storage.CampaignTarget{}, err
+    }
+    if callback.Phone == "" {
+        settleCallback(&callback, storage.CallbackFailed, "no phone number to dial", false, now)
+        if err := store.Callbacks().Update(ctx, callback); err != nil {
+            log.Printf("Callback %s update failed: %v", callback.Id, err)
+        }
+        return storage.CampaignTarget{}, storage.ErrNotFound
+    }
+    metadata, _ := json.Marshal(map[string]interface{}{
+        "callbackId":     callback.Id,
+        "callbackReason": callback.Reason,
+        "callbackDetail": callback.Detail,
+    })
+    target := storage.CampaignTarget{
+        Id:         newRandomId(),
+        CampaignId: campaign.Id,
+        Phone:      callback.Phone,
+        CustomerId: callback.CustomerId,
+        Metadata:   metadata,
+        CallbackId: callback.Id,
+        Status:     storage.TargetDialing,
+        Attempts:   1,
+        CreatedAt:  now.UnixNano() / int64(time.Millisecond),
+        UpdatedAt:  now.UnixNano() / int64(time.Millisecond),
+    }
+    if err := store.Campaigns().AddTargets(ctx, []storage.CampaignTarget{target}); err != nil {
+        return storage.CampaignTarget{}, err
+    }
+    return target, nil
+}
+
+func dialTarget(campaign storage.Campaign, target storage.CampaignTarget) {
+    var metadata map[string]interface{}
+    if len(target.Metadata) > 0 {
+        json.Unmarshal(target.Metadata, &metadata)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+    defer cancel()
+    call, err := placeCall(ctx, outboundRequest{
+        To:            target.Phone,
+        From:          campaign.From,
+        Tenant:        campaign.Tenant,
+        Template:      campaign.Template,
+        CustomerId:    target.CustomerId,
+        Metadata:      metadata,
+        Greeting:      campaign.Greeting,
+        DetectMachine: true,
+        OnMachine:     campaign.OnMachine,
+        Message:       campaign.Message,
+    }, campaign.Id, target.Id, func(call outboundCall, outcome string, detail string) {
+        finishTarget(campaign, target, call, outcome, detail)
+    })
+    if err != nil {
+        finishTarget(campaign, target, outboundCall{}, OutcomeFailed, err.Error())
+        return
+    }
+    target.RoomId = call.RoomId
+    target.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    if err := store.Campaigns().UpdateTarget(ctx, target); err != nil {
+        log.Printf("Campaign %s target %s update failed: %v", campaign.Id, target.Id, err)
+    }
+}
+
+// campaignCallAnswered marks a campaign call's target connected.
+func campaignCallAnswered(call outboundCall) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    targets, err := store.Campaigns().Targets(ctx, call.CampaignId, storage.TargetDialing, 0)
+    if err != nil {
+        log.Printf("Campaign %s target lookup failed: %v", call.CampaignId, err)
+        return
+    }
+    for _, target := range targets {
+        if target.Id != call.TargetId {
+            continue
+        }
+        target.Status, target.RoomId = storage.TargetConnected, call.RoomId
+        target.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+        if err := store.Campaigns().UpdateTarget(ctx, target); err != nil {
+            log.Printf("Campaign %s target %s update failed: %v", call.CampaignId, target.Id, err)
+        }
+        return
+    }
+}
+
+// finishTarget records an attempt's outcome, scheduling a retry when the
+// callee could not be reached. Targets from the callback queue are retried
+// by the queue instead.
+func finishTarget(campaign storage.Campaign, target storage.CampaignTarget, call outboundCall, outcome string, detail string) {
+    now := time.Now()
+    target.Status, target.Outcome, target.Detail = storage.TargetDone, outcome, detail
+    target.UpdatedAt = now.UnixNano() / int64(time.Millisecond)
+    target.Duration = 0
+    if call.AnsweredAt != 0 {
+        target.Duration = target.UpdatedAt - call.AnsweredAt
+    }
+    if call.RoomId != "" {
+        target.RoomId = call.RoomId
+    }
+    unreached := outcome == OutcomeBusy || outcome == OutcomeNoAnswer || outcome == OutcomeFailed
+    if unreached && target.CallbackId == "" && target.Attempts < campaign.MaxAttempts {
+        target.Status = storage.TargetPending
+        target.NotBefore = now.Add(time.Duration(campaign.RetryDelaySeconds) * time.Second).UnixNano() / int64(time.Millisecond)
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := store.Campaigns().UpdateTarget(ctx, target); err != nil {
+        log.Printf("Campaign %s target %s update failed: %v", campaign.Id, target.Id, err)
+    }
+    if target.CallbackId == "" {
+        return
+    }
+    callback, err := store.Callbacks().Get(ctx, target.CallbackId)
+    if err != nil {
+        log.Printf("Callback %s lookup failed: %v", target.CallbackId, err)
+        return
+    }
+    status := storage.CallbackFailed
+    if outcome == OutcomeAnswered || (outcome == OutcomeMachine && campaign.OnMachine == OnMachineMessage && campaign.Message != "") {
+        status = storage.CallbackCompleted
+    }
+    settleCallback(&callback, status, fmt.Sprintf("campaign %s: %s", campaign.Id, outcome), true, now)
+    if err := store.Callbacks().Update(ctx, callback); err != nil {
+        log.Printf("Callback %s update failed: %v", callback.Id, err)
+    }
+}
+
+func completeCampaign(campaign storage.Campaign, stop chan struct{}) {
+    campaignsMu.Lock()
+    if campaignRunners[campaign.Id] != stop {
+        // Paused or cancelled meanwhile
+        campaignsMu.Unlock()
+        return
+    }
+    delete(campaignRunners, campaign.Id)
+    campaignsMu.Unlock()
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    id := campaign.Id
+    campaign, err := store.Campaigns().Get(ctx, id)
+    if err != nil {
+        log.Printf("Completing campaign %s failed: %v", id, err)
+        return
+    }
+    campaign.Status = storage.CampaignCompleted
+    campaign.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    if err := store.Campaigns().Update(ctx, campaign); err != nil {
+        log.Printf("Completing campaign %s failed: %v", campaign.Id, err)
+        return
+    }
+    tally, _ := store.Campaigns().Tally(ctx, campaign.Id)
+    log.Printf("Campaign %s (%s) completed: %v", campaign.Id, campaign.Name, tally)
+    emitEvent("campaign_completed", &RoomInfo{Tenant: campaign.Tenant}, map[string]interface{}{
+        "campaignId": campaign.Id,
+        "name":       campaign.Name,
+        "tally":      tally,
+    })
+}
+
+// campaignRequest is the body of POST /admin/campaigns.
+type campaignRequest struct {
+    storage.Campaign
+    Targets []storage.CampaignTarget `json:"targets"`
+    Start   bool                     `json:"start"` // Start dialling right away instead of saving a draft
+}
+
+// validCampaign fills in defaults and checks a campaign's settings.
+func validCampaign(campaign *storage.Campaign) error {
+    if strings.TrimSpace(campaign.Name) == "" {
+        return fmt.Errorf("name required")
+    }
+    if campaign.CallsPerMinute == 0 {
+        campaign.CallsPerMinute = defaultCallsPerMinute
+    }
+    if campaign.MaxConcurrent == 0 {
+        campaign.MaxConcurrent = defaultMaxConcurrent
+    }
+    if campaign.MaxAttempts == 0 {
+        campaign.MaxAttempts = defaultMaxAttempts
+    }
+    if campaign.RetryDelaySeconds == 0 {
+        campaign.RetryDelaySeconds = defaultRetryDelaySeconds
+    }
+    if campaign.OnMachine == "" {
+        campaign.OnMachine = OnMachineHangup
+    }
+    switch {
+    case campaign.CallsPerMinute < 0 || campaign.CallsPerMinute > 600:
+        return fmt.Errorf("callsPerMinute must be up to 600")
+    case campaign.MaxConcurrent < 0 || campaign.MaxAttempts < 0 || campaign.RetryDelaySeconds < 0:
+        return fmt.Errorf("maxConcurrent, maxAttempts and retryDelaySeconds must be positive")
+    case campaign.OnMachine != OnMachineHangup && campaign.OnMachine != OnMachineMessage:
+        return fmt.Errorf("onMachine must be hangup or message")
+    case campaign.OnMachine == OnMachineMessage && campaign.Message == "":
+        return fmt.Errorf("message required to leave one on answering machines")
+    }
+    return nil
+}
+
+// newTargets checks targets given to a campaign and makes them pending.
+func newTargets(campaignId string, given []storage.CampaignTarget) ([]storage.CampaignTarget, error) {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    targets := make([]storage.CampaignTarget, 0, len(given))
+    for i, target := range given {
+        if strings.TrimSpace(target.Phone) == "" {
+            return nil, fmt.Errorf("target %d has no phone", i)
+        }
+        targets = append(targets, storage.CampaignTarget{
+            Id:         newRandomId(),
+            CampaignId: campaignId,
+            Phone:      strings.TrimSpace(target.Phone),
+            CustomerId: target.CustomerId,
+            Metadata:   target.Metadata,
+            Status:     storage.TargetPending,
+            CreatedAt:  now,
+            UpdatedAt:  now,
+        })
+    }
+    return targets, nil
+}
+
+// /admin/campaigns (admin):
+//
+//   GET  /admin/campaigns[?tenant=]
+//   POST /admin/campaigns                           a campaign with its targets, see campaignRequest
+//   GET  /admin/campaigns/ID                        with target counts and live calls
+//   POST /admin/campaigns/ID/start|pause|cancel
+//   GET  /admin/campaigns/ID/targets[?status=&limit=]
+//   POST /admin/campaigns/ID/targets                [{"phone", "customerId", "metadata"}, ...]
+func handleCampaigns(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/campaigns"), "/")
+    id, action, _ := strings.Cut(path, "/")
+    
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        campaigns, err := store.Campaigns().List(r.Context(), r.URL.Query().Get("tenant"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"campaigns": campaigns})
+    case id == "" && r.Method == http.MethodPost:
+        addCampaign(w, r)
+    case id == "":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    default:
+        campaign, err := store.Campaigns().Get(r.Context(), id)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Campaign not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        switch {
+        case action == "" && r.Method == http.MethodGet:
+            tally, err := store.Campaigns().Tally(r.Context(), id)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(map[string]interface{}{
+                "campaign": campaign,
+                "tally":    tally,
+                "calls":    liveCalls(id),
+            })
+        case (action == "start" || action == "pause" || action == "cancel") && r.Method == http.MethodPost:
+            setCampaignStatus(w, r, campaign, action)
+        case action == "targets" && r.Method == http.MethodGet:
+            listTargets(w, r, id)
+        case action == "targets" && r.Method == http.MethodPost:
+            addTargets(w, r, campaign)
+        case action == "" || action == "start" || action == "pause" || action == "cancel" || action == "targets":
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        default:
+            http.NotFound(w, r)
+        }
+    }
+}
+
+func addCampaign(w http.ResponseWriter, r *http.Request) {
+    var req campaignRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    campaign := req.Campaign
+    if err := validCampaign(&campaign); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if req.Start && telephonyProvider == nil {
+        http.Error(w, "Outbound calling is disabled, set -telephony-url", http.StatusConflict)
+        return
+    }
+    campaign.Id = newRandomId()
+    targets, err := newTargets(campaign.Id, req.Targets)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    campaign.Status = storage.CampaignDraft
+    if req.Start {
+        campaign.Status = storage.CampaignRunning
+    }
+    campaign.CreatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    campaign.UpdatedAt = campaign.CreatedAt
+    
+    if err := store.Campaigns().Add(r.Context(), campaign); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if len(targets) > 0 {
+        if err := store.Campaigns().AddTargets(r.Context(), targets); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+    if req.Start {
+        startCampaignRunner(campaign)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "campaign": campaign,
+        "targets":  len(targets),
+    })
+}
+
+func setCampaignStatus(w http.ResponseWriter, r *http.Request, campaign storage.Campaign, action string) {
+    var to string
+    switch action {
+    case "start":
+        if campaign.Status == storage.CampaignCancelled || campaign.Status == storage.CampaignRunning {
+            http.Error(w, "Campaign is "+campaign.Status, http.StatusConflict)
+            return
+        }
+        if telephonyProvider == nil {
+            http.Error(w, "Outbound calling is disabled, set -telephony-url", http.StatusConflict)
+            return
+        }
+        to = storage.CampaignRunning
+    case "pause":
+        if campaign.Status != storage.CampaignRunning {
+            http.Error(w, "Campaign is "+campaign.Status, http.StatusConflict)
+            return
+        }
+        to = storage.CampaignPaused
+    default:
+        if campaign.Status == storage.CampaignCancelled {
+            http.Error(w, "Campaign is "+campaign.Status, http.StatusConflict)
+            return
+        }
+        to = storage.CampaignCancelled
+    }
+    
+    // Calls already up run to their end either way
+    stopCampaignRunner(campaign.Id)
+    campaign.Status = to
+    campaign.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    if err := store.Campaigns().Update(r.Context(), campaign); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if to == storage.CampaignRunning {
+        startCampaignRunner(campaign)
+    }
+    log.Printf("Campaign %s (%s) %s", campaign.Id, campaign.Name, to)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(campaign)
+}
+
+func listTargets(w http.ResponseWriter, r *http.Request, id string) {
+    limit := 100
+    if value := r.URL.Query().Get("limit"); value != "" {
+        var err error
+        if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxTargetPage {
+            http.Error(w, "limit must be 1 to "+strconv.Itoa(maxTargetPage), http.StatusBadRequest)
+            return
+        }
+    }
+    targets, err := store.Campaigns().Targets(r.Context(), id, r.URL.Query().Get("status"), limit)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "targets": targets,
+        "count":   len(targets),
+    })
+}
+
+func addTargets(w http.ResponseWriter, r *http.Request, campaign storage.Campaign) {
+    if campaign.Status == storage.CampaignCancelled {
+        http.Error(w, "Campaign is "+campaign.Status, http.StatusConflict)
+        return
+    }
+    var given []storage.CampaignTarget
+    if err := json.NewDecoder(r.Body).Decode(&given); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    targets, err := newTargets(campaign.Id, given)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := store.Campaigns().AddTargets(r.Context(), targets); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{"added": len(targets)})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "time"
+)
+
+// Channel is a private sub-channel between two participants of a room.
+type Channel struct {
+    Id        string    `json:"channelId"`
+    Members   [2]string `json:"members"`
+    OpenedBy  string    `json:"openedBy"`
+    CreatedAt int64     `json:"createdAt"`
+}
+
+func (c *Channel) peerOf(clientId string) (string, bool) {
+    switch clientId {
+    case c.Members[0]:
+        return c.Members[1], true
+    case c.Members[1]:
+        return c.Members[0], true
+    }
+    return "", false
+}
+
+func lookupChannel(roomId string, channelId string) *Channel {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    
+    room := rooms[roomId]
+    if room == nil {
+        return nil
+    }
+    return room.Channels[channelId]
+}
+
+func sendChannelEvent(roomId string, msgType string, channel *Channel, extra map[string]interface{}) {
+    data := map[string]interface{}{
+        "channelId": channel.Id,
+        "members":   channel.Members,
+        "openedBy":  channel.OpenedBy,
+    }
+    for key, value := range extra {
+        data[key] = value
+    }
+    
+    msg := &Message{
+        Type:      msgType,
+        From: SystemSender,
+        To:        channel.Members[:],
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    selectiveSend(roomId, nil, msg)
+}
+
+// channel_open: {"peer": CLIENT_ID, "audio": bool}
+func handleChannelOpen(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    peerId, _ := data["peer"].(string)
+    
+    if peerId == "" || peerId == sender.clientId || findClient(roomId, peerId) == nil {
+        sendError(sender, ErrTargetNotFound, msg, "peer %q is not in the room", peerId)
+        return
+    }
+    
+    channel := &Channel{
+        Id:        newRandomId(),
+        Members:   [2]string{sender.clientId, peerId},
+        OpenedBy:  sender.clientId,
+        CreatedAt: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return
+    }
+    room.Channels[channel.Id] = channel
+    roomsMu.Unlock()
+    
+    logAt("info", roomId, sender.clientId, "Channel %s opened with %s", channel.Id, peerId)
+    sendChannelEvent(roomId, "channel_opened", channel, nil)
+    
+    if audio, _ := data["audio"].(bool); audio {
+        setChannelAudio(roomId, sender, channel, true)
+    }
+}
+
+// channel_close: {"channelId": ID}
+func handleChannelClose(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    channelId, _ := data["channelId"].(string)
+    
+    channel := lookupChannel(roomId, channelId)
+    if channel == nil {
+        sendError(sender, ErrTargetNotFound, msg, "channel %q not found", channelId)
+        return
+    }
+    if _, member := channel.peerOf(sender.clientId); !member {
+        sendError(sender, ErrNotPermitted, msg, "not a member of channel %s", channelId)
+        return
+    }
+    
+    closeChannel(roomId, channel, sender.clientId)
+}
+
+func closeChannel(roomId string, channel *Channel, closedBy string) {
+    roomsMu.Lock()
+    if room := rooms[roomId]; room != nil {
+        delete(room.Channels, channel.Id)
+    }
+    roomsMu.Unlock()
+    
+    for _, member := range channel.Members {
+        if client := findClient(roomId, member); client != nil {
+            client.mu.Lock()
+            if client.audioChannel == channel.Id {
+                client.audioChannel = ""
+            }
+            client.mu.Unlock()
+        }
+    }
+    
+    logAt("info", roomId, closedBy, "Channel %s closed", channel.Id)
+    sendChannelEvent(roomId, "channel_closed", channel, map[string]interface{}{"closedBy": closedBy})
+}
+
+// closeClientChannels tears down every channel the leaving client belongs to.
+func closeClientChannels(roomId string, client *Client) {
+    roomsMu.RLock()
+    var owned []*Channel
+    if room := rooms[roomId]; room != nil {
+        for _, channel := range room.Channels {
+            if _, member := channel.peerOf(client.clientId); member {
+                owned = append(owned, channel)
+            }
+        }
+    }
+    roomsMu.RUnlock()
+    
+    for _, channel := range owned {
+        closeChannel(roomId, channel, client.clientId)
+    }
+}
+
+// channel_audio: {"channelId": ID, "enabled": bool} diverts the sender's
+// audio into the channel instead of the room while enabled.
+func handleChannelAudio(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    channelId, _ := data["channelId"].(string)
+    enabled, _ := data["enabled"].(bool)
+    
+    channel := lookupChannel(roomId, channelId)
+    if channel == nil {
+        sendError(sender, ErrTargetNotFound, msg, "channel %q not found", channelId)
+        return
+    }
+    if _, member := channel.peerOf(sender.clientId); !member {
+        sendError(sender, ErrNotPermitted, msg, "not a member of channel %s", channelId)
+        return
+    }
+    
+    setChannelAudio(roomId, sender, channel, enabled)
+}
+
+func setChannelAudio(roomId string, client *Client, channel *Channel, enabled bool) {
+    client.mu.Lock()
+    if enabled {
+        client.audioChannel = channel.Id
+    } else if client.audioChannel == channel.Id {
+        client.audioChannel = ""
+    }
+    client.mu.Unlock()
+    
+    sendChannelEvent(roomId, "channel_audio_changed", channel, map[string]interface{}{
+        "clientId": client.clientId,
+        "enabled":  enabled,
+    })
+}
+
+// sendToChannel delivers a message carrying a channel ID to the other member only.
+func sendToChannel(roomId string, sender *Client, msg *Message) {
+    channel := lookupChannel(roomId, msg.Channel)
+    if channel == nil {
+        sendError(sender, ErrTargetNotFound, msg, "channel %q not found", msg.Channel)
+        return
+    }
+    
+    peerId, member := channel.peerOf(sender.clientId)
+    if !member {
+        sendError(sender, ErrNotPermitted, msg, "not a member of channel %s", channel.Id)
+        return
+    }
+    
+    if peer := findClient(roomId, peerId); peer != nil {
+        msg.To = []string{peerId}
+        sendMessageToClient(peer, msg)
+    }
+}
+
+// forwardChannelAudio returns false when the client's audio is not diverted.
+func forwardChannelAudio(roomId string, client *Client, audioData []byte, paced bool) bool {
+    client.mu.Lock()
+    channelId := client.audioChannel
+    client.mu.Unlock()
+    
+    if channelId == "" {
+        return false
+    }
+    
+    channel := lookupChannel(roomId, channelId)
+    if channel == nil {
+        return false
+    }
+    
+    peerId, _ := channel.peerOf(client.clientId)
+    if peer := findClient(roomId, peerId); peer != nil {
+        if err := sendAudio(peer, audioData, paced); err != nil {
+            logAt("warn", roomId, peerId, "Channel audio forward error: %v", err)
+        }
+    }
+    return true
+}
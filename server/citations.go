@@ -0,0 +1,126 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Answer provenance. The virtual agent sends its answers as assistant_final:
+//
+//   {"text": TEXT, "citations": [{"type": "kg_node", "id": "4:ab12:7", "label": "Doctor: Jane Roe"}, ...]}
+//
+// The server checks the citations, stores them with the transcript entry and
+// passes them on to agents. Users get the answer without them unless
+// -citations-to-users is set.
+
+type Citation struct {
+    Type    string  `json:"type"`              // See citationTypes
+    Id      string  `json:"id"`                // Node or relationship element ID, document or FAQ ID, query hash
+    Ref     string  `json:"ref,omitempty"`     // Cypher, URL or section the item was found by
+    Label   string  `json:"label,omitempty"`   // Human readable name shown to agents
+    Snippet string  `json:"snippet,omitempty"` // The content the answer relied on
+    Score   float64 `json:"score,omitempty"`   // Retrieval score, when the source has one
+}
+
+var citationTypes = []string{"kg_node", "kg_relationship", "kg_query", "document", "faq"}
+
+const (
+    maxCitationField   = 256
+    maxCitationSnippet = 1024
+)
+
+func validCitationType(citationType string) bool {
+    for _, t := range citationTypes {
+        if t == citationType {
+            return true
+        }
+    }
+    return false
+}
+
+// parseCitations validates the citations of an assistant_final payload.
+func parseCitations(raw interface{}) ([]Citation, error) {
+    if raw == nil {
+        return []Citation{}, nil
+    }
+    list, ok := raw.([]interface{})
+    if !ok {
+        return nil, fmt.Errorf("citations must be an array")
+    }
+    if len(list) > cfg.MaxCitations {
+        return nil, fmt.Errorf("at most %d citations per answer", cfg.MaxCitations)
+    }
+    
+    citations := make([]Citation, 0, len(list))
+    for i, item := range list {
+        fields, ok := item.(map[string]interface{})
+        if !ok {
+            return nil, fmt.Errorf("citation %d must be an object", i)
+        }
+        c := Citation{}
+        c.Type, _ = fields["type"].(string)
+        c.Id, _ = fields["id"].(string)
+        c.Ref, _ = fields["ref"].(string)
+        c.Label, _ = fields["label"].(string)
+        c.Snippet, _ = fields["snippet"].(string)
+        c.Score, _ = fields["score"].(float64)
+        
+        if !validCitationType(c.Type) {
+            return nil, fmt.Errorf("citation %d: type must be one of %s", i, strings.Join(citationTypes, ", "))
+        }
+        if c.Id == "" {
+            return nil, fmt.Errorf("citation %d: id required", i)
+        }
+        if len(c.Id) > maxCitationField || len(c.Label) > maxCitationField || len(c.Ref) > maxCitationSnippet || len(c.Snippet) > maxCitationSnippet {
+            return nil, fmt.Errorf("citation %d is too long", i)
+        }
+        citations = append(citations, c)
+    }
+    return citations, nil
+}
+
+// prepareAssistantFinal checks an answer before it is recorded, replacing
+// the posted citations with the validated ones.
+func prepareAssistantFinal(sender *Client, msg *Message) error {
+    if sender.clientType != ClientTypeAgent {
+        return newCodedError(ErrNotPermitted, "only agents can send assistant_final")
+    }
+    data, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        return newCodedError(ErrInvalidMessage, "assistant_final data must be an object")
+    }
+    if text, _ := data["text"].(string); text == "" {
+        return newCodedError(ErrInvalidMessage, "assistant_final needs text")
+    }
+    citations, err := parseCitations(data["citations"])
+    if err != nil {
+        return newCodedError(ErrInvalidMessage, "%v", err)
+    }
+    
+    clean := make(map[string]interface{}, len(data))
+    for key, value := range data {
+        clean[key] = value
+    }
+    clean["citations"] = citations
+    msg.Data = clean
+    return nil
+}
+
+func handleAssistantFinal(roomId string, sender *Client, msg *Message) {
+    if cfg.CitationsToUsers {
+        broadcastToRoom(roomId, sender, msg)
+        return
+    }
+    sendToAgents(roomId, sender, msg)
+    
+    data := msg.Data.(map[string]interface{})
+    userData := make(map[string]interface{}, len(data))
+    for key, value := range data {
+        if key != "citations" {
+            userData[key] = value
+        }
+    }
+    userMsg := *msg
+    userMsg.Data = userData
+    sendToUsers(roomId, sender, &userMsg)
+}
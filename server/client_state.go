@@ -0,0 +1,57 @@
+package main
+
+import (
+    "errors"
+)
+
+// ClientState is the lifecycle of a connection. It only moves forward:
+// connecting -> active -> draining -> closed.
+type ClientState int
+
+const (
+    ClientConnecting ClientState = iota // Upgraded, not yet visible in the room
+    ClientActive                        // In the room, receives messages and audio
+    ClientDraining                      // Leaving, no new writes are accepted
+    ClientClosed                        // Writer stopped, connection closed
+)
+
+var errClientNotActive = errors.New("client not active")
+
+func (s ClientState) String() string {
+    switch s {
+    case ClientConnecting:
+        return "connecting"
+    case ClientActive:
+        return "active"
+    case ClientDraining:
+        return "draining"
+    case ClientClosed:
+        return "closed"
+    }
+    return "unknown"
+}
+
+func (c *Client) currentState() ClientState {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    return c.state
+}
+
+// setState advances the lifecycle and reports whether the transition happened.
+func (c *Client) setState(next ClientState) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    if next <= c.state {
+        return false
+    }
+    c.state = next
+    return true
+}
+
+// acceptsWrites must be called with c.mu held. Connecting clients already
+// accept writes so the welcome message can be queued before they go active.
+func (c *Client) acceptsWrites() bool {
+    return c.state == ClientConnecting || c.state == ClientActive
+}
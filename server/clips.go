@@ -0,0 +1,174 @@
+package main
+
+import (
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+    "github.com/yourusername/my-go-project/stt"
+)
+
+// Utterance clips. With -clips-dir every final transcript of a caller comes
+// with the audio it was recognized from, cut at the recognizer's voice
+// activity boundaries plus clipPadding either side, so a reviewer can hear
+// exactly the disputed sentence without scrubbing the recording:
+//
+//   {"type": "transcript", "data": {"text": "...", "startMs": 5120, "endMs": 7480, "clipUrl": "/room/R1/clips/MESSAGE_ID"}}
+//
+// The URL is kept in history, so GET /room/ROOM_ID/messages lists it with
+// the text, and GET /room/ROOM_ID/clips/MESSAGE_ID (admin) serves the WAV.
+// Only what the recognizer heard goes into clips, never a secure capture or
+// a paused recording. The last -clip-buffer of each caller's audio is held
+// for cutting, longer utterances lose their start. Clips are deleted after
+// -clips-retention.
+
+const clipPadding = 200 * time.Millisecond
+
+// clipBuffer is the recent audio of a caller's STT session. Guarded by the
+// client's mu.
+type clipBuffer struct {
+    format audiogen.Format
+    audio  []byte
+    offset int // Session offset of audio[0] in bytes
+    max    int
+}
+
+func newClipBuffer(format audiogen.Format) *clipBuffer {
+    return &clipBuffer{format: format, max: format.Bytes(cfg.ClipBuffer)}
+}
+
+// write appends audio as the session got it, trimming in batches so a frame
+// costs no copy of the whole buffer.
+func (b *clipBuffer) write(data []byte) {
+    b.audio = append(b.audio, data...)
+    if len(b.audio) > b.max+b.max/2 {
+        drop := len(b.audio) - b.max
+        b.audio = append(b.audio[:0], b.audio[drop:]...)
+        b.offset += drop
+    }
+}
+
+// cut copies the session audio between two offsets, as much of it as is
+// still held.
+func (b *clipBuffer) cut(start time.Duration, end time.Duration) []byte {
+    from, to := b.format.Bytes(start-clipPadding)-b.offset, b.format.Bytes(end+clipPadding)-b.offset
+    if from < 0 {
+        from = 0
+    }
+    if to > len(b.audio) {
+        to = len(b.audio)
+    }
+    if to <= from {
+        return nil
+    }
+    return append([]byte(nil), b.audio[from:to]...)
+}
+
+// clipPath is where a transcript's clip lives, empty for IDs that are not
+// safe file names.
+func clipPath(roomId string, messageId string) string {
+    for _, name := range []string{roomId, messageId} {
+        if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+            return ""
+        }
+    }
+    return filepath.Join(cfg.ClipsDir, roomId, messageId+".wav")
+}
+
+// cutClip takes the audio of a final transcript for writeClip, returning it
+// with its URL, or nil and "" when there is none.
+func cutClip(client *Client, messageId string, result stt.Result) (*clipAudio, string) {
+    client.mu.Lock()
+    var audio []byte
+    var format audiogen.Format
+    if client.clip != nil {
+        audio, format = client.clip.cut(result.Start, result.End), client.clip.format
+    }
+    client.mu.Unlock()
+    path := clipPath(client.room, messageId)
+    if len(audio) == 0 || path == "" {
+        return nil, ""
+    }
+    return &clipAudio{path: path, audio: audio, format: format}, "/room/" + url.PathEscape(client.room) + "/clips/" + messageId
+}
+
+// writeClip stores a clip cut by cutClip.
+func writeClip(client *Client, clip *clipAudio) {
+    if clip == nil {
+        return
+    }
+    err := os.MkdirAll(filepath.Dir(clip.path), 0755)
+    if err == nil {
+        err = os.WriteFile(clip.path, audiogen.EncodeWAV(clip.audio, clip.format), 0644)
+    }
+    if err != nil {
+        logAt("warn", client.room, client.clientId, "Saving clip failed: %v", err)
+    }
+}
+
+// GET /room/ROOM_ID/clips/MESSAGE_ID (admin)
+func handleRoomClip(w http.ResponseWriter, r *http.Request, roomId string, messageId string) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    path := clipPath(roomId, messageId)
+    if cfg.ClipsDir == "" || path == "" {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "audio/wav")
+    http.ServeFile(w, r, path)
+}
+
+// startClipJanitor deletes clips older than -clips-retention.
+func startClipJanitor() {
+    if cfg.ClipsDir == "" || cfg.ClipsRetention <= 0 {
+        return
+    }
+    go func() {
+        for ; ; time.Sleep(time.Hour) {
+            pruneClips(time.Now().Add(-cfg.ClipsRetention))
+        }
+    }()
+}
+
+func pruneClips(cutoff time.Time) {
+    rooms, err := os.ReadDir(cfg.ClipsDir)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("level=warn Pruning clips: %v", err)
+        }
+        return
+    }
+    removed := 0
+    for _, room := range rooms {
+        dir := filepath.Join(cfg.ClipsDir, room.Name())
+        clips, err := os.ReadDir(dir)
+        if err != nil {
+            continue
+        }
+        kept := len(clips)
+        for _, clip := range clips {
+            info, err := clip.Info()
+            if err == nil && info.ModTime().Before(cutoff) && os.Remove(filepath.Join(dir, clip.Name())) == nil {
+                removed++
+                kept--
+            }
+        }
+        if kept == 0 {
+            os.Remove(dir)
+        }
+    }
+    if removed > 0 {
+        log.Printf("Pruned %d utterance clips", removed)
+    }
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "net/http"
+    "time"
+    "unicode/utf8"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Close codes. Every connection the server ends gets a close frame with one
+// of these, from the 4000-4999 range RFC 6455 leaves to applications, and
+// its name as the reason, followed by ": " and a detail where there is one.
+// Retry tells the SDKs whether reconnecting can help: a kicked client or a
+// bad ticket fares no better the second time, a restarting server does.
+// 4000 is the SDKs' own, closing to follow a redirect. /protocol lists the
+// codes as x-close-codes.
+type CloseCode int
+
+const (
+    CloseRoomFull CloseCode = 4001 + iota
+    CloseKicked
+    CloseAuthFailed
+    CloseDuplicateId
+    CloseIdleTimeout
+    CloseServerShutdown
+    CloseRoomClosed
+    CloseRoomMoved
+    CloseMigrated
+    CloseRateLimited
+    CloseQuotaExceeded
+    CloseSlowConsumer
+    CloseRejected
+)
+
+type closeSpec struct {
+    Name    string
+    Retry   bool
+    Summary string
+}
+
+var closeSpecs = map[CloseCode]closeSpec{
+    CloseRoomFull:       {"room_full", true, "The room has no place left for the client's type; one may free up"},
+    CloseKicked:         {"kicked", false, "A moderator removed the client and revoked its resume token"},
+    CloseAuthFailed:     {"auth_failed", false, "The ticket was missing, invalid or expired, or the role needs admin credentials"},
+    CloseDuplicateId:    {"duplicate_id", false, "Another connection with the same clientId replaced this one, or this one was refused for it"},
+    CloseIdleTimeout:    {"idle_timeout", true, "Nothing arrived within -pong-timeout; the resume token still holds the place"},
+    CloseServerShutdown: {"server_shutdown", true, "The server is restarting or draining; reconnect with the resume token"},
+    CloseRoomClosed:     {"room_closed", false, "The room ended"},
+    CloseRoomMoved:      {"room_moved", true, "An admin moved the room; rejoining places it on another server"},
+    CloseMigrated:       {"migrated", true, "The room migrated; follow the redirect sent before"},
+    CloseRateLimited:    {"rate_limited", false, "The client went over its rate limits too often"},
+    CloseQuotaExceeded:  {"quota_exceeded", false, "The tenant used up its quota"},
+    CloseSlowConsumer:   {"slow_consumer", true, "The client fell behind its send queue; resume with lastSeq to have the messages replayed"},
+    CloseRejected:       {"rejected", false, "The join was refused, see the error sent before"},
+}
+
+func (code CloseCode) String() string {
+    return closeSpecs[code].Name
+}
+
+// closeCodeFor is the close code a join refused with code ends with.
+func closeCodeFor(code ErrorCode) CloseCode {
+    switch code {
+    case ErrRoomFull:
+        return CloseRoomFull
+    case ErrDuplicateClient:
+        return CloseDuplicateId
+    case ErrNotPermitted:
+        return CloseAuthFailed
+    case ErrTargetNotFound:
+        return CloseRoomClosed
+    }
+    return CloseRejected
+}
+
+// closeFrame is code's close frame with detail after its name, within the
+// 123 bytes of UTF-8 a close reason may take.
+func closeFrame(code CloseCode, detail string) []byte {
+    reason := code.String()
+    if detail != "" {
+        reason += ": " + detail
+    }
+    for len(reason) > 123 {
+        _, size := utf8.DecodeLastRuneInString(reason)
+        reason = reason[:len(reason)-size]
+    }
+    return websocket.FormatCloseMessage(int(code), reason)
+}
+
+// closeConnection sends code's close frame and closes conn. WriteControl is
+// safe beside the writer goroutine.
+func closeConnection(conn *websocket.Conn, code CloseCode, detail string) {
+    conn.WriteControl(websocket.CloseMessage, closeFrame(code, detail), time.Now().Add(time.Second))
+    conn.Close()
+}
+
+// refuseUpgrade turns a WebSocket request away by upgrading it only to close
+// with code, as browsers hide the status of a failed handshake. Requests
+// that aren't upgrades get status and text instead.
+func refuseUpgrade(w http.ResponseWriter, r *http.Request, code ErrorCode, status int, text string) {
+    if !websocket.IsWebSocketUpgrade(r) {
+        http.Error(w, text, status)
+        return
+    }
+    conn, err := upgrader.Upgrade(w, r, nil)
+    if err != nil {
+        return // Upgrade wrote the error
+    }
+    rejectConnection(conn, code, text)
+}
+
+// closeCodesSpec describes the close codes for /protocol.
+func closeCodesSpec() []interface{} {
+    var codes []interface{}
+    for code := CloseRoomFull; code <= CloseRejected; code++ {
+        spec := closeSpecs[code]
+        codes = append(codes, map[string]interface{}{
+            "code":    int(code),
+            "name":    spec.Name,
+            "retry":   spec.Retry,
+            "summary": spec.Summary,
+        })
+    }
+    return codes
+}
@@ -0,0 +1,117 @@
+// Command audiogen joins a room as a test client and streams generated audio
+// at real-time pace, e.g.
+//
+//   go run ./cmd/audiogen -room r1 -tone 440 -duration 3s
+//   go run ./cmd/audiogen -room r1 -say "Hello, I'd like to book an appointment."
+//   go run ./cmd/audiogen -room r1 -wav fixtures/greeting.wav -save received.wav
+//
+// Audio is announced as pcm16 so the server frames and paces it like a real
+// microphone. Whatever audio the room sends back can be saved as a WAV.
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "net/url"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    "github.com/yourusername/my-go-project/audiogen"
+)
+
+func main() {
+    server := flag.String("server", "ws://localhost:8080/ws", "Media server WebSocket URL")
+    room := flag.String("room", "", "Room to join")
+    clientId := flag.String("client-id", "audiogen", "Client ID to join as")
+    clientType := flag.String("type", "user", "Client type, user or agent")
+    ticket := flag.String("ticket", "", "Allocation ticket when the server requires one")
+    rate := flag.Int("sample-rate", audiogen.Default.SampleRate, "Sample rate to generate")
+    frameMs := flag.Int("frame-ms", 20, "Frame duration")
+    tone := flag.Float64("tone", 0, "Play a sine tone at this frequency (Hz)")
+    duration := flag.Duration("duration", 2*time.Second, "Tone duration")
+    say := flag.String("say", "", "Play a deterministic speech-like phrase for this text")
+    wav := flag.String("wav", "", "Play a 16-bit PCM WAV fixture")
+    lead := flag.Duration("silence", 500*time.Millisecond, "Silence before and after the audio, so a VAD sees clean edges")
+    linger := flag.Duration("linger", 2*time.Second, "How long to stay connected after playing")
+    save := flag.String("save", "", "Write audio received from the room to this WAV file")
+    flag.Parse()
+    
+    if *room == "" {
+        log.Fatal("-room is required")
+    }
+    format := audiogen.Format{SampleRate: *rate, Channels: 1}
+    
+    var clip []byte
+    switch {
+    case *wav != "":
+        var err error
+        if clip, err = audiogen.LoadWAV(*wav, format); err != nil {
+            log.Fatal(err)
+        }
+    case *say != "":
+        clip = audiogen.Phrase(format, *say)
+    case *tone > 0:
+        clip = audiogen.Tone(format, *tone, *duration, 0.5)
+    default:
+        log.Fatal("one of -tone, -say or -wav is required")
+    }
+    clip = audiogen.Concat(audiogen.Silence(format, *lead), clip, audiogen.Silence(format, *lead))
+    
+    query := url.Values{
+        "room":        {*room},
+        "clientId":    {*clientId},
+        "type":        {*clientType},
+        "audioFormat": {"pcm16"},
+        "sampleRate":  {strconv.Itoa(*rate)},
+    }
+    if *ticket != "" {
+        query.Set("ticket", *ticket)
+    }
+    conn, _, err := websocket.DefaultDialer.Dial(*server+"?"+query.Encode(), nil)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer conn.Close()
+    
+    var mu sync.Mutex
+    var received []byte
+    go func() {
+        for {
+            messageType, data, err := conn.ReadMessage()
+            if err != nil {
+                return
+            }
+            if messageType == websocket.BinaryMessage {
+                mu.Lock()
+                received = append(received, data...)
+                mu.Unlock()
+            } else {
+                fmt.Println(string(data))
+            }
+        }
+    }()
+    
+    frames := audiogen.Frames(format, clip, *frameMs)
+    log.Printf("Playing %d frames (%v)", len(frames), format.Duration(len(clip)))
+    err = audiogen.Play(context.Background(), frames, *frameMs, func(frame []byte) error {
+        return conn.WriteMessage(websocket.BinaryMessage, frame)
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+    time.Sleep(*linger)
+    
+    if *save != "" {
+        mu.Lock()
+        defer mu.Unlock()
+        if err := os.WriteFile(*save, audiogen.EncodeWAV(received, format), 0644); err != nil {
+            log.Fatal(err)
+        }
+        log.Printf("Saved %d bytes of received audio to %s", len(received), *save)
+    }
+}
@@ -0,0 +1,84 @@
+// Command evalrun replays a corpus of golden conversations against a running
+// media server and reports the ones whose intents, tool calls or summary
+// differ from what they expect, e.g.
+//
+//   go run ./cmd/evalrun -corpus testdata/golden
+//   go run ./cmd/evalrun -corpus calls/ -live -admin-token $ADMIN_TOKEN
+//   go run ./cmd/evalrun -corpus calls/ -audio -json > results.jsonl
+//
+// Replayed, both sides come from the recordings, which checks the server's
+// routing and bookkeeping. With -live only the callers' turns are played and
+// the room's real agent answers, which checks the agent. It exits 1 when any
+// conversation fails.
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/golden"
+)
+
+func main() {
+    server := flag.String("server", "ws://localhost:8080/ws", "Media server WebSocket URL")
+    corpus := flag.String("corpus", "testdata/golden", "Conversation file, or a directory of them")
+    only := flag.String("run", "", "Only run conversations whose name contains this")
+    live := flag.Bool("live", false, "Play only the callers' turns and let the room's agent answer")
+    audio := flag.Bool("audio", false, "Play audio turns for server side transcription instead of sending their text")
+    adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "Admin token, for live runs to read tool calls and summaries back")
+    turnTimeout := flag.Duration("turn-timeout", 10*time.Second, "How long to wait for each turn to come through or be answered")
+    settle := flag.Duration("settle", 1500*time.Millisecond, "Quiet that ends a live agent's reply")
+    asJSON := flag.Bool("json", false, "Print each result as a JSON line")
+    flag.Parse()
+    
+    conversations, err := golden.Load(*corpus)
+    if err != nil {
+        log.Fatal(err)
+    }
+    runner := &golden.Runner{
+        Server:      *server,
+        AdminToken:  *adminToken,
+        Live:        *live,
+        Audio:       *audio,
+        TurnTimeout: *turnTimeout,
+        Settle:      *settle,
+    }
+    
+    ran, failed := 0, 0
+    for _, c := range conversations {
+        if !strings.Contains(c.Name, *only) {
+            continue
+        }
+        ran++
+        result, err := runner.Run(context.Background(), c)
+        if err != nil {
+            log.Fatalf("%s: %v", c.Name, err)
+        }
+        if !result.Passed() {
+            failed++
+        }
+        if *asJSON {
+            line, _ := json.Marshal(result)
+            fmt.Println(string(line))
+            continue
+        }
+        if result.Passed() {
+            fmt.Printf("ok    %s\n", c.Name)
+            continue
+        }
+        fmt.Printf("FAIL  %s (room %s)\n", c.Name, result.RoomId)
+        for _, failure := range result.Failures {
+            fmt.Printf("        %s\n", failure)
+        }
+    }
+    log.Printf("%d of %d conversations passed", ran-failed, ran)
+    if failed > 0 {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,412 @@
+// Command protocolgen turns the server's /protocol document into client
+// message types and close codes, e.g.
+//
+//   go run ./cmd/protocolgen -in http://localhost:8080/protocol -out ../clients/js/src/protocol.ts
+//   go run ./cmd/protocolgen -lang py -out ../clients/python/iva_agent/protocol.py
+//
+// -in is a URL or a file. The output is committed beside each SDK, so the
+// SDKs build without a server; rerun it when a message type or close code
+// changes.
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+)
+
+type schema struct {
+    Ref                  string             `json:"$ref"`
+    Type                 string             `json:"type"`
+    Const                string             `json:"const"`
+    Properties           map[string]*schema `json:"properties"`
+    Required             []string           `json:"required"`
+    Items                *schema            `json:"items"`
+    AdditionalProperties *schema            `json:"additionalProperties"`
+    AllOf                []*schema          `json:"allOf"`
+}
+
+type message struct {
+    Name       string  `json:"name"`
+    Summary    string  `json:"summary"`
+    Direction  string  `json:"x-direction"`
+    Permission string  `json:"x-permission"`
+    Payload    *schema `json:"payload"`
+}
+
+type closeCode struct {
+    Code    int    `json:"code"`
+    Name    string `json:"name"`
+    Retry   bool   `json:"retry"`
+    Summary string `json:"summary"`
+}
+
+type document struct {
+    Info struct {
+        Version string `json:"version"`
+    } `json:"info"`
+    Channels struct {
+        Room struct {
+            Messages map[string]message `json:"messages"`
+        } `json:"room"`
+    } `json:"channels"`
+    Components struct {
+        Schemas map[string]*schema `json:"schemas"`
+    } `json:"components"`
+    CloseCodes []closeCode `json:"x-close-codes"`
+}
+
+func main() {
+    in := flag.String("in", "http://localhost:8080/protocol", "URL or file of the protocol document")
+    out := flag.String("out", "", "File to write (default stdout)")
+    lang := flag.String("lang", "ts", "Language to write: ts or py")
+    flag.Parse()
+    
+    raw, err := read(*in)
+    if err != nil {
+        log.Fatal(err)
+    }
+    var doc document
+    if err := json.Unmarshal(raw, &doc); err != nil {
+        log.Fatalf("%s: %v", *in, err)
+    }
+    var code []byte
+    switch *lang {
+    case "ts":
+        code = typescript(&doc)
+    case "py":
+        code = python(&doc)
+    default:
+        log.Fatalf("-lang %q: want ts or py", *lang)
+    }
+    if *out == "" {
+        os.Stdout.Write(code)
+        return
+    }
+    if err := os.WriteFile(*out, code, 0644); err != nil {
+        log.Fatal(err)
+    }
+}
+
+func read(source string) ([]byte, error) {
+    if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+        return os.ReadFile(source)
+    }
+    resp, err := http.Get(source)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s: %s", source, resp.Status)
+    }
+    return io.ReadAll(resp.Body)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// pascal is a message type's name in type names, e.g. tts_started, TtsStarted.
+func pascal(name string) string {
+    var b strings.Builder
+    for _, part := range strings.Split(name, "_") {
+        if part != "" {
+            b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+        }
+    }
+    return b.String()
+}
+
+func typescript(doc *document) []byte {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "// Code generated by protocolgen from the server's /protocol. DO NOT EDIT.\n\n")
+    fmt.Fprintf(&b, "export const PROTOCOL_VERSION = %s;\n", doc.Info.Version)
+    
+    for _, name := range sortedKeys(doc.Components.Schemas) {
+        fmt.Fprintf(&b, "\nexport interface %s %s\n", name, tsObject(doc.Components.Schemas[name], ""))
+    }
+    
+    messages := doc.Channels.Room.Messages
+    for _, name := range sortedKeys(messages) {
+        msg := messages[name]
+        fmt.Fprintf(&b, "\n/** %s */\n", msg.Summary)
+        fmt.Fprintf(&b, "export type %sData = %s;\n", pascal(name), tsType(dataSchema(msg), ""))
+    }
+    
+    list := func(title string, from func(message) bool) {
+        fmt.Fprintf(&b, "\n/** %s */\nexport interface %s {\n", title, strings.Fields(title)[0])
+        for _, name := range sortedKeys(messages) {
+            if from(messages[name]) {
+                fmt.Fprintf(&b, "  %s: %sData;\n", name, pascal(name))
+            }
+        }
+        fmt.Fprintf(&b, "}\n")
+    }
+    list("ClientMessages clients may send, by type", func(m message) bool { return m.Direction != "server" })
+    list("ServerMessages clients may receive, by type: the server's own and those relayed from other participants", func(m message) bool { return m.Direction != "client" })
+    
+    fmt.Fprintf(&b, "\nexport type ClientMessageType = keyof ClientMessages;\n")
+    fmt.Fprintf(&b, "export type ServerMessageType = keyof ServerMessages;\n")
+    fmt.Fprintf(&b, "\n/** The envelope every JSON frame carries, its data typed by its type. */\n")
+    fmt.Fprintf(&b, "export type TypedMessage<M, T extends keyof M> = Omit<Message, \"type\" | \"data\"> & { type: T; data: M[T] };\n")
+    fmt.Fprintf(&b, "export type IncomingMessage<T extends ServerMessageType = ServerMessageType> = { [K in T]: TypedMessage<ServerMessages, K> }[T];\n")
+    fmt.Fprintf(&b, "export type OutgoingMessage<T extends ClientMessageType = ClientMessageType> = { [K in T]: TypedMessage<ClientMessages, K> }[T];\n")
+    
+    fmt.Fprintf(&b, "\n/** The permission sending each message type takes, see the server's roles. */\n")
+    fmt.Fprintf(&b, "export const MESSAGE_PERMISSIONS: Partial<Record<ClientMessageType, string>> = {\n")
+    for _, name := range sortedKeys(messages) {
+        if perm := messages[name].Permission; perm != "" {
+            fmt.Fprintf(&b, "  %s: %q,\n", name, perm)
+        }
+    }
+    fmt.Fprintf(&b, "};\n")
+    
+    fmt.Fprintf(&b, "\n/** The codes the server closes connections with, the reason starting with the name. */\n")
+    fmt.Fprintf(&b, "export const CLOSE_CODES: Record<number, string> = {\n")
+    for _, code := range doc.CloseCodes {
+        fmt.Fprintf(&b, "  %d: %q, // %s\n", code.Code, code.Name, code.Summary)
+    }
+    fmt.Fprintf(&b, "};\n")
+    fmt.Fprintf(&b, "\n/** The close codes after which reconnecting can help. */\n")
+    fmt.Fprintf(&b, "export const RETRY_CLOSE_CODES: ReadonlySet<number> = new Set([")
+    for i, code := range retryCodes(doc) {
+        if i > 0 {
+            b.WriteString(", ")
+        }
+        fmt.Fprintf(&b, "%d", code)
+    }
+    fmt.Fprintf(&b, "]);\n")
+    return b.Bytes()
+}
+
+// dataSchema is the data property of a message's payload.
+func dataSchema(msg message) *schema {
+    if msg.Payload == nil {
+        return nil
+    }
+    for _, part := range msg.Payload.AllOf {
+        if part.Properties != nil && part.Properties["data"] != nil {
+            return part.Properties["data"]
+        }
+    }
+    return nil
+}
+
+func tsType(s *schema, indent string) string {
+    if s == nil {
+        return "unknown"
+    }
+    if s.Ref != "" {
+        return s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+    }
+    switch s.Type {
+    case "string":
+        return "string"
+    case "integer", "number":
+        return "number"
+    case "boolean":
+        return "boolean"
+    case "array":
+        item := tsType(s.Items, indent)
+        if strings.ContainsAny(item, " |{") {
+            return "Array<" + item + ">"
+        }
+        return item + "[]"
+    case "object":
+        if len(s.Properties) == 0 {
+            if s.AdditionalProperties != nil {
+                return "Record<string, " + tsType(s.AdditionalProperties, indent) + ">"
+            }
+            return "Record<string, unknown>"
+        }
+        return tsObject(s, indent)
+    }
+    return "unknown"
+}
+
+func tsObject(s *schema, indent string) string {
+    if len(s.Properties) == 0 {
+        return "{}"
+    }
+    required := make(map[string]bool)
+    for _, name := range s.Required {
+        required[name] = true
+    }
+    var b strings.Builder
+    b.WriteString("{\n")
+    for _, name := range sortedKeys(s.Properties) {
+        optional := "?"
+        if required[name] {
+            optional = ""
+        }
+        fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, name, optional, tsType(s.Properties[name], indent+"  "))
+    }
+    b.WriteString(indent + "}")
+    return b.String()
+}
+
+func python(doc *document) []byte {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "# Code generated by protocolgen from the server's /protocol. DO NOT EDIT.\n")
+    fmt.Fprintf(&b, "\"\"\"The message types of the room protocol, typed by their data.\"\"\"\n\n")
+    fmt.Fprintf(&b, "from typing import Any, Dict, List, TypedDict\n\n")
+    fmt.Fprintf(&b, "PROTOCOL_VERSION = %s\n", doc.Info.Version)
+    
+    for _, name := range sortedKeys(doc.Components.Schemas) {
+        pyClass(&b, name, "", doc.Components.Schemas[name])
+    }
+    messages := doc.Channels.Room.Messages
+    for _, name := range sortedKeys(messages) {
+        msg := messages[name]
+        pyClass(&b, pascal(name)+"Data", msg.Summary, dataSchema(msg))
+    }
+    
+    set := func(title string, from func(message) bool) {
+        fmt.Fprintf(&b, "\n%s = frozenset({\n", title)
+        for _, name := range sortedKeys(messages) {
+            if from(messages[name]) {
+                fmt.Fprintf(&b, "    %q,\n", name)
+            }
+        }
+        fmt.Fprintf(&b, "})\n")
+    }
+    fmt.Fprintf(&b, "\n# The types clients may send, and those they may receive: the server's own\n# and those relayed from other participants.")
+    set("CLIENT_MESSAGE_TYPES", func(m message) bool { return m.Direction != "server" })
+    set("SERVER_MESSAGE_TYPES", func(m message) bool { return m.Direction != "client" })
+    
+    fmt.Fprintf(&b, "\n# The permission sending each message type takes, see the server's roles.\n")
+    fmt.Fprintf(&b, "MESSAGE_PERMISSIONS: Dict[str, str] = {\n")
+    for _, name := range sortedKeys(messages) {
+        if perm := messages[name].Permission; perm != "" {
+            fmt.Fprintf(&b, "    %q: %q,\n", name, perm)
+        }
+    }
+    fmt.Fprintf(&b, "}\n\n")
+    fmt.Fprintf(&b, "DATA_TYPES: Dict[str, type] = {\n")
+    for _, name := range sortedKeys(messages) {
+        fmt.Fprintf(&b, "    %q: %sData,\n", name, pascal(name))
+    }
+    fmt.Fprintf(&b, "}\n")
+    
+    fmt.Fprintf(&b, "\n# The codes the server closes connections with, the reason starting with the name.\n")
+    fmt.Fprintf(&b, "CLOSE_CODES: Dict[int, str] = {\n")
+    for _, code := range doc.CloseCodes {
+        fmt.Fprintf(&b, "    %d: %q,  # %s\n", code.Code, code.Name, code.Summary)
+    }
+    fmt.Fprintf(&b, "}\n\n")
+    fmt.Fprintf(&b, "# The close codes after which reconnecting can help.\n")
+    fmt.Fprintf(&b, "RETRY_CLOSE_CODES = frozenset({")
+    for i, code := range retryCodes(doc) {
+        if i > 0 {
+            b.WriteString(", ")
+        }
+        fmt.Fprintf(&b, "%d", code)
+    }
+    fmt.Fprintf(&b, "})\n")
+    return b.Bytes()
+}
+
+func retryCodes(doc *document) []int {
+    var codes []int
+    for _, code := range doc.CloseCodes {
+        if code.Retry {
+            codes = append(codes, code.Code)
+        }
+    }
+    return codes
+}
+
+// pythonKeywords are the keywords a field may be named, which the class
+// syntax cannot declare.
+var pythonKeywords = map[string]bool{"from": true, "class": true, "import": true, "global": true, "in": true, "is": true, "not": true, "pass": true, "return": true}
+
+// pyClass writes a TypedDict, its required keys in a base class when it has
+// any, e.g. Message's.
+func pyClass(b *bytes.Buffer, name, summary string, s *schema) {
+    required := make(map[string]bool)
+    var fields []string
+    if s != nil {
+        for _, field := range s.Required {
+            required[field] = true
+        }
+        fields = sortedKeys(s.Properties)
+    }
+    write := func(name, base string, total bool, doc string, want bool) {
+        var keys []string
+        keyword := false
+        for _, field := range fields {
+            if required[field] == want {
+                keys = append(keys, field)
+                keyword = keyword || pythonKeywords[field]
+            }
+        }
+        if keyword {
+            fmt.Fprintf(b, "\n\n%s = TypedDict(%q, {\n", name, name)
+            for _, field := range keys {
+                fmt.Fprintf(b, "    %q: %s,\n", field, pyType(s.Properties[field]))
+            }
+            fmt.Fprintf(b, "}, total=%s)\n", map[bool]string{true: "True", false: "False"}[total])
+            return
+        }
+        if total {
+            fmt.Fprintf(b, "\n\nclass %s(%s):\n", name, base)
+        } else {
+            fmt.Fprintf(b, "\n\nclass %s(%s, total=False):\n", name, base)
+        }
+        if doc != "" {
+            fmt.Fprintf(b, "    \"\"\"%s\"\"\"\n", doc)
+        }
+        for _, field := range keys {
+            fmt.Fprintf(b, "    %s: %s\n", field, pyType(s.Properties[field]))
+        }
+        if doc == "" && len(keys) == 0 {
+            fmt.Fprintf(b, "    pass\n")
+        }
+    }
+    base := "TypedDict"
+    if len(required) > 0 {
+        base = "_" + name + "Required"
+        write(base, "TypedDict", true, "", true)
+    }
+    write(name, base, false, summary, false)
+}
+
+func pyType(s *schema) string {
+    if s == nil {
+        return "Any"
+    }
+    if s.Ref != "" {
+        return "\"" + s.Ref[strings.LastIndex(s.Ref, "/")+1:] + "\""
+    }
+    switch s.Type {
+    case "string":
+        return "str"
+    case "integer":
+        return "int"
+    case "number":
+        return "float"
+    case "boolean":
+        return "bool"
+    case "array":
+        return "List[" + pyType(s.Items) + "]"
+    case "object":
+        if s.AdditionalProperties != nil {
+            return "Dict[str, " + pyType(s.AdditionalProperties) + "]"
+        }
+        return "Dict[str, Any]"
+    }
+    return "Any"
+}
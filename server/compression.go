@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Compression is negotiated per connection (permessage-deflate) with
+// -ws-compression, for clients that offer it, but toggled per frame: JSON
+// messages of -ws-compression-threshold bytes or more are compressed, and
+// audio only with -ws-compress-audio. Small frames cost more CPU to deflate
+// than the bytes they save, and pcm16 hardly shrinks.
+
+// checkCompression validates the compression flags.
+func checkCompression() error {
+    if cfg.WSCompressionLevel < 1 || cfg.WSCompressionLevel > 9 {
+        return fmt.Errorf("-ws-compression-level must be 1 (fastest) to 9 (smallest)")
+    }
+    if cfg.WSCompressionThreshold < 0 {
+        return fmt.Errorf("-ws-compression-threshold must not be negative")
+    }
+    return nil
+}
+
+func configureCompression(conn *websocket.Conn) {
+    if !cfg.WSCompression {
+        return
+    }
+    conn.SetCompressionLevel(cfg.WSCompressionLevel)
+}
+
+// compressFrame decides whether a frame of size bytes is compressed, on
+// connections that negotiated it.
+func compressFrame(messageType int, size int) bool {
+    switch {
+    case !cfg.WSCompression || size < cfg.WSCompressionThreshold:
+        return false
+    case messageType == websocket.BinaryMessage:
+        return cfg.WSCompressAudio
+    }
+    return true
+}
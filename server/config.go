@@ -0,0 +1,866 @@
+package main
+
+import (
+    "flag"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/watermark"
+)
+
+type Config struct {
+    Role       string
+    Addr       string
+    PidFile    string
+    UpgradeTimeout time.Duration
+    ShutdownTimeout time.Duration
+    AdminToken string
+    ObserverTokensFile string
+    RoomAPIAuth bool
+    PermissionsFile string
+    MaxRoomParticipants int
+    APIDocs    bool
+    DemoPage   bool
+    
+    WSCompression          bool
+    WSCompressionLevel     int
+    WSCompressionThreshold int // Bytes
+    WSCompressAudio        bool
+    
+    DefaultTTSVoice          string
+    TTSVoices                []string // TEMPLATE=VOICE pairs
+    DefaultLocale            string
+    TemplateLocales          []string // TEMPLATE=LOCALE pairs
+    MessageCatalog           string
+    RecordingConsentRequired bool
+    
+    AudioQueueSize   int
+    ControlQueueSize int
+    BulkMessageBytes int
+    SendQueueOverflow string
+    WriteTimeout     time.Duration
+    SendBlockTimeout time.Duration
+    PingInterval     time.Duration
+    PongTimeout      time.Duration
+    
+    AudioFrameMs     int
+    AudioPacerFrames int
+    
+    PublicAddress string
+    TicketSecret  string
+    TicketTTL     time.Duration
+    
+    TLSCert            string
+    TLSKey             string
+    TLSAutocertDomains []string
+    TLSAutocertCache   string
+    TLSAutocertEmail   string
+    TLSACMEDirectory   string
+    TLSHTTPAddr        string
+    
+    RegistryAddr          string
+    RegistryTLSCert       string
+    RegistryTLSKey        string
+    RegistryClientCA      string
+    RegistryTrustDomain   string
+    RegistryBindAddress   bool
+    RegistryHealthyWindow time.Duration
+    RegistryURL           string
+    RegistryClientCert    string
+    RegistryClientKey     string
+    Region                string
+    DrainTimeout          time.Duration
+    FailoverAfter         time.Duration
+    FailoverCheckpoints   bool
+    
+    TrustProxy     bool
+    ProxyHops      int // Proxies appending to X-Forwarded-For under -trust-proxy
+    MaxConnsPerIP  int
+    ConnRatePerIP  float64
+    ConnBurstPerIP int
+    IPAllow        []string
+    IPDeny         []string
+    AllowedOrigins []string
+    OriginsFile    string
+    
+    MessageRate      float64
+    MessageRates     []string
+    AudioByteRate    float64
+    AudioByteRates   []string
+    RoomMessageRate  float64
+    RateLimitStrikes int
+    RateLimitWindow  time.Duration
+    
+    MetadataSchemaFile    string
+    MaxMetadataKeys       int
+    MaxMetadataValueBytes int
+    MaxFrameBytes         int64
+    MaxMessageBytes       int
+    MaxRecipients         int
+    ProtectedMetadataKeys []string
+    PublicMetadataKeys    []string
+    
+    ResumeGrace          time.Duration
+    DuplicateIdPolicy    string
+    DuplicateIdTenants   []string
+    DuplicateIdTemplates []string
+    OfflineQueueMessages int
+    OfflineMessageTTL    time.Duration
+    JournalMaxEvents     int
+    
+    OutboxFile       string
+    OutboxMaxBackoff time.Duration
+    WebhookURL       string
+    WebhookSecret    string
+    KafkaRESTURL     string
+    KafkaTopic       string
+    
+    StorageDSN       string
+    DurableWriters   int           // Writers draining durable writes, each one room at a time in order
+    DurableWriteWait time.Duration // How long a durable write waits for a full queue before it is dropped
+    
+    TTSProvider          string
+    TTSTemplateProviders []string
+    TTSStyles            []string
+    TTSSpeeds            []string
+    TTSSampleRate        int
+    TTSPrices            []string
+    TTSVoicesTTL         time.Duration
+    TTSCache             string
+    TTSCacheBytes        int
+    TTSCacheMaxEntry     int
+    TTSCacheTTL          time.Duration
+    AzureSpeechRegion    string
+    AzureSpeechKey       string
+    GoogleTTSAPIKey      string
+    PiperCommand         string
+    PiperVoicesDir       string
+    
+    STTProvider          string
+    STTTenantProviders   []string
+    STTTemplateProviders []string
+    STTLanguage          string
+    STTPartialInterval   time.Duration
+    STTPrices            []string
+    STTVocabularyFile    string
+    TranscriptWorkers    int
+    TranscriptQueueSize  int
+    ClipsDir             string
+    ClipBuffer           time.Duration
+    ClipsRetention       time.Duration
+    WhisperURL           string
+    WhisperAPIKey        string
+    WhisperModel         string
+    DeepgramURL          string
+    DeepgramAPIKey       string
+    DeepgramModel        string
+    GoogleSTTAPIKey      string
+    GoogleSTTModel       string
+    
+    DemoAgent           string
+    DemoAgentDelay      time.Duration
+    DemoAgentPhrases    string
+    DemoAgentSampleRate int
+    DemoAgentTurnGap    time.Duration
+    
+    TraceMaxDuration time.Duration
+    TraceMaxEvents   int
+    TraceRetention   time.Duration
+    
+    CitationsToUsers bool
+    MaxCitations     int
+    AnswerCacheSize  int
+    AnswerCacheTTL   time.Duration
+    ShadowLog        string
+    ShadowMaxTurns   int
+    KnowledgeGapsUncited bool
+    KnowledgeGapHistory  int
+    KGAutoApproveConfidence float64
+    KGReviewCategories      []string
+    KGTenantPartitions      []string
+    KGTemplatePartitions    []string
+    KGSnapshot              string
+    
+    SegmentMinUtterances  int
+    SegmentWindow         int
+    SegmentMinLength      int
+    SegmentTimeout        time.Duration
+    SegmentURL            string
+    SegmentAPIKey         string
+    SegmentEmbeddingModel string
+    SegmentChatModel      string
+    
+    QualityURL           string
+    QualityAPIKey        string
+    QualityModel         string
+    QualityTimeout       time.Duration
+    QualitySampleRate    float64
+    QualityMinUtterances int
+    QualityLowScore      float64
+    
+    RoomMaxDuration  time.Duration
+    RoomMaxAudio     time.Duration
+    RoomMaxLLMTokens int
+    RoomQuotas       []string // [TEMPLATE/]QUOTA=LIMIT overrides
+    RoomQuotaWarnAt  float64
+    RoomQuotaGrace   time.Duration
+    
+    LLMPrices      []string // MODEL=USD_PER_MILLION_TOKENS, INPUT/OUTPUT or one price
+    TelephonyPrice float64  // USD per connected outbound minute
+    CostAlerts     []string // USD amounts of per-call spend to alert at
+    
+    VerifyKBAURL         string
+    VerifyKBAQuestions   int
+    VerifyKBAMinCorrect  int
+    VerifySMSWebhook     string
+    VerifyOTPLength      int
+    VerifyOTPTTL         time.Duration
+    VerifyOTPMessage     string
+    VerifyVoiceThreshold float64
+    VerifyAPIKey         string
+    VerifyMaxAttempts    int
+    VerifyTimeout        time.Duration
+    VerifyTTL            time.Duration
+    SensitiveTools       []string
+    
+    SpeakerURL               string
+    SpeakerAPIKey            string
+    SpeakerSampleSeconds     int
+    SpeakerIdentifyAfter     int
+    SpeakerMatchThreshold    float64
+    SpeakerEnrollSeconds     int
+    SpeakerAutoEnroll        bool
+    
+    BreakerThreshold   int
+    BreakerCooldown    time.Duration
+    FallbackPolicy     string
+    FallbackPolicies   []string
+    FallbackPromptsDir string
+    
+    CallbackAbandonAfter time.Duration
+    CallbackLease        time.Duration
+    CallbackMaxAttempts  int
+    CallbackRetryDelay   time.Duration
+    CallbackHistory      int
+    
+    ConsultHoldPrompt string
+    
+    HoldAssetsDir     string
+    HoldInterval      time.Duration
+    HoldQueueAfter    time.Duration
+    HoldThinkingAfter time.Duration
+    
+    TelephonyURL        string
+    TelephonyAPIKey     string
+    TelephonyFrom       string
+    PublicURL           string
+    AgentJoinURL        string
+    OutboundRingTimeout time.Duration
+    OutboundSampleRate  int
+    AMDMode             string
+    AMDAnalysisTime     time.Duration
+    AMDSilenceThreshold float64
+    
+    AgentWorkers  []string // ID=JOIN_URL@CAPACITY
+    AssignTimeout time.Duration
+    RouteInterval time.Duration
+    
+    PoolWebhook          string
+    PoolSpawnCommand     string
+    PoolTerminateCommand string
+    PoolJoinURL          string
+    PoolWorkerCapacity   int
+    PoolMinWorkers       int
+    PoolMaxWorkers       int
+    PoolQueueDepth       int
+    PoolIdleTimeout      time.Duration
+    PoolInterval         time.Duration
+    
+    WrapUpTime       time.Duration
+    DispositionCodes []string
+    
+    RecordingMaxPause     time.Duration
+    RecordingPauseIntents []string
+    
+    SecureCaptureWebhook string
+    SecureCaptureTimeout time.Duration
+    
+    WatermarkKey           string
+    WatermarkStrength      float64
+    WatermarkMachineAgents []string
+    
+    LatencyTarget         time.Duration
+    LatencyWindow         time.Duration
+    LatencyVoiceThreshold float64
+    LatencyTTSProvider    string
+    LatencyTTSVoice       string
+    LatencyModel          string
+    LatencyMaxContext     int
+    BargeInTime           time.Duration
+    
+    ProfanityPolicy         string
+    ProfanityTenantPolicies []string
+    ProfanityWordsFile      string
+    
+    ModerationRulesFile   string
+    ModerationProviders   []string
+    ModerationThreshold   float64
+    ModerationOnFlagged   string
+    ModerationTranscripts bool
+    ModerationFallback    string
+    ModerationFailClosed  bool
+    ModerationTimeout     time.Duration
+    OpenAIModerationURL   string
+    OpenAIAPIKey          string
+    OpenAIModerationModel string
+    ContentSafetyURL      string
+    ContentSafetyKey      string
+    
+    MetricsToken         string
+    MetricsTenants       []string
+    MetricsMaxTenants    int
+    MetricsTemplateLabel bool
+    MetricsMaxTemplates  int
+    
+    AnalyticsFlush   time.Duration
+    SLAFirstResponse time.Duration
+    
+    OpenSearchURL       string
+    OpenSearchUser      string
+    OpenSearchPassword  string
+    OpenSearchIndex     string
+    OpenSearchBatchSize int
+    OpenSearchQueueSize int
+    
+    HistoryMaxMessages int
+    HistoryRetention   time.Duration
+    
+    MaxFileBytes      int64
+    MaxFileStoreBytes int64
+    FileRetention     time.Duration
+}
+
+var cfg = Config{
+    Role:                  RoleAll,
+    Addr:                  ":8080",
+    UpgradeTimeout:        30 * time.Second,
+    ShutdownTimeout:       15 * time.Second,
+    MaxMetadataKeys:       32,
+    MaxMetadataValueBytes: 1024,
+    MaxFrameBytes:         1 << 20,
+    MaxMessageBytes:       64 << 10,
+    MaxRecipients:         64,
+    WSCompressionLevel:    1,
+    WSCompressionThreshold: 512,
+    DefaultTTSVoice:       "en-US-AriaNeural",
+    DefaultLocale:         "en-US",
+    TLSAutocertCache:      "autocert-cache",
+    AudioQueueSize:        256,
+    ControlQueueSize:      256,
+    BulkMessageBytes:      16 << 10,
+    SendQueueOverflow:     OverflowBlock,
+    SendBlockTimeout:      250 * time.Millisecond,
+    WriteTimeout:          10 * time.Second,
+    PingInterval:          25 * time.Second,
+    PongTimeout:           60 * time.Second,
+    AudioFrameMs:          20,
+    AudioPacerFrames:      50,
+    TicketTTL:             time.Minute,
+    RegistryHealthyWindow: 30 * time.Second,
+    RegistryBindAddress:   true,
+    DrainTimeout:          10 * time.Minute,
+    ProxyHops:             1,
+    MaxConnsPerIP:         20,
+    ConnRatePerIP:         2,
+    ConnBurstPerIP:        10,
+    MessageRate:           20,
+    AudioByteRate:         256 << 10,
+    RoomMessageRate:       200,
+    RateLimitStrikes:      5,
+    RateLimitWindow:       time.Minute,
+    ProtectedMetadataKeys: []string{"role", "verified", "verifiedIdentity"},
+    PublicMetadataKeys:    []string{"role", "language", "capabilities"},
+    ResumeGrace:           2 * time.Minute,
+    DuplicateIdPolicy:     "replace",
+    OfflineQueueMessages:  100,
+    OfflineMessageTTL:     2 * time.Minute,
+    JournalMaxEvents:      1000,
+    TTSSampleRate:         16000,
+    TTSVoicesTTL:          time.Hour,
+    TTSCacheBytes:         64 << 20,
+    TTSCacheMaxEntry:      2 << 20,
+    TTSCacheTTL:           24 * time.Hour,
+    PiperCommand:          "piper",
+    STTLanguage:           "en-US",
+    STTPartialInterval:    time.Second,
+    TranscriptWorkers:     4,
+    TranscriptQueueSize:   256,
+    ClipBuffer:            30 * time.Second,
+    ClipsRetention:        30 * 24 * time.Hour,
+    WhisperModel:          "whisper-1",
+    DemoAgentDelay:        3 * time.Second,
+    DemoAgentSampleRate:   16000,
+    DemoAgentTurnGap:      time.Second,
+    TraceMaxDuration:      30 * time.Minute,
+    TraceMaxEvents:        100000,
+    TraceRetention:        time.Hour,
+    MaxCitations:          20,
+    AnswerCacheTTL:        24 * time.Hour,
+    ShadowMaxTurns:        1000,
+    KnowledgeGapHistory:   6,
+    KGAutoApproveConfidence: 0.9,
+    SegmentMinUtterances:  30,
+    SegmentWindow:         3,
+    SegmentMinLength:      6,
+    SegmentTimeout:        time.Minute,
+    SegmentEmbeddingModel: "text-embedding-3-small",
+    SegmentChatModel:      "gpt-4o-mini",
+    QualityModel:          "gpt-4o-mini",
+    QualityTimeout:        time.Minute,
+    QualitySampleRate:     1,
+    QualityMinUtterances:  4,
+    QualityLowScore:       3,
+    RoomQuotaWarnAt:       0.8,
+    RoomQuotaGrace:        15 * time.Second,
+    VerifyKBAQuestions:    2,
+    VerifyKBAMinCorrect:   2,
+    VerifyOTPLength:       6,
+    VerifyOTPTTL:          5 * time.Minute,
+    VerifyVoiceThreshold:  0.8,
+    VerifyMaxAttempts:     3,
+    VerifyTimeout:         5 * time.Second,
+    SpeakerSampleSeconds:  15,
+    SpeakerIdentifyAfter:  4,
+    SpeakerMatchThreshold: 0.85,
+    SpeakerEnrollSeconds:  8,
+    BreakerThreshold:      5,
+    BreakerCooldown:       30 * time.Second,
+    FallbackPolicy:        "canned",
+    CallbackAbandonAfter:  10 * time.Second,
+    CallbackLease:         5 * time.Minute,
+    CallbackMaxAttempts:   3,
+    CallbackRetryDelay:    15 * time.Minute,
+    CallbackHistory:       10,
+    ConsultHoldPrompt:     "Please hold for a moment while I check with a colleague.",
+    HoldInterval:          30 * time.Second,
+    HoldQueueAfter:        2 * time.Second,
+    HoldThinkingAfter:     3 * time.Second,
+    OutboundRingTimeout:   45 * time.Second,
+    OutboundSampleRate:    8000,
+    AMDMode:               "auto",
+    AMDAnalysisTime:       5 * time.Second,
+    AMDSilenceThreshold:   256,
+    AssignTimeout:         30 * time.Second,
+    RouteInterval:         2 * time.Second,
+    PoolWorkerCapacity:    20,
+    PoolMaxWorkers:        10,
+    PoolQueueDepth:        1,
+    PoolIdleTimeout:       5 * time.Minute,
+    PoolInterval:          10 * time.Second,
+    RecordingMaxPause:     10 * time.Minute,
+    SecureCaptureTimeout:  2 * time.Minute,
+    WatermarkStrength:     watermark.DefaultStrength,
+    LatencyWindow:         5 * time.Minute,
+    LatencyVoiceThreshold: 0.01,
+    ModerationThreshold:   0.5,
+    ModerationOnFlagged:   "block",
+    ModerationTimeout:     2 * time.Second,
+    OpenAIModerationURL:   "https://api.openai.com/v1",
+    OpenAIModerationModel: "omni-moderation-latest",
+    MetricsMaxTenants:     100,
+    MetricsMaxTemplates:   20,
+    OpenSearchIndex:       "iva-conversations",
+    AnalyticsFlush:        time.Minute,
+    SLAFirstResponse:      10 * time.Second,
+    OpenSearchBatchSize:   500,
+    OpenSearchQueueSize:   10000,
+    OutboxMaxBackoff:      time.Minute,
+    DurableWriters:        4,
+    DurableWriteWait:      2 * time.Second,
+    KafkaTopic:            "iva.room-events",
+    HistoryMaxMessages:    500,
+    HistoryRetention:      24 * time.Hour,
+    MaxFileBytes:          10 << 20,
+    MaxFileStoreBytes:     256 << 20,
+    FileRetention:         time.Hour,
+}
+
+func envOr(key string, fallback string) string {
+    if value, ok := os.LookupEnv(key); ok {
+        return value
+    }
+    return fallback
+}
+
+func envInt(key string, fallback int) int {
+    if value, ok := os.LookupEnv(key); ok {
+        if n, err := strconv.Atoi(value); err == nil {
+            return n
+        }
+    }
+    return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+    if value, ok := os.LookupEnv(key); ok {
+        if f, err := strconv.ParseFloat(value, 64); err == nil {
+            return f
+        }
+    }
+    return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+    if value, ok := os.LookupEnv(key); ok {
+        if b, err := strconv.ParseBool(value); err == nil {
+            return b
+        }
+    }
+    return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+    if value, ok := os.LookupEnv(key); ok {
+        if d, err := time.ParseDuration(value); err == nil {
+            return d
+        }
+    }
+    return fallback
+}
+
+func splitList(value string) []string {
+    list := make([]string, 0)
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
+}
+
+func loadConfig() {
+    flag.StringVar(&cfg.Role, "role", envOr("SERVER_ROLE", cfg.Role), "Process role: all, media, registry or worker")
+    flag.StringVar(&cfg.Addr, "addr", envOr("SERVER_ADDR", cfg.Addr), "HTTP listen address, HTTPS with -tls-cert or -tls-autocert-domains")
+    flag.StringVar(&cfg.TLSCert, "tls-cert", envOr("TLS_CERT", ""), "Certificate file -addr serves TLS with, reloaded when it changes")
+    flag.StringVar(&cfg.TLSKey, "tls-key", envOr("TLS_KEY", ""), "Private key file of -tls-cert")
+    autocertDomains := flag.String("tls-autocert-domains", envOr("TLS_AUTOCERT_DOMAINS", ""), "Comma separated domains -addr gets Let's Encrypt certificates for (instead of -tls-cert)")
+    flag.StringVar(&cfg.TLSAutocertCache, "tls-autocert-cache", envOr("TLS_AUTOCERT_CACHE", cfg.TLSAutocertCache), "Directory ACME certificates and the account key are kept in")
+    flag.StringVar(&cfg.TLSAutocertEmail, "tls-autocert-email", envOr("TLS_AUTOCERT_EMAIL", ""), "Contact email for the ACME account, told about expiring certificates")
+    flag.StringVar(&cfg.TLSACMEDirectory, "tls-acme-directory", envOr("TLS_ACME_DIRECTORY", ""), "ACME directory URL of a CA other than Let's Encrypt, e.g. its staging environment")
+    flag.StringVar(&cfg.TLSHTTPAddr, "tls-http-addr", envOr("TLS_HTTP_ADDR", ""), "Plain HTTP listen address for ACME HTTP-01 challenges and https redirects, e.g. :80")
+    flag.StringVar(&cfg.PidFile, "pid-file", envOr("PID_FILE", ""), "File the serving process writes its PID to, rewritten by each hot upgrade")
+    flag.DurationVar(&cfg.UpgradeTimeout, "upgrade-timeout", envDuration("UPGRADE_TIMEOUT", cfg.UpgradeTimeout), "How long a hot upgrade (SIGUSR2) waits for the new process, then for the old one's requests")
+    flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout), "How long SIGTERM and SIGINT wait for the closed calls' records to be written before exiting")
+    flag.StringVar(&cfg.AdminToken, "admin-token", envOr("ADMIN_TOKEN", cfg.AdminToken), "Bearer token required by /admin and /broadcast endpoints (empty disables them)")
+    flag.StringVar(&cfg.ObserverTokensFile, "observer-tokens-file", envOr("OBSERVER_TOKENS_FILE", ""), "JSON file of read-only tokens for dashboards, scoped to endpoints and tenants")
+    flag.BoolVar(&cfg.RoomAPIAuth, "room-api-auth", envBool("ROOM_API_AUTH", false), "Require the admin token or an observer token on /rooms and /room/{id}")
+    flag.IntVar(&cfg.MaxRoomParticipants, "max-room-participants", envInt("MAX_ROOM_PARTICIPANTS", cfg.MaxRoomParticipants), "Participants allowed per room (0 is unlimited)")
+    flag.StringVar(&cfg.PermissionsFile, "permissions", envOr("PERMISSIONS_FILE", ""), "JSON file mapping roles to permissions")
+    flag.BoolVar(&cfg.APIDocs, "api-docs", envBool("API_DOCS", false), "Serve Swagger UI for /openapi.json at /docs")
+    flag.BoolVar(&cfg.DemoPage, "demo-page", envBool("DEMO_PAGE", false), "Serve a browser demo call with mic capture, captions and chat at /demo/")
+    flag.BoolVar(&cfg.WSCompression, "ws-compression", envBool("WS_COMPRESSION", cfg.WSCompression), "Negotiate permessage-deflate and compress JSON messages")
+    flag.IntVar(&cfg.WSCompressionLevel, "ws-compression-level", envInt("WS_COMPRESSION_LEVEL", cfg.WSCompressionLevel), "Deflate level, 1 (fastest) to 9 (smallest)")
+    flag.IntVar(&cfg.WSCompressionThreshold, "ws-compression-threshold", envInt("WS_COMPRESSION_THRESHOLD", cfg.WSCompressionThreshold), "Messages smaller than this many bytes are sent uncompressed")
+    flag.BoolVar(&cfg.WSCompressAudio, "ws-compress-audio", envBool("WS_COMPRESS_AUDIO", cfg.WSCompressAudio), "Also compress binary audio frames")
+    flag.StringVar(&cfg.DefaultTTSVoice, "tts-voice", envOr("TTS_VOICE", cfg.DefaultTTSVoice), "TTS voice announced to rooms without a template voice")
+    ttsVoices := flag.String("tts-voices", envOr("TTS_VOICES", ""), "Comma separated TEMPLATE=VOICE overrides")
+    flag.StringVar(&cfg.DefaultLocale, "locale", envOr("LOCALE", cfg.DefaultLocale), "Locale of rooms opened without ?locale= and without a template locale")
+    templateLocales := flag.String("locales", envOr("LOCALES", ""), "Comma separated TEMPLATE=LOCALE overrides of -locale")
+    flag.StringVar(&cfg.MessageCatalog, "message-catalog", envOr("MESSAGE_CATALOG", ""), "JSON file of system prompts and a TTS voice per locale")
+    flag.BoolVar(&cfg.RecordingConsentRequired, "recording-consent-required", envBool("RECORDING_CONSENT_REQUIRED", cfg.RecordingConsentRequired), "Tell clients participants must consent before recording")
+    flag.IntVar(&cfg.AudioQueueSize, "audio-queue-size", envInt("AUDIO_QUEUE_SIZE", cfg.AudioQueueSize), "Audio frames queued per client")
+    flag.IntVar(&cfg.ControlQueueSize, "control-queue-size", envInt("CONTROL_QUEUE_SIZE", cfg.ControlQueueSize), "Control and bulk messages queued per client")
+    flag.IntVar(&cfg.BulkMessageBytes, "bulk-message-bytes", envInt("BULK_MESSAGE_BYTES", cfg.BulkMessageBytes), "JSON messages larger than this are sent at bulk priority")
+    flag.StringVar(&cfg.SendQueueOverflow, "send-queue-overflow", envOr("SEND_QUEUE_OVERFLOW", cfg.SendQueueOverflow), "What a full send queue does to messages: block for -send-block-timeout, drop them, or close the client so it resumes with a replay (audio drops its oldest frame)")
+    flag.DurationVar(&cfg.SendBlockTimeout, "send-block-timeout", envDuration("SEND_BLOCK_TIMEOUT", cfg.SendBlockTimeout), "Longest a message waits for room in a full send queue under -send-queue-overflow block, then the client is closed")
+    flag.DurationVar(&cfg.WriteTimeout, "write-timeout", envDuration("WRITE_TIMEOUT", cfg.WriteTimeout), "Longest a WebSocket write may take before the client is disconnected (0 waits forever)")
+    flag.DurationVar(&cfg.PingInterval, "ping-interval", envDuration("PING_INTERVAL", cfg.PingInterval), "How often every WebSocket is pinged (0 sends no pings)")
+    flag.DurationVar(&cfg.PongTimeout, "pong-timeout", envDuration("PONG_TIMEOUT", cfg.PongTimeout), "Silence after which a client is taken for dead and removed, reset by pongs and messages (0 never times out)")
+    flag.IntVar(&cfg.AudioFrameMs, "audio-frame-ms", envInt("AUDIO_FRAME_MS", cfg.AudioFrameMs), "Frame size PCM audio is coalesced into, 20 or 40 (0 disables)")
+    flag.IntVar(&cfg.AudioPacerFrames, "audio-pacer-frames", envInt("AUDIO_PACER_FRAMES", cfg.AudioPacerFrames), "Paced frames buffered per subscriber before dropping")
+    flag.StringVar(&cfg.PublicAddress, "public-address", envOr("PUBLIC_ADDRESS", ""), "host:port this media server is registered as, checked against allocation tickets")
+    flag.StringVar(&cfg.TicketSecret, "ticket-secret", envOr("TICKET_SECRET", ""), "Shared HMAC secret for allocation tickets (enables ticket checks on /ws)")
+    flag.DurationVar(&cfg.TicketTTL, "ticket-ttl", envDuration("TICKET_TTL", cfg.TicketTTL), "Lifetime of allocation tickets")
+    flag.StringVar(&cfg.RegistryAddr, "registry-addr", envOr("REGISTRY_ADDR", ""), "Listen address for the mTLS registry endpoints (/register, /heartbeat)")
+    flag.StringVar(&cfg.RegistryTLSCert, "registry-tls-cert", envOr("REGISTRY_TLS_CERT", ""), "Registry listener certificate")
+    flag.StringVar(&cfg.RegistryTLSKey, "registry-tls-key", envOr("REGISTRY_TLS_KEY", ""), "Registry listener private key")
+    flag.StringVar(&cfg.RegistryClientCA, "registry-client-ca", envOr("REGISTRY_CLIENT_CA", ""), "CA bundle media server certificates must chain to (enables mTLS)")
+    flag.StringVar(&cfg.RegistryTrustDomain, "registry-trust-domain", envOr("REGISTRY_TRUST_DOMAIN", ""), "Require a spiffe://DOMAIN/... URI SAN in media server certificates")
+    flag.DurationVar(&cfg.RegistryHealthyWindow, "registry-healthy-window", envDuration("REGISTRY_HEALTHY_WINDOW", cfg.RegistryHealthyWindow), "How recently a server must have heartbeated to be listed as healthy")
+    flag.StringVar(&cfg.RegistryURL, "registry-url", envOr("REGISTRY_URL", ""), "Registry a -role media server registers -public-address with, e.g. https://registry:8443")
+    flag.DurationVar(&cfg.FailoverAfter, "failover-after", envDuration("FAILOVER_AFTER", cfg.FailoverAfter), "Heartbeat silence after which the registry recreates a media server's rooms elsewhere (0 disables)")
+    flag.BoolVar(&cfg.FailoverCheckpoints, "failover-checkpoints", envBool("FAILOVER_CHECKPOINTS", cfg.FailoverCheckpoints), "Send the registry a checkpoint of every room with each heartbeat, for -failover-after")
+    flag.DurationVar(&cfg.DrainTimeout, "drain-timeout", envDuration("DRAIN_TIMEOUT", cfg.DrainTimeout), "How long a draining server lets its calls run before moving them elsewhere, unless POST /admin/drain says")
+    flag.StringVar(&cfg.RegistryClientCert, "registry-client-cert", envOr("REGISTRY_CLIENT_CERT", ""), "Certificate a media server presents to an mTLS registry")
+    flag.StringVar(&cfg.RegistryClientKey, "registry-client-key", envOr("REGISTRY_CLIENT_KEY", ""), "Private key of -registry-client-cert")
+    flag.StringVar(&cfg.Region, "region", envOr("REGION", ""), "Region a media server registers in, see /v1/list?region=")
+    flag.BoolVar(&cfg.RegistryBindAddress, "registry-bind-address", envBool("REGISTRY_BIND_ADDRESS", cfg.RegistryBindAddress), "Only accept registrations for addresses the certificate is valid for")
+    flag.BoolVar(&cfg.TrustProxy, "trust-proxy", envBool("TRUST_PROXY", cfg.TrustProxy), "Take client addresses from X-Forwarded-For")
+    flag.IntVar(&cfg.ProxyHops, "proxy-hops", envInt("PROXY_HOPS", cfg.ProxyHops), "Trusted proxies in front of the server under -trust-proxy; the client address is the X-Forwarded-For entry this many from the right")
+    flag.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", envInt("MAX_CONNS_PER_IP", cfg.MaxConnsPerIP), "Concurrent WebSocket connections allowed per address (0 is unlimited)")
+    flag.Float64Var(&cfg.ConnRatePerIP, "conn-rate-per-ip", envFloat("CONN_RATE_PER_IP", cfg.ConnRatePerIP), "New WebSocket connections per second per address (0 is unlimited)")
+    flag.IntVar(&cfg.ConnBurstPerIP, "conn-burst-per-ip", envInt("CONN_BURST_PER_IP", cfg.ConnBurstPerIP), "Connection burst allowed per address")
+    flag.Float64Var(&cfg.MessageRate, "message-rate", envFloat("MESSAGE_RATE", cfg.MessageRate), "JSON messages a second a client may send, in bursts of twice that (0 is unlimited)")
+    messageRates := flag.String("message-rates", envOr("MESSAGE_RATES", ""), "Comma separated CLIENT_TYPE=RATE overrides of -message-rate, e.g. agent=50")
+    flag.Float64Var(&cfg.AudioByteRate, "audio-byte-rate", envFloat("AUDIO_BYTE_RATE", cfg.AudioByteRate), "Audio bytes a second a client may send, in bursts of twice that (0 is unlimited)")
+    audioByteRates := flag.String("audio-byte-rates", envOr("AUDIO_BYTE_RATES", ""), "Comma separated CLIENT_TYPE=RATE overrides of -audio-byte-rate")
+    flag.Float64Var(&cfg.RoomMessageRate, "room-message-rate", envFloat("ROOM_MESSAGE_RATE", cfg.RoomMessageRate), "JSON messages a second all of a room's clients may send together (0 is unlimited)")
+    flag.IntVar(&cfg.RateLimitStrikes, "rate-limit-strikes", envInt("RATE_LIMIT_STRIKES", cfg.RateLimitStrikes), "Rate limit warnings within -rate-limit-window that close a client (0 never closes)")
+    flag.DurationVar(&cfg.RateLimitWindow, "rate-limit-window", envDuration("RATE_LIMIT_WINDOW", cfg.RateLimitWindow), "How long a rate limit warning counts against a client")
+    ipAllow := flag.String("ip-allow", envOr("IP_ALLOW", ""), "Comma separated addresses or CIDRs allowed to connect (empty allows all)")
+    ipDeny := flag.String("ip-deny", envOr("IP_DENY", ""), "Comma separated addresses or CIDRs refused")
+    allowedOrigins := flag.String("allowed-origins", envOr("ALLOWED_ORIGINS", ""), "Comma separated browser origins allowed to connect, e.g. https://app.example.com,*.example.com (empty allows only the server's own pages, * allows all)")
+    flag.StringVar(&cfg.OriginsFile, "origins-file", envOr("ORIGINS_FILE", ""), "JSON file of allowed origins per room template (\"_default\" adds to -allowed-origins)")
+    flag.StringVar(&cfg.MetadataSchemaFile, "metadata-schemas", envOr("METADATA_SCHEMA_FILE", ""), "JSON file with per-tenant client metadata schemas")
+    flag.IntVar(&cfg.MaxMetadataKeys, "max-metadata-keys", envInt("MAX_METADATA_KEYS", cfg.MaxMetadataKeys), "Maximum number of metadata keys per client")
+    flag.IntVar(&cfg.MaxMetadataValueBytes, "max-metadata-value-bytes", envInt("MAX_METADATA_VALUE_BYTES", cfg.MaxMetadataValueBytes), "Maximum JSON size of a single metadata value")
+    flag.Int64Var(&cfg.MaxFrameBytes, "max-frame-bytes", int64(envInt("MAX_FRAME_BYTES", int(cfg.MaxFrameBytes))), "Largest WebSocket frame read, text or audio, bigger ones close the connection with 1009 (0 is unlimited)")
+    flag.IntVar(&cfg.MaxMessageBytes, "max-message-bytes", envInt("MAX_MESSAGE_BYTES", cfg.MaxMessageBytes), "Largest JSON message a client may send, bigger ones are refused with message_too_large (0 is unlimited)")
+    flag.IntVar(&cfg.MaxRecipients, "max-recipients", envInt("MAX_RECIPIENTS", cfg.MaxRecipients), "Most clients a message's to list may name (0 is unlimited)")
+    protectedKeys := flag.String("protected-metadata-keys", envOr("PROTECTED_METADATA_KEYS", strings.Join(cfg.ProtectedMetadataKeys, ",")), "Comma separated metadata keys only the server may set")
+    publicKeys := flag.String("public-metadata-keys", envOr("PUBLIC_METADATA_KEYS", strings.Join(cfg.PublicMetadataKeys, ",")), "Comma separated metadata keys shared with other participants")
+    flag.DurationVar(&cfg.ResumeGrace, "resume-grace", envDuration("RESUME_GRACE", cfg.ResumeGrace), "How long a disconnected client still counts as a room member")
+    flag.StringVar(&cfg.DuplicateIdPolicy, "duplicate-client-ids", envOr("DUPLICATE_CLIENT_IDS", cfg.DuplicateIdPolicy), "What joining with a clientId already in the room does: reject, replace (the old connection) or suffix (the new ID)")
+    duplicateTenants := flag.String("duplicate-client-id-tenants", envOr("DUPLICATE_CLIENT_ID_TENANTS", ""), "Comma separated TENANT=POLICY overrides of -duplicate-client-ids")
+    duplicateTemplates := flag.String("duplicate-client-id-templates", envOr("DUPLICATE_CLIENT_ID_TEMPLATES", ""), "Comma separated TEMPLATE=POLICY overrides, winning over the tenant's")
+    flag.IntVar(&cfg.OfflineQueueMessages, "offline-queue-messages", envInt("OFFLINE_QUEUE_MESSAGES", cfg.OfflineQueueMessages), "Selective messages queued per disconnected client (0 disables queuing)")
+    flag.DurationVar(&cfg.OfflineMessageTTL, "offline-message-ttl", envDuration("OFFLINE_MESSAGE_TTL", cfg.OfflineMessageTTL), "How long a queued message waits for its target to reconnect")
+    flag.IntVar(&cfg.JournalMaxEvents, "journal-max-events", envInt("JOURNAL_MAX_EVENTS", cfg.JournalMaxEvents), "Room events kept for reconnect replay")
+    flag.StringVar(&cfg.OutboxFile, "outbox-file", envOr("OUTBOX_FILE", ""), "Durable log of lifecycle events not yet published (empty keeps them in memory)")
+    flag.DurationVar(&cfg.OutboxMaxBackoff, "outbox-max-backoff", envDuration("OUTBOX_MAX_BACKOFF", cfg.OutboxMaxBackoff), "Longest wait between publish retries")
+    flag.StringVar(&cfg.WebhookURL, "webhook-url", envOr("WEBHOOK_URL", ""), "URL room lifecycle events are POSTed to")
+    flag.StringVar(&cfg.WebhookSecret, "webhook-secret", envOr("WEBHOOK_SECRET", ""), "Secret for the X-IVA-Signature HMAC on webhook bodies")
+    flag.StringVar(&cfg.KafkaRESTURL, "kafka-rest-url", envOr("KAFKA_REST_URL", ""), "Kafka REST Proxy base URL room lifecycle events are produced through")
+    flag.StringVar(&cfg.KafkaTopic, "kafka-topic", envOr("KAFKA_TOPIC", cfg.KafkaTopic), "Kafka topic for room lifecycle events")
+    flag.StringVar(&cfg.StorageDSN, "storage-dsn", envOr("STORAGE_DSN", ""), "postgres:// DSN for durable data (empty keeps it in memory)")
+    flag.IntVar(&cfg.DurableWriters, "durable-writers", envInt("DURABLE_WRITERS", cfg.DurableWriters), "Concurrent writers of CDRs, transcripts and other durable records; a room's writes stay in order on one")
+    flag.DurationVar(&cfg.DurableWriteWait, "durable-write-wait", envDuration("DURABLE_WRITE_WAIT", cfg.DurableWriteWait), "How long a durable write waits for room in a full queue, holding up its caller, before it is dropped")
+    flag.StringVar(&cfg.TTSProvider, "tts-provider", envOr("TTS_PROVIDER", ""), "Default text-to-speech provider for speak: azure, google or piper (empty disables)")
+    ttsTemplates := flag.String("tts-template-providers", envOr("TTS_TEMPLATE_PROVIDERS", ""), "Comma separated TEMPLATE=PROVIDER overrides")
+    ttsStyles := flag.String("tts-styles", envOr("TTS_STYLES", ""), "Comma separated TEMPLATE=STYLE speaking styles, e.g. support=customerservice")
+    ttsSpeeds := flag.String("tts-speeds", envOr("TTS_SPEEDS", ""), "Comma separated TEMPLATE=SPEED rates, 1 is normal")
+    flag.IntVar(&cfg.TTSSampleRate, "tts-sample-rate", envInt("TTS_SAMPLE_RATE", cfg.TTSSampleRate), "Sample rate of synthesized pcm16 sent to users")
+    ttsPrices := flag.String("tts-prices", envOr("TTS_PRICES", ""), "Comma separated PROVIDER=USD_PER_MILLION_CHARACTERS used for the cost metric")
+    flag.DurationVar(&cfg.TTSVoicesTTL, "tts-voices-ttl", envDuration("TTS_VOICES_TTL", cfg.TTSVoicesTTL), "How long each provider's voice catalog is cached for /voices")
+    flag.StringVar(&cfg.TTSCache, "tts-cache", envOr("TTS_CACHE", ""), "Cache synthesized audio: memory or redis://[:PASSWORD@]HOST:PORT[/DB] (empty disables)")
+    flag.IntVar(&cfg.TTSCacheBytes, "tts-cache-bytes", envInt("TTS_CACHE_BYTES", cfg.TTSCacheBytes), "Audio bytes the memory cache holds before evicting the least recently used")
+    flag.IntVar(&cfg.TTSCacheMaxEntry, "tts-cache-max-entry", envInt("TTS_CACHE_MAX_ENTRY", cfg.TTSCacheMaxEntry), "Longest utterance in bytes worth caching")
+    flag.DurationVar(&cfg.TTSCacheTTL, "tts-cache-ttl", envDuration("TTS_CACHE_TTL", cfg.TTSCacheTTL), "Expiry of redis cache entries, size is left to the redis maxmemory policy")
+    flag.StringVar(&cfg.AzureSpeechRegion, "azure-speech-region", envOr("AZURE_SPEECH_REGION", ""), "Azure AI Speech region, e.g. westeurope")
+    flag.StringVar(&cfg.AzureSpeechKey, "azure-speech-key", envOr("AZURE_SPEECH_KEY", ""), "Azure AI Speech subscription key")
+    flag.StringVar(&cfg.GoogleTTSAPIKey, "google-tts-api-key", envOr("GOOGLE_TTS_API_KEY", ""), "Google Cloud Text-to-Speech API key")
+    flag.StringVar(&cfg.PiperCommand, "piper-command", envOr("PIPER_COMMAND", cfg.PiperCommand), "Path to the local piper binary")
+    flag.StringVar(&cfg.PiperVoicesDir, "piper-voices-dir", envOr("PIPER_VOICES_DIR", ""), "Directory of piper .onnx voices (empty disables the local engine)")
+    flag.StringVar(&cfg.STTProvider, "stt-provider", envOr("STT_PROVIDER", ""), "Default speech-to-text provider: whisper, deepgram or google (empty disables server side transcription)")
+    sttTenants := flag.String("stt-tenant-providers", envOr("STT_TENANT_PROVIDERS", ""), "Comma separated TENANT=PROVIDER overrides, none disables")
+    sttTemplates := flag.String("stt-template-providers", envOr("STT_TEMPLATE_PROVIDERS", ""), "Comma separated TEMPLATE=PROVIDER overrides, winning over the tenant's")
+    flag.StringVar(&cfg.STTLanguage, "stt-language", envOr("STT_LANGUAGE", cfg.STTLanguage), "Language hint used when the client doesn't send one")
+    flag.DurationVar(&cfg.STTPartialInterval, "stt-partial-interval", envDuration("STT_PARTIAL_INTERVAL", cfg.STTPartialInterval), "How often utterance based providers re-recognize for partials (0 sends finals only)")
+    flag.StringVar(&cfg.STTVocabularyFile, "stt-vocabulary", envOr("STT_VOCABULARY_FILE", ""), "JSON file of per-tenant custom vocabulary (\"_default\" applies to all) used as hints and to correct transcripts")
+    flag.IntVar(&cfg.TranscriptWorkers, "transcript-workers", envInt("TRANSCRIPT_WORKERS", cfg.TranscriptWorkers), "Goroutines each transcript consumer (captions, history, clips) spreads rooms over")
+    flag.IntVar(&cfg.TranscriptQueueSize, "transcript-queue-size", envInt("TRANSCRIPT_QUEUE_SIZE", cfg.TranscriptQueueSize), "Transcripts queued per consumer goroutine before partials are dropped")
+    flag.StringVar(&cfg.ClipsDir, "clips-dir", envOr("CLIPS_DIR", ""), "Directory the audio of each final transcript is saved to as a clip (empty disables)")
+    flag.DurationVar(&cfg.ClipBuffer, "clip-buffer", envDuration("CLIP_BUFFER", cfg.ClipBuffer), "How much of a caller's recent audio is kept for cutting clips, the longest clip")
+    flag.DurationVar(&cfg.ClipsRetention, "clips-retention", envDuration("CLIPS_RETENTION", cfg.ClipsRetention), "How long clips are kept (0 keeps them)")
+    sttPrices := flag.String("stt-prices", envOr("STT_PRICES", ""), "Comma separated PROVIDER=USD_PER_MINUTE used for the cost metric")
+    flag.StringVar(&cfg.WhisperURL, "whisper-url", envOr("WHISPER_URL", ""), "OpenAI compatible transcription API base URL, e.g. https://api.openai.com/v1")
+    flag.StringVar(&cfg.WhisperAPIKey, "whisper-api-key", envOr("WHISPER_API_KEY", ""), "Bearer token for -whisper-url")
+    flag.StringVar(&cfg.WhisperModel, "whisper-model", envOr("WHISPER_MODEL", cfg.WhisperModel), "Whisper model name")
+    flag.StringVar(&cfg.DeepgramURL, "deepgram-url", envOr("DEEPGRAM_URL", ""), "Deepgram live endpoint (empty uses the hosted API)")
+    flag.StringVar(&cfg.DeepgramAPIKey, "deepgram-api-key", envOr("DEEPGRAM_API_KEY", ""), "Deepgram API key")
+    flag.StringVar(&cfg.DeepgramModel, "deepgram-model", envOr("DEEPGRAM_MODEL", ""), "Deepgram model, e.g. nova-2")
+    flag.StringVar(&cfg.GoogleSTTAPIKey, "google-stt-api-key", envOr("GOOGLE_STT_API_KEY", ""), "Google Cloud Speech-to-Text API key")
+    flag.StringVar(&cfg.GoogleSTTModel, "google-stt-model", envOr("GOOGLE_STT_MODEL", ""), "Google recognition model, e.g. phone_call")
+    flag.StringVar(&cfg.DemoAgent, "demo-agent", envOr("DEMO_AGENT", ""), "Join rooms that have no agent with a built-in demo agent: echo or phrases (empty disables)")
+    flag.DurationVar(&cfg.DemoAgentDelay, "demo-agent-delay", envDuration("DEMO_AGENT_DELAY", cfg.DemoAgentDelay), "How long a room waits for a real agent before the demo agent joins")
+    flag.StringVar(&cfg.DemoAgentPhrases, "demo-agent-phrases", envOr("DEMO_AGENT_PHRASES", ""), "File of canned phrases, one TEXT or TEXT|PCM_CLIP_PATH per line")
+    flag.IntVar(&cfg.DemoAgentSampleRate, "demo-agent-sample-rate", envInt("DEMO_AGENT_SAMPLE_RATE", cfg.DemoAgentSampleRate), "Sample rate of the demo agent's pcm16 phrase clips")
+    flag.DurationVar(&cfg.DemoAgentTurnGap, "demo-agent-turn-gap", envDuration("DEMO_AGENT_TURN_GAP", cfg.DemoAgentTurnGap), "Silence after user audio that the phrases demo agent answers")
+    flag.DurationVar(&cfg.TraceMaxDuration, "trace-max-duration", envDuration("TRACE_MAX_DURATION", cfg.TraceMaxDuration), "Longest room trace an admin can start")
+    flag.IntVar(&cfg.TraceMaxEvents, "trace-max-events", envInt("TRACE_MAX_EVENTS", cfg.TraceMaxEvents), "Events buffered per room trace")
+    flag.DurationVar(&cfg.TraceRetention, "trace-retention", envDuration("TRACE_RETENTION", cfg.TraceRetention), "How long a finished trace stays downloadable")
+    flag.BoolVar(&cfg.CitationsToUsers, "citations-to-users", envBool("CITATIONS_TO_USERS", false), "Also send assistant_final citations to users, not just agents")
+    flag.IntVar(&cfg.MaxCitations, "max-citations", envInt("MAX_CITATIONS", cfg.MaxCitations), "Most citations accepted on one assistant_final")
+    flag.IntVar(&cfg.AnswerCacheSize, "answer-cache-size", envInt("ANSWER_CACHE_SIZE", 0), "Virtual agent answers kept for repeat questions (0 disables the answer cache)")
+    flag.DurationVar(&cfg.AnswerCacheTTL, "answer-cache-ttl", envDuration("ANSWER_CACHE_TTL", cfg.AnswerCacheTTL), "How long a cached answer is served")
+    flag.StringVar(&cfg.ShadowLog, "shadow-log", envOr("SHADOW_LOG", ""), "File shadow agent answers are appended to as JSON lines, beside production's (empty keeps them in memory only)")
+    flag.IntVar(&cfg.ShadowMaxTurns, "shadow-max-turns", envInt("SHADOW_MAX_TURNS", cfg.ShadowMaxTurns), "Shadow agent turns kept in memory for /admin/shadow")
+    flag.BoolVar(&cfg.KnowledgeGapsUncited, "knowledge-gaps-uncited", envBool("KNOWLEDGE_GAPS_UNCITED", false), "Also queue questions the virtual agent answered without citations as knowledge gaps, not just those it marks grounded false")
+    flag.IntVar(&cfg.KnowledgeGapHistory, "knowledge-gap-history", envInt("KNOWLEDGE_GAP_HISTORY", cfg.KnowledgeGapHistory), "Recent messages kept as a knowledge gap's context")
+    flag.Float64Var(&cfg.KGAutoApproveConfidence, "kg-auto-approve-confidence", envFloat("KG_AUTO_APPROVE_CONFIDENCE", cfg.KGAutoApproveConfidence), "Extracted facts less confident than this wait for review before reaching the graph (above 1 reviews all)")
+    kgReview := flag.String("kg-review-categories", envOr("KG_REVIEW_CATEGORIES", "price,policy"), "Comma separated fact categories always reviewed, however confident the extraction")
+    kgTenants := flag.String("kg-tenant-partitions", envOr("KG_TENANT_PARTITIONS", ""), "Comma separated TENANT=PARTITION knowledge graph partitions a tenant's rooms are limited to")
+    flag.StringVar(&cfg.KGSnapshot, "kg-snapshot", envOr("KG_SNAPSHOT", ""), "Knowledge graph snapshot rooms are pinned to until the ingest announces another")
+    kgTemplates := flag.String("kg-template-partitions", envOr("KG_TEMPLATE_PARTITIONS", ""), "Comma separated TEMPLATE=PARTITION bindings, winning over the tenant's (none lifts it)")
+    flag.IntVar(&cfg.SegmentMinUtterances, "segment-min-utterances", envInt("SEGMENT_MIN_UTTERANCES", cfg.SegmentMinUtterances), "Transcript lines a closed call needs to be segmented into topics (0 disables)")
+    flag.IntVar(&cfg.SegmentWindow, "segment-window", envInt("SEGMENT_WINDOW", cfg.SegmentWindow), "Lines compared on each side of a candidate topic boundary")
+    flag.IntVar(&cfg.SegmentMinLength, "segment-min-length", envInt("SEGMENT_MIN_LENGTH", cfg.SegmentMinLength), "Fewest lines in a topic segment")
+    flag.DurationVar(&cfg.SegmentTimeout, "segment-timeout", envDuration("SEGMENT_TIMEOUT", cfg.SegmentTimeout), "Time allowed to segment one call")
+    flag.StringVar(&cfg.SegmentURL, "segment-url", envOr("SEGMENT_URL", ""), "OpenAI compatible API base URL for segment embeddings and labels (empty segments locally)")
+    flag.StringVar(&cfg.SegmentAPIKey, "segment-api-key", envOr("SEGMENT_API_KEY", ""), "Bearer token for -segment-url")
+    flag.StringVar(&cfg.SegmentEmbeddingModel, "segment-embedding-model", envOr("SEGMENT_EMBEDDING_MODEL", cfg.SegmentEmbeddingModel), "Embedding model used to find topic boundaries")
+    flag.StringVar(&cfg.SegmentChatModel, "segment-chat-model", envOr("SEGMENT_CHAT_MODEL", cfg.SegmentChatModel), "Chat model used to label topic segments")
+    flag.StringVar(&cfg.QualityURL, "quality-url", envOr("QUALITY_URL", ""), "OpenAI compatible API base URL of the judge grading closed calls (empty disables quality scoring)")
+    flag.StringVar(&cfg.QualityAPIKey, "quality-api-key", envOr("QUALITY_API_KEY", ""), "Bearer token for -quality-url")
+    flag.StringVar(&cfg.QualityModel, "quality-model", envOr("QUALITY_MODEL", cfg.QualityModel), "Chat model grading calls for resolution, accuracy and tone")
+    flag.DurationVar(&cfg.QualityTimeout, "quality-timeout", envDuration("QUALITY_TIMEOUT", cfg.QualityTimeout), "Time allowed to grade one call")
+    flag.Float64Var(&cfg.QualitySampleRate, "quality-sample-rate", envFloat("QUALITY_SAMPLE_RATE", cfg.QualitySampleRate), "Share of closed calls graded, 0 to 1")
+    flag.IntVar(&cfg.QualityMinUtterances, "quality-min-utterances", envInt("QUALITY_MIN_UTTERANCES", cfg.QualityMinUtterances), "Transcript lines a closed call needs to be graded")
+    flag.Float64Var(&cfg.QualityLowScore, "quality-low-score", envFloat("QUALITY_LOW_SCORE", cfg.QualityLowScore), "Overall score under which a call counts as low quality")
+    flag.DurationVar(&cfg.RoomMaxDuration, "room-max-duration", envDuration("ROOM_MAX_DURATION", 0), "Longest a room may stay open (0 is unlimited)")
+    flag.DurationVar(&cfg.RoomMaxAudio, "room-max-audio", envDuration("ROOM_MAX_AUDIO", 0), "Most audio a room's participants may stream into it, e.g. 30m (0 is unlimited)")
+    flag.IntVar(&cfg.RoomMaxLLMTokens, "room-max-llm-tokens", envInt("ROOM_MAX_LLM_TOKENS", 0), "Most LLM tokens a room's agents may report spending (0 is unlimited)")
+    roomQuotas := flag.String("room-quotas", envOr("ROOM_QUOTAS", ""), "Comma separated TEMPLATE/QUOTA=LIMIT overrides of duration, audio and tokens, e.g. support/duration=1h")
+    flag.Float64Var(&cfg.RoomQuotaWarnAt, "room-quota-warn", envFloat("ROOM_QUOTA_WARN", cfg.RoomQuotaWarnAt), "Share of a quota used at which the room is warned, 0 disables warnings")
+    flag.DurationVar(&cfg.RoomQuotaGrace, "room-quota-grace", envDuration("ROOM_QUOTA_GRACE", cfg.RoomQuotaGrace), "How long a room past a quota has before its connections are closed")
+    llmPrices := flag.String("llm-prices", envOr("LLM_PRICES", ""), "Comma separated MODEL=USD per million tokens, INPUT/OUTPUT or one price, with default for unlisted models, e.g. default=0.15/0.6")
+    flag.Float64Var(&cfg.TelephonyPrice, "telephony-price", envFloat("TELEPHONY_PRICE", 0), "Estimated USD per connected minute of an outbound call")
+    costAlerts := flag.String("cost-alerts", envOr("COST_ALERTS", ""), "Comma separated USD amounts of a call's estimated spend to alert at, e.g. 0.5,2")
+    flag.StringVar(&cfg.VerifyKBAURL, "verify-kba-url", envOr("VERIFY_KBA_URL", ""), "CRM or knowledge graph service that returns a customer's security questions (empty disables kba)")
+    flag.IntVar(&cfg.VerifyKBAQuestions, "verify-kba-questions", envInt("VERIFY_KBA_QUESTIONS", cfg.VerifyKBAQuestions), "Security questions asked per kba attempt")
+    flag.IntVar(&cfg.VerifyKBAMinCorrect, "verify-kba-min-correct", envInt("VERIFY_KBA_MIN_CORRECT", cfg.VerifyKBAMinCorrect), "Correct answers needed to pass kba")
+    flag.StringVar(&cfg.VerifySMSWebhook, "verify-sms-webhook", envOr("VERIFY_SMS_WEBHOOK", ""), "Webhook that texts one-time passcodes (empty disables otp)")
+    flag.IntVar(&cfg.VerifyOTPLength, "verify-otp-length", envInt("VERIFY_OTP_LENGTH", cfg.VerifyOTPLength), "Digits in a one-time passcode")
+    flag.DurationVar(&cfg.VerifyOTPTTL, "verify-otp-ttl", envDuration("VERIFY_OTP_TTL", cfg.VerifyOTPTTL), "How long a one-time passcode stays valid")
+    flag.StringVar(&cfg.VerifyOTPMessage, "verify-otp-message", envOr("VERIFY_OTP_MESSAGE", ""), "SMS text with one %s for the passcode")
+    flag.Float64Var(&cfg.VerifyVoiceThreshold, "verify-voice-threshold", envFloat("VERIFY_VOICE_THRESHOLD", cfg.VerifyVoiceThreshold), "Voice match score (0 to 1) that passes voice verification, see -speaker-url")
+    flag.StringVar(&cfg.VerifyAPIKey, "verify-api-key", envOr("VERIFY_API_KEY", ""), "Bearer token for the verification services")
+    flag.IntVar(&cfg.VerifyMaxAttempts, "verify-max-attempts", envInt("VERIFY_MAX_ATTEMPTS", cfg.VerifyMaxAttempts), "Failed verifications allowed per caller and call")
+    flag.DurationVar(&cfg.VerifyTimeout, "verify-timeout", envDuration("VERIFY_TIMEOUT", cfg.VerifyTimeout), "Time allowed for one call to a verification service")
+    flag.DurationVar(&cfg.VerifyTTL, "verify-ttl", envDuration("VERIFY_TTL", 0), "How long a passed verification unlocks sensitive tools (0 for the rest of the call)")
+    flag.StringVar(&cfg.SpeakerURL, "speaker-url", envOr("SPEAKER_URL", ""), "Speaker recognition service for voiceprints (empty disables voice verification and caller recognition)")
+    flag.StringVar(&cfg.SpeakerAPIKey, "speaker-api-key", envOr("SPEAKER_API_KEY", ""), "Bearer token for -speaker-url")
+    flag.IntVar(&cfg.SpeakerSampleSeconds, "speaker-sample-seconds", envInt("SPEAKER_SAMPLE_SECONDS", cfg.SpeakerSampleSeconds), "Seconds of recent caller audio kept for voice matching and enrollment")
+    flag.IntVar(&cfg.SpeakerIdentifyAfter, "speaker-identify-after", envInt("SPEAKER_IDENTIFY_AFTER", cfg.SpeakerIdentifyAfter), "Seconds of caller audio heard before trying to recognize a returning caller (0 disables)")
+    flag.Float64Var(&cfg.SpeakerMatchThreshold, "speaker-match-threshold", envFloat("SPEAKER_MATCH_THRESHOLD", cfg.SpeakerMatchThreshold), "Voiceprint score (0 to 1) that recognizes a returning caller")
+    flag.IntVar(&cfg.SpeakerEnrollSeconds, "speaker-enroll-seconds", envInt("SPEAKER_ENROLL_SECONDS", cfg.SpeakerEnrollSeconds), "Caller audio needed to enroll a voiceprint")
+    flag.BoolVar(&cfg.SpeakerAutoEnroll, "speaker-auto-enroll", envBool("SPEAKER_AUTO_ENROLL", false), "Enroll consenting callers' voiceprints when they pass another verification method")
+    flag.IntVar(&cfg.BreakerThreshold, "breaker-threshold", envInt("BREAKER_THRESHOLD", cfg.BreakerThreshold), "Consecutive STT or TTS provider failures that open its circuit")
+    flag.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", envDuration("BREAKER_COOLDOWN", cfg.BreakerCooldown), "How long an open circuit refuses calls before probing the provider again")
+    flag.StringVar(&cfg.FallbackPolicy, "fallback-policy", envOr("FALLBACK_POLICY", cfg.FallbackPolicy), "What callers get when an integration fails: canned, human, callback or none")
+    fallbackPolicies := flag.String("fallback-policies", envOr("FALLBACK_POLICIES", ""), "Comma separated INTEGRATION=POLICY or TEMPLATE/INTEGRATION=POLICY overrides for stt, tts, llm and kg, e.g. llm=human,sales/kg=callback")
+    flag.StringVar(&cfg.FallbackPromptsDir, "fallback-prompts", envOr("FALLBACK_PROMPTS_DIR", ""), "Directory of KEY.txt and KEY.wav prompts replacing the built-in ones (stt, tts, llm, kg, human, callback)")
+    flag.DurationVar(&cfg.CallbackAbandonAfter, "callback-abandon-after", envDuration("CALLBACK_ABANDON_AFTER", cfg.CallbackAbandonAfter), "Wait for an agent after which a caller hanging up gets a callback request (0 disables)")
+    flag.DurationVar(&cfg.CallbackLease, "callback-lease", envDuration("CALLBACK_LEASE", cfg.CallbackLease), "How long a claimed callback is held for its worker before it is handed out again")
+    flag.IntVar(&cfg.CallbackMaxAttempts, "callback-max-attempts", envInt("CALLBACK_MAX_ATTEMPTS", cfg.CallbackMaxAttempts), "Call attempts before a failed callback is given up")
+    flag.DurationVar(&cfg.CallbackRetryDelay, "callback-retry-delay", envDuration("CALLBACK_RETRY_DELAY", cfg.CallbackRetryDelay), "Wait before retrying a failed callback")
+    flag.IntVar(&cfg.CallbackHistory, "callback-history", envInt("CALLBACK_HISTORY", cfg.CallbackHistory), "Recent messages kept in a callback request's context")
+    flag.StringVar(&cfg.ConsultHoldPrompt, "consult-hold-prompt", envOr("CONSULT_HOLD_PROMPT", cfg.ConsultHoldPrompt), "Built-in phrase callers hear while their agent consults another room, for tenants without hold assets")
+    flag.StringVar(&cfg.HoldAssetsDir, "hold-assets", envOr("HOLD_ASSETS_DIR", ""), "Directory per-tenant hold music and phrases are kept in as TENANT/KIND.json and KIND.wav (empty keeps uploads in memory)")
+    flag.DurationVar(&cfg.HoldInterval, "hold-interval", envDuration("HOLD_INTERVAL", cfg.HoldInterval), "How often hold phrases repeat when an asset sets no everySeconds (0 speaks each once)")
+    flag.DurationVar(&cfg.HoldQueueAfter, "hold-queue-after", envDuration("HOLD_QUEUE_AFTER", cfg.HoldQueueAfter), "Wait for an agent after which callers hear the queue's hold audio (0 disables)")
+    flag.DurationVar(&cfg.HoldThinkingAfter, "hold-thinking-after", envDuration("HOLD_THINKING_AFTER", cfg.HoldThinkingAfter), "Silence after a caller's speech, with no answer yet, after which they hear the thinking audio (0 disables)")
+    flag.StringVar(&cfg.TelephonyURL, "telephony-url", envOr("TELEPHONY_URL", ""), "Voice gateway that places outbound calls (empty disables outbound calls and campaigns)")
+    flag.StringVar(&cfg.TelephonyAPIKey, "telephony-api-key", envOr("TELEPHONY_API_KEY", ""), "Bearer token for -telephony-url")
+    flag.StringVar(&cfg.TelephonyFrom, "telephony-from", envOr("TELEPHONY_FROM", ""), "Default caller ID for outbound calls")
+    flag.StringVar(&cfg.PublicURL, "public-url", envOr("PUBLIC_URL", ""), "Base URL the voice gateway reaches this server on, e.g. https://iva.example.com")
+    flag.StringVar(&cfg.AgentJoinURL, "agent-join-url", envOr("AGENT_JOIN_URL", ""), "Virtual agent endpoint asked to join answered outbound calls, e.g. the bot's http://localhost:8000/join")
+    flag.DurationVar(&cfg.OutboundRingTimeout, "outbound-ring-timeout", envDuration("OUTBOUND_RING_TIMEOUT", cfg.OutboundRingTimeout), "How long an outbound call rings before it counts as unanswered")
+    flag.IntVar(&cfg.OutboundSampleRate, "outbound-sample-rate", envInt("OUTBOUND_SAMPLE_RATE", cfg.OutboundSampleRate), "Sample rate of the pcm16 audio the voice gateway sends for outbound calls")
+    flag.StringVar(&cfg.AMDMode, "amd-mode", envOr("AMD_MODE", cfg.AMDMode), "Answering machine detection on outbound calls: auto, gateway, audio or off")
+    flag.DurationVar(&cfg.AMDAnalysisTime, "amd-analysis-time", envDuration("AMD_ANALYSIS_TIME", cfg.AMDAnalysisTime), "Callee audio analysed before an undecided call goes to the agent")
+    flag.Float64Var(&cfg.AMDSilenceThreshold, "amd-silence-threshold", envFloat("AMD_SILENCE_THRESHOLD", cfg.AMDSilenceThreshold), "RMS level (0 to 32767) below which callee audio counts as silence")
+    agentWorkers := flag.String("agent-workers", envOr("AGENT_WORKERS", ""), "Comma separated agent workers rooms are routed to, ID=JOIN_URL@CAPACITY, e.g. bot=http://localhost:8000/join@20")
+    flag.DurationVar(&cfg.AssignTimeout, "assign-timeout", envDuration("ASSIGN_TIMEOUT", cfg.AssignTimeout), "How long an assigned agent worker has to join before the room is routed again")
+    flag.DurationVar(&cfg.RouteInterval, "route-interval", envDuration("ROUTE_INTERVAL", cfg.RouteInterval), "How often rooms still waiting for an agent are rerouted")
+    flag.StringVar(&cfg.PoolWebhook, "pool-webhook", envOr("POOL_WEBHOOK", ""), "Endpoint POSTed spawn and terminate requests for agent pool workers")
+    flag.StringVar(&cfg.PoolSpawnCommand, "pool-spawn-command", envOr("POOL_SPAWN_COMMAND", ""), "Command that starts an agent pool worker, {id} and {capacity} replaced, used without -pool-webhook (empty for neither disables the pool)")
+    flag.StringVar(&cfg.PoolTerminateCommand, "pool-terminate-command", envOr("POOL_TERMINATE_COMMAND", ""), "Command that stops an agent pool worker, e.g. docker rm -f {id}")
+    flag.StringVar(&cfg.PoolJoinURL, "pool-join-url", envOr("POOL_JOIN_URL", ""), "Join URL of a spawned worker, e.g. http://{id}:8000/join (empty for workers that follow agent_assigned events)")
+    flag.IntVar(&cfg.PoolWorkerCapacity, "pool-worker-capacity", envInt("POOL_WORKER_CAPACITY", cfg.PoolWorkerCapacity), "Rooms each agent pool worker takes at once")
+    flag.IntVar(&cfg.PoolMinWorkers, "pool-min-workers", envInt("POOL_MIN_WORKERS", cfg.PoolMinWorkers), "Agent pool workers kept running when idle")
+    flag.IntVar(&cfg.PoolMaxWorkers, "pool-max-workers", envInt("POOL_MAX_WORKERS", cfg.PoolMaxWorkers), "Most agent pool workers running at once")
+    flag.IntVar(&cfg.PoolQueueDepth, "pool-queue-depth", envInt("POOL_QUEUE_DEPTH", cfg.PoolQueueDepth), "Waiting rooms beyond free agent capacity that spawn pool workers")
+    flag.DurationVar(&cfg.PoolIdleTimeout, "pool-idle-timeout", envDuration("POOL_IDLE_TIMEOUT", cfg.PoolIdleTimeout), "How long a pool worker without rooms runs before it is terminated")
+    flag.DurationVar(&cfg.PoolInterval, "pool-interval", envDuration("POOL_INTERVAL", cfg.PoolInterval), "How often the agent pool is scaled")
+    flag.DurationVar(&cfg.WrapUpTime, "wrap-up-time", envDuration("WRAP_UP_TIME", 0), "How long an agent has to submit a disposition once a call ends, holding one of its rooms meanwhile (0 disables wrap-up)")
+    flag.DurationVar(&cfg.RecordingMaxPause, "recording-max-pause", envDuration("RECORDING_MAX_PAUSE", cfg.RecordingMaxPause), "Longest a recording pause lasts before it resumes by itself (0 for no limit)")
+    flag.StringVar(&cfg.SecureCaptureWebhook, "secure-capture-webhook", envOr("SECURE_CAPTURE_WEBHOOK", ""), "PCI provider URL secure captures' digits are POSTed to (empty checks payment cards locally and discards them)")
+    flag.DurationVar(&cfg.SecureCaptureTimeout, "secure-capture-timeout", envDuration("SECURE_CAPTURE_TIMEOUT", cfg.SecureCaptureTimeout), "How long a secure capture waits for the caller's keypad before the room goes back to normal")
+    flag.StringVar(&cfg.WatermarkKey, "watermark-key", envOr("WATERMARK_KEY", ""), "Secret keying inaudible watermarks of tenant and call in the audio callers hear (empty disables watermarking)")
+    flag.Float64Var(&cfg.WatermarkStrength, "watermark-strength", envFloat("WATERMARK_STRENGTH", cfg.WatermarkStrength), "Watermark amplitude as a share of full scale")
+    watermarkMachineAgents := flag.String("watermark-machine-agents", envOr("WATERMARK_MACHINE_AGENTS", ""), "Comma separated agent IDs whose audio is synthesized, watermarked as machine-generated, e.g. bot")
+    flag.DurationVar(&cfg.LatencyTarget, "latency-target", envDuration("LATENCY_TARGET", 0), "p95 turn latency, caller speech end to agent audio, past which a flow goes on a latency budget (0 disables the budget)")
+    flag.DurationVar(&cfg.LatencyWindow, "latency-window", envDuration("LATENCY_WINDOW", cfg.LatencyWindow), "How far back turns count toward a flow's p95 latency")
+    flag.Float64Var(&cfg.LatencyVoiceThreshold, "latency-voice-threshold", envFloat("LATENCY_VOICE_THRESHOLD", cfg.LatencyVoiceThreshold), "RMS, 0 to 1, of caller audio that counts as speech when timing turns")
+    flag.StringVar(&cfg.LatencyTTSProvider, "latency-tts-provider", envOr("LATENCY_TTS_PROVIDER", ""), "TTS provider speak switches to while a flow is over its latency budget")
+    flag.StringVar(&cfg.LatencyTTSVoice, "latency-tts-voice", envOr("LATENCY_TTS_VOICE", ""), "TTS voice speak switches to while a flow is over its latency budget")
+    flag.StringVar(&cfg.LatencyModel, "latency-model", envOr("LATENCY_MODEL", ""), "Faster model agents are asked to switch to while their flow is over its latency budget")
+    flag.IntVar(&cfg.LatencyMaxContext, "latency-max-context", envInt("LATENCY_MAX_CONTEXT", 0), "Conversation turns agents are asked to trim their context to while over the latency budget (0 for no hint)")
+    flag.DurationVar(&cfg.BargeInTime, "barge-in-time", envDuration("BARGE_IN_TIME", 0), "Caller speech over the agent's server-side speech that interrupts it (0 disables barge-in)")
+    recordingPauseIntents := flag.String("recording-pause-intents", envOr("RECORDING_PAUSE_INTENTS", ""), "Comma separated agent message intents that pause recording until the next agent message with another, e.g. payment")
+    dispositionCodes := flag.String("disposition-codes", envOr("DISPOSITION_CODES", ""), "Comma separated disposition codes agents may submit, e.g. resolved,escalated,no_answer (empty allows any)")
+    sensitiveTools := flag.String("sensitive-tools", envOr("SENSITIVE_TOOLS", ""), "Comma separated tools that need a verified caller, e.g. refund,change_address")
+    flag.StringVar(&cfg.ProfanityPolicy, "profanity-policy", envOr("PROFANITY_POLICY", "off"), "Default profanity policy for chat and transcripts: off, or log, mask and alert joined with +")
+    profanityTenants := flag.String("profanity-tenant-policies", envOr("PROFANITY_TENANT_POLICIES", ""), "Comma separated TENANT=POLICY overrides, e.g. acme=mask+alert")
+    flag.StringVar(&cfg.ProfanityWordsFile, "profanity-words", envOr("PROFANITY_WORDS_FILE", ""), "File of profane words, one per line, replacing the built-in list")
+    flag.StringVar(&cfg.ModerationRulesFile, "moderation-rules", envOr("MODERATION_RULES_FILE", ""), "JSON file of per-tenant moderation rules (\"_default\" applies to all)")
+    moderationProviders := flag.String("moderation-providers", envOr("MODERATION_PROVIDERS", ""), "Comma separated moderation APIs run after the rules: openai, azure")
+    flag.Float64Var(&cfg.ModerationThreshold, "moderation-threshold", envFloat("MODERATION_THRESHOLD", cfg.ModerationThreshold), "Provider category score (0 to 1) that counts as flagged")
+    flag.StringVar(&cfg.ModerationOnFlagged, "moderation-on-flagged", envOr("MODERATION_ON_FLAGGED", cfg.ModerationOnFlagged), "What a provider flag does: flag (report only) or block")
+    flag.BoolVar(&cfg.ModerationTranscripts, "moderate-transcripts", envBool("MODERATE_TRANSCRIPTS", false), "Also moderate user transcripts, not just assistant answers")
+    flag.StringVar(&cfg.ModerationFallback, "moderation-fallback", envOr("MODERATION_FALLBACK", ""), "Text sent instead of a blocked answer (empty drops it and errors to the agent)")
+    flag.BoolVar(&cfg.ModerationFailClosed, "moderation-fail-closed", envBool("MODERATION_FAIL_CLOSED", false), "Block when a moderation provider fails instead of letting the text through")
+    flag.DurationVar(&cfg.ModerationTimeout, "moderation-timeout", envDuration("MODERATION_TIMEOUT", cfg.ModerationTimeout), "Time allowed for all moderation providers on one message")
+    flag.StringVar(&cfg.OpenAIModerationURL, "openai-moderation-url", envOr("OPENAI_MODERATION_URL", cfg.OpenAIModerationURL), "OpenAI compatible API base URL for moderation")
+    flag.StringVar(&cfg.OpenAIAPIKey, "openai-api-key", envOr("OPENAI_API_KEY", ""), "Bearer token for -openai-moderation-url")
+    flag.StringVar(&cfg.OpenAIModerationModel, "openai-moderation-model", envOr("OPENAI_MODERATION_MODEL", cfg.OpenAIModerationModel), "OpenAI moderation model")
+    flag.StringVar(&cfg.ContentSafetyURL, "content-safety-url", envOr("CONTENT_SAFETY_URL", ""), "Azure AI Content Safety endpoint, e.g. https://NAME.cognitiveservices.azure.com")
+    flag.StringVar(&cfg.ContentSafetyKey, "content-safety-key", envOr("CONTENT_SAFETY_KEY", ""), "Azure AI Content Safety key")
+    flag.StringVar(&cfg.MetricsToken, "metrics-token", envOr("METRICS_TOKEN", ""), "Bearer token required on /metrics (empty leaves it open)")
+    metricsTenants := flag.String("metrics-tenants", envOr("METRICS_TENANTS", ""), "Comma separated tenants labelled individually, others report as \"other\" (empty allows the first -metrics-max-tenants)")
+    flag.IntVar(&cfg.MetricsMaxTenants, "metrics-max-tenants", envInt("METRICS_MAX_TENANTS", cfg.MetricsMaxTenants), "Distinct tenant label values before new tenants report as \"other\"")
+    flag.BoolVar(&cfg.MetricsTemplateLabel, "metrics-template-label", envBool("METRICS_TEMPLATE_LABEL", false), "Also label metrics by room template")
+    flag.IntVar(&cfg.MetricsMaxTemplates, "metrics-max-templates", envInt("METRICS_MAX_TEMPLATES", cfg.MetricsMaxTemplates), "Distinct template label values before new templates report as \"other\"")
+    flag.DurationVar(&cfg.AnalyticsFlush, "analytics-flush-interval", envDuration("ANALYTICS_FLUSH_INTERVAL", cfg.AnalyticsFlush), "How often call rollups are written to the analytics tables")
+    flag.DurationVar(&cfg.SLAFirstResponse, "sla-first-response", envDuration("SLA_FIRST_RESPONSE", cfg.SLAFirstResponse), "Agent first response target counted as SLA met")
+    flag.StringVar(&cfg.OpenSearchURL, "opensearch-url", envOr("OPENSEARCH_URL", ""), "OpenSearch or Elasticsearch base URL to index conversations into")
+    flag.StringVar(&cfg.OpenSearchUser, "opensearch-user", envOr("OPENSEARCH_USER", ""), "OpenSearch basic auth user")
+    flag.StringVar(&cfg.OpenSearchPassword, "opensearch-password", envOr("OPENSEARCH_PASSWORD", ""), "OpenSearch basic auth password")
+    flag.StringVar(&cfg.OpenSearchIndex, "opensearch-index", envOr("OPENSEARCH_INDEX", cfg.OpenSearchIndex), "Index name prefix, a -YYYY.MM suffix is added per month")
+    flag.IntVar(&cfg.OpenSearchBatchSize, "opensearch-batch-size", envInt("OPENSEARCH_BATCH_SIZE", cfg.OpenSearchBatchSize), "Documents per bulk request")
+    flag.IntVar(&cfg.OpenSearchQueueSize, "opensearch-queue-size", envInt("OPENSEARCH_QUEUE_SIZE", cfg.OpenSearchQueueSize), "Documents buffered before the indexer starts dropping")
+    flag.IntVar(&cfg.HistoryMaxMessages, "history-max-messages", envInt("HISTORY_MAX_MESSAGES", cfg.HistoryMaxMessages), "Chat messages kept per room (0 disables history)")
+    flag.DurationVar(&cfg.HistoryRetention, "history-retention", envDuration("HISTORY_RETENTION", cfg.HistoryRetention), "How long chat history is kept")
+    flag.Int64Var(&cfg.MaxFileBytes, "max-file-bytes", int64(envInt("MAX_FILE_BYTES", int(cfg.MaxFileBytes))), "Largest file a participant may share")
+    flag.Int64Var(&cfg.MaxFileStoreBytes, "max-file-store-bytes", int64(envInt("MAX_FILE_STORE_BYTES", int(cfg.MaxFileStoreBytes))), "Total bytes of shared files held in memory")
+    flag.DurationVar(&cfg.FileRetention, "file-retention", envDuration("FILE_RETENTION", cfg.FileRetention), "How long shared files stay downloadable")
+    flag.Parse()
+    
+    cfg.ProtectedMetadataKeys = splitList(*protectedKeys)
+    cfg.PublicMetadataKeys = splitList(*publicKeys)
+    cfg.IPAllow = splitList(*ipAllow)
+    cfg.MessageRates = splitList(*messageRates)
+    cfg.AudioByteRates = splitList(*audioByteRates)
+    cfg.KGReviewCategories = splitList(*kgReview)
+    cfg.KGTenantPartitions = splitList(*kgTenants)
+    cfg.DuplicateIdTenants = splitList(*duplicateTenants)
+    cfg.DuplicateIdTemplates = splitList(*duplicateTemplates)
+    cfg.KGTemplatePartitions = splitList(*kgTemplates)
+    cfg.SensitiveTools = splitList(*sensitiveTools)
+    cfg.AgentWorkers = splitList(*agentWorkers)
+    cfg.DispositionCodes = splitList(*dispositionCodes)
+    cfg.RecordingPauseIntents = splitList(*recordingPauseIntents)
+    cfg.WatermarkMachineAgents = splitList(*watermarkMachineAgents)
+    cfg.FallbackPolicies = splitList(*fallbackPolicies)
+    cfg.RoomQuotas = splitList(*roomQuotas)
+    cfg.LLMPrices = splitList(*llmPrices)
+    cfg.CostAlerts = splitList(*costAlerts)
+    cfg.IPDeny = splitList(*ipDeny)
+    cfg.AllowedOrigins = splitList(*allowedOrigins)
+    cfg.TTSVoices = splitList(*ttsVoices)
+    cfg.TemplateLocales = splitList(*templateLocales)
+    cfg.TLSAutocertDomains = splitList(*autocertDomains)
+    cfg.MetricsTenants = splitList(*metricsTenants)
+    cfg.STTTenantProviders = splitList(*sttTenants)
+    cfg.STTTemplateProviders = splitList(*sttTemplates)
+    cfg.STTPrices = splitList(*sttPrices)
+    cfg.TTSTemplateProviders = splitList(*ttsTemplates)
+    cfg.ModerationProviders = splitList(*moderationProviders)
+    cfg.ProfanityTenantPolicies = splitList(*profanityTenants)
+    cfg.TTSStyles = splitList(*ttsStyles)
+    cfg.TTSSpeeds = splitList(*ttsSpeeds)
+    cfg.TTSPrices = splitList(*ttsPrices)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// Consult bridges. An agent puts its caller on hold and opens a
+// back-to-back bridge to participants of another room on this server, a
+// specialist's or a supervisor's, to ask before answering:
+//
+//   {"type": "consult_start", "data": {"roomId": "billing-experts", "peers": ["sme-1"]}}
+//
+// No peers means every agent of that room. While the consult lasts the
+// agent's audio goes to the peers instead of the caller, the peers' audio
+// comes back to the agent alone, and the caller hears the tenant's hold
+// audio, see hold.go. A holdPrompt replaces its phrases for this consult, ""
+// leaves the music alone. consult_message carries text across the bridge.
+//
+// consult_end from either side takes the caller off hold. consult_transfer
+// from the agent completes a warm transfer instead: the peers' consult_ended
+// carries the caller's roomId, and a ticket to join it when tickets are on,
+// and the consulting agent is expected to leave. A consult also ends when
+// the agent or its last peer leaves.
+
+// Consult is one agent's bridge to another room.
+type Consult struct {
+    Id        string   `json:"consultId"`
+    RoomId    string   `json:"roomId"`  // The caller's room
+    AgentId   string   `json:"agentId"` // The consulting agent
+    PeerRoom  string   `json:"peerRoomId"`
+    Peers     []string `json:"peers"` // Guarded by consultsMu
+    StartedAt int64    `json:"startedAt"`
+    
+    labels []string
+}
+
+var (
+    consults   = make(map[string]*Consult)
+    consultsMu sync.Mutex
+)
+
+var consultsTotal = newCounterVec("iva_consults_total", "Consult bridges by how they ended.", "reason")
+
+func isConsultMessage(msgType string) bool {
+    switch msgType {
+    case "consult_start", "consult_end", "consult_transfer", "consult_message":
+        return true
+    }
+    return false
+}
+
+func handleConsultMessage(roomId string, sender *Client, msg *Message) {
+    if msg.Type == "consult_start" {
+        handleConsultStart(roomId, sender, msg)
+        return
+    }
+    
+    consult := consultOf(sender)
+    data, _ := msg.Data.(map[string]interface{})
+    if id, _ := data["consultId"].(string); consult == nil || (id != "" && id != consult.Id) {
+        sendError(sender, ErrTargetNotFound, msg, "not in consult %q", id)
+        return
+    }
+    switch msg.Type {
+    case "consult_end":
+        endConsult(consult, sender.clientId, "ended")
+    case "consult_transfer":
+        if sender.clientId != consult.AgentId || sender.room != consult.RoomId {
+            sendError(sender, ErrNotPermitted, msg, "only the consulting agent transfers the call")
+            return
+        }
+        endConsult(consult, sender.clientId, "transferred")
+    case "consult_message":
+        msg.To = nil
+        for _, client := range consultMembers(consult) {
+            if client != sender {
+                sendMessageToClient(client, msg)
+            }
+        }
+    }
+}
+
+// consult_start: {"roomId": ID, "peers": [CLIENT_ID...], "holdPrompt": TEXT}
+func handleConsultStart(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    peerRoom, _ := data["roomId"].(string)
+    var holdPhrases []string
+    if prompt, ok := data["holdPrompt"].(string); ok {
+        holdPhrases = []string{}
+        if prompt != "" {
+            holdPhrases = append(holdPhrases, prompt)
+        }
+    }
+    var requested []string
+    list, _ := data["peers"].([]interface{})
+    for _, item := range list {
+        if id, ok := item.(string); ok {
+            requested = append(requested, id)
+        }
+    }
+    
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents consult")
+        return
+    }
+    if peerRoom == "" || peerRoom == roomId {
+        sendError(sender, ErrInvalidMessage, msg, "roomId must name another room")
+        return
+    }
+    
+    roomsMu.RLock()
+    room, target := rooms[roomId], rooms[peerRoom]
+    var peers []*Client
+    missing := ""
+    if room != nil && target != nil && target.Tenant == room.Tenant {
+        if len(requested) == 0 {
+            for _, client := range target.Agents {
+                peers = append(peers, client)
+            }
+        }
+        for _, id := range requested {
+            client := target.Users[id]
+            if client == nil {
+                client = target.Agents[id]
+            }
+            if client == nil {
+                missing = id
+                break
+            }
+            peers = append(peers, client)
+        }
+    }
+    roomsMu.RUnlock()
+    
+    switch {
+    case room == nil:
+        return
+    case target == nil || target.Tenant != room.Tenant:
+        sendError(sender, ErrTargetNotFound, msg, "room %q is not on this server", peerRoom)
+        return
+    case missing != "":
+        sendError(sender, ErrTargetNotFound, msg, "%q is not in room %s", missing, peerRoom)
+        return
+    case len(peers) == 0:
+        sendError(sender, ErrTargetNotFound, msg, "room %s has no agents to consult", peerRoom)
+        return
+    }
+    sort.Slice(peers, func(i, j int) bool { return peers[i].clientId < peers[j].clientId })
+    
+    consult := &Consult{
+        Id:        newRandomId(),
+        RoomId:    roomId,
+        AgentId:   sender.clientId,
+        PeerRoom:  peerRoom,
+        StartedAt: time.Now().UnixNano() / int64(time.Millisecond),
+        labels:    room.labels,
+    }
+    for _, peer := range peers {
+        consult.Peers = append(consult.Peers, peer.clientId)
+    }
+    
+    // Everyone on the bridge joins it at once or not at all
+    consultsMu.Lock()
+    members := append([]*Client{sender}, peers...)
+    for _, client := range members {
+        client.mu.Lock()
+        busy := client.consult != ""
+        client.mu.Unlock()
+        if busy {
+            consultsMu.Unlock()
+            sendError(sender, ErrInvalidMessage, msg, "%s is already in a consult", client.clientId)
+            return
+        }
+    }
+    for _, client := range members {
+        client.mu.Lock()
+        client.consult = consult.Id
+        client.mu.Unlock()
+    }
+    consults[consult.Id] = consult
+    snapshot := *consult
+    consultsMu.Unlock()
+    
+    logAt("info", roomId, sender.clientId, "Consult %s started with %v in room %s", consult.Id, consult.Peers, peerRoom)
+    started := &Message{
+        Type:      "consult_started",
+        From:      SystemSender,
+        Data:      snapshot,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    for _, client := range members {
+        sendMessageToClient(client, started)
+    }
+    startHold(roomId, holdConsult, holdPhrases)
+}
+
+func consultOf(client *Client) *Consult {
+    client.mu.Lock()
+    id := client.consult
+    client.mu.Unlock()
+    if id == "" {
+        return nil
+    }
+    consultsMu.Lock()
+    defer consultsMu.Unlock()
+    return consults[id]
+}
+
+// consultMembers returns the agent and the peers still connected.
+func consultMembers(consult *Consult) []*Client {
+    consultsMu.Lock()
+    peers := append([]string(nil), consult.Peers...)
+    consultsMu.Unlock()
+    
+    var members []*Client
+    if agent := findClient(consult.RoomId, consult.AgentId); agent != nil {
+        members = append(members, agent)
+    }
+    for _, id := range peers {
+        if peer := findClient(consult.PeerRoom, id); peer != nil {
+            members = append(members, peer)
+        }
+    }
+    return members
+}
+
+func endConsult(consult *Consult, endedBy string, reason string) {
+    members := consultMembers(consult)
+    consultsMu.Lock()
+    if consults[consult.Id] != consult {
+        consultsMu.Unlock()
+        return
+    }
+    delete(consults, consult.Id)
+    consultsMu.Unlock()
+    stopHold(consult.RoomId, holdConsult)
+    
+    for _, client := range members {
+        client.mu.Lock()
+        if client.consult == consult.Id {
+            client.consult = ""
+        }
+        client.mu.Unlock()
+    }
+    
+    consultsTotal.inc(consult.labels, reason)
+    logAt("info", consult.RoomId, consult.AgentId, "Consult %s %s by %s", consult.Id, reason, endedBy)
+    for _, client := range members {
+        data := map[string]interface{}{
+            "consultId": consult.Id,
+            "endedBy":   endedBy,
+            "reason":    reason,
+        }
+        if reason == "transferred" && client.room == consult.PeerRoom {
+            data["roomId"] = consult.RoomId
+            if ticketsEnabled() {
+                data["ticket"] = consultTicket(consult, client)
+            }
+        }
+        sendMessageToClient(client, &Message{
+            Type:      "consult_ended",
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+    }
+}
+
+// consultTicket lets a peer join the caller's room after a transfer.
+func consultTicket(consult *Consult, peer *Client) string {
+    roomsMu.RLock()
+    tenant := ""
+    if room := rooms[consult.RoomId]; room != nil {
+        tenant = room.Tenant
+    }
+    roomsMu.RUnlock()
+    return signTicket(Ticket{
+        RoomId:     consult.RoomId,
+        ClientId:   peer.clientId,
+        ClientType: peer.clientType,
+        Tenant:     tenant,
+        Role:       peer.role,
+        Server:     cfg.PublicAddress,
+        Expiry:     time.Now().Add(cfg.TicketTTL).Unix(),
+    })
+}
+
+// leaveConsult takes a leaving client off its bridge. The consult ends with
+// the agent or the last peer.
+func leaveConsult(client *Client) {
+    consult := consultOf(client)
+    if consult == nil {
+        return
+    }
+    if client.clientId == consult.AgentId && client.room == consult.RoomId {
+        endConsult(consult, client.clientId, "left")
+        return
+    }
+    
+    consultsMu.Lock()
+    for i, id := range consult.Peers {
+        if id == client.clientId {
+            consult.Peers = append(consult.Peers[:i:i], consult.Peers[i+1:]...)
+            break
+        }
+    }
+    last := len(consult.Peers) == 0
+    consultsMu.Unlock()
+    client.mu.Lock()
+    client.consult = ""
+    client.mu.Unlock()
+    if last {
+        endConsult(consult, client.clientId, "left")
+    }
+}
+
+// forwardConsultAudio returns false when the client is on no bridge. The
+// agent's audio goes to the peers, theirs to the agent.
+func forwardConsultAudio(roomId string, client *Client, audioData []byte, paced bool) bool {
+    consult := consultOf(client)
+    if consult == nil {
+        return false
+    }
+    
+    consultsMu.Lock()
+    targetRoom, targets := consult.RoomId, []string{consult.AgentId}
+    if client.clientId == consult.AgentId && roomId == consult.RoomId {
+        targetRoom, targets = consult.PeerRoom, append([]string(nil), consult.Peers...)
+    }
+    consultsMu.Unlock()
+    
+    for _, id := range targets {
+        if peer := findClient(targetRoom, id); peer != nil {
+            if err := sendAudio(peer, audioData, paced); err != nil {
+                logAt("warn", targetRoom, id, "Consult audio forward error: %v", err)
+            }
+        }
+    }
+    return true
+}
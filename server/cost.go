@@ -0,0 +1,240 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Live spend per call. Billable use is priced as it happens and added to its
+// room: transcribed audio at -stt-prices, synthesized characters at
+// -tts-prices, the LLM tokens agents report with usage (quotas.go) at
+// -llm-prices, and the connected minutes of outbound calls at
+// -telephony-price. GET /room/{id}/stats has the running total of a live
+// room, or the total of its last call, which is kept on the CDR. Each of
+// -cost-alerts, in USD, is raised once per call when its spend crosses it: a
+// warn log, a room_cost_alert event and a cost_alert to the room's agents.
+
+const (
+    spendSTT = iota
+    spendTTS
+    spendLLM
+    spendTelephony
+    spendCategories
+)
+
+// roomSpend amounts are nano-USD, added atomically under roomsMu's read
+// lock. alerted, the -cost-alerts already crossed, is guarded by roomsMu.
+type roomSpend struct {
+    amounts [spendCategories]int64
+    alerted int
+}
+
+var (
+    costAlerts []float64 // -cost-alerts, ascending
+    
+    llmCostTotal       = newCounterVec("iva_llm_cost_usd_total", "Estimated LLM spend from reported usage and -llm-prices.", "model")
+    telephonyCostTotal = newCounterVec("iva_telephony_cost_usd_total", "Estimated telephony spend of outbound calls from -telephony-price.")
+    costAlertsTotal    = newCounterVec("iva_room_cost_alerts_total", "Calls whose estimated spend crossed a -cost-alerts threshold.", "threshold")
+)
+
+func init() {
+    metricSeries = append(metricSeries, llmCostTotal, telephonyCostTotal, costAlertsTotal)
+}
+
+// loadCosts checks -llm-prices and reads -cost-alerts.
+func loadCosts() error {
+    for _, entry := range cfg.LLMPrices {
+        _, value, _ := strings.Cut(entry, "=")
+        if _, _, err := parseLLMPrice(value); err != nil {
+            return fmt.Errorf("-llm-prices %q: %v", entry, err)
+        }
+    }
+    costAlerts = nil
+    for _, entry := range cfg.CostAlerts {
+        threshold, err := strconv.ParseFloat(entry, 64)
+        if err != nil || threshold <= 0 {
+            return fmt.Errorf("-cost-alerts %q: want a positive USD amount", entry)
+        }
+        costAlerts = append(costAlerts, threshold)
+    }
+    sort.Float64s(costAlerts)
+    return nil
+}
+
+// parseLLMPrice reads USD per million tokens, INPUT/OUTPUT or one price for
+// both.
+func parseLLMPrice(value string) (float64, float64, error) {
+    in, out, split := strings.Cut(value, "/")
+    prompt, err := strconv.ParseFloat(in, 64)
+    if err != nil || prompt < 0 {
+        return 0, 0, fmt.Errorf("want USD per million tokens, e.g. 0.15/0.6")
+    }
+    if !split {
+        return prompt, prompt, nil
+    }
+    completion, err := strconv.ParseFloat(out, 64)
+    if err != nil || completion < 0 {
+        return 0, 0, fmt.Errorf("want USD per million tokens, e.g. 0.15/0.6")
+    }
+    return prompt, completion, nil
+}
+
+// llmPrice is the model's prompt and completion price, falling back to the
+// default entry for unlisted models.
+func llmPrice(model string) (float64, float64) {
+    value, ok := lookupOverride(cfg.LLMPrices, model)
+    if !ok {
+        value, _ = lookupOverride(cfg.LLMPrices, "default")
+    }
+    prompt, completion, _ := parseLLMPrice(value)
+    return prompt, completion
+}
+
+// addSpend charges usd to a live room.
+func addSpend(roomId string, category int, usd float64) {
+    if usd <= 0 {
+        return
+    }
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    if room := rooms[roomId]; room != nil {
+        atomic.AddInt64(&room.spend.amounts[category], int64(usd*1e9))
+    }
+}
+
+// roomCost is the room's spend so far, its outbound calls' connected time
+// included. Callers hold roomsMu.
+func roomCost(room *RoomInfo, now int64) storage.CDRCost {
+    usd := func(category int) float64 {
+        return float64(atomic.LoadInt64(&room.spend.amounts[category])) / 1e9
+    }
+    cost := storage.CDRCost{STT: usd(spendSTT), TTS: usd(spendTTS), LLM: usd(spendLLM), Telephony: usd(spendTelephony)}
+    if cfg.TelephonyPrice > 0 {
+        outboundMu.Lock()
+        for _, call := range outboundCalls {
+            if call.RoomId == room.RoomId && call.AnsweredAt != 0 {
+                cost.Telephony += float64(now-call.AnsweredAt) / 60000 * cfg.TelephonyPrice
+            }
+        }
+        outboundMu.Unlock()
+    }
+    cost.Total = cost.STT + cost.TTS + cost.LLM + cost.Telephony
+    return cost
+}
+
+func startCostAlerts() {
+    if len(costAlerts) == 0 {
+        return
+    }
+    go func() {
+        for range time.Tick(time.Second) {
+            checkCostAlerts(time.Now().UnixNano() / int64(time.Millisecond))
+        }
+    }()
+}
+
+type costAlert struct {
+    room      *RoomInfo
+    threshold float64
+    cost      storage.CDRCost
+}
+
+func checkCostAlerts(now int64) {
+    var alerts []costAlert
+    roomsMu.Lock()
+    for _, room := range rooms {
+        if room.spend.alerted == len(costAlerts) {
+            continue
+        }
+        cost := roomCost(room, now)
+        for room.spend.alerted < len(costAlerts) && cost.Total >= costAlerts[room.spend.alerted] {
+            alerts = append(alerts, costAlert{room: room, threshold: costAlerts[room.spend.alerted], cost: cost})
+            room.spend.alerted++
+        }
+    }
+    roomsMu.Unlock()
+    
+    for _, alert := range alerts {
+        room := alert.room
+        threshold := strconv.FormatFloat(alert.threshold, 'f', -1, 64)
+        costAlertsTotal.inc(room.labels, threshold)
+        logAt("warn", room.RoomId, "", "Call spend reached $%.4f, over the $%s alert", alert.cost.Total, threshold)
+        data := map[string]interface{}{"threshold": alert.threshold, "cost": alert.cost}
+        emitEvent("room_cost_alert", room, data)
+        sendToAgents(room.RoomId, nil, &Message{
+            Id:        newMessageId(),
+            Type:      "cost_alert",
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: now,
+        })
+    }
+}
+
+// GET /room/{id}/stats (admin): a live room's running usage and spend, or
+// the spend of its last call once it has closed.
+func handleRoomStats(w http.ResponseWriter, r *http.Request, roomId string) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    
+    roomsMu.RLock()
+    room := rooms[roomId]
+    var response map[string]interface{}
+    if room != nil {
+        crossed := append([]float64{}, costAlerts[:room.spend.alerted]...)
+        response = map[string]interface{}{
+            "roomId":       roomId,
+            "live":         true,
+            "startedAt":    room.CreatedAt,
+            "durationMs":   now - room.CreatedAt,
+            "users":        len(room.Users),
+            "agents":       len(room.Agents),
+            "participants": len(room.cdr.Participants),
+            "audioMs":      atomic.LoadInt64(&room.usage.audioUs) / 1000,
+            "llmTokens":    room.usage.llmTokens,
+            "cost":         roomCost(room, now),
+            "costAlerts":   crossed,
+        }
+    }
+    roomsMu.RUnlock()
+    
+    if response == nil {
+        cdr, err := roomCDR(r.Context(), roomId)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Room not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            log.Printf("Reading the CDR of room %s failed: %v", roomId, err)
+            http.Error(w, "CDRs unavailable", http.StatusInternalServerError)
+            return
+        }
+        response = map[string]interface{}{
+            "roomId":       roomId,
+            "live":         false,
+            "cdrId":        cdr.Id,
+            "startedAt":    cdr.StartedAt,
+            "endedAt":      cdr.EndedAt,
+            "durationMs":   cdr.EndedAt - cdr.StartedAt,
+            "participants": len(cdr.Participants),
+            "cost":         cdr.Cost,
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
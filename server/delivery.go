@@ -0,0 +1,71 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+// DeliveryReport tells the sender of a selective message what happened to
+// each target so agent orchestration can retry or reroute.
+type DeliveryReport struct {
+    RequestId string   `json:"requestId,omitempty"`
+    Delivered []string `json:"delivered"`
+    Queued    []string `json:"queued"`  // Held until the target reconnects, see queueOffline
+    Unknown   []string `json:"unknown"` // Never seen in the room, or gone longer than the resume grace
+    Offline   []string `json:"offline"` // Disconnected within the resume grace and not queued
+    Failed    []string `json:"failed"`  // Connected but their send queue refused the message
+}
+
+// markDeparted remembers a leaving client so selective sends can report it
+// as offline rather than unknown. Callers must hold roomsMu.
+func markDeparted(room *RoomInfo, clientId string) {
+    now := time.Now()
+    if room.Departed == nil {
+        room.Departed = make(map[string]time.Time)
+    }
+    room.Departed[clientId] = now
+    for id, left := range room.Departed {
+        if now.Sub(left) > cfg.ResumeGrace {
+            delete(room.Departed, id)
+        }
+    }
+}
+
+// lookupTarget resolves a selective send target. A nil client with offline
+// set means the target left recently enough to count as a room member.
+func lookupTarget(roomId string, clientId string) (client *Client, offline bool) {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    
+    room := rooms[roomId]
+    if room == nil {
+        return nil, false
+    }
+    if client, ok := room.Users[clientId]; ok {
+        return client, false
+    }
+    if client, ok := room.Agents[clientId]; ok {
+        return client, false
+    }
+    left, ok := room.Departed[clientId]
+    return nil, ok && time.Since(left) <= cfg.ResumeGrace
+}
+
+// sendDeliveryReport answers a client's selective message. Server generated
+// selective sends have no sender and get no report.
+func sendDeliveryReport(sender *Client, msg *Message, report DeliveryReport) {
+    if sender == nil {
+        return
+    }
+    if len(report.Delivered) < len(msg.To) {
+        log.Printf("Selective send from %s: %d delivered, %d queued, %d unknown, %d offline, %d failed",
+            sender.clientId, len(report.Delivered), len(report.Queued), len(report.Unknown), len(report.Offline), len(report.Failed))
+    }
+    report.RequestId = msg.Id
+    sendMessageToClient(sender, &Message{
+        Type:      "delivery_report",
+        From:      SystemSender,
+        Data:      report,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "bufio"
+    "crypto/subtle"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// The demo agent stands in for the Python bot so frontends can exercise the
+// whole user flow without STT, LLM or TTS infrastructure. It joins any room
+// that still has no agent after -demo-agent-delay, over a loopback WebSocket
+// like any other agent, and leaves when the last user does or a real agent
+// joins.
+//
+//   echo    plays the user's audio straight back and repeats their text
+//   phrases answers each user turn with the next canned phrase, as a
+//           bot_message plus its pre-rendered PCM clip when one is given
+
+const (
+    DemoAgentEcho    = "echo"
+    DemoAgentPhrases = "phrases"
+)
+
+type demoPhrase struct {
+    text string
+    clip []byte // pcm16 at -demo-agent-sample-rate, may be nil
+}
+
+var defaultDemoPhrases = []string{
+    "Hello! How may I assist you today?",
+    "Give me a minute.",
+    "I'm a demo agent, so I can't really help, but your audio and messages are reaching me.",
+    "Ok, I am listening.",
+}
+
+var (
+    demoPhrases []demoPhrase
+    demoRooms   = make(map[string]bool) // Rooms with a demo agent joining or joined
+    demoRoomsMu sync.Mutex
+)
+
+// loadDemoPhrases reads -demo-agent-phrases, one phrase per line written as
+// TEXT or TEXT|CLIP_PATH. Blank lines and lines starting with # are skipped.
+func loadDemoPhrases(path string) error {
+    if path == "" {
+        demoPhrases = nil
+        for _, text := range defaultDemoPhrases {
+            demoPhrases = append(demoPhrases, demoPhrase{text: text})
+        }
+        return nil
+    }
+    
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    
+    var phrases []demoPhrase
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        text, clipPath, hasClip := strings.Cut(line, "|")
+        phrase := demoPhrase{text: strings.TrimSpace(text)}
+        if hasClip {
+            clip, err := os.ReadFile(strings.TrimSpace(clipPath))
+            if err != nil {
+                return fmt.Errorf("phrase %q: %v", phrase.text, err)
+            }
+            phrase.clip = clip
+        }
+        phrases = append(phrases, phrase)
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+    if len(phrases) == 0 {
+        return fmt.Errorf("%s has no phrases", path)
+    }
+    demoPhrases = phrases
+    return nil
+}
+
+// scheduleDemoAgent is called after a user joins. The delay gives a real
+// agent (the bot is usually spawned by the frontend) the chance to arrive first.
+func scheduleDemoAgent(roomId string) {
+    if cfg.DemoAgent == "" {
+        return
+    }
+    time.AfterFunc(cfg.DemoAgentDelay, func() {
+        room, users, agents := roomMembers(roomId)
+        if room == nil || len(users) == 0 || len(agents) > 0 {
+            return
+        }
+        
+        demoRoomsMu.Lock()
+        if demoRooms[roomId] {
+            demoRoomsMu.Unlock()
+            return
+        }
+        demoRooms[roomId] = true
+        demoRoomsMu.Unlock()
+        
+        go func() {
+            defer func() {
+                demoRoomsMu.Lock()
+                delete(demoRooms, roomId)
+                demoRoomsMu.Unlock()
+            }()
+            if err := runDemoAgent(roomId, room.Tenant); err != nil {
+                logAt("warn", roomId, "", "Demo agent failed: %v", err)
+            }
+        }()
+    })
+}
+
+// loopbackURL is the /ws URL the server itself listens on.
+func loopbackURL(query url.Values) string {
+    host, port, err := net.SplitHostPort(cfg.Addr)
+    if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+        host = "127.0.0.1"
+    }
+    scheme := "ws"
+    if tlsEnabled() {
+        scheme = "wss"
+    }
+    return (&url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: "/ws", RawQuery: query.Encode()}).String()
+}
+
+// loopbackToken vouches for the server's own agents on /ws where neither a
+// ticket nor the admin token does. It never leaves the process.
+var loopbackToken = newRandomId()
+
+func isLoopbackRequest(r *http.Request) bool {
+    return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Loopback-Token")), []byte(loopbackToken)) == 1
+}
+
+// Our own certificate names the public host, not the loopback address
+var loopbackDialer = &websocket.Dialer{
+    HandshakeTimeout: 45 * time.Second,
+    TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+}
+
+type demoAgent struct {
+    roomId  string
+    writeMu sync.Mutex // gorilla connections allow one concurrent writer
+    conn    *websocket.Conn
+    next    int         // Next canned phrase
+    turn    *time.Timer // Fires once the user has been quiet for a moment
+}
+
+func runDemoAgent(roomId string, tenant string) error {
+    clientId := "demo-agent-" + newRandomId()[:8]
+    query := url.Values{
+        "room":        {roomId},
+        "clientId":    {clientId},
+        "type":        {string(ClientTypeAgent)},
+        "displayName": {"Demo Agent"},
+        "audioFormat": {"pcm16"},
+        "sampleRate":  {strconv.Itoa(cfg.DemoAgentSampleRate)},
+    }
+    if ticketsEnabled() {
+        query.Set("ticket", signTicket(Ticket{
+            RoomId:     roomId,
+            ClientId:   clientId,
+            ClientType: ClientTypeAgent,
+            Tenant:     tenant,
+            Server:     cfg.PublicAddress,
+            Expiry:     time.Now().Add(cfg.TicketTTL).Unix(),
+        }))
+    }
+    
+    conn, _, err := loopbackDialer.Dial(loopbackURL(query), http.Header{"X-Loopback-Token": {loopbackToken}})
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    
+    agent := &demoAgent{roomId: roomId, conn: conn}
+    defer func() {
+        agent.writeMu.Lock()
+        if agent.turn != nil {
+            agent.turn.Stop()
+        }
+        agent.writeMu.Unlock()
+    }()
+    logAt("info", roomId, clientId, "Demo agent (%s) joined", cfg.DemoAgent)
+    
+    for {
+        messageType, data, err := conn.ReadMessage()
+        if err != nil {
+            return nil // Closed by the server or on our way out
+        }
+        
+        if messageType == websocket.BinaryMessage {
+            agent.onAudio(data)
+            continue
+        }
+        
+        var msg Message
+        if err := json.Unmarshal(data, &msg); err != nil {
+            continue
+        }
+        if !agent.onMessage(&msg) {
+            agent.say("Goodbye!")
+            agent.writeMu.Lock()
+            conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+            agent.writeMu.Unlock()
+            return nil
+        }
+    }
+}
+
+// onMessage returns false when the demo agent should leave the room.
+func (a *demoAgent) onMessage(msg *Message) bool {
+    data, _ := msg.Data.(map[string]interface{})
+    
+    switch msg.Type {
+    case "welcome":
+        if cfg.DemoAgent == DemoAgentPhrases {
+            a.speakNext()
+        } else {
+            a.say("Hi, I'm the demo echo agent. Talk or type and I'll send it back.")
+        }
+    case "client_joined":
+        // Step aside for a real agent
+        if data["clientType"] == string(ClientTypeAgent) {
+            return false
+        }
+    case "client_left":
+        if _, users, _ := roomMembers(a.roomId); len(users) == 0 {
+            return false
+        }
+    default:
+        text, _ := data["text"].(string)
+        if text == "" || isSystemMessageType(msg.Type) {
+            return true
+        }
+        if sender := findClient(a.roomId, msg.From); sender == nil || sender.clientType != ClientTypeUser {
+            return true
+        }
+        if cfg.DemoAgent == DemoAgentPhrases {
+            a.speakNext()
+        } else {
+            a.say("You said: " + text)
+        }
+    }
+    return true
+}
+
+func (a *demoAgent) onAudio(data []byte) {
+    if cfg.DemoAgent == DemoAgentEcho {
+        a.writeMu.Lock()
+        a.conn.WriteMessage(websocket.BinaryMessage, data)
+        a.writeMu.Unlock()
+        return
+    }
+    
+    // Treat a pause in the user's audio as the end of their turn
+    a.writeMu.Lock()
+    defer a.writeMu.Unlock()
+    if a.turn == nil {
+        a.turn = time.AfterFunc(cfg.DemoAgentTurnGap, a.speakNext)
+    } else {
+        a.turn.Reset(cfg.DemoAgentTurnGap)
+    }
+}
+
+func (a *demoAgent) say(text string) {
+    a.writeMu.Lock()
+    defer a.writeMu.Unlock()
+    a.conn.WriteJSON(map[string]interface{}{
+        "type": "bot_message",
+        "data": map[string]interface{}{"text": text},
+    })
+}
+
+// speakNext sends the next canned phrase, then its clip in frame sized chunks
+// which the server paces like any pcm16 source.
+func (a *demoAgent) speakNext() {
+    a.writeMu.Lock()
+    phrase := demoPhrases[a.next%len(demoPhrases)]
+    a.next++
+    a.writeMu.Unlock()
+    
+    a.say(phrase.text)
+    
+    chunk := cfg.DemoAgentSampleRate * 2 / 50 // 20ms of mono pcm16
+    for offset := 0; offset < len(phrase.clip); offset += chunk {
+        end := offset + chunk
+        if end > len(phrase.clip) {
+            end = len(phrase.clip)
+        }
+        a.writeMu.Lock()
+        err := a.conn.WriteMessage(websocket.BinaryMessage, phrase.clip[offset:end])
+        a.writeMu.Unlock()
+        if err != nil {
+            return
+        }
+    }
+}
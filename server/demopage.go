@@ -0,0 +1,38 @@
+package main
+
+import (
+    "embed"
+    "encoding/json"
+    "io/fs"
+    "net/http"
+)
+
+// The demo page, /demo/ with -demo-page, is a call in the browser: it joins a
+// room with the microphone, shows the server's live captions and chats with
+// the room's agents over the real protocol, so a deployment can be checked
+// end to end before any frontend exists. Pair it with -demo-agent to have
+// someone answer. The page is embedded in the binary and pulls nothing from
+// elsewhere; /demo/config.json tells it the rate of the pcm16 it plays and
+// whether joining takes a ticket.
+
+//go:embed demo
+var demoFiles embed.FS
+
+func demoPageHandler() http.Handler {
+    files, _ := fs.Sub(demoFiles, "demo")
+    mux := http.NewServeMux()
+    mux.Handle("/demo/", http.StripPrefix("/demo/", http.FileServer(http.FS(files))))
+    mux.HandleFunc("/demo/config.json", handleDemoConfig)
+    return mux
+}
+
+// GET /demo/config.json
+func handleDemoConfig(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "sampleRate":      cfg.TTSSampleRate,
+        "frameMs":         cfg.AudioFrameMs,
+        "ticketsRequired": ticketsEnabled(),
+        "demoAgent":       cfg.DemoAgent,
+    })
+}
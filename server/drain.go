@@ -0,0 +1,253 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+// Drain mode, for rolling restarts of the media fleet. Draining a server
+//
+//   POST /admin/drain   {"timeoutMs": 600000, "reason": "deploy 4.2"}
+//
+// tells the registry at once, on an early heartbeat, and the registry stops
+// allocating it. The server refuses to open new rooms but its calls run on:
+// participants keep joining and resuming in them. Once the last one closes
+// the server is drained and safe to stop. Calls still open when the timeout
+// (-drain-timeout by default) runs out are moved as by POST
+// /admin/rooms/move, their participants reconnecting to other servers.
+// GET /admin/drain reports the progress,
+//
+//   {"draining", "since", "deadline", "reason", "rooms", "participants",
+//    "moved", "drained", "registryAcked"}
+//
+// registryAcked saying whether the registry has confirmed it stopped
+// allocating the server (with -registry-url), and DELETE /admin/drain
+// takes the server back into service.
+//
+// SIGTERM and SIGINT drain the server without waiting: it stops accepting,
+// closes every call as the timeout would, each client with server_shutdown
+// so it reconnects to another server, and exits once the calls' records are
+// written or -shutdown-timeout runs out.
+
+type DrainRequest struct {
+    TimeoutMs int64  `json:"timeoutMs,omitempty"` // How long calls may run before they are moved
+    Reason    string `json:"reason,omitempty"`
+}
+
+type DrainStatus struct {
+    Draining      bool   `json:"draining"`
+    Since         int64  `json:"since,omitempty"`    // Unix ms
+    Deadline      int64  `json:"deadline,omitempty"` // When open calls are moved
+    Reason        string `json:"reason,omitempty"`
+    Rooms         int    `json:"rooms"`
+    Participants  int    `json:"participants"`
+    Moved         int    `json:"moved"` // Rooms moved at the deadline
+    Drained       bool   `json:"drained"`
+    RegistryAcked bool   `json:"registryAcked"`
+}
+
+var drain struct {
+    sync.Mutex
+    active        bool
+    since         int64
+    deadline      int64
+    reason        string
+    timer         *time.Timer
+    moved         int
+    registryAcked bool
+}
+
+// registryNudge wakes the heartbeat loop when the drain state changes.
+var registryNudge = make(chan struct{}, 1)
+
+func isDraining() bool {
+    drain.Lock()
+    defer drain.Unlock()
+    return drain.active
+}
+
+func nudgeRegistry() {
+    select {
+    case registryNudge <- struct{}{}:
+    default:
+    }
+}
+
+// noteRegistryDraining records whether the registry's last answer had the
+// server draining.
+func noteRegistryDraining(acked bool) {
+    drain.Lock()
+    defer drain.Unlock()
+    if acked && !drain.registryAcked && drain.active {
+        log.Printf("Registry confirmed the drain, no new calls are allocated here")
+    }
+    drain.registryAcked = acked
+}
+
+// noteDrainProgress is told how many rooms are left each time one closes.
+// Callers hold roomsMu.
+func noteDrainProgress(left int) {
+    drain.Lock()
+    defer drain.Unlock()
+    if drain.active && left == 0 {
+        log.Printf("Drained: the last call ended after %s", time.Since(time.Unix(0, drain.since*int64(time.Millisecond))).Round(time.Second))
+    }
+}
+
+// GET|POST|DELETE /admin/drain (admin)
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req DrainRequest
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                http.Error(w, "Invalid JSON", http.StatusBadRequest)
+                return
+            }
+        }
+        if req.TimeoutMs < 0 {
+            http.Error(w, "timeoutMs must not be negative", http.StatusBadRequest)
+            return
+        }
+        timeout := cfg.DrainTimeout
+        if req.TimeoutMs > 0 {
+            timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+        }
+        startDrain(timeout, req.Reason)
+    case http.MethodDelete:
+        stopDrain()
+    default:
+        http.Error(w, "Only GET, POST and DELETE allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(drainStatus())
+}
+
+// startDrain starts draining, or moves the deadline of a drain under way.
+func startDrain(timeout time.Duration, reason string) {
+    drain.Lock()
+    now := time.Now()
+    if !drain.active {
+        drain.active = true
+        drain.since = now.UnixNano() / int64(time.Millisecond)
+        drain.moved = 0
+        drain.registryAcked = false
+    }
+    if drain.timer != nil {
+        drain.timer.Stop()
+    }
+    drain.deadline = now.Add(timeout).UnixNano() / int64(time.Millisecond)
+    drain.reason = reason
+    drain.timer = time.AfterFunc(timeout, moveDrainedRooms)
+    drain.Unlock()
+    
+    nudgeRegistry()
+    audit(nil, "admin", "drain_start", cfg.PublicAddress, "draining", map[string]interface{}{"reason": reason, "timeoutMs": timeout.Milliseconds()})
+    log.Printf("Draining, calls still open in %s are moved: %s", timeout, reason)
+}
+
+func stopDrain() {
+    drain.Lock()
+    if !drain.active {
+        drain.Unlock()
+        return
+    }
+    drain.active = false
+    drain.registryAcked = false
+    drain.timer.Stop()
+    drain.Unlock()
+    
+    nudgeRegistry()
+    audit(nil, "admin", "drain_stop", cfg.PublicAddress, "serving", nil)
+    log.Printf("Drain cancelled, taking new calls again")
+}
+
+// moveDrainedRooms moves the calls a drain's timeout caught still open.
+func moveDrainedRooms() {
+    drain.Lock()
+    if !drain.active {
+        drain.Unlock()
+        return
+    }
+    reason := drain.reason
+    drain.Unlock()
+    
+    targets := bulkTargets(&BulkRoomRequest{All: true})
+    for _, target := range targets {
+        closeBulkTarget(target, CloseServerShutdown, "draining")
+        bulkRoomOperations.inc(target.room.labels, "move")
+        audit(target.room, "admin", "rooms_move", target.room.RoomId, "moved", map[string]interface{}{
+            "reason":       reason,
+            "participants": len(target.clients) + len(target.held),
+            "drain":        true,
+        })
+    }
+    
+    drain.Lock()
+    drain.moved += len(targets)
+    drain.Unlock()
+    log.Printf("Drain timeout: moved %d open calls", len(targets))
+}
+
+// shutdown is what SIGTERM and SIGINT do, see above.
+func shutdown(server *http.Server) {
+    log.Printf("Shutting down: moving every call, then exiting")
+    deadline := time.Now().Add(cfg.ShutdownTimeout)
+    startDrain(cfg.ShutdownTimeout, "shutdown")
+    
+    stopped := make(chan struct{})
+    go func() {
+        ctx, cancel := context.WithDeadline(context.Background(), deadline)
+        defer cancel()
+        server.Shutdown(ctx)
+        close(stopped)
+    }()
+    if tlsHTTPServer != nil {
+        tlsHTTPServer.Close()
+    }
+    moveDrainedRooms()
+    
+    // Each room closes, writing its CDR, once its read loops see the close
+    for drainStatus().Rooms > 0 && time.Now().Before(deadline) {
+        time.Sleep(50 * time.Millisecond)
+    }
+    if !flushDurableWrites(time.Until(deadline)) {
+        log.Printf("level=warn Shutdown timed out with durable writes still queued")
+    }
+    if events != nil {
+        events.handOver() // Synced and closed for the next start to load
+    }
+    <-stopped
+    os.Exit(0)
+}
+
+func drainStatus() DrainStatus {
+    roomsMu.RLock()
+    open, participants := len(rooms), 0
+    for _, room := range rooms {
+        participants += len(room.Users) + len(room.Agents) + len(room.held)
+    }
+    roomsMu.RUnlock()
+    
+    drain.Lock()
+    defer drain.Unlock()
+    status := DrainStatus{Draining: drain.active, Rooms: open, Participants: participants}
+    if drain.active {
+        status.Since, status.Deadline, status.Reason = drain.since, drain.deadline, drain.reason
+        status.Moved, status.RegistryAcked = drain.moved, drain.registryAcked
+        status.Drained = open == 0
+    }
+    return status
+}
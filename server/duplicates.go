@@ -0,0 +1,121 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Duplicate client IDs. Joining a room with a clientId someone there already
+// has, a second browser tab say, or a phone back on the network before its
+// old connection timed out, follows the room's policy:
+//
+//   replace  the default: the old connection is closed with duplicate_id,
+//            the room gets client_left with "reason": "replaced" and then
+//            the new client's client_joined
+//   reject   the new connection is refused with duplicate_client_id and
+//            closed with duplicate_id
+//   suffix   the new client joins as ID-2 (or -3, ...) beside the old one;
+//            its welcome carries the ID it got
+//
+// -duplicate-client-ids sets the policy, -duplicate-client-id-tenants and
+// -duplicate-client-id-templates override it per tenant and template, and
+// the room config in welcome says which applies as "duplicateClientIds".
+// A held session (see resume.go) keeps its ID taken. Reconnecting with the
+// resume token still takes the old place back, under the ID asked for or a
+// suffixed one.
+
+const (
+    DuplicateReplace = "replace"
+    DuplicateReject  = "reject"
+    DuplicateSuffix  = "suffix"
+)
+
+var duplicateClientIds = newCounterVec("iva_duplicate_client_ids_total", "Joins with a clientId already in the room, by the policy applied.", "policy")
+
+func init() {
+    metricSeries = append(metricSeries, duplicateClientIds)
+}
+
+// duplicateIdPolicyFor is the policy of the room's template, else its
+// tenant's, else the server's.
+func duplicateIdPolicyFor(room *RoomInfo) string {
+    policy := cfg.DuplicateIdPolicy
+    if value, ok := lookupOverride(cfg.DuplicateIdTenants, room.Tenant); ok {
+        policy = value
+    }
+    if value, ok := lookupOverride(cfg.DuplicateIdTemplates, room.Template); ok {
+        policy = value
+    }
+    return policy
+}
+
+// checkDuplicateIdPolicies refuses to start with a policy nobody knows.
+func checkDuplicateIdPolicies() error {
+    policies := []string{cfg.DuplicateIdPolicy}
+    for _, entry := range append(append([]string(nil), cfg.DuplicateIdTenants...), cfg.DuplicateIdTemplates...) {
+        _, policy, _ := strings.Cut(entry, "=")
+        policies = append(policies, policy)
+    }
+    for _, policy := range policies {
+        switch policy {
+        case DuplicateReplace, DuplicateReject, DuplicateSuffix:
+        default:
+            return fmt.Errorf("unknown policy %q, use replace, reject or suffix", policy)
+        }
+    }
+    return nil
+}
+
+// claimClientId applies the room's policy when client's ID is taken,
+// returning the participant it replaced, if any. Callers hold roomsMu.
+func claimClientId(room *RoomInfo, client *Client) (*Client, error) {
+    existing := memberOf(room, client.clientId)
+    held := room.held[client.clientId]
+    if existing == nil && held != nil {
+        existing = held.client
+    }
+    if existing == nil {
+        return nil, nil
+    }
+    
+    policy := duplicateIdPolicyFor(room)
+    duplicateClientIds.inc(room.labels, policy)
+    switch policy {
+    case DuplicateReject:
+        return nil, newCodedError(ErrDuplicateClient, "clientId %q is already in the room", client.clientId)
+    case DuplicateSuffix:
+        client.requestedId = client.clientId
+        for n := 2; ; n++ {
+            id := client.requestedId + "-" + strconv.Itoa(n)
+            if memberOf(room, id) == nil && room.held[id] == nil {
+                client.clientId = id
+                return nil, nil
+            }
+        }
+    }
+    
+    // The replaced connection's read loop finds itself superseded and leaves quietly
+    if held != nil {
+        held.timer.Stop()
+        delete(room.held, client.clientId)
+    }
+    existing.mu.Lock()
+    existing.superseded = true
+    existing.resumeToken = ""
+    existing.mu.Unlock()
+    leaveRoom(room, existing)
+    return existing, nil
+}
+
+// closeReplaced ends the connection a new client took the ID of. Closing
+// with duplicate_id keeps the SDKs from reconnecting and replacing the new
+// one in turn.
+func closeReplaced(roomId string, replaced *Client) {
+    notifyClientLeft(roomId, replaced, "replaced")
+    logAt("info", roomId, replaced.clientId, "Connection replaced by a new one with the same clientId")
+    if replaced.conn == nil {
+        return // Held since a migration, never connected here
+    }
+    closeConnection(replaced.conn, CloseDuplicateId, "replaced")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "time"
+)
+
+// Ephemeral messages are relayed immediately and never stored in room history.
+var ephemeralThrottle = map[string]time.Duration{
+    "typing":   time.Second,
+    "reaction": 200 * time.Millisecond,
+    "read":     500 * time.Millisecond,
+}
+
+func isEphemeralType(msgType string) bool {
+    _, ok := ephemeralThrottle[msgType]
+    return ok
+}
+
+// allowEphemeral reports whether the client may send another message of this
+// type yet. "typing" stops are always let through so indicators never stick.
+func allowEphemeral(client *Client, msg *Message) bool {
+    if msg.Type == "typing" {
+        if data, ok := msg.Data.(map[string]interface{}); ok && data["state"] == "stop" {
+            return true
+        }
+    }
+    
+    now := time.Now()
+    
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    if last, ok := client.lastEphemeral[msg.Type]; ok && now.Sub(last) < ephemeralThrottle[msg.Type] {
+        return false
+    }
+    client.lastEphemeral[msg.Type] = now
+    return true
+}
+
+func validEphemeral(msg *Message) bool {
+    data, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        return false
+    }
+    
+    switch msg.Type {
+    case "typing":
+        state, _ := data["state"].(string)
+        return state == "start" || state == "stop"
+    case "reaction":
+        emoji, _ := data["emoji"].(string)
+        return emoji != "" && len(emoji) <= 32 && data["messageId"] != nil
+    case "read":
+        return data["messageId"] != nil
+    }
+    return false
+}
+
+func handleEphemeral(roomId string, sender *Client, msg *Message) {
+    if !validEphemeral(msg) {
+        sendError(sender, ErrInvalidMessage, msg, "invalid %s payload", msg.Type)
+        return
+    }
+    
+    if !allowEphemeral(sender, msg) {
+        // Extra typing starts are simply coalesced, UIs send one per keystroke
+        if msg.Type != "typing" {
+            sendError(sender, ErrRateLimited, msg, "%s messages are limited to one per %v", msg.Type, ephemeralThrottle[msg.Type])
+        }
+        return
+    }
+    
+    if len(msg.To) > 0 {
+        selectiveSend(roomId, sender, msg)
+        return
+    }
+    broadcastToRoom(roomId, sender, msg)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// ErrorCode is the machine-readable reason in an "error" message.
+type ErrorCode string
+
+const (
+    ErrInvalidMessage  ErrorCode = "invalid_message"
+    ErrRateLimited     ErrorCode = "rate_limited"
+    ErrNotPermitted    ErrorCode = "not_permitted"
+    ErrRoomFull        ErrorCode = "room_full"
+    ErrDuplicateClient ErrorCode = "duplicate_client_id"
+    ErrTargetNotFound  ErrorCode = "target_not_found"
+    ErrUnavailable     ErrorCode = "unavailable"
+    ErrTooLarge        ErrorCode = "message_too_large"
+)
+
+// codedError lets validation helpers pick the code their caller reports.
+type codedError struct {
+    code ErrorCode
+    text string
+}
+
+func (e *codedError) Error() string {
+    return e.text
+}
+
+func newCodedError(code ErrorCode, format string, args ...interface{}) error {
+    return &codedError{code: code, text: fmt.Sprintf(format, args...)}
+}
+
+func errorCode(err error, fallback ErrorCode) ErrorCode {
+    if coded, ok := err.(*codedError); ok {
+        return coded.code
+    }
+    return fallback
+}
+
+// rejectConnection writes an error and closes a connection that never made it
+// into a room. The caller must make sure the client's writer is stopped.
+func rejectConnection(conn *websocket.Conn, code ErrorCode, text string) {
+    conn.WriteJSON(&Message{
+        Type: "error",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "code":    code,
+            "message": text,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    closeConnection(conn, closeCodeFor(code), "")
+}
+
+// sendError tells the sender why its request failed. ref is the offending
+// message, nil when the failure isn't tied to one (e.g. a join).
+func sendError(client *Client, code ErrorCode, ref *Message, format string, args ...interface{}) {
+    data := map[string]interface{}{
+        "code":    code,
+        "message": fmt.Sprintf(format, args...),
+    }
+    if ref != nil {
+        if ref.Id != "" {
+            data["requestId"] = ref.Id
+        }
+        data["requestType"] = ref.Type
+    }
+    
+    errorsTotal.inc(client.labels, string(code))
+    traceEvent(client.room, "error", client.clientId, data)
+    sendMessageToClient(client, &Message{
+        Type:      "error",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
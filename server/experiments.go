@@ -0,0 +1,388 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// A/B experiments on conversations. An experiment splits the rooms of a
+// tenant, and optionally one template, between weighted variants:
+//
+//   PUT /admin/experiments/greeting-v2
+//   {"tenant": "acme", "template": "billing", "variants": [
+//       {"name": "control"},
+//       {"name": "short", "prompt": "greeting_short", "voice": "en_US-amy", "flow": "fast_auth"}]}
+//
+// A room is assigned as it is created, by hashing the experiment name and
+// room ID, so the same room always lands in the same variant and no state is
+// kept per room. The voice is applied to speak; the prompt and flow are the
+// agents' to apply, from the welcome's room config:
+//
+//   "experiments": {"greeting-v2": {"variant": "short", "prompt": "greeting_short", "flow": "fast_auth"}}
+//
+// Every analytics rollup and CDR of the room carries its variants, the
+// rollups as "experiment=variant" pairs, which /analytics?groupBy=variant
+// splits on, and
+//
+//   GET /admin/experiments/NAME/report?granularity=&from=&to=
+//
+// compares each variant with the first, the control: calls, duration,
+// sentiment, first response, SLA and quality score (quality.go), with a
+// two-proportion z-test of the SLA rate. from defaults to when the experiment was created. Experiments live
+// in memory like retention policies.
+
+type ExperimentVariant struct {
+    Name   string `json:"name"`
+    Weight int    `json:"weight,omitempty"` // Share of rooms relative to the others; 0 counts as 1
+    Prompt string `json:"prompt,omitempty"`
+    Voice  string `json:"voice,omitempty"`
+    Flow   string `json:"flow,omitempty"`
+}
+
+type Experiment struct {
+    Name      string              `json:"name"`
+    Tenant    string              `json:"tenant"`
+    Template  string              `json:"template,omitempty"` // Empty for all of the tenant's templates
+    Variants  []ExperimentVariant `json:"variants"`
+    CreatedAt int64               `json:"createdAt"`
+}
+
+var (
+    experiments   = make(map[string]*Experiment) // By name
+    experimentsMu sync.RWMutex                   // Taken under roomsMu, never the other way
+)
+
+func (v ExperimentVariant) weight() int {
+    if v.Weight <= 0 {
+        return 1
+    }
+    return v.Weight
+}
+
+// Names go into the rollups' variant tag, so they cannot carry its separators.
+func validExperimentName(name string) bool {
+    return name != "" && !strings.ContainsAny(name, ",=;")
+}
+
+// assign picks the room's variant.
+func (e *Experiment) assign(roomId string) ExperimentVariant {
+    sum := sha256.Sum256([]byte(e.Name + "\x00" + roomId))
+    total := 0
+    for _, variant := range e.Variants {
+        total += variant.weight()
+    }
+    point := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+    for _, variant := range e.Variants {
+        if point < variant.weight() {
+            return variant
+        }
+        point -= variant.weight()
+    }
+    return e.Variants[len(e.Variants)-1]
+}
+
+// assignVariants puts a new room in a variant of each experiment running on
+// its tenant and template. Callers hold roomsMu.
+func assignVariants(room *RoomInfo) {
+    experimentsMu.RLock()
+    defer experimentsMu.RUnlock()
+    for _, experiment := range experiments {
+        if experiment.Tenant != room.Tenant || (experiment.Template != "" && experiment.Template != room.Template) {
+            continue
+        }
+        variant := experiment.assign(room.RoomId)
+        if room.Variants == nil {
+            room.Variants = make(map[string]string)
+            room.variants = make(map[string]ExperimentVariant)
+        }
+        room.Variants[experiment.Name] = variant.Name
+        room.variants[experiment.Name] = variant
+    }
+}
+
+// variantTag is the rollups' variant dimension: "experiment=variant" pairs
+// sorted by experiment.
+func variantTag(variants map[string]string) string {
+    pairs := make([]string, 0, len(variants))
+    for experiment, variant := range variants {
+        pairs = append(pairs, experiment+"="+variant)
+    }
+    sort.Strings(pairs)
+    return strings.Join(pairs, ",")
+}
+
+// tagVariant finds an experiment's variant in a variant tag.
+func tagVariant(tag string, experiment string) (string, bool) {
+    for _, pair := range strings.Split(tag, ",") {
+        if name := strings.TrimPrefix(pair, experiment+"="); name != pair {
+            return name, true
+        }
+    }
+    return "", false
+}
+
+// experimentVoice is the voice a room's variants speak in, if any sets one.
+// Callers hold roomsMu.
+func experimentVoice(room *RoomInfo) string {
+    names := make([]string, 0, len(room.variants))
+    for name := range room.variants {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if voice := room.variants[name].Voice; voice != "" {
+            return voice
+        }
+    }
+    return ""
+}
+
+// roomExperiments is the room config's experiments, nil outside any.
+func roomExperiments(room *RoomInfo) map[string]interface{} {
+    if len(room.variants) == 0 {
+        return nil
+    }
+    out := make(map[string]interface{}, len(room.variants))
+    for name, variant := range room.variants {
+        settings := map[string]interface{}{"variant": variant.Name}
+        if variant.Prompt != "" {
+            settings["prompt"] = variant.Prompt
+        }
+        if variant.Voice != "" {
+            settings["voice"] = variant.Voice
+        }
+        if variant.Flow != "" {
+            settings["flow"] = variant.Flow
+        }
+        out[name] = settings
+    }
+    return out
+}
+
+// Admin API: /admin/experiments, /admin/experiments/NAME and
+// /admin/experiments/NAME/report
+
+func handleExperiments(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/experiments"), "/")
+    if path == "" {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        experimentsMu.RLock()
+        list := make([]*Experiment, 0, len(experiments))
+        for _, experiment := range experiments {
+            list = append(list, experiment)
+        }
+        experimentsMu.RUnlock()
+        sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+        json.NewEncoder(w).Encode(map[string]interface{}{"experiments": list})
+        return
+    }
+    
+    name, report := strings.TrimSuffix(path, "/report"), strings.HasSuffix(path, "/report")
+    if report {
+        handleExperimentReport(w, r, name)
+        return
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+        experimentsMu.RLock()
+        experiment := experiments[name]
+        experimentsMu.RUnlock()
+        if experiment == nil {
+            http.Error(w, "Experiment not found", http.StatusNotFound)
+            return
+        }
+        json.NewEncoder(w).Encode(experiment)
+    
+    case http.MethodPut:
+        var experiment Experiment
+        if err := json.NewDecoder(r.Body).Decode(&experiment); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        experiment.Name = name
+        if !validExperimentName(name) {
+            http.Error(w, "Experiment names cannot contain , = or ;", http.StatusBadRequest)
+            return
+        }
+        if len(experiment.Variants) < 2 {
+            http.Error(w, "An experiment needs at least two variants", http.StatusBadRequest)
+            return
+        }
+        seen := make(map[string]bool)
+        for _, variant := range experiment.Variants {
+            if !validExperimentName(variant.Name) || seen[variant.Name] {
+                http.Error(w, "Variants need unique names without , = or ;", http.StatusBadRequest)
+                return
+            }
+            if variant.Weight < 0 {
+                http.Error(w, "Weights must not be negative", http.StatusBadRequest)
+                return
+            }
+            seen[variant.Name] = true
+        }
+        
+        // Changing an experiment keeps its start, so the report still covers it
+        experimentsMu.Lock()
+        if previous := experiments[name]; previous != nil {
+            experiment.CreatedAt = previous.CreatedAt
+        } else {
+            experiment.CreatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+        }
+        experiments[name] = &experiment
+        experimentsMu.Unlock()
+        
+        log.Printf("Experiment %s set for tenant %q template %q with %d variants", name, experiment.Tenant, experiment.Template, len(experiment.Variants))
+        json.NewEncoder(w).Encode(&experiment)
+    
+    case http.MethodDelete:
+        experimentsMu.Lock()
+        delete(experiments, name)
+        experimentsMu.Unlock()
+        
+        w.WriteHeader(http.StatusNoContent)
+    
+    default:
+        http.Error(w, "Only GET, PUT and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// GET /admin/experiments/NAME/report?granularity=hour|day&from=&to= (admin)
+func handleExperimentReport(w http.ResponseWriter, r *http.Request, name string) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    experimentsMu.RLock()
+    experiment := experiments[name]
+    experimentsMu.RUnlock()
+    if experiment == nil {
+        http.Error(w, "Experiment not found", http.StatusNotFound)
+        return
+    }
+    
+    query := r.URL.Query()
+    granularity := query.Get("granularity")
+    if granularity == "" {
+        granularity = "day"
+    }
+    if granularity != "hour" && granularity != "day" {
+        http.Error(w, "granularity must be hour or day", http.StatusBadRequest)
+        return
+    }
+    from, err := parseAnalyticsTime(query.Get("from"), time.Unix(0, experiment.CreatedAt*int64(time.Millisecond)))
+    if err != nil {
+        http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseAnalyticsTime(query.Get("to"), time.Now())
+    if err != nil {
+        http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    rows, err := store.Analytics().Query(r.Context(), granularity, bucketStart(granularity, from), to, experiment.Tenant)
+    if err != nil {
+        log.Printf("Analytics query failed: %v", err)
+        http.Error(w, "Analytics unavailable", http.StatusInternalServerError)
+        return
+    }
+    totals := make(map[string]*storage.Rollup)
+    for _, row := range rows {
+        if experiment.Template != "" && row.Template != experiment.Template {
+            continue
+        }
+        variant, ok := tagVariant(row.Variant, name)
+        if !ok {
+            continue
+        }
+        if totals[variant] == nil {
+            totals[variant] = &storage.Rollup{Granularity: granularity, Tenant: experiment.Tenant, Template: experiment.Template}
+        }
+        totals[variant].Merge(row)
+    }
+    
+    control := experiment.Variants[0].Name
+    if totals[control] == nil {
+        totals[control] = &storage.Rollup{}
+    }
+    variants := make([]map[string]interface{}, 0, len(experiment.Variants))
+    for _, variant := range experiment.Variants {
+        total := totals[variant.Name]
+        if total == nil {
+            total = &storage.Rollup{}
+        }
+        row := map[string]interface{}{
+            "variant":            variant.Name,
+            "calls":              total.Calls,
+            "avgDurationMs":      ratio(float64(total.DurationMs), total.Calls),
+            "avgSentiment":       ratio(total.SentimentSum, total.SentimentCount),
+            "avgFirstResponseMs": ratio(float64(total.FirstResponseMs), total.Responded),
+            "slaMet":             total.SLAMet,
+            "slaMissed":          total.SLAMissed,
+            "slaPct":             100 * ratio(float64(total.SLAMet), total.SLAMet+total.SLAMissed),
+            "profaneCalls":       total.ProfaneCalls,
+            "intents":            total.Intents,
+            "scored":             total.Scored,
+            "avgQuality":         ratio(total.OverallSum, total.Scored),
+            "lowQuality":         total.LowQuality,
+        }
+        if variant.Name != control {
+            row["vsControl"] = compareVariant(totals[control], total)
+        }
+        variants = append(variants, row)
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "experiment":  experiment,
+        "granularity": granularity,
+        "from":        from,
+        "to":          to,
+        "control":     control,
+        "variants":    variants,
+    })
+}
+
+// compareVariant sets a variant against the control: differences in the
+// averages, and whether its SLA rate differs by more than chance.
+func compareVariant(control *storage.Rollup, variant *storage.Rollup) map[string]interface{} {
+    comparison := map[string]interface{}{
+        "avgDurationMsDelta":      ratio(float64(variant.DurationMs), variant.Calls) - ratio(float64(control.DurationMs), control.Calls),
+        "avgSentimentDelta":       ratio(variant.SentimentSum, variant.SentimentCount) - ratio(control.SentimentSum, control.SentimentCount),
+        "avgFirstResponseMsDelta": ratio(float64(variant.FirstResponseMs), variant.Responded) - ratio(float64(control.FirstResponseMs), control.Responded),
+        "avgQualityDelta":         ratio(variant.OverallSum, variant.Scored) - ratio(control.OverallSum, control.Scored),
+    }
+    n1, n2 := control.SLAMet+control.SLAMissed, variant.SLAMet+variant.SLAMissed
+    if n1 == 0 || n2 == 0 {
+        return comparison
+    }
+    p1, p2 := float64(control.SLAMet)/float64(n1), float64(variant.SLAMet)/float64(n2)
+    pooled := float64(control.SLAMet+variant.SLAMet) / float64(n1+n2)
+    comparison["slaPctDelta"] = 100 * (p2 - p1)
+    stderr := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+    if stderr == 0 {
+        return comparison
+    }
+    z := (p2 - p1) / stderr
+    p := math.Erfc(math.Abs(z) / math.Sqrt2)
+    comparison["slaZ"] = z
+    comparison["slaPValue"] = p
+    comparison["significant"] = p < 0.05
+    return comparison
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+// Failover of calls off a media server that dies. With -failover-checkpoints
+// a media server sends a checkpoint of its rooms with every heartbeat: what a
+// migration moves (see migration.go), with a SHA-256 hash of each resume
+// token rather than the token, so the registry holds nothing a client could
+// resume with. A registry
+// with -failover-after takes a server that hasn't heartbeated for that long
+// for dead. It stops allocating the server and recreates each room of its
+// last checkpoint on a healthy one, through that server's POST
+// /admin/rooms/import with the -admin-token the fleet shares, and there the
+// participants are held for the resume grace.
+//
+// Clients learn where their room went when they reconnect through GET
+// /allocate: asked for a room that failed over, it allocates the server the
+// room is on now, ticket and all. The JS SDK with allocate set reconnects
+// like that and takes its place back with its resume token. Clients
+// connecting to a server directly have nobody to ask and join afresh.
+//
+// A room comes back as it was at the last heartbeat before the crash:
+// participants who joined after it join afresh and the messages since are
+// lost. Rooms no healthy server takes, and those of a server that sent no
+// checkpoints, are lost. Each room counts in iva_failovers_total by outcome,
+// recreated or lost, and gets a failover entry in the registry's audit log.
+// A server only cut off from the registry keeps its calls while their copies
+// wait for resumes elsewhere, so set -failover-after well above
+// -registry-healthy-window.
+
+// heartbeat is what a media server posts to /heartbeat.
+type heartbeat struct {
+    ServerInfo
+    Rooms []RoomMigration `json:"rooms,omitempty"` // With -failover-checkpoints
+}
+
+var (
+    checkpoints = make(map[string][]RoomMigration) // By server address:port, guarded by serversMu
+    failovers   = make(map[string]failedOver)      // By room ID
+    failoversMu sync.Mutex
+)
+
+type failedOver struct {
+    server ServerInfo
+    until  time.Time
+}
+
+var failoversTotal = newCounterVec("iva_failovers_total", "Rooms of dead media servers, by outcome: recreated or lost.", "outcome")
+
+func init() {
+    metricSeries = append(metricSeries, failoversTotal)
+}
+
+func serverKey(server ServerInfo) string {
+    return fmt.Sprintf("%s:%d", server.Address, server.Port)
+}
+
+// roomCheckpoints snapshots every room for the next heartbeat.
+func roomCheckpoints() []RoomMigration {
+    roomsMu.RLock()
+    ids := make([]string, 0, len(rooms))
+    for roomId := range rooms {
+        ids = append(ids, roomId)
+    }
+    roomsMu.RUnlock()
+    
+    checkpoint := make([]RoomMigration, 0, len(ids))
+    for _, roomId := range ids {
+        journal := lockJournal(roomId)
+        migration := snapshotRoom(roomId, journal.seq)
+        journal.mu.Unlock()
+        if migration != nil {
+            for i := range migration.Participants {
+                hashCheckpointToken(&migration.Participants[i])
+            }
+            checkpoint = append(checkpoint, *migration)
+        }
+    }
+    return checkpoint
+}
+
+// hashCheckpointToken swaps a participant's resume token for its hash. The
+// server a room fails over to checks a resume against the hash, see
+// tokenMatches.
+func hashCheckpointToken(p *MigratedParticipant) {
+    if p.ResumeToken != "" {
+        p.ResumeTokenHash = hashResumeToken(p.ResumeToken)
+        p.ResumeToken = ""
+    }
+}
+
+// noteCheckpoint keeps a server's latest checkpoint. Callers hold serversMu.
+func noteCheckpoint(server ServerInfo, rooms []RoomMigration) {
+    if cfg.FailoverAfter <= 0 {
+        return
+    }
+    checkpoints[serverKey(server)] = rooms
+}
+
+func startFailover() {
+    if cfg.FailoverAfter <= 0 {
+        return
+    }
+    go func() {
+        for range time.Tick(cfg.FailoverAfter / 4) {
+            failOverDeadServers()
+        }
+    }()
+}
+
+// failOverDeadServers drops the servers silent for -failover-after and
+// recreates their rooms elsewhere.
+func failOverDeadServers() {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    serversMu.Lock()
+    alive := make([]ServerInfo, 0, len(servers))
+    var orphaned []RoomMigration
+    for _, server := range servers {
+        if now-server.LastSeen <= cfg.FailoverAfter.Milliseconds() {
+            alive = append(alive, server)
+            continue
+        }
+        key := serverKey(server)
+        log.Printf("level=warn Server %s missed heartbeats for %s, failing over its %d rooms", key, cfg.FailoverAfter, len(checkpoints[key]))
+        orphaned = append(orphaned, checkpoints[key]...)
+        delete(checkpoints, key)
+    }
+    servers = alive
+    serversMu.Unlock()
+    
+    for _, migration := range orphaned {
+        failOverRoom(migration)
+    }
+}
+
+func failOverRoom(migration RoomMigration) {
+    room := &RoomInfo{RoomId: migration.RoomId, Tenant: migration.Tenant, Template: migration.Template}
+    labels := metricLabelsFor(migration.Tenant, migration.Template)
+    for _, target := range failoverTargets() {
+        key := serverKey(target)
+        if err := importRoom(serverURL(target), &migration); err != nil {
+            logAt("warn", migration.RoomId, "", "Failover to %s failed: %v", key, err)
+            continue
+        }
+        failoversMu.Lock()
+        failovers[migration.RoomId] = failedOver{server: target, until: time.Now().Add(cfg.ResumeGrace)}
+        failoversMu.Unlock()
+        failoversTotal.inc(labels, "recreated")
+        audit(room, "registry", "failover", migration.RoomId, "recreated", map[string]interface{}{
+            "server":       key,
+            "participants": len(migration.Participants),
+        })
+        logAt("info", migration.RoomId, "", "Room failed over to %s", key)
+        return
+    }
+    failoversTotal.inc(labels, "lost")
+    audit(room, "registry", "failover", migration.RoomId, "lost", nil)
+    logAt("warn", migration.RoomId, "", "Room lost, no healthy server took it")
+}
+
+// failoverTargets lists the healthy servers taking calls, in random order.
+func failoverTargets() []ServerInfo {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    serversMu.Lock()
+    defer serversMu.Unlock()
+    var targets []ServerInfo
+    for _, server := range servers {
+        if !server.Draining && serverHealthy(server, now) {
+            targets = append(targets, server)
+        }
+    }
+    rnd.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+    return targets
+}
+
+// failedOverTo is the server a room was recreated on, while its
+// participants may still resume there.
+func failedOverTo(roomId string) (ServerInfo, bool) {
+    failoversMu.Lock()
+    defer failoversMu.Unlock()
+    failover, ok := failovers[roomId]
+    if ok && time.Now().After(failover.until) {
+        delete(failovers, roomId)
+        return ServerInfo{}, false
+    }
+    return failover.server, ok
+}
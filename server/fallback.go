@@ -0,0 +1,456 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+    "github.com/yourusername/my-go-project/breaker"
+    "github.com/yourusername/my-go-project/tts"
+)
+
+// Fallback routing when integrations fail. Speech provider calls go through
+// a circuit breaker per provider: -breaker-threshold failures in a row open
+// it for -breaker-cooldown, then one probe decides whether it closes. The
+// LLM and knowledge graph live in the agent, which keeps its own breakers
+// and reports them:
+//
+//   {"type": "integration_status", "data": {"integration": "llm", "state": "open", "error": "..."}}
+//
+// When a room loses an integration its fallback policy decides what the
+// caller gets, once per outage:
+//
+//   canned    a stock prompt for the integration, the call carries on degraded
+//   human     a transfer prompt and a handoff_requested event for human agents
+//   callback  an apology and a callback request for each caller, see callbacks.go
+//   none      nothing beyond telling the agents
+//
+// Either way the room gets a fallback message, so agents adapt and text
+// clients show the prompt. -fallback-policy is the default;
+// -fallback-policies overrides it per INTEGRATION or TEMPLATE/INTEGRATION.
+// Prompts are built in, a -fallback-prompts directory replaces them with
+// KEY.txt texts and KEY.wav recordings. Recordings play even with every TTS
+// provider down. A text that is a <speak> document is spoken as SSML and
+// shown to text clients without its tags. Other locales' prompts come from the message catalog, see
+// locale.go.
+
+const (
+    fallbackCanned   = "canned"
+    fallbackHuman    = "human"
+    fallbackCallback = "callback"
+    fallbackNone     = "none"
+)
+
+var fallbackIntegrations = []string{"stt", "tts", "llm", "kg"}
+
+// Prompt keys are the integrations for canned, plus human and callback
+var defaultFallbackPrompts = map[string]string{
+    "stt":      "Sorry, I'm having trouble hearing you right now. Please bear with me.",
+    "tts":      "Sorry, I'm having trouble speaking right now. Please bear with me.",
+    "llm":      "Sorry, I can't look that up right now. Please bear with me.",
+    "kg":       "Sorry, I can't reach your account details right now, but I can still help with general questions.",
+    "human":    "I'm having some technical trouble, so I'm connecting you with a colleague. Please stay on the line.",
+    "callback": "We're sorry, we're having technical difficulties. We'll call you back as soon as we can.",
+}
+
+type fallbackPrompt struct {
+    text  string
+    audio []byte // pcm16 mono at -tts-sample-rate, nil to synthesize the text
+}
+
+var fallbackPrompts = make(map[string]*fallbackPrompt)
+
+var (
+    breakers   = make(map[string]*breaker.Breaker) // By INTEGRATION:PROVIDER
+    breakersMu sync.Mutex
+)
+
+var (
+    breakerTransitions = newCounterVec("iva_breaker_transitions_total", "Circuit breaker state changes by integration and new state.", "integration", "state")
+    fallbacksTotal     = newCounterVec("iva_fallbacks_total", "Fallback policies applied to rooms by integration and action.", "integration", "action")
+)
+
+func init() {
+    metricSeries = append(metricSeries, breakerTransitions, fallbacksTotal)
+}
+
+// loadFallbacks checks the policies and reads the prompts.
+func loadFallbacks() error {
+    policies := append([]string{"=" + cfg.FallbackPolicy}, cfg.FallbackPolicies...)
+    for _, entry := range policies {
+        _, action, _ := strings.Cut(entry, "=")
+        switch action {
+        case fallbackCanned, fallbackHuman, fallbackCallback, fallbackNone:
+        default:
+            return fmt.Errorf("unknown fallback policy %q, want canned, human, callback or none", entry)
+        }
+    }
+    
+    format := audiogen.Format{SampleRate: cfg.TTSSampleRate, Channels: 1}
+    for key, text := range defaultFallbackPrompts {
+        prompt := &fallbackPrompt{text: text}
+        if cfg.FallbackPromptsDir != "" {
+            data, err := os.ReadFile(filepath.Join(cfg.FallbackPromptsDir, key+".txt"))
+            if err == nil {
+                prompt.text = strings.TrimSpace(string(data))
+            } else if !os.IsNotExist(err) {
+                return err
+            }
+            if err := checkPromptSSML(prompt.text); err != nil {
+                return fmt.Errorf("%s.txt: %v", key, err)
+            }
+            prompt.audio, err = audiogen.LoadWAV(filepath.Join(cfg.FallbackPromptsDir, key+".wav"), format)
+            if err != nil && !os.IsNotExist(err) {
+                return fmt.Errorf("%s.wav: %v", key, err)
+            }
+        }
+        fallbackPrompts[key] = prompt
+    }
+    return nil
+}
+
+// breakerFor returns the circuit breaker guarding one provider of an
+// integration, creating it on first use.
+func breakerFor(integration string, provider string) *breaker.Breaker {
+    name := integration + ":" + provider
+    breakersMu.Lock()
+    defer breakersMu.Unlock()
+    if b := breakers[name]; b != nil {
+        return b
+    }
+    b := breaker.New(name, cfg.BreakerThreshold, cfg.BreakerCooldown)
+    b.OnChange = func(b *breaker.Breaker, from breaker.State, to breaker.State) {
+        breakerTransitions.inc(nil, integration, string(to))
+        status := b.Status()
+        log.Printf("Circuit %s %s -> %s (%d failures%s)", name, from, to, status.Failures, logSuffix(status.LastError))
+        if to == breaker.Closed {
+            clearFallbacks(integration)
+        }
+    }
+    breakers[name] = b
+    return b
+}
+
+func logSuffix(lastError string) string {
+    if lastError == "" {
+        return ""
+    }
+    return ", last: " + lastError
+}
+
+// integrationError reports a failed call to the breaker. A room that can't
+// go on without the integration falls back straight away, others once the
+// circuit opens.
+func integrationError(room *RoomInfo, integration string, b *breaker.Breaker, err error, required bool) {
+    b.Failure(err)
+    if required || b.State() != breaker.Closed {
+        integrationFailed(room, integration, fmt.Sprintf("%s: %v", b.Name, err))
+    }
+}
+
+func fallbackPolicyFor(room *RoomInfo, integration string) string {
+    if room.Template != "" {
+        if action, ok := lookupOverride(cfg.FallbackPolicies, room.Template+"/"+integration); ok {
+            return action
+        }
+    }
+    if action, ok := lookupOverride(cfg.FallbackPolicies, integration); ok {
+        return action
+    }
+    return cfg.FallbackPolicy
+}
+
+// integrationFailed applies the room's fallback policy, unless it already
+// did for this outage.
+func integrationFailed(room *RoomInfo, integration string, reason string) {
+    roomsMu.Lock()
+    if _, applied := room.fallbacks[integration]; applied {
+        roomsMu.Unlock()
+        return
+    }
+    action := fallbackPolicyFor(room, integration)
+    if room.fallbacks == nil {
+        room.fallbacks = make(map[string]string)
+    }
+    room.fallbacks[integration] = action
+    if action == fallbackHuman {
+        room.waitingSince = time.Now().UnixNano() / int64(time.Millisecond) // For a human, see callbacks.go
+    }
+    roomsMu.Unlock()
+    
+    fallbacksTotal.inc(room.labels, integration, action)
+    logAt("warn", room.RoomId, "", "%s unavailable (%s), falling back to %s", integration, reason, action)
+    
+    data := map[string]interface{}{
+        "integration": integration,
+        "action":      action,
+        "reason":      reason,
+    }
+    key := ""
+    switch action {
+    case fallbackCanned:
+        key = integration
+    case fallbackHuman:
+        key = "human"
+        emitEvent("handoff_requested", room, map[string]interface{}{
+            "integration": integration,
+            "reason":      reason,
+        })
+    case fallbackCallback:
+        key = "callback"
+        _, users, _ := roomMembers(room.RoomId)
+        for _, user := range users {
+            go captureCallback(room, user, "outage", integration+" unavailable: "+reason, "", 0)
+        }
+    }
+    var prompt *fallbackPrompt
+    if key != "" {
+        prompt = fallbackPromptFor(room, key) // See locale.go
+        data["text"] = promptText(prompt.text)
+        data["audio"] = promptPlayable(prompt) // Else agents speak it
+    }
+    
+    broadcastToRoom(room.RoomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "fallback",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    emitEvent("fallback", room, data)
+    if prompt != nil {
+        go playFallbackPrompt(context.Background(), room, prompt)
+    }
+}
+
+// clearFallbacks ends an outage for every room that fell back, so the next
+// one applies the policy again.
+func clearFallbacks(integration string) {
+    roomsMu.Lock()
+    var cleared []*RoomInfo
+    for _, room := range rooms {
+        if _, applied := room.fallbacks[integration]; applied {
+            delete(room.fallbacks, integration)
+            cleared = append(cleared, room)
+        }
+    }
+    roomsMu.Unlock()
+    for _, room := range cleared {
+        announceRecovery(room, integration)
+    }
+}
+
+func clearRoomFallback(room *RoomInfo, integration string) {
+    roomsMu.Lock()
+    _, applied := room.fallbacks[integration]
+    delete(room.fallbacks, integration)
+    roomsMu.Unlock()
+    if applied {
+        announceRecovery(room, integration)
+    }
+}
+
+func announceRecovery(room *RoomInfo, integration string) {
+    logAt("info", room.RoomId, "", "%s available again", integration)
+    data := map[string]interface{}{"integration": integration}
+    sendToAgents(room.RoomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "fallback_cleared",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    emitEvent("fallback_cleared", room, data)
+}
+
+// roomFallbacks copies the integrations a room is falling back for.
+func roomFallbacks(room *RoomInfo) map[string]string {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    if len(room.fallbacks) == 0 {
+        return nil
+    }
+    fallbacks := make(map[string]string, len(room.fallbacks))
+    for integration, action := range room.fallbacks {
+        fallbacks[integration] = action
+    }
+    return fallbacks
+}
+
+// playFallbackPrompt plays a prompt's recording to the room's users, or
+// failing that the text through the first TTS provider that is up, until
+// ctx is done.
+func playFallbackPrompt(ctx context.Context, room *RoomInfo, prompt *fallbackPrompt) {
+    audio, rate := prompt.audio, cfg.TTSSampleRate
+    if audio == nil {
+        audio, rate = synthesizePrompt(room, prompt.text)
+    }
+    if len(audio) == 0 {
+        return
+    }
+    
+    frameMs := cfg.AudioFrameMs
+    if frameMs <= 0 {
+        frameMs = 20
+    }
+    frameBytes := rate * 2 * frameMs / 1000
+    interval := time.Duration(frameMs) * time.Millisecond
+    start := time.Now()
+    mark := callWatermark(room.RoomId, true)
+    for i := 0; i*frameBytes < len(audio) && ctx.Err() == nil; i++ {
+        end := (i + 1) * frameBytes
+        if end > len(audio) {
+            end = len(audio)
+        }
+        time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+        chunk := audio[i*frameBytes : end]
+        if mark != nil {
+            chunk = mark.Embed(chunk)
+        }
+        forwardAudioToUsers(room.RoomId, SystemSender, chunk, false)
+    }
+}
+
+// promptPlayable guesses whether playFallbackPrompt will have audio.
+func promptPlayable(prompt *fallbackPrompt) bool {
+    if prompt.audio != nil {
+        return true
+    }
+    for _, provider := range ttsProviders {
+        if breakerFor("tts", provider.Name()).State() != breaker.Open {
+            return true
+        }
+    }
+    return false
+}
+
+func synthesizePrompt(room *RoomInfo, text string) ([]byte, int) {
+    settings := ttsSettingsFor(room)
+    names := make([]string, 0, len(ttsProviders))
+    for name := range ttsProviders {
+        if name != settings.Provider {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    if ttsProviders[settings.Provider] != nil {
+        names = append([]string{settings.Provider}, names...)
+    }
+    
+    for _, name := range names {
+        provider := ttsProviders[name]
+        b := breakerFor("tts", provider.Name())
+        if !b.Allow() {
+            continue
+        }
+        req := tts.Request{Text: text, SSML: tts.IsSSML(text), SampleRate: cfg.TTSSampleRate, Speed: 1}
+        if name == settings.Provider {
+            req.Voice, req.Style, req.Speed = settings.Voice, settings.Style, settings.Speed
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        audio, err := provider.Synthesize(ctx, pronounced(room.Tenant, provider, req))
+        var pcm []byte
+        if err == nil {
+            pcm, err = io.ReadAll(audio.Body)
+            audio.Body.Close()
+        }
+        cancel()
+        if err != nil {
+            b.Failure(err)
+            logAt("warn", room.RoomId, "", "Fallback prompt not synthesized by %s: %v", name, err)
+            continue
+        }
+        b.Success()
+        return pcm, audio.SampleRate
+    }
+    return nil, 0
+}
+
+// integration_status: {"integration": "llm", "state": "open"|"closed", "error": "..."}
+func handleIntegrationStatus(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents report integration status")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    integration, _ := data["integration"].(string)
+    state, _ := data["state"].(string)
+    known := false
+    for _, name := range fallbackIntegrations {
+        known = known || name == integration
+    }
+    if !known || (state != string(breaker.Open) && state != string(breaker.Closed)) {
+        sendError(sender, ErrInvalidMessage, msg, "integration_status needs an integration (%s) and a state (open or closed)", strings.Join(fallbackIntegrations, ", "))
+        return
+    }
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    
+    if state == string(breaker.Closed) {
+        clearRoomFallback(room, integration)
+        return
+    }
+    reason, _ := data["error"].(string)
+    if reason == "" {
+        reason = "reported by " + sender.clientId
+    }
+    integrationFailed(room, integration, reason)
+}
+
+// GET /admin/breakers (admin): every circuit breaker's state.
+// POST /admin/breakers/NAME/reset closes one, e.g. after a provider outage
+// was fixed.
+func handleBreakers(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/breakers"), "/")
+    
+    if path == "" {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        breakersMu.Lock()
+        statuses := make([]breaker.Status, 0, len(breakers))
+        for _, b := range breakers {
+            statuses = append(statuses, b.Status())
+        }
+        breakersMu.Unlock()
+        sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(statuses)
+        return
+    }
+    
+    name, action, _ := strings.Cut(path, "/")
+    if action != "reset" {
+        http.NotFound(w, r)
+        return
+    }
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    breakersMu.Lock()
+    b := breakers[name]
+    breakersMu.Unlock()
+    if b == nil {
+        http.Error(w, "No such breaker", http.StatusNotFound)
+        return
+    }
+    b.Reset()
+    w.WriteHeader(http.StatusNoContent)
+}
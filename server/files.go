@@ -0,0 +1,205 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+type SharedFile struct {
+    Id        string `json:"fileId"`
+    RoomId    string `json:"roomId"`
+    Owner     string `json:"owner"`
+    Name      string `json:"name"`
+    MimeType  string `json:"mimeType"`
+    Size      int64  `json:"size"`
+    Sha256    string `json:"sha256"`
+    URL       string `json:"url"`
+    ExpiresAt int64  `json:"expiresAt"`
+    data      []byte
+}
+
+var (
+    sharedFiles      = make(map[string]*SharedFile)
+    sharedFilesBytes int64
+    sharedFilesMu    sync.Mutex
+)
+
+func newRandomId() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+func evictExpiredFiles(now int64) {
+    for id, file := range sharedFiles {
+        if file.ExpiresAt <= now {
+            sharedFilesBytes -= file.Size
+            delete(sharedFiles, id)
+        }
+    }
+}
+
+func storeSharedFile(file *SharedFile) error {
+    sharedFilesMu.Lock()
+    defer sharedFilesMu.Unlock()
+    
+    evictExpiredFiles(time.Now().UnixNano() / int64(time.Millisecond))
+    
+    if sharedFilesBytes+file.Size > cfg.MaxFileStoreBytes {
+        return fmt.Errorf("file store full")
+    }
+    sharedFiles[file.Id] = file
+    sharedFilesBytes += file.Size
+    return nil
+}
+
+func lookupSharedFile(id string) *SharedFile {
+    sharedFilesMu.Lock()
+    defer sharedFilesMu.Unlock()
+    
+    file := sharedFiles[id]
+    if file == nil || file.ExpiresAt <= time.Now().UnixNano()/int64(time.Millisecond) {
+        return nil
+    }
+    return file
+}
+
+// POST /room/ROOM_ID/files?clientId=CLIENT_ID&name=NAME[&to=ID,ID]
+// The raw request body is the file, and X-Resume-Token the uploader's resume
+// token. Participants are told through a file_shared message and download it
+// from /files/FILE_ID.
+func handleRoomFileUpload(w http.ResponseWriter, r *http.Request, roomId string) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    query := r.URL.Query()
+    owner := roomParticipant(r, roomId)
+    if owner == nil {
+        http.Error(w, "clientId must be connected to the room, with its resume token in X-Resume-Token", http.StatusForbidden)
+        return
+    }
+    if !hasPermission(owner, PermShareFile) {
+        http.Error(w, "Role may not share files", http.StatusForbidden)
+        return
+    }
+    
+    name := path.Base(query.Get("name"))
+    if name == "" || name == "." || name == "/" {
+        http.Error(w, "name query param required", http.StatusBadRequest)
+        return
+    }
+    
+    data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxFileBytes))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("File larger than %d bytes", cfg.MaxFileBytes), http.StatusRequestEntityTooLarge)
+        return
+    }
+    if len(data) == 0 {
+        http.Error(w, "Empty file", http.StatusBadRequest)
+        return
+    }
+    
+    mimeType := r.Header.Get("Content-Type")
+    if mimeType == "" || strings.HasPrefix(mimeType, "application/x-www-form-urlencoded") {
+        mimeType = http.DetectContentType(data)
+    }
+    
+    sum := sha256.Sum256(data)
+    file := &SharedFile{
+        Id:        newRandomId(),
+        RoomId:    roomId,
+        Owner:     owner.clientId,
+        Name:      name,
+        MimeType:  mimeType,
+        Size:      int64(len(data)),
+        Sha256:    hex.EncodeToString(sum[:]),
+        ExpiresAt: time.Now().Add(cfg.FileRetention).UnixNano() / int64(time.Millisecond),
+        data:      data,
+    }
+    file.URL = "/files/" + file.Id
+    
+    if err := storeSharedFile(file); err != nil {
+        http.Error(w, err.Error(), http.StatusInsufficientStorage)
+        return
+    }
+    
+    // The announcement ends up in chat history, so it must not pin the file contents
+    announced := *file
+    announced.data = nil
+    
+    msg := &Message{
+        Id:        newMessageId(),
+        Type:      "file_shared",
+        From:      owner.clientId,
+        Data:      &announced,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    if to := query.Get("to"); to != "" {
+        msg.To = splitList(to)
+        selectiveSend(roomId, owner, msg)
+    } else {
+        broadcastToRoom(roomId, owner, msg)
+    }
+    recordHistory(roomId, msg)
+    
+    log.Printf("Client %s shared file %s (%d bytes) in room %s", owner.clientId, name, file.Size, roomId)
+    
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(file)
+}
+
+func handleFileDownload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    file := lookupSharedFile(strings.TrimPrefix(r.URL.Path, "/files/"))
+    if file == nil {
+        http.Error(w, "File not found", http.StatusNotFound)
+        return
+    }
+    
+    // The type is the uploader's word, so never let a browser render or sniff it
+    w.Header().Set("Content-Type", file.MimeType)
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+    w.Header().Set("X-Content-Sha256", file.Sha256)
+    w.Write(file.data)
+}
+
+// handleFileReceipt routes a recipient's completion receipt back to the
+// uploader, flagging checksum mismatches reported by the client.
+func handleFileReceipt(roomId string, sender *Client, msg *Message) {
+    data, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        sendError(sender, ErrInvalidMessage, msg, "file_receipt data must be an object")
+        return
+    }
+    
+    fileId, _ := data["fileId"].(string)
+    file := lookupSharedFile(fileId)
+    if file == nil || file.RoomId != roomId {
+        sendError(sender, ErrTargetNotFound, msg, "file %q not found", fileId)
+        return
+    }
+    
+    if sha, ok := data["sha256"].(string); ok {
+        data["verified"] = strings.EqualFold(sha, file.Sha256)
+    }
+    
+    msg.To = []string{file.Owner}
+    selectiveSend(roomId, sender, msg)
+}
@@ -2,4 +2,13 @@ module github.com/yourusername/my-go-project
 
 go 1.20
 
-require github.com/gorilla/websocket v1.5.3 
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.10.9
+)
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+)
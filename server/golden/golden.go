@@ -0,0 +1,202 @@
+// Package golden replays recorded conversations through a running media
+// server and checks what came out of the pipeline against what the recording
+// expects: the intents agents tagged their messages with, the tools they
+// asked authorization for, and the fields of the call summary.
+//
+// A conversation is a JSON file:
+//
+//   {"name": "book-cleaning",
+//    "query": {"template": "dental"},
+//    "turns": [
+//      {"speaker": "user", "text": "Hi, I'd like to book a cleaning."},
+//      {"speaker": "agent", "text": "Sure, which day suits you?", "metadata": {"intent": "book_appointment"}},
+//      {"speaker": "user", "text": "Thursday morning", "audio": true},
+//      {"speaker": "agent", "type": "tool_authorize", "data": {"tool": "create_booking", "userId": "caller"}},
+//      {"speaker": "agent", "type": "summary", "data": {"outcome": "booked", "day": "thursday"}}],
+//    "expect": {
+//      "intents": ["book_appointment"],
+//      "toolCalls": [{"tool": "create_booking", "allowed": true}],
+//      "summary": {"outcome": "booked"},
+//      "transcripts": ["Thursday morning."]}}
+//
+// Replayed, both sides are played from the recording. Live, only the user's
+// turns are, and whatever agent the server gives the room answers them.
+package golden
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// UserId is who the replayed caller joins as, for turns addressing them.
+const UserId = "caller"
+
+type Conversation struct {
+    Name   string            `json:"name"`
+    Query  map[string]string `json:"query,omitempty"` // Extra join parameters for every side, e.g. template
+    Turns  []Turn            `json:"turns"`
+    Expect Expect            `json:"expect"`
+    Path   string            `json:"-"`
+}
+
+type Turn struct {
+    Speaker  string                 `json:"speaker"`        // user or agent
+    Type     string                 `json:"type,omitempty"` // Defaults to broadcast
+    Text     string                 `json:"text,omitempty"`
+    Data     map[string]interface{} `json:"data,omitempty"` // Sent instead of {"text": Text}
+    Metadata map[string]interface{} `json:"metadata,omitempty"`
+    
+    // User turns only: play the turn as audio rather than sending its text,
+    // a WAV fixture relative to the conversation file or, without one, a
+    // speech-like phrase with Text as what recognition should hear.
+    Audio bool   `json:"audio,omitempty"`
+    WAV   string `json:"wav,omitempty"`
+}
+
+type ToolCall struct {
+    Tool    string `json:"tool"`
+    Allowed *bool  `json:"allowed,omitempty"` // Unchecked when unset
+}
+
+type Expect struct {
+    Intents     []string               `json:"intents,omitempty"`     // In order, a repeated intent counted once
+    ToolCalls   []ToolCall             `json:"toolCalls,omitempty"`   // In order
+    Summary     map[string]interface{} `json:"summary,omitempty"`     // Fields the summary's data must have, with these values
+    Transcripts []string               `json:"transcripts,omitempty"` // Final transcripts of the audio turns, replayed only
+}
+
+// Observed is what the pipeline delivered during a run.
+type Observed struct {
+    Intents     []string               `json:"intents"`
+    ToolCalls   []ToolCall             `json:"toolCalls"`
+    Summary     map[string]interface{} `json:"summary,omitempty"`
+    Transcripts []string               `json:"transcripts,omitempty"`
+}
+
+// Load reads a conversation file, or every *.json file of a directory in
+// name order.
+func Load(path string) ([]Conversation, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+    files := []string{path}
+    if info.IsDir() {
+        if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+            return nil, err
+        }
+        sort.Strings(files)
+    }
+    
+    conversations := make([]Conversation, 0, len(files))
+    for _, file := range files {
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+        var c Conversation
+        if err := json.Unmarshal(data, &c); err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        c.Path = file
+        if c.Name == "" {
+            c.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+        }
+        if err := c.validate(); err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        conversations = append(conversations, c)
+    }
+    return conversations, nil
+}
+
+func (c *Conversation) validate() error {
+    if len(c.Turns) == 0 {
+        return fmt.Errorf("no turns")
+    }
+    for i, turn := range c.Turns {
+        switch {
+        case turn.Speaker != "user" && turn.Speaker != "agent":
+            return fmt.Errorf("turn %d: speaker must be user or agent", i+1)
+        case turn.Audio && turn.Speaker != "user":
+            return fmt.Errorf("turn %d: only user turns can be audio", i+1)
+        case turn.Text == "" && turn.Data == nil && turn.WAV == "":
+            return fmt.Errorf("turn %d: no text or data", i+1)
+        }
+    }
+    return nil
+}
+
+// Check lists how the observations differ from the expectations. Transcripts
+// are only checked when the run observed them.
+func (c *Conversation) Check(o Observed) []string {
+    var failures []string
+    intents := make([]string, 0, len(o.Intents))
+    for _, intent := range o.Intents {
+        if len(intents) == 0 || intents[len(intents)-1] != intent {
+            intents = append(intents, intent)
+        }
+    }
+    if c.Expect.Intents != nil && strings.Join(intents, ",") != strings.Join(c.Expect.Intents, ",") {
+        failures = append(failures, fmt.Sprintf("intents: got %q, want %q", intents, c.Expect.Intents))
+    }
+    
+    if c.Expect.ToolCalls != nil {
+        if len(o.ToolCalls) != len(c.Expect.ToolCalls) {
+            failures = append(failures, fmt.Sprintf("tool calls: got %s, want %s", toolNames(o.ToolCalls), toolNames(c.Expect.ToolCalls)))
+        } else {
+            for i, want := range c.Expect.ToolCalls {
+                got := o.ToolCalls[i]
+                if got.Tool != want.Tool {
+                    failures = append(failures, fmt.Sprintf("tool call %d: got %s, want %s", i+1, got.Tool, want.Tool))
+                } else if want.Allowed != nil && (got.Allowed == nil || *got.Allowed != *want.Allowed) {
+                    failures = append(failures, fmt.Sprintf("tool call %d: %s allowed %s, want %v", i+1, want.Tool, allowedText(got.Allowed), *want.Allowed))
+                }
+            }
+        }
+    }
+    
+    if c.Expect.Summary != nil && o.Summary == nil {
+        failures = append(failures, "summary: none sent")
+    }
+    if o.Summary != nil {
+        fields := make([]string, 0, len(c.Expect.Summary))
+        for field := range c.Expect.Summary {
+            fields = append(fields, field)
+        }
+        sort.Strings(fields)
+        for _, field := range fields {
+            want, _ := json.Marshal(c.Expect.Summary[field])
+            got, _ := json.Marshal(o.Summary[field])
+            if _, ok := o.Summary[field]; !ok {
+                failures = append(failures, fmt.Sprintf("summary.%s: missing, want %s", field, want))
+            } else if string(got) != string(want) {
+                failures = append(failures, fmt.Sprintf("summary.%s: got %s, want %s", field, got, want))
+            }
+        }
+    }
+    
+    if c.Expect.Transcripts != nil && o.Transcripts != nil && strings.Join(o.Transcripts, "|") != strings.Join(c.Expect.Transcripts, "|") {
+        failures = append(failures, fmt.Sprintf("transcripts: got %q, want %q", o.Transcripts, c.Expect.Transcripts))
+    }
+    return failures
+}
+
+func toolNames(calls []ToolCall) string {
+    names := make([]string, len(calls))
+    for i, call := range calls {
+        names[i] = call.Tool
+    }
+    return "[" + strings.Join(names, " ") + "]"
+}
+
+func allowedText(allowed *bool) string {
+    if allowed == nil {
+        return "unknown"
+    }
+    return fmt.Sprint(*allowed)
+}
@@ -0,0 +1,453 @@
+package golden
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    "github.com/yourusername/my-go-project/audiogen"
+)
+
+// AgentId is who the replayed agent joins as.
+const AgentId = "golden-agent"
+
+// Runner plays conversations against one server. Each conversation gets a
+// room of its own.
+type Runner struct {
+    Server     string // WebSocket URL of /ws, e.g. ws://localhost:8080/ws
+    AdminToken string // Live runs read tool calls from the audit log, and the summary once the call ends
+    Live       bool   // Leave the agent's side to the agent the server gives the room
+    
+    // Audio plays audio turns for server side transcription; otherwise
+    // their text is sent instead. Say is told what each phrase played
+    // should be recognized as, e.g. stt.Script's Say.
+    Audio bool
+    Say   func(text string)
+    
+    TurnTimeout time.Duration // For each turn to come through, or a live agent to reply; default 10s
+    Settle      time.Duration // Quiet ending a live agent's reply; default 1.5s
+}
+
+type Result struct {
+    Conversation string   `json:"conversation"`
+    RoomId       string   `json:"roomId"`
+    Observed     Observed `json:"observed"`
+    Failures     []string `json:"failures,omitempty"`
+}
+
+func (r *Result) Passed() bool {
+    return len(r.Failures) == 0
+}
+
+// Message is the part of a server message runs look at.
+type message struct {
+    Type     string                 `json:"type"`
+    From     string                 `json:"from"`
+    Data     map[string]interface{} `json:"data"`
+    Metadata map[string]interface{} `json:"metadata"`
+}
+
+type conn struct {
+    ws       *websocket.Conn
+    messages chan message // Closed when the connection is
+    writeMu  sync.Mutex
+}
+
+func (c *conn) send(msg map[string]interface{}) error {
+    c.writeMu.Lock()
+    defer c.writeMu.Unlock()
+    return c.ws.WriteJSON(msg)
+}
+
+func (c *conn) sendAudio(frame []byte) error {
+    c.writeMu.Lock()
+    defer c.writeMu.Unlock()
+    return c.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (c *conn) read() {
+    defer close(c.messages)
+    for {
+        messageType, data, err := c.ws.ReadMessage()
+        if err != nil {
+            return
+        }
+        // Agent audio and TTS aren't checked
+        if messageType != websocket.TextMessage {
+            continue
+        }
+        var msg message
+        if json.Unmarshal(data, &msg) == nil {
+            c.messages <- msg
+        }
+    }
+}
+
+type run struct {
+    *Runner
+    c       Conversation
+    roomId  string
+    user    *conn
+    agent   *conn // Replayed only
+    agents  map[string]bool
+    result  Result
+    summary bool
+}
+
+// Run plays one conversation, returning an error only when the server
+// couldn't be reached; everything the pipeline got wrong is a failure.
+func (r *Runner) Run(ctx context.Context, c Conversation) (*Result, error) {
+    if r.TurnTimeout <= 0 {
+        r.TurnTimeout = 10 * time.Second
+    }
+    if r.Settle <= 0 {
+        r.Settle = 1500 * time.Millisecond
+    }
+    run := &run{
+        Runner: r,
+        c:      c,
+        roomId: "golden-" + c.Name + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+        agents: make(map[string]bool),
+    }
+    run.result = Result{Conversation: c.Name, RoomId: run.roomId, Observed: Observed{Intents: []string{}, ToolCalls: []ToolCall{}}}
+    
+    if !r.Live {
+        agent, err := run.dial(AgentId, "agent")
+        if err != nil {
+            return nil, err
+        }
+        defer agent.ws.Close()
+        run.agent = agent
+        run.agents[AgentId] = true
+        if r.Audio {
+            run.result.Observed.Transcripts = []string{}
+        }
+    }
+    user, err := run.dial(UserId, "user")
+    if err != nil {
+        return nil, err
+    }
+    run.user = user
+    if r.Live && !run.awaitAgent() {
+        user.ws.Close()
+        run.fail("no agent joined the room within %v", r.TurnTimeout)
+        return &run.result, nil
+    }
+    
+    for i, turn := range c.Turns {
+        if err := run.play(ctx, i+1, turn); err != nil {
+            run.fail("turn %d: %v", i+1, err)
+            break
+        }
+    }
+    user.ws.Close()
+    if r.Live && r.AdminToken != "" {
+        run.readBack(ctx)
+    }
+    run.result.Failures = append(run.result.Failures, c.Check(run.result.Observed)...)
+    return &run.result, nil
+}
+
+func (r *run) fail(format string, args ...interface{}) {
+    r.result.Failures = append(r.result.Failures, fmt.Sprintf(format, args...))
+}
+
+func (r *run) dial(clientId string, clientType string) (*conn, error) {
+    query := url.Values{"room": {r.roomId}, "clientId": {clientId}, "type": {clientType}}
+    for key, value := range r.c.Query {
+        query.Set(key, value)
+    }
+    if clientType == "user" && r.Audio {
+        query.Set("audioFormat", "pcm16")
+        query.Set("sampleRate", strconv.Itoa(audiogen.Default.SampleRate))
+        query.Set("channels", strconv.Itoa(audiogen.Default.Channels))
+    }
+    header := http.Header{}
+    if r.AdminToken != "" {
+        header.Set("Authorization", "Bearer "+r.AdminToken)
+    }
+    ws, _, err := websocket.DefaultDialer.Dial(r.Server+"?"+query.Encode(), header)
+    if err != nil {
+        return nil, fmt.Errorf("joining %s as %s: %v", r.roomId, clientId, err)
+    }
+    c := &conn{ws: ws, messages: make(chan message, 256)}
+    go c.read()
+    if _, err := r.await(c, r.TurnTimeout, func(msg message) bool { return msg.Type == "welcome" }); err != nil {
+        ws.Close()
+        return nil, fmt.Errorf("joining %s as %s: %v", r.roomId, clientId, err)
+    }
+    return c, nil
+}
+
+var errTimeout = errors.New("timed out")
+
+// await reads c until a message matches, observing every message read.
+func (r *run) await(c *conn, timeout time.Duration, match func(message) bool) (message, error) {
+    deadline := time.NewTimer(timeout)
+    defer deadline.Stop()
+    for {
+        select {
+        case msg, ok := <-c.messages:
+            if !ok {
+                return message{}, errors.New("connection closed")
+            }
+            r.observe(c, msg)
+            if match(msg) {
+                return msg, nil
+            }
+        case <-deadline.C:
+            return message{}, errTimeout
+        }
+    }
+}
+
+// observe notes the intents and summary agents send the caller, and, on
+// the replayed agent's side, final transcripts and tool authorizations.
+func (r *run) observe(c *conn, msg message) {
+    o := &r.result.Observed
+    switch {
+    case msg.Type == "welcome" || msg.Type == "client_joined":
+        participants, _ := msg.Data["participants"].([]interface{})
+        if msg.Type == "client_joined" {
+            participants = []interface{}{msg.Data}
+        }
+        for _, p := range participants {
+            participant, _ := p.(map[string]interface{})
+            if clientId, _ := participant["clientId"].(string); participant["clientType"] == "agent" && clientId != "" {
+                r.agents[clientId] = true
+            }
+        }
+    case c == r.user && r.agents[msg.From]:
+        if intent, _ := msg.Metadata["intent"].(string); intent != "" {
+            o.Intents = append(o.Intents, intent)
+        }
+        if msg.Type == "summary" {
+            o.Summary = msg.Data
+            r.summary = true
+        }
+    case c == r.agent && msg.Type == "transcript" && msg.Data["clientId"] == UserId && msg.Data["final"] == true:
+        text, _ := msg.Data["text"].(string)
+        o.Transcripts = append(o.Transcripts, text)
+    case c == r.agent && msg.Type == "tool_authorization":
+        tool, _ := msg.Data["tool"].(string)
+        allowed, ok := msg.Data["allowed"].(bool)
+        call := ToolCall{Tool: tool}
+        if ok {
+            call.Allowed = &allowed
+        }
+        o.ToolCalls = append(o.ToolCalls, call)
+    }
+}
+
+func (r *run) awaitAgent() bool {
+    if len(r.agents) > 0 {
+        return true
+    }
+    _, err := r.await(r.user, r.TurnTimeout, func(message) bool { return len(r.agents) > 0 })
+    return err == nil
+}
+
+func (r *run) play(ctx context.Context, n int, turn Turn) error {
+    if turn.Speaker == "agent" {
+        if r.Live {
+            return nil
+        }
+        return r.playAgent(n, turn)
+    }
+    
+    if r.Audio && (turn.Audio || turn.WAV != "") {
+        if err := r.playAudio(ctx, turn); err != nil {
+            return err
+        }
+    } else if err := r.user.send(outgoing(turn)); err != nil {
+        return err
+    }
+    
+    if r.Live {
+        return r.awaitReply()
+    }
+    // The turn has come through once the agent has it
+    _, err := r.await(r.agent, r.TurnTimeout, func(msg message) bool {
+        if r.Audio && (turn.Audio || turn.WAV != "") {
+            return msg.Type == "transcript" && msg.Data["clientId"] == UserId && msg.Data["final"] == true
+        }
+        return msg.From == UserId && msg.Type == outgoingType(turn)
+    })
+    if err == errTimeout {
+        return fmt.Errorf("the agent never got the caller's %s", outgoingType(turn))
+    }
+    return err
+}
+
+func (r *run) playAgent(n int, turn Turn) error {
+    msg := outgoing(turn)
+    if turn.Type == "tool_authorize" {
+        data := msg["data"].(map[string]interface{})
+        if _, ok := data["requestId"]; !ok {
+            data["requestId"] = "turn-" + strconv.Itoa(n)
+        }
+        if err := r.agent.send(msg); err != nil {
+            return err
+        }
+        _, err := r.await(r.agent, r.TurnTimeout, func(msg message) bool {
+            return msg.Type == "tool_authorization" || msg.Type == "error"
+        })
+        if err == errTimeout {
+            return fmt.Errorf("no tool_authorization for %v", data["tool"])
+        }
+        return err
+    }
+    
+    if err := r.agent.send(msg); err != nil {
+        return err
+    }
+    // Nothing of the agent's own reaches the caller
+    if turn.Type == "agent_only" {
+        return nil
+    }
+    _, err := r.await(r.user, r.TurnTimeout, func(msg message) bool {
+        return msg.From == AgentId && msg.Type == outgoingType(turn)
+    })
+    if err == errTimeout {
+        return fmt.Errorf("the caller never got the agent's %s", outgoingType(turn))
+    }
+    return err
+}
+
+// awaitReply waits for a live agent to answer, then for it to go quiet.
+func (r *run) awaitReply() error {
+    fromAgent := func(msg message) bool { return r.agents[msg.From] }
+    if _, err := r.await(r.user, r.TurnTimeout, fromAgent); err != nil {
+        if err == errTimeout {
+            return fmt.Errorf("no reply within %v", r.TurnTimeout)
+        }
+        return err
+    }
+    for {
+        if _, err := r.await(r.user, r.Settle, fromAgent); err == errTimeout {
+            return nil
+        } else if err != nil {
+            return err
+        }
+    }
+}
+
+func (r *run) playAudio(ctx context.Context, turn Turn) error {
+    format := audiogen.Default
+    var clip []byte
+    if turn.WAV != "" {
+        path := turn.WAV
+        if !filepath.IsAbs(path) {
+            path = filepath.Join(filepath.Dir(r.c.Path), path)
+        }
+        pcm, err := audiogen.LoadWAV(path, format)
+        if err != nil {
+            return err
+        }
+        clip = pcm
+    } else {
+        clip = audiogen.Phrase(format, turn.Text)
+    }
+    if r.Say != nil {
+        r.Say(turn.Text)
+    }
+    // Clean edges either side, the trailing silence ending the utterance
+    clip = audiogen.Concat(audiogen.Silence(format, 300*time.Millisecond), clip, audiogen.Silence(format, time.Second))
+    return audiogen.Play(ctx, audiogen.Frames(format, clip, 20), 20, r.user.sendAudio)
+}
+
+func outgoingType(turn Turn) string {
+    if turn.Type == "" {
+        return "broadcast"
+    }
+    return turn.Type
+}
+
+func outgoing(turn Turn) map[string]interface{} {
+    data := make(map[string]interface{}, len(turn.Data)+1)
+    for key, value := range turn.Data {
+        data[key] = value
+    }
+    if turn.Data == nil {
+        data["text"] = turn.Text
+    }
+    msg := map[string]interface{}{"type": outgoingType(turn), "data": data}
+    if turn.Metadata != nil {
+        msg["metadata"] = turn.Metadata
+    }
+    return msg
+}
+
+// readBack fills in what a live run couldn't see from the caller's side:
+// tool authorizations from the audit log, which only has sensitive tools,
+// and the summary an agent sent after the caller left.
+func (r *run) readBack(ctx context.Context) {
+    base, err := url.Parse(r.Server)
+    if err != nil {
+        return
+    }
+    base.Scheme = strings.Replace(base.Scheme, "ws", "http", 1)
+    base.Path, base.RawQuery = "", ""
+    
+    var audit struct {
+        Entries []struct {
+            At     int64                  `json:"at"`
+            Action string                 `json:"action"`
+            Detail map[string]interface{} `json:"detail"`
+        } `json:"entries"`
+    }
+    if err := r.get(ctx, base.String()+"/admin/audit?roomId="+url.QueryEscape(r.roomId)+"&limit=1000", &audit); err != nil {
+        r.fail("reading the audit log: %v", err)
+    }
+    sort.SliceStable(audit.Entries, func(i, j int) bool { return audit.Entries[i].At < audit.Entries[j].At })
+    for _, entry := range audit.Entries {
+        if entry.Action != "tool_authorized" && entry.Action != "tool_denied" {
+            continue
+        }
+        tool, _ := entry.Detail["tool"].(string)
+        allowed := entry.Action == "tool_authorized"
+        r.result.Observed.ToolCalls = append(r.result.Observed.ToolCalls, ToolCall{Tool: tool, Allowed: &allowed})
+    }
+    
+    if r.summary || r.c.Expect.Summary == nil {
+        return
+    }
+    time.Sleep(r.Settle)
+    var summary struct {
+        Summary *struct {
+            Data map[string]interface{} `json:"data"`
+        } `json:"summary"`
+    }
+    if err := r.get(ctx, base.String()+"/room/"+url.PathEscape(r.roomId)+"/summary", &summary); err != nil {
+        r.fail("reading the summary: %v", err)
+    } else if summary.Summary != nil {
+        r.result.Observed.Summary = summary.Summary.Data
+    }
+}
+
+func (r *run) get(ctx context.Context, target string, out interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+r.AdminToken)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return errors.New(resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    
+    "github.com/yourusername/my-go-project/golden"
+    "github.com/yourusername/my-go-project/stt"
+)
+
+// TestGoldenConversations replays testdata/golden through a server on a
+// loopback port, with scripted speech-to-text standing in for a vendor. The
+// same corpus runs against a deployed server with cmd/evalrun.
+func TestGoldenConversations(t *testing.T) {
+    conversations, err := golden.Load("testdata/golden")
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    saved, savedProviders := cfg, sttProviders
+    script := &stt.Script{Endpointing: stt.Endpointing{Threshold: 0.01, Silence: 300 * time.Millisecond, MaxLength: 30 * time.Second}}
+    cfg.ConnRatePerIP = 0
+    cfg.MaxConnsPerIP = 0
+    cfg.AdminToken = "golden-admin-token" // Agents join with it
+    cfg.STTProvider = "script"
+    cfg.SensitiveTools = []string{"issue_refund"}
+    sttProviders = map[string]stt.Provider{"script": script}
+    t.Cleanup(func() { cfg, sttProviders = saved, savedProviders })
+    
+    // The handlers read cfg until their connections are cleaned up, so every
+    // one must have returned before the cleanup above puts cfg back
+    var handlers sync.WaitGroup
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handlers.Add(1)
+        defer handlers.Done()
+        handleWebSocket(w, r)
+    }))
+    t.Cleanup(func() {
+        server.Close()
+        handlers.Wait()
+    })
+    runner := &golden.Runner{
+        Server:      "ws" + strings.TrimPrefix(server.URL, "http"),
+        AdminToken:  cfg.AdminToken,
+        Audio:       true,
+        Say:         script.Say,
+        TurnTimeout: 5 * time.Second,
+    }
+    
+    for _, c := range conversations {
+        c := c
+        t.Run(c.Name, func(t *testing.T) {
+            result, err := runner.Run(context.Background(), c)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, failure := range result.Failures {
+                t.Error(failure)
+            }
+        })
+    }
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type HistoryEntry struct {
+    Message
+    Audience string `json:"audience"` // all, agents, users or direct
+}
+
+type RetentionPolicy struct {
+    MaxMessages   int `json:"maxMessages"`
+    MaxAgeSeconds int `json:"maxAgeSeconds"`
+}
+
+var (
+    retentionPolicies   = make(map[string]RetentionPolicy)
+    retentionPoliciesMu sync.RWMutex
+    
+    messageSeq uint64
+)
+
+// Control messages act on server state and are never replayed as chat
+var unrecordedTypes = map[string]bool{
+    "metadata":         true,
+    "profile_update":   true,
+    "file_receipt":     true,
+    "channel_open":     true,
+    "channel_close":    true,
+    "channel_audio":    true,
+    "kick":             true,
+    "recording_start":  true,
+    "recording_stop":   true,
+    "recording_pause":  true,
+    "recording_resume": true,
+    "speak_stream":     true, // Recorded whole as a speak once spoken
+    "answer_lookup":    true,
+}
+
+// newMessageId returns IDs that sort in arrival order, they double as history cursors.
+func newMessageId() string {
+    seq := atomic.AddUint64(&messageSeq, 1)
+    return fmt.Sprintf("%013d-%06d", time.Now().UnixNano()/int64(time.Millisecond), seq%1000000)
+}
+
+func retentionForTenant(tenant string) RetentionPolicy {
+    retentionPoliciesMu.RLock()
+    defer retentionPoliciesMu.RUnlock()
+    
+    if policy, ok := retentionPolicies[tenant]; ok {
+        return policy
+    }
+    if policy, ok := retentionPolicies[""]; ok {
+        return policy
+    }
+    return RetentionPolicy{
+        MaxMessages:   cfg.HistoryMaxMessages,
+        MaxAgeSeconds: int(cfg.HistoryRetention / time.Second),
+    }
+}
+
+func historyAudience(msg *Message) string {
+    switch {
+    case msg.Type == "agent_only":
+        return "agents"
+    case msg.Type == "user_only":
+        return "users"
+    case len(msg.To) > 0:
+        return "direct"
+    }
+    return "all"
+}
+
+func recordHistory(roomId string, msg *Message) {
+    if unrecordedTypes[msg.Type] || isEphemeralType(msg.Type) {
+        return
+    }
+    
+    roomsMu.RLock()
+    tenant := ""
+    if room := rooms[roomId]; room != nil {
+        tenant = room.Tenant
+    }
+    roomsMu.RUnlock()
+    
+    policy := retentionForTenant(tenant)
+    if policy.MaxMessages <= 0 {
+        return
+    }
+    
+    // Through the ordered writer, so a slow store doesn't hold up the live chat
+    entry := transcriptEntry(roomId, tenant, msg)
+    persist(roomId, func(ctx context.Context) error {
+        if err := store.Transcripts().Append(ctx, entry, policy.MaxMessages); err != nil {
+            return fmt.Errorf("recording history for room %s: %v", roomId, err)
+        }
+        indexTranscript(entry)
+        return nil
+    })
+}
+
+func pruneHistories() {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    err := store.Transcripts().Prune(ctx, func(tenant string) (int, int64) {
+        policy := retentionForTenant(tenant)
+        if policy.MaxAgeSeconds <= 0 {
+            return policy.MaxMessages, 0
+        }
+        return policy.MaxMessages, now - int64(policy.MaxAgeSeconds)*1000
+    })
+    if err != nil {
+        log.Printf("Pruning history failed: %v", err)
+    }
+}
+
+func startHistoryJanitor() {
+    go func() {
+        for range time.Tick(time.Minute) {
+            pruneHistories()
+        }
+    }()
+}
+
+// visibleTo reports whether a participant would have received the entry live.
+func (e *HistoryEntry) visibleTo(clientId string, clientType ClientType) bool {
+    if e.From == clientId {
+        return true
+    }
+    
+    switch e.Audience {
+    case "agents":
+        return clientType == ClientTypeAgent
+    case "users":
+        return clientType == ClientTypeUser
+    case "direct":
+        for _, id := range e.To {
+            if id == clientId {
+                return true
+            }
+        }
+        return false
+    }
+    return true
+}
+
+// GET /room/ROOM_ID/messages?before=MESSAGE_ID&limit=N[&clientId=ID]
+// The admin token and observer tokens read what their scope allows, a
+// participant, clientId with its resume token in X-Resume-Token, only what
+// it would have received live.
+func handleRoomMessages(w http.ResponseWriter, r *http.Request, roomId string) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    scope, status, text := observerAccess(r, "/room/{id}", false)
+    var reader *Client
+    if scope == nil {
+        if reader = roomParticipant(r, roomId); reader == nil {
+            http.Error(w, text, status)
+            return
+        }
+        scope = &observerScope{}
+    }
+    
+    query := r.URL.Query()
+    before := query.Get("before")
+    
+    limit := 50
+    if value := query.Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n <= 0 {
+            http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+            return
+        }
+        if n > 200 {
+            n = 200
+        }
+        limit = n
+    }
+    
+    // Visibility is filtered here, so keep reading batches until the page
+    // fills or the transcript runs out
+    page := make([]HistoryEntry, 0, limit)
+    hasMore := false
+    cursor := before
+    for !hasMore {
+        batch, err := store.Transcripts().List(r.Context(), roomId, cursor, 200)
+        if err != nil {
+            log.Printf("Reading history for room %s failed: %v", roomId, err)
+            http.Error(w, "History unavailable", http.StatusInternalServerError)
+            return
+        }
+        for _, stored := range batch {
+            cursor = stored.MessageId
+            entry := historyEntryFrom(stored)
+            if !scope.allows(stored.Tenant) || (reader != nil && !entry.visibleTo(reader.clientId, reader.clientType)) {
+                continue
+            }
+            if len(page) == limit {
+                hasMore = true
+                break
+            }
+            page = append(page, entry)
+        }
+        if len(batch) < 200 {
+            break
+        }
+    }
+    
+    // Collected newest first, return in chronological order
+    for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+        page[i], page[j] = page[j], page[i]
+    }
+    
+    response := map[string]interface{}{
+        "roomId":   roomId,
+        "messages": page,
+        "hasMore":  hasMore,
+    }
+    if hasMore && len(page) > 0 {
+        response["nextBefore"] = page[0].Id
+    }
+    
+    json.NewEncoder(w).Encode(response)
+}
+
+// Admin API: /admin/retention/TENANT (use "_default" to change the server default)
+
+func handleRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    tenant := strings.TrimPrefix(r.URL.Path, "/admin/retention/")
+    if tenant == "" {
+        http.Error(w, "Tenant required", http.StatusBadRequest)
+        return
+    }
+    
+    if tenant == "_default" {
+        tenant = ""
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+        json.NewEncoder(w).Encode(retentionForTenant(tenant))
+        
+    case http.MethodPut:
+        var policy RetentionPolicy
+        if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if policy.MaxMessages < 0 || policy.MaxAgeSeconds < 0 {
+            http.Error(w, "Limits must not be negative", http.StatusBadRequest)
+            return
+        }
+        
+        retentionPoliciesMu.Lock()
+        retentionPolicies[tenant] = policy
+        retentionPoliciesMu.Unlock()
+        
+        pruneHistories()
+        json.NewEncoder(w).Encode(policy)
+        
+    case http.MethodDelete:
+        retentionPoliciesMu.Lock()
+        delete(retentionPolicies, tenant)
+        retentionPoliciesMu.Unlock()
+        
+        w.WriteHeader(http.StatusNoContent)
+        
+    default:
+        http.Error(w, "Only GET, PUT and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+)
+
+// Hold audio. Callers who would otherwise sit in silence hear their
+// tenant's hold assets, music looped with phrases spoken every
+// everySeconds (-hold-interval by default), or the phrases alone:
+//
+//   queue     waiting -hold-queue-after for an agent to join (0 disables)
+//   hold      while their agent consults another room, see consult.go
+//   thinking  -hold-thinking-after from the end of their speech with no
+//             answer from the agent yet (0 disables), until it answers or
+//             they speak again
+//
+// Assets are per tenant and situation, falling back to the _default
+// tenant's; hold alone has a built-in phrase, -consult-hold-prompt, so a
+// tenant without assets hears nothing in a queue or while the agent thinks
+// unless the message catalog has hold.KIND for the room's locale (see
+// locale.go). Phrases, plain text or SSML documents, go through the room's
+// TTS, in the room's locale when the asset has phrases localized for it, and
+// music is uploaded as WAV:
+//
+//   PUT /admin/hold/TENANT/KIND        {"phrases": [...], "localized": {"es": [...]}, "everySeconds": 20}
+//   PUT /admin/hold/TENANT/KIND/music  WAV, played at -tts-sample-rate
+//
+// With -hold-assets they are kept as DIR/TENANT/KIND.json and KIND.wav and
+// loaded on start, otherwise they last until the server restarts.
+
+const (
+    holdQueue    = "queue"
+    holdConsult  = "hold"
+    holdThinking = "thinking"
+)
+
+var holdKinds = []string{holdQueue, holdConsult, holdThinking}
+
+// HoldAsset is what a tenant's callers hear in one situation.
+type HoldAsset struct {
+    Phrases      []string            `json:"phrases"`
+    Localized    map[string][]string `json:"localized,omitempty"`    // Phrases by lower case locale
+    EverySeconds int                 `json:"everySeconds,omitempty"` // Between phrases, -hold-interval when 0
+    MusicSeconds float64             `json:"musicSeconds,omitempty"` // Length of the uploaded music, 0 for none
+    Inherited    bool                `json:"inherited,omitempty"`    // From _default or built in, GET only
+    
+    music   []byte // pcm16 mono at -tts-sample-rate
+    builtIn bool   // No tenant has assets for the situation
+}
+
+// holdPlayback is the hold audio playing to a room. Guarded by roomsMu.
+type holdPlayback struct {
+    kind string
+    turn int64 // thinking only, the caller's voiceAt it comforts
+    stop context.CancelFunc
+}
+
+var (
+    holdAssets   = make(map[string]map[string]*HoldAsset) // Tenant, "" for _default, to kind
+    holdAssetsMu sync.RWMutex                              // Never held while taking roomsMu
+    
+    holdPlaysTotal = newCounterVec("iva_hold_plays_total", "Hold audio started for callers by situation.", "kind")
+)
+
+func init() {
+    metricSeries = append(metricSeries, holdPlaysTotal)
+}
+
+func holdFormat() audiogen.Format {
+    return audiogen.Format{SampleRate: cfg.TTSSampleRate, Channels: 1}
+}
+
+// loadHoldAssets reads -hold-assets.
+func loadHoldAssets() error {
+    if err := checkPromptSSML(cfg.ConsultHoldPrompt); err != nil {
+        return fmt.Errorf("-consult-hold-prompt: %v", err)
+    }
+    if cfg.HoldAssetsDir == "" {
+        return nil
+    }
+    entries, err := os.ReadDir(cfg.HoldAssetsDir)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            continue
+        }
+        tenant := entry.Name()
+        for _, kind := range holdKinds {
+            dir := filepath.Join(cfg.HoldAssetsDir, tenant)
+            asset := &HoldAsset{}
+            found := false
+            data, err := os.ReadFile(filepath.Join(dir, kind+".json"))
+            if err == nil {
+                if err := json.Unmarshal(data, asset); err != nil {
+                    return fmt.Errorf("%s/%s.json: %v", tenant, kind, err)
+                }
+                found = true
+            } else if !os.IsNotExist(err) {
+                return err
+            }
+            asset.music, err = audiogen.LoadWAV(filepath.Join(dir, kind+".wav"), holdFormat())
+            if err == nil {
+                asset.MusicSeconds = holdFormat().Duration(len(asset.music)).Seconds()
+                found = true
+            } else if !os.IsNotExist(err) {
+                return fmt.Errorf("%s/%s.wav: %v", tenant, kind, err)
+            }
+            if found {
+                setHoldAsset(tenant, kind, asset)
+            }
+        }
+    }
+    return nil
+}
+
+func setHoldAsset(tenant string, kind string, asset *HoldAsset) {
+    if tenant == defaultPhraseTenant {
+        tenant = ""
+    }
+    holdAssetsMu.Lock()
+    defer holdAssetsMu.Unlock()
+    if asset == nil {
+        delete(holdAssets[tenant], kind)
+        return
+    }
+    if holdAssets[tenant] == nil {
+        holdAssets[tenant] = make(map[string]*HoldAsset)
+    }
+    holdAssets[tenant][kind] = asset
+}
+
+// holdAssetFor resolves what a tenant plays in a situation.
+func holdAssetFor(tenant string, kind string) HoldAsset {
+    holdAssetsMu.RLock()
+    defer holdAssetsMu.RUnlock()
+    if asset := holdAssets[tenant][kind]; asset != nil {
+        return *asset
+    }
+    if asset := holdAssets[""][kind]; asset != nil {
+        inherited := *asset
+        inherited.Inherited = tenant != ""
+        return inherited
+    }
+    asset := HoldAsset{Phrases: []string{}, Inherited: true, builtIn: true}
+    if kind == holdConsult && cfg.ConsultHoldPrompt != "" {
+        asset.Phrases = []string{cfg.ConsultHoldPrompt}
+    }
+    return asset
+}
+
+// startHold plays a situation's hold audio to a room's users until
+// stopHold. phrases, when not nil, replace the tenant's. A consult's hold
+// takes over from a queue or thinking, which never interrupt anything.
+func startHold(roomId string, kind string, phrases []string) {
+    roomsMu.RLock()
+    room := rooms[roomId]
+    tenant := ""
+    if room != nil {
+        tenant = room.Tenant
+    }
+    roomsMu.RUnlock()
+    if room == nil {
+        return
+    }
+    asset := holdAssetFor(tenant, kind)
+    asset.Phrases = holdPhrasesFor(room, kind, asset)
+    if phrases != nil {
+        asset.Phrases = phrases
+    }
+    if len(asset.Phrases) == 0 && len(asset.music) == 0 {
+        return
+    }
+    
+    ctx, cancel := context.WithCancel(context.Background())
+    roomsMu.Lock()
+    if room.hold != nil && (room.hold.kind == kind || kind != holdConsult) {
+        roomsMu.Unlock()
+        cancel()
+        return
+    }
+    if room.hold != nil {
+        room.hold.stop()
+    }
+    room.hold = &holdPlayback{kind: kind, turn: room.turn.voiceAt, stop: cancel}
+    labels := room.labels
+    roomsMu.Unlock()
+    
+    holdPlaysTotal.inc(labels, kind)
+    logAt("info", roomId, "", "Playing %s audio", kind)
+    go playHold(ctx, room, asset)
+}
+
+// holdPhrasesFor picks an asset's phrases in the room's locale: those the
+// tenant localized for it, else for built-in assets the message catalog's.
+func holdPhrasesFor(room *RoomInfo, kind string, asset HoldAsset) []string {
+    for _, locale := range localeChain(room.Locale) {
+        if phrases, ok := asset.Localized[locale]; ok {
+            return phrases
+        }
+    }
+    if text, ok := localized(room, "hold."+kind); ok && asset.builtIn {
+        return []string{text}
+    }
+    return asset.Phrases
+}
+
+func stopHold(roomId string, kind string) {
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    if room := rooms[roomId]; room != nil {
+        stopHoldLocked(room, kind)
+    }
+}
+
+// stopHoldLocked is stopHold for callers holding roomsMu.
+func stopHoldLocked(room *RoomInfo, kind string) {
+    if room.hold != nil && room.hold.kind == kind {
+        room.hold.stop()
+        room.hold = nil
+    }
+}
+
+// noteHoldLeave silences a room its last user left. Callers hold roomsMu.
+func noteHoldLeave(room *RoomInfo) {
+    if len(room.Users) == 0 && room.hold != nil {
+        room.hold.stop()
+        room.hold = nil
+    }
+}
+
+// playHold loops the music, breaking for a phrase every interval.
+func playHold(ctx context.Context, room *RoomInfo, asset HoldAsset) {
+    frameMs := cfg.AudioFrameMs
+    if frameMs <= 0 {
+        frameMs = 20
+    }
+    every := time.Duration(asset.EverySeconds) * time.Second
+    if every <= 0 {
+        every = cfg.HoldInterval
+    }
+    music := audiogen.Frames(holdFormat(), asset.music, frameMs)
+    position := 0
+    send := func(frame []byte) error {
+        position = (position + 1) % len(music)
+        forwardAudioToUsers(room.RoomId, SystemSender, frame, false)
+        return nil
+    }
+    
+    var phraseAt time.Time
+    for phrase := 0; ctx.Err() == nil; {
+        if len(asset.Phrases) > 0 && !time.Now().Before(phraseAt) {
+            text := asset.Phrases[phrase%len(asset.Phrases)]
+            playFallbackPrompt(ctx, room, &fallbackPrompt{text: text})
+            phrase++
+            if every <= 0 && len(music) == 0 {
+                return
+            }
+            phraseAt = time.Now().Add(every)
+            if every <= 0 {
+                phraseAt = time.Now().Add(24 * time.Hour)
+            }
+            continue
+        }
+        if len(music) == 0 {
+            select {
+            case <-ctx.Done():
+            case <-time.After(time.Until(phraseAt)):
+            }
+            continue
+        }
+        musicCtx, cancel := context.WithCancel(ctx)
+        if len(asset.Phrases) > 0 {
+            musicCtx, cancel = context.WithDeadline(ctx, phraseAt)
+        }
+        audiogen.Play(musicCtx, music[position:], frameMs, send)
+        cancel()
+    }
+}
+
+// startHoldPlayer starts the queue and thinking audio of rooms as their
+// callers pass the thresholds.
+func startHoldPlayer() {
+    if cfg.HoldQueueAfter <= 0 && cfg.HoldThinkingAfter <= 0 {
+        return
+    }
+    go func() {
+        for range time.Tick(250 * time.Millisecond) {
+            checkHolds()
+        }
+    }()
+}
+
+func checkHolds() {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    var queued, thinking []string
+    roomsMu.Lock()
+    for roomId, room := range rooms {
+        waiting := room.waitingSince != 0 && len(room.Agents) == 0 && len(room.Users) > 0
+        if !waiting {
+            stopHoldLocked(room, holdQueue)
+        }
+        turn := room.turn
+        if room.hold != nil && room.hold.kind == holdThinking && (!turn.waiting || turn.voiceAt != room.hold.turn) {
+            stopHoldLocked(room, holdThinking)
+        }
+        if room.hold != nil {
+            continue
+        }
+        switch {
+        case waiting && cfg.HoldQueueAfter > 0 && now-room.waitingSince >= cfg.HoldQueueAfter.Milliseconds():
+            queued = append(queued, roomId)
+        case cfg.HoldThinkingAfter > 0 && len(room.Agents) > 0 && turn.waiting && turn.voiceAt != room.comforted &&
+            now-turn.voiceAt >= cfg.HoldThinkingAfter.Milliseconds():
+            room.comforted = turn.voiceAt
+            thinking = append(thinking, roomId)
+        }
+    }
+    roomsMu.Unlock()
+    
+    for _, roomId := range queued {
+        startHold(roomId, holdQueue, nil)
+    }
+    for _, roomId := range thinking {
+        startHold(roomId, holdThinking, nil)
+    }
+}
+
+// GET /admin/hold/TENANT (admin): the tenant's hold assets by situation.
+// PUT|DELETE /admin/hold/TENANT/KIND[/music] sets or removes one.
+// _default is the tenant of rooms that have none, and every tenant's
+// fallback.
+func handleHoldAssets(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/hold/"), "/")
+    tenant := parts[0]
+    if tenant == "" || strings.ContainsAny(tenant, `.\`) {
+        http.Error(w, "Tenant required", http.StatusBadRequest)
+        return
+    }
+    lookup := tenant
+    if lookup == defaultPhraseTenant {
+        lookup = ""
+    }
+    
+    if len(parts) == 1 {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        assets := make(map[string]HoldAsset)
+        for _, kind := range holdKinds {
+            assets[kind] = holdAssetFor(lookup, kind)
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(assets)
+        return
+    }
+    
+    kind := parts[1]
+    if !containsString(holdKinds, kind) || len(parts) > 3 || (len(parts) == 3 && parts[2] != "music") {
+        http.Error(w, "Unknown hold asset, use queue, hold or thinking[/music]", http.StatusNotFound)
+        return
+    }
+    music := len(parts) == 3
+    holdAssetsMu.RLock()
+    asset := &HoldAsset{Phrases: []string{}}
+    if existing := holdAssets[lookup][kind]; existing != nil {
+        copied := *existing
+        asset = &copied
+    }
+    holdAssetsMu.RUnlock()
+    
+    switch {
+    case r.Method == http.MethodPut && music:
+        data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxFileBytes))
+        if err != nil {
+            http.Error(w, "Music too large", http.StatusRequestEntityTooLarge)
+            return
+        }
+        asset.music, err = audiogen.DecodeWAV(data, holdFormat())
+        if err != nil {
+            http.Error(w, "Invalid WAV: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        asset.MusicSeconds = holdFormat().Duration(len(asset.music)).Seconds()
+    case r.Method == http.MethodPut:
+        var body HoldAsset
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if body.EverySeconds < 0 {
+            http.Error(w, "everySeconds must not be negative", http.StatusBadRequest)
+            return
+        }
+        asset.Phrases, asset.Localized, asset.EverySeconds = trimPhrases(body.Phrases), nil, body.EverySeconds
+        for locale, phrases := range body.Localized {
+            if !validLocale(locale) {
+                http.Error(w, "localized keys must be locales like en-US", http.StatusBadRequest)
+                return
+            }
+            if phrases = trimPhrases(phrases); len(phrases) > 0 {
+                if asset.Localized == nil {
+                    asset.Localized = make(map[string][]string)
+                }
+                asset.Localized[strings.ToLower(locale)] = phrases
+            }
+        }
+        if err := checkHoldPhrases(asset); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    case r.Method == http.MethodDelete && music:
+        asset.music, asset.MusicSeconds = nil, 0
+    case r.Method == http.MethodDelete:
+        asset = nil
+    default:
+        http.Error(w, "Only PUT and DELETE allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    if asset != nil && len(asset.Phrases) == 0 && len(asset.Localized) == 0 && asset.music == nil && asset.EverySeconds == 0 {
+        asset = nil
+    }
+    if err := saveHoldAsset(tenant, kind, asset); err != nil {
+        log.Printf("level=error Saving hold asset %s/%s: %v", tenant, kind, err)
+        http.Error(w, "Saving failed", http.StatusInternalServerError)
+        return
+    }
+    setHoldAsset(tenant, kind, asset)
+    if asset == nil {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(asset)
+}
+
+// checkHoldPhrases validates the phrases that are SSML documents.
+func checkHoldPhrases(asset *HoldAsset) error {
+    lists := [][]string{asset.Phrases}
+    for _, phrases := range asset.Localized {
+        lists = append(lists, phrases)
+    }
+    for _, phrases := range lists {
+        for _, phrase := range phrases {
+            if err := checkPromptSSML(phrase); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func trimPhrases(phrases []string) []string {
+    trimmed := []string{}
+    for _, phrase := range phrases {
+        if phrase = strings.TrimSpace(phrase); phrase != "" {
+            trimmed = append(trimmed, phrase)
+        }
+    }
+    return trimmed
+}
+
+// saveHoldAsset writes an asset to -hold-assets, removing its files when
+// asset is nil.
+func saveHoldAsset(tenant string, kind string, asset *HoldAsset) error {
+    if cfg.HoldAssetsDir == "" {
+        return nil
+    }
+    dir := filepath.Join(cfg.HoldAssetsDir, tenant)
+    base := filepath.Join(dir, kind)
+    if asset == nil {
+        for _, path := range []string{base + ".json", base + ".wav"} {
+            if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+                return err
+            }
+        }
+        return nil
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    data, _ := json.MarshalIndent(asset, "", "  ")
+    if err := os.WriteFile(base+".json", data, 0644); err != nil {
+        return err
+    }
+    if asset.music == nil {
+        if err := os.Remove(base + ".wav"); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        return nil
+    }
+    return os.WriteFile(base+".wav", audiogen.EncodeWAV(asset.music, holdFormat()), 0644)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// SearchDocument is what the indexer writes to OpenSearch. Kind is
+// transcript for chat messages, summary for messages of type "summary"
+// posted by agents, and cdr for the record written when a room closes.
+type SearchDocument struct {
+    Kind         string                   `json:"kind"`
+    RoomId       string                   `json:"roomId"`
+    Tenant       string                   `json:"tenant,omitempty"`
+    Template     string                   `json:"template,omitempty"`
+    MessageId    string                   `json:"messageId,omitempty"`
+    From         string                   `json:"from,omitempty"`
+    Type         string                   `json:"type,omitempty"`
+    Audience     string                   `json:"audience,omitempty"`
+    Text         string                   `json:"text,omitempty"`
+    Timestamp    int64                    `json:"timestamp"`
+    StartedAt    int64                    `json:"startedAt,omitempty"`
+    EndedAt      int64                    `json:"endedAt,omitempty"`
+    DurationMs   int64                    `json:"durationMs,omitempty"`
+    Participants []storage.CDRParticipant `json:"participants,omitempty"`
+}
+
+// searchIndexTemplate is the mapping installed on startup for every index
+// matching the configured prefix. Keep it in sync with SearchDocument.
+const searchIndexTemplate = `{
+    "index_patterns": ["%s*"],
+    "template": {
+        "mappings": {
+            "dynamic": false,
+            "properties": {
+                "kind":       {"type": "keyword"},
+                "roomId":     {"type": "keyword"},
+                "tenant":     {"type": "keyword"},
+                "template":   {"type": "keyword"},
+                "messageId":  {"type": "keyword"},
+                "from":       {"type": "keyword"},
+                "type":       {"type": "keyword"},
+                "audience":   {"type": "keyword"},
+                "text":       {"type": "text"},
+                "timestamp":  {"type": "date", "format": "epoch_millis"},
+                "startedAt":  {"type": "date", "format": "epoch_millis"},
+                "endedAt":    {"type": "date", "format": "epoch_millis"},
+                "durationMs": {"type": "long"},
+                "participants": {
+                    "type": "nested",
+                    "properties": {
+                        "clientId":   {"type": "keyword"},
+                        "clientType": {"type": "keyword"},
+                        "role":       {"type": "keyword"},
+                        "joinedAt":   {"type": "date", "format": "epoch_millis"},
+                        "leftAt":     {"type": "date", "format": "epoch_millis"}
+                    }
+                }
+            }
+        }
+    }
+}`
+
+type searchIndexOp struct {
+    id  string
+    doc SearchDocument
+}
+
+// searchIndexer is nil unless -opensearch-url is set
+var searchIndexer chan searchIndexOp
+
+var searchHTTP = &http.Client{Timeout: 30 * time.Second}
+
+func startSearchIndexer() error {
+    if cfg.OpenSearchURL == "" {
+        return nil
+    }
+    
+    body := fmt.Sprintf(searchIndexTemplate, cfg.OpenSearchIndex)
+    if err := searchRequest(http.MethodPut, "/_index_template/"+cfg.OpenSearchIndex, "application/json", []byte(body)); err != nil {
+        return fmt.Errorf("installing index template: %v", err)
+    }
+    
+    searchIndexer = make(chan searchIndexOp, cfg.OpenSearchQueueSize)
+    go runSearchIndexer()
+    return nil
+}
+
+// indexSearchDocument queues doc without blocking the caller. Documents use
+// stable IDs so a retried bulk request overwrites instead of duplicating.
+func indexSearchDocument(id string, doc SearchDocument) {
+    if searchIndexer == nil {
+        return
+    }
+    select {
+    case searchIndexer <- searchIndexOp{id: id, doc: doc}:
+    default:
+        log.Printf("Search indexer queue full, dropping %s %s", doc.Kind, id)
+    }
+}
+
+func indexTranscript(entry storage.TranscriptEntry) {
+    kind := "transcript"
+    if entry.Type == "summary" {
+        kind = "summary"
+    }
+    indexSearchDocument(entry.RoomId+"/"+entry.MessageId, SearchDocument{
+        Kind:      kind,
+        RoomId:    entry.RoomId,
+        Tenant:    entry.Tenant,
+        MessageId: entry.MessageId,
+        From:      entry.From,
+        Type:      entry.Type,
+        Audience:  entry.Audience,
+        Text:      searchText(entry.Data),
+        Timestamp: entry.Timestamp,
+    })
+}
+
+func indexCDR(cdr storage.CDR) {
+    indexSearchDocument("cdr/"+cdr.Id, SearchDocument{
+        Kind:         "cdr",
+        RoomId:       cdr.RoomId,
+        Tenant:       cdr.Tenant,
+        Template:     cdr.Template,
+        Timestamp:    cdr.EndedAt,
+        StartedAt:    cdr.StartedAt,
+        EndedAt:      cdr.EndedAt,
+        DurationMs:   cdr.EndedAt - cdr.StartedAt,
+        Participants: cdr.Participants,
+    })
+}
+
+// searchText pulls the human readable part out of a message payload: the
+// payload itself when it's a string, otherwise its text field.
+func searchText(data json.RawMessage) string {
+    var text string
+    if json.Unmarshal(data, &text) == nil {
+        return text
+    }
+    var object struct {
+        Text string `json:"text"`
+    }
+    if json.Unmarshal(data, &object) == nil {
+        return object.Text
+    }
+    return ""
+}
+
+// searchIndexName partitions indices by month so old conversations can be
+// dropped by deleting whole indices.
+func searchIndexName(timestamp int64) string {
+    return cfg.OpenSearchIndex + "-" + time.Unix(0, timestamp*int64(time.Millisecond)).UTC().Format("2006.01")
+}
+
+// runSearchIndexer batches queued documents into bulk requests, flushing
+// when a batch fills or a second has passed.
+func runSearchIndexer() {
+    var batch []searchIndexOp
+    flush := time.NewTicker(time.Second)
+    defer flush.Stop()
+    
+    for {
+        select {
+        case op := <-searchIndexer:
+            batch = append(batch, op)
+            if len(batch) < cfg.OpenSearchBatchSize {
+                continue
+            }
+        case <-flush.C:
+            if len(batch) == 0 {
+                continue
+            }
+        }
+        
+        sendSearchBatch(batch)
+        batch = batch[:0]
+    }
+}
+
+func sendSearchBatch(batch []searchIndexOp) {
+    var body bytes.Buffer
+    for _, op := range batch {
+        action, _ := json.Marshal(map[string]interface{}{
+            "index": map[string]string{"_index": searchIndexName(op.doc.Timestamp), "_id": op.id},
+        })
+        doc, _ := json.Marshal(op.doc)
+        body.Write(action)
+        body.WriteByte('\n')
+        body.Write(doc)
+        body.WriteByte('\n')
+    }
+    
+    backoff := time.Second
+    for attempt := 1; ; attempt++ {
+        err := searchRequest(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+        if err == nil {
+            return
+        }
+        if attempt == 5 {
+            log.Printf("Search indexer dropping %d documents after %d attempts: %v", len(batch), attempt, err)
+            return
+        }
+        log.Printf("Search indexer bulk request failed, retrying in %v: %v", backoff, err)
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+func searchRequest(method string, path string, contentType string, body []byte) error {
+    req, err := http.NewRequest(method, strings.TrimRight(cfg.OpenSearchURL, "/")+path, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", contentType)
+    if cfg.OpenSearchUser != "" {
+        req.SetBasicAuth(cfg.OpenSearchUser, cfg.OpenSearchPassword)
+    }
+    
+    resp, err := searchHTTP.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode >= 300 {
+        detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("status %d: %s", resp.StatusCode, detail)
+    }
+    
+    // Bulk responses are 200 even when individual documents fail
+    var result struct {
+        Errors bool `json:"errors"`
+    }
+    if path == "/_bulk" && json.NewDecoder(resp.Body).Decode(&result) == nil && result.Errors {
+        log.Printf("Search indexer: some documents in a bulk request were rejected")
+    }
+    return nil
+}
+
+// GET /search?q=TEXT[&tenant=&roomId=&kind=&from=&size=] (admin)
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if cfg.OpenSearchURL == "" {
+        http.Error(w, "Search is not configured", http.StatusNotImplemented)
+        return
+    }
+    
+    query := r.URL.Query()
+    var filters []interface{}
+    for _, field := range []string{"tenant", "roomId", "kind"} {
+        if value := query.Get(field); value != "" {
+            filters = append(filters, map[string]interface{}{"term": map[string]string{field: value}})
+        }
+    }
+    must := []interface{}{map[string]interface{}{"match_all": map[string]interface{}{}}}
+    if text := query.Get("q"); text != "" {
+        must = []interface{}{map[string]interface{}{"match": map[string]string{"text": text}}}
+    }
+    
+    size := 20
+    if value := query.Get("size"); value != "" {
+        if _, err := fmt.Sscan(value, &size); err != nil || size <= 0 || size > 100 {
+            http.Error(w, "size must be between 1 and 100", http.StatusBadRequest)
+            return
+        }
+    }
+    from := 0
+    if value := query.Get("from"); value != "" {
+        if _, err := fmt.Sscan(value, &from); err != nil || from < 0 {
+            http.Error(w, "from must be a non-negative integer", http.StatusBadRequest)
+            return
+        }
+    }
+    
+    body, _ := json.Marshal(map[string]interface{}{
+        "from":  from,
+        "size":  size,
+        "query": map[string]interface{}{"bool": map[string]interface{}{"must": must, "filter": filters}},
+        "sort":  []interface{}{"_score", map[string]string{"timestamp": "desc"}},
+    })
+    req, err := http.NewRequest(http.MethodPost, strings.TrimRight(cfg.OpenSearchURL, "/")+"/"+cfg.OpenSearchIndex+"-*/_search", bytes.NewReader(body))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if cfg.OpenSearchUser != "" {
+        req.SetBasicAuth(cfg.OpenSearchUser, cfg.OpenSearchPassword)
+    }
+    
+    resp, err := searchHTTP.Do(req)
+    if err != nil {
+        log.Printf("Search request failed: %v", err)
+        http.Error(w, "Search unavailable", http.StatusBadGateway)
+        return
+    }
+    defer resp.Body.Close()
+    
+    var result struct {
+        Hits struct {
+            Total struct {
+                Value int `json:"value"`
+            } `json:"total"`
+            Hits []struct {
+                Id     string          `json:"_id"`
+                Score  float64         `json:"_score"`
+                Source json.RawMessage `json:"_source"`
+            } `json:"hits"`
+        } `json:"hits"`
+    }
+    if resp.StatusCode >= 300 || json.NewDecoder(resp.Body).Decode(&result) != nil {
+        log.Printf("Search request failed with status %d", resp.StatusCode)
+        http.Error(w, "Search unavailable", http.StatusBadGateway)
+        return
+    }
+    
+    hits := make([]map[string]interface{}, 0, len(result.Hits.Hits))
+    for _, hit := range result.Hits.Hits {
+        hits = append(hits, map[string]interface{}{"id": hit.Id, "score": hit.Score, "document": hit.Source})
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "total": result.Hits.Total.Value,
+        "hits":  hits,
+    })
+}
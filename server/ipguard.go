@@ -0,0 +1,204 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+type IPFilter struct {
+    Allow []string `json:"allow"` // Empty allows everyone not denied
+    Deny  []string `json:"deny"`
+}
+
+type ipGuard struct {
+    mu          sync.Mutex
+    connections map[string]int
+    buckets     map[string]*tokenBucket
+    allow       []*net.IPNet
+    deny        []*net.IPNet
+    filter      IPFilter
+}
+
+var guard = &ipGuard{
+    connections: make(map[string]int),
+    buckets:     make(map[string]*tokenBucket),
+}
+
+// clientIP uses X-Forwarded-For only when the server runs behind a trusted
+// proxy. Each of the -proxy-hops proxies appends the address it took the
+// request from, so the entry that many from the right is the client's; the
+// ones left of it are whatever the client sent.
+func clientIP(r *http.Request) string {
+    if cfg.TrustProxy {
+        var entries []string
+        for _, header := range r.Header.Values("X-Forwarded-For") {
+            for _, entry := range strings.Split(header, ",") {
+                if entry = strings.TrimSpace(entry); entry != "" {
+                    entries = append(entries, entry)
+                }
+            }
+        }
+        if len(entries) > 0 {
+            i := len(entries) - cfg.ProxyHops
+            if i < 0 {
+                i = 0 // Fewer entries than proxies, all of them a proxy's
+            }
+            return entries[i]
+        }
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+    nets := make([]*net.IPNet, 0, len(entries))
+    for _, entry := range entries {
+        if !strings.Contains(entry, "/") {
+            if strings.Contains(entry, ":") {
+                entry += "/128"
+            } else {
+                entry += "/32"
+            }
+        }
+        _, ipNet, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid address %q", entry)
+        }
+        nets = append(nets, ipNet)
+    }
+    return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+    for _, n := range nets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+func (g *ipGuard) setFilter(filter IPFilter) error {
+    allow, err := parseNets(filter.Allow)
+    if err != nil {
+        return err
+    }
+    deny, err := parseNets(filter.Deny)
+    if err != nil {
+        return err
+    }
+    
+    g.mu.Lock()
+    g.allow, g.deny, g.filter = allow, deny, filter
+    g.mu.Unlock()
+    return nil
+}
+
+// admit runs the allow/deny lists, the connection rate limit and the
+// concurrent connection cap. The returned release func must be called when
+// the connection ends.
+func (g *ipGuard) admit(ip string) (func(), int, string) {
+    parsed := net.ParseIP(ip)
+    
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    
+    if parsed != nil {
+        if containsIP(g.deny, parsed) {
+            return nil, http.StatusForbidden, "Address denied"
+        }
+        if len(g.allow) > 0 && !containsIP(g.allow, parsed) {
+            return nil, http.StatusForbidden, "Address not allowed"
+        }
+    }
+    
+    bucket := g.buckets[ip]
+    if bucket == nil {
+        bucket = newTokenBucket(cfg.ConnRatePerIP, float64(cfg.ConnBurstPerIP))
+        g.buckets[ip] = bucket
+    }
+    if !bucket.take(1) {
+        return nil, http.StatusTooManyRequests, "Connection rate exceeded"
+    }
+    
+    if cfg.MaxConnsPerIP > 0 && g.connections[ip] >= cfg.MaxConnsPerIP {
+        return nil, http.StatusTooManyRequests, "Too many connections from this address"
+    }
+    g.connections[ip]++
+    
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            g.mu.Lock()
+            defer g.mu.Unlock()
+            
+            if g.connections[ip]--; g.connections[ip] <= 0 {
+                delete(g.connections, ip)
+            }
+        })
+    }, 0, ""
+}
+
+func (g *ipGuard) pruneBuckets() {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    
+    for ip, bucket := range g.buckets {
+        if g.connections[ip] == 0 && bucket.idleSince(10*time.Minute) {
+            delete(g.buckets, ip)
+        }
+    }
+}
+
+func startIPGuardJanitor() {
+    go func() {
+        for range time.Tick(time.Minute) {
+            guard.pruneBuckets()
+        }
+    }()
+}
+
+// Admin API: GET/PUT /admin/ipfilter
+
+func handleIPFilter(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+        guard.mu.Lock()
+        response := map[string]interface{}{
+            "filter":      guard.filter,
+            "connections": guard.connections,
+        }
+        json.NewEncoder(w).Encode(response)
+        guard.mu.Unlock()
+        
+    case http.MethodPut:
+        var filter IPFilter
+        if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if err := guard.setFilter(filter); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        
+        log.Printf("IP filter updated: %d allow, %d deny entries", len(filter.Allow), len(filter.Deny))
+        json.NewEncoder(w).Encode(filter)
+        
+    default:
+        http.Error(w, "Only GET and PUT allowed", http.StatusMethodNotAllowed)
+    }
+}
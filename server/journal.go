@@ -0,0 +1,183 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// journalEntry is one numbered room event, kept with enough routing detail
+// to replay it only to the participants who would have received it live.
+type journalEntry struct {
+    msg      Message
+    exclude  string     // The sender, which never gets its own fan-out
+    audience ClientType // Empty for everyone, otherwise users or agents only
+}
+
+// roomJournal is the ordered event log of one room. mu is held across
+// numbering and fan-out so every participant sees events in sequence order.
+type roomJournal struct {
+    mu      sync.Mutex
+    seq     uint64
+    events  []journalEntry
+    updated time.Time
+    deleted bool // Set by the janitor, lockJournal retries on a fresh journal
+}
+
+var (
+    journals   = make(map[string]*roomJournal)
+    journalsMu sync.Mutex
+)
+
+// lockJournal returns the room's journal with mu held, creating it on first
+// use. Journals outlive empty rooms for the resume grace so reconnecting
+// clients keep a continuous sequence.
+func lockJournal(roomId string) *roomJournal {
+    for {
+        journalsMu.Lock()
+        j := journals[roomId]
+        if j == nil {
+            j = &roomJournal{updated: time.Now()}
+            journals[roomId] = j
+        }
+        journalsMu.Unlock()
+        
+        j.mu.Lock()
+        if !j.deleted {
+            return j
+        }
+        j.mu.Unlock()
+    }
+}
+
+// journalEvent numbers msg and appends it to the room's journal. The journal
+// stays locked until the returned func is called, which fan-out does once
+// every recipient has it queued. Ephemeral signals aren't journaled.
+func journalEvent(roomId string, sender *Client, msg *Message, audience ClientType) func() {
+    traceFanout(roomId, sender, msg, audience)
+    if isEphemeralType(msg.Type) || msg.Type == "transcript_partial" {
+        return func() {}
+    }
+    
+    j := lockJournal(roomId)
+    j.seq++
+    msg.Seq = j.seq
+    entry := journalEntry{msg: *msg, audience: audience}
+    if sender != nil {
+        entry.exclude = sender.clientId
+    }
+    j.events = append(j.events, entry)
+    if over := len(j.events) - cfg.JournalMaxEvents; over > 0 {
+        j.events = append([]journalEntry(nil), j.events[over:]...)
+    }
+    j.updated = time.Now()
+    return j.mu.Unlock
+}
+
+// visibleTo mirrors the live routing in broadcastToRoom, sendToAgents,
+// sendToUsers and selectiveSend.
+func (e *journalEntry) visibleTo(client *Client) bool {
+    if e.exclude == client.clientId {
+        return false
+    }
+    if e.audience != "" && e.audience != client.clientType {
+        return false
+    }
+    if len(e.msg.To) == 0 {
+        return true
+    }
+    for _, id := range e.msg.To {
+        if id == client.clientId {
+            return true
+        }
+    }
+    return false
+}
+
+// replay sends the client every event after lastSeq it would have received.
+// It reports the sequence range it covered; complete is false when older
+// events were already trimmed from the journal. Callers must hold j.mu.
+func (j *roomJournal) replay(client *Client, lastSeq uint64) (from uint64, to uint64, complete bool) {
+    from, to = lastSeq+1, j.seq
+    complete = true
+    if lastSeq > j.seq {
+        // The journal restarted since the client last saw it
+        complete = false
+    } else if len(j.events) > 0 && j.events[0].msg.Seq > from {
+        from = j.events[0].msg.Seq
+        complete = false
+    } else if len(j.events) == 0 && j.seq > lastSeq {
+        from = j.seq + 1
+        complete = false
+    }
+    
+    replayed := 0
+    for i := range j.events {
+        entry := &j.events[i]
+        if entry.msg.Seq < from || !entry.visibleTo(client) {
+            continue
+        }
+        msg := entry.msg
+        sendMessageToClient(client, &msg)
+        replayed++
+    }
+    
+    sendMessageToClient(client, &Message{
+        Type: "replay_complete",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "lastSeq":  lastSeq,
+            "fromSeq":  from,
+            "toSeq":    to,
+            "replayed": replayed,
+            "complete": complete,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    return from, to, complete
+}
+
+// parseLastSeq reads the optional lastSeq connect parameter. ok is false when
+// the client didn't ask for a replay.
+func parseLastSeq(raw string) (seq uint64, ok bool, err error) {
+    if raw == "" {
+        return 0, false, nil
+    }
+    seq, err = strconv.ParseUint(raw, 10, 64)
+    if err != nil {
+        return 0, false, fmt.Errorf("lastSeq must be a non-negative integer")
+    }
+    return seq, true, nil
+}
+
+// pruneJournals drops journals of rooms that have been gone for longer than
+// the resume grace.
+func pruneJournals() {
+    journalsMu.Lock()
+    defer journalsMu.Unlock()
+    
+    for roomId, j := range journals {
+        roomsMu.RLock()
+        _, live := rooms[roomId]
+        roomsMu.RUnlock()
+        if live {
+            continue
+        }
+        
+        j.mu.Lock()
+        if time.Since(j.updated) > cfg.ResumeGrace {
+            j.deleted = true
+            delete(journals, roomId)
+        }
+        j.mu.Unlock()
+    }
+}
+
+func startJournalJanitor() {
+    go func() {
+        for range time.Tick(time.Minute) {
+            pruneJournals()
+        }
+    }()
+}
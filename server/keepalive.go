@@ -0,0 +1,73 @@
+package main
+
+import (
+    "net"
+    "strconv"
+    "sync/atomic"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Keepalive. A client that vanishes without closing, a phone losing its
+// network say, would otherwise stay in its room until a write to it failed.
+// The server pings every connection each -ping-interval and expects to read
+// something, a pong or any message, within -pong-timeout. A connection that
+// stays silent longer is closed with idle_timeout, and the room gets
+// client_left with "reason": "timeout". Browsers and the SDKs answer pings
+// on their own, echoing the payload: each ping carries a sequence number,
+// and only a pong with the latest counts. A pong that answers no ping of
+// ours, an unsolicited one or a stale one, says nothing about the
+// connection now.
+
+// startKeepalive arms the read deadline and pings until the writer stops.
+func (c *Client) startKeepalive() {
+    if cfg.PongTimeout <= 0 {
+        return
+    }
+    c.extendReadDeadline()
+    interval := cfg.PingInterval
+    if interval <= 0 {
+        return
+    }
+    
+    var pinged uint64 // The latest ping's sequence number, 0 before the first
+    c.conn.SetPongHandler(func(payload string) error {
+        if seq := atomic.LoadUint64(&pinged); seq != 0 && payload == strconv.FormatUint(seq, 10) {
+            c.extendReadDeadline()
+        }
+        return nil
+    })
+    done := c.send.done
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+                // WriteControl is safe beside the writer goroutine
+                seq := atomic.AddUint64(&pinged, 1)
+                if err := c.conn.WriteControl(websocket.PingMessage, []byte(strconv.FormatUint(seq, 10)), time.Now().Add(interval)); err != nil {
+                    return
+                }
+            }
+        }
+    }()
+}
+
+// extendReadDeadline gives the client another -pong-timeout to be heard from.
+func (c *Client) extendReadDeadline() {
+    if cfg.PongTimeout > 0 {
+        c.conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+    }
+}
+
+// leaveReason tells a dead connection from a closed one.
+func leaveReason(err error) string {
+    if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+        return "timeout"
+    }
+    return "closed"
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Knowledge graph feedback. Facts the agent or a post-call pipeline extracts
+// from conversations reach the graph through the server rather than being
+// written straight in:
+//
+//   {"type": "kg_facts", "data": {"messageId": ID, "facts": [{"subject": "Clinic:Northside", "predicate": "PRICE_OF_CHECKUP",
+//       "object": "$95", "previous": "$80", "confidence": 0.97, "category": "price"}]}}
+//
+// A fact at least -kg-auto-approve-confidence sure, outside the
+// -kg-review-categories, is approved on arrival. The rest, such as a price
+// change or a policy statement, are staged in the pending set until a
+// reviewer approves or rejects them over /admin/knowledge/facts. A fact
+// without a category gets the first review category its predicate mentions,
+// so PRICE_OF_CHECKUP is a price. The sender gets kg_facts_staged with what
+// was approved and what waits. Facts take the room's graph partition, see
+// kgpartitions.go, which an agent can't choose.
+//
+// Only approved facts become queryable: each is published as a
+// kg_fact_approved event, which the graph's ingest consumes. Rejected and
+// pending facts stay on record with the call they came from.
+
+const (
+    maxFactsPerBatch = 100
+    maxFactPage      = 1000
+)
+
+var kgFactsTotal = newCounterVec("iva_kg_facts_total", "Extracted knowledge graph facts by outcome: auto_approved, pending, approved or rejected.", "outcome")
+
+func init() {
+    metricSeries = append(metricSeries, kgFactsTotal)
+}
+
+// triageFact decides whether a fact needs review, and why.
+func triageFact(fact *storage.KnowledgeFact) (string, string) {
+    if fact.Category == "" {
+        predicate := strings.ToLower(fact.Predicate)
+        for _, category := range cfg.KGReviewCategories {
+            if strings.Contains(predicate, strings.ToLower(category)) {
+                fact.Category = category
+                break
+            }
+        }
+    }
+    for _, category := range cfg.KGReviewCategories {
+        if strings.EqualFold(fact.Category, category) {
+            return storage.FactPending, category
+        }
+    }
+    if fact.Confidence < cfg.KGAutoApproveConfidence {
+        return storage.FactPending, fmt.Sprintf("confidence %.2f below %.2f", fact.Confidence, cfg.KGAutoApproveConfidence)
+    }
+    return storage.FactApproved, ""
+}
+
+// parseFacts decodes a batch of extracted facts.
+func parseFacts(raw interface{}) ([]storage.KnowledgeFact, error) {
+    encoded, _ := json.Marshal(raw)
+    var facts []storage.KnowledgeFact
+    if err := json.Unmarshal(encoded, &facts); err != nil {
+        return nil, fmt.Errorf("facts must be an array of facts")
+    }
+    if len(facts) == 0 || len(facts) > maxFactsPerBatch {
+        return nil, fmt.Errorf("1 to %d facts per batch", maxFactsPerBatch)
+    }
+    for i, fact := range facts {
+        if fact.Subject == "" || fact.Predicate == "" || fact.Object == "" {
+            return nil, fmt.Errorf("fact %d needs subject, predicate and object", i)
+        }
+        if fact.Confidence < 0 || fact.Confidence > 1 {
+            return nil, fmt.Errorf("fact %d: confidence must be 0 to 1", i)
+        }
+    }
+    return facts, nil
+}
+
+// stageFacts stores a batch, approving what needs no review, and returns the
+// IDs approved and pending.
+func stageFacts(ctx context.Context, room *RoomInfo, source string, messageId string, facts []storage.KnowledgeFact) ([]string, []string, error) {
+    approved, pending := []string{}, []string{}
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    partition := kgPartitionFor(room)
+    for _, fact := range facts {
+        fact.Id, fact.Tenant, fact.RoomId, fact.Source = newRandomId(), room.Tenant, room.RoomId, source
+        fact.Partition = partition
+        if messageId != "" {
+            fact.MessageId = messageId
+        }
+        fact.ReviewedBy, fact.Note, fact.CreatedAt, fact.UpdatedAt = "", "", now, now
+        fact.Status, fact.Reason = triageFact(&fact)
+        if err := store.Knowledge().AddFact(ctx, fact); err != nil {
+            return approved, pending, err
+        }
+        if fact.Status == storage.FactApproved {
+            approved = append(approved, fact.Id)
+            kgFactsTotal.inc(room.labels, "auto_approved")
+            publishFact(fact)
+        } else {
+            pending = append(pending, fact.Id)
+            kgFactsTotal.inc(room.labels, "pending")
+            logAt("info", room.RoomId, "", "Fact %s staged for review (%s): %s %s %s", fact.Id, fact.Reason, fact.Subject, fact.Predicate, fact.Object)
+        }
+    }
+    return approved, pending, nil
+}
+
+// publishFact hands an approved fact to the graph's ingest.
+func publishFact(fact storage.KnowledgeFact) {
+    emitEvent("kg_fact_approved", &RoomInfo{RoomId: fact.RoomId, Tenant: fact.Tenant}, map[string]interface{}{
+        "factId":     fact.Id,
+        "subject":    fact.Subject,
+        "predicate":  fact.Predicate,
+        "object":     fact.Object,
+        "previous":   fact.Previous,
+        "confidence": fact.Confidence,
+        "category":   fact.Category,
+        "partition":  fact.Partition,
+        "source":     fact.Source,
+        "messageId":  fact.MessageId,
+        "reviewedBy": fact.ReviewedBy,
+    })
+}
+
+// kg_facts: {"messageId": ID, "facts": [FACT...]}
+func handleKGFacts(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents submit facts")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    facts, err := parseFacts(data["facts"])
+    if err != nil {
+        sendError(sender, ErrInvalidMessage, msg, "%v", err)
+        return
+    }
+    messageId, _ := data["messageId"].(string)
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        approved, pending, err := stageFacts(ctx, room, sender.clientId, messageId, facts)
+        if err != nil {
+            logAt("warn", roomId, sender.clientId, "Staging facts failed: %v", err)
+            sendError(sender, ErrUnavailable, msg, "facts could not be stored")
+            return
+        }
+        sendMessageToClient(sender, &Message{
+            Id:   newMessageId(),
+            Type: "kg_facts_staged",
+            From: SystemSender,
+            Data: map[string]interface{}{
+                "requestId": msg.Id,
+                "approved":  approved,
+                "pending":   pending,
+            },
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+    }()
+}
+
+// /admin/knowledge/facts (admin), from handleKnowledge:
+//
+//   GET  /admin/knowledge/facts[?tenant=&status=&roomId=&limit=]  oldest first, status defaults to pending
+//   POST /admin/knowledge/facts                                    {"tenant", "template", "roomId", "source", "messageId", "facts": [...]}
+//   GET  /admin/knowledge/facts/ID
+//   POST /admin/knowledge/facts/ID/approve|reject                 {"reviewer": NAME, "note": TEXT}
+func handleKnowledgeFacts(w http.ResponseWriter, r *http.Request, id string, action string) {
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        listFacts(w, r)
+    case id == "" && r.Method == http.MethodPost:
+        submitFacts(w, r)
+    case id != "" && action == "" && r.Method == http.MethodGet:
+        fact, err := store.Knowledge().GetFact(r.Context(), id)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Fact not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(fact)
+    case id != "" && (action == "approve" || action == "reject") && r.Method == http.MethodPost:
+        reviewFact(w, r, id, action)
+    case action == "" || action == "approve" || action == "reject":
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func listFacts(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    limit := 100
+    if value := query.Get("limit"); value != "" {
+        var err error
+        if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxFactPage {
+            http.Error(w, "limit must be 1 to "+strconv.Itoa(maxFactPage), http.StatusBadRequest)
+            return
+        }
+    }
+    status := query.Get("status")
+    if !query.Has("status") {
+        status = storage.FactPending
+    }
+    facts, err := store.Knowledge().ListFacts(r.Context(), storage.FactFilter{
+        Tenant: query.Get("tenant"),
+        Status: status,
+        RoomId: query.Get("roomId"),
+        Limit:  limit,
+    })
+    if err != nil {
+        log.Printf("Fact query failed: %v", err)
+        http.Error(w, "Pending facts unavailable", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "facts": facts,
+        "count": len(facts),
+    })
+}
+
+func submitFacts(w http.ResponseWriter, r *http.Request) {
+    var batch struct {
+        Tenant    string      `json:"tenant"`
+        Template  string      `json:"template"`
+        RoomId    string      `json:"roomId"`
+        Source    string      `json:"source"`
+        MessageId string      `json:"messageId"`
+        Facts     interface{} `json:"facts"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    facts, err := parseFacts(batch.Facts)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if batch.Source == "" {
+        batch.Source = "api"
+    }
+    room := &RoomInfo{RoomId: batch.RoomId, Tenant: batch.Tenant, Template: batch.Template, labels: metricLabelsFor(batch.Tenant, "")}
+    approved, pending, err := stageFacts(r.Context(), room, batch.Source, batch.MessageId, facts)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{"approved": approved, "pending": pending})
+}
+
+func reviewFact(w http.ResponseWriter, r *http.Request, id string, action string) {
+    var review struct {
+        Reviewer string `json:"reviewer"`
+        Note     string `json:"note"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    fact, err := store.Knowledge().GetFact(r.Context(), id)
+    if err == storage.ErrNotFound {
+        http.Error(w, "Fact not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if fact.Status != storage.FactPending {
+        http.Error(w, "Fact already "+fact.Status, http.StatusConflict)
+        return
+    }
+    
+    fact.Status, fact.ReviewedBy, fact.Note = storage.FactRejected, review.Reviewer, review.Note
+    if action == "approve" {
+        fact.Status = storage.FactApproved
+    }
+    fact.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    err = store.Knowledge().ReviewFact(r.Context(), fact)
+    if err == storage.ErrNotFound {
+        http.Error(w, "Fact already reviewed", http.StatusConflict)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    kgFactsTotal.inc(metricLabelsFor(fact.Tenant, ""), fact.Status)
+    log.Printf("Fact %s %s by %s", fact.Id, fact.Status, review.Reviewer)
+    if fact.Status == storage.FactApproved {
+        publishFact(fact)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(fact)
+}
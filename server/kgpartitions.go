@@ -0,0 +1,29 @@
+package main
+
+// Knowledge graph partitions. A room can be bound to a named partition of the
+// graph, one product line say, so the virtual agent retrieves and answers
+// only from the knowledge meant for that conversation:
+//
+//   -kg-tenant-partitions acme=retail -kg-template-partitions acme-pharmacy=pharmacy
+//
+// A template's binding wins over its tenant's, and "none" gives the
+// template's rooms the whole graph again. The agent learns its partition
+// from the room config in welcome, "kgPartition", and its query layer runs
+// every Cypher scoped to nodes tagged with it (see bot/rag/neo4j.py), so a
+// question the model steers elsewhere finds nothing. Facts extracted in the
+// room carry the partition to the graph's ingest. Cached answers are already
+// kept per tenant and template, so a partition never answers for another.
+// Rooms without a binding see everything.
+
+// kgPartitionFor is the partition a room's retrieval is limited to, empty
+// for none.
+func kgPartitionFor(room *RoomInfo) string {
+    partition, _ := lookupOverride(cfg.KGTenantPartitions, room.Tenant)
+    if value, ok := lookupOverride(cfg.KGTemplatePartitions, room.Template); ok {
+        partition = value
+    }
+    if partition == "none" {
+        return ""
+    }
+    return partition
+}
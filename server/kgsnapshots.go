@@ -0,0 +1,94 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Knowledge graph snapshots. The graph's ingest publishes changes as
+// numbered snapshots and tells the server which one is current:
+//
+//   POST /admin/knowledge/snapshot   {"snapshot": "v43"}
+//
+// (-kg-snapshot names the one to start with). A room is pinned to the
+// snapshot current when it is created and keeps it for the whole call, so an
+// update landing mid-call can't have the agent contradict itself: the room
+// config in welcome carries "kgSnapshot", the agent retrieves from that
+// snapshot only, and answer_lookup without a kgVersion looks in its cache
+// entries. Every assistant_final is recorded with the kgVersion it was
+// answered from, the pinned one unless the agent says otherwise, and the CDR
+// keeps the pin, so an audit can query the graph as the agent saw it.
+
+var kgSnapshot struct {
+    sync.Mutex
+    current string
+    since   int64 // Unix ms it became current
+}
+
+// currentKGSnapshot is the snapshot new rooms are pinned to.
+func currentKGSnapshot() string {
+    kgSnapshot.Lock()
+    defer kgSnapshot.Unlock()
+    if kgSnapshot.current == "" {
+        return cfg.KGSnapshot
+    }
+    return kgSnapshot.current
+}
+
+// stampKGVersion records the pinned snapshot on an assistant_final that
+// doesn't name the one it used.
+func stampKGVersion(roomId string, sender *Client, msg *Message) {
+    room, _, _ := roomMembers(roomId)
+    if room == nil || room.KGSnapshot == "" {
+        return
+    }
+    data := msg.Data.(map[string]interface{})
+    version, _ := data["kgVersion"].(string)
+    switch {
+    case version == "":
+        data["kgVersion"] = room.KGSnapshot
+    case version != room.KGSnapshot:
+        logAt("warn", roomId, sender.clientId, "Answer from snapshot %s in a room pinned to %s", version, room.KGSnapshot)
+    }
+}
+
+// The snapshot is media server state, so unlike the rest of /admin/knowledge
+// this is not a worker route: the ingest posts it to every media server.
+//
+//   GET  /admin/knowledge/snapshot   {"snapshot", "since"} (admin)
+//   POST /admin/knowledge/snapshot   {"snapshot": NAME} makes it current for new rooms
+func handleKGSnapshot(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            Snapshot string `json:"snapshot"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        if req.Snapshot == "" {
+            http.Error(w, "snapshot required", http.StatusBadRequest)
+            return
+        }
+        kgSnapshot.Lock()
+        kgSnapshot.current, kgSnapshot.since = req.Snapshot, time.Now().UnixNano()/int64(time.Millisecond)
+        kgSnapshot.Unlock()
+        log.Printf("Knowledge graph snapshot %s is current", req.Snapshot)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    kgSnapshot.Lock()
+    since := kgSnapshot.since
+    kgSnapshot.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"snapshot": currentKGSnapshot(), "since": since})
+}
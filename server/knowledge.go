@@ -0,0 +1,343 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Knowledge gaps. When the virtual agent can't ground an answer in the
+// knowledge graph or FAQ it says so on the assistant_final:
+//
+//   {"type": "assistant_final", "data": {"text": "I'm not sure, let me get someone.", "question": TEXT, "grounded": false}}
+//
+// and the question joins its tenant's curation queue with the answer given
+// and the last -knowledge-gap-history messages of the call. With
+// -knowledge-gaps-uncited an answer to a question that cites nothing counts
+// as ungrounded too. Without a question the caller's last transcript is
+// taken. The same question asked again, after normalizing as the answer
+// cache does, counts another occurrence on the open gap, so the queue lists
+// what callers ask most first.
+//
+// Curators work through it over /admin/knowledge: approving a gap with an
+// answer adds an FAQ entry, which the agent grounds later answers in and
+// cites as {"type": "faq", "id": ID}. Every new entry is published as a
+// faq_added event for the graph's ingest, and cached answers to the question
+// are dropped so the next caller gets the curated one.
+
+const maxGapPage = 1000
+
+var knowledgeGapsTotal = newCounterVec("iva_knowledge_gaps_total", "Ungrounded answers, by whether the question was new to the queue.", "outcome")
+
+func init() {
+    metricSeries = append(metricSeries, knowledgeGapsTotal)
+}
+
+// noteKnowledgeGap queues the question of an ungrounded assistant_final.
+func noteKnowledgeGap(roomId string, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    grounded, declared := data["grounded"].(bool)
+    question, _ := data["question"].(string)
+    citations, _ := data["citations"].([]Citation)
+    if declared && grounded {
+        return
+    }
+    if !declared && (!cfg.KnowledgeGapsUncited || question == "" || len(citations) > 0) {
+        return
+    }
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    answer, _ := data["text"].(string)
+    go recordKnowledgeGap(room, msg.Id, question, answer)
+}
+
+func recordKnowledgeGap(room *RoomInfo, messageId string, question string, answer string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    entries, err := store.Transcripts().List(ctx, room.RoomId, "", cfg.KnowledgeGapHistory)
+    if err != nil {
+        logAt("warn", room.RoomId, "", "Knowledge gap without history: %v", err)
+    }
+    // Newest first from the store, oldest first for a reader
+    for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+        entries[i], entries[j] = entries[j], entries[i]
+    }
+    if question == "" {
+        question = lastTranscript(entries)
+    }
+    key := normalizeQuestion(question)
+    if key == "" {
+        return
+    }
+    snapshot, _ := json.Marshal(entries)
+    
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    gap, err := store.Knowledge().RecordGap(ctx, storage.KnowledgeGap{
+        Id:          newRandomId(),
+        Tenant:      room.Tenant,
+        Template:    room.Template,
+        Key:         key,
+        Question:    question,
+        Answer:      answer,
+        RoomId:      room.RoomId,
+        MessageId:   messageId,
+        Context:     snapshot,
+        Occurrences: 1,
+        Status:      storage.GapOpen,
+        CreatedAt:   now,
+        UpdatedAt:   now,
+    })
+    if err != nil {
+        logAt("warn", room.RoomId, "", "Recording knowledge gap failed: %v", err)
+        return
+    }
+    if gap.Occurrences > 1 {
+        knowledgeGapsTotal.inc(room.labels, "repeat")
+        return
+    }
+    knowledgeGapsTotal.inc(room.labels, "new")
+    logAt("info", room.RoomId, "", "Knowledge gap %s queued: %q", gap.Id, question)
+}
+
+// lastTranscript is the text of the newest transcript in entries.
+func lastTranscript(entries []storage.TranscriptEntry) string {
+    for i := len(entries) - 1; i >= 0; i-- {
+        if entries[i].Type != "transcript" {
+            continue
+        }
+        var data struct {
+            Text string `json:"text"`
+        }
+        if json.Unmarshal(entries[i].Data, &data) == nil && data.Text != "" {
+            return data.Text
+        }
+    }
+    return ""
+}
+
+// /admin/knowledge (admin):
+//
+//   GET    /admin/knowledge/gaps[?tenant=&status=&limit=]  the queue, most asked first
+//   GET    /admin/knowledge/gaps/ID
+//   POST   /admin/knowledge/gaps/ID/approve                {"question": TEXT, "answer": TEXT, "reviewer": NAME}
+//   POST   /admin/knowledge/gaps/ID/dismiss                {"reviewer": NAME, "note": TEXT}
+//   GET    /admin/knowledge/faq[?tenant=]
+//   POST   /admin/knowledge/faq                            add an entry no caller asked for yet
+//   DELETE /admin/knowledge/faq/ID
+func handleKnowledge(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/knowledge"), "/"), "/")
+    if len(parts) > 3 {
+        http.NotFound(w, r)
+        return
+    }
+    for len(parts) < 3 {
+        parts = append(parts, "")
+    }
+    kind, id, action := parts[0], parts[1], parts[2]
+    if kind == "facts" {
+        handleKnowledgeFacts(w, r, id, action)
+        return
+    }
+    reviewing := action == "approve" || action == "dismiss"
+    
+    switch {
+    case kind == "gaps" && id == "" && r.Method == http.MethodGet:
+        listGaps(w, r)
+    case kind == "gaps" && id != "" && action == "" && r.Method == http.MethodGet:
+        gap, ok := loadGap(w, r, id)
+        if !ok {
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(gap)
+    case kind == "gaps" && id != "" && reviewing && r.Method == http.MethodPost:
+        reviewGap(w, r, id, action)
+    case kind == "faq" && id == "" && r.Method == http.MethodGet:
+        entries, err := store.Knowledge().ListFAQ(r.Context(), r.URL.Query().Get("tenant"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries, "count": len(entries)})
+    case kind == "faq" && id == "" && r.Method == http.MethodPost:
+        var entry storage.FAQEntry
+        if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+            http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        entry.Id, entry.GapId, entry.CreatedAt = newRandomId(), "", time.Now().UnixNano()/int64(time.Millisecond)
+        if !cleanFAQ(&entry) {
+            http.Error(w, "question and answer required", http.StatusBadRequest)
+            return
+        }
+        if err := store.Knowledge().AddFAQ(r.Context(), entry); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        publishFAQ(w, entry)
+    case kind == "faq" && id != "" && action == "" && r.Method == http.MethodDelete:
+        deleteFAQ(w, r, id)
+    case (kind == "gaps" && (action == "" || reviewing)) || (kind == "faq" && action == ""):
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func listGaps(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    limit := 100
+    if value := query.Get("limit"); value != "" {
+        var err error
+        if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxGapPage {
+            http.Error(w, "limit must be 1 to "+strconv.Itoa(maxGapPage), http.StatusBadRequest)
+            return
+        }
+    }
+    status := query.Get("status")
+    if !query.Has("status") {
+        status = storage.GapOpen
+    }
+    gaps, err := store.Knowledge().ListGaps(r.Context(), storage.GapFilter{
+        Tenant: query.Get("tenant"),
+        Status: status,
+        Limit:  limit,
+    })
+    if err != nil {
+        log.Printf("Knowledge gap query failed: %v", err)
+        http.Error(w, "Knowledge gap queue unavailable", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "gaps":  gaps,
+        "count": len(gaps),
+    })
+}
+
+func loadGap(w http.ResponseWriter, r *http.Request, id string) (storage.KnowledgeGap, bool) {
+    gap, err := store.Knowledge().GetGap(r.Context(), id)
+    if err == storage.ErrNotFound {
+        http.Error(w, "Knowledge gap not found", http.StatusNotFound)
+        return gap, false
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return gap, false
+    }
+    return gap, true
+}
+
+// reviewGap closes an open gap. An approval's answer becomes an FAQ entry,
+// the question defaulting to the one asked.
+func reviewGap(w http.ResponseWriter, r *http.Request, id string, action string) {
+    var review struct {
+        Question string `json:"question"`
+        Answer   string `json:"answer"`
+        Reviewer string `json:"reviewer"`
+        Note     string `json:"note"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    gap, ok := loadGap(w, r, id)
+    if !ok {
+        return
+    }
+    if gap.Status != storage.GapOpen {
+        http.Error(w, "Knowledge gap already "+gap.Status, http.StatusConflict)
+        return
+    }
+    
+    gap.Status, gap.ReviewedBy, gap.Note = storage.GapDismissed, review.Reviewer, review.Note
+    gap.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    var entry storage.FAQEntry
+    if action == "approve" {
+        if review.Question == "" {
+            review.Question = gap.Question
+        }
+        entry = storage.FAQEntry{
+            Id:        newRandomId(),
+            Tenant:    gap.Tenant,
+            Question:  review.Question,
+            Answer:    review.Answer,
+            GapId:     gap.Id,
+            CreatedBy: review.Reviewer,
+            CreatedAt: gap.UpdatedAt,
+        }
+        gap.Status, gap.FAQId = storage.GapApproved, entry.Id
+        if !cleanFAQ(&entry) {
+            http.Error(w, "answer required", http.StatusBadRequest)
+            return
+        }
+        if err := store.Knowledge().AddFAQ(r.Context(), entry); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+    if err := store.Knowledge().ReviewGap(r.Context(), gap); err != nil {
+        if gap.FAQId != "" {
+            store.Knowledge().DeleteFAQ(r.Context(), gap.FAQId)
+        }
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    log.Printf("Knowledge gap %s %s by %s", gap.Id, gap.Status, review.Reviewer)
+    if gap.FAQId != "" {
+        publishFAQ(w, entry)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(gap)
+}
+
+// cleanFAQ trims an entry, reporting whether it has a question and answer.
+func cleanFAQ(entry *storage.FAQEntry) bool {
+    entry.Question, entry.Answer = strings.TrimSpace(entry.Question), strings.TrimSpace(entry.Answer)
+    return normalizeQuestion(entry.Question) != "" && entry.Answer != ""
+}
+
+// publishFAQ hands a stored entry to the graph's ingest, drops the cached
+// answers it supersedes and answers 201 with it.
+func publishFAQ(w http.ResponseWriter, entry storage.FAQEntry) {
+    key := normalizeQuestion(entry.Question)
+    invalidateAnswers(func(answer *cachedAnswer) bool {
+        return answer.Tenant == entry.Tenant && answer.Question == key
+    })
+    emitEvent("faq_added", &RoomInfo{Tenant: entry.Tenant}, map[string]interface{}{
+        "faqId":    entry.Id,
+        "question": entry.Question,
+        "answer":   entry.Answer,
+        "gapId":    entry.GapId,
+    })
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(entry)
+}
+
+func deleteFAQ(w http.ResponseWriter, r *http.Request, id string) {
+    err := store.Knowledge().DeleteFAQ(r.Context(), id)
+    if err == storage.ErrNotFound {
+        http.Error(w, "FAQ entry not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    emitEvent("faq_deleted", &RoomInfo{}, map[string]interface{}{"faqId": id})
+    w.WriteHeader(http.StatusNoContent)
+}
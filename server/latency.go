@@ -0,0 +1,277 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "log"
+    "math"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Latency budget for the speech loop. A turn's latency runs from the end of
+// the caller's speech, their last frame louder than -latency-voice-threshold,
+// to the agent's first audio after it, streamed by the agent or spoken
+// through speak. Every turn goes into iva_turn_latency_seconds.
+//
+// With -latency-target set, the p95 of each tenant and template's turns over
+// -latency-window is held against it. A flow past the target goes on a
+// budget until its p95 is back under 80% of the target: speak switches to
+// -latency-tts-provider and -latency-tts-voice, and the flow's agents get
+//
+//   {"type": "latency_budget", "data": {"state": "over", "p95Ms", "targetMs", "model", "maxContextTurns"}}
+//
+// to move to -latency-model and trim their context to -latency-max-context
+// turns, then "state": "ok" once it is over. Agents joining meanwhile find
+// the same in the welcome's room config.
+
+// turnTimer follows the current turn of a room. Guarded by roomsMu.
+type turnTimer struct {
+    voiceAt  int64 // Unix ms of the caller's last voiced frame
+    waiting  bool  // The caller has spoken since the agent last did
+    agentAt  int64 // Unix ms of the agent's last frame
+    runAt    int64 // Unix ms the caller's current stretch of speech began
+    bargedIn bool  // The stretch has interrupted the agent
+}
+
+// A pause this long in the agent's audio makes the next frame a new turn.
+const turnGapMs = 250
+
+// Budget decisions wait for this many turns in the window.
+const minBudgetTurns = 10
+
+type latencySample struct {
+    at      time.Time
+    latency time.Duration
+}
+
+// latencyScope is one tenant and template's recent turns. Guarded by
+// latencyMu.
+type latencyScope struct {
+    labels  []string
+    samples []latencySample
+    p95     time.Duration
+    over    bool
+}
+
+var (
+    latencyScopes = make(map[string]*latencyScope) // By metric labels
+    latencyMu     sync.Mutex                        // Never held while taking roomsMu
+    
+    turnLatency         = newHistogramVec("iva_turn_latency_seconds", "Time from the end of the caller's speech to the agent's first audio.", []float64{0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5})
+    latencyBudgetsTotal = newCounterVec("iva_latency_budget_total", "Flows going over their latency budget and back.", "state")
+)
+
+func init() {
+    metricSeries = append(metricSeries, latencyBudgetsTotal)
+}
+
+// noteCallerAudio marks the caller's latest speech.
+func noteCallerAudio(client *Client, data []byte) {
+    if frameLevel(data) < cfg.LatencyVoiceThreshold {
+        return
+    }
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    var agents []*Client
+    roomsMu.Lock()
+    if room := rooms[client.room]; room != nil {
+        turn := &room.turn
+        if now-turn.voiceAt > bargeInGapMs {
+            turn.runAt, turn.bargedIn = now, false
+        }
+        turn.voiceAt = now
+        turn.waiting = true
+        // Talking over the agent's audio for -barge-in-time stops its speech
+        if cfg.BargeInTime > 0 && !turn.bargedIn && now-turn.runAt >= cfg.BargeInTime.Milliseconds() && now-turn.agentAt <= turnGapMs {
+            turn.bargedIn = true
+            for _, agent := range room.Agents {
+                agents = append(agents, agent)
+            }
+        }
+    }
+    roomsMu.Unlock()
+    if len(agents) > 0 {
+        bargeIn(client.room, agents)
+    }
+}
+
+// noteAgentAudio times the turn an agent's audio answers.
+func noteAgentAudio(roomId string) {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return
+    }
+    turn := &room.turn
+    stopHoldLocked(room, holdThinking)
+    starts := now-turn.agentAt > turnGapMs
+    turn.agentAt = now
+    if !starts || !turn.waiting {
+        roomsMu.Unlock()
+        return
+    }
+    turn.waiting = false
+    latency := time.Duration(now-turn.voiceAt) * time.Millisecond
+    labels := room.labels
+    roomsMu.Unlock()
+    
+    turnLatency.observe(latency.Seconds(), labels)
+    if cfg.LatencyTarget > 0 {
+        observeLatency(labels, latency)
+    }
+}
+
+// observeLatency adds a turn to its flow's window and moves the flow on or
+// off its budget.
+func observeLatency(labels []string, latency time.Duration) {
+    now := time.Now()
+    key := strings.Join(labels, "/")
+    latencyMu.Lock()
+    scope := latencyScopes[key]
+    if scope == nil {
+        scope = &latencyScope{labels: labels}
+        latencyScopes[key] = scope
+    }
+    scope.samples = append(scope.samples, latencySample{now, latency})
+    kept := scope.samples[:0]
+    for _, sample := range scope.samples {
+        if now.Sub(sample.at) <= cfg.LatencyWindow {
+            kept = append(kept, sample)
+        }
+    }
+    scope.samples = kept
+    if len(scope.samples) < minBudgetTurns {
+        latencyMu.Unlock()
+        return
+    }
+    sorted := make([]time.Duration, len(scope.samples))
+    for i, sample := range scope.samples {
+        sorted[i] = sample.latency
+    }
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    scope.p95 = sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+    changed := false
+    if !scope.over && scope.p95 > cfg.LatencyTarget {
+        scope.over, changed = true, true
+    } else if scope.over && scope.p95 < cfg.LatencyTarget*8/10 {
+        scope.over, changed = false, true
+    }
+    budget := latencyBudget(scope)
+    latencyMu.Unlock()
+    
+    if changed {
+        announceLatencyBudget(labels, budget)
+    }
+}
+
+// latencyBudget describes a flow's budget to its agents. Callers hold
+// latencyMu.
+func latencyBudget(scope *latencyScope) map[string]interface{} {
+    budget := map[string]interface{}{
+        "state":    "ok",
+        "p95Ms":    scope.p95.Milliseconds(),
+        "targetMs": cfg.LatencyTarget.Milliseconds(),
+    }
+    if scope.over {
+        budget["state"] = "over"
+        if cfg.LatencyModel != "" {
+            budget["model"] = cfg.LatencyModel
+        }
+        if cfg.LatencyMaxContext > 0 {
+            budget["maxContextTurns"] = cfg.LatencyMaxContext
+        }
+    }
+    return budget
+}
+
+func announceLatencyBudget(labels []string, budget map[string]interface{}) {
+    state := budget["state"].(string)
+    log.Printf("level=warn Latency budget %s for tenant %q template %q: p95 %dms, target %dms", state, labels[0], labels[1], budget["p95Ms"], budget["targetMs"])
+    latencyBudgetsTotal.inc(labels, state)
+    
+    var roomIds []string
+    roomsMu.RLock()
+    for roomId, room := range rooms {
+        if strings.Join(room.labels, "/") == strings.Join(labels, "/") {
+            roomIds = append(roomIds, roomId)
+        }
+    }
+    roomsMu.RUnlock()
+    data := map[string]interface{}{"tenant": labels[0], "template": labels[1]}
+    for key, value := range budget {
+        data[key] = value
+    }
+    emitEvent("latency_budget", &RoomInfo{Tenant: labels[0], Template: labels[1]}, data)
+    for _, roomId := range roomIds {
+        sendToAgents(roomId, nil, &Message{
+            Id:        newMessageId(),
+            Type:      "latency_budget",
+            From:      SystemSender,
+            Data:      budget,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+    }
+}
+
+// overBudget reports whether the room's flow is on its latency budget.
+func overBudget(room *RoomInfo) bool {
+    if cfg.LatencyTarget <= 0 {
+        return false
+    }
+    latencyMu.Lock()
+    defer latencyMu.Unlock()
+    scope := latencyScopes[strings.Join(room.labels, "/")]
+    return scope != nil && scope.over
+}
+
+// roomLatencyBudget is the room config's latencyBudget, nil without a target.
+func roomLatencyBudget(room *RoomInfo) map[string]interface{} {
+    if cfg.LatencyTarget <= 0 {
+        return nil
+    }
+    latencyMu.Lock()
+    defer latencyMu.Unlock()
+    scope := latencyScopes[strings.Join(room.labels, "/")]
+    if scope == nil {
+        scope = &latencyScope{}
+    }
+    return latencyBudget(scope)
+}
+
+// writeLatencyGauges reports each flow's windowed p95.
+func writeLatencyGauges(b *strings.Builder) {
+    if cfg.LatencyTarget <= 0 {
+        return
+    }
+    latencyMu.Lock()
+    defer latencyMu.Unlock()
+    keys := make([]string, 0, len(latencyScopes))
+    for key := range latencyScopes {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    fmt.Fprintf(b, "# HELP iva_turn_latency_p95_seconds Windowed p95 turn latency the latency budget is held to.\n# TYPE iva_turn_latency_p95_seconds gauge\n")
+    for _, key := range keys {
+        b.WriteString("iva_turn_latency_p95_seconds")
+        writeLabels(b, []string{"tenant", "template"}, latencyScopes[key].labels)
+        fmt.Fprintf(b, " %g\n", latencyScopes[key].p95.Seconds())
+    }
+}
+
+// frameLevel is a pcm16 frame's RMS, 0..1.
+func frameLevel(pcm []byte) float64 {
+    samples := len(pcm) / 2
+    if samples == 0 {
+        return 0
+    }
+    sum := 0.0
+    for i := 0; i < samples; i++ {
+        v := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / math.MaxInt16
+        sum += v * v
+    }
+    return math.Sqrt(sum / float64(samples))
+}
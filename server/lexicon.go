@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+    "github.com/yourusername/my-go-project/tts"
+)
+
+// Pronunciation lexicon, so brand and product names are said and heard
+// right. Each tenant maps written forms to a phoneme string (IPA or X-SAMPA)
+// and/or a respelled alias, managed over /admin/tts/lexicon like phrase
+// hints, with "_default" entries applying to every tenant and a tenant's
+// own winning. Speech to a room goes out with the words marked up, see
+// tts.Lexicon, and the written forms join the tenant's STT vocabulary with
+// their soundsLike as the mishearings post-correction rewrites. Aliases stay
+// out of it, as respellings may be ordinary words.
+// Entries take effect for the next utterance; transcription sessions already
+// running keep the vocabulary they started with.
+
+var (
+    lexicons   = make(map[string]*tts.Lexicon) // By tenant, compiled
+    lexiconsMu sync.Mutex
+)
+
+func lexiconFor(tenant string) *tts.Lexicon {
+    lexiconsMu.Lock()
+    defer lexiconsMu.Unlock()
+    if lexicon, ok := lexicons[tenant]; ok {
+        return lexicon
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    
+    var entries []tts.Pronunciation
+    for _, entry := range lexiconEntries(ctx, tenant) {
+        entries = append(entries, tts.Pronunciation{Written: entry.Written, Phoneme: entry.Phoneme, Alphabet: entry.Alphabet, Alias: entry.Alias})
+    }
+    lexicon := tts.NewLexicon(entries)
+    lexicons[tenant] = lexicon
+    return lexicon
+}
+
+// lexiconEntries are the _default entries followed by the tenant's.
+func lexiconEntries(ctx context.Context, tenant string) []storage.Pronunciation {
+    var entries []storage.Pronunciation
+    for _, scope := range []string{defaultPhraseTenant, tenant} {
+        list, err := store.Lexicon().List(ctx, scope)
+        if err != nil {
+            logAt("warn", "", "", "Loading the lexicon for %s: %v", logValue(scope), err)
+            continue
+        }
+        entries = append(entries, list...)
+        if tenant == defaultPhraseTenant {
+            break
+        }
+    }
+    return entries
+}
+
+// pronounced is req with the tenant's lexicon applied for provider.
+func pronounced(tenant string, provider tts.Provider, req tts.Request) tts.Request {
+    return lexiconFor(tenant).Apply(req, tts.HonoursSSML(provider.Name()))
+}
+
+// invalidateLexicon drops the compiled lexicons and vocabularies a change
+// to the tenant's entries affects.
+func invalidateLexicon(tenant string) {
+    lexiconsMu.Lock()
+    if tenant == defaultPhraseTenant {
+        lexicons = make(map[string]*tts.Lexicon)
+    } else {
+        delete(lexicons, tenant)
+    }
+    lexiconsMu.Unlock()
+    invalidateVocabulary(tenant)
+}
+
+// Admin API: /admin/tts/lexicon/TENANT[/WRITTEN]
+//   GET    lists the tenant's pronunciations
+//   POST   adds or updates one: {"written", "phoneme", "alphabet", "alias", "soundsLike"}
+//   PUT    replaces the tenant's pronunciations with the posted list
+//   DELETE with a WRITTEN form removes that pronunciation
+func handleLexicon(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    // Written forms may contain spaces and slashes, so take them from the escaped path
+    rest := strings.TrimPrefix(r.URL.EscapedPath(), "/admin/tts/lexicon/")
+    tenantPart, writtenPart, _ := strings.Cut(rest, "/")
+    tenant, err1 := url.PathUnescape(tenantPart)
+    written, err2 := url.PathUnescape(writtenPart)
+    if tenant == "" || err1 != nil || err2 != nil {
+        http.Error(w, "Tenant required, use _default for every tenant", http.StatusBadRequest)
+        return
+    }
+    
+    ctx := r.Context()
+    lexicon := store.Lexicon()
+    switch r.Method {
+    case http.MethodGet:
+        list, err := lexicon.List(ctx, tenant)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"tenant": tenant, "lexicon": list})
+    
+    case http.MethodPost:
+        var entry storage.Pronunciation
+        if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if !validPronunciation(w, &entry, tenant) {
+            return
+        }
+        if err := lexicon.Put(ctx, entry); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        invalidateLexicon(tenant)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(entry)
+    
+    case http.MethodPut:
+        var list []storage.Pronunciation
+        if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+            http.Error(w, "Expected a JSON array of pronunciations", http.StatusBadRequest)
+            return
+        }
+        keep := make(map[string]bool)
+        for i := range list {
+            if !validPronunciation(w, &list[i], tenant) {
+                return
+            }
+            keep[list[i].Written] = true
+        }
+        
+        existing, err := lexicon.List(ctx, tenant)
+        if err == nil {
+            for _, entry := range existing {
+                if !keep[entry.Written] {
+                    err = lexicon.Delete(ctx, tenant, entry.Written)
+                }
+                if err != nil {
+                    break
+                }
+            }
+        }
+        for i := 0; err == nil && i < len(list); i++ {
+            err = lexicon.Put(ctx, list[i])
+        }
+        invalidateLexicon(tenant)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    
+    case http.MethodDelete:
+        if written == "" {
+            http.Error(w, "Written form required", http.StatusBadRequest)
+            return
+        }
+        err := lexicon.Delete(ctx, tenant, written)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Pronunciation not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        invalidateLexicon(tenant)
+        w.WriteHeader(http.StatusNoContent)
+    
+    default:
+        http.Error(w, "Only GET, POST, PUT and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// validPronunciation normalizes a posted pronunciation and writes the error
+// itself.
+func validPronunciation(w http.ResponseWriter, entry *storage.Pronunciation, tenant string) bool {
+    entry.Tenant = tenant
+    entry.Written = strings.TrimSpace(entry.Written)
+    entry.Phoneme = strings.TrimSpace(entry.Phoneme)
+    entry.Alias = strings.TrimSpace(entry.Alias)
+    entry.SoundsLike = trimPhrases(entry.SoundsLike)
+    switch {
+    case len([]rune(entry.Written)) < 2 || len(entry.Written) > 100:
+        http.Error(w, "written must be 2 to 100 characters", http.StatusBadRequest)
+    case entry.Phoneme == "" && entry.Alias == "":
+        http.Error(w, "Give a phoneme, an alias or both", http.StatusBadRequest)
+    case len(entry.Phoneme) > 200 || len(entry.Alias) > 100:
+        http.Error(w, "phoneme must be at most 200 characters and alias 100", http.StatusBadRequest)
+    case entry.Alphabet != "" && entry.Alphabet != "ipa" && entry.Alphabet != "x-sampa":
+        http.Error(w, "alphabet must be ipa or x-sampa", http.StatusBadRequest)
+    case entry.Phoneme == "" && entry.Alphabet != "":
+        http.Error(w, "alphabet needs a phoneme", http.StatusBadRequest)
+    default:
+        entry.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+        return true
+    }
+    return false
+}
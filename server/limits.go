@@ -0,0 +1,46 @@
+package main
+
+import (
+    "fmt"
+)
+
+// Message limits, so no one client can make the server buffer without
+// bound. Every connection reads frames of at most -max-frame-bytes, text or
+// audio: a bigger one closes it with 1009 (message too big). Under that, a
+// message over a limit is refused with an error and the connection carries
+// on:
+//
+//   a JSON message over -max-message-bytes         message_too_large
+//   a "to" list naming over -max-recipients        message_too_large
+//   metadata over -max-metadata-keys, or a value
+//   over -max-metadata-value-bytes                 message_too_large
+//
+// the error's message saying which limit. Welcome's room config lists them
+// under "limits". Zero turns a limit off.
+
+// checkMessageLimits refuses limits that contradict each other.
+func checkMessageLimits() error {
+    if cfg.MaxFrameBytes < 0 || cfg.MaxMessageBytes < 0 || cfg.MaxRecipients < 0 {
+        return fmt.Errorf("-max-frame-bytes, -max-message-bytes and -max-recipients must not be negative")
+    }
+    if cfg.MaxFrameBytes > 0 && int64(cfg.MaxMessageBytes) > cfg.MaxFrameBytes {
+        return fmt.Errorf("-max-message-bytes %d is over -max-frame-bytes %d, no message that big is read", cfg.MaxMessageBytes, cfg.MaxFrameBytes)
+    }
+    return nil
+}
+
+// messageTooLarge checks a client's JSON message before it is parsed.
+func messageTooLarge(data []byte) error {
+    if cfg.MaxMessageBytes > 0 && len(data) > cfg.MaxMessageBytes {
+        return newCodedError(ErrTooLarge, "message is %d bytes, over the limit of %d", len(data), cfg.MaxMessageBytes)
+    }
+    return nil
+}
+
+// checkRecipients checks a parsed message's "to" list.
+func checkRecipients(msg *Message) error {
+    if cfg.MaxRecipients > 0 && len(msg.To) > cfg.MaxRecipients {
+        return newCodedError(ErrTooLarge, "to names %d recipients, over the limit of %d", len(msg.To), cfg.MaxRecipients)
+    }
+    return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// Localization of what the server itself says to callers: the fallback
+// prompts, the built-in hold phrases and the TTS voice they are spoken in. A
+// room's locale is the ?locale= of whoever opens it, else its template's in
+// -locales TEMPLATE=LOCALE, else -locale. Welcome's room config carries it,
+// and transcription takes it as the language hint when the client sends
+// none and the locale isn't -locale.
+//
+// -message-catalog names a JSON file of texts and a voice per locale:
+//
+//   {"es": {"voice": "es-ES-ElviraNeural",
+//           "messages": {"fallback.stt": "Perdone, ...", "hold.queue": "..."}},
+//    "es-MX": {"voice": "es-MX-DaliaNeural"}}
+//
+// Keys are fallback.KEY for the fallback prompts (stt, tts, llm, kg, human
+// and callback, see fallback.go) and hold.KIND for the phrases of tenants
+// without hold assets (queue, hold and thinking, see hold.go). A locale
+// missing a key or the voice falls back to its language, es-MX to es, then
+// to the built-in or -fallback-prompts text and recording, the hold prompts
+// and the default voice. A localized prompt is always synthesized, as SSML
+// when it is a <speak> document.
+// -tts-voices overrides still win for their templates, and tenants localize
+// their own hold phrases under "localized" in PUT /admin/hold/TENANT/KIND.
+
+type localeEntry struct {
+    Voice    string            `json:"voice,omitempty"`
+    Messages map[string]string `json:"messages,omitempty"`
+}
+
+var messageCatalog = make(map[string]localeEntry) // By lower case locale
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+func validLocale(locale string) bool {
+    return len(locale) <= 35 && localePattern.MatchString(locale)
+}
+
+// catalogKeys lists the messages a catalog may localize.
+func catalogKeys() []string {
+    keys := make([]string, 0, len(defaultFallbackPrompts)+len(holdKinds))
+    for key := range defaultFallbackPrompts {
+        keys = append(keys, "fallback."+key)
+    }
+    for _, kind := range holdKinds {
+        keys = append(keys, "hold."+kind)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// loadMessageCatalog checks the locales and reads -message-catalog.
+func loadMessageCatalog() error {
+    if !validLocale(cfg.DefaultLocale) {
+        return fmt.Errorf("-locale %q is not a locale like en-US", cfg.DefaultLocale)
+    }
+    for _, entry := range cfg.TemplateLocales {
+        if _, locale, _ := strings.Cut(entry, "="); !validLocale(locale) {
+            return fmt.Errorf("-locales %q: want TEMPLATE=LOCALE with a locale like en-US", entry)
+        }
+    }
+    if cfg.MessageCatalog == "" {
+        return nil
+    }
+    
+    data, err := os.ReadFile(cfg.MessageCatalog)
+    if err != nil {
+        return err
+    }
+    var catalog map[string]localeEntry
+    if err := json.Unmarshal(data, &catalog); err != nil {
+        return fmt.Errorf("%s: %v", cfg.MessageCatalog, err)
+    }
+    keys := catalogKeys()
+    for locale, entry := range catalog {
+        if !validLocale(locale) {
+            return fmt.Errorf("%s: %q is not a locale like en-US", cfg.MessageCatalog, locale)
+        }
+        for key := range entry.Messages {
+            if !containsString(keys, key) {
+                return fmt.Errorf("%s: unknown message %s.%s, use %s", cfg.MessageCatalog, locale, key, strings.Join(keys, ", "))
+            }
+            if err := checkPromptSSML(entry.Messages[key]); err != nil {
+                return fmt.Errorf("%s: %s.%s: %v", cfg.MessageCatalog, locale, key, err)
+            }
+        }
+        messageCatalog[strings.ToLower(locale)] = entry
+    }
+    log.Printf("Loaded the message catalog for %d locales", len(catalog))
+    return nil
+}
+
+// roomLocale picks a new room's locale.
+func roomLocale(requested string, template string) string {
+    if requested != "" {
+        return requested
+    }
+    if locale, ok := lookupOverride(cfg.TemplateLocales, template); ok {
+        return locale
+    }
+    return cfg.DefaultLocale
+}
+
+// localeChain is what a locale falls back through, most specific first and
+// lower case: es-mx, es.
+func localeChain(locale string) []string {
+    var chain []string
+    for locale = strings.ToLower(locale); locale != ""; {
+        chain = append(chain, locale)
+        i := strings.LastIndex(locale, "-")
+        if i < 0 {
+            break
+        }
+        locale = locale[:i]
+    }
+    return chain
+}
+
+// localized is the catalog's text for key in the room's locale.
+func localized(room *RoomInfo, key string) (string, bool) {
+    for _, locale := range localeChain(room.Locale) {
+        if text, ok := messageCatalog[locale].Messages[key]; ok {
+            return text, true
+        }
+    }
+    return "", false
+}
+
+// localeVoice is the catalog's TTS voice for the room's locale.
+func localeVoice(room *RoomInfo) (string, bool) {
+    for _, locale := range localeChain(room.Locale) {
+        if voice := messageCatalog[locale].Voice; voice != "" {
+            return voice, true
+        }
+    }
+    return "", false
+}
+
+// fallbackPromptFor is the fallback prompt for key as the room hears it.
+func fallbackPromptFor(room *RoomInfo, key string) *fallbackPrompt {
+    if text, ok := localized(room, "fallback."+key); ok {
+        return &fallbackPrompt{text: text}
+    }
+    return fallbackPrompts[key]
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Log lines carry their level and the room and client they concern as a
+// logfmt prefix, e.g. "level=warn roomId=r1 clientId=c1 Write error ...".
+// logTail parses them back out so operators can follow one call live.
+
+type LogEntry struct {
+    Time    int64             `json:"time"` // Unix milliseconds
+    Level   string            `json:"level"`
+    Message string            `json:"message"`
+    Fields  map[string]string `json:"fields,omitempty"`
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// logFieldKeys are the prefix keys parsed into LogEntry.Fields.
+var logFieldKeys = map[string]bool{"roomId": true, "clientId": true, "tenant": true, "ip": true}
+
+// logAt writes a leveled line about a room and client. Either ID may be empty.
+func logAt(level string, roomId string, clientId string, format string, args ...interface{}) {
+    var prefix strings.Builder
+    prefix.WriteString("level=" + level)
+    if roomId != "" {
+        prefix.WriteString(" roomId=" + logValue(roomId))
+    }
+    if clientId != "" {
+        prefix.WriteString(" clientId=" + logValue(clientId))
+    }
+    message := fmt.Sprintf(format, args...)
+    log.Printf("%s %s", prefix.String(), message)
+    if roomId != "" {
+        traceEvent(roomId, "log", clientId, map[string]interface{}{"level": level, "message": message})
+    }
+}
+
+func logValue(value string) string {
+    if value == "" || strings.ContainsAny(value, " =\"") {
+        return strconv.Quote(value)
+    }
+    return value
+}
+
+type logSubscriber struct {
+    entries  chan LogEntry
+    minLevel int
+    fields   map[string]string // Every listed field must match
+    dropped  int               // Guarded by logTail.mu
+}
+
+type logTailer struct {
+    mu     sync.Mutex
+    recent []LogEntry // Ring of the latest lines for ?backlog=
+    next   int
+    subs   map[*logSubscriber]bool
+}
+
+var logTail = &logTailer{recent: make([]LogEntry, 0, 500), subs: make(map[*logSubscriber]bool)}
+
+// Write receives each line from the standard logger, see main.
+func (t *logTailer) Write(p []byte) (int, error) {
+    entry := parseLogLine(strings.TrimRight(string(p), "\n"))
+    
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    
+    if len(t.recent) < cap(t.recent) {
+        t.recent = append(t.recent, entry)
+    } else {
+        t.recent[t.next] = entry
+        t.next = (t.next + 1) % len(t.recent)
+    }
+    for sub := range t.subs {
+        if !sub.matches(entry) {
+            continue
+        }
+        select {
+        case sub.entries <- entry:
+        default:
+            sub.dropped++
+        }
+    }
+    return len(p), nil
+}
+
+// parseLogLine strips the standard date and time prefix, then reads leading
+// key=value pairs. Lines without a level are info.
+func parseLogLine(line string) LogEntry {
+    entry := LogEntry{Time: time.Now().UnixNano() / int64(time.Millisecond), Level: "info"}
+    if len(line) >= 20 && line[4] == '/' && line[7] == '/' && line[13] == ':' {
+        line = line[20:]
+    }
+    
+    for {
+        key, rest, ok := strings.Cut(line, "=")
+        if !ok || strings.ContainsAny(key, " \t") || (key != "level" && !logFieldKeys[key]) {
+            break
+        }
+        var value string
+        if strings.HasPrefix(rest, `"`) {
+            quoted, err := strconv.QuotedPrefix(rest)
+            if err != nil {
+                break
+            }
+            value, _ = strconv.Unquote(quoted)
+            rest = rest[len(quoted):]
+        } else {
+            value, rest, _ = strings.Cut(rest, " ")
+            rest = " " + rest
+        }
+        if key == "level" {
+            entry.Level = value
+        } else {
+            if entry.Fields == nil {
+                entry.Fields = make(map[string]string)
+            }
+            entry.Fields[key] = value
+        }
+        line = strings.TrimPrefix(rest, " ")
+    }
+    entry.Message = line
+    return entry
+}
+
+func (s *logSubscriber) matches(entry LogEntry) bool {
+    if logLevels[entry.Level] < s.minLevel {
+        return false
+    }
+    for key, want := range s.fields {
+        if entry.Fields[key] != want {
+            return false
+        }
+    }
+    return true
+}
+
+// GET /admin/logs/stream?level=info&roomId=&clientId=&backlog=N (admin)
+// Server-sent events, one "log" event per line. Lines a slow reader misses
+// are counted and reported in a "dropped" event.
+func handleLogStream(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+    
+    query := r.URL.Query()
+    sub := &logSubscriber{entries: make(chan LogEntry, 256), fields: make(map[string]string)}
+    if level := query.Get("level"); level != "" {
+        n, known := logLevels[level]
+        if !known {
+            http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+            return
+        }
+        sub.minLevel = n
+    }
+    for key := range logFieldKeys {
+        if value := query.Get(key); value != "" {
+            sub.fields[key] = value
+        }
+    }
+    backlog := 0
+    if value := query.Get("backlog"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 0 {
+            http.Error(w, "backlog must be a non-negative integer", http.StatusBadRequest)
+            return
+        }
+        backlog = n
+    }
+    
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    
+    // Snapshot the backlog and subscribe atomically so no line is missed or
+    // sent twice
+    logTail.mu.Lock()
+    var history []LogEntry
+    for i := 0; i < len(logTail.recent); i++ {
+        entry := logTail.recent[(logTail.next+i)%len(logTail.recent)]
+        if sub.matches(entry) {
+            history = append(history, entry)
+        }
+    }
+    if len(history) > backlog {
+        history = history[len(history)-backlog:]
+    }
+    logTail.subs[sub] = true
+    logTail.mu.Unlock()
+    
+    defer func() {
+        logTail.mu.Lock()
+        delete(logTail.subs, sub)
+        logTail.mu.Unlock()
+    }()
+    
+    for _, entry := range history {
+        writeLogEvent(w, entry)
+    }
+    flusher.Flush()
+    
+    keepalive := time.NewTicker(15 * time.Second)
+    defer keepalive.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case entry := <-sub.entries:
+            writeLogEvent(w, entry)
+        case <-keepalive.C:
+            fmt.Fprint(w, ": keepalive\n\n")
+        }
+        
+        logTail.mu.Lock()
+        dropped := sub.dropped
+        sub.dropped = 0
+        logTail.mu.Unlock()
+        if dropped > 0 {
+            fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped)
+        }
+        flusher.Flush()
+    }
+}
+
+func writeLogEvent(w http.ResponseWriter, entry LogEntry) {
+    data, _ := json.Marshal(entry)
+    fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "fmt"
     "log"
     "math/rand"
+    "io"
     "net/http"
+    "os"
+    "strconv"
     "strings"
     "sync"
     "time"
     "github.com/gorilla/websocket"
+    "github.com/yourusername/my-go-project/amd"
+    "github.com/yourusername/my-go-project/storage"
+    "github.com/yourusername/my-go-project/stt"
+    "github.com/yourusername/my-go-project/watermark"
 )
 
 type ClientType string
@@ -20,31 +29,103 @@ const (
 
 type Client struct {
     conn     *websocket.Conn
+    send     *sendQueue // Drained by the client's writer goroutine
+    state    ClientState // Guarded by mu
     room     string
     clientId string
     clientType ClientType
+    role     string
+    tenant   string
+    locale   string // The room locale asked for, see locale.go
+    mu       sync.Mutex // Guards state, metadata, lastEphemeral, audioChannel, consult and the pacer
     metadata map[string]interface{}
+    lastEphemeral map[string]time.Time
+    audioChannel string // Private channel the client's audio is diverted to
+    consult      string // Consult bridge the client is on, see consult.go
+    framer   *audioFramer // Only used by the read loop
+    pacer    *audioPacer  // Guarded by mu, created on first paced frame
+    pacerStopped bool
+    resumeToken string // Guarded by mu, cleared when the client may not resume, see resume.go
+    resumeTokenHash string // Guarded by mu, all a failed-over session knows of its token, see failover.go
+    superseded  bool   // Guarded by mu, a resumed connection or a duplicate took this one's place
+    requestedId string // The clientId asked for when the room suffixed it, see duplicates.go
+    displayName string // Guarded by mu, see profile_update
+    avatar      string
+    labels      []string // Capped tenant and template metric labels, set on join
+    stt         stt.Session // Guarded by mu, nil unless the room transcribes
+    sttStopped  bool        // Guarded by mu, a session that opens after stopTranscription closes
+    sttProvider string
+    sttBytesPerSecond int
+    clip        *clipBuffer // Guarded by mu, recent STT audio when -clips-dir is set
+    stopSpeech  context.CancelCauseFunc // Guarded by mu, interrupts the agent's current speech
+    speechStream *speechStream // Guarded by mu, the speak_stream being spoken
+    voiceRate   int    // Guarded by mu, non-zero while recent audio is kept for voice matching
+    voiceSample []byte // Guarded by mu, the last -speaker-sample-seconds
+    voiceHeard  int    // Guarded by mu, bytes of audio since joining
+    speakerChecked bool // Guarded by mu, recognition has been tried
+    machineDetector *amd.Detector // Guarded by mu, set while an outbound callee's answer is analysed
+    agentId     string // Agents only, the worker whose capacity the connection counts against, see routing.go
+    watermark   *watermark.Embedder // Only used by the read loop, marks the agent's audio for callers
+    candidate   string // Shadow agents only, the configuration being evaluated, see shadow.go
+    shadowStreams map[string]string // Only used by a shadow's read loop, speak_stream text by streamId
+    audioBytesPerSecond int  // Only used by the read loop, 0 unless the client declared pcm16, see quotas.go
+    lastAudioAt time.Time    // Only used by the read loop
+    rates       *clientRates // Only used by the read loop, see ratelimit.go
 }
 
 type Message struct {
+    Id        string                 `json:"id,omitempty"`
     Type      string                 `json:"type"`
     From      string                 `json:"from"`
     To        []string               `json:"to,omitempty"` // Empty means broadcast to all
     Data      interface{}            `json:"data"`
+    Channel   string                 `json:"channel,omitempty"` // Private channel ID, see channel_open
     Metadata  map[string]interface{} `json:"metadata,omitempty"`
+    Seq       uint64                 `json:"seq,omitempty"` // Room journal position, see journalEvent
     Timestamp int64                  `json:"timestamp"`
 }
 
 type ServerInfo struct {
-    Address string `json:"address"`
-    Port    int    `json:"port"`
+    Address  string `json:"address"`
+    Port     int    `json:"port"`
+    Identity string `json:"identity,omitempty"` // Certificate identity when registered over mTLS
+    Region   string `json:"region,omitempty"`
+    LastSeen int64  `json:"lastSeen,omitempty"`
+    Draining bool   `json:"draining,omitempty"` // Not allocated, see drain.go
+    TLS      bool   `json:"tls,omitempty"`      // Serves https:// and wss://, see tls.go
 }
 
 type RoomInfo struct {
     RoomId    string            `json:"roomId"`
     Users     map[string]*Client `json:"users"`
     Agents    map[string]*Client `json:"agents"`
-    CreatedAt int64             `json:"createdAt"`
+    Channels  map[string]*Channel `json:"-"`
+    Departed  map[string]time.Time `json:"-"` // Recently left client IDs, see markDeparted
+    Shadows   map[string]*Client `json:"-"`   // Shadow agents, see shadow.go
+    held      map[string]*heldSession         // Dropped clients that may resume, by client ID, see resume.go
+    shadowTurn *ShadowTurn                    // The caller turn shadows are answering
+    cdr       *storage.CDR        // Built up while the room is open, saved on close
+    stats     callStats           // Rolled into analytics on close
+    labels    []string            // Capped tenant and template metric labels
+    verifications map[string]*callerVerification // By user client ID
+    fallbacks map[string]string                   // Integration to the fallback applied, see fallback.go
+    callbacks map[string]string                   // User client ID to their queued callback
+    waitingSince int64                            // Unix ms users have waited for an agent since, see callbacks.go
+    watermark string                              // Token of the call's audio once audited, see watermark.go
+    turn      turnTimer                           // See latency.go
+    hold      *holdPlayback                       // Hold audio playing to the users, see hold.go
+    comforted int64                               // The turn.voiceAt thinking audio last played for
+    variants  map[string]ExperimentVariant        // By experiment, see experiments.go
+    usage     roomUsage                           // Spend against the room's quotas, see quotas.go
+    spend     roomSpend                           // Estimated cost so far, see cost.go
+    rate      *tokenBucket                        // All the room's messages together, see ratelimit.go
+    Recording  RecordingState    `json:"recording"`
+    Tenant     string            `json:"tenant,omitempty"`
+    Template   string            `json:"template,omitempty"`
+    Locale     string            `json:"locale,omitempty"`
+    CreatedAt  int64             `json:"createdAt"`
+    Variants   map[string]string `json:"variants,omitempty"`   // Experiment to variant
+    KGSnapshot string            `json:"kgSnapshot,omitempty"` // Pinned for the call, see kgsnapshots.go
 }
 
 var (
@@ -56,15 +137,15 @@ var (
 )
 
 var upgrader = websocket.Upgrader{
-    CheckOrigin: func(r *http.Request) bool {
-        return true
-    },
+    CheckOrigin: checkOrigin, // See origins.go
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
     roomId := r.URL.Query().Get("room")
     clientId := r.URL.Query().Get("clientId")
     clientType := ClientType(r.URL.Query().Get("type"))
+    tenant := r.URL.Query().Get("tenant")
+    template := r.URL.Query().Get("template")
     
     if roomId == "" {
         http.Error(w, "room query param required", http.StatusBadRequest)
@@ -76,174 +157,641 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
         return
     }
     
+    if isReservedClientId(clientId) {
+        http.Error(w, "clientId is reserved", http.StatusBadRequest)
+        return
+    }
+    
     if clientType != ClientTypeUser && clientType != ClientTypeAgent {
         clientType = ClientTypeUser // default to user
     }
     
-    conn, err := upgrader.Upgrade(w, r, nil)
-    if err != nil {
-        log.Println("Upgrade error:", err)
+    role := r.URL.Query().Get("role")
+    
+    locale := r.URL.Query().Get("locale")
+    if locale != "" && !validLocale(locale) {
+        http.Error(w, "locale must be like en-US", http.StatusBadRequest)
+        return
+    }
+    
+    // With tickets enabled the signed claims win over query params
+    claimed := false
+    if ticketsEnabled() {
+        ticket, err := ticketForConnection(r.URL.Query().Get("ticket"), roomId, clientId, clientType)
+        if err != nil {
+            refuseUpgrade(w, r, ErrNotPermitted, http.StatusUnauthorized, err.Error())
+            return
+        }
+        if ticket.Tenant != "" {
+            tenant = ticket.Tenant
+        }
+        if ticket.Role != "" {
+            role = ticket.Role
+            claimed = true
+        }
+        claimed = claimed || ticket.ClientType == ClientTypeAgent // Only admins mint agent tickets
+    }
+    
+    if role == "" {
+        role = string(clientType)
+    }
+    if !knownRole(role) {
+        http.Error(w, "unknown role", http.StatusBadRequest)
+        return
+    }
+    if role == string(ClientTypeUser) && clientType != ClientTypeUser {
+        http.Error(w, "the user role connects as a user", http.StatusBadRequest)
+        return
+    }
+    if isElevated(clientType, role) && !claimed && !isAdminRequest(r) && !isLoopbackRequest(r) {
+        refuseUpgrade(w, r, ErrNotPermitted, http.StatusForbidden, "role requires admin credentials")
+        return
+    }
+    if role == "shadow" && clientType != ClientTypeAgent {
+        http.Error(w, "shadows connect as agents", http.StatusBadRequest)
         return
     }
     
     client := &Client{
-        conn:       conn,
         room:       roomId,
         clientId:   clientId,
         clientType: clientType,
-        metadata:   make(map[string]interface{}),
+        role:       role,
+        tenant:     tenant,
+        locale:     locale,
+        metadata:   map[string]interface{}{"role": role},
+        lastEphemeral: make(map[string]time.Time),
+        resumeToken: newRandomId(),
+        rates:       newClientRates(clientType),
+    }
+    if role == "shadow" {
+        client.candidate = r.URL.Query().Get("candidate")
+    }
+    
+    if err := setInitialProfile(client, r.URL.Query().Get("displayName"), r.URL.Query().Get("avatar")); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    if err := parseInitialMetadata(client, r.URL.Query().Get("metadata")); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    if clientType == ClientTypeAgent {
+        client.agentId = r.URL.Query().Get("agentId")
+        if client.agentId == "" {
+            client.agentId = clientId
+        }
+    }
+    if err := declareCapacity(client, r.URL.Query()); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
     
-    // Add client to room
-    addClientToRoom(roomId, client)
+    lastSeq, replay, err := parseLastSeq(r.URL.Query().Get("lastSeq"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    ip := clientIP(r)
+    release, status, reason := guard.admit(ip)
+    if release == nil {
+        log.Printf("level=warn ip=%s Connection refused: %s", logValue(ip), reason)
+        http.Error(w, reason, status)
+        return
+    }
+    defer release()
     
-    log.Printf("Client %s (%s) joined room: %s", clientId, clientType, roomId)
+    conn, err := upgrader.Upgrade(w, r, nil)
+    if err != nil {
+        log.Println("Upgrade error:", err)
+        return
+    }
+    configureCompression(conn)
+    if cfg.MaxFrameBytes > 0 {
+        conn.SetReadLimit(cfg.MaxFrameBytes) // See limits.go
+    }
+    client.conn = conn
+    client.framer = newAudioFramer(r.URL.Query())
+    client.audioBytesPerSecond = pcm16BytesPerSecond(r.URL.Query())
+    client.startWriter()
+    client.startKeepalive()
+    
+    // Shadows evaluate a candidate agent off to the side of the room
+    if role == "shadow" {
+        serveShadow(client)
+        return
+    }
+    
+    // A client reconnecting with its resume token takes its old place, see resume.go
+    resumed := resumeSession(roomId, clientId, clientType, r.URL.Query().Get("resumeToken"))
+    if resumed != nil {
+        restoreSession(client, resumed.client)
+    }
+    
+    // Add client to room. The journal stays locked until the welcome (and any
+    // replay) is queued so no event slips between the snapshot and the stream.
+    journal := lockJournal(roomId)
+    if migrated, ok := migratedTo(roomId); ok {
+        journal.mu.Unlock()
+        serveRedirect(client, migrated, r.URL.Query().Get("resumeToken"))
+        return
+    }
+    // The token proves the client is the session's, even where it has to join afresh
+    proven := resumed != nil
+    if resumed != nil && (resumed.handed || !resumeClientInRoom(roomId, client)) {
+        resumed = nil
+    }
+    var replaced *Client
+    if resumed == nil {
+        if replaced, err = addClientToRoom(roomId, client, template); err != nil {
+            journal.mu.Unlock()
+            logAt("warn", roomId, clientId, "Client refused: %v", err)
+            client.stopWriter()
+            rejectConnection(conn, errorCode(err, ErrRoomFull), err.Error())
+            return
+        }
+    }
+    clientId = client.clientId // Suffixed when the ID was taken, see duplicates.go
+    client.setState(ClientActive)
+    
+    logAt("info", roomId, clientId, "Client (%s) joined room", clientType)
+    // Opening a session dials the vendor, which must hold up neither the room nor the read loop
+    go startTranscription(client, r.URL.Query())
+    startVoiceSample(client, r.URL.Query())
+    startMachineDetection(client, r.URL.Query())
     
     // Send welcome message with room info
-    sendWelcomeMessage(client)
+    sendWelcomeMessage(client, journal.seq)
+    var replayFrom, replayTo uint64
+    if replay {
+        replayFrom, replayTo, _ = journal.replay(client, lastSeq)
+    }
+    journal.mu.Unlock()
+    // Queued messages are private: only a resume token or a ticket proves they are this client's
+    if proven || ticketsEnabled() {
+        deliverOfflineQueue(client, replayFrom, replayTo)
+    } else {
+        discardOfflineQueue(roomId, clientId)
+    }
     
     // Notify others about new client
-    notifyClientJoined(roomId, client)
+    if replaced != nil {
+        closeReplaced(roomId, replaced)
+    }
+    if resumed != nil {
+        notifySessionResumed(roomId, client, resumed.since)
+        logAt("info", roomId, clientId, "Client (%s) resumed its session", clientType)
+    } else {
+        notifyClientJoined(roomId, client)
+        if clientType == ClientTypeUser {
+            scheduleDemoAgent(roomId)
+        }
+    }
     
     // Handle messages - FIXED VERSION
+    leftBecause := "closed"
+    var readErr error
     for {
         messageType, data, err := conn.ReadMessage()
+        if err == websocket.ErrReadLimit {
+            logAt("warn", roomId, clientId, "Frame over %d bytes, closed with 1009", cfg.MaxFrameBytes)
+        }
         if err != nil {
-            log.Printf("Read error (room %s, client %s): %v", roomId, clientId, err)
+            logAt("info", roomId, clientId, "Read error: %v", err)
+            leftBecause, readErr = leaveReason(err), err
             break
         }
+        client.extendReadDeadline()
         
         switch messageType {
         case websocket.TextMessage:
             // Handle JSON messages
+            if !allowMessage(client) {
+                continue
+            }
+            if err := messageTooLarge(data); err != nil {
+                logAt("warn", roomId, clientId, "Message refused: %v", err)
+                sendError(client, ErrTooLarge, nil, "%v", err)
+                continue
+            }
             var msg Message
             err := json.Unmarshal(data, &msg)
             if err != nil {
-                log.Printf("JSON unmarshal error (room %s, client %s): %v", roomId, clientId, err)
+                logAt("warn", roomId, clientId, "JSON unmarshal error: %v", err)
+                sendError(client, ErrInvalidMessage, nil, "message is not valid JSON")
                 continue
             }
             
+            // From is always the authenticated client, whatever the payload claims
+            msg.Id = newMessageId()
             msg.From = clientId
             msg.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+            if err := checkRecipients(&msg); err != nil {
+                sendError(client, ErrTooLarge, &msg, "%v", err)
+                continue
+            }
             
             handleMessage(roomId, client, &msg)
-            
+        
         case websocket.BinaryMessage:
-            // Handle binary audio data - forward to appropriate clients
-            if client.clientType == ClientTypeUser {
-                forwardAudioToAgents(roomId, clientId, data)
+            if !allowAudio(client, data) {
+                continue
             }
-            // If it's from an agent, forward to users
-            if client.clientType == ClientTypeAgent {
-                forwardAudioToUsers(roomId, clientId, data)
+            // Small PCM chunks are regrouped into fixed frames and paced per subscriber
+            if client.framer != nil {
+                for _, frame := range client.framer.push(data) {
+                    routeAudio(roomId, client, frame, true)
+                }
+                continue
             }
-            
+            routeAudio(roomId, client, data, false)
+        
         default:
-            log.Printf("Unknown message type: %d", messageType)
+            logAt("warn", roomId, clientId, "Unknown message type: %d", messageType)
         }
     }
     
-    // Remove client on disconnect
-    removeClientFromRoom(roomId, client)
-    notifyClientLeft(roomId, client)
-    
-    log.Printf("Client %s left room: %s", clientId, roomId)
-    conn.Close()
+    // Remove client on disconnect, draining first so no forwarding path can
+    // queue more data for it while it is being taken out of the room
+    client.setState(ClientDraining)
+    client.stopAudioPacer()
+    stopTranscription(client)
+    client.mu.Lock()
+    stopSpeaking(client, nil)
+    client.mu.Unlock()
+    closeClientChannels(roomId, client)
+    leaveConsult(client)
+    switch {
+    case client.isSuperseded():
+        logAt("info", roomId, clientId, "Connection replaced by a newer one")
+    case holdSession(roomId, client, readErr, leftBecause):
+        logAt("info", roomId, clientId, "Client dropped (%s), held for resume", leftBecause)
+    default:
+        removeClientFromRoom(roomId, client)
+        notifyClientLeft(roomId, client, leftBecause)
+        logAt("info", roomId, clientId, "Client left room (%s)", leftBecause)
+    }
+    client.stopWriter()
+    if leftBecause == "timeout" {
+        closeConnection(conn, CloseIdleTimeout, "") // In case the client still listens
+    } else {
+        conn.Close()
+    }
 }
 
-func forwardAudioToAgents(roomId string, fromClientId string, audioData []byte) {
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
+func routeAudio(roomId string, client *Client, data []byte, paced bool) {
+    audioBytesTotal.add(float64(len(data)), client.labels)
+    meterAudio(client, data)
+    // A caller keying in a card number (securecapture.go) is heard by nobody
+    if client.clientType == ClientTypeUser && capturing(roomId) {
+        traceAudio(roomId, client, data, paced, "secure")
+        return
+    }
+    // Paused recordings (recording.go) transcribe and sample nothing
+    if !audioPaused(roomId) {
+        transcribe(client, data)
+        sampleVoice(client, data)
+    }
+    detectMachine(client, data)
+    // Audio diverted into a private channel never reaches the rest of the room
+    if forwardChannelAudio(roomId, client, data, paced) {
+        traceAudio(roomId, client, data, paced, "channel")
+        return
+    }
+    // So is audio on a consult bridge, see consult.go
+    if forwardConsultAudio(roomId, client, data, paced) {
+        traceAudio(roomId, client, data, paced, "consult")
+        return
+    }
     
+    if !hasPermission(client, PermBroadcastAudio) {
+        traceAudio(roomId, client, data, paced, "denied")
+        return
+    }
+    traceAudio(roomId, client, data, paced, "room")
+    
+    // Handle binary audio data - forward to appropriate clients
+    if client.clientType == ClientTypeUser {
+        noteCallerAudio(client, data)
+        forwardAudioToAgents(roomId, client.clientId, data, paced)
+    }
+    // If it's from an agent, forward to users
+    if client.clientType == ClientTypeAgent {
+        noteAgentAudio(roomId)
+        forwardAudioToUsers(roomId, client.clientId, markAgentAudio(client, data), paced)
+    }
+}
+
+func forwardAudioToAgents(roomId string, fromClientId string, audioData []byte, paced bool) {
+    room, _, agents := roomMembers(roomId)
     if room == nil {
         return
     }
     
     // Forward audio to all agents in the room
-    for _, client := range room.Agents {
-        if client.clientId != fromClientId {
-            err := client.conn.WriteMessage(websocket.BinaryMessage, audioData)
+    paused := audioPaused(roomId)
+    for _, client := range agents {
+        if client.clientId != fromClientId && hearsAudio(client, paused) {
+            err := sendAudio(client, audioData, paced)
             if err != nil {
-                log.Printf("Audio forward error to agent %s: %v", client.clientId, err)
+                logAt("warn", roomId, client.clientId, "Audio forward error to agent: %v", err)
             }
         }
     }
 }
 
-func forwardAudioToUsers(roomId string, fromClientId string, audioData []byte) {
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
-    
+func forwardAudioToUsers(roomId string, fromClientId string, audioData []byte, paced bool) {
+    room, users, _ := roomMembers(roomId)
     if room == nil {
         return
     }
     
     // Forward audio to all users in the room
-    for _, client := range room.Users {
-        if client.clientId != fromClientId {
-            err := client.conn.WriteMessage(websocket.BinaryMessage, audioData)
+    paused := audioPaused(roomId)
+    for _, client := range users {
+        if client.clientId != fromClientId && hearsAudio(client, paused) {
+            err := sendAudio(client, audioData, paced)
             if err != nil {
-                log.Printf("Audio forward error to user %s: %v", client.clientId, err)
+                logAt("warn", roomId, client.clientId, "Audio forward error to user: %v", err)
             }
         }
     }
 }
 
-func addClientToRoom(roomId string, client *Client) {
+// addClientToRoom refuses the client when the room is at capacity or its ID
+// is taken and the room rejects duplicates, and returns the participant it
+// replaced when the room replaces them.
+func addClientToRoom(roomId string, client *Client, template string) (*Client, error) {
     roomsMu.Lock()
     defer roomsMu.Unlock()
     
+    // The first participant decides which tenant and template the room belongs to
+    if rooms[roomId] == nil && isDraining() {
+        return nil, newCodedError(ErrUnavailable, "server is draining")
+    }
     if rooms[roomId] == nil {
         rooms[roomId] = &RoomInfo{
-            RoomId:    roomId,
-            Users:     make(map[string]*Client),
-            Agents:    make(map[string]*Client),
-            CreatedAt: time.Now().UnixNano() / int64(time.Millisecond),
+            RoomId:     roomId,
+            Users:      make(map[string]*Client),
+            Agents:     make(map[string]*Client),
+            Channels:   make(map[string]*Channel),
+            Tenant:     client.tenant,
+            Template:   template,
+            Locale:     roomLocale(client.locale, template),
+            CreatedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+            KGSnapshot: currentKGSnapshot(),
+        }
+        assignVariants(rooms[roomId])
+        rooms[roomId].rate = newRoomRate()
+        rooms[roomId].cdr = cdrFor(rooms[roomId])
+        rooms[roomId].labels = metricLabelsFor(client.tenant, template)
+        recordRoomCreated(rooms[roomId])
+        created := map[string]interface{}{"template": template}
+        if rooms[roomId].KGSnapshot != "" {
+            created["kgSnapshot"] = rooms[roomId].KGSnapshot
         }
+        if rooms[roomId].Variants != nil {
+            created["variants"] = rooms[roomId].Variants
+        }
+        emitEvent("room_created", rooms[roomId], created)
+    }
+    
+    room := rooms[roomId]
+    replaced, err := claimClientId(room, client)
+    if err != nil {
+        return nil, err
+    }
+    if replaced == nil && cfg.MaxRoomParticipants > 0 && len(room.Users)+len(room.Agents)+len(room.held) >= cfg.MaxRoomParticipants {
+        return nil, newCodedError(ErrRoomFull, "room is full")
     }
     
     if client.clientType == ClientTypeAgent {
-        rooms[roomId].Agents[client.clientId] = client
+        room.Agents[client.clientId] = client
     } else {
-        rooms[roomId].Users[client.clientId] = client
+        room.Users[client.clientId] = client
     }
+    delete(room.Departed, client.clientId)
+    noteQueueJoin(room, client)
+    noteAgentPresence(room, client, true)
+    client.labels = room.labels
+    connectionsTotal.inc(client.labels, string(client.clientType))
+    recordParticipant(room, client, true)
+    emitEvent("participant_joined", room, participantEvent(client))
+    return replaced, nil
 }
 
-func removeClientFromRoom(roomId string, client *Client) {
-    roomsMu.Lock()
-    defer roomsMu.Unlock()
+// roomMembers snapshots a room's participants so callers can fan out without
+// holding roomsMu and without racing joins and leaves on the maps.
+func roomMembers(roomId string) (*RoomInfo, []*Client, []*Client) {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
     
     room := rooms[roomId]
     if room == nil {
-        return
+        return nil, nil, nil
     }
     
-    if client.clientType == ClientTypeAgent {
-        delete(room.Agents, client.clientId)
-    } else {
-        delete(room.Users, client.clientId)
+    users := make([]*Client, 0, len(room.Users))
+    for _, client := range room.Users {
+        users = append(users, client)
+    }
+    agents := make([]*Client, 0, len(room.Agents))
+    for _, client := range room.Agents {
+        agents = append(agents, client)
+    }
+    return room, users, agents
+}
+
+func findClient(roomId string, clientId string) *Client {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    
+    room := rooms[roomId]
+    if room == nil {
+        return nil
+    }
+    if client, ok := room.Users[clientId]; ok {
+        return client
+    }
+    return room.Agents[clientId]
+}
+
+func removeClientFromRoom(roomId string, client *Client) {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return
     }
+    leaveRoom(room, client)
     
-    // Clean up empty rooms
-    if len(room.Users) == 0 && len(room.Agents) == 0 {
+    // Clean up empty rooms, unless someone dropped may still come back. The
+    // CDR is queued before the lock goes, ahead of any disposition for the
+    // call, which finds the room gone and goes to the store
+    closing := len(room.Users) == 0 && len(room.Agents) == 0 && len(room.held) == 0
+    var shadows []*Client
+    if closing {
         delete(rooms, roomId)
+        noteDrainProgress(len(rooms))
+        recordRoomClosed(room)
+        shadows = takeShadows(room)
+    }
+    roomsMu.Unlock()
+    if !closing {
+        return
     }
+    
+    // Out of rooms nothing changes the room any more, the rest needs no lock
+    rollupCall(room, time.Now().UnixNano()/int64(time.Millisecond))
+    callsTotal.inc(room.labels)
+    callDuration.observe(time.Since(time.Unix(0, room.CreatedAt*int64(time.Millisecond))).Seconds(), room.labels)
+    emitEvent("room_closed", room, nil)
+    closeShadows(shadows)
+    scheduleSegmentation(roomId)
+}
+
+// leaveRoom undoes a client's join but leaves the room open even when it
+// empties. Callers hold roomsMu.
+func leaveRoom(room *RoomInfo, client *Client) {
+    detachClient(room, client)
+    markDeparted(room, client.clientId)
+    noteQueueLeave(room, client)
+    noteHoldLeave(room)
+    noteOutboundLeave(client)
+    noteCallEnded(room, client)
+    noteCaptureLeave(room, client)
+    noteAgentPresence(room, client, false)
+    recordParticipant(room, client, false)
+    emitEvent("participant_left", room, participantEvent(client))
 }
 
 func handleMessage(roomId string, sender *Client, msg *Message) {
+    messagesTotal.inc(sender.labels, messageTypeLabel(msg.Type))
+    traceMessage(roomId, sender, msg)
+    
+    if isSystemMessageType(msg.Type) {
+        logAt("warn", roomId, sender.clientId, "Client tried to send reserved message type %s", msg.Type)
+        sendError(sender, ErrNotPermitted, msg, "message type %s is reserved for the server", msg.Type)
+        return
+    }
+    
+    if err := checkMessagePermission(sender, msg); err != nil {
+        sendError(sender, ErrNotPermitted, msg, "%v", err)
+        return
+    }
+    
+    // Private channel traffic bypasses room routing and history
+    if msg.Channel != "" {
+        sendToChannel(roomId, sender, msg)
+        return
+    }
+    
+    // Verification carries security answers and passcodes, it stays out of history
+    if isVerificationMessage(msg.Type) {
+        handleVerification(roomId, sender, msg)
+        return
+    }
+    if isSpeakerMessage(msg.Type) {
+        handleSpeakerMessage(roomId, sender, msg)
+        return
+    }
+    // Consults span two rooms, neither room's history keeps them
+    if isConsultMessage(msg.Type) {
+        handleConsultMessage(roomId, sender, msg)
+        return
+    }
+    
+    if msg.Type == "integration_status" {
+        handleIntegrationStatus(roomId, sender, msg)
+        return
+    }
+    if msg.Type == "usage" {
+        handleUsage(roomId, sender, msg)
+        return
+    }
+    if msg.Type == "callback_request" {
+        handleCallbackRequest(roomId, sender, msg)
+        return
+    }
+    if msg.Type == "disposition" {
+        handleDisposition(roomId, sender, msg)
+        return
+    }
+    if msg.Type == "kg_facts" {
+        handleKGFacts(roomId, sender, msg)
+        return
+    }
+    // Keypad input may be a card number, it stays out of history
+    switch msg.Type {
+    case "dtmf":
+        handleDTMF(roomId, sender, msg)
+        return
+    case "secure_capture_start":
+        handleCaptureStart(roomId, sender, msg)
+        return
+    case "secure_capture_stop":
+        handleCaptureStop(roomId, sender, msg)
+        return
+    }
+    
+    if msg.Type == "assistant_final" {
+        if err := prepareAssistantFinal(sender, msg); err != nil {
+            sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+            return
+        }
+        if !moderateAssistantFinal(roomId, sender, msg) {
+            return
+        }
+        stampKGVersion(roomId, sender, msg)
+        cacheAnswer(roomId, sender, msg)
+        noteKnowledgeGap(roomId, msg)
+    }
+    filterChatProfanity(roomId, sender, msg)
+    pauseForIntent(roomId, sender, msg)
+    
+    recordHistory(roomId, msg)
+    observeForAnalytics(roomId, sender, msg)
+    
     switch msg.Type {
     case "broadcast":
         broadcastToRoom(roomId, sender, msg)
     case "selective":
-        selectiveSend(roomId, sender, msg)
+        sendDeliveryReport(sender, msg, selectiveSend(roomId, sender, msg))
     case "agent_only":
         sendToAgents(roomId, sender, msg)
     case "user_only":
         sendToUsers(roomId, sender, msg)
     case "metadata":
         updateClientMetadata(sender, msg)
+    case "typing", "reaction", "read":
+        handleEphemeral(roomId, sender, msg)
+    case "file_receipt":
+        handleFileReceipt(roomId, sender, msg)
+    case "channel_open":
+        handleChannelOpen(roomId, sender, msg)
+    case "channel_close":
+        handleChannelClose(roomId, sender, msg)
+    case "channel_audio":
+        handleChannelAudio(roomId, sender, msg)
+    case "kick":
+        handleKick(roomId, sender, msg)
+    case "recording_start", "recording_stop":
+        handleRecordingControl(roomId, sender, msg)
+    case "recording_pause", "recording_resume":
+        handleRecordingPause(roomId, sender, msg)
+    case "profile_update":
+        handleProfileUpdate(roomId, sender, msg)
+    case "speak", "speak_stop":
+        handleSpeak(roomId, sender, msg)
+    case "speak_stream":
+        handleSpeakStream(roomId, sender, msg)
+    case "answer_lookup":
+        handleAnswerLookup(roomId, sender, msg)
+    case "assistant_final":
+        handleAssistantFinal(roomId, sender, msg)
     default:
         // Default behavior is broadcast
         broadcastToRoom(roomId, sender, msg)
@@ -251,82 +799,87 @@ func handleMessage(roomId string, sender *Client, msg *Message) {
 }
 
 func broadcastToRoom(roomId string, sender *Client, msg *Message) {
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
-    
+    defer journalEvent(roomId, sender, msg, "")()
+    room, users, agents := roomMembers(roomId)
     if room == nil {
         return
     }
     
     // Send to all users except sender
-    for _, client := range room.Users {
+    for _, client := range users {
         if client != sender {
             sendMessageToClient(client, msg)
         }
     }
     
     // Send to all agents except sender
-    for _, client := range room.Agents {
+    for _, client := range agents {
         if client != sender {
             sendMessageToClient(client, msg)
         }
     }
+    for _, client := range roomShadows(roomId) {
+        sendMessageToClient(client, msg)
+    }
 }
 
-func selectiveSend(roomId string, sender *Client, msg *Message) {
+// selectiveSend delivers msg to each listed target and reports the outcome
+// per target. An empty target list falls back to a broadcast.
+func selectiveSend(roomId string, sender *Client, msg *Message) DeliveryReport {
+    report := DeliveryReport{Delivered: []string{}, Queued: []string{}, Unknown: []string{}, Offline: []string{}, Failed: []string{}}
     if len(msg.To) == 0 {
         broadcastToRoom(roomId, sender, msg)
-        return
-    }
-    
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
-    
-    if room == nil {
-        return
+        return report
     }
+    defer journalEvent(roomId, sender, msg, "")()
     
     // Send to specific clients
     for _, targetId := range msg.To {
-        // Check users first
-        if client, exists := room.Users[targetId]; exists {
-            sendMessageToClient(client, msg)
-        }
-        // Check agents
-        if client, exists := room.Agents[targetId]; exists {
-            sendMessageToClient(client, msg)
+        client, offline := lookupTarget(roomId, targetId)
+        switch {
+        case client != nil:
+            if err := writeJSON(client, msg); err != nil {
+                logAt("warn", roomId, client.clientId, "Write error: %v", err)
+                report.Failed = append(report.Failed, targetId)
+            } else {
+                report.Delivered = append(report.Delivered, targetId)
+            }
+        case offline && queueOffline(roomId, targetId, msg):
+            report.Queued = append(report.Queued, targetId)
+        case offline:
+            report.Offline = append(report.Offline, targetId)
+        default:
+            report.Unknown = append(report.Unknown, targetId)
         }
     }
+    return report
 }
 
 func sendToAgents(roomId string, sender *Client, msg *Message) {
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
-    
+    defer journalEvent(roomId, sender, msg, ClientTypeAgent)()
+    room, _, agents := roomMembers(roomId)
     if room == nil {
         return
     }
     
-    for _, client := range room.Agents {
+    for _, client := range agents {
         if client != sender {
             sendMessageToClient(client, msg)
         }
     }
+    for _, client := range roomShadows(roomId) {
+        sendMessageToClient(client, msg)
+    }
 }
 
 func sendToUsers(roomId string, sender *Client, msg *Message) {
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
-    
+    defer journalEvent(roomId, sender, msg, ClientTypeUser)()
+    room, users, _ := roomMembers(roomId)
     if room == nil {
         return
     }
     
-    for _, client := range room.Users {
+    for _, client := range users {
         if client != sender {
             sendMessageToClient(client, msg)
         }
@@ -334,41 +887,57 @@ func sendToUsers(roomId string, sender *Client, msg *Message) {
 }
 
 func sendMessageToClient(client *Client, msg *Message) {
-    err := client.conn.WriteJSON(msg)
+    err := writeJSON(client, msg)
     if err != nil {
-        log.Printf("Write error to client %s: %v", client.clientId, err)
+        logAt("warn", client.room, client.clientId, "Write error: %v", err)
     }
 }
 
-func sendWelcomeMessage(client *Client) {
-    roomsMu.RLock()
-    room := rooms[client.room]
-    roomsMu.RUnlock()
-    
+func sendWelcomeMessage(client *Client, seq uint64) {
+    room, roomUsers, roomAgents := roomMembers(client.room)
     if room == nil {
         return
     }
     
     // Prepare room participants info
-    users := make([]string, 0, len(room.Users))
-    agents := make([]string, 0, len(room.Agents))
+    users := make([]string, 0, len(roomUsers))
+    agents := make([]string, 0, len(roomAgents))
+    participants := make([]map[string]interface{}, 0, len(roomUsers)+len(roomAgents))
     
-    for id := range room.Users {
-        users = append(users, id)
+    for _, member := range roomUsers {
+        users = append(users, member.clientId)
+        participants = append(participants, participantInfo(member))
     }
-    for id := range room.Agents {
-        agents = append(agents, id)
+    for _, member := range roomAgents {
+        agents = append(agents, member.clientId)
+        participants = append(participants, participantInfo(member))
     }
     
+    client.mu.Lock()
+    resumeToken := client.resumeToken
+    client.mu.Unlock()
+    
     welcomeMsg := &Message{
         Type: "welcome",
-        From: "system",
+        From: SystemSender,
         Data: map[string]interface{}{
             "roomId": client.room,
             "clientId": client.clientId,
             "clientType": client.clientType,
+            "role": client.role,
+            "displayName": displayNameOf(client),
+            "avatar": avatarOf(client),
             "users": users,
             "agents": agents,
+            "participants": participants,
+            "metadata": snapshotMetadata(client),
+            "protocolVersion": ProtocolVersion,
+            "capabilities": serverCapabilities(),
+            "room": roomConfig(room),
+            "recording": recordingStatus(room),
+            "ttsVoice": ttsVoiceFor(room),
+            "resumeToken": resumeToken,
+            "seq": seq,
         },
         Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
     }
@@ -379,24 +948,24 @@ func sendWelcomeMessage(client *Client) {
 func notifyClientJoined(roomId string, newClient *Client) {
     msg := &Message{
         Type: "client_joined",
-        From: "system",
-        Data: map[string]interface{}{
-            "clientId": newClient.clientId,
-            "clientType": newClient.clientType,
-        },
+        From: SystemSender,
+        Data: participantInfo(newClient),
         Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
     }
     
     broadcastToRoom(roomId, newClient, msg)
 }
 
-func notifyClientLeft(roomId string, leftClient *Client) {
+// notifyClientLeft tells the room, reason being "closed" or "timeout" for a
+// connection that went silent.
+func notifyClientLeft(roomId string, leftClient *Client, reason string) {
     msg := &Message{
         Type: "client_left",
-        From: "system",
+        From: SystemSender,
         Data: map[string]interface{}{
             "clientId": leftClient.clientId,
             "clientType": leftClient.clientType,
+            "reason": reason,
         },
         Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
     }
@@ -405,11 +974,28 @@ func notifyClientLeft(roomId string, leftClient *Client) {
 }
 
 func updateClientMetadata(client *Client, msg *Message) {
-    if metadata, ok := msg.Data.(map[string]interface{}); ok {
-        for key, value := range metadata {
+    metadata, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        sendError(client, ErrInvalidMessage, msg, "metadata data must be an object")
+        return
+    }
+    
+    if err := validateMetadataUpdate(client, metadata); err != nil {
+        sendError(client, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    
+    client.mu.Lock()
+    for key, value := range metadata {
+        if value == nil {
+            delete(client.metadata, key)
+        } else {
             client.metadata[key] = value
         }
     }
+    client.mu.Unlock()
+    
+    notifyMetadataUpdated(client, metadata)
 }
 
 // REST API Handlers
@@ -426,6 +1012,15 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
         return
     }
     
+    identity, err := registryIdentity(r, newServer)
+    if err != nil {
+        log.Printf("Registration of %s:%d refused: %v", newServer.Address, newServer.Port, err)
+        http.Error(w, err.Error(), http.StatusUnauthorized)
+        return
+    }
+    newServer.Identity = identity
+    newServer.LastSeen = time.Now().UnixNano() / int64(time.Millisecond)
+    
     serversMu.Lock()
     defer serversMu.Unlock()
     
@@ -441,17 +1036,114 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(newServer)
 }
 
+func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    var beat heartbeat
+    if err := json.NewDecoder(r.Body).Decode(&beat); err != nil {
+        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        return
+    }
+    
+    identity, err := registryIdentity(r, beat.ServerInfo)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnauthorized)
+        return
+    }
+    
+    serversMu.Lock()
+    defer serversMu.Unlock()
+    
+    for i := range servers {
+        if servers[i].Address == beat.Address && servers[i].Port == beat.Port {
+            // Only the identity that registered a server may keep it alive
+            if servers[i].Identity != identity {
+                http.Error(w, "Identity mismatch", http.StatusForbidden)
+                return
+            }
+            servers[i].LastSeen = time.Now().UnixNano() / int64(time.Millisecond)
+            servers[i].Draining = beat.Draining
+            servers[i].TLS = beat.TLS
+            noteCheckpoint(servers[i], beat.Rooms) // See failover.go
+            json.NewEncoder(w).Encode(servers[i])
+            return
+        }
+    }
+    
+    http.Error(w, "Not registered", http.StatusNotFound)
+}
+
 func handleAllocate(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    roomId := query.Get("room")
+    clientId := query.Get("clientId")
+    clientType := ClientType(query.Get("type"))
+    tenant := query.Get("tenant")
+    role := query.Get("role")
+    if clientType != ClientTypeAgent {
+        clientType = ClientTypeUser
+    }
+    
+    if ticketsEnabled() {
+        if roomId == "" || clientId == "" {
+            http.Error(w, "room and clientId query params required", http.StatusBadRequest)
+            return
+        }
+        // Only trusted backends may mint tickets claiming a tenant, a role or an agent
+        if (tenant != "" || role != "" || clientType == ClientTypeAgent) && !isAdminRequest(r) {
+            http.Error(w, "tenant, role and agent claims require admin credentials", http.StatusForbidden)
+            return
+        }
+    }
+    
     serversMu.Lock()
     defer serversMu.Unlock()
     
-    if len(servers) == 0 {
+    // Draining servers finish their calls but take no new ones
+    candidates := make([]ServerInfo, 0, len(servers))
+    for _, server := range servers {
+        if !server.Draining {
+            candidates = append(candidates, server)
+        }
+    }
+    if len(candidates) == 0 {
         http.Error(w, "No servers available", http.StatusServiceUnavailable)
         return
     }
     
-    selected := servers[rnd.Intn(len(servers))]
-    json.NewEncoder(w).Encode(selected)
+    selected := candidates[rnd.Intn(len(candidates))]
+    // A room recreated after its server died is wherever it failed over to
+    if server, ok := failedOverTo(roomId); ok {
+        for _, candidate := range candidates {
+            if serverKey(candidate) == serverKey(server) {
+                selected = candidate
+            }
+        }
+    }
+    if !ticketsEnabled() {
+        json.NewEncoder(w).Encode(selected)
+        return
+    }
+    
+    expiry := time.Now().Add(cfg.TicketTTL).Unix()
+    ticket := signTicket(Ticket{
+        RoomId:     roomId,
+        ClientId:   clientId,
+        ClientType: clientType,
+        Tenant:     tenant,
+        Role:       role,
+        Server:     fmt.Sprintf("%s:%d", selected.Address, selected.Port),
+        Expiry:     expiry,
+    })
+    
+    json.NewEncoder(w).Encode(Allocation{
+        ServerInfo: selected,
+        Ticket:     ticket,
+        ExpiresAt:  expiry,
+    })
 }
 
 func handleList(w http.ResponseWriter, r *http.Request) {
@@ -462,35 +1154,71 @@ func handleList(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleRoomInfo(w http.ResponseWriter, r *http.Request) {
-    roomId := strings.TrimPrefix(r.URL.Path, "/room/")
+    roomId, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/room/"), "/")
     if roomId == "" {
         http.Error(w, "Room ID required", http.StatusBadRequest)
         return
     }
     
-    roomsMu.RLock()
-    room := rooms[roomId]
-    roomsMu.RUnlock()
+    switch resource {
+    case "":
+    case "messages":
+        handleRoomMessages(w, r, roomId)
+        return
+    case "files":
+        handleRoomFileUpload(w, r, roomId)
+        return
+    case "summary", "segments":
+        handleRoomSummary(w, r, roomId, resource)
+        return
+    case "verification":
+        handleRoomVerification(w, r, roomId)
+        return
+    case "quality":
+        handleRoomQuality(w, r, roomId)
+        return
+    case "stats":
+        handleRoomStats(w, r, roomId)
+        return
+    default:
+        if messageId, ok := strings.CutPrefix(resource, "clips/"); ok {
+            handleRoomClip(w, r, roomId, messageId)
+            return
+        }
+        http.NotFound(w, r)
+        return
+    }
     
-    if room == nil {
+    scope, ok := readScope(w, r, "/room/{id}", !cfg.RoomAPIAuth)
+    if !ok {
+        return
+    }
+    room, roomUsers, roomAgents := roomMembers(roomId)
+    if room == nil || !scope.allows(room.Tenant) {
         http.Error(w, "Room not found", http.StatusNotFound)
         return
     }
     
-    users := make([]map[string]interface{}, 0, len(room.Users))
-    agents := make([]map[string]interface{}, 0, len(room.Agents))
+    users := make([]map[string]interface{}, 0, len(roomUsers))
+    agents := make([]map[string]interface{}, 0, len(roomAgents))
     
-    for id, client := range room.Users {
+    for _, client := range roomUsers {
         users = append(users, map[string]interface{}{
-            "clientId": id,
-            "metadata": client.metadata,
+            "clientId":    client.clientId,
+            "displayName": displayNameOf(client),
+            "avatar":      avatarOf(client),
+            "state":       client.currentState().String(),
+            "metadata":    snapshotMetadata(client),
         })
     }
     
-    for id, client := range room.Agents {
+    for _, client := range roomAgents {
         agents = append(agents, map[string]interface{}{
-            "clientId": id,
-            "metadata": client.metadata,
+            "clientId":    client.clientId,
+            "displayName": displayNameOf(client),
+            "avatar":      avatarOf(client),
+            "state":       client.currentState().String(),
+            "metadata":    snapshotMetadata(client),
         })
     }
     
@@ -498,47 +1226,315 @@ func handleRoomInfo(w http.ResponseWriter, r *http.Request) {
         "roomId":    roomId,
         "users":     users,
         "agents":    agents,
+        "tenant":    room.Tenant,
+        "template":  room.Template,
         "createdAt": room.CreatedAt,
     }
+    if partition := kgPartitionFor(room); partition != "" {
+        response["kgPartition"] = partition
+    }
+    if room.KGSnapshot != "" {
+        response["kgSnapshot"] = room.KGSnapshot
+    }
+    response["usage"] = roomUsageView(room)
+    if fallbacks := roomFallbacks(room); fallbacks != nil {
+        response["fallbacks"] = fallbacks
+    }
     
     json.NewEncoder(w).Encode(response)
 }
 
+// GET /rooms takes /v1/rooms' filters, see registryapi.go
 func handleRoomList(w http.ResponseWriter, r *http.Request) {
-    roomsMu.RLock()
-    defer roomsMu.RUnlock()
-    
-    roomList := make([]map[string]interface{}, 0, len(rooms))
-    
-    for roomId, room := range rooms {
-        roomList = append(roomList, map[string]interface{}{
-            "roomId":     roomId,
-            "userCount":  len(room.Users),
-            "agentCount": len(room.Agents),
-            "createdAt":  room.CreatedAt,
-        })
+    scope, ok := readScope(w, r, "/rooms", !cfg.RoomAPIAuth)
+    if !ok {
+        return
+    }
+    items, page, err := listRooms(r.URL.Query(), scope)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if r.URL.Query().Get("limit") == "" {
+        page.Limit = len(items)
     }
     
+    roomList, next := pageOf(items, page)
+    w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+    if next != "" {
+        w.Header().Set("X-Next-After", next)
+    }
     json.NewEncoder(w).Encode(roomList)
 }
 
+// roomListEntry is a room as /rooms lists it. Callers hold roomsMu.
+func roomListEntry(room *RoomInfo) map[string]interface{} {
+    return map[string]interface{}{
+        "roomId":     room.RoomId,
+        "userCount":  len(room.Users),
+        "agentCount": len(room.Agents),
+        "tenant":     room.Tenant,
+        "template":   room.Template,
+        "createdAt":  room.CreatedAt,
+    }
+}
+
 func main() {
-    http.HandleFunc("/ws", handleWebSocket)
-    http.HandleFunc("/register", handleRegister)
-    http.HandleFunc("/allocate", handleAllocate)
-    http.HandleFunc("/list", handleList)
-    http.HandleFunc("/room/", handleRoomInfo)
-    http.HandleFunc("/rooms", handleRoomList)
-    
-    log.Println("Enhanced Server + Registry running on :8080")
+    log.SetOutput(io.MultiWriter(os.Stderr, logTail))
+    loadConfig()
+    
+    if err := checkRole(); err != nil {
+        log.Fatal(err)
+    }
+    if err := checkSendQueue(); err != nil {
+        log.Fatal(err)
+    }
+    if err := checkCompression(); err != nil {
+        log.Fatal(err)
+    }
+    if cfg.DurableWriters < 1 {
+        log.Fatal("-durable-writers must be at least 1")
+    }
+    if cfg.ProxyHops < 1 {
+        log.Fatal("-proxy-hops must be at least 1")
+    }
+    if err := checkTranscriptBus(); err != nil {
+        log.Fatal(err)
+    }
+    if err := checkMessageLimits(); err != nil {
+        log.Fatal(err)
+    }
+    if err := checkRateLimits(); err != nil {
+        log.Fatal(err)
+    }
+    if err := loadObserverTokens(); err != nil {
+        log.Fatalf("Observer tokens: %v", err)
+    }
+    if err := loadMessageCatalog(); err != nil {
+        log.Fatalf("Message catalog: %v", err)
+    }
+    
+    switch cfg.Role {
+    case RoleAll, RoleMedia:
+        startMedia()
+    case RoleWorker:
+        startWorker()
+    }
+    if cfg.Role == RoleAll || cfg.Role == RoleRegistry {
+        startRegistryListener()
+        startFailover()
+    }
+    startRegistration()
+    
+    for _, route := range apiRoutes() {
+        if servesRoute(route.Pattern) {
+            http.HandleFunc(route.Pattern, route.Handler)
+        }
+    }
+    if cfg.APIDocs {
+        http.HandleFunc("/docs", handleAPIDocs)
+    }
+    if cfg.DemoPage {
+        http.Handle("/demo/", demoPageHandler())
+    }
+    http.Handle("/v1/", http.StripPrefix("/v1", http.DefaultServeMux))
+    
+    scheme := "http"
+    if tlsEnabled() {
+        scheme = "https"
+    }
+    log.Printf("Enhanced Server + Registry running on %s (%s) as -role %s", cfg.Addr, scheme, cfg.Role)
+    if cfg.Role == RoleAll {
+        logEndpoints()
+    } else {
+        logRoleEndpoints()
+    }
+    
+    log.Fatal(serve())
+}
+
+// startMedia loads what rooms need and starts their background jobs.
+func startMedia() {
+    upgrader.EnableCompression = cfg.WSCompression
+    startTranscriptBus()
+    
+    if cfg.MetadataSchemaFile != "" {
+        if err := loadMetadataSchemas(cfg.MetadataSchemaFile); err != nil {
+            log.Fatalf("Loading metadata schemas: %v", err)
+        }
+    }
+    
+    if cfg.PermissionsFile != "" {
+        if err := loadRolePermissions(cfg.PermissionsFile); err != nil {
+            log.Fatalf("Loading permissions: %v", err)
+        }
+    }
+    
+    if err := guard.setFilter(IPFilter{Allow: cfg.IPAllow, Deny: cfg.IPDeny}); err != nil {
+        log.Fatalf("IP filter: %v", err)
+    }
+    if err := loadOrigins(); err != nil {
+        log.Fatalf("Allowed origins: %v", err)
+    }
+    
+    switch cfg.DemoAgent {
+    case "", DemoAgentEcho, DemoAgentPhrases:
+    default:
+        log.Fatalf("Unknown -demo-agent mode %q", cfg.DemoAgent)
+    }
+    if err := loadDemoPhrases(cfg.DemoAgentPhrases); err != nil {
+        log.Fatalf("Loading demo agent phrases: %v", err)
+    }
+    
+    if err := startPersistence(); err != nil {
+        log.Fatalf("Storage: %v", err)
+    }
+    
+    if err := startSearchIndexer(); err != nil {
+        log.Fatalf("Search indexer: %v", err)
+    }
+    
+    if err := startOutbox(); err != nil {
+        log.Fatalf("Outbox: %v", err)
+    }
+    
+    loadSTTProviders()
+    if cfg.STTVocabularyFile != "" {
+        if err := loadSTTVocabulary(cfg.STTVocabularyFile); err != nil {
+            log.Fatalf("Loading STT vocabulary: %v", err)
+        }
+    }
+    if err := loadTTSProviders(); err != nil {
+        log.Fatalf("TTS: %v", err)
+    }
+    if cfg.TTSProvider != "" && ttsProviders[cfg.TTSProvider] == nil {
+        log.Fatalf("TTS provider %q is not configured", cfg.TTSProvider)
+    }
+    if cfg.STTProvider != "" && cfg.STTProvider != "none" && sttProviders[cfg.STTProvider] == nil {
+        log.Fatalf("STT provider %q is not configured", cfg.STTProvider)
+    }
+    if err := loadFallbacks(); err != nil {
+        log.Fatalf("Fallbacks: %v", err)
+    }
+    if err := loadHoldAssets(); err != nil {
+        log.Fatalf("Hold assets: %v", err)
+    }
+    if err := loadRoomQuotas(); err != nil {
+        log.Fatalf("Room quotas: %v", err)
+    }
+    if err := loadCosts(); err != nil {
+        log.Fatalf("Costs: %v", err)
+    }
+    if err := loadModeration(); err != nil {
+        log.Fatalf("Moderation: %v", err)
+    }
+    loadSegmenter()
+    loadSpeakers()
+    loadVerification()
+    if err := loadWorkers(); err != nil {
+        log.Fatalf("Agent workers: %v", err)
+    }
+    if err := loadPool(); err != nil {
+        log.Fatalf("Agent pool: %v", err)
+    }
+    if err := loadTelephony(); err != nil {
+        log.Fatalf("Telephony: %v", err)
+    }
+    if telephonyProvider != nil {
+        resumeCampaigns()
+    }
+    if err := loadProfanity(); err != nil {
+        log.Fatalf("Profanity words: %v", err)
+    }
+    policies := []string{cfg.ProfanityPolicy}
+    for _, entry := range cfg.ProfanityTenantPolicies {
+        _, policy, _ := strings.Cut(entry, "=")
+        policies = append(policies, policy)
+    }
+    for _, policy := range policies {
+        if _, ok := parseProfanityPolicy(policy); !ok {
+            log.Fatalf("Unknown profanity policy %q, use off or log, mask and alert joined with +", policy)
+        }
+    }
+    
+    if err := openShadowLog(); err != nil {
+        log.Fatalf("Shadow log: %v", err)
+    }
+    if err := checkDuplicateIdPolicies(); err != nil {
+        log.Fatalf("Duplicate client IDs: %v", err)
+    }
+    
+    startHistoryJanitor()
+    startAnalyticsJob()
+    startOfflineQueueJanitor()
+    startJournalJanitor()
+    startTraceJanitor()
+    startIPGuardJanitor()
+    startQuotaEnforcer()
+    startCostAlerts()
+    startHoldPlayer()
+    startClipJanitor()
+}
+
+func logEndpoints() {
     log.Println("WebSocket endpoints:")
-    log.Println("  /ws?room=ROOM_ID&clientId=CLIENT_ID&type=user|agent")
+    log.Println("  /ws?room=ROOM_ID&clientId=CLIENT_ID&type=user|agent[&tenant=TENANT&template=TEMPLATE&role=ROLE&ticket=TICKET&metadata=JSON&displayName=NAME&avatar=URL]")
+    log.Println("    PCM sources may add audioFormat=pcm16&sampleRate=HZ[&channels=N] for frame coalescing")
     log.Println("REST API endpoints:")
-    log.Println("  GET  /rooms - List all active rooms")
+    log.Println("  GET  /rooms[?tenant=&template=&minParticipants=&createdAfter=&sort=&limit=&after=] - List active rooms")
     log.Println("  GET  /room/ROOM_ID - Get room information")
+    log.Println("  GET  /room/ROOM_ID/messages?before=&limit= - Page through chat history")
+    log.Println("  POST /room/ROOM_ID/files?clientId=CLIENT_ID&name=NAME - Share a file with the room")
+    log.Println("  GET  /room/ROOM_ID/summary - Call summary, topic segments and recordings (admin)")
+    log.Println("  POST /room/ROOM_ID/segments - Re-segment a call's transcript into topics (admin)")
+    log.Println("  GET  /room/ROOM_ID/verification - Caller identity verification state (admin)")
+    log.Println("  GET  /room/ROOM_ID/quality - Post-call quality scores (admin)")
+    log.Println("  GET  /room/ROOM_ID/stats - Live usage and estimated spend of a call (admin)")
+    log.Println("  GET  /room/ROOM_ID/clips/MESSAGE_ID - Audio of a final transcript, with -clips-dir (admin)")
+    log.Println("  GET  /files/FILE_ID - Download a shared file")
     log.Println("  POST /register - Register a server")
-    log.Println("  GET  /allocate - Get a random server")
+    log.Println("  POST /heartbeat - Refresh a registered server")
+    log.Println("  GET  /allocate[?room=ROOM_ID&clientId=CLIENT_ID&type=user|agent] - Get a random server (and a signed ticket)")
     log.Println("  GET  /list - List all servers")
-    
-    log.Fatal(http.ListenAndServe(":8080", nil))
+    log.Println("  GET  /v1/list[?region=&healthy=true&limit=&after=] - Page through registered servers")
+    log.Println("  GET  /v1/rooms[?tenant=&template=&minParticipants=&createdAfter=&sort=&limit=&after=] - Page through active rooms")
+    log.Println("  *    /v1/... - Version 1 of every other endpoint")
+    log.Println("  GET  /metrics - Prometheus metrics labelled by tenant")
+    log.Println("  GET  /protocol - AsyncAPI description of the WebSocket messages")
+    log.Println("  GET  /openapi.json - OpenAPI description of these endpoints (Swagger UI at /docs with -api-docs)")
+    if cfg.DemoPage {
+        log.Println("  GET  /demo/ - Browser demo call: microphone, live captions and agent chat")
+    }
+    log.Println("  POST /broadcast - Send an announcement to matching rooms (admin)")
+    log.Println("  POST /admin/rooms/close|move|refresh - Bulk room operations, with dryRun and a report (admin)")
+    log.Println("  POST /admin/rooms/migrate|import - Live room migration between servers (admin)")
+    log.Println("  GET|POST|DELETE /admin/drain - Drain the server for a restart, or cancel it (admin)")
+    log.Println("  GET  /search?q=TEXT[&tenant=&roomId=&kind=] - Search indexed conversations (admin)")
+    log.Println("  GET  /voices[?provider=&language=] - Voice catalog of the configured TTS providers")
+    log.Println("  GET|POST|PUT|DELETE /admin/stt/phrases/TENANT[/TERM] - Manage speech recognition phrase hints (admin)")
+    log.Println("  GET|POST|PUT|DELETE /admin/tts/lexicon/TENANT[/WRITTEN] - Manage the pronunciation lexicon (admin)")
+    log.Println("  GET  /analytics?granularity=hour|day[&from=&to=&tenant=&groupBy=&format=csv] - Call rollups (admin)")
+    log.Println("  GET  /analytics/agents?granularity=hour|day[&from=&to=&tenant=&agentId=&groupBy=&format=csv] - Agent performance (admin)")
+    log.Println("  GET  /admin/audit[?tenant=&roomId=&action=&subject=&from=&to=&limit=] - Security audit log (admin)")
+    log.Println("  POST /admin/watermark/detect - Trace WAV or pcm16 audio back to the call it was watermarked in (admin)")
+    log.Println("  GET|DELETE /admin/answers[?tenant=], POST /admin/answers/invalidate - Cached virtual agent answers (admin)")
+    log.Println("  GET /admin/experiments, GET|PUT|DELETE /admin/experiments/NAME, GET /admin/experiments/NAME/report - A/B experiments (admin)")
+    log.Println("  GET /admin/shadow?tenant=&roomId=&candidate=&limit= - Shadow agent answers beside production (admin)")
+    log.Println("  GET|POST|DELETE /admin/calls[/ID] - Outbound calls through -telephony-url (admin)")
+    log.Println("  GET|POST|DELETE /admin/agents[/ID] - Agent workers, their capacity and load (admin)")
+    log.Println("  GET|POST /admin/wrapups[/ID] - Open wrap-ups and their dispositions (admin)")
+    log.Println("  GET|POST /admin/campaigns[/ID[/start|pause|cancel|targets]] - Outbound dialing campaigns (admin)")
+    log.Println("  POST /telephony/status/ID - Call progress from the voice gateway")
+    log.Println("  GET|POST /admin/callbacks[/claim|/ID[/result]] - Callback queue for agents and the outbound dialer (admin)")
+    log.Println("  GET|POST|DELETE /admin/knowledge/gaps[/ID[/approve|/dismiss]], /admin/knowledge/faq[/ID] - Knowledge gap curation and FAQ entries (admin)")
+    log.Println("  GET|POST /admin/knowledge/facts[/ID[/approve|/reject]] - Extracted facts awaiting review before they reach the graph (admin)")
+    log.Println("  GET|POST /admin/knowledge/snapshot - Knowledge graph snapshot new rooms are pinned to (admin)")
+    log.Println("  GET  /admin/breakers - Circuit breaker states, POST /admin/breakers/NAME/reset closes one (admin)")
+    log.Println("  GET|DELETE /admin/speakers/TENANT/CUSTOMER_ID - Voiceprint consent, DELETE withdraws it and erases the voiceprint (admin)")
+    log.Println("  GET|PUT|DELETE /admin/metadata-schema/TENANT - Manage metadata schemas (admin)")
+    log.Println("  GET|PUT|DELETE /admin/retention/TENANT - Manage chat history retention (admin)")
+    log.Println("  GET|PUT|DELETE /admin/hold/TENANT[/KIND[/music]] - Hold music and comfort phrases for queues, holds and slow answers (admin)")
+    log.Println("  GET|PUT /admin/ipfilter - Manage the IP allow/deny lists (admin)")
+    log.Println("  GET  /admin/logs/stream[?level=&roomId=&clientId=&backlog=N] - Tail the server log as SSE (admin)")
+    log.Println("  POST|GET|DELETE /admin/room/ROOM_ID/trace[?minutes=N] - Capture and download a room trace (admin)")
 }
\ No newline at end of file
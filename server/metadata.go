@@ -0,0 +1,349 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// MetadataField describes one allowed key in a tenant's metadata schema.
+type MetadataField struct {
+    Type      string   `json:"type"` // string, number, boolean, object, array or any
+    MaxLength int      `json:"maxLength,omitempty"`
+    Enum      []string `json:"enum,omitempty"`
+    Public    bool     `json:"public,omitempty"` // Shown to other participants in rosters and events
+}
+
+type MetadataSchema struct {
+    Fields       map[string]MetadataField `json:"fields"`
+    AllowUnknown bool                     `json:"allowUnknown"`
+}
+
+var (
+    // Keyed by tenant, "" is the default schema used when a tenant has none
+    metadataSchemas   = make(map[string]*MetadataSchema)
+    metadataSchemasMu sync.RWMutex
+)
+
+func isProtectedMetadataKey(key string) bool {
+    for _, k := range cfg.ProtectedMetadataKeys {
+        if k == key {
+            return true
+        }
+    }
+    return false
+}
+
+// isPublicMetadataKey decides what other participants may see. Keys the
+// tenant schema marks public, plus the server-wide public list, are shared;
+// everything else stays private to the client and the REST API.
+func isPublicMetadataKey(tenant string, key string) bool {
+    if schema := schemaForTenant(tenant); schema != nil {
+        if field, ok := schema.Fields[key]; ok && field.Public {
+            return true
+        }
+    }
+    for _, k := range cfg.PublicMetadataKeys {
+        if k == key {
+            return true
+        }
+    }
+    return false
+}
+
+func filterPublicMetadata(tenant string, metadata map[string]interface{}) map[string]interface{} {
+    public := make(map[string]interface{})
+    for key, value := range metadata {
+        if isPublicMetadataKey(tenant, key) {
+            public[key] = value
+        }
+    }
+    return public
+}
+
+func publicMetadata(client *Client) map[string]interface{} {
+    return filterPublicMetadata(client.tenant, snapshotMetadata(client))
+}
+
+// participantInfo is what rosters and join events carry about a client.
+func participantInfo(client *Client) map[string]interface{} {
+    return map[string]interface{}{
+        "clientId":    client.clientId,
+        "clientType":  client.clientType,
+        "role":        client.role,
+        "displayName": displayNameOf(client),
+        "avatar":      avatarOf(client),
+        "metadata":    publicMetadata(client),
+    }
+}
+
+func schemaForTenant(tenant string) *MetadataSchema {
+    metadataSchemasMu.RLock()
+    defer metadataSchemasMu.RUnlock()
+    
+    if schema, ok := metadataSchemas[tenant]; ok {
+        return schema
+    }
+    return metadataSchemas[""]
+}
+
+func (f MetadataField) validate(value interface{}) error {
+    switch f.Type {
+    case "", "any":
+    case "string":
+        s, ok := value.(string)
+        if !ok {
+            return fmt.Errorf("expected string")
+        }
+        if f.MaxLength > 0 && len(s) > f.MaxLength {
+            return fmt.Errorf("longer than %d characters", f.MaxLength)
+        }
+        if len(f.Enum) > 0 {
+            for _, allowed := range f.Enum {
+                if s == allowed {
+                    return nil
+                }
+            }
+            return fmt.Errorf("must be one of %s", strings.Join(f.Enum, ", "))
+        }
+    case "number":
+        if _, ok := value.(float64); !ok {
+            return fmt.Errorf("expected number")
+        }
+    case "boolean":
+        if _, ok := value.(bool); !ok {
+            return fmt.Errorf("expected boolean")
+        }
+    case "object":
+        if _, ok := value.(map[string]interface{}); !ok {
+            return fmt.Errorf("expected object")
+        }
+    case "array":
+        if _, ok := value.([]interface{}); !ok {
+            return fmt.Errorf("expected array")
+        }
+    default:
+        return fmt.Errorf("unknown schema type %q", f.Type)
+    }
+    return nil
+}
+
+// validateMetadataUpdate checks a client supplied update against the size
+// limits, the protected keys and the tenant schema. A nil value deletes the key.
+func validateMetadataUpdate(client *Client, update map[string]interface{}) error {
+    if len(update) > cfg.MaxMetadataKeys {
+        return newCodedError(ErrTooLarge, "too many keys (max %d)", cfg.MaxMetadataKeys)
+    }
+    
+    schema := schemaForTenant(client.tenant)
+    
+    for key, value := range update {
+        if isProtectedMetadataKey(key) {
+            return newCodedError(ErrNotPermitted, "key %q is managed by the server", key)
+        }
+        
+        encoded, err := json.Marshal(value)
+        if err != nil {
+            return fmt.Errorf("key %q: %v", key, err)
+        }
+        if len(encoded) > cfg.MaxMetadataValueBytes {
+            return newCodedError(ErrTooLarge, "key %q exceeds %d bytes", key, cfg.MaxMetadataValueBytes)
+        }
+        
+        if value == nil || schema == nil {
+            continue
+        }
+        
+        field, known := schema.Fields[key]
+        if !known {
+            if schema.AllowUnknown {
+                continue
+            }
+            return fmt.Errorf("key %q not allowed by schema", key)
+        }
+        if err := field.validate(value); err != nil {
+            return fmt.Errorf("key %q: %v", key, err)
+        }
+    }
+    
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    keys := len(client.metadata)
+    for key, value := range update {
+        _, exists := client.metadata[key]
+        if value == nil && exists {
+            keys--
+        } else if value != nil && !exists {
+            keys++
+        }
+    }
+    if keys > cfg.MaxMetadataKeys {
+        return newCodedError(ErrTooLarge, "client metadata would exceed %d keys", cfg.MaxMetadataKeys)
+    }
+    
+    return nil
+}
+
+// setProtectedMetadata is the only way server components should write
+// protected keys such as role or verified identity.
+func setProtectedMetadata(client *Client, key string, value interface{}) {
+    client.mu.Lock()
+    if value == nil {
+        delete(client.metadata, key)
+    } else {
+        client.metadata[key] = value
+    }
+    client.mu.Unlock()
+    
+    notifyMetadataUpdated(client, map[string]interface{}{key: value})
+}
+
+func snapshotMetadata(client *Client) map[string]interface{} {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    metadata := make(map[string]interface{}, len(client.metadata))
+    for key, value := range client.metadata {
+        metadata[key] = value
+    }
+    return metadata
+}
+
+// notifyMetadataUpdated tells the owner about every change and the rest of
+// the room only about public keys.
+func notifyMetadataUpdated(client *Client, changed map[string]interface{}) {
+    newMsg := func(changed map[string]interface{}) *Message {
+        return &Message{
+            Type: "metadata_updated",
+            From: SystemSender,
+            Data: map[string]interface{}{
+                "clientId":   client.clientId,
+                "clientType": client.clientType,
+                "changed":    changed,
+            },
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        }
+    }
+    
+    sendMessageToClient(client, newMsg(changed))
+    
+    if public := filterPublicMetadata(client.tenant, changed); len(public) > 0 {
+        broadcastToRoom(client.room, client, newMsg(public))
+    }
+}
+
+// parseInitialMetadata validates the optional metadata query param so
+// participants show up in rosters fully described from the first event.
+func parseInitialMetadata(client *Client, raw string) error {
+    if raw == "" {
+        return nil
+    }
+    
+    var metadata map[string]interface{}
+    if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+        return fmt.Errorf("metadata must be a JSON object")
+    }
+    if err := validateMetadataUpdate(client, metadata); err != nil {
+        return err
+    }
+    
+    for key, value := range metadata {
+        if value != nil {
+            client.metadata[key] = value
+        }
+    }
+    return nil
+}
+
+// Admin API: /admin/metadata-schema/TENANT (use "_default" for the fallback schema)
+
+func handleMetadataSchema(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    tenant := strings.TrimPrefix(r.URL.Path, "/admin/metadata-schema/")
+    if tenant == "" {
+        http.Error(w, "Tenant required", http.StatusBadRequest)
+        return
+    }
+    if tenant == "_default" {
+        tenant = ""
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+        metadataSchemasMu.RLock()
+        schema := metadataSchemas[tenant]
+        metadataSchemasMu.RUnlock()
+        
+        if schema == nil {
+            http.Error(w, "Schema not found", http.StatusNotFound)
+            return
+        }
+        json.NewEncoder(w).Encode(schema)
+        
+    case http.MethodPut:
+        var schema MetadataSchema
+        if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        for key, field := range schema.Fields {
+            switch field.Type {
+            case "", "any", "string", "number", "boolean", "object", "array":
+            default:
+                http.Error(w, fmt.Sprintf("field %q has unknown type %q", key, field.Type), http.StatusBadRequest)
+                return
+            }
+        }
+        
+        metadataSchemasMu.Lock()
+        metadataSchemas[tenant] = &schema
+        metadataSchemasMu.Unlock()
+        
+        log.Printf("Metadata schema updated for tenant %q", tenant)
+        json.NewEncoder(w).Encode(schema)
+        
+    case http.MethodDelete:
+        metadataSchemasMu.Lock()
+        delete(metadataSchemas, tenant)
+        metadataSchemasMu.Unlock()
+        
+        w.WriteHeader(http.StatusNoContent)
+        
+    default:
+        http.Error(w, "Only GET, PUT and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// loadMetadataSchemas reads a JSON object of tenant -> schema, "" or
+// "_default" being the fallback schema.
+func loadMetadataSchemas(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    
+    var schemas map[string]*MetadataSchema
+    if err := json.Unmarshal(data, &schemas); err != nil {
+        return err
+    }
+    
+    metadataSchemasMu.Lock()
+    defer metadataSchemasMu.Unlock()
+    
+    for tenant, schema := range schemas {
+        if tenant == "_default" {
+            tenant = ""
+        }
+        metadataSchemas[tenant] = schema
+    }
+    return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+    "crypto/subtle"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// A minimal Prometheus text exposition. Every series carries tenant and
+// template labels, capped by metricLabelsFor so a busy multi-tenant server
+// can't explode the series count.
+
+const otherLabel = "other"
+
+type counterVec struct {
+    name   string
+    help   string
+    labels []string
+    mu     sync.Mutex
+    values map[string]float64 // Keyed by label values joined with \xff
+}
+
+type histogramVec struct {
+    name    string
+    help    string
+    labels  []string
+    buckets []float64
+    mu      sync.Mutex
+    series  map[string]*histogram
+}
+
+type histogram struct {
+    counts []uint64 // Cumulative count per bucket
+    sum    float64
+    count  uint64
+}
+
+var (
+    connectionsTotal = newCounterVec("iva_connections_total", "Clients admitted to a room.", "client_type")
+    messagesTotal    = newCounterVec("iva_messages_total", "Messages received from clients by type.", "type")
+    audioBytesTotal  = newCounterVec("iva_audio_bytes_total", "Audio bytes received from clients.")
+    queueDropsTotal  = newCounterVec("iva_send_queue_drops_total", "Outbound frames dropped because a client's send queue was full.", "priority")
+    errorsTotal      = newCounterVec("iva_errors_total", "Error messages sent to clients by code.", "code")
+    callsTotal       = newCounterVec("iva_calls_total", "Rooms closed.")
+    callDuration     = newHistogramVec("iva_call_duration_seconds", "Room lifetime from first join to close.",
+        []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600})
+)
+
+var metricSeries = []*counterVec{connectionsTotal, messagesTotal, audioBytesTotal, queueDropsTotal, errorsTotal, callsTotal}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+    return &counterVec{name: name, help: help, labels: append([]string{"tenant", "template"}, labels...), values: make(map[string]float64)}
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+    return &histogramVec{name: name, help: help, labels: append([]string{"tenant", "template"}, labels...), buckets: buckets, series: make(map[string]*histogram)}
+}
+
+// add takes the capped tenant/template pair from the client or room followed
+// by the metric's own label values. Clients that never joined have no pair.
+func (c *counterVec) add(v float64, labels []string, extra ...string) {
+    if labels == nil {
+        labels = []string{"", ""}
+    }
+    key := strings.Join(append(append([]string(nil), labels...), extra...), "\xff")
+    c.mu.Lock()
+    c.values[key] += v
+    c.mu.Unlock()
+}
+
+func (c *counterVec) inc(labels []string, extra ...string) {
+    c.add(1, labels, extra...)
+}
+
+func (h *histogramVec) observe(v float64, labels []string, extra ...string) {
+    if labels == nil {
+        labels = []string{"", ""}
+    }
+    key := strings.Join(append(append([]string(nil), labels...), extra...), "\xff")
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    s := h.series[key]
+    if s == nil {
+        s = &histogram{counts: make([]uint64, len(h.buckets))}
+        h.series[key] = s
+    }
+    for i, bound := range h.buckets {
+        if v <= bound {
+            s.counts[i]++
+        }
+    }
+    s.sum += v
+    s.count++
+}
+
+var (
+    labelTenants   = make(map[string]bool)
+    labelTemplates = make(map[string]bool)
+    labelsMu       sync.Mutex
+)
+
+// metricLabelsFor maps a tenant and template to the label values used on
+// every series. Tenants outside -metrics-tenants, or beyond the first
+// -metrics-max-tenants seen, collapse into "other"; templates are only
+// labelled with -metrics-template-label and are capped the same way.
+func metricLabelsFor(tenant string, template string) []string {
+    labelsMu.Lock()
+    defer labelsMu.Unlock()
+    
+    tenant = capLabel(tenant, cfg.MetricsTenants, cfg.MetricsMaxTenants, labelTenants)
+    if !cfg.MetricsTemplateLabel {
+        template = ""
+    } else {
+        template = capLabel(template, nil, cfg.MetricsMaxTemplates, labelTemplates)
+    }
+    return []string{tenant, template}
+}
+
+// capLabel must be called with labelsMu held.
+func capLabel(value string, allow []string, limit int, seen map[string]bool) string {
+    if len(allow) > 0 {
+        for _, allowed := range allow {
+            if allowed == value {
+                return value
+            }
+        }
+        return otherLabel
+    }
+    if seen[value] {
+        return value
+    }
+    if limit > 0 && len(seen) >= limit {
+        return otherLabel
+    }
+    seen[value] = true
+    return value
+}
+
+func writeLabels(b *strings.Builder, names []string, values []string) {
+    b.WriteByte('{')
+    for i, name := range names {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+        fmt.Fprintf(b, `%s="%s"`, name, value)
+    }
+    b.WriteByte('}')
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+    keys := make([]string, 0, len(c.values))
+    for key := range c.values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        b.WriteString(c.name)
+        writeLabels(b, c.labels, strings.Split(key, "\xff"))
+        fmt.Fprintf(b, " %g\n", c.values[key])
+    }
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+    keys := make([]string, 0, len(h.series))
+    for key := range h.series {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        s := h.series[key]
+        values := strings.Split(key, "\xff")
+        names := append(append([]string(nil), h.labels...), "le")
+        for i, bound := range h.buckets {
+            b.WriteString(h.name + "_bucket")
+            writeLabels(b, names, append(append([]string(nil), values...), fmt.Sprintf("%g", bound)))
+            fmt.Fprintf(b, " %d\n", s.counts[i])
+        }
+        b.WriteString(h.name + "_bucket")
+        writeLabels(b, names, append(append([]string(nil), values...), "+Inf"))
+        fmt.Fprintf(b, " %d\n", s.count)
+        b.WriteString(h.name + "_sum")
+        writeLabels(b, h.labels, values)
+        fmt.Fprintf(b, " %g\n", s.sum)
+        b.WriteString(h.name + "_count")
+        writeLabels(b, h.labels, values)
+        fmt.Fprintf(b, " %d\n", s.count)
+    }
+}
+
+// writeRoomGauges reports live rooms and clients, computed at scrape time.
+func writeRoomGauges(b *strings.Builder) {
+    roomCounts := make(map[string]int)
+    clientCounts := make(map[string]int)
+    
+    roomsMu.RLock()
+    for _, room := range rooms {
+        labels := room.labels
+        roomCounts[strings.Join(labels, "\xff")]++
+        clientCounts[strings.Join(append(append([]string(nil), labels...), string(ClientTypeUser)), "\xff")] += len(room.Users)
+        clientCounts[strings.Join(append(append([]string(nil), labels...), string(ClientTypeAgent)), "\xff")] += len(room.Agents)
+    }
+    roomsMu.RUnlock()
+    
+    for _, gauge := range []struct {
+        name   string
+        help   string
+        labels []string
+        values map[string]int
+    }{
+        {"iva_active_rooms", "Rooms with at least one participant.", []string{"tenant", "template"}, roomCounts},
+        {"iva_active_clients", "Connected clients.", []string{"tenant", "template", "client_type"}, clientCounts},
+    } {
+        fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+        keys := make([]string, 0, len(gauge.values))
+        for key := range gauge.values {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        for _, key := range keys {
+            b.WriteString(gauge.name)
+            writeLabels(b, gauge.labels, strings.Split(key, "\xff"))
+            fmt.Fprintf(b, " %d\n", gauge.values[key])
+        }
+    }
+}
+
+// messageTypeLabel keeps arbitrary client chosen types out of the labels.
+func messageTypeLabel(msgType string) string {
+    for _, known := range clientMessageTypes {
+        if known == msgType {
+            return msgType
+        }
+    }
+    return otherLabel
+}
+
+// GET /metrics, guarded by -metrics-token when set
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if cfg.MetricsToken != "" {
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+    }
+    
+    var b strings.Builder
+    for _, c := range metricSeries {
+        c.write(&b)
+    }
+    callDuration.write(&b)
+    sttLatency.write(&b)
+    ttsLatency.write(&b)
+    turnLatency.write(&b)
+    callQuality.write(&b)
+    writeRoomGauges(&b)
+    writeLatencyGauges(&b)
+    
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.Write([]byte(b.String()))
+}
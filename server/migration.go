@@ -0,0 +1,381 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Room migration, for shedding live calls off a server that is failing or
+// about to go away without dropping them:
+//
+//   POST /admin/rooms/migrate   {filter..., "target": "https://media-2:8443", "reason": TEXT}
+//
+// takes the rooms matching the filter, as for the other bulk room
+// operations, and hands each to the target server in turn:
+//
+//  1. the room's state is posted to the target's POST /admin/rooms/import
+//     with this server's -admin-token, which the fleet shares
+//  2. the target opens the room with every participant held for resume, as
+//     if they had all just dropped (see resume.go)
+//  3. each participant here gets
+//       {"type": "redirect", "data": {"url", "roomId", "resumeToken", "reason"}}
+//     and reconnects to url with its resume token, taking its place back on
+//     the target: the room sees session_resumed and lastSeq carries on
+//
+// The room's journal is locked throughout, so nothing said in it during the
+// transfer is lost. What moves is the room's tenant, template, creation
+// time, snapshot pin, experiment variants, recording state and CDR so far,
+// and each participant's ID, role, profile, metadata and resume token (its
+// hash, in a failover checkpoint, see failover.go). The rest of a call's
+// live state starts afresh on the target: private channels, consults,
+// verification and secure capture in progress, the offline queues, the
+// journal's events (a resume replays nothing from before the move) and the
+// usage counted against quotas. The CDR is written once, by the server the
+// call ends on.
+//
+// The SDKs follow redirects. A client that doesn't close within a few
+// seconds is closed with migrated, and anyone connecting here to a migrated
+// room during the resume grace, held sessions coming back say, is
+// redirected in turn.
+
+// RoomMigration is the state a room moves between servers with.
+type RoomMigration struct {
+    RoomId       string                `json:"roomId"`
+    Tenant       string                `json:"tenant,omitempty"`
+    Template     string                `json:"template,omitempty"`
+    Locale       string                `json:"locale,omitempty"`
+    CreatedAt    int64                 `json:"createdAt"`
+    KGSnapshot   string                `json:"kgSnapshot,omitempty"`
+    Variants     map[string]string     `json:"variants,omitempty"`
+    Recording    RecordingState        `json:"recording"`
+    CDR          *storage.CDR          `json:"cdr,omitempty"`
+    Seq          uint64                `json:"seq"` // The journal's, so lastSeq carries on
+    Participants []MigratedParticipant `json:"participants"`
+}
+
+type MigratedParticipant struct {
+    ClientId        string                 `json:"clientId"`
+    RequestedId     string                 `json:"requestedId,omitempty"` // See duplicates.go
+    ClientType      ClientType             `json:"clientType"`
+    Role            string                 `json:"role"`
+    Tenant          string                 `json:"tenant,omitempty"`
+    AgentId         string                 `json:"agentId,omitempty"`
+    DisplayName     string                 `json:"displayName,omitempty"`
+    Avatar          string                 `json:"avatar,omitempty"`
+    Metadata        map[string]interface{} `json:"metadata,omitempty"`
+    ResumeToken     string                 `json:"resumeToken,omitempty"`
+    ResumeTokenHash string                 `json:"resumeTokenHash,omitempty"` // Instead of the token in checkpoints, see failover.go
+}
+
+// How long a redirected client has to close before it is closed.
+const redirectGrace = 5 * time.Second
+
+// migrations are the rooms that moved away, by ID, kept for the resume grace.
+var (
+    migrations   = make(map[string]migratedRoom)
+    migrationsMu sync.Mutex
+)
+
+type migratedRoom struct {
+    target string
+    reason string
+    until  time.Time
+}
+
+var migrationClient = &http.Client{Timeout: 10 * time.Second}
+
+// migrateRoom moves one room to the server at target, a base URL.
+func migrateRoom(roomId string, target string, reason string) error {
+    journal := lockJournal(roomId)
+    defer journal.mu.Unlock()
+    
+    migration := snapshotRoom(roomId, journal.seq)
+    if migration == nil {
+        return fmt.Errorf("room closed")
+    }
+    if err := importRoom(target, migration); err != nil {
+        return err
+    }
+    
+    migrationsMu.Lock()
+    migrations[roomId] = migratedRoom{target: target, reason: reason, until: time.Now().Add(cfg.ResumeGrace)}
+    migrationsMu.Unlock()
+    for _, client := range retireRoom(roomId, target) {
+        client.mu.Lock()
+        token := client.resumeToken
+        client.mu.Unlock()
+        redirectClient(client, target, token, reason)
+        conn := client.conn
+        time.AfterFunc(redirectGrace, func() {
+            closeConnection(conn, CloseMigrated, "")
+        })
+    }
+    logAt("info", roomId, "", "Room migrated to %s: %s", target, reason)
+    return nil
+}
+
+// importRoom hands a room's state to the server at target.
+func importRoom(target string, migration *RoomMigration) error {
+    body, _ := json.Marshal(migration)
+    req, err := http.NewRequest(http.MethodPost, target+"/admin/rooms/import", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+    resp, err := migrationClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(text)))
+    }
+    return nil
+}
+
+// snapshotRoom captures what a room moves with, nil when it is gone.
+func snapshotRoom(roomId string, seq uint64) *RoomMigration {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    room := rooms[roomId]
+    if room == nil {
+        return nil
+    }
+    migration := &RoomMigration{
+        RoomId:     room.RoomId,
+        Tenant:     room.Tenant,
+        Template:   room.Template,
+        Locale:     room.Locale,
+        CreatedAt:  room.CreatedAt,
+        KGSnapshot: room.KGSnapshot,
+        Variants:   room.Variants,
+        Recording:  room.Recording,
+        Seq:        seq,
+    }
+    if room.cdr != nil {
+        cdr := *room.cdr
+        cdr.Participants = append([]storage.CDRParticipant(nil), room.cdr.Participants...)
+        migration.CDR = &cdr
+    }
+    clients := make([]*Client, 0, len(room.Users)+len(room.Agents)+len(room.held))
+    for _, client := range room.Users {
+        clients = append(clients, client)
+    }
+    for _, client := range room.Agents {
+        clients = append(clients, client)
+    }
+    for _, held := range room.held {
+        clients = append(clients, held.client)
+    }
+    for _, client := range clients {
+        client.mu.Lock()
+        migration.Participants = append(migration.Participants, MigratedParticipant{
+            ClientId:        client.clientId,
+            RequestedId:     client.requestedId,
+            ClientType:      client.clientType,
+            Role:            client.role,
+            Tenant:          client.tenant,
+            AgentId:         client.agentId,
+            DisplayName:     client.displayName,
+            Avatar:          client.avatar,
+            Metadata:        copyMetadata(client.metadata),
+            ResumeToken:     client.resumeToken,
+            ResumeTokenHash: client.resumeTokenHash,
+        })
+        client.mu.Unlock()
+    }
+    return migration
+}
+
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+    copied := make(map[string]interface{}, len(metadata))
+    for key, value := range metadata {
+        copied[key] = value
+    }
+    return copied
+}
+
+// retireRoom takes a migrated room down here without ending its call: the
+// connections are superseded, leaving quietly, and no CDR is written. It
+// returns the connected participants to redirect.
+func retireRoom(roomId string, target string) []*Client {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return nil
+    }
+    var clients []*Client
+    for _, members := range []map[string]*Client{room.Users, room.Agents} {
+        for _, client := range members {
+            client.mu.Lock()
+            client.superseded = true
+            client.mu.Unlock()
+            clients = append(clients, client)
+        }
+    }
+    for _, client := range room.Agents {
+        go noteAgentLeave(client)
+    }
+    for _, held := range room.held {
+        held.timer.Stop()
+        if held.client.clientType == ClientTypeAgent {
+            go noteAgentLeave(held.client)
+        }
+    }
+    room.Users, room.Agents, room.held = map[string]*Client{}, map[string]*Client{}, nil
+    noteHoldLeave(room)
+    delete(rooms, roomId)
+    noteDrainProgress(len(rooms))
+    emitEvent("room_migrated", room, map[string]interface{}{"target": target})
+    shadows := takeShadows(room)
+    roomsMu.Unlock()
+    
+    closeShadows(shadows)
+    return clients
+}
+
+func redirectClient(client *Client, target string, token string, reason string) {
+    sendMessageToClient(client, &Message{
+        Type: "redirect",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "url":         target,
+            "roomId":      client.room,
+            "resumeToken": token,
+            "reason":      reason,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// migratedTo is where a room that moved away went, empty when it didn't.
+func migratedTo(roomId string) (migratedRoom, bool) {
+    migrationsMu.Lock()
+    defer migrationsMu.Unlock()
+    migrated, ok := migrations[roomId]
+    if ok && time.Now().After(migrated.until) {
+        delete(migrations, roomId)
+        return migratedRoom{}, false
+    }
+    return migrated, ok
+}
+
+// serveRedirect points a client connecting to a migrated room at its new
+// server and waits for it to leave.
+func serveRedirect(client *Client, migrated migratedRoom, token string) {
+    redirectClient(client, migrated.target, token, migrated.reason)
+    client.conn.SetReadDeadline(time.Now().Add(redirectGrace))
+    for {
+        if _, _, err := client.conn.ReadMessage(); err != nil {
+            break
+        }
+    }
+    client.stopWriter()
+    closeConnection(client.conn, CloseMigrated, "")
+}
+
+// POST /admin/rooms/import (admin), the receiving end of a migration.
+func handleRoomImport(w http.ResponseWriter, r *http.Request) {
+    var migration RoomMigration
+    if err := json.NewDecoder(r.Body).Decode(&migration); err != nil {
+        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        return
+    }
+    if migration.RoomId == "" {
+        http.Error(w, "roomId required", http.StatusBadRequest)
+        return
+    }
+    if isDraining() {
+        http.Error(w, "server is draining", http.StatusServiceUnavailable)
+        return
+    }
+    
+    // Reconnects resume from the source's sequence, not a fresh one
+    journal := lockJournal(migration.RoomId)
+    if journal.seq < migration.Seq {
+        journal.seq = migration.Seq
+    }
+    defer journal.mu.Unlock()
+    
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    if rooms[migration.RoomId] != nil {
+        http.Error(w, "room already open here", http.StatusConflict)
+        return
+    }
+    room := &RoomInfo{
+        RoomId:     migration.RoomId,
+        Users:      make(map[string]*Client),
+        Agents:     make(map[string]*Client),
+        Channels:   make(map[string]*Channel),
+        held:       make(map[string]*heldSession),
+        Tenant:     migration.Tenant,
+        Template:   migration.Template,
+        Locale:     migration.Locale,
+        CreatedAt:  migration.CreatedAt,
+        KGSnapshot: migration.KGSnapshot,
+        Variants:   migration.Variants,
+        Recording:  migration.Recording,
+        cdr:        migration.CDR,
+        rate:       newRoomRate(),
+    }
+    if room.cdr == nil {
+        room.cdr = cdrFor(room)
+    }
+    room.labels = metricLabelsFor(room.Tenant, room.Template)
+    rooms[room.RoomId] = room
+    
+    // A call that had its agent isn't routed another while they reconnect
+    staffed := false
+    for _, p := range migration.Participants {
+        staffed = staffed || p.ClientType == ClientTypeAgent
+    }
+    for _, p := range migration.Participants {
+        client := &Client{
+            room:            room.RoomId,
+            clientId:        p.ClientId,
+            requestedId:     p.RequestedId,
+            clientType:      p.ClientType,
+            role:            p.Role,
+            tenant:          p.Tenant,
+            agentId:         p.AgentId,
+            displayName:     p.DisplayName,
+            avatar:          p.Avatar,
+            metadata:        p.Metadata,
+            lastEphemeral:   make(map[string]time.Time),
+            resumeToken:     p.ResumeToken,
+            resumeTokenHash: p.ResumeTokenHash,
+            labels:          room.labels,
+        }
+        if client.metadata == nil {
+            client.metadata = map[string]interface{}{"role": client.role}
+        }
+        if client.clientType == ClientTypeAgent || !staffed {
+            noteQueueJoin(room, client)
+        }
+        noteAgentPresence(room, client, true)
+        room.held[client.clientId] = &heldSession{
+            client: client,
+            reason: "closed",
+            since:  time.Now(),
+            timer:  time.AfterFunc(cfg.ResumeGrace, func() { expireSession(room.RoomId, client) }),
+        }
+    }
+    emitEvent("room_migrated", room, map[string]interface{}{"imported": true})
+    logAt("info", room.RoomId, "", "Room imported with %d participants waiting to resume", len(migration.Participants))
+    
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{"roomId": room.RoomId, "participants": len(migration.Participants)})
+}
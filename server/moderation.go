@@ -0,0 +1,163 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "time"
+    
+    "github.com/yourusername/my-go-project/moderation"
+)
+
+// Guardrails. Virtual agent answers (assistant_final) and, with
+// -moderate-transcripts, user transcripts go through the tenant's moderation
+// pipeline: the -moderation-rules followed by the -moderation-providers.
+// Anything that isn't allowed outright is reported to the room's agents as a
+// moderation_event, kept in the history and emitted to the event outbox so
+// it can be reviewed later.
+
+var moderationPipelines = make(map[string]*moderation.Pipeline) // By tenant, "" is the default
+
+var (
+    moderationActions = newCounterVec("iva_moderation_actions_total", "Moderated messages by source and resulting action.", "source", "action")
+    moderationErrors  = newCounterVec("iva_moderation_errors_total", "Moderation provider failures.", "provider")
+)
+
+func init() {
+    metricSeries = append(metricSeries, moderationActions, moderationErrors)
+}
+
+// loadModeration builds a pipeline per tenant named in the rules file, each
+// with the "_default" rules followed by the tenant's own.
+func loadModeration() error {
+    var providers []moderation.Provider
+    for _, name := range cfg.ModerationProviders {
+        switch name {
+        case "openai":
+            providers = append(providers, &moderation.OpenAI{URL: cfg.OpenAIModerationURL, APIKey: cfg.OpenAIAPIKey, Model: cfg.OpenAIModerationModel})
+        case "azure":
+            providers = append(providers, &moderation.Azure{Endpoint: cfg.ContentSafetyURL, APIKey: cfg.ContentSafetyKey})
+        default:
+            return fmt.Errorf("unknown moderation provider %q", name)
+        }
+    }
+    onFlagged, err := moderation.ParseAction(cfg.ModerationOnFlagged)
+    if err != nil {
+        return err
+    }
+    
+    rules := map[string][]moderation.Rule{}
+    if cfg.ModerationRulesFile != "" {
+        data, err := os.ReadFile(cfg.ModerationRulesFile)
+        if err != nil {
+            return err
+        }
+        if err := json.Unmarshal(data, &rules); err != nil {
+            return fmt.Errorf("%s: %v", cfg.ModerationRulesFile, err)
+        }
+    }
+    if len(rules) == 0 && len(providers) == 0 {
+        return nil
+    }
+    
+    defaults := rules["_default"]
+    moderationPipelines[""], err = moderation.NewPipeline(defaults, providers, cfg.ModerationThreshold, onFlagged)
+    if err != nil {
+        return err
+    }
+    for tenant, list := range rules {
+        if tenant == "_default" {
+            continue
+        }
+        combined := append(append([]moderation.Rule(nil), defaults...), list...)
+        if moderationPipelines[tenant], err = moderation.NewPipeline(combined, providers, cfg.ModerationThreshold, onFlagged); err != nil {
+            return fmt.Errorf("tenant %s: %v", tenant, err)
+        }
+    }
+    return nil
+}
+
+func moderationFor(tenant string) *moderation.Pipeline {
+    if pipeline, ok := moderationPipelines[tenant]; ok {
+        return pipeline
+    }
+    return moderationPipelines[""]
+}
+
+// moderate runs text through the room tenant's pipeline and reports any
+// verdict other than allow. rulesOnly skips the providers.
+func moderate(roomId string, client *Client, source string, messageId string, text string, rulesOnly bool) moderation.Result {
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return moderation.Result{Action: moderation.Allow, Text: text}
+    }
+    pipeline := moderationFor(room.Tenant)
+    if pipeline == nil {
+        return moderation.Result{Action: moderation.Allow, Text: text}
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.ModerationTimeout)
+    defer cancel()
+    result := pipeline.Moderate(ctx, text, rulesOnly)
+    for _, err := range result.Errors {
+        logAt("warn", roomId, client.clientId, "Moderation: %v", err)
+        moderationErrors.inc(client.labels, err.Provider)
+    }
+    if len(result.Errors) > 0 && cfg.ModerationFailClosed && result.Action != moderation.Block {
+        result.Action = moderation.Block
+        result.Matches = append(result.Matches, moderation.Match{Source: "server", Name: "provider_unavailable", Action: moderation.Block})
+    }
+    
+    moderationActions.inc(client.labels, source, string(result.Action))
+    if result.Action == moderation.Allow {
+        return result
+    }
+    
+    data := map[string]interface{}{
+        "source":    source,
+        "messageId": messageId,
+        "clientId":  client.clientId,
+        "action":    result.Action,
+        "matches":   result.Matches,
+        "text":      text,
+    }
+    if result.Action == moderation.Rewrite {
+        data["rewritten"] = result.Text
+    }
+    event := &Message{
+        Id:        newMessageId(),
+        Type:      "moderation_event",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    logAt("info", roomId, client.clientId, "Moderation %s on %s %s", result.Action, source, messageId)
+    recordHistory(roomId, event)
+    sendToAgents(roomId, nil, event)
+    emitEvent("moderation", room, data)
+    return result
+}
+
+// moderateAssistantFinal applies the verdict to an answer before it is
+// recorded and delivered. It reports false when nothing should be sent.
+func moderateAssistantFinal(roomId string, sender *Client, msg *Message) bool {
+    data := msg.Data.(map[string]interface{})
+    text, _ := data["text"].(string)
+    result := moderate(roomId, sender, "assistant_final", msg.Id, text, false)
+    switch result.Action {
+    case moderation.Block:
+        if cfg.ModerationFallback == "" {
+            sendError(sender, ErrNotPermitted, msg, "answer blocked by moderation")
+            return false
+        }
+        // The citations belonged to the blocked answer, not the fallback
+        data["text"] = cfg.ModerationFallback
+        data["citations"] = []Citation{}
+        data["moderated"] = true
+    case moderation.Rewrite:
+        data["text"] = result.Text
+        data["moderated"] = true
+    }
+    return true
+}
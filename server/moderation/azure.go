@@ -0,0 +1,49 @@
+package moderation
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// Azure calls Azure AI Content Safety text analysis. Severities run 0 to 7
+// and are scaled to 0 to 1 scores; the service gives no verdict of its own.
+type Azure struct {
+    Endpoint string // e.g. https://NAME.cognitiveservices.azure.com
+    APIKey   string
+    Client   *http.Client
+}
+
+func (a *Azure) Name() string {
+    return "azure"
+}
+
+func (a *Azure) Classify(ctx context.Context, text string) ([]Category, error) {
+    body, _ := json.Marshal(map[string]interface{}{"text": text, "outputType": "EightSeverityLevels"})
+    url := strings.TrimRight(a.Endpoint, "/") + "/contentsafety/text:analyze?api-version=2023-10-01"
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Ocp-Apim-Subscription-Key", a.APIKey)
+    
+    var response struct {
+        CategoriesAnalysis []struct {
+            Category string `json:"category"`
+            Severity int    `json:"severity"`
+        } `json:"categoriesAnalysis"`
+    }
+    if err := doJSON(a.Client, req, &response); err != nil {
+        return nil, fmt.Errorf("azure: %v", err)
+    }
+    
+    categories := make([]Category, 0, len(response.CategoriesAnalysis))
+    for _, analysis := range response.CategoriesAnalysis {
+        categories = append(categories, Category{Name: strings.ToLower(analysis.Category), Score: float64(analysis.Severity) / 7})
+    }
+    return categories, nil
+}
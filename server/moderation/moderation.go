@@ -0,0 +1,170 @@
+// Package moderation checks text the IVA says or hears against configurable
+// rules and vendor moderation APIs, and decides whether it goes out as is,
+// rewritten, or not at all.
+package moderation
+
+import (
+    "context"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// Action is what should happen to moderated text, ordered by severity.
+type Action string
+
+const (
+    Allow   Action = "allow"
+    Flag    Action = "flag"    // Deliver unchanged, but report for review
+    Rewrite Action = "rewrite" // Deliver with the matches replaced
+    Block   Action = "block"   // Don't deliver
+)
+
+var severity = map[Action]int{Allow: 0, Flag: 1, Rewrite: 2, Block: 3}
+
+func ParseAction(s string) (Action, error) {
+    action := Action(strings.ToLower(s))
+    if _, ok := severity[action]; !ok {
+        return "", fmt.Errorf("unknown moderation action %q", s)
+    }
+    return action, nil
+}
+
+// Rule matches either a regular expression or a list of whole-word terms.
+type Rule struct {
+    Name        string   `json:"name"`
+    Category    string   `json:"category,omitempty"`
+    Pattern     string   `json:"pattern,omitempty"`
+    Terms       []string `json:"terms,omitempty"`
+    Action      Action   `json:"action"`
+    Replacement string   `json:"replacement,omitempty"` // For rewrite, "***" when empty
+}
+
+// Category is one classification returned by a Provider.
+type Category struct {
+    Name    string
+    Score   float64 // 0 to 1
+    Flagged bool    // The vendor's own verdict, if it gives one
+}
+
+type Provider interface {
+    Name() string
+    Classify(ctx context.Context, text string) ([]Category, error)
+}
+
+type Match struct {
+    Source   string  `json:"source"` // "rule" or the provider name
+    Name     string  `json:"name"`   // Rule name or provider category
+    Category string  `json:"category,omitempty"`
+    Score    float64 `json:"score,omitempty"`
+    Action   Action  `json:"action"`
+}
+
+type Result struct {
+    Action  Action
+    Text    string // The text to deliver when Action is Allow, Flag or Rewrite
+    Matches []Match
+    Errors  []*ProviderError // The caller decides whether a failed provider blocks
+}
+
+type ProviderError struct {
+    Provider string
+    Err      error
+}
+
+func (e *ProviderError) Error() string {
+    return e.Err.Error()
+}
+
+type compiledRule struct {
+    Rule
+    re *regexp.Regexp
+}
+
+// Pipeline runs rules first, then every provider on the rewritten text.
+type Pipeline struct {
+    rules     []compiledRule
+    Providers []Provider
+    Threshold float64 // Provider scores at or above this count as flagged
+    OnFlagged Action  // What a flagged provider category does
+}
+
+func NewPipeline(rules []Rule, providers []Provider, threshold float64, onFlagged Action) (*Pipeline, error) {
+    p := &Pipeline{Providers: providers, Threshold: threshold, OnFlagged: onFlagged}
+    for _, rule := range rules {
+        if _, ok := severity[rule.Action]; !ok {
+            return nil, fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+        }
+        pattern := rule.Pattern
+        if len(rule.Terms) > 0 {
+            quoted := make([]string, len(rule.Terms))
+            for i, term := range rule.Terms {
+                quoted[i] = regexp.QuoteMeta(term)
+            }
+            // Longest first so "damn it" wins over "damn"
+            sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+            terms := `\b(?:` + strings.Join(quoted, "|") + `)\b`
+            if pattern != "" {
+                pattern = "(?:" + pattern + ")|" + terms
+            } else {
+                pattern = terms
+            }
+        }
+        if pattern == "" {
+            return nil, fmt.Errorf("rule %q needs a pattern or terms", rule.Name)
+        }
+        re, err := regexp.Compile("(?i)" + pattern)
+        if err != nil {
+            return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+        }
+        if rule.Replacement == "" {
+            rule.Replacement = "***"
+        }
+        p.rules = append(p.rules, compiledRule{rule, re})
+    }
+    return p, nil
+}
+
+// Moderate checks text. Providers are skipped when rulesOnly is set, for
+// high volume input like partial transcripts.
+func (p *Pipeline) Moderate(ctx context.Context, text string, rulesOnly bool) Result {
+    result := Result{Action: Allow, Text: text}
+    if p == nil {
+        return result
+    }
+    
+    for _, rule := range p.rules {
+        if !rule.re.MatchString(result.Text) {
+            continue
+        }
+        result.add(Match{Source: "rule", Name: rule.Name, Category: rule.Category, Action: rule.Action})
+        if rule.Action == Rewrite {
+            result.Text = rule.re.ReplaceAllLiteralString(result.Text, rule.Replacement)
+        }
+    }
+    if result.Action == Block || rulesOnly {
+        return result
+    }
+    
+    for _, provider := range p.Providers {
+        categories, err := provider.Classify(ctx, result.Text)
+        if err != nil {
+            result.Errors = append(result.Errors, &ProviderError{provider.Name(), err})
+            continue
+        }
+        for _, category := range categories {
+            if category.Flagged || (p.Threshold > 0 && category.Score >= p.Threshold) {
+                result.add(Match{Source: provider.Name(), Name: category.Name, Score: category.Score, Action: p.OnFlagged})
+            }
+        }
+    }
+    return result
+}
+
+func (r *Result) add(m Match) {
+    r.Matches = append(r.Matches, m)
+    if severity[m.Action] > severity[r.Action] {
+        r.Action = m.Action
+    }
+}
@@ -0,0 +1,72 @@
+package moderation
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// OpenAI calls the /moderations endpoint of the OpenAI API or a compatible
+// server.
+type OpenAI struct {
+    URL    string // Base URL, e.g. https://api.openai.com/v1
+    APIKey string
+    Model  string // Empty uses the server's default
+    Client *http.Client
+}
+
+func (o *OpenAI) Name() string {
+    return "openai"
+}
+
+func (o *OpenAI) Classify(ctx context.Context, text string) ([]Category, error) {
+    body, _ := json.Marshal(map[string]interface{}{"input": text, "model": o.Model})
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(o.URL, "/")+"/moderations", bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if o.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+o.APIKey)
+    }
+    
+    var response struct {
+        Results []struct {
+            Categories     map[string]bool    `json:"categories"`
+            CategoryScores map[string]float64 `json:"category_scores"`
+        } `json:"results"`
+    }
+    if err := doJSON(o.Client, req, &response); err != nil {
+        return nil, fmt.Errorf("openai: %v", err)
+    }
+    
+    var categories []Category
+    for _, result := range response.Results {
+        for name, score := range result.CategoryScores {
+            categories = append(categories, Category{Name: name, Score: score, Flagged: result.Categories[name]})
+        }
+    }
+    return categories, nil
+}
+
+// doJSON sends req and decodes a 2xx JSON response into out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+)
+
+func registryMTLSEnabled() bool {
+    return cfg.RegistryClientCA != ""
+}
+
+// registryIdentity authenticates a media server by its verified client
+// certificate. With mTLS disabled every caller is accepted, as before.
+func registryIdentity(r *http.Request, server ServerInfo) (string, error) {
+    if !registryMTLSEnabled() {
+        return "", nil
+    }
+    
+    if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+        return "", fmt.Errorf("verified client certificate required")
+    }
+    cert := r.TLS.VerifiedChains[0][0]
+    
+    identity := cert.Subject.CommonName
+    if cfg.RegistryTrustDomain != "" {
+        identity = ""
+        for _, uri := range cert.URIs {
+            if uri.Scheme == "spiffe" && uri.Host == cfg.RegistryTrustDomain {
+                identity = uri.String()
+                break
+            }
+        }
+        if identity == "" {
+            return "", fmt.Errorf("certificate has no SPIFFE ID in trust domain %s", cfg.RegistryTrustDomain)
+        }
+    }
+    
+    // A valid certificate only vouches for its own hosts, not for every address
+    if cfg.RegistryBindAddress && !certCoversHost(cert, server.Address) {
+        return "", fmt.Errorf("certificate not valid for address %s", server.Address)
+    }
+    
+    return identity, nil
+}
+
+func certCoversHost(cert *x509.Certificate, host string) bool {
+    if ip := net.ParseIP(host); ip != nil {
+        for _, certIP := range cert.IPAddresses {
+            if certIP.Equal(ip) {
+                return true
+            }
+        }
+        return false
+    }
+    return cert.VerifyHostname(host) == nil
+}
+
+// startRegistryListener serves the registration endpoints on a separate
+// mutual TLS listener so media servers never talk to the registry in clear.
+func startRegistryListener() {
+    if !registryMTLSEnabled() {
+        return
+    }
+    if cfg.RegistryAddr == "" || cfg.RegistryTLSCert == "" || cfg.RegistryTLSKey == "" {
+        log.Fatal("Registry mTLS needs -registry-addr, -registry-tls-cert and -registry-tls-key")
+    }
+    
+    caPEM, err := os.ReadFile(cfg.RegistryClientCA)
+    if err != nil {
+        log.Fatalf("Reading registry client CA: %v", err)
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(caPEM) {
+        log.Fatalf("No certificates found in %s", cfg.RegistryClientCA)
+    }
+    
+    mux := http.NewServeMux()
+    mux.HandleFunc("/register", handleRegister)
+    mux.HandleFunc("/heartbeat", handleHeartbeat)
+    mux.HandleFunc("/v1/register", handleRegister)
+    mux.HandleFunc("/v1/heartbeat", handleHeartbeat)
+    
+    server := &http.Server{
+        Addr:    cfg.RegistryAddr,
+        Handler: mux,
+        TLSConfig: &tls.Config{
+            ClientAuth: tls.RequireAndVerifyClientCert,
+            ClientCAs:  pool,
+            MinVersion: tls.VersionTLS12,
+        },
+    }
+    
+    go func() {
+        log.Printf("Registry mTLS listener running on %s", cfg.RegistryAddr)
+        log.Fatal(server.ListenAndServeTLS(cfg.RegistryTLSCert, cfg.RegistryTLSKey))
+    }()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// Observer tokens, for wallboards and dashboards that poll call state
+// without holding the admin token. -observer-tokens-file names a JSON list,
+//
+//   [{"name": "floor-2-wallboard", "token": "...", "tenants": ["acme"],
+//     "endpoints": ["/rooms", "/room/{id}", "/analytics"]}]
+//
+// and a token is sent like the admin one, as "Authorization: Bearer TOKEN".
+// It only reads, GET of the endpoints it lists or of all three when it lists
+// none, /rooms standing for /v1/rooms too. Tenants limit what it sees to
+// those tenants' calls, none meaning every tenant: room lists leave the rest
+// out, another tenant's /room/{id} is not found, and /analytics answers only
+// for the token's tenants. /rooms and /room/{id} are otherwise open to
+// anyone; -room-api-auth keeps them to the admin token and observer tokens.
+
+type ObserverToken struct {
+    Name      string   `json:"name"`
+    Token     string   `json:"token"`
+    Tenants   []string `json:"tenants,omitempty"`   // Every tenant when empty
+    Endpoints []string `json:"endpoints,omitempty"` // Every observer endpoint when empty
+}
+
+var observerEndpoints = []string{"/rooms", "/room/{id}", "/analytics"}
+
+var observerTokens []ObserverToken
+
+// observerScope is the tenants a read may see, every one when empty.
+type observerScope struct {
+    name    string // The observer token's, empty for the admin token or an open endpoint
+    tenants []string
+}
+
+func (s *observerScope) allows(tenant string) bool {
+    return len(s.tenants) == 0 || containsString(s.tenants, tenant)
+}
+
+// loadObserverTokens reads -observer-tokens-file.
+func loadObserverTokens() error {
+    if cfg.ObserverTokensFile == "" {
+        return nil
+    }
+    data, err := os.ReadFile(cfg.ObserverTokensFile)
+    if err != nil {
+        return err
+    }
+    var tokens []ObserverToken
+    if err := json.Unmarshal(data, &tokens); err != nil {
+        return fmt.Errorf("%s: %v", cfg.ObserverTokensFile, err)
+    }
+    names := make(map[string]bool)
+    for _, token := range tokens {
+        switch {
+        case token.Name == "" || names[token.Name]:
+            return fmt.Errorf("every observer token needs a name of its own, %q is missing or repeated", token.Name)
+        case len(token.Token) < 16:
+            return fmt.Errorf("observer token %s: use a token of at least 16 characters", token.Name)
+        case token.Token == cfg.AdminToken:
+            return fmt.Errorf("observer token %s is the admin token", token.Name)
+        }
+        names[token.Name] = true
+        for _, endpoint := range token.Endpoints {
+            if !containsString(observerEndpoints, endpoint) {
+                return fmt.Errorf("observer token %s: unknown endpoint %q, use %s", token.Name, endpoint, strings.Join(observerEndpoints, ", "))
+            }
+        }
+    }
+    observerTokens = tokens
+    log.Printf("Loaded %d observer tokens", len(tokens))
+    return nil
+}
+
+// observerAccess decides a read of endpoint, returning the scope it may see
+// or the status and text to refuse it with. An open endpoint lets anyone
+// without a token read everything.
+func observerAccess(r *http.Request, endpoint string, open bool) (*observerScope, int, string) {
+    if isAdminRequest(r) {
+        return &observerScope{}, 0, ""
+    }
+    bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    for _, token := range observerTokens {
+        if subtle.ConstantTimeCompare([]byte(bearer), []byte(token.Token)) != 1 {
+            continue
+        }
+        if r.Method != http.MethodGet {
+            return nil, http.StatusForbidden, "Observer tokens are read-only"
+        }
+        if len(token.Endpoints) > 0 && !containsString(token.Endpoints, endpoint) {
+            return nil, http.StatusForbidden, "Not an endpoint of this observer token"
+        }
+        return &observerScope{name: token.Name, tenants: token.Tenants}, 0, ""
+    }
+    switch {
+    case open:
+        return &observerScope{}, 0, ""
+    case cfg.AdminToken == "" && len(observerTokens) == 0:
+        return nil, http.StatusForbidden, "Admin API disabled"
+    }
+    return nil, http.StatusUnauthorized, "Unauthorized"
+}
+
+// readScope is observerAccess for handlers answering errors as plain text.
+func readScope(w http.ResponseWriter, r *http.Request, endpoint string, open bool) (*observerScope, bool) {
+    scope, status, text := observerAccess(r, endpoint, open)
+    if scope == nil {
+        http.Error(w, text, status)
+        return nil, false
+    }
+    return scope, true
+}
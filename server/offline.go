@@ -0,0 +1,113 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "time"
+)
+
+// queuedMessage is a selective message held for a target that dropped out
+// within its resume grace.
+type queuedMessage struct {
+    msg      *Message
+    queuedAt time.Time
+}
+
+var (
+    offlineQueues   = make(map[string][]queuedMessage) // Keyed by offlineKey
+    offlineQueuesMu sync.Mutex
+)
+
+func offlineKey(roomId string, clientId string) string {
+    return roomId + "/" + clientId
+}
+
+// queueOffline holds msg for a disconnected target. It reports false when
+// queuing is disabled or the target's queue is full.
+func queueOffline(roomId string, clientId string, msg *Message) bool {
+    if cfg.OfflineQueueMessages <= 0 {
+        return false
+    }
+    
+    offlineQueuesMu.Lock()
+    defer offlineQueuesMu.Unlock()
+    
+    key := offlineKey(roomId, clientId)
+    queue := unexpired(offlineQueues[key], time.Now())
+    if len(queue) >= cfg.OfflineQueueMessages {
+        offlineQueues[key] = queue
+        return false
+    }
+    offlineQueues[key] = append(queue, queuedMessage{msg: msg, queuedAt: time.Now()})
+    return true
+}
+
+// deliverOfflineQueue flushes whatever was queued while the client was away.
+// Called once the client is active again so messages follow the welcome.
+// Messages whose journal sequence falls in [skipFrom, skipTo] were already
+// replayed and are dropped.
+func deliverOfflineQueue(client *Client, skipFrom uint64, skipTo uint64) {
+    key := offlineKey(client.room, client.clientId)
+    
+    offlineQueuesMu.Lock()
+    queue := unexpired(offlineQueues[key], time.Now())
+    delete(offlineQueues, key)
+    offlineQueuesMu.Unlock()
+    
+    if len(queue) == 0 {
+        return
+    }
+    log.Printf("Delivering %d queued messages to client %s", len(queue), client.clientId)
+    for _, queued := range queue {
+        if seq := queued.msg.Seq; seq != 0 && seq >= skipFrom && seq <= skipTo {
+            continue
+        }
+        sendMessageToClient(client, queued.msg)
+    }
+}
+
+// discardOfflineQueue drops what was queued for a session that a connection
+// without its resume token took over.
+func discardOfflineQueue(roomId string, clientId string) {
+    offlineQueuesMu.Lock()
+    queue := offlineQueues[offlineKey(roomId, clientId)]
+    delete(offlineQueues, offlineKey(roomId, clientId))
+    offlineQueuesMu.Unlock()
+    
+    if len(queue) > 0 {
+        log.Printf("Discarded %d queued messages for client %s, it joined without its resume token", len(queue), clientId)
+    }
+}
+
+// unexpired drops messages older than the offline message TTL. Queues are
+// appended in order so the survivors are always a suffix.
+func unexpired(queue []queuedMessage, now time.Time) []queuedMessage {
+    for i, queued := range queue {
+        if now.Sub(queued.queuedAt) <= cfg.OfflineMessageTTL {
+            return queue[i:]
+        }
+    }
+    return nil
+}
+
+func pruneOfflineQueues() {
+    offlineQueuesMu.Lock()
+    defer offlineQueuesMu.Unlock()
+    
+    now := time.Now()
+    for key, queue := range offlineQueues {
+        if queue = unexpired(queue, now); len(queue) == 0 {
+            delete(offlineQueues, key)
+        } else {
+            offlineQueues[key] = queue
+        }
+    }
+}
+
+func startOfflineQueueJanitor() {
+    go func() {
+        for range time.Tick(time.Minute) {
+            pruneOfflineQueues()
+        }
+    }()
+}
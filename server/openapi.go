@@ -0,0 +1,597 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "reflect"
+    "regexp"
+    "strings"
+    "unicode"
+    
+    "github.com/yourusername/my-go-project/breaker"
+    "github.com/yourusername/my-go-project/storage"
+    "github.com/yourusername/my-go-project/telephony"
+)
+
+// The REST API is declared once, in apiRoutes: main registers each route's
+// handler from it, and GET /openapi.json describes every operation of the
+// table as OpenAPI 3.1, request and response schemas reflected from the Go
+// types the handlers decode and encode. Webhook bodies are described from
+// OutboxEvent. -api-docs also serves Swagger UI at /docs, loaded from a CDN.
+
+type apiRoute struct {
+    Pattern    string // ServeMux pattern
+    Handler    http.HandlerFunc
+    Operations []apiOperation
+}
+
+type apiOperation struct {
+    Method   string
+    Path     string // OpenAPI path, {name} for path parameters
+    Summary  string
+    Admin    bool
+    Required []string    // Query parameters
+    Query    []string
+    Body     interface{} // Zero value of the JSON request body
+    BodyType string      // Content type of a non-JSON request body
+    Response interface{} // Zero value of the JSON response, nil for an untyped object
+    Produces string      // Content type of a non-JSON response
+    Status   int         // Success status, 200 by default
+}
+
+// object marks a response that is a JSON object without a Go type of its own.
+type object map[string]interface{}
+
+func apiRoutes() []apiRoute {
+    var dispositionBody struct {
+        Code  string `json:"code"`
+        Notes string `json:"notes"`
+    }
+    var callbackResultBody struct {
+        Status  string `json:"status"`
+        Outcome string `json:"outcome"`
+        Retry   *bool  `json:"retry"`
+    }
+    var gapReviewBody struct {
+        Question string `json:"question"`
+        Answer   string `json:"answer"`
+        Reviewer string `json:"reviewer"`
+        Note     string `json:"note"`
+    }
+    var factBatchBody struct {
+        Tenant    string                  `json:"tenant"`
+        Template  string                  `json:"template"`
+        RoomId    string                  `json:"roomId"`
+        Source    string                  `json:"source"`
+        MessageId string                  `json:"messageId"`
+        Facts     []storage.KnowledgeFact `json:"facts"`
+    }
+    var kgSnapshotBody struct {
+        Snapshot string `json:"snapshot"`
+        Since    int64  `json:"since,omitempty"`
+    }
+    var factReviewBody struct {
+        Reviewer string `json:"reviewer"`
+        Note     string `json:"note"`
+    }
+    return []apiRoute{
+        {"/ws", handleWebSocket, []apiOperation{
+            {Method: "GET", Path: "/ws", Summary: "Join a room over WebSocket, see /protocol for the messages",
+                Required: []string{"room", "clientId", "type"},
+                Query:    []string{"tenant", "template", "role", "ticket", "metadata", "displayName", "avatar", "audioFormat", "sampleRate", "channels"},
+                Status:   http.StatusSwitchingProtocols},
+        }},
+        {"/register", handleRegister, []apiOperation{
+            {Method: "POST", Path: "/register", Summary: "Register a server", Body: ServerInfo{}, Response: ServerInfo{}, Status: http.StatusCreated},
+        }},
+        {"/heartbeat", handleHeartbeat, []apiOperation{
+            {Method: "POST", Path: "/heartbeat", Summary: "Refresh a registered server, with a checkpoint of its rooms for failover", Body: heartbeat{}, Response: ServerInfo{}},
+        }},
+        {"/allocate", handleAllocate, []apiOperation{
+            {Method: "GET", Path: "/allocate", Summary: "Get a random server, and a signed ticket when tickets are enabled",
+                Query: []string{"room", "clientId", "type", "tenant", "role"}, Response: Allocation{}},
+        }},
+        {"/list", handleList, []apiOperation{
+            {Method: "GET", Path: "/list", Summary: "List all servers", Response: []ServerInfo{}},
+        }},
+        {"/v1/list", handleV1List, []apiOperation{
+            {Method: "GET", Path: "/v1/list", Summary: "Page through registered servers",
+                Query: []string{"region", "healthy", "limit", "after"}, Response: apiList{Data: []serverView{}}},
+        }},
+        {"/rooms", handleRoomList, []apiOperation{
+            {Method: "GET", Path: "/rooms", Summary: "List active rooms",
+                Query: []string{"tenant", "template", "minParticipants", "createdAfter", "sort", "limit", "after"}, Response: []object{}},
+        }},
+        {"/v1/rooms", handleV1Rooms, []apiOperation{
+            {Method: "GET", Path: "/v1/rooms", Summary: "Page through active rooms",
+                Query: []string{"tenant", "template", "minParticipants", "createdAfter", "sort", "limit", "after"}, Response: apiList{Data: []object{}}},
+        }},
+        {"/room/", handleRoomInfo, []apiOperation{
+            {Method: "GET", Path: "/room/{roomId}", Summary: "Get room information"},
+            {Method: "GET", Path: "/room/{roomId}/messages", Summary: "Page through chat history",
+                Query: []string{"before", "limit", "clientId"}},
+            {Method: "POST", Path: "/room/{roomId}/files", Summary: "Share a file with the room",
+                Required: []string{"clientId", "name"}, Query: []string{"to"}, BodyType: "application/octet-stream", Response: SharedFile{}},
+            {Method: "GET", Path: "/room/{roomId}/summary", Summary: "Call summary, topic segments and recordings", Admin: true},
+            {Method: "GET", Path: "/room/{roomId}/segments", Summary: "Topic segments of a call", Admin: true},
+            {Method: "POST", Path: "/room/{roomId}/segments", Summary: "Re-segment a call's transcript into topics", Admin: true},
+            {Method: "GET", Path: "/room/{roomId}/verification", Summary: "Caller identity verification state", Admin: true},
+            {Method: "GET", Path: "/room/{roomId}/quality", Summary: "Post-call quality scores", Admin: true, Response: storage.CDRQuality{}},
+            {Method: "GET", Path: "/room/{roomId}/stats", Summary: "Live usage and estimated spend of a call", Admin: true},
+            {Method: "GET", Path: "/room/{roomId}/clips/{messageId}", Summary: "Audio of a final transcript, with -clips-dir", Admin: true, Produces: "audio/wav"},
+        }},
+        {"/broadcast", handleBroadcast, []apiOperation{
+            {Method: "POST", Path: "/broadcast", Summary: "Send an announcement to matching rooms", Admin: true, Body: BroadcastRequest{}},
+        }},
+        {"/admin/rooms/", handleBulkRooms, []apiOperation{
+            {Method: "POST", Path: "/admin/rooms/close", Summary: "End matching calls", Admin: true, Body: BulkRoomRequest{}, Response: BulkRoomReport{}},
+            {Method: "POST", Path: "/admin/rooms/move", Summary: "Have matching calls reconnect to another server", Admin: true, Body: BulkRoomRequest{}, Response: BulkRoomReport{}},
+            {Method: "POST", Path: "/admin/rooms/refresh", Summary: "Re-pin matching rooms to the current snapshot and push their config", Admin: true, Body: BulkRoomRequest{}, Response: BulkRoomReport{}},
+            {Method: "POST", Path: "/admin/rooms/migrate", Summary: "Hand matching calls, live, to another server", Admin: true, Body: BulkRoomRequest{}, Response: BulkRoomReport{}},
+            {Method: "POST", Path: "/admin/rooms/import", Summary: "Take over a call migrated from another server", Admin: true, Body: RoomMigration{}, Status: http.StatusCreated},
+        }},
+        {"/admin/drain", handleDrain, []apiOperation{
+            {Method: "GET", Path: "/admin/drain", Summary: "Drain progress", Admin: true, Response: DrainStatus{}},
+            {Method: "POST", Path: "/admin/drain", Summary: "Stop taking new calls, moving those still open after the timeout", Admin: true, Body: DrainRequest{}, Response: DrainStatus{}},
+            {Method: "DELETE", Path: "/admin/drain", Summary: "Take the server back into service", Admin: true, Response: DrainStatus{}},
+        }},
+        {"/files/", handleFileDownload, []apiOperation{
+            {Method: "GET", Path: "/files/{fileId}", Summary: "Download a shared file", Produces: "application/octet-stream"},
+        }},
+        {"/search", handleSearch, []apiOperation{
+            {Method: "GET", Path: "/search", Summary: "Search indexed conversations", Admin: true,
+                Required: []string{"q"}, Query: []string{"tenant", "roomId", "kind"}},
+        }},
+        {"/voices", handleVoices, []apiOperation{
+            {Method: "GET", Path: "/voices", Summary: "Voice catalog of the configured TTS providers", Query: []string{"provider", "language"}},
+        }},
+        {"/admin/stt/phrases/", handlePhrases, []apiOperation{
+            {Method: "GET", Path: "/admin/stt/phrases/{tenant}", Summary: "List speech recognition phrase hints", Admin: true, Response: []storage.Phrase{}},
+            {Method: "POST", Path: "/admin/stt/phrases/{tenant}", Summary: "Add a phrase hint", Admin: true, Body: storage.Phrase{}, Response: storage.Phrase{}},
+            {Method: "PUT", Path: "/admin/stt/phrases/{tenant}", Summary: "Replace a tenant's phrase hints", Admin: true, Body: []storage.Phrase{}, Status: http.StatusNoContent},
+            {Method: "DELETE", Path: "/admin/stt/phrases/{tenant}/{term}", Summary: "Remove a phrase hint", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/tts/lexicon/", handleLexicon, []apiOperation{
+            {Method: "GET", Path: "/admin/tts/lexicon/{tenant}", Summary: "List pronunciations", Admin: true, Response: []storage.Pronunciation{}},
+            {Method: "POST", Path: "/admin/tts/lexicon/{tenant}", Summary: "Add a pronunciation", Admin: true, Body: storage.Pronunciation{}, Response: storage.Pronunciation{}},
+            {Method: "PUT", Path: "/admin/tts/lexicon/{tenant}", Summary: "Replace a tenant's lexicon", Admin: true, Body: []storage.Pronunciation{}, Status: http.StatusNoContent},
+            {Method: "DELETE", Path: "/admin/tts/lexicon/{tenant}/{written}", Summary: "Remove a pronunciation", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/analytics", handleAnalytics, []apiOperation{
+            {Method: "GET", Path: "/analytics", Summary: "Call rollups, as JSON or CSV, also to observer tokens", Admin: true,
+                Required: []string{"granularity"}, Query: []string{"from", "to", "tenant", "groupBy", "format"}},
+        }},
+        {"/analytics/agents", handleAgentAnalytics, []apiOperation{
+            {Method: "GET", Path: "/analytics/agents", Summary: "Agent performance, as JSON or CSV", Admin: true,
+                Required: []string{"granularity"}, Query: []string{"from", "to", "tenant", "agentId", "groupBy", "format"}},
+        }},
+        {"/admin/audit", handleAudit, []apiOperation{
+            {Method: "GET", Path: "/admin/audit", Summary: "Security audit log", Admin: true,
+                Query: []string{"tenant", "roomId", "action", "subject", "from", "to", "limit"}},
+        }},
+        {"/admin/watermark/detect", handleWatermarkDetect, []apiOperation{
+            {Method: "POST", Path: "/admin/watermark/detect", Summary: "Trace WAV or pcm16 audio back to the call it was watermarked in", Admin: true,
+                BodyType: "application/octet-stream"},
+        }},
+        {"/admin/answers", handleAnswers, []apiOperation{
+            {Method: "GET", Path: "/admin/answers", Summary: "Cached virtual agent answers", Admin: true, Query: []string{"tenant"}},
+            {Method: "DELETE", Path: "/admin/answers", Summary: "Clear cached answers", Admin: true, Query: []string{"tenant"}},
+        }},
+        {"/admin/answers/", handleAnswers, []apiOperation{
+            {Method: "POST", Path: "/admin/answers/invalidate", Summary: "Invalidate cached answers", Admin: true},
+        }},
+        {"/admin/experiments", handleExperiments, []apiOperation{
+            {Method: "GET", Path: "/admin/experiments", Summary: "List A/B experiments", Admin: true, Response: []Experiment{}},
+        }},
+        {"/admin/experiments/", handleExperiments, []apiOperation{
+            {Method: "GET", Path: "/admin/experiments/{name}", Summary: "Get an experiment", Admin: true, Response: Experiment{}},
+            {Method: "PUT", Path: "/admin/experiments/{name}", Summary: "Create or replace an experiment", Admin: true, Body: Experiment{}, Response: Experiment{}},
+            {Method: "DELETE", Path: "/admin/experiments/{name}", Summary: "Delete an experiment", Admin: true, Status: http.StatusNoContent},
+            {Method: "GET", Path: "/admin/experiments/{name}/report", Summary: "Per variant report of an experiment", Admin: true},
+        }},
+        {"/admin/shadow", handleShadowTurns, []apiOperation{
+            {Method: "GET", Path: "/admin/shadow", Summary: "Shadow agent answers beside production", Admin: true,
+                Query: []string{"tenant", "roomId", "candidate", "limit"}},
+        }},
+        {"/admin/speakers/", handleSpeakers, []apiOperation{
+            {Method: "GET", Path: "/admin/speakers/{tenant}/{customerId}", Summary: "Voiceprint consent", Admin: true, Response: storage.Consent{}},
+            {Method: "DELETE", Path: "/admin/speakers/{tenant}/{customerId}", Summary: "Withdraw consent and erase the voiceprint", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/breakers", handleBreakers, []apiOperation{
+            {Method: "GET", Path: "/admin/breakers", Summary: "Circuit breaker states", Admin: true, Response: []breaker.Status{}},
+        }},
+        {"/admin/breakers/", handleBreakers, []apiOperation{
+            {Method: "POST", Path: "/admin/breakers/{name}/reset", Summary: "Close a circuit breaker", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/callbacks", handleCallbacks, []apiOperation{
+            {Method: "GET", Path: "/admin/callbacks", Summary: "Callback queue", Admin: true},
+            {Method: "POST", Path: "/admin/callbacks", Summary: "Queue a callback", Admin: true, Body: storage.Callback{}, Response: storage.Callback{}, Status: http.StatusCreated},
+        }},
+        {"/admin/callbacks/", handleCallbacks, []apiOperation{
+            {Method: "POST", Path: "/admin/callbacks/claim", Summary: "Claim the next due callback, 204 when none is due", Admin: true,
+                Required: []string{"worker"}, Query: []string{"tenant"}, Response: storage.Callback{}},
+            {Method: "GET", Path: "/admin/callbacks/{id}", Summary: "Get a callback", Admin: true, Response: storage.Callback{}},
+            {Method: "POST", Path: "/admin/callbacks/{id}/result", Summary: "Record a callback attempt's result", Admin: true, Body: callbackResultBody, Response: storage.Callback{}},
+        }},
+        {"/admin/knowledge/", handleKnowledge, []apiOperation{
+            {Method: "GET", Path: "/admin/knowledge/gaps", Summary: "Knowledge gaps, most asked first (status defaults to open)", Admin: true,
+                Query: []string{"tenant", "status", "limit"}, Response: []storage.KnowledgeGap{}},
+            {Method: "GET", Path: "/admin/knowledge/gaps/{id}", Summary: "Get a knowledge gap with its call context", Admin: true, Response: storage.KnowledgeGap{}},
+            {Method: "POST", Path: "/admin/knowledge/gaps/{id}/approve", Summary: "Answer a knowledge gap, adding an FAQ entry", Admin: true,
+                Body: gapReviewBody, Response: storage.FAQEntry{}, Status: http.StatusCreated},
+            {Method: "POST", Path: "/admin/knowledge/gaps/{id}/dismiss", Summary: "Close a knowledge gap without an answer", Admin: true, Body: gapReviewBody, Response: storage.KnowledgeGap{}},
+            {Method: "GET", Path: "/admin/knowledge/faq", Summary: "Curated FAQ entries", Admin: true, Query: []string{"tenant"}, Response: []storage.FAQEntry{}},
+            {Method: "POST", Path: "/admin/knowledge/faq", Summary: "Add an FAQ entry", Admin: true, Body: storage.FAQEntry{}, Response: storage.FAQEntry{}, Status: http.StatusCreated},
+            {Method: "DELETE", Path: "/admin/knowledge/faq/{id}", Summary: "Delete an FAQ entry", Admin: true, Status: http.StatusNoContent},
+            {Method: "GET", Path: "/admin/knowledge/facts", Summary: "Extracted facts, oldest first (status defaults to pending)", Admin: true,
+                Query: []string{"tenant", "status", "roomId", "limit"}, Response: []storage.KnowledgeFact{}},
+            {Method: "POST", Path: "/admin/knowledge/facts", Summary: "Submit extracted facts, approving those that need no review", Admin: true,
+                Body: factBatchBody, Status: http.StatusCreated},
+            {Method: "GET", Path: "/admin/knowledge/facts/{id}", Summary: "Get an extracted fact", Admin: true, Response: storage.KnowledgeFact{}},
+            {Method: "POST", Path: "/admin/knowledge/facts/{id}/approve", Summary: "Approve a pending fact into the graph", Admin: true, Body: factReviewBody, Response: storage.KnowledgeFact{}},
+            {Method: "POST", Path: "/admin/knowledge/facts/{id}/reject", Summary: "Reject a pending fact", Admin: true, Body: factReviewBody, Response: storage.KnowledgeFact{}},
+        }},
+        {"/admin/knowledge/snapshot", handleKGSnapshot, []apiOperation{
+            {Method: "GET", Path: "/admin/knowledge/snapshot", Summary: "Knowledge graph snapshot new rooms are pinned to", Admin: true, Response: kgSnapshotBody},
+            {Method: "POST", Path: "/admin/knowledge/snapshot", Summary: "Make a knowledge graph snapshot current for new rooms", Admin: true, Body: kgSnapshotBody, Response: kgSnapshotBody},
+        }},
+        {"/admin/calls", handleCalls, []apiOperation{
+            {Method: "GET", Path: "/admin/calls", Summary: "Outbound calls in progress", Admin: true, Response: []outboundCall{}},
+            {Method: "POST", Path: "/admin/calls", Summary: "Place an outbound call through -telephony-url", Admin: true, Body: outboundRequest{}, Response: outboundCall{}, Status: http.StatusCreated},
+        }},
+        {"/admin/calls/", handleCalls, []apiOperation{
+            {Method: "GET", Path: "/admin/calls/{id}", Summary: "Get an outbound call", Admin: true, Response: outboundCall{}},
+            {Method: "DELETE", Path: "/admin/calls/{id}", Summary: "Hang up an outbound call", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/wrapups", handleWrapUps, []apiOperation{
+            {Method: "GET", Path: "/admin/wrapups", Summary: "Open wrap-ups", Admin: true, Response: []wrapUp{}},
+        }},
+        {"/admin/wrapups/", handleWrapUps, []apiOperation{
+            {Method: "POST", Path: "/admin/wrapups/{id}", Summary: "Dispose a wrap-up", Admin: true, Body: dispositionBody, Response: wrapUp{}},
+        }},
+        {"/admin/agents", handleAgents, []apiOperation{
+            {Method: "GET", Path: "/admin/agents", Summary: "Agent workers, their capacity and load", Admin: true, Response: []agentWorker{}},
+            {Method: "POST", Path: "/admin/agents", Summary: "Register an agent worker", Admin: true, Body: agentWorker{}, Response: agentWorker{}},
+        }},
+        {"/admin/agents/", handleAgents, []apiOperation{
+            {Method: "DELETE", Path: "/admin/agents/{id}", Summary: "Remove an agent worker", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/campaigns", handleCampaigns, []apiOperation{
+            {Method: "GET", Path: "/admin/campaigns", Summary: "Outbound dialing campaigns", Admin: true},
+            {Method: "POST", Path: "/admin/campaigns", Summary: "Create a campaign", Admin: true, Body: campaignRequest{}, Response: storage.Campaign{}, Status: http.StatusCreated},
+        }},
+        {"/admin/campaigns/", handleCampaigns, []apiOperation{
+            {Method: "GET", Path: "/admin/campaigns/{id}", Summary: "Get a campaign", Admin: true, Response: storage.Campaign{}},
+            {Method: "POST", Path: "/admin/campaigns/{id}/start", Summary: "Start or resume a campaign", Admin: true, Response: storage.Campaign{}},
+            {Method: "POST", Path: "/admin/campaigns/{id}/pause", Summary: "Pause a campaign", Admin: true, Response: storage.Campaign{}},
+            {Method: "POST", Path: "/admin/campaigns/{id}/cancel", Summary: "Cancel a campaign", Admin: true, Response: storage.Campaign{}},
+            {Method: "GET", Path: "/admin/campaigns/{id}/targets", Summary: "A campaign's targets", Admin: true, Response: []storage.CampaignTarget{}},
+            {Method: "POST", Path: "/admin/campaigns/{id}/targets", Summary: "Add targets to a campaign", Admin: true, Body: []storage.CampaignTarget{}, Status: http.StatusCreated},
+        }},
+        {"/telephony/status/", handleTelephonyStatus, []apiOperation{
+            {Method: "POST", Path: "/telephony/status/{id}", Summary: "Call progress from the voice gateway", Required: []string{"token"}, Body: telephony.Status{}, Status: http.StatusNoContent},
+        }},
+        {"/metrics", handleMetrics, []apiOperation{
+            {Method: "GET", Path: "/metrics", Summary: "Prometheus metrics labelled by tenant", Produces: "text/plain"},
+        }},
+        {"/admin/metadata-schema/", handleMetadataSchema, []apiOperation{
+            {Method: "GET", Path: "/admin/metadata-schema/{tenant}", Summary: "Get a tenant's metadata schema", Admin: true, Response: MetadataSchema{}},
+            {Method: "PUT", Path: "/admin/metadata-schema/{tenant}", Summary: "Set a tenant's metadata schema", Admin: true, Body: MetadataSchema{}, Response: MetadataSchema{}},
+            {Method: "DELETE", Path: "/admin/metadata-schema/{tenant}", Summary: "Remove a tenant's metadata schema", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/hold/", handleHoldAssets, []apiOperation{
+            {Method: "GET", Path: "/admin/hold/{tenant}", Summary: "Hold assets by situation, queue, hold and thinking; _default for every tenant's fallback", Admin: true,
+                Response: map[string]HoldAsset{}},
+            {Method: "PUT", Path: "/admin/hold/{tenant}/{kind}", Summary: "Set a situation's comfort phrases", Admin: true, Body: HoldAsset{}, Response: HoldAsset{}},
+            {Method: "DELETE", Path: "/admin/hold/{tenant}/{kind}", Summary: "Remove a situation's phrases and music", Admin: true, Status: http.StatusNoContent},
+            {Method: "PUT", Path: "/admin/hold/{tenant}/{kind}/music", Summary: "Upload a situation's hold music as WAV", Admin: true,
+                BodyType: "audio/wav", Response: HoldAsset{}},
+            {Method: "DELETE", Path: "/admin/hold/{tenant}/{kind}/music", Summary: "Remove a situation's hold music", Admin: true, Response: HoldAsset{}},
+        }},
+        {"/admin/retention/", handleRetentionPolicy, []apiOperation{
+            {Method: "GET", Path: "/admin/retention/{tenant}", Summary: "Chat history retention, _default for the server's", Admin: true, Response: RetentionPolicy{}},
+            {Method: "PUT", Path: "/admin/retention/{tenant}", Summary: "Set chat history retention", Admin: true, Body: RetentionPolicy{}, Response: RetentionPolicy{}},
+            {Method: "DELETE", Path: "/admin/retention/{tenant}", Summary: "Reset a tenant to the default retention", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/admin/ipfilter", handleIPFilter, []apiOperation{
+            {Method: "GET", Path: "/admin/ipfilter", Summary: "IP allow and deny lists", Admin: true},
+            {Method: "PUT", Path: "/admin/ipfilter", Summary: "Replace the IP allow and deny lists", Admin: true, Body: IPFilter{}, Response: IPFilter{}},
+        }},
+        {"/admin/logs/stream", handleLogStream, []apiOperation{
+            {Method: "GET", Path: "/admin/logs/stream", Summary: "Tail the server log as server-sent events", Admin: true,
+                Query: []string{"level", "roomId", "clientId", "backlog"}, Produces: "text/event-stream"},
+        }},
+        {"/admin/room/", handleAdminRoom, []apiOperation{
+            {Method: "POST", Path: "/admin/room/{roomId}/trace", Summary: "Start capturing a room trace", Admin: true, Query: []string{"minutes"}},
+            {Method: "GET", Path: "/admin/room/{roomId}/trace", Summary: "Download a room trace", Admin: true, Produces: "application/x-ndjson"},
+            {Method: "DELETE", Path: "/admin/room/{roomId}/trace", Summary: "Stop and discard a room trace", Admin: true, Status: http.StatusNoContent},
+        }},
+        {"/protocol", handleProtocol, []apiOperation{
+            {Method: "GET", Path: "/protocol", Summary: "AsyncAPI description of the WebSocket messages"},
+        }},
+        {"/openapi.json", handleOpenAPI, []apiOperation{
+            {Method: "GET", Path: "/openapi.json", Summary: "This document"},
+        }},
+    }
+}
+
+// apiList is the /v1/ list envelope, see registryapi.go.
+type apiList struct {
+    Data      interface{} `json:"data"`
+    Total     int         `json:"total"`
+    HasMore   bool        `json:"hasMore"`
+    NextAfter string      `json:"nextAfter,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument describes apiRoutes.
+func openAPIDocument() map[string]interface{} {
+    schemas := openAPISchemas{
+        "Error": map[string]interface{}{"type": "string", "description": "Plain text error message"},
+        "APIError": map[string]interface{}{
+            "type":     "object",
+            "required": []string{"error"},
+            "properties": map[string]interface{}{
+                "error": map[string]interface{}{
+                    "type": "object",
+                    "properties": map[string]interface{}{
+                        "status":  map[string]interface{}{"type": "integer"},
+                        "message": map[string]interface{}{"type": "string"},
+                    },
+                },
+            },
+        },
+    }
+    paths := make(map[string]map[string]interface{})
+    for _, route := range apiRoutes() {
+        if !servesRoute(route.Pattern) {
+            continue
+        }
+        for _, op := range route.Operations {
+            if paths[op.Path] == nil {
+                paths[op.Path] = make(map[string]interface{})
+            }
+            paths[op.Path][strings.ToLower(op.Method)] = schemas.operation(op)
+        }
+    }
+    return map[string]interface{}{
+        "openapi": "3.1.0",
+        "info": map[string]interface{}{
+            "title":   "IVA media server",
+            "version": "1",
+        },
+        "paths": paths,
+        "webhooks": map[string]interface{}{
+            "roomEvent": map[string]interface{}{
+                "post": map[string]interface{}{
+                    "summary":     "Room lifecycle event POSTed to -webhook-url, at least once; dedupe on id",
+                    "operationId": "roomEvent",
+                    "parameters": []interface{}{map[string]interface{}{
+                        "name": "X-IVA-Signature", "in": "header",
+                        "description": "sha256= and the hex HMAC-SHA256 of the body under -webhook-secret",
+                        "schema":      map[string]interface{}{"type": "string"},
+                    }},
+                    "requestBody": map[string]interface{}{
+                        "required": true,
+                        "content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(OutboxEvent{}))}},
+                    },
+                    "responses": map[string]interface{}{
+                        "2XX": map[string]interface{}{"description": "Delivered"},
+                        "4XX": map[string]interface{}{"description": "Dropped, not retried"},
+                        "5XX": map[string]interface{}{"description": "Retried"},
+                    },
+                },
+            },
+        },
+        "components": map[string]interface{}{
+            "schemas": schemas,
+            "securitySchemes": map[string]interface{}{
+                "adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "-admin-token"},
+            },
+        },
+    }
+}
+
+// openAPISchemas are the document's component schemas, by name.
+type openAPISchemas map[string]interface{}
+
+func (s openAPISchemas) operation(op apiOperation) map[string]interface{} {
+    operation := map[string]interface{}{
+        "summary":     op.Summary,
+        "operationId": operationId(op),
+    }
+    var params []interface{}
+    for _, match := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+        params = append(params, map[string]interface{}{"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}})
+    }
+    for _, name := range op.Required {
+        params = append(params, map[string]interface{}{"name": name, "in": "query", "required": true, "schema": map[string]interface{}{"type": "string"}})
+    }
+    for _, name := range op.Query {
+        params = append(params, map[string]interface{}{"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"}})
+    }
+    if params != nil {
+        operation["parameters"] = params
+    }
+    if op.Body != nil {
+        operation["requestBody"] = map[string]interface{}{
+            "required": true,
+            "content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(op.Body))}},
+        }
+    } else if op.BodyType != "" {
+        operation["requestBody"] = map[string]interface{}{
+            "required": true,
+            "content":  map[string]interface{}{op.BodyType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}},
+        }
+    }
+    if op.Admin {
+        operation["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+    }
+    
+    status := op.Status
+    if status == 0 {
+        status = http.StatusOK
+    }
+    success := map[string]interface{}{"description": http.StatusText(status)}
+    switch {
+    case status == http.StatusNoContent || status == http.StatusSwitchingProtocols:
+    case op.Produces != "":
+        success["content"] = map[string]interface{}{op.Produces: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+    case op.Response != nil:
+        schema := s.of(reflect.TypeOf(op.Response))
+        if list, ok := op.Response.(apiList); ok {
+            schema = map[string]interface{}{"allOf": []interface{}{schema, map[string]interface{}{
+                "properties": map[string]interface{}{"data": s.of(reflect.TypeOf(list.Data))},
+            }}}
+        }
+        success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+    default:
+        success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}}
+    }
+    failure := map[string]interface{}{
+        "description": "Error",
+        "content":     map[string]interface{}{"text/plain": map[string]interface{}{"schema": schemaRef("Error")}},
+    }
+    if strings.HasPrefix(op.Path, "/v1/") {
+        failure["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef("APIError")}}
+    }
+    operation["responses"] = map[string]interface{}{fmt.Sprint(status): success, "default": failure}
+    return operation
+}
+
+// operationId is the method and the path's words, e.g. getRoomStats.
+func operationId(op apiOperation) string {
+    id := strings.ToLower(op.Method)
+    for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+        id += strings.ToUpper(part[:1]) + part[1:]
+    }
+    return id
+}
+
+func schemaRef(name string) map[string]interface{} {
+    return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// of is the schema of values of t as encoding/json writes them. Named
+// structs become component schemas.
+func (s openAPISchemas) of(t reflect.Type) map[string]interface{} {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == reflect.TypeOf(object{}) {
+        return map[string]interface{}{"type": "object"}
+    }
+    switch t.Kind() {
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return map[string]interface{}{"type": "string", "format": "byte"}
+        }
+        return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+    case reflect.Struct:
+        if t.Name() == "" {
+            return s.object(t)
+        }
+        if t.Name() == "Time" && t.PkgPath() == "time" {
+            return map[string]interface{}{"type": "string", "format": "date-time"}
+        }
+        // storage's are the data model; other packages' names, like
+        // breaker.Status and telephony.Status, are only unique qualified
+        name := t.Name()
+        if pkg := path.Base(t.PkgPath()); pkg != "main" && pkg != "storage" {
+            name = pkg + name
+        }
+        name = strings.ToUpper(name[:1]) + name[1:]
+        if _, ok := s[name]; !ok {
+            s[name] = map[string]interface{}{} // Placeholder for recursive types
+            s[name] = s.object(t)
+        }
+        return schemaRef(name)
+    }
+    return map[string]interface{}{}
+}
+
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+    properties := make(map[string]interface{})
+    var required []string
+    s.fields(t, properties, &required)
+    schema := map[string]interface{}{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        schema["required"] = required
+    }
+    return schema
+}
+
+// fields adds t's JSON fields, those of embedded structs included.
+func (s openAPISchemas) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        tag := field.Tag.Get("json")
+        if tag == "-" {
+            continue
+        }
+        if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+            s.fields(field.Type, properties, required)
+            continue
+        }
+        if field.PkgPath != "" {
+            continue
+        }
+        switch field.Type.Kind() {
+        case reflect.Func, reflect.Chan:
+            continue
+        }
+        name, options, _ := strings.Cut(tag, ",")
+        if name == "" {
+            name = field.Name
+        }
+        properties[name] = s.of(field.Type)
+        if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+            *required = append(*required, name)
+        }
+    }
+}
+
+// GET /openapi.json
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Access-Control-Allow-Origin", "*")
+    json.NewEncoder(w).Encode(openAPIDocument())
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>IVA media server API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// GET /docs, with -api-docs
+func handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    fmt.Fprint(w, swaggerUIPage)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+)
+
+// Origin checking. Browsers send the page's origin on the WebSocket upgrade,
+// and without a check any web page could open a call with its visitor's
+// cookies and network. -allowed-origins (ALLOWED_ORIGINS) lists the origins
+// allowed, each one of
+//
+//   https://app.example.com   that origin exactly
+//   app.example.com           the host over any scheme
+//   *.example.com             any subdomain, https://*.example.com over https only
+//   *                         everything
+//
+// a port being part of the host. -origins-file adds more from a JSON object
+// of room template to list: "_default" adds to the flag's, and a template's
+// own list replaces both for its rooms,
+//
+//   {"_default": ["https://app.example.com"], "kiosk": ["https://kiosk.example.com"]}
+//
+// A room keeps the list of the template it was created with. Pages the
+// server serves itself (see /demo/) are always allowed, as are connections
+// without an Origin, which aren't browsers: the SDKs, bots and telephony.
+// With no list at all only those are, every other page being refused until
+// its origin, or "*", is listed. Refusals answer 403 and count in
+// iva_origin_rejections_total.
+
+var (
+    defaultOrigins  []string
+    templateOrigins map[string][]string
+)
+
+var originRejections = newCounterVec("iva_origin_rejections_total", "WebSocket upgrades refused for their Origin.")
+
+func init() {
+    metricSeries = append(metricSeries, originRejections)
+}
+
+// loadOrigins checks -allowed-origins and reads -origins-file.
+func loadOrigins() error {
+    lists := map[string][]string{}
+    if cfg.OriginsFile != "" {
+        data, err := os.ReadFile(cfg.OriginsFile)
+        if err != nil {
+            return err
+        }
+        if err := json.Unmarshal(data, &lists); err != nil {
+            return fmt.Errorf("%s: %v", cfg.OriginsFile, err)
+        }
+    }
+    lists["_default"] = append(append([]string(nil), cfg.AllowedOrigins...), lists["_default"]...)
+    for template, patterns := range lists {
+        for _, pattern := range patterns {
+            if err := checkOriginPattern(pattern); err != nil {
+                return fmt.Errorf("%s: %v", template, err)
+            }
+        }
+    }
+    defaultOrigins = lists["_default"]
+    delete(lists, "_default")
+    templateOrigins = lists
+    return nil
+}
+
+func checkOriginPattern(pattern string) error {
+    if pattern == "*" {
+        return nil
+    }
+    host := pattern
+    if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+        if scheme == "" {
+            return fmt.Errorf("origin %q has no scheme before ://", pattern)
+        }
+        host = rest
+    }
+    host = strings.TrimPrefix(host, "*.")
+    if host == "" || strings.ContainsAny(host, "*/") {
+        return fmt.Errorf("origin %q: use a host, scheme://host or *.domain", pattern)
+    }
+    return nil
+}
+
+// originsFor is the allowlist of a room template's rooms.
+func originsFor(template string) []string {
+    if list, ok := templateOrigins[template]; ok && template != "" {
+        return list
+    }
+    return defaultOrigins
+}
+
+func originMatches(pattern string, origin *url.URL) bool {
+    if pattern == "*" {
+        return true
+    }
+    host := strings.ToLower(pattern)
+    if scheme, rest, ok := strings.Cut(host, "://"); ok {
+        if scheme != strings.ToLower(origin.Scheme) {
+            return false
+        }
+        host = rest
+    }
+    originHost := strings.ToLower(origin.Host)
+    if domain, ok := strings.CutPrefix(host, "*."); ok {
+        return strings.HasSuffix(originHost, "."+domain)
+    }
+    return originHost == host
+}
+
+// checkOrigin is the upgrader's CheckOrigin. An existing room is checked
+// against its template's list, a new one against the template asked for.
+func checkOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    parsed, err := url.Parse(origin)
+    if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+        return true
+    }
+    
+    query := r.URL.Query()
+    roomId, template := query.Get("room"), query.Get("template")
+    var labels []string
+    roomsMu.RLock()
+    if room := rooms[roomId]; room != nil {
+        template, labels = room.Template, room.labels
+    }
+    roomsMu.RUnlock()
+    
+    if err == nil && parsed.Host != "" {
+        for _, pattern := range originsFor(template) {
+            if originMatches(pattern, parsed) {
+                return true
+            }
+        }
+    }
+    originRejections.inc(labels)
+    logAt("warn", roomId, query.Get("clientId"), "Connection refused: origin %s is not allowed", logValue(origin))
+    return false
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/telephony"
+)
+
+// Outbound calls. POST /admin/calls asks the voice gateway (-telephony-url)
+// to dial a number. The gateway connects the answered call as a user client
+// of a fresh room, over a join URL built on -public-url, and reports progress
+// to /telephony/status/ID. Once a human answers, the virtual agent is asked
+// to join (-agent-join-url, the bot's /join). When answering machine
+// detection (amd.go) says a machine picked up, the call is hung up or the
+// agent leaves the message it was given. Campaigns (campaigns.go) place their calls here.
+
+// Call outcomes, recorded when a call ends.
+const (
+    OutcomeAnswered = "answered"
+    OutcomeMachine  = "machine"
+    OutcomeBusy     = "busy"
+    OutcomeNoAnswer = "no_answer"
+    OutcomeFailed   = "failed"
+)
+
+const (
+    OnMachineHangup  = "hangup"
+    OnMachineMessage = "message"
+)
+
+// outboundRequest is the body of POST /admin/calls.
+type outboundRequest struct {
+    To            string                 `json:"to"`
+    From          string                 `json:"from,omitempty"`
+    Tenant        string                 `json:"tenant,omitempty"`
+    Template      string                 `json:"template,omitempty"`
+    RoomId        string                 `json:"roomId,omitempty"` // Generated when empty
+    CustomerId    string                 `json:"customerId,omitempty"`
+    Metadata      map[string]interface{} `json:"metadata,omitempty"` // The callee's initial metadata
+    Agent         *bool                  `json:"agent,omitempty"`    // Attach the virtual agent on answer, default true
+    Greeting      string                 `json:"greeting,omitempty"`
+    DetectMachine bool                   `json:"detectMachine,omitempty"`
+    OnMachine     string                 `json:"onMachine,omitempty"` // hangup (default) or message
+    Message       string                 `json:"message,omitempty"`   // Left on answering machines
+}
+
+// outboundCall is a call placed through the gateway, live until it ends.
+type outboundCall struct {
+    Id         string `json:"id"`
+    CallId     string `json:"callId,omitempty"` // The gateway's
+    To         string `json:"to"`
+    RoomId     string `json:"roomId"`
+    ClientId   string `json:"clientId"` // The callee in the room
+    Tenant     string `json:"tenant,omitempty"`
+    Template   string `json:"template,omitempty"`
+    CampaignId string `json:"campaignId,omitempty"`
+    TargetId   string `json:"targetId,omitempty"`
+    Status     string `json:"status"`
+    AnsweredBy string `json:"answeredBy,omitempty"`
+    StartedAt  int64  `json:"startedAt"` // Unix ms
+    AnsweredAt int64  `json:"answeredAt,omitempty"`
+    AMD        *amdVerdict `json:"amd,omitempty"`
+    
+    token      string
+    request    outboundRequest
+    ring       *time.Timer
+    amdTimeout *time.Timer
+    // onEnd is called once, without locks held, when the call ends.
+    onEnd func(call outboundCall, outcome string, detail string)
+}
+
+var (
+    telephonyProvider telephony.Provider // Nil unless -telephony-url is set
+    outboundCalls     = make(map[string]*outboundCall)
+    outboundMu        sync.Mutex
+    
+    outboundCallsTotal = newCounterVec("iva_outbound_calls_total", "Outbound calls ended by outcome.", "outcome")
+)
+
+func init() {
+    metricSeries = append(metricSeries, outboundCallsTotal)
+}
+
+func loadTelephony() error {
+    switch cfg.AMDMode {
+    case AMDAuto, AMDGateway, AMDAudio, AMDOff:
+    default:
+        return fmt.Errorf("unknown -amd-mode %q, use auto, gateway, audio or off", cfg.AMDMode)
+    }
+    if cfg.TelephonyURL == "" {
+        return nil
+    }
+    if cfg.PublicURL == "" {
+        return fmt.Errorf("-public-url is required with -telephony-url, the gateway joins rooms and reports call status there")
+    }
+    telephonyProvider = &telephony.HTTP{
+        URL:    cfg.TelephonyURL,
+        APIKey: cfg.TelephonyAPIKey,
+        Client: &http.Client{Timeout: 10 * time.Second},
+    }
+    return nil
+}
+
+// placeCall dials req.To. onEnd, which may be nil, learns the outcome; it is
+// not called when the gateway refuses the call.
+func placeCall(ctx context.Context, req outboundRequest, campaignId string, targetId string,
+    onEnd func(call outboundCall, outcome string, detail string)) (outboundCall, error) {
+    if telephonyProvider == nil {
+        return outboundCall{}, newCodedError(ErrInvalidMessage, "outbound calling is disabled, set -telephony-url")
+    }
+    if req.To == "" {
+        return outboundCall{}, newCodedError(ErrInvalidMessage, "to required")
+    }
+    switch req.OnMachine {
+    case "":
+        req.OnMachine = OnMachineHangup
+    case OnMachineHangup, OnMachineMessage:
+    default:
+        return outboundCall{}, newCodedError(ErrInvalidMessage, "onMachine must be hangup or message")
+    }
+    if req.From == "" {
+        req.From = cfg.TelephonyFrom
+    }
+    id := newRandomId()
+    if req.RoomId == "" {
+        req.RoomId = "out-" + id[:12]
+    }
+    call := &outboundCall{
+        Id:         id,
+        To:         req.To,
+        RoomId:     req.RoomId,
+        ClientId:   "callee-" + id[:8],
+        Tenant:     req.Tenant,
+        Template:   req.Template,
+        CampaignId: campaignId,
+        TargetId:   targetId,
+        Status:     "dialing",
+        StartedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+        token:      newRandomId(),
+        request:    req,
+        onEnd:      onEnd,
+    }
+    
+    joinURL, err := calleeJoinURL(call)
+    if err != nil {
+        return outboundCall{}, err
+    }
+    base := strings.TrimRight(cfg.PublicURL, "/")
+    
+    // Registered before dialling, the first status can beat Dial's answer
+    outboundMu.Lock()
+    outboundCalls[id] = call
+    outboundMu.Unlock()
+    
+    callId, err := telephonyProvider.Dial(ctx, telephony.Call{
+        To:            req.To,
+        From:          req.From,
+        JoinURL:       joinURL,
+        StatusURL:     base + "/telephony/status/" + id + "?token=" + call.token,
+        DetectMachine: (cfg.AMDMode == AMDAuto || cfg.AMDMode == AMDGateway) && (req.DetectMachine || req.OnMachine == OnMachineMessage || campaignId != ""),
+    })
+    outboundMu.Lock()
+    if err != nil {
+        delete(outboundCalls, id)
+        outboundMu.Unlock()
+        logAt("warn", call.RoomId, "", "Outbound call to %s failed: %v", logValue(req.To), err)
+        return outboundCall{}, err
+    }
+    call.CallId = callId
+    call.ring = time.AfterFunc(cfg.OutboundRingTimeout, func() { ringTimeout(id) })
+    snapshot := *call
+    outboundMu.Unlock()
+    
+    logAt("info", call.RoomId, call.ClientId, "Dialling %s (call %s)", logValue(req.To), callId)
+    emitEvent("outbound_call_started", &RoomInfo{RoomId: call.RoomId, Tenant: call.Tenant}, map[string]interface{}{
+        "callId":     id,
+        "to":         req.To,
+        "campaignId": campaignId,
+    })
+    return snapshot, nil
+}
+
+// calleeJoinURL is where the gateway connects the answered call: the room as
+// a user, with the callee's metadata and a ticket when tickets are on.
+func calleeJoinURL(call *outboundCall) (string, error) {
+    metadata := map[string]interface{}{}
+    for key, value := range call.request.Metadata {
+        metadata[key] = value
+    }
+    metadata["phone"] = call.To
+    metadata["outbound"] = true
+    if call.request.CustomerId != "" {
+        metadata["customerId"] = call.request.CustomerId
+    }
+    if call.CampaignId != "" {
+        metadata["campaignId"] = call.CampaignId
+    }
+    encoded, err := json.Marshal(metadata)
+    if err != nil {
+        return "", newCodedError(ErrInvalidMessage, "metadata: %v", err)
+    }
+    
+    query := url.Values{
+        "room":     {call.RoomId},
+        "clientId": {call.ClientId},
+        "type":     {string(ClientTypeUser)},
+        "metadata": {string(encoded)},
+        // Telephony audio, which machine detection and transcription can read
+        "audioFormat": {"pcm16"},
+        "sampleRate":  {strconv.Itoa(cfg.OutboundSampleRate)},
+    }
+    if call.Tenant != "" {
+        query.Set("tenant", call.Tenant)
+    }
+    if call.Template != "" {
+        query.Set("template", call.Template)
+    }
+    if ticketsEnabled() {
+        query.Set("ticket", signTicket(Ticket{
+            RoomId:     call.RoomId,
+            ClientId:   call.ClientId,
+            ClientType: ClientTypeUser,
+            Tenant:     call.Tenant,
+            Server:     cfg.PublicAddress,
+            Expiry:   time.Now().Add(cfg.OutboundRingTimeout + time.Minute).Unix(),
+        }))
+    }
+    base := strings.TrimRight(cfg.PublicURL, "/")
+    if strings.HasPrefix(base, "https://") {
+        base = "wss://" + strings.TrimPrefix(base, "https://")
+    } else {
+        base = "ws://" + strings.TrimPrefix(base, "http://")
+    }
+    return base + "/ws?" + query.Encode(), nil
+}
+
+func ringTimeout(id string) {
+    outboundMu.Lock()
+    call := outboundCalls[id]
+    answered := call != nil && call.AnsweredAt != 0
+    outboundMu.Unlock()
+    if call == nil || answered {
+        return
+    }
+    hangupCall(call.CallId)
+    endCall(id, OutcomeNoAnswer, fmt.Sprintf("not answered within %s", cfg.OutboundRingTimeout))
+}
+
+func hangupCall(callId string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := telephonyProvider.Hangup(ctx, callId); err != nil {
+        log.Printf("level=warn Hanging up call %s failed: %v", callId, err)
+    }
+}
+
+// callStatus applies a gateway progress report.
+func callStatus(id string, status telephony.Status) {
+    outboundMu.Lock()
+    call := outboundCalls[id]
+    if call == nil {
+        outboundMu.Unlock()
+        return
+    }
+    call.Status = status.Status
+    answered := status.Status == telephony.Answered && call.AnsweredAt == 0
+    if answered {
+        call.AnsweredAt = time.Now().UnixNano() / int64(time.Millisecond)
+        call.ring.Stop()
+    }
+    snapshot := *call
+    outboundMu.Unlock()
+    
+    switch {
+    case telephony.Final(status.Status):
+        endCall(id, outcomeOf(snapshot, status.Status), status.Error)
+    case answered:
+        callAnswered(snapshot, status.AnsweredBy)
+    }
+}
+
+func outcomeOf(call outboundCall, status string) string {
+    switch status {
+    case telephony.Busy:
+        return OutcomeBusy
+    case telephony.NoAnswer:
+        return OutcomeNoAnswer
+    case telephony.Failed:
+        return OutcomeFailed
+    }
+    switch {
+    case call.AnsweredBy == telephony.Machine:
+        return OutcomeMachine
+    case call.AnsweredAt != 0:
+        return OutcomeAnswered
+    }
+    return OutcomeNoAnswer
+}
+
+// answerCall attaches the agent, or deals with an answering machine.
+func answerCall(call outboundCall) {
+    if call.AnsweredBy == telephony.Machine {
+        if call.request.OnMachine != OnMachineMessage || call.request.Message == "" {
+            logAt("info", call.RoomId, call.ClientId, "Answering machine, hanging up")
+            hangupCall(call.CallId)
+            endCall(call.Id, OutcomeMachine, "hung up on answering machine")
+            return
+        }
+        logAt("info", call.RoomId, call.ClientId, "Answering machine, leaving a message")
+    }
+    if call.CampaignId != "" {
+        campaignCallAnswered(call)
+    }
+    if call.request.Agent != nil && !*call.request.Agent {
+        return
+    }
+    if err := attachAgent(call); err != nil {
+        logAt("warn", call.RoomId, "", "Attaching the agent failed: %v", err)
+    }
+}
+
+// attachAgent asks the bot to join the call's room, through the router when
+// agent workers are registered (routing.go). An answering machine gets the
+// message instead of a conversation.
+func attachAgent(call outboundCall) error {
+    request := map[string]interface{}{"campaign": call.CampaignId}
+    if call.AnsweredBy == telephony.Machine {
+        request["voicemail"] = call.request.Message
+    } else if call.request.Greeting != "" {
+        request["greeting"] = call.request.Greeting
+    }
+    if routingEnabled() {
+        if !assignRoom(call.RoomId, call.Tenant, call.Template, request) {
+            return fmt.Errorf("no agent worker can take the call")
+        }
+        return nil
+    }
+    if cfg.AgentJoinURL == "" {
+        return nil
+    }
+    request["room_id"] = call.RoomId
+    request["flow"] = call.Template
+    return postJoin(cfg.AgentJoinURL, request)
+}
+
+// endCall finishes a call once, whichever of the gateway, the ring timer or
+// the callee leaving the room notices first.
+func endCall(id string, outcome string, detail string) {
+    outboundMu.Lock()
+    call := outboundCalls[id]
+    delete(outboundCalls, id)
+    outboundMu.Unlock()
+    if call == nil {
+        return
+    }
+    if call.ring != nil {
+        call.ring.Stop()
+    }
+    if call.amdTimeout != nil {
+        call.amdTimeout.Stop()
+    }
+    
+    var connected int64
+    if call.AnsweredAt != 0 {
+        connected = time.Now().UnixNano() / int64(time.Millisecond) - call.AnsweredAt
+    }
+    outboundCallsTotal.inc(metricLabelsFor(call.Tenant, call.Template), outcome)
+    if connected > 0 && cfg.TelephonyPrice > 0 {
+        usd := float64(connected) / 60000 * cfg.TelephonyPrice
+        telephonyCostTotal.add(usd, metricLabelsFor(call.Tenant, call.Template))
+        addSpend(call.RoomId, spendTelephony, usd)
+    }
+    ended := outcome
+    if detail != "" {
+        ended += " (" + detail + ")"
+    }
+    logAt("info", call.RoomId, call.ClientId, "Outbound call to %s ended: %s", logValue(call.To), ended)
+    emitEvent("outbound_call_ended", &RoomInfo{RoomId: call.RoomId, Tenant: call.Tenant}, map[string]interface{}{
+        "callId":     call.Id,
+        "to":         call.To,
+        "campaignId": call.CampaignId,
+        "outcome":    outcome,
+        "detail":     detail,
+        "duration":   connected,
+    })
+    if call.onEnd != nil {
+        call.onEnd(*call, outcome, detail)
+    }
+}
+
+// noteOutboundLeave ends a call whose callee left the room before the
+// gateway said so. Callers hold roomsMu.
+func noteOutboundLeave(client *Client) {
+    if client.clientType != ClientTypeUser {
+        return
+    }
+    if call, ok := outboundCallOf(client); ok {
+        go endCall(call.Id, outcomeOf(call, telephony.Completed), "")
+    }
+}
+
+// outboundCallOf finds the live call a user is the callee of.
+func outboundCallOf(client *Client) (outboundCall, bool) {
+    outboundMu.Lock()
+    defer outboundMu.Unlock()
+    for _, call := range outboundCalls {
+        if call.RoomId == client.room && call.ClientId == client.clientId {
+            return *call, true
+        }
+    }
+    return outboundCall{}, false
+}
+
+// isOutboundCallee reports whether a user is the callee of an outbound call,
+// who is not waiting in a queue.
+func isOutboundCallee(client *Client) bool {
+    _, ok := outboundCallOf(client)
+    return ok
+}
+
+func liveCalls(campaignId string) []outboundCall {
+    outboundMu.Lock()
+    defer outboundMu.Unlock()
+    list := make([]outboundCall, 0)
+    for _, call := range outboundCalls {
+        if campaignId == "" || call.CampaignId == campaignId {
+            list = append(list, *call)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+    return list
+}
+
+// /admin/calls (admin):
+//
+//   GET    /admin/calls      live outbound calls
+//   POST   /admin/calls      place one, see outboundRequest
+//   GET    /admin/calls/ID
+//   DELETE /admin/calls/ID   hang up
+func handleCalls(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/calls"), "/")
+    
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"calls": liveCalls("")})
+    case id == "" && r.Method == http.MethodPost:
+        var req outboundRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        call, err := placeCall(r.Context(), req, "", "", nil)
+        if errorCode(err, "") == ErrInvalidMessage {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadGateway)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(call)
+    case id != "" && (r.Method == http.MethodGet || r.Method == http.MethodDelete):
+        outboundMu.Lock()
+        call, ok := outboundCalls[id]
+        var snapshot outboundCall
+        if ok {
+            snapshot = *call
+        }
+        outboundMu.Unlock()
+        if !ok {
+            http.Error(w, "Call not found", http.StatusNotFound)
+            return
+        }
+        if r.Method == http.MethodDelete {
+            hangupCall(snapshot.CallId)
+            endCall(id, outcomeOf(snapshot, telephony.Completed), "hung up by an admin")
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(snapshot)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// POST /telephony/status/ID?token=TOKEN, a telephony.Status from the gateway.
+// The token in the status URL it was given authenticates it.
+func handleTelephonyStatus(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/telephony/status/")
+    outboundMu.Lock()
+    call := outboundCalls[id]
+    var token string
+    if call != nil {
+        token = call.token
+    }
+    outboundMu.Unlock()
+    if call == nil {
+        http.Error(w, "Call not found", http.StatusNotFound)
+        return
+    }
+    if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+        http.Error(w, "Bad token", http.StatusForbidden)
+        return
+    }
+    var status telephony.Status
+    if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+        http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    callStatus(id, status)
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// OutboxEvent is a room lifecycle event published to webhooks and Kafka. Id
+// doubles as the idempotency key: publishing is at-least-once, so consumers
+// dedupe on it to process every event exactly once.
+type OutboxEvent struct {
+    Id        string                 `json:"id"`
+    Seq       uint64                 `json:"seq"`
+    Type      string                 `json:"type"` // room_created, participant_joined, participant_left, room_closed, room_migrated
+    RoomId    string                 `json:"roomId"`
+    Tenant    string                 `json:"tenant,omitempty"`
+    Data      map[string]interface{} `json:"data,omitempty"`
+    Timestamp int64                  `json:"timestamp"`
+}
+
+// outboxRecord is one line of the outbox log: either a new event or a sink's
+// acknowledgement of one.
+type outboxRecord struct {
+    Event *OutboxEvent `json:"event,omitempty"`
+    Ack   string       `json:"ack,omitempty"`
+    Sink  string       `json:"sink,omitempty"`
+}
+
+type outboxSink interface {
+    name() string
+    publish(event *OutboxEvent, body []byte) error
+}
+
+// errPermanent marks a publish failure retrying won't fix, such as a 4xx.
+type errPermanent struct{ error }
+
+type pendingEvent struct {
+    event   *OutboxEvent
+    body    []byte
+    waiting map[string]bool // Sinks that haven't acknowledged the event yet
+}
+
+type outbox struct {
+    mu      sync.Mutex
+    file    *os.File // Nil keeps the outbox in memory only
+    seq     uint64
+    synced  uint64 // The last event on disk, published only up to it
+    pending []*pendingEvent
+    sinks   []outboxSink
+    wake    chan struct{}
+    dirty   chan struct{} // Wakes the syncer after a write
+    handed  bool          // The log went to the new process in an upgrade, see handOver
+}
+
+var events *outbox // Nil unless a webhook or Kafka sink is configured
+
+var outboxHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// emitEvent appends a lifecycle event to the outbox. Callers hold the lock
+// guarding the state change (roomsMu for rooms) so the log order matches the
+// order changes became visible, all but room_closed, emitted once the room
+// left rooms: a room reopened at once under the same ID may log its
+// room_created first. It only writes the record: the fsync, which
+// would hold every join and leave up on the disk, is the syncer's, one for
+// however many events were written meanwhile, and run publishes an event
+// once it is on disk.
+func emitEvent(eventType string, room *RoomInfo, data map[string]interface{}) {
+    if events == nil {
+        return
+    }
+    
+    events.mu.Lock()
+    defer events.mu.Unlock()
+    if events.handed {
+        return
+    }
+    
+    events.seq++
+    event := &OutboxEvent{
+        Id:        fmt.Sprintf("%s-%d", newRandomId(), events.seq),
+        Seq:       events.seq,
+        Type:      eventType,
+        RoomId:    room.RoomId,
+        Tenant:    room.Tenant,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    if err := events.persist(outboxRecord{Event: event}); err != nil {
+        log.Printf("Outbox write failed, %s for room %s not persisted: %v", eventType, room.RoomId, err)
+    }
+    events.enqueue(event)
+    if events.file == nil {
+        events.synced = event.Seq
+        notify(events.wake)
+    }
+}
+
+func notify(ch chan struct{}) {
+    select {
+    case ch <- struct{}{}:
+    default:
+    }
+}
+
+// syncLoop fsyncs the log after writes, group committing whatever was
+// written since the last sync, and wakes run for the events it made durable.
+// A failed sync proves nothing on disk, so it is retried with backoff and
+// the events wait.
+func (o *outbox) syncLoop() {
+    backoff := time.Second
+    for range o.dirty {
+        o.mu.Lock()
+        seq, file := o.seq, o.file
+        o.mu.Unlock()
+        if file == nil {
+            return // Handed over
+        }
+        if err := file.Sync(); err != nil {
+            log.Printf("level=error Outbox sync failed, retrying in %v: %v", backoff, err)
+            time.Sleep(backoff)
+            if backoff *= 2; backoff > cfg.OutboxMaxBackoff {
+                backoff = cfg.OutboxMaxBackoff
+            }
+            notify(o.dirty)
+            continue
+        }
+        backoff = time.Second
+        o.mu.Lock()
+        o.synced = seq
+        o.mu.Unlock()
+        notify(o.wake)
+    }
+}
+
+func (o *outbox) enqueue(event *OutboxEvent) {
+    body, _ := json.Marshal(event)
+    waiting := make(map[string]bool, len(o.sinks))
+    for _, sink := range o.sinks {
+        waiting[sink.name()] = true
+    }
+    o.pending = append(o.pending, &pendingEvent{event: event, body: body, waiting: waiting})
+}
+
+// persist appends a record for the syncer to sync. Callers must hold o.mu.
+func (o *outbox) persist(rec outboxRecord) error {
+    if o.file == nil {
+        return nil
+    }
+    line, _ := json.Marshal(rec)
+    if _, err := o.file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    notify(o.dirty)
+    return nil
+}
+
+// ack records that sink has the event, dropping it once every sink does.
+// When nothing is pending the log is truncated so it doesn't grow forever.
+func (o *outbox) ack(p *pendingEvent, sink string) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.handed {
+        return // The new process publishes it again
+    }
+    
+    if err := o.persist(outboxRecord{Ack: p.event.Id, Sink: sink}); err != nil {
+        log.Printf("Outbox ack write failed for %s: %v", p.event.Id, err)
+    }
+    delete(p.waiting, sink)
+    if len(p.waiting) > 0 {
+        return
+    }
+    
+    o.pending = o.pending[1:]
+    if len(o.pending) == 0 && o.file != nil {
+        if err := o.file.Truncate(0); err != nil {
+            log.Printf("Outbox truncate failed: %v", err)
+        }
+        o.file.Seek(0, 0)
+    }
+}
+
+// run publishes pending events in order, retrying each sink with backoff
+// until it accepts. A stuck sink holds back later events so consumers
+// always see a room's events in sequence.
+func (o *outbox) run() {
+    backoff := time.Second
+    for {
+        o.mu.Lock()
+        if o.handed {
+            o.mu.Unlock()
+            return
+        }
+        var next *pendingEvent
+        if len(o.pending) > 0 && o.pending[0].event.Seq <= o.synced {
+            next = o.pending[0]
+        }
+        o.mu.Unlock()
+        
+        if next == nil {
+            <-o.wake
+            continue
+        }
+        
+        failed := false
+        for _, sink := range o.sinks {
+            if !next.waiting[sink.name()] {
+                continue
+            }
+            err := sink.publish(next.event, next.body)
+            if perm, ok := err.(errPermanent); ok {
+                log.Printf("Outbox %s rejected %s %s, dropping: %v", sink.name(), next.event.Type, next.event.Id, perm.error)
+                err = nil
+            }
+            if err != nil {
+                log.Printf("Outbox %s publish of %s failed, retrying in %v: %v", sink.name(), next.event.Id, backoff, err)
+                failed = true
+                break
+            }
+            o.ack(next, sink.name())
+        }
+        
+        if failed {
+            time.Sleep(backoff)
+            if backoff *= 2; backoff > cfg.OutboxMaxBackoff {
+                backoff = cfg.OutboxMaxBackoff
+            }
+            continue
+        }
+        backoff = time.Second
+    }
+}
+
+// load replays the outbox log, keeping events some sink hasn't acknowledged,
+// then rewrites the log with just those.
+func (o *outbox) load(path string) error {
+    acks := make(map[string]map[string]bool)
+    var logged []*OutboxEvent
+    
+    if f, err := os.Open(path); err == nil {
+        scanner := bufio.NewScanner(f)
+        scanner.Buffer(make([]byte, 64<<10), 16<<20)
+        for scanner.Scan() {
+            var rec outboxRecord
+            if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+                // A torn final line from a crash mid-write
+                log.Printf("Outbox: skipping unreadable record: %v", err)
+                continue
+            }
+            switch {
+            case rec.Event != nil:
+                logged = append(logged, rec.Event)
+                if rec.Event.Seq > o.seq {
+                    o.seq = rec.Event.Seq
+                }
+            case rec.Ack != "":
+                if acks[rec.Ack] == nil {
+                    acks[rec.Ack] = make(map[string]bool)
+                }
+                acks[rec.Ack][rec.Sink] = true
+            }
+        }
+        f.Close()
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+    
+    tmp := path + ".tmp"
+    file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    o.file = file
+    for _, event := range logged {
+        o.enqueue(event)
+        p := o.pending[len(o.pending)-1]
+        for sink := range acks[event.Id] {
+            delete(p.waiting, sink)
+        }
+        if len(p.waiting) == 0 {
+            o.pending = o.pending[:len(o.pending)-1]
+            continue
+        }
+        if err := o.persist(outboxRecord{Event: event}); err != nil {
+            return err
+        }
+        for sink := range acks[event.Id] {
+            if err := o.persist(outboxRecord{Ack: event.Id, Sink: sink}); err != nil {
+                return err
+            }
+        }
+    }
+    if err := file.Sync(); err != nil {
+        return err
+    }
+    o.synced = o.seq
+    return os.Rename(tmp, path)
+}
+
+func startOutbox() error {
+    var sinks []outboxSink
+    if cfg.WebhookURL != "" {
+        sinks = append(sinks, &webhookSink{url: cfg.WebhookURL, secret: cfg.WebhookSecret})
+    }
+    if cfg.KafkaRESTURL != "" {
+        sinks = append(sinks, &kafkaRESTSink{url: strings.TrimRight(cfg.KafkaRESTURL, "/"), topic: cfg.KafkaTopic})
+    }
+    if len(sinks) == 0 {
+        return nil
+    }
+    
+    o := &outbox{sinks: sinks, wake: make(chan struct{}, 1), dirty: make(chan struct{}, 1)}
+    if cfg.OutboxFile != "" && os.Getenv(upgradeEnv) != "" {
+        log.Printf("Outbox: loading %s once the old process hands it over", cfg.OutboxFile)
+    } else if cfg.OutboxFile != "" {
+        if err := o.load(cfg.OutboxFile); err != nil {
+            return fmt.Errorf("outbox %s: %v", cfg.OutboxFile, err)
+        }
+        log.Printf("Outbox: %d events pending from %s", len(o.pending), cfg.OutboxFile)
+    } else {
+        log.Println("Outbox: no -outbox-file, events are lost on restart")
+    }
+    events = o
+    if o.file != nil {
+        go o.syncLoop()
+    }
+    go o.run()
+    return nil
+}
+
+// handOver stops the outbox for an upgrade: the log is synced and closed for
+// the new process to load, and this process writes and publishes no more.
+// Events it was still publishing go out again from the new one, which
+// consumers dedupe by ID.
+func (o *outbox) handOver() {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.handed = true
+    notify(o.wake)
+    if o.file == nil {
+        return
+    }
+    if err := o.file.Sync(); err != nil {
+        log.Printf("level=error Outbox sync before the handover failed: %v", err)
+    }
+    o.file.Close()
+    o.file = nil
+    notify(o.dirty)
+}
+
+// takeOverOutbox loads the log an upgrading process handed over, which it
+// has closed by the time its state arrives.
+func takeOverOutbox() {
+    if events == nil || cfg.OutboxFile == "" {
+        return
+    }
+    events.mu.Lock()
+    err := events.load(cfg.OutboxFile)
+    if err != nil && events.file != nil {
+        events.file.Close()
+        events.file = nil
+    }
+    pending := len(events.pending)
+    events.mu.Unlock()
+    if err != nil {
+        log.Printf("level=error Outbox %s: %v, events are kept in memory only", cfg.OutboxFile, err)
+        return
+    }
+    log.Printf("Outbox: %d events pending from %s", pending, cfg.OutboxFile)
+    go events.syncLoop()
+    notify(events.wake)
+}
+
+// webhookSink POSTs each event as JSON. The Idempotency-Key header carries
+// the event ID and X-IVA-Signature an HMAC-SHA256 of the body when a secret
+// is configured.
+type webhookSink struct {
+    url    string
+    secret string
+}
+
+func (s *webhookSink) name() string { return "webhook" }
+
+func (s *webhookSink) publish(event *OutboxEvent, body []byte) error {
+    req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+    if err != nil {
+        return errPermanent{err}
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Idempotency-Key", event.Id)
+    req.Header.Set("X-IVA-Event", event.Type)
+    if s.secret != "" {
+        mac := hmac.New(sha256.New, []byte(s.secret))
+        mac.Write(body)
+        req.Header.Set("X-IVA-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    }
+    return postOutbox(req)
+}
+
+// kafkaRESTSink produces to a topic through a Kafka REST Proxy (v2 API),
+// keyed by room ID so each room's events stay in one partition and in order.
+type kafkaRESTSink struct {
+    url   string
+    topic string
+}
+
+func (s *kafkaRESTSink) name() string { return "kafka" }
+
+func (s *kafkaRESTSink) publish(event *OutboxEvent, body []byte) error {
+    payload, _ := json.Marshal(map[string]interface{}{
+        "records": []map[string]interface{}{
+            {"key": event.RoomId, "value": json.RawMessage(body)},
+        },
+    })
+    req, err := http.NewRequest(http.MethodPost, s.url+"/topics/"+s.topic, bytes.NewReader(payload))
+    if err != nil {
+        return errPermanent{err}
+    }
+    req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+    return postOutbox(req)
+}
+
+func postOutbox(req *http.Request) error {
+    resp, err := outboxHTTP.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    
+    switch {
+    case resp.StatusCode >= 200 && resp.StatusCode < 300:
+        return nil
+    case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+        return fmt.Errorf("status %d", resp.StatusCode)
+    case resp.StatusCode >= 400 && resp.StatusCode < 500:
+        return errPermanent{fmt.Errorf("status %d", resp.StatusCode)}
+    }
+    return fmt.Errorf("status %d", resp.StatusCode)
+}
+
+func participantEvent(client *Client) map[string]interface{} {
+    return map[string]interface{}{
+        "clientId":   client.clientId,
+        "clientType": client.clientType,
+        "role":       client.role,
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+type Permission string
+
+const (
+    PermSendMessage    Permission = "send_message"
+    PermBroadcastAudio Permission = "broadcast_audio"
+    PermChangeMetadata Permission = "change_metadata"
+    PermShareFile      Permission = "share_file"
+    PermPrivateChannel Permission = "private_channel"
+    PermRecord         Permission = "record"
+    PermHandoff        Permission = "handoff"
+    PermKick           Permission = "kick"
+    PermVerifyCaller   Permission = "verify_caller"
+)
+
+var (
+    rolePermissions = map[string][]Permission{
+        "user":       {PermSendMessage, PermBroadcastAudio, PermChangeMetadata, PermShareFile},
+        "agent":      {PermSendMessage, PermBroadcastAudio, PermChangeMetadata, PermShareFile, PermPrivateChannel, PermRecord, PermHandoff, PermVerifyCaller},
+        "supervisor": {PermSendMessage, PermBroadcastAudio, PermChangeMetadata, PermShareFile, PermPrivateChannel, PermRecord, PermHandoff, PermKick, PermVerifyCaller},
+        "observer":   {},
+        "shadow":     {}, // Sends nothing to the room, see shadow.go
+    }
+    rolePermissionsMu sync.RWMutex
+)
+
+// Connections that need a verified claim at connect time, a ticket's or
+// admin credentials: every one but a user in the user role, as both the
+// client type and the role are self-asserted and the agent features look at
+// the type
+func isElevated(clientType ClientType, role string) bool {
+    return clientType != ClientTypeUser || role != string(ClientTypeUser)
+}
+
+func knownRole(role string) bool {
+    rolePermissionsMu.RLock()
+    defer rolePermissionsMu.RUnlock()
+    
+    _, ok := rolePermissions[role]
+    return ok
+}
+
+func hasPermission(client *Client, perm Permission) bool {
+    rolePermissionsMu.RLock()
+    defer rolePermissionsMu.RUnlock()
+    
+    for _, p := range rolePermissions[client.role] {
+        if p == perm {
+            return true
+        }
+    }
+    return false
+}
+
+// messagePermission maps a client message type to the permission it needs.
+// Ephemeral and receipt messages need none.
+func messagePermission(msgType string) (Permission, bool) {
+    switch msgType {
+    case "metadata", "profile_update":
+        return PermChangeMetadata, true
+    case "channel_open", "channel_close", "channel_audio":
+        return PermPrivateChannel, true
+    case "recording_start", "recording_stop", "recording_pause", "recording_resume":
+        return PermRecord, true
+    case "handoff", "integration_status", "disposition", "usage", "consult_start", "consult_transfer":
+        return PermHandoff, true
+    case "kick":
+        return PermKick, true
+    case "speak", "speak_stop", "speak_stream":
+        return PermBroadcastAudio, true
+    case "verify_start", "tool_authorize", "speaker_enroll", "secure_capture_start", "secure_capture_stop":
+        return PermVerifyCaller, true
+    case "typing", "reaction", "read", "file_receipt":
+        return "", false
+    }
+    return PermSendMessage, true
+}
+
+func checkMessagePermission(client *Client, msg *Message) error {
+    perm, needed := messagePermission(msg.Type)
+    if needed && !hasPermission(client, perm) {
+        return fmt.Errorf("role %q lacks %s", client.role, perm)
+    }
+    return nil
+}
+
+// kick: {"clientId": ID, "reason": TEXT}
+func handleKick(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    targetId, _ := data["clientId"].(string)
+    reason, _ := data["reason"].(string)
+    
+    target := findClient(roomId, targetId)
+    if target == nil || target == sender {
+        sendError(sender, ErrTargetNotFound, msg, "client %q is not in the room", targetId)
+        return
+    }
+    
+    sendMessageToClient(target, &Message{
+        Type: "kicked",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "by":     sender.clientId,
+            "reason": reason,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    
+    logAt("info", roomId, targetId, "Kicked by %s: %s", sender.clientId, reason)
+    target.mu.Lock()
+    target.resumeToken = "" // A kicked client can't resume its way back in
+    target.mu.Unlock()
+    
+    // The target's read loop fails once the connection closes and runs the usual leave cleanup
+    closeConnection(target.conn, CloseKicked, "")
+}
+
+// loadRolePermissions replaces the default role table with a JSON object of
+// role -> list of permissions.
+func loadRolePermissions(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    
+    var table map[string][]Permission
+    if err := json.Unmarshal(data, &table); err != nil {
+        return err
+    }
+    
+    rolePermissionsMu.Lock()
+    rolePermissions = table
+    rolePermissionsMu.Unlock()
+    return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "hash/fnv"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// store holds durable data. It defaults to in-memory so the server runs
+// without a database, see -storage-dsn.
+var store storage.Store = storage.NewMemory()
+
+// durableWrites take room, CDR, history and recording writes off the request
+// path, to -durable-writers writers each with its own queue. A write goes to
+// the queue its key, the room ID, hashes to, so a room's writes reach the
+// store in the order they were queued, which callers do holding roomsMu so
+// it is the order the state changed. A write that must persist waits up to
+// -durable-write-wait for room in a full queue, a store that stalled, and a
+// best-effort one is dropped at once; either way a dropped write is counted
+// in iva_durable_writes_dropped_total.
+var (
+    durableWrites []chan func(ctx context.Context) error
+    writersOnce   sync.Once
+    
+    durableWritesDropped = newCounterVec("iva_durable_writes_dropped_total", "Durable writes dropped because the store fell behind and their queue stayed full, by kind: durable or best_effort.", "kind")
+    lastDropLogged       atomic.Int64 // Unix seconds
+)
+
+func init() {
+    metricSeries = append(metricSeries, durableWritesDropped)
+}
+
+// persist queues a write that must reach the store, such as a CDR or a
+// transcript line, behind the earlier writes with the same key.
+func persist(key string, write func(ctx context.Context) error) {
+    queue := durableQueue(key)
+    select {
+    case queue <- write:
+        return
+    default:
+    }
+    wait := time.NewTimer(cfg.DurableWriteWait)
+    defer wait.Stop()
+    select {
+    case queue <- write:
+    case <-wait.C:
+        droppedDurableWrite(queue, "durable")
+    }
+}
+
+// persistBestEffort queues a write the server can do without, such as a
+// quality grade, dropping it when its queue is full.
+func persistBestEffort(key string, write func(ctx context.Context) error) {
+    queue := durableQueue(key)
+    select {
+    case queue <- write:
+    default:
+        droppedDurableWrite(queue, "best_effort")
+    }
+}
+
+func durableQueue(key string) chan func(ctx context.Context) error {
+    writersOnce.Do(func() {
+        for i := 0; i < cfg.DurableWriters; i++ {
+            queue := make(chan func(ctx context.Context) error, 4096)
+            durableWrites = append(durableWrites, queue)
+            go runDurableWrites(queue)
+        }
+    })
+    h := fnv.New32a()
+    h.Write([]byte(key))
+    return durableWrites[h.Sum32()%uint32(len(durableWrites))]
+}
+
+// flushDurableWrites waits up to timeout for every write queued so far to
+// be done, reporting whether they were.
+func flushDurableWrites(timeout time.Duration) bool {
+    durableQueue("")
+    expired := make(chan struct{})
+    defer time.AfterFunc(timeout, func() { close(expired) }).Stop()
+    
+    var done sync.WaitGroup
+    for _, queue := range durableWrites {
+        done.Add(1)
+        select {
+        case queue <- func(context.Context) error { done.Done(); return nil }:
+        case <-expired:
+            return false
+        }
+    }
+    flushed := make(chan struct{})
+    go func() {
+        done.Wait()
+        close(flushed)
+    }()
+    select {
+    case <-flushed:
+        return true
+    case <-expired:
+        return false
+    }
+}
+
+func droppedDurableWrite(queue chan func(ctx context.Context) error, kind string) {
+    durableWritesDropped.inc(nil, kind)
+    if now := time.Now().Unix(); lastDropLogged.Swap(now) < now-60 {
+        log.Printf("level=error Storage is behind, %d writes queued, dropping %s writes", len(queue), kind)
+    }
+}
+
+func runDurableWrites(queue chan func(ctx context.Context) error) {
+    for write := range queue {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        if err := write(ctx); err != nil {
+            log.Printf("Storage write failed: %v", err)
+        }
+        cancel()
+    }
+}
+
+func startPersistence() error {
+    s, err := storage.Open(cfg.StorageDSN)
+    if err != nil {
+        return err
+    }
+    store = s
+    return nil
+}
+
+// cdrFor starts the call detail record when a room is created. Callers hold
+// roomsMu, which also guards the record.
+func cdrFor(room *RoomInfo) *storage.CDR {
+    return &storage.CDR{
+        Id:         newRandomId(),
+        RoomId:     room.RoomId,
+        Tenant:     room.Tenant,
+        Template:   room.Template,
+        StartedAt:  room.CreatedAt,
+        Variants:   room.Variants,
+        KGSnapshot: room.KGSnapshot,
+    }
+}
+
+func recordRoomCreated(room *RoomInfo) {
+    record := storage.Room{Id: room.RoomId, Tenant: room.Tenant, Template: room.Template, CreatedAt: room.CreatedAt}
+    persist(room.RoomId, func(ctx context.Context) error {
+        return store.Rooms().Create(ctx, record)
+    })
+}
+
+// recordParticipant notes a join or leave on the room's CDR. Callers must
+// hold roomsMu.
+func recordParticipant(room *RoomInfo, client *Client, joined bool) {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    if joined {
+        room.cdr.Participants = append(room.cdr.Participants, storage.CDRParticipant{
+            ClientId:   client.clientId,
+            ClientType: string(client.clientType),
+            Role:       client.role,
+            JoinedAt:   now,
+        })
+        return
+    }
+    for i := len(room.cdr.Participants) - 1; i >= 0; i-- {
+        if p := &room.cdr.Participants[i]; p.ClientId == client.clientId && p.LeftAt == 0 {
+            p.LeftAt = now
+            return
+        }
+    }
+}
+
+// recordRoomClosed writes the finished CDR. Callers must hold roomsMu.
+func recordRoomClosed(room *RoomInfo) {
+    cdr := *room.cdr
+    cdr.EndedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    cost := roomCost(room, cdr.EndedAt)
+    cdr.Cost = &cost
+    cdr.Participants = append([]storage.CDRParticipant(nil), room.cdr.Participants...)
+    persist(cdr.RoomId, func(ctx context.Context) error {
+        if err := store.Rooms().Close(ctx, cdr.RoomId, cdr.EndedAt); err != nil && err != storage.ErrNotFound {
+            return err
+        }
+        return store.CDRs().Save(ctx, cdr)
+    })
+    indexCDR(cdr)
+    scheduleQualityScoring(cdr)
+}
+
+func recordRecordingStarted(room *RoomInfo) {
+    rec := storage.Recording{
+        Id:        room.Recording.Id,
+        RoomId:    room.RoomId,
+        Tenant:    room.Tenant,
+        StartedBy: room.Recording.StartedBy,
+        StartedAt: room.Recording.StartedAt,
+    }
+    persist(room.RoomId, func(ctx context.Context) error {
+        return store.Recordings().Start(ctx, rec)
+    })
+}
+
+func recordRecordingStopped(roomId string, recordingId string) {
+    stoppedAt := time.Now().UnixNano() / int64(time.Millisecond)
+    persist(roomId, func(ctx context.Context) error {
+        return store.Recordings().Stop(ctx, recordingId, stoppedAt)
+    })
+}
+
+func transcriptEntry(roomId string, tenant string, msg *Message) storage.TranscriptEntry {
+    entry := storage.TranscriptEntry{
+        RoomId:    roomId,
+        Tenant:    tenant,
+        MessageId: msg.Id,
+        Type:      msg.Type,
+        From:      msg.From,
+        To:        msg.To,
+        Audience:  historyAudience(msg),
+        Timestamp: msg.Timestamp,
+    }
+    if msg.Data != nil {
+        entry.Data, _ = json.Marshal(msg.Data)
+    }
+    if len(msg.Metadata) > 0 {
+        entry.Metadata, _ = json.Marshal(msg.Metadata)
+    }
+    if data, ok := msg.Data.(map[string]interface{}); ok && msg.Type == "assistant_final" {
+        if citations, ok := data["citations"].([]Citation); ok && len(citations) > 0 {
+            entry.Citations, _ = json.Marshal(citations)
+        }
+    }
+    return entry
+}
+
+func historyEntryFrom(entry storage.TranscriptEntry) HistoryEntry {
+    h := HistoryEntry{
+        Message: Message{
+            Id:        entry.MessageId,
+            Type:      entry.Type,
+            From:      entry.From,
+            To:        entry.To,
+            Timestamp: entry.Timestamp,
+        },
+        Audience: entry.Audience,
+    }
+    if len(entry.Data) > 0 {
+        h.Data = entry.Data
+    }
+    if len(entry.Metadata) > 0 {
+        json.Unmarshal(entry.Metadata, &h.Metadata)
+    }
+    return h
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+    "github.com/yourusername/my-go-project/stt"
+)
+
+// Custom vocabulary for speech recognition. Phrases come from the optional
+// -stt-vocabulary file and from the phrase store, managed per tenant over
+// /admin/stt/phrases, joined by the written forms of the pronunciation
+// lexicon (see lexicon.go). "_default" phrases apply to every tenant. A
+// tenant's compiled vocabulary is cached until its phrases or lexicon
+// change; sessions already running keep the hints they started with.
+
+const defaultPhraseTenant = "_default"
+
+var (
+    fileVocabulary = make(map[string][]stt.Term) // From -stt-vocabulary, by tenant
+    vocabularies   = make(map[string]*stt.Vocabulary)
+    vocabulariesMu sync.Mutex
+)
+
+// loadSTTVocabulary reads a JSON file mapping tenants to term lists.
+func loadSTTVocabulary(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    
+    var terms map[string][]string
+    if err := json.Unmarshal(data, &terms); err != nil {
+        return err
+    }
+    for tenant, list := range terms {
+        for _, term := range list {
+            fileVocabulary[tenant] = append(fileVocabulary[tenant], stt.Term{Text: term})
+        }
+    }
+    return nil
+}
+
+func sttVocabularyFor(tenant string) *stt.Vocabulary {
+    vocabulariesMu.Lock()
+    defer vocabulariesMu.Unlock()
+    if vocabulary, ok := vocabularies[tenant]; ok {
+        return vocabulary
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+    defer cancel()
+    
+    var terms []stt.Term
+    for _, scope := range []string{defaultPhraseTenant, tenant} {
+        terms = append(terms, fileVocabulary[scope]...)
+        phrases, err := store.Phrases().List(ctx, scope)
+        if err != nil {
+            logAt("warn", "", "", "Loading phrases for %s: %v", logValue(scope), err)
+            continue
+        }
+        for _, phrase := range phrases {
+            terms = append(terms, stt.Term{Text: phrase.Term, Boost: phrase.Boost, SoundsLike: phrase.SoundsLike})
+        }
+        if tenant == defaultPhraseTenant {
+            break
+        }
+    }
+    for _, entry := range lexiconEntries(ctx, tenant) {
+        terms = append(terms, stt.Term{Text: entry.Written, SoundsLike: entry.SoundsLike})
+    }
+    vocabulary := stt.NewVocabulary(terms)
+    vocabularies[tenant] = vocabulary
+    return vocabulary
+}
+
+// invalidateVocabulary drops cached vocabularies a phrase change affects.
+func invalidateVocabulary(tenant string) {
+    vocabulariesMu.Lock()
+    defer vocabulariesMu.Unlock()
+    if tenant == defaultPhraseTenant {
+        vocabularies = make(map[string]*stt.Vocabulary)
+        return
+    }
+    delete(vocabularies, tenant)
+}
+
+// Admin API: /admin/stt/phrases/TENANT[/TERM]
+//   GET    lists the tenant's phrases
+//   POST   adds or updates one phrase: {"term", "boost", "soundsLike"}
+//   PUT    replaces the tenant's phrases with the posted list
+//   DELETE with a TERM removes that phrase
+func handlePhrases(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    // Terms may contain spaces and slashes, so take them from the escaped path
+    rest := strings.TrimPrefix(r.URL.EscapedPath(), "/admin/stt/phrases/")
+    tenantPart, termPart, _ := strings.Cut(rest, "/")
+    tenant, err1 := url.PathUnescape(tenantPart)
+    term, err2 := url.PathUnescape(termPart)
+    if tenant == "" || err1 != nil || err2 != nil {
+        http.Error(w, "Tenant required, use _default for every tenant", http.StatusBadRequest)
+        return
+    }
+    
+    ctx := r.Context()
+    phrases := store.Phrases()
+    switch r.Method {
+    case http.MethodGet:
+        list, err := phrases.List(ctx, tenant)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"tenant": tenant, "phrases": list})
+        
+    case http.MethodPost:
+        var phrase storage.Phrase
+        if err := json.NewDecoder(r.Body).Decode(&phrase); err != nil {
+            http.Error(w, "Invalid JSON", http.StatusBadRequest)
+            return
+        }
+        if !validPhrase(w, &phrase, tenant) {
+            return
+        }
+        if err := phrases.Put(ctx, phrase); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        invalidateVocabulary(tenant)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(phrase)
+        
+    case http.MethodPut:
+        var list []storage.Phrase
+        if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+            http.Error(w, "Expected a JSON array of phrases", http.StatusBadRequest)
+            return
+        }
+        keep := make(map[string]bool)
+        for i := range list {
+            if !validPhrase(w, &list[i], tenant) {
+                return
+            }
+            keep[list[i].Term] = true
+        }
+        
+        existing, err := phrases.List(ctx, tenant)
+        if err == nil {
+            for _, phrase := range existing {
+                if !keep[phrase.Term] {
+                    err = phrases.Delete(ctx, tenant, phrase.Term)
+                }
+                if err != nil {
+                    break
+                }
+            }
+        }
+        for i := 0; err == nil && i < len(list); i++ {
+            err = phrases.Put(ctx, list[i])
+        }
+        invalidateVocabulary(tenant)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+        
+    case http.MethodDelete:
+        if term == "" {
+            http.Error(w, "Term required", http.StatusBadRequest)
+            return
+        }
+        err := phrases.Delete(ctx, tenant, term)
+        if err == storage.ErrNotFound {
+            http.Error(w, "Phrase not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        invalidateVocabulary(tenant)
+        w.WriteHeader(http.StatusNoContent)
+        
+    default:
+        http.Error(w, "Only GET, POST, PUT and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// validPhrase normalizes a posted phrase and writes the error itself.
+func validPhrase(w http.ResponseWriter, phrase *storage.Phrase, tenant string) bool {
+    phrase.Tenant = tenant
+    phrase.Term = strings.TrimSpace(phrase.Term)
+    if len([]rune(phrase.Term)) < 2 || len(phrase.Term) > 100 {
+        http.Error(w, "term must be 2 to 100 characters", http.StatusBadRequest)
+        return false
+    }
+    if phrase.Boost < 0 || phrase.Boost > 20 {
+        http.Error(w, "boost must be between 0 and 20", http.StatusBadRequest)
+        return false
+    }
+    phrase.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+    return true
+}
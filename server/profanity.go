@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Profanity filtering for chat messages and transcripts. Each tenant has a
+// policy, a "+" separated set of:
+//
+//   log    log the sender and the number of words
+//   mask   replace each word with its first letter and asterisks
+//   alert  send a profanity_alert to the room's supervisors and emit a
+//          "profanity" outbox event for off-room review
+//
+// Any policy other than off counts words into the call's analytics.
+
+var defaultProfanity = []string{
+    "arse", "arsehole", "ass", "asshole", "bastard", "bitch", "bollocks", "bullshit",
+    "crap", "cunt", "damn", "dick", "fuck", "fucker", "motherfucker", "piss", "prick",
+    "shit", "slut", "twat", "wanker", "whore",
+}
+
+var profanityPattern *regexp.Regexp
+
+var profanityTotal = newCounterVec("iva_profanity_total", "Profane words detected, by chat or transcript.", "source")
+
+func init() {
+    metricSeries = append(metricSeries, profanityTotal)
+}
+
+type profanityPolicy struct {
+    log, mask, alert bool
+}
+
+func (p profanityPolicy) enabled() bool {
+    return p.log || p.mask || p.alert
+}
+
+func parseProfanityPolicy(value string) (profanityPolicy, bool) {
+    var policy profanityPolicy
+    for _, part := range strings.Split(value, "+") {
+        switch strings.TrimSpace(part) {
+        case "", "off":
+        case "log":
+            policy.log = true
+        case "mask":
+            policy.mask = true
+        case "alert":
+            policy.alert = true
+        default:
+            return profanityPolicy{}, false
+        }
+    }
+    return policy, true
+}
+
+// loadProfanity compiles the word list, from -profanity-words when set.
+// Words match whole, with common inflections ("fucking", "bitches").
+func loadProfanity() error {
+    words := defaultProfanity
+    if cfg.ProfanityWordsFile != "" {
+        file, err := os.Open(cfg.ProfanityWordsFile)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        
+        words = nil
+        scanner := bufio.NewScanner(file)
+        for scanner.Scan() {
+            if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+                words = append(words, word)
+            }
+        }
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+    }
+    if len(words) == 0 {
+        return nil
+    }
+    
+    quoted := make([]string, len(words))
+    for i, word := range words {
+        quoted[i] = regexp.QuoteMeta(strings.ToLower(word))
+    }
+    sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+    profanityPattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)(?:s|es|ed|er|ers|ing|in)?\b`)
+    return nil
+}
+
+func profanityPolicyFor(tenant string) profanityPolicy {
+    value := cfg.ProfanityPolicy
+    if override, ok := lookupOverride(cfg.ProfanityTenantPolicies, tenant); ok {
+        value = override
+    }
+    policy, _ := parseProfanityPolicy(value)
+    return policy
+}
+
+func maskProfanity(word string) string {
+    runes := []rune(word)
+    return string(runes[0]) + strings.Repeat("*", len(runes)-1)
+}
+
+// filterProfanity applies the room tenant's policy to text from client,
+// returning the text to deliver. Partial transcripts set count to false so
+// words aren't counted and alerted again with every revision.
+func filterProfanity(roomId string, client *Client, source string, messageId string, text string, count bool) string {
+    if profanityPattern == nil || text == "" {
+        return text
+    }
+    room, _, agents := roomMembers(roomId)
+    if room == nil {
+        return text
+    }
+    policy := profanityPolicyFor(room.Tenant)
+    if !policy.enabled() {
+        return text
+    }
+    matches := profanityPattern.FindAllStringIndex(text, -1)
+    if len(matches) == 0 {
+        return text
+    }
+    
+    masked := profanityPattern.ReplaceAllStringFunc(text, maskProfanity)
+    if !count {
+        if policy.mask {
+            return masked
+        }
+        return text
+    }
+    
+    profanityTotal.add(float64(len(matches)), client.labels, source)
+    roomsMu.Lock()
+    room.stats.profanity += int64(len(matches))
+    roomsMu.Unlock()
+    
+    if policy.log {
+        logAt("info", roomId, client.clientId, "Profanity in %s %s: %d words", source, messageId, len(matches))
+    }
+    if policy.alert {
+        data := map[string]interface{}{
+            "source":     source,
+            "messageId":  messageId,
+            "clientId":   client.clientId,
+            "clientType": client.clientType,
+            "count":      len(matches),
+            "text":       masked,
+        }
+        alert := &Message{
+            Id:        newMessageId(),
+            Type:      "profanity_alert",
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        }
+        for _, agent := range agents {
+            if agent.role == "supervisor" && agent != client {
+                sendMessageToClient(agent, alert)
+            }
+        }
+        emitEvent("profanity", room, data)
+    }
+    if policy.mask {
+        return masked
+    }
+    return text
+}
+
+// filterChatProfanity masks the text of chat messages in place.
+func filterChatProfanity(roomId string, sender *Client, msg *Message) {
+    switch msg.Type {
+    case "broadcast", "selective", "agent_only", "user_only", "assistant_final":
+    default:
+        return
+    }
+    data, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        return
+    }
+    if text, ok := data["text"].(string); ok {
+        data["text"] = filterProfanity(roomId, sender, "chat", msg.Id, text, true)
+    }
+}
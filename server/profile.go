@@ -0,0 +1,126 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+const (
+    maxDisplayNameRunes = 64
+    maxAvatarURLBytes   = 512
+)
+
+func validateDisplayName(name string) (string, error) {
+    name = strings.TrimSpace(name)
+    if utf8.RuneCountInString(name) > maxDisplayNameRunes {
+        return "", fmt.Errorf("displayName longer than %d characters", maxDisplayNameRunes)
+    }
+    for _, r := range name {
+        if unicode.IsControl(r) {
+            return "", fmt.Errorf("displayName contains control characters")
+        }
+    }
+    return name, nil
+}
+
+func validateAvatar(avatar string) (string, error) {
+    avatar = strings.TrimSpace(avatar)
+    if avatar == "" {
+        return "", nil
+    }
+    if len(avatar) > maxAvatarURLBytes {
+        return "", fmt.Errorf("avatar URL longer than %d bytes", maxAvatarURLBytes)
+    }
+    u, err := url.Parse(avatar)
+    if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+        return "", fmt.Errorf("avatar must be an http(s) URL")
+    }
+    return avatar, nil
+}
+
+// displayNameOf falls back to the client ID so UIs always have something to show.
+func displayNameOf(client *Client) string {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    if client.displayName != "" {
+        return client.displayName
+    }
+    return client.clientId
+}
+
+func avatarOf(client *Client) string {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    
+    return client.avatar
+}
+
+func setInitialProfile(client *Client, displayName string, avatar string) error {
+    name, err := validateDisplayName(displayName)
+    if err != nil {
+        return err
+    }
+    avatar, err = validateAvatar(avatar)
+    if err != nil {
+        return err
+    }
+    
+    client.displayName = name
+    client.avatar = avatar
+    return nil
+}
+
+// profile_update: {"displayName": TEXT, "avatar": URL}, omitted fields are unchanged
+func handleProfileUpdate(roomId string, sender *Client, msg *Message) {
+    data, ok := msg.Data.(map[string]interface{})
+    if !ok {
+        sendError(sender, ErrInvalidMessage, msg, "profile_update data must be an object")
+        return
+    }
+    
+    changed := make(map[string]interface{})
+    
+    sender.mu.Lock()
+    name, hasName := data["displayName"].(string)
+    avatar, hasAvatar := data["avatar"].(string)
+    
+    var err error
+    if hasName {
+        if name, err = validateDisplayName(name); err == nil {
+            sender.displayName = name
+            changed["displayName"] = name
+        }
+    }
+    if hasAvatar && err == nil {
+        if avatar, err = validateAvatar(avatar); err == nil {
+            sender.avatar = avatar
+            changed["avatar"] = avatar
+        }
+    }
+    sender.mu.Unlock()
+    
+    if err != nil {
+        sendError(sender, ErrInvalidMessage, msg, "%v", err)
+        return
+    }
+    if len(changed) == 0 {
+        return
+    }
+    
+    broadcastToRoom(roomId, nil, &Message{
+        Type: "profile_updated",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "clientId":    sender.clientId,
+            "displayName": displayNameOf(sender),
+            "avatar":      avatarOf(sender),
+            "changed":     changed,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
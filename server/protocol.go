@@ -0,0 +1,170 @@
+package main
+
+import (
+    "strings"
+    "time"
+)
+
+// Bump ProtocolVersion when message shapes change incompatibly.
+const ProtocolVersion = 1
+
+// Message types clients may send, advertised in the welcome payload.
+var clientMessageTypes = []string{
+    "broadcast", "selective", "agent_only", "user_only",
+    "metadata", "profile_update",
+    "typing", "reaction", "read",
+    "file_receipt",
+    "channel_open", "channel_close", "channel_audio",
+    "recording_start", "recording_stop", "recording_pause", "recording_resume",
+    "handoff", "kick",
+    "speak", "speak_stop", "speak_stream",
+    "assistant_final", "answer_lookup",
+    "verify_start", "verify_answer", "tool_authorize",
+    "speaker_consent", "speaker_enroll",
+    "integration_status", "callback_request", "usage", "kg_facts",
+    "disposition",
+    "dtmf", "secure_capture_start", "secure_capture_stop",
+    "consult_start", "consult_end", "consult_transfer", "consult_message",
+}
+
+// Message types only the server emits. Clients sending them are dropped so
+// nobody can forge room events.
+var systemMessageTypes = []string{
+    "welcome", "client_joined", "client_left", "error",
+    "metadata_updated", "profile_updated",
+    "announcement", "kicked", "file_shared", "delivery_report",
+    "replay_complete", "transcript", "transcript_partial",
+    "tts_started", "tts_finished", "moderation_event", "profanity_alert",
+    "verify_challenge", "verify_result", "tool_authorization",
+    "speaker_recognized", "speaker_enrolled", "speaker_consent_updated",
+    "fallback", "fallback_cleared", "callback_queued", "amd_result",
+    "wrap_up_started", "wrap_up_ended", "recording_paused", "recording_resumed",
+    "secure_capture_started", "secure_capture_ended", "latency_budget",
+    "answer_cached", "quota_warning", "quota_exceeded", "cost_alert",
+    "channel_opened", "channel_closed", "channel_audio_changed",
+    "consult_started", "consult_ended", "kg_facts_staged", "session_resumed",
+    "room_config", "redirect", "slow_consumer",
+}
+
+// SystemSender is the From of every server generated message and can't be
+// used as a client ID.
+const SystemSender = "system"
+
+func isSystemMessageType(msgType string) bool {
+    for _, t := range systemMessageTypes {
+        if t == msgType {
+            return true
+        }
+    }
+    return false
+}
+
+func isReservedClientId(clientId string) bool {
+    return strings.EqualFold(clientId, SystemSender)
+}
+
+var supportedAudioCodecs = []string{"pcm16"}
+
+type RecordingState struct {
+    Id              string          `json:"id,omitempty"` // Recording metadata ID in storage
+    Active          bool            `json:"active"`
+    StartedBy       string          `json:"startedBy,omitempty"`
+    StartedAt       int64           `json:"startedAt,omitempty"`
+    ConsentRequired bool            `json:"consentRequired"`
+    Pause           *RecordingPause `json:"pause,omitempty"` // See recording.go
+}
+
+func recordingStatus(room *RoomInfo) RecordingState {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    
+    state := room.Recording
+    state.ConsentRequired = cfg.RecordingConsentRequired
+    return state
+}
+
+// handleRecordingControl tracks whether the room is being recorded and relays
+// the request to the room so the recording agent can act on it.
+func handleRecordingControl(roomId string, sender *Client, msg *Message) {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return
+    }
+    if msg.Type == "recording_start" {
+        if !room.Recording.Active {
+            room.Recording = RecordingState{
+                Id:        newRandomId(),
+                Active:    true,
+                StartedBy: sender.clientId,
+                StartedAt: time.Now().UnixNano() / int64(time.Millisecond),
+                Pause:     room.Recording.Pause,
+            }
+            recordRecordingStarted(room)
+        }
+    } else {
+        if room.Recording.Active {
+            recordRecordingStopped(room.RoomId, room.Recording.Id)
+        }
+        // A pause outlives the recording, it still keeps audio from transcription
+        room.Recording = RecordingState{Pause: room.Recording.Pause}
+    }
+    roomsMu.Unlock()
+    
+    broadcastToRoom(roomId, sender, msg)
+}
+
+// ttsVoiceFor picks the voice configured for the room's template, falling
+// back to its locale's and then the server default.
+func ttsVoiceFor(room *RoomInfo) string {
+    for _, entry := range cfg.TTSVoices {
+        template, voice, ok := strings.Cut(entry, "=")
+        if ok && template == room.Template {
+            return voice
+        }
+    }
+    if voice, ok := localeVoice(room); ok {
+        return voice
+    }
+    return cfg.DefaultTTSVoice
+}
+
+func roomConfig(room *RoomInfo) map[string]interface{} {
+    retention := retentionForTenant(room.Tenant)
+    return map[string]interface{}{
+        "tenant":    room.Tenant,
+        "template":  room.Template,
+        "locale":    room.Locale,
+        "createdAt": room.CreatedAt,
+        "limits": map[string]interface{}{
+            "maxMetadataKeys":       cfg.MaxMetadataKeys,
+            "maxMetadataValueBytes": cfg.MaxMetadataValueBytes,
+            "maxFrameBytes":         cfg.MaxFrameBytes,
+            "maxMessageBytes":       cfg.MaxMessageBytes,
+            "maxRecipients":         cfg.MaxRecipients,
+            "maxFileBytes":          cfg.MaxFileBytes,
+            "historyMaxMessages":    retention.MaxMessages,
+        },
+        "stt": sttNameFor(room),
+        "tts": ttsSettingsFor(room),
+        "latencyBudget": roomLatencyBudget(room),
+        "experiments": roomExperiments(room),
+        "quotas": roomQuotaLimits(room),
+        "kgPartition": kgPartitionFor(room),
+        "kgSnapshot": room.KGSnapshot,
+        "duplicateClientIds": duplicateIdPolicyFor(room),
+    }
+}
+
+func serverCapabilities() map[string]interface{} {
+    return map[string]interface{}{
+        "messageTypes": clientMessageTypes,
+        "audio": map[string]interface{}{
+            "codecs":      supportedAudioCodecs,
+            "passthrough": true, // Other payloads are forwarded untouched
+            "frameMs":     cfg.AudioFrameMs,
+        },
+        "compression": cfg.WSCompression,
+    }
+}
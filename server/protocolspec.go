@@ -0,0 +1,265 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// GET /protocol describes the WebSocket protocol as an AsyncAPI 3.0 document
+// for client codegen: the Message envelope reflected from its Go type, and
+// every type of clientMessageTypes and systemMessageTypes as a message with
+// its direction, the permission sending it takes and its data, reflected
+// from the Go type where the server sends one and listed field by field
+// where handlers read loose JSON.
+// x-direction is "client" for what clients send the server, "server" for
+// what only the server sends and "relay" for what clients send that the
+// server passes on to others as is. x-close-codes lists the codes the
+// server closes connections with, see closecodes.go.
+
+type messageSpec struct {
+    Summary string
+    Data    map[string]string // Field to JSON type, "string[]" for arrays
+    Type    interface{}       // Zero value of the data's Go type, instead of Data
+}
+
+// relayedMessageTypes reach other participants as their sender wrote them.
+var relayedMessageTypes = []string{
+    "broadcast", "selective", "agent_only", "user_only",
+    "typing", "reaction", "read", "file_receipt",
+    "recording_start", "recording_stop", "handoff", "assistant_final",
+    "consult_message",
+}
+
+var messageSpecs = map[string]messageSpec{
+    "broadcast":  {"Chat to everyone else in the room", map[string]string{"text": "string"}, nil},
+    "selective":  {"Chat to the client IDs in to, answered with a delivery_report", map[string]string{"text": "string"}, nil},
+    "agent_only": {"Chat to the room's agents", map[string]string{"text": "string"}, nil},
+    "user_only":  {"Chat to the room's users", map[string]string{"text": "string"}, nil},
+    "metadata":   {"Change the sender's metadata, null values remove keys", map[string]string{}, nil},
+    "profile_update": {"Change the sender's display name or avatar, omitted fields are unchanged",
+        map[string]string{"displayName": "string", "avatar": "string"}, nil},
+    "typing":       {"Typing indicator, not kept in history", map[string]string{"state": "string"}, nil},
+    "reaction":     {"Reaction to a message, not kept in history", map[string]string{"messageId": "string", "emoji": "string"}, nil},
+    "read":         {"Read receipt, not kept in history", map[string]string{"messageId": "string"}, nil},
+    "file_receipt": {"Acknowledge a shared file", map[string]string{"fileId": "string"}, nil},
+    "channel_open": {"Open a private channel to a peer", map[string]string{"peer": "string", "audio": "boolean"}, nil},
+    "channel_close": {"Close a private channel", map[string]string{"channelId": "string"}, nil},
+    "channel_audio": {"Divert the sender's audio into a private channel or back",
+        map[string]string{"channelId": "string", "enabled": "boolean"}, nil},
+    "recording_start":  {"Start recording the room", nil, nil},
+    "recording_stop":   {"Stop recording the room", nil, nil},
+    "recording_pause":  {"Pause the recording, e.g. while payment details are read out", map[string]string{"reason": "string"}, nil},
+    "recording_resume": {"Resume a paused recording", map[string]string{"reason": "string"}, nil},
+    "handoff":          {"Hand the call to another agent or queue", nil, nil},
+    "kick":             {"Disconnect a participant", map[string]string{"clientId": "string", "reason": "string"}, nil},
+    "speak":            {"Synthesize text, or an SSML <speak> document, and play it into the room", map[string]string{"text": "string", "ssml": "string", "voice": "string"}, nil},
+    "speak_stop":       {"Stop the sender's speech", nil, nil},
+    "speak_stream": {"Stream LLM text into speech as it is generated",
+        map[string]string{"streamId": "string", "text": "string", "final": "boolean"}, nil},
+    "assistant_final": {"A virtual agent's final answer, with its knowledge graph citations",
+        map[string]string{"text": "string", "citations": "array", "question": "string", "kgVersion": "string", "cache": "boolean", "grounded": "boolean"}, nil},
+    "answer_lookup": {"Look a question up in the answer cache, answered with answer_cached",
+        map[string]string{"question": "string", "kgVersion": "string", "speak": "boolean"}, nil},
+    "verify_start": {"Start verifying a caller's identity",
+        map[string]string{"method": "string", "userId": "string", "customerId": "string"}, nil},
+    "verify_answer": {"Answer a verification challenge",
+        map[string]string{"verificationId": "string", "answers": "object"}, nil},
+    "tool_authorize": {"Ask whether a sensitive tool may run, answered with tool_authorization",
+        map[string]string{"tool": "string", "userId": "string", "requestId": "string"}, nil},
+    "speaker_consent": {"Record a caller's voiceprint consent",
+        map[string]string{"granted": "boolean", "customerId": "string", "userId": "string"}, nil},
+    "speaker_enroll": {"Enroll a verified caller's voiceprint", map[string]string{"userId": "string"}, nil},
+    "integration_status": {"Report an integration's health, for fallbacks",
+        map[string]string{"integration": "string", "state": "string", "error": "string"}, nil},
+    "callback_request": {"Queue a callback to the caller",
+        map[string]string{"userId": "string", "phone": "string", "note": "string", "notBefore": "integer"}, nil},
+    "kg_facts": {"Submit facts extracted from the conversation for the knowledge graph, answered with kg_facts_staged",
+        map[string]string{"messageId": "string", "facts": "array"}, nil},
+    "usage": {"Report the LLM tokens a completion cost, not kept in history",
+        map[string]string{"promptTokens": "integer", "completionTokens": "integer", "totalTokens": "integer", "model": "string"}, nil},
+    "disposition": {"Dispose the call's wrap-up",
+        map[string]string{"code": "string", "notes": "string", "wrapUpId": "string"}, nil},
+    "dtmf": {"Keypad digits from a caller", map[string]string{"digits": "string"}, nil},
+    "secure_capture_start": {"Capture the caller's keypad digits away from everyone else",
+        map[string]string{"purpose": "string", "maxDigits": "integer", "terminator": "string"}, nil},
+    "secure_capture_stop": {"Cancel the room's secure capture", nil, nil},
+    "consult_start": {"Hold the caller and bridge the sending agent to participants of another room",
+        map[string]string{"roomId": "string", "peers": "string[]", "holdPrompt": "string"}, nil},
+    "consult_end":      {"End the consult and take the caller off hold", map[string]string{"consultId": "string"}, nil},
+    "consult_transfer": {"End the consult by handing the caller to its peers", map[string]string{"consultId": "string"}, nil},
+    "consult_message":  {"Text to the other side of the consult", map[string]string{"consultId": "string", "text": "string"}, nil},
+    
+    "welcome": {"Sent on joining: the room, its participants, its config and the server's capabilities",
+        map[string]string{"roomId": "string", "clientId": "string", "clientType": "string", "role": "string",
+            "displayName": "string", "avatar": "string", "users": "string[]", "agents": "string[]", "participants": "array",
+            "metadata": "object", "protocolVersion": "integer", "capabilities": "object", "room": "object",
+            "recording": "object", "ttsVoice": "string", "resumeToken": "string", "seq": "integer"}, nil},
+    "client_joined": {"A participant joined",
+        map[string]string{"clientId": "string", "clientType": "string", "role": "string", "displayName": "string", "avatar": "string", "metadata": "object"}, nil},
+    "client_left":      {"A participant left, reason closed, timeout or replaced", map[string]string{"clientId": "string", "clientType": "string", "reason": "string"}, nil},
+    "session_resumed":  {"A dropped participant reconnected within the resume grace, in place of client_left and client_joined",
+        map[string]string{"clientId": "string", "clientType": "string", "role": "string", "displayName": "string", "avatar": "string", "metadata": "object", "awayMs": "integer"}, nil},
+    "room_config":      {"The room's config changed, as it would be in welcome now, see POST /admin/rooms/refresh",
+        map[string]string{"room": "object", "ttsVoice": "string", "reason": "string"}, nil},
+    "redirect":         {"The room moved to another server: reconnect to url with resumeToken, see POST /admin/rooms/migrate",
+        map[string]string{"url": "string", "roomId": "string", "resumeToken": "string", "reason": "string"}, nil},
+    "slow_consumer":    {"A participant's send queue overflowed (lagging true), or it caught up having lost dropped frames",
+        map[string]string{"clientId": "string", "clientType": "string", "lagging": "boolean", "queue": "string", "dropped": "integer"}, nil},
+    "error":            {"A message was refused", map[string]string{"code": "string", "message": "string"}, nil},
+    "metadata_updated": {"A participant's metadata changed", map[string]string{"clientId": "string", "clientType": "string", "changed": "object"}, nil},
+    "profile_updated":  {"A participant's profile changed", map[string]string{"clientId": "string", "displayName": "string", "avatar": "string", "changed": "string[]"}, nil},
+    "announcement":     {"An operator announcement, see POST /broadcast", map[string]string{"text": "string", "data": "object"}, nil},
+    "kicked":           {"The receiver was disconnected", map[string]string{"by": "string", "reason": "string"}, nil},
+    "file_shared":      {"A file was shared with the room, see POST /room/{roomId}/files", nil, SharedFile{}},
+    "delivery_report":  {"Who a selective message reached", nil, DeliveryReport{}},
+    "replay_complete":  {"Missed messages were replayed on resume", map[string]string{"fromSeq": "integer", "toSeq": "integer", "replayed": "integer", "complete": "boolean"}, nil},
+    "transcript": {"A final transcript of a participant's speech",
+        map[string]string{"clientId": "string", "text": "string", "final": "boolean", "confidence": "number", "language": "string", "provider": "string", "startMs": "integer", "endMs": "integer", "clipUrl": "string"}, nil},
+    "transcript_partial": {"An interim transcript, superseded by the next", map[string]string{"clientId": "string", "text": "string", "final": "boolean"}, nil},
+    "tts_started":        {"Speech started playing", map[string]string{"requestId": "string", "clientId": "string", "format": "string", "sampleRate": "integer", "cached": "boolean"}, nil},
+    "tts_finished":       {"Speech finished playing", map[string]string{"requestId": "string", "clientId": "string", "interrupted": "boolean"}, nil},
+    "moderation_event":   {"A message was flagged or blocked by moderation", map[string]string{"clientId": "string", "messageId": "string", "action": "string", "source": "string"}, nil},
+    "profanity_alert":    {"Profanity was masked", map[string]string{"clientId": "string", "clientType": "string", "messageId": "string", "count": "integer", "source": "string"}, nil},
+    "verify_challenge":   {"Questions or a passcode prompt for the caller", map[string]string{"verificationId": "string", "method": "string", "userId": "string", "prompts": "array"}, nil},
+    "verify_result": {"Whether the caller was verified",
+        map[string]string{"verificationId": "string", "method": "string", "userId": "string", "customerId": "string", "verified": "boolean", "score": "number", "attemptsLeft": "integer"}, nil},
+    "tool_authorization":      {"Whether a sensitive tool may run", map[string]string{"tool": "string", "userId": "string", "requestId": "string", "allowed": "boolean", "reason": "string"}, nil},
+    "speaker_recognized":      {"A caller's voice matched an enrolled voiceprint", map[string]string{"userId": "string", "customerId": "string", "score": "number"}, nil},
+    "speaker_enrolled":        {"A caller's voiceprint was enrolled", map[string]string{"userId": "string", "customerId": "string", "seconds": "number"}, nil},
+    "speaker_consent_updated": {"A caller's voiceprint consent changed", map[string]string{"userId": "string", "customerId": "string", "granted": "boolean"}, nil},
+    "fallback":                {"An integration failed and a fallback applies", map[string]string{"integration": "string", "action": "string", "reason": "string"}, nil},
+    "fallback_cleared":        {"An integration recovered", map[string]string{"integration": "string"}, nil},
+    "callback_queued":         {"A callback was queued", map[string]string{"callbackId": "string", "requestId": "string", "userId": "string", "phone": "string"}, nil},
+    "amd_result":              {"Whether an outbound call reached a person or a machine", map[string]string{"callId": "string", "clientId": "string", "answeredBy": "string", "source": "string", "elapsedMs": "integer"}, nil},
+    "wrap_up_started":         {"The agent's wrap-up window opened", map[string]string{"wrapUpId": "string", "roomId": "string", "clientId": "string", "agentId": "string", "codes": "string[]", "deadline": "integer"}, nil},
+    "wrap_up_ended":           {"The wrap-up was disposed or lapsed", map[string]string{"wrapUpId": "string", "clientId": "string", "agentId": "string", "code": "string", "notes": "string", "lapsed": "boolean"}, nil},
+    "recording_paused":        {"The recording was paused", map[string]string{"recordingId": "string", "pausedBy": "string", "reason": "string"}, nil},
+    "recording_resumed":       {"The recording was resumed", map[string]string{"resumedBy": "string", "reason": "string", "pausedAt": "integer", "resumedAt": "integer", "durationMs": "integer"}, nil},
+    "secure_capture_started":  {"The caller's keypad is being captured", map[string]string{"captureId": "string", "purpose": "string", "maxDigits": "integer", "terminator": "string", "startedBy": "string"}, nil},
+    "secure_capture_ended":    {"The secure capture ended; only the agent that started it gets the digits", map[string]string{"captureId": "string", "purpose": "string", "reason": "string", "length": "integer", "digits": "string"}, nil},
+    "latency_budget":          {"The room's replies are over or back under the latency budget", map[string]string{"state": "string", "p95Ms": "integer", "targetMs": "integer", "model": "string", "maxContextTurns": "integer"}, nil},
+    "kg_facts_staged":         {"Which submitted facts were approved and which wait for review", map[string]string{"requestId": "string", "approved": "array", "pending": "array"}, nil},
+    "answer_cached":           {"The answer cache's reply to answer_lookup", map[string]string{"hit": "boolean", "answerId": "string", "question": "string", "text": "string", "citations": "array"}, nil},
+    "quota_warning":           {"The room is nearing a quota", map[string]string{"quota": "string", "used": "integer", "limit": "integer", "unit": "string"}, nil},
+    "quota_exceeded":          {"The room is past a quota and closing", map[string]string{"quota": "string", "used": "integer", "limit": "integer", "unit": "string", "closingInMs": "integer"}, nil},
+    "cost_alert":              {"The call's estimated spend crossed a -cost-alerts threshold", map[string]string{"threshold": "number", "cost": "object"}, nil},
+    "channel_opened":          {"A private channel opened", map[string]string{"channelId": "string", "members": "string[]", "audio": "boolean"}, nil},
+    "channel_closed":          {"A private channel closed", map[string]string{"channelId": "string", "closedBy": "string"}, nil},
+    "channel_audio_changed":   {"A member's audio moved into or out of a private channel", map[string]string{"channelId": "string", "clientId": "string", "enabled": "boolean"}, nil},
+    "consult_started":         {"A consult bridge opened, sent to its agent and peers", nil, Consult{}},
+    "consult_ended":           {"A consult ended; after a transfer each peer gets the caller's room and a ticket to join it", map[string]string{"consultId": "string", "endedBy": "string", "reason": "string", "roomId": "string", "ticket": "string"}, nil},
+}
+
+func protocolDocument() map[string]interface{} {
+    schemas := openAPISchemas{}
+    envelope := schemas.of(reflect.TypeOf(Message{}))
+    relayed := make(map[string]bool)
+    for _, msgType := range relayedMessageTypes {
+        relayed[msgType] = true
+    }
+    
+    messages := make(map[string]interface{})
+    var received, sent []interface{}
+    describe := func(msgType string, direction string) {
+        spec := messageSpecs[msgType]
+        properties := make(map[string]interface{}, len(spec.Data))
+        for field, kind := range spec.Data {
+            if strings.HasSuffix(kind, "[]") {
+                properties[field] = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": strings.TrimSuffix(kind, "[]")}}
+            } else {
+                properties[field] = map[string]interface{}{"type": kind}
+            }
+        }
+        data := map[string]interface{}{"type": "object", "properties": properties}
+        if spec.Type != nil {
+            data = schemas.of(reflect.TypeOf(spec.Type))
+        }
+        message := map[string]interface{}{
+            "name":        msgType,
+            "summary":     spec.Summary,
+            "x-direction": direction,
+            "payload": map[string]interface{}{"allOf": []interface{}{envelope, map[string]interface{}{
+                "properties": map[string]interface{}{
+                    "type": map[string]interface{}{"const": msgType},
+                    "data": data,
+                },
+            }}},
+        }
+        if direction != "server" {
+            if perm, needed := messagePermission(msgType); needed {
+                message["x-permission"] = perm
+            }
+        }
+        messages[msgType] = message
+        ref := map[string]interface{}{"$ref": "#/channels/room/messages/" + msgType}
+        if direction != "server" {
+            received = append(received, ref)
+        }
+        if direction != "client" {
+            sent = append(sent, ref)
+        }
+    }
+    for _, msgType := range clientMessageTypes {
+        direction := "client"
+        if relayed[msgType] {
+            direction = "relay"
+        }
+        describe(msgType, direction)
+    }
+    for _, msgType := range systemMessageTypes {
+        describe(msgType, "server")
+    }
+    sortRefs := func(refs []interface{}) {
+        sort.Slice(refs, func(i, j int) bool {
+            return refs[i].(map[string]interface{})["$ref"].(string) < refs[j].(map[string]interface{})["$ref"].(string)
+        })
+    }
+    sortRefs(received)
+    sortRefs(sent)
+    
+    return map[string]interface{}{
+        "asyncapi": "3.0.0",
+        "info": map[string]interface{}{
+            "title":       "IVA media server WebSocket protocol",
+            "version":     strconv.Itoa(ProtocolVersion),
+            "description": "JSON messages in text frames; binary frames carry audio. Clients are told the types they may send in welcome.capabilities.messageTypes.",
+        },
+        "channels": map[string]interface{}{
+            "room": map[string]interface{}{
+                "address":  "/ws",
+                "messages": messages,
+            },
+        },
+        "operations": map[string]interface{}{
+            "receiveClientMessages": map[string]interface{}{
+                "action":   "receive",
+                "channel":  map[string]interface{}{"$ref": "#/channels/room"},
+                "messages": received,
+            },
+            "sendToClients": map[string]interface{}{
+                "action":   "send",
+                "channel":  map[string]interface{}{"$ref": "#/channels/room"},
+                "messages": sent,
+            },
+        },
+        "components": map[string]interface{}{
+            "schemas": schemas,
+        },
+        "x-close-codes": closeCodesSpec(),
+    }
+}
+
+// GET /protocol
+func handleProtocol(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Access-Control-Allow-Origin", "*")
+    json.NewEncoder(w).Encode(protocolDocument())
+}
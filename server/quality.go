@@ -0,0 +1,266 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "hash/fnv"
+    "log"
+    "net/http"
+    "time"
+    
+    "github.com/yourusername/my-go-project/quality"
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Post-call quality scoring, on with -quality-url. When a call closes, an LLM
+// judge (package quality) grades its transcript for resolution, accuracy
+// against the knowledge graph facts the agent's answers cited, and tone,
+// each 1 to 5. The grade is kept with the CDR and summed into the call's
+// analytics rollups, so /analytics and experiment reports show average
+// scores and low quality calls (overall under -quality-low-score) beside the
+// other numbers. -quality-sample-rate grades a share of the calls, picked by
+// CDR ID. GET /room/{id}/quality has a room's latest grade, POST grades it
+// again now.
+
+var (
+    qualityWorkers = make(chan struct{}, 4) // Concurrent gradings
+    
+    callQuality      = newHistogramVec("iva_call_quality_score", "Overall post-call quality score of graded calls, 1 to 5.", []float64{1.5, 2, 2.5, 3, 3.5, 4, 4.5, 5})
+    qualityRunsTotal = newCounterVec("iva_quality_scoring_total", "Post-call quality gradings by result.", "result")
+)
+
+func init() {
+    metricSeries = append(metricSeries, qualityRunsTotal)
+}
+
+func qualityEnabled() bool {
+    return cfg.QualityURL != ""
+}
+
+func qualityJudge() *quality.Judge {
+    return &quality.Judge{URL: cfg.QualityURL, APIKey: cfg.QualityAPIKey, Model: cfg.QualityModel}
+}
+
+// sampledForQuality picks the same calls however often it is asked.
+func sampledForQuality(cdrId string) bool {
+    if cfg.QualitySampleRate >= 1 {
+        return true
+    }
+    h := fnv.New32a()
+    h.Write([]byte(cdrId))
+    return float64(h.Sum32()%10000) < cfg.QualitySampleRate*10000
+}
+
+// scheduleQualityScoring grades a closed call in the background, once the
+// durable writes queued before it on the room's writer, its transcript and
+// CDR, are done.
+func scheduleQualityScoring(cdr storage.CDR) {
+    if !qualityEnabled() || !sampledForQuality(cdr.Id) {
+        return
+    }
+    persistBestEffort(cdr.RoomId, func(context.Context) error {
+        go func() {
+            qualityWorkers <- struct{}{}
+            defer func() { <-qualityWorkers }()
+            
+            ctx, cancel := context.WithTimeout(context.Background(), cfg.QualityTimeout)
+            defer cancel()
+            if _, err := scoreCall(ctx, cdr, cfg.QualityMinUtterances); err != nil {
+                log.Printf("Scoring call %s of room %s failed: %v", cdr.Id, cdr.RoomId, err)
+            }
+        }()
+        return nil
+    })
+}
+
+// Transcript entry types graded, speak being how voice agents answer.
+var gradedTypes = map[string]bool{
+    "transcript": true, "broadcast": true, "selective": true, "user_only": true, "assistant_final": true, "speak": true,
+}
+
+// callLines is the call's side of the room transcript, agents' answers with
+// the facts they cited.
+func callLines(ctx context.Context, cdr storage.CDR) ([]quality.Line, error) {
+    entries, err := roomTranscript(ctx, cdr.RoomId)
+    if err != nil {
+        return nil, err
+    }
+    agents := make(map[string]bool)
+    for _, participant := range cdr.Participants {
+        if participant.ClientType == string(ClientTypeAgent) {
+            agents[participant.ClientId] = true
+        }
+    }
+    var lines []quality.Line
+    for _, entry := range entries {
+        if !gradedTypes[entry.Type] || entry.Timestamp < cdr.StartedAt || (cdr.EndedAt > 0 && entry.Timestamp > cdr.EndedAt) {
+            continue
+        }
+        text := searchText(entry.Data)
+        if text == "" {
+            continue
+        }
+        speaker := entry.From
+        if entry.Type == "transcript" {
+            var data struct {
+                ClientId string `json:"clientId"`
+            }
+            json.Unmarshal(entry.Data, &data)
+            speaker = data.ClientId
+        }
+        line := quality.Line{Speaker: speaker, Agent: agents[speaker], Text: text}
+        // The speak of an answer already written out says nothing new
+        if len(lines) > 0 && lines[len(lines)-1].Speaker == speaker && lines[len(lines)-1].Text == text {
+            continue
+        }
+        var citations []Citation
+        if len(entry.Citations) > 0 && json.Unmarshal(entry.Citations, &citations) == nil {
+            for _, c := range citations {
+                fact := c.Snippet
+                if fact == "" {
+                    fact = c.Label
+                }
+                if fact != "" {
+                    line.Facts = append(line.Facts, fact)
+                }
+            }
+        }
+        lines = append(lines, line)
+    }
+    return lines, nil
+}
+
+// scoreCall grades a call with at least minUtterances lines and one from an
+// agent, storing and rolling up the grade. It returns nil for calls too
+// short to grade.
+func scoreCall(ctx context.Context, cdr storage.CDR, minUtterances int) (*storage.CDRQuality, error) {
+    lines, err := callLines(ctx, cdr)
+    if err != nil {
+        return nil, err
+    }
+    answered := false
+    for _, line := range lines {
+        answered = answered || line.Agent
+    }
+    labels := metricLabelsFor(cdr.Tenant, cdr.Template)
+    if len(lines) < minUtterances || !answered {
+        qualityRunsTotal.inc(labels, "skipped")
+        return nil, nil
+    }
+    
+    scores, err := qualityJudge().Score(ctx, lines)
+    if err != nil {
+        qualityRunsTotal.inc(labels, "failed")
+        return nil, err
+    }
+    grade := storage.CDRQuality{
+        Resolution: scores.Resolution,
+        Accuracy:   scores.Accuracy,
+        Tone:       scores.Tone,
+        Overall:    scores.Overall,
+        Notes:      scores.Notes,
+        Model:      cfg.QualityModel,
+        ScoredAt:   time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    persistBestEffort(cdr.RoomId, func(ctx context.Context) error {
+        return store.CDRs().Score(ctx, cdr.Id, grade)
+    })
+    qualityRunsTotal.inc(labels, "scored")
+    callQuality.observe(grade.Overall, labels)
+    if grade.Overall < cfg.QualityLowScore {
+        logAt("warn", cdr.RoomId, "", "Call %s scored %.1f: %s", cdr.Id, grade.Overall, grade.Notes)
+    }
+    rollupQuality(cdr, grade)
+    return &grade, nil
+}
+
+// rollupQuality adds a grade to the rollups of the call's buckets. A call
+// graded again is counted again.
+func rollupQuality(cdr storage.CDR, grade storage.CDRQuality) {
+    delta := storage.Rollup{
+        Tenant:        cdr.Tenant,
+        Template:      cdr.Template,
+        Variant:       variantTag(cdr.Variants),
+        Scored:        1,
+        ResolutionSum: grade.Resolution,
+        ToneSum:       grade.Tone,
+        OverallSum:    grade.Overall,
+    }
+    if grade.Accuracy > 0 {
+        delta.AccuracySum = grade.Accuracy
+        delta.AccuracyCount = 1
+    }
+    if grade.Overall < cfg.QualityLowScore {
+        delta.LowQuality = 1
+    }
+    pendingRollupsMu.Lock()
+    defer pendingRollupsMu.Unlock()
+    for _, granularity := range rollupGranularities {
+        bucket := delta
+        bucket.Granularity = granularity
+        bucket.BucketStart = bucketStart(granularity, cdr.StartedAt)
+        mergePending(bucket)
+    }
+}
+
+// roomCDR finds the record of a room's latest call.
+func roomCDR(ctx context.Context, roomId string) (storage.CDR, error) {
+    room, err := store.Rooms().Get(ctx, roomId)
+    if err != nil {
+        return storage.CDR{}, err
+    }
+    cdrs, err := store.CDRs().List(ctx, room.Tenant, room.CreatedAt, room.CreatedAt+1)
+    if err != nil {
+        return storage.CDR{}, err
+    }
+    for i := len(cdrs) - 1; i >= 0; i-- {
+        if cdrs[i].RoomId == roomId {
+            return cdrs[i], nil
+        }
+    }
+    return storage.CDR{}, storage.ErrNotFound
+}
+
+// GET /room/{id}/quality (admin): the grade of the room's latest call.
+// POST grades it again now, whatever its length.
+func handleRoomQuality(w http.ResponseWriter, r *http.Request, roomId string) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet && r.Method != http.MethodPost {
+        http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    cdr, err := roomCDR(r.Context(), roomId)
+    if err == storage.ErrNotFound {
+        http.Error(w, "No closed call for the room", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Printf("Reading the CDR of room %s failed: %v", roomId, err)
+        http.Error(w, "CDRs unavailable", http.StatusInternalServerError)
+        return
+    }
+    
+    grade := cdr.Quality
+    if r.Method == http.MethodPost {
+        if !qualityEnabled() {
+            http.Error(w, "Quality scoring is not configured", http.StatusNotFound)
+            return
+        }
+        ctx, cancel := context.WithTimeout(r.Context(), cfg.QualityTimeout)
+        defer cancel()
+        grade, err = scoreCall(ctx, cdr, 1)
+        if err != nil {
+            log.Printf("Scoring call %s of room %s failed: %v", cdr.Id, roomId, err)
+            http.Error(w, "Scoring failed: "+err.Error(), http.StatusBadGateway)
+            return
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "roomId":  roomId,
+        "cdrId":   cdr.Id,
+        "quality": grade,
+    })
+}
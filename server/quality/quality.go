@@ -0,0 +1,163 @@
+// Package quality grades a finished call against a rubric with an LLM judge:
+// whether the caller's issue was resolved, whether the agent's answers agree
+// with the knowledge graph facts they cited, and the agent's tone.
+package quality
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "strings"
+)
+
+type Line struct {
+    Speaker string
+    Agent   bool
+    Text    string
+    Facts   []string // What an agent's answer cited, as retrieved
+}
+
+// Scores are on the rubric's 1 to 5 scale. Accuracy is 0 when no answer cited
+// anything to check it against.
+type Scores struct {
+    Resolution float64 `json:"resolution"`
+    Accuracy   float64 `json:"accuracy"`
+    Tone       float64 `json:"tone"`
+    Overall    float64 `json:"overall"` // Mean of the scored dimensions
+    Notes      string  `json:"notes,omitempty"`
+}
+
+// Judge grades through an OpenAI compatible chat API.
+type Judge struct {
+    URL    string // Base URL, e.g. https://api.openai.com/v1
+    APIKey string
+    Model  string
+    Client *http.Client
+}
+
+const rubric = `You grade customer service calls handled by a virtual agent. Read the transcript and reply with a JSON object only:
+{"resolution": N, "accuracy": N or null, "tone": N, "notes": "one sentence on the weakest point"}
+
+Each N is 1 to 5:
+resolution: 5 the caller's issue was fully resolved or correctly handed off, 3 partly, 1 not at all or the caller gave up.
+accuracy: 5 every factual claim of the agent agrees with the facts listed under its lines, 3 minor errors or claims beyond them, 1 it contradicts them. Null when no facts are listed.
+tone: 5 courteous, clear and concise, 3 stilted, repetitive or curt, 1 rude or confusing.`
+
+// Characters of transcript sent to the judge; longer calls keep their start
+// and end.
+const maxTranscript = 24000
+
+func (j *Judge) Score(ctx context.Context, lines []Line) (Scores, error) {
+    request := map[string]interface{}{
+        "model":           j.Model,
+        "temperature":     0,
+        "response_format": map[string]string{"type": "json_object"},
+        "messages": []map[string]string{
+            {"role": "system", "content": rubric},
+            {"role": "user", "content": transcript(lines)},
+        },
+    }
+    var response struct {
+        Choices []struct {
+            Message struct {
+                Content string `json:"content"`
+            } `json:"message"`
+        } `json:"choices"`
+    }
+    if err := j.post(ctx, "/chat/completions", request, &response); err != nil {
+        return Scores{}, err
+    }
+    if len(response.Choices) == 0 {
+        return Scores{}, fmt.Errorf("empty response")
+    }
+    var grade struct {
+        Resolution float64  `json:"resolution"`
+        Accuracy   *float64 `json:"accuracy"`
+        Tone       float64  `json:"tone"`
+        Notes      string   `json:"notes"`
+    }
+    content := strings.TrimSpace(response.Choices[0].Message.Content)
+    content = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```"), "```")
+    if err := json.Unmarshal([]byte(content), &grade); err != nil {
+        return Scores{}, fmt.Errorf("grade is not JSON: %v", err)
+    }
+    scores := Scores{Resolution: clamp(grade.Resolution), Tone: clamp(grade.Tone), Notes: grade.Notes}
+    if scores.Resolution == 0 || scores.Tone == 0 {
+        return Scores{}, fmt.Errorf("grade is missing resolution or tone")
+    }
+    scores.Overall = (scores.Resolution + scores.Tone) / 2
+    if grade.Accuracy != nil && hasFacts(lines) {
+        scores.Accuracy = clamp(*grade.Accuracy)
+        scores.Overall = (scores.Resolution + scores.Accuracy + scores.Tone) / 3
+    }
+    return scores, nil
+}
+
+func clamp(score float64) float64 {
+    if score == 0 || math.IsNaN(score) {
+        return 0
+    }
+    return math.Max(1, math.Min(5, score))
+}
+
+func hasFacts(lines []Line) bool {
+    for _, line := range lines {
+        if line.Agent && len(line.Facts) > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// transcript writes the call for the judge, each agent line followed by the
+// facts it cited.
+func transcript(lines []Line) string {
+    var b strings.Builder
+    for _, line := range lines {
+        role := "Caller"
+        if line.Agent {
+            role = "Agent"
+        }
+        fmt.Fprintf(&b, "%s: %s\n", role, line.Text)
+        for _, fact := range line.Facts {
+            fmt.Fprintf(&b, "    fact: %s\n", fact)
+        }
+    }
+    text := b.String()
+    if len(text) > maxTranscript {
+        text = text[:maxTranscript/2] + "\n[...]\n" + text[len(text)-maxTranscript/2:]
+    }
+    return text
+}
+
+func (j *Judge) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+    data, _ := json.Marshal(body)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(j.URL, "/")+path, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if j.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+j.APIKey)
+    }
+    
+    client := j.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
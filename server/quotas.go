@@ -0,0 +1,355 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Per-room quotas cap what one runaway call can cost: how long the room stays
+// open, the minutes of audio its participants stream into it and the LLM
+// tokens its agents spend. -room-max-duration, -room-max-audio and
+// -room-max-llm-tokens set the limits, zero being unlimited, and -room-quotas
+// overrides them per template, e.g. "support/duration=1h,support/tokens=0".
+//
+// Agents report what each completion cost, and the report stays out of
+// history:
+//
+//   {"type": "usage", "data": {"promptTokens": 812, "completionTokens": 96}}
+//
+// or {"totalTokens": N}. Once a room has used -room-quota-warn of a limit
+// everyone in it gets a quota_warning, so the agent can wrap up, and once it
+// has used all of it a quota_exceeded:
+//
+//   {"quota": "duration"|"audio"|"tokens", "used": N, "limit": N,
+//    "unit": "ms"|"tokens", "closingInMs": N}
+//
+// -room-quota-grace later the room's connections are closed with
+// quota_exceeded.
+
+const (
+    quotaDuration = "duration"
+    quotaAudio    = "audio"
+    quotaTokens   = "tokens"
+)
+
+type roomLimits struct {
+    Duration  time.Duration
+    Audio     time.Duration
+    LLMTokens int
+}
+
+// roomUsage is guarded by roomsMu, except audioUs, which read loops add to
+// atomically under the read lock.
+type roomUsage struct {
+    audioUs   int64
+    llmTokens int
+    warned    map[string]bool
+    exceeded  string // The quota the room is being closed for
+}
+
+type quotaState struct {
+    Quota string `json:"quota"`
+    Used  int64  `json:"used"`
+    Limit int64  `json:"limit"`
+    Unit  string `json:"unit"`
+}
+
+var (
+    llmTokensTotal     = newCounterVec("iva_llm_tokens_total", "LLM tokens agents reported spending.")
+    quotaWarningsTotal = newCounterVec("iva_room_quota_warnings_total", "Rooms warned they are nearing a quota.", "quota")
+    quotaExceededTotal = newCounterVec("iva_room_quota_exceeded_total", "Rooms closed for exceeding a quota.", "quota")
+)
+
+func init() {
+    metricSeries = append(metricSeries, llmTokensTotal, quotaWarningsTotal, quotaExceededTotal)
+}
+
+// loadRoomQuotas checks -room-quotas.
+func loadRoomQuotas() error {
+    for _, entry := range cfg.RoomQuotas {
+        key, value, _ := strings.Cut(entry, "=")
+        if i := strings.LastIndex(key, "/"); i >= 0 {
+            key = key[i+1:]
+        }
+        if _, err := parseQuota(key, value); err != nil {
+            return fmt.Errorf("%q: %v", entry, err)
+        }
+    }
+    return nil
+}
+
+func quotasConfigured() bool {
+    return cfg.RoomMaxDuration > 0 || cfg.RoomMaxAudio > 0 || cfg.RoomMaxLLMTokens > 0 || len(cfg.RoomQuotas) > 0
+}
+
+// parseQuota reads a limit, durations for duration and audio, a count for
+// tokens.
+func parseQuota(quota string, value string) (int64, error) {
+    switch quota {
+    case quotaDuration, quotaAudio:
+        d, err := time.ParseDuration(value)
+        if err != nil || d < 0 {
+            return 0, fmt.Errorf("%s needs a duration, e.g. 30m", quota)
+        }
+        return int64(d), nil
+    case quotaTokens:
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 0 {
+            return 0, fmt.Errorf("tokens needs a count")
+        }
+        return int64(n), nil
+    }
+    return 0, fmt.Errorf("unknown quota %q, want duration, audio or tokens", quota)
+}
+
+// roomLimitsFor applies the room template's -room-quotas over the defaults.
+func roomLimitsFor(room *RoomInfo) roomLimits {
+    limits := roomLimits{Duration: cfg.RoomMaxDuration, Audio: cfg.RoomMaxAudio, LLMTokens: cfg.RoomMaxLLMTokens}
+    override := func(quota string) (int64, bool) {
+        if room.Template == "" {
+            return 0, false
+        }
+        value, ok := lookupOverride(cfg.RoomQuotas, room.Template+"/"+quota)
+        if !ok {
+            return 0, false
+        }
+        n, err := parseQuota(quota, value)
+        return n, err == nil
+    }
+    if n, ok := override(quotaDuration); ok {
+        limits.Duration = time.Duration(n)
+    }
+    if n, ok := override(quotaAudio); ok {
+        limits.Audio = time.Duration(n)
+    }
+    if n, ok := override(quotaTokens); ok {
+        limits.LLMTokens = int(n)
+    }
+    return limits
+}
+
+// quotaStates is where the room stands against each of its limits. Callers
+// hold roomsMu.
+func quotaStates(room *RoomInfo, now time.Time) []quotaState {
+    limits := roomLimitsFor(room)
+    var states []quotaState
+    if limits.Duration > 0 {
+        open := now.UnixNano()/int64(time.Millisecond) - room.CreatedAt
+        states = append(states, quotaState{Quota: quotaDuration, Used: open, Limit: limits.Duration.Milliseconds(), Unit: "ms"})
+    }
+    if limits.Audio > 0 {
+        audio := atomic.LoadInt64(&room.usage.audioUs) / 1000
+        states = append(states, quotaState{Quota: quotaAudio, Used: audio, Limit: limits.Audio.Milliseconds(), Unit: "ms"})
+    }
+    if limits.LLMTokens > 0 {
+        states = append(states, quotaState{Quota: quotaTokens, Used: int64(room.usage.llmTokens), Limit: int64(limits.LLMTokens), Unit: "tokens"})
+    }
+    return states
+}
+
+// roomQuotaLimits is advertised in the room config, nil when unlimited.
+func roomQuotaLimits(room *RoomInfo) map[string]interface{} {
+    limits := roomLimitsFor(room)
+    if limits.Duration <= 0 && limits.Audio <= 0 && limits.LLMTokens <= 0 {
+        return nil
+    }
+    quotas := make(map[string]interface{})
+    if limits.Duration > 0 {
+        quotas["maxDurationMs"] = limits.Duration.Milliseconds()
+    }
+    if limits.Audio > 0 {
+        quotas["maxAudioMs"] = limits.Audio.Milliseconds()
+    }
+    if limits.LLMTokens > 0 {
+        quotas["maxLLMTokens"] = limits.LLMTokens
+    }
+    return quotas
+}
+
+// roomUsageView is the room's spend so far, for GET /room/{id}.
+func roomUsageView(room *RoomInfo) map[string]interface{} {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    view := map[string]interface{}{
+        "durationMs": time.Now().UnixNano()/int64(time.Millisecond) - room.CreatedAt,
+        "audioMs":    atomic.LoadInt64(&room.usage.audioUs) / 1000,
+        "llmTokens":  room.usage.llmTokens,
+    }
+    if room.usage.exceeded != "" {
+        view["exceeded"] = room.usage.exceeded
+    }
+    return view
+}
+
+// pcm16BytesPerSecond is the rate of a client's declared pcm16 audio, 0 when
+// it streams something else.
+func pcm16BytesPerSecond(query url.Values) int {
+    if query.Get("audioFormat") != "pcm16" {
+        return 0
+    }
+    rate, err := strconv.Atoi(query.Get("sampleRate"))
+    if err != nil || rate <= 0 {
+        return 0
+    }
+    channels, err := strconv.Atoi(query.Get("channels"))
+    if err != nil || channels <= 0 {
+        channels = 1
+    }
+    return rate * channels * 2
+}
+
+// meterAudio adds a chunk of a participant's audio to the room's usage.
+// Declared pcm16 is measured by its size, anything else by the wall time
+// the stream covers, gaps of a second or more not counting.
+func meterAudio(client *Client, data []byte) {
+    var us int64
+    if client.audioBytesPerSecond > 0 {
+        us = int64(len(data)) * 1000000 / int64(client.audioBytesPerSecond)
+    } else {
+        now := time.Now()
+        if gap := now.Sub(client.lastAudioAt); !client.lastAudioAt.IsZero() && gap < time.Second {
+            us = gap.Microseconds()
+        }
+        client.lastAudioAt = now
+    }
+    if us == 0 {
+        return
+    }
+    roomsMu.RLock()
+    if room := rooms[client.room]; room != nil {
+        atomic.AddInt64(&room.usage.audioUs, us)
+    }
+    roomsMu.RUnlock()
+}
+
+// usage: {"promptTokens": N, "completionTokens": N} or {"totalTokens": N},
+// with the "model" that -llm-prices prices it by (cost.go).
+func handleUsage(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    total, _ := data["totalTokens"].(float64)
+    prompt, _ := data["promptTokens"].(float64)
+    completion, _ := data["completionTokens"].(float64)
+    if total == 0 {
+        total = prompt + completion
+    }
+    if total <= 0 {
+        sendError(sender, ErrInvalidMessage, msg, "usage needs totalTokens, or promptTokens and completionTokens")
+        return
+    }
+    llmTokensTotal.add(total, sender.labels)
+    if len(cfg.LLMPrices) > 0 {
+        model, _ := data["model"].(string)
+        promptPrice, completionPrice := llmPrice(model)
+        var usd float64
+        if prompt+completion > 0 {
+            usd = (prompt*promptPrice + completion*completionPrice) / 1e6
+        } else {
+            usd = total * promptPrice / 1e6
+        }
+        if usd > 0 {
+            if model == "" {
+                model = "default"
+            }
+            llmCostTotal.add(usd, sender.labels, model)
+            addSpend(roomId, spendLLM, usd)
+        }
+    }
+    
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    if room := rooms[roomId]; room != nil {
+        room.usage.llmTokens += int(total)
+    }
+}
+
+func startQuotaEnforcer() {
+    if !quotasConfigured() {
+        return
+    }
+    go func() {
+        for range time.Tick(time.Second) {
+            enforceQuotas(time.Now())
+        }
+    }()
+}
+
+type quotaNotice struct {
+    room     *RoomInfo
+    state    quotaState
+    exceeded bool
+}
+
+// enforceQuotas warns rooms nearing a limit, once per quota, and starts
+// closing rooms past one.
+func enforceQuotas(now time.Time) {
+    var notices []quotaNotice
+    roomsMu.Lock()
+    for _, room := range rooms {
+        if room.usage.exceeded != "" {
+            continue
+        }
+        for _, state := range quotaStates(room, now) {
+            if state.Used >= state.Limit {
+                room.usage.exceeded = state.Quota
+                notices = append(notices, quotaNotice{room: room, state: state, exceeded: true})
+                emitEvent("room_quota_exceeded", room, map[string]interface{}{"quota": state.Quota, "used": state.Used, "limit": state.Limit})
+                break
+            }
+            if !room.usage.warned[state.Quota] && cfg.RoomQuotaWarnAt > 0 && float64(state.Used) >= cfg.RoomQuotaWarnAt*float64(state.Limit) {
+                if room.usage.warned == nil {
+                    room.usage.warned = make(map[string]bool)
+                }
+                room.usage.warned[state.Quota] = true
+                notices = append(notices, quotaNotice{room: room, state: state})
+            }
+        }
+    }
+    roomsMu.Unlock()
+    
+    for _, notice := range notices {
+        room, state := notice.room, notice.state
+        data := map[string]interface{}{"quota": state.Quota, "used": state.Used, "limit": state.Limit, "unit": state.Unit}
+        msgType := "quota_warning"
+        if notice.exceeded {
+            msgType = "quota_exceeded"
+            data["closingInMs"] = cfg.RoomQuotaGrace.Milliseconds()
+            quotaExceededTotal.inc(room.labels, state.Quota)
+            logAt("warn", room.RoomId, "", "Room exceeded its %s quota (%d of %d %s), closing in %v", state.Quota, state.Used, state.Limit, state.Unit, cfg.RoomQuotaGrace)
+            time.AfterFunc(cfg.RoomQuotaGrace, func() { closeRoomForQuota(room) })
+        } else {
+            quotaWarningsTotal.inc(room.labels, state.Quota)
+            logAt("info", room.RoomId, "", "Room nearing its %s quota (%d of %d %s)", state.Quota, state.Used, state.Limit, state.Unit)
+        }
+        broadcastToRoom(room.RoomId, nil, &Message{
+            Id:        newMessageId(),
+            Type:      msgType,
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: now.UnixNano() / int64(time.Millisecond),
+        })
+    }
+}
+
+// closeRoomForQuota disconnects everyone still in the room; the usual leave
+// cleanup closes it once the last has gone.
+func closeRoomForQuota(room *RoomInfo) {
+    roomsMu.RLock()
+    var members []*Client
+    if rooms[room.RoomId] == room {
+        for _, client := range room.Users {
+            members = append(members, client)
+        }
+        for _, client := range room.Agents {
+            members = append(members, client)
+        }
+    }
+    roomsMu.RUnlock()
+    
+    for _, client := range members {
+        closeConnection(client.conn, CloseQuotaExceeded, "")
+    }
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+    return &tokenBucket{
+        rate:   rate,
+        burst:  burst,
+        tokens: burst,
+        last:   time.Now(),
+    }
+}
+
+// take removes n tokens if available. A non-positive rate means unlimited.
+func (b *tokenBucket) take(n float64) bool {
+    if b.rate <= 0 {
+        return true
+    }
+    
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    now := time.Now()
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+    
+    if b.tokens < n {
+        return false
+    }
+    b.tokens -= n
+    return true
+}
+
+func (b *tokenBucket) idleSince(d time.Duration) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    return time.Since(b.last) > d
+}
+
+// Client rate limits. Each connection's JSON messages are limited to
+// -message-rate a second and its audio to -audio-byte-rate bytes a second,
+// both with bursts of twice that, and -message-rates and -audio-byte-rates
+// override them per client type, "agent=50,user=10". All the messages sent
+// into a room are limited together to -room-message-rate, so many clients
+// can't flood it between them either. What's over a limit is dropped, and
+// the sender warned at most once a second with a rate_limited error. A
+// client warned -rate-limit-strikes times within -rate-limit-window for its
+// own limits is closed with rate_limited, without a resume.
+// Drops count in iva_rate_limited_total by limit, and closes in
+// iva_rate_limit_disconnects_total.
+
+var (
+    rateLimited          = newCounterVec("iva_rate_limited_total", "Client messages and audio frames dropped over a rate limit, by limit: messages, audio or room.", "limit")
+    rateLimitDisconnects = newCounterVec("iva_rate_limit_disconnects_total", "Clients closed for breaking their rate limits again and again.")
+)
+
+func init() {
+    metricSeries = append(metricSeries, rateLimited, rateLimitDisconnects)
+}
+
+// clientRates are a connection's buckets, only used by its read loop.
+type clientRates struct {
+    messages *tokenBucket
+    audio    *tokenBucket
+    warnedAt time.Time
+    strikes  []time.Time // Warnings within -rate-limit-window
+}
+
+// checkRateLimits refuses overrides that aren't rates.
+func checkRateLimits() error {
+    for _, entry := range append(append([]string(nil), cfg.MessageRates...), cfg.AudioByteRates...) {
+        if _, err := parseRateOverride(entry); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func parseRateOverride(entry string) (float64, error) {
+    _, value, _ := strings.Cut(entry, "=")
+    rate, err := strconv.ParseFloat(value, 64)
+    if err != nil || rate < 0 {
+        return 0, fmt.Errorf("rate override %q, use TYPE=RATE", entry)
+    }
+    return rate, nil
+}
+
+func rateFor(overrides []string, clientType ClientType, fallback float64) float64 {
+    if value, ok := lookupOverride(overrides, string(clientType)); ok {
+        if rate, err := strconv.ParseFloat(value, 64); err == nil {
+            return rate
+        }
+    }
+    return fallback
+}
+
+func newClientRates(clientType ClientType) *clientRates {
+    messages := rateFor(cfg.MessageRates, clientType, cfg.MessageRate)
+    audio := rateFor(cfg.AudioByteRates, clientType, cfg.AudioByteRate)
+    return &clientRates{
+        messages: newTokenBucket(messages, 2*messages),
+        audio:    newTokenBucket(audio, 2*audio),
+    }
+}
+
+func newRoomRate() *tokenBucket {
+    return newTokenBucket(cfg.RoomMessageRate, 2*cfg.RoomMessageRate)
+}
+
+// allowMessage takes a JSON message from the client's and its room's limits.
+func allowMessage(client *Client) bool {
+    if !client.rates.messages.take(1) {
+        rateLimitExceeded(client, "messages", true)
+        return false
+    }
+    roomsMu.RLock()
+    var roomRate *tokenBucket
+    if room := rooms[client.room]; room != nil {
+        roomRate = room.rate
+    }
+    roomsMu.RUnlock()
+    if roomRate != nil && !roomRate.take(1) {
+        rateLimitExceeded(client, "room", false)
+        return false
+    }
+    return true
+}
+
+// allowAudio takes a frame of audio from the client's limit.
+func allowAudio(client *Client, data []byte) bool {
+    if !client.rates.audio.take(float64(len(data))) {
+        rateLimitExceeded(client, "audio", true)
+        return false
+    }
+    return true
+}
+
+// rateLimitExceeded warns a client whose message or audio was dropped, and
+// closes it on too many strikes of its own.
+func rateLimitExceeded(client *Client, limit string, strike bool) {
+    rateLimited.inc(client.labels, limit)
+    rates := client.rates
+    now := time.Now()
+    if now.Sub(rates.warnedAt) < time.Second {
+        return
+    }
+    rates.warnedAt = now
+    
+    switch limit {
+    case "messages":
+        sendError(client, ErrRateLimited, nil, "over %g messages a second, dropped", client.rates.messages.rate)
+    case "audio":
+        sendError(client, ErrRateLimited, nil, "audio over %g bytes a second, dropped", client.rates.audio.rate)
+    default:
+        sendError(client, ErrRateLimited, nil, "the room is over %g messages a second, dropped", cfg.RoomMessageRate)
+    }
+    if !strike || cfg.RateLimitStrikes <= 0 {
+        return
+    }
+    
+    kept := rates.strikes[:0]
+    for _, at := range rates.strikes {
+        if now.Sub(at) < cfg.RateLimitWindow {
+            kept = append(kept, at)
+        }
+    }
+    rates.strikes = append(kept, now)
+    if len(rates.strikes) < cfg.RateLimitStrikes {
+        return
+    }
+    
+    rateLimitDisconnects.inc(client.labels)
+    logAt("warn", client.room, client.clientId, "Closed for going over its %s rate limit %d times", limit, len(rates.strikes))
+    client.mu.Lock()
+    client.resumeToken = ""
+    client.mu.Unlock()
+    // The read loop fails once the connection closes and runs the usual leave cleanup
+    closeConnection(client.conn, CloseRateLimited, limit)
+}
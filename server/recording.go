@@ -0,0 +1,149 @@
+package main
+
+import (
+    "time"
+)
+
+// Recording pauses for sensitive moments, such as reading out a card number.
+// An agent sends recording_pause and recording_resume ({"reason": TEXT}), or
+// the policy pauses when an agent message's intent metadata is one of
+// -recording-pause-intents and resumes at the next agent message with
+// another. While paused the room's audio goes to no transcription, voice
+// sample or observer (the recording agent, silent monitors), and the room
+// hears recording_paused so the recording agent stops writing media. Every
+// pause is audited with its interval as it ends; -recording-max-pause ends
+// forgotten ones.
+
+// RecordingPause is a pause in progress.
+type RecordingPause struct {
+    PausedBy string `json:"pausedBy"` // Client ID, or policy
+    PausedAt int64  `json:"pausedAt"`
+    Reason   string `json:"reason,omitempty"`
+    timer    *time.Timer
+}
+
+const pausedByPolicy = "policy"
+
+// pauseRecording starts a pause unless one is on, reporting whether it did.
+func pauseRecording(roomId string, by string, reason string) bool {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil || room.Recording.Pause != nil {
+        roomsMu.Unlock()
+        return false
+    }
+    pause := &RecordingPause{PausedBy: by, PausedAt: time.Now().UnixNano() / int64(time.Millisecond), Reason: reason}
+    if cfg.RecordingMaxPause > 0 {
+        pause.timer = time.AfterFunc(cfg.RecordingMaxPause, func() {
+            resumeRecording(roomId, SystemSender, "longest pause reached")
+        })
+    }
+    room.Recording.Pause = pause
+    recordingId := room.Recording.Id
+    audit(room, by, "recording_pause", recordingId, "paused", map[string]interface{}{"reason": reason, "recording": room.Recording.Active})
+    emitEvent("recording_paused", room, map[string]interface{}{"pausedBy": by, "reason": reason, "recordingId": recordingId})
+    roomsMu.Unlock()
+    
+    logAt("info", roomId, "", "Recording paused by %s: %s", by, reason)
+    broadcastToRoom(roomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "recording_paused",
+        From:      SystemSender,
+        Data:      map[string]interface{}{"pausedBy": by, "reason": reason, "recordingId": recordingId},
+        Timestamp: pause.PausedAt,
+    })
+    return true
+}
+
+// resumeRecording ends the pause, auditing the interval.
+func resumeRecording(roomId string, by string, reason string) bool {
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil || room.Recording.Pause == nil {
+        roomsMu.Unlock()
+        return false
+    }
+    pause := room.Recording.Pause
+    room.Recording.Pause = nil
+    if pause.timer != nil {
+        pause.timer.Stop()
+    }
+    interval := map[string]interface{}{
+        "pausedBy":   pause.PausedBy,
+        "pausedAt":   pause.PausedAt,
+        "resumedBy":  by,
+        "resumedAt":  now,
+        "durationMs": now - pause.PausedAt,
+        "reason":     pause.Reason,
+    }
+    if reason != "" {
+        interval["resumeReason"] = reason
+    }
+    audit(room, by, "recording_resume", room.Recording.Id, "resumed", interval)
+    interval["recordingId"] = room.Recording.Id
+    emitEvent("recording_resumed", room, interval)
+    roomsMu.Unlock()
+    
+    logAt("info", roomId, "", "Recording resumed by %s after %s", by, time.Duration(now-pause.PausedAt)*time.Millisecond)
+    broadcastToRoom(roomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "recording_resumed",
+        From:      SystemSender,
+        Data:      interval,
+        Timestamp: now,
+    })
+    return true
+}
+
+// audioPaused reports whether the room's audio is kept from transcription
+// and observers.
+func audioPaused(roomId string) bool {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    room := rooms[roomId]
+    return room != nil && room.Recording.Pause != nil
+}
+
+// hearsAudio is false for observers while the room is paused.
+func hearsAudio(client *Client, paused bool) bool {
+    return !paused || client.role != "observer"
+}
+
+// recording_pause, recording_resume: {"reason": TEXT}
+func handleRecordingPause(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    reason, _ := data["reason"].(string)
+    var changed bool
+    if msg.Type == "recording_pause" {
+        changed = pauseRecording(roomId, sender.clientId, reason)
+    } else {
+        changed = resumeRecording(roomId, sender.clientId, reason)
+    }
+    if !changed {
+        state := "already paused"
+        if msg.Type == "recording_resume" {
+            state = "not paused"
+        }
+        sendError(sender, ErrInvalidMessage, msg, "recording is %s", state)
+    }
+}
+
+// pauseForIntent applies -recording-pause-intents to an agent message.
+func pauseForIntent(roomId string, sender *Client, msg *Message) {
+    if len(cfg.RecordingPauseIntents) == 0 || sender.clientType != ClientTypeAgent {
+        return
+    }
+    intent, _ := msg.Metadata["intent"].(string)
+    if containsString(cfg.RecordingPauseIntents, intent) {
+        pauseRecording(roomId, pausedByPolicy, "intent "+intent)
+        return
+    }
+    roomsMu.RLock()
+    room := rooms[roomId]
+    policyPause := room != nil && room.Recording.Pause != nil && room.Recording.Pause.PausedBy == pausedByPolicy
+    roomsMu.RUnlock()
+    if policyPause {
+        resumeRecording(roomId, pausedByPolicy, "")
+    }
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Version 1 of the REST API lives under /v1/. Lists come in one envelope,
+// paged by key:
+//
+//   GET /v1/list?region=eu-west&healthy=true&limit=50&after=10.0.0.7:8080
+//   {"data": [...], "total": 212, "hasMore": true, "nextAfter": "10.0.0.9:8080"}
+//
+// and errors in another, {"error": {"status": 400, "message": "..."}}.
+// /v1/list keys servers by address:port, a server being healthy while it
+// has heartbeated within -registry-healthy-window. /v1/rooms keys rooms by
+// the sort they are listed in, ID by default, and filters them:
+//
+//   GET /v1/rooms?tenant=acme&template=support&minParticipants=2
+//       &createdAfter=1791990000000&sort=-createdAt&limit=100
+//
+// Every other /v1/ path is its unversioned endpoint, which stays for existing
+// clients. /list and /rooms still return bare arrays; /rooms takes the same
+// parameters and sends X-Total-Count, and X-Next-After when there is more.
+
+const (
+    apiPageSize    = 50
+    apiMaxPageSize = 500
+)
+
+type apiPage struct {
+    Limit int
+    After string // Key of the last item of the previous page
+    Desc  bool
+}
+
+type apiItem struct {
+    key   string
+    value interface{}
+}
+
+type serverView struct {
+    ServerInfo
+    Healthy bool `json:"healthy"`
+}
+
+func parseAPIPage(query url.Values) (apiPage, error) {
+    page := apiPage{Limit: apiPageSize, After: query.Get("after")}
+    if value := query.Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n <= 0 {
+            return apiPage{}, fmt.Errorf("limit must be a positive integer")
+        }
+        if n > apiMaxPageSize {
+            n = apiMaxPageSize
+        }
+        page.Limit = n
+    }
+    return page, nil
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "error": map[string]interface{}{"status": status, "message": fmt.Sprintf(format, args...)},
+    })
+}
+
+// pageOf sorts items by key and cuts the page after page.After, returning
+// the key to continue after, empty on the last page.
+func pageOf(items []apiItem, page apiPage) ([]interface{}, string) {
+    sort.Slice(items, func(i, j int) bool { return (items[i].key < items[j].key) != page.Desc })
+    start := sort.Search(len(items), func(i int) bool {
+        if page.Desc {
+            return page.After == "" || items[i].key < page.After
+        }
+        return items[i].key > page.After
+    })
+    end := start + page.Limit
+    next := ""
+    if end < len(items) {
+        next = items[end-1].key
+    } else {
+        end = len(items)
+    }
+    data := make([]interface{}, 0, end-start)
+    for _, item := range items[start:end] {
+        data = append(data, item.value)
+    }
+    return data, next
+}
+
+func writeAPIPage(w http.ResponseWriter, items []apiItem, page apiPage) {
+    data, next := pageOf(items, page)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(apiList{Data: data, Total: len(items), HasMore: next != "", NextAfter: next})
+}
+
+func serverHealthy(server ServerInfo, now int64) bool {
+    return now-server.LastSeen <= cfg.RegistryHealthyWindow.Milliseconds()
+}
+
+// GET /v1/list[?region=&healthy=true&limit=&after=]
+func handleV1List(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeAPIError(w, http.StatusMethodNotAllowed, "only GET allowed")
+        return
+    }
+    query := r.URL.Query()
+    page, err := parseAPIPage(query)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "%v", err)
+        return
+    }
+    region := query.Get("region")
+    healthyOnly := false
+    if value := query.Get("healthy"); value != "" {
+        if healthyOnly, err = strconv.ParseBool(value); err != nil {
+            writeAPIError(w, http.StatusBadRequest, "healthy must be true or false")
+            return
+        }
+    }
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    
+    serversMu.Lock()
+    items := make([]apiItem, 0, len(servers))
+    for _, server := range servers {
+        healthy := serverHealthy(server, now)
+        if (region != "" && server.Region != region) || (healthyOnly && !healthy) {
+            continue
+        }
+        items = append(items, apiItem{
+            key:   fmt.Sprintf("%s:%d", server.Address, server.Port),
+            value: serverView{ServerInfo: server, Healthy: healthy},
+        })
+    }
+    serversMu.Unlock()
+    
+    writeAPIPage(w, items, page)
+}
+
+// GET /v1/rooms[?tenant=&template=&minParticipants=&createdAfter=&sort=&limit=&after=]
+func handleV1Rooms(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeAPIError(w, http.StatusMethodNotAllowed, "only GET allowed")
+        return
+    }
+    scope, status, text := observerAccess(r, "/rooms", !cfg.RoomAPIAuth)
+    if scope == nil {
+        writeAPIError(w, status, "%s", text)
+        return
+    }
+    items, page, err := listRooms(r.URL.Query(), scope)
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "%v", err)
+        return
+    }
+    writeAPIPage(w, items, page)
+}
+
+// listRooms filters the active rooms scope sees by query and keys them by
+// its sort: roomId, createdAt or participants, descending with a leading "-".
+// Numeric sorts key by the zero padded value and the room ID, which breaks
+// ties.
+func listRooms(query url.Values, scope *observerScope) ([]apiItem, apiPage, error) {
+    page, err := parseAPIPage(query)
+    if err != nil {
+        return nil, page, err
+    }
+    sortBy := query.Get("sort")
+    if strings.HasPrefix(sortBy, "-") {
+        sortBy, page.Desc = sortBy[1:], true
+    }
+    switch sortBy {
+    case "":
+        sortBy = "roomId"
+    case "roomId", "createdAt", "participants":
+    default:
+        return nil, page, fmt.Errorf("sort must be roomId, createdAt or participants, - for descending")
+    }
+    tenant, template := query.Get("tenant"), query.Get("template")
+    var minParticipants int
+    if value := query.Get("minParticipants"); value != "" {
+        if minParticipants, err = strconv.Atoi(value); err != nil || minParticipants < 0 {
+            return nil, page, fmt.Errorf("minParticipants must be a count")
+        }
+    }
+    var createdAfter int64
+    if value := query.Get("createdAfter"); value != "" {
+        if createdAfter, err = strconv.ParseInt(value, 10, 64); err != nil {
+            return nil, page, fmt.Errorf("createdAfter must be Unix milliseconds")
+        }
+    }
+    
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    items := make([]apiItem, 0, len(rooms))
+    for roomId, room := range rooms {
+        participants := len(room.Users) + len(room.Agents)
+        if (tenant != "" && room.Tenant != tenant) || (template != "" && room.Template != template) ||
+            participants < minParticipants || room.CreatedAt <= createdAfter || !scope.allows(room.Tenant) {
+            continue
+        }
+        key := roomId
+        switch sortBy {
+        case "createdAt":
+            key = fmt.Sprintf("%020d/%s", room.CreatedAt, roomId)
+        case "participants":
+            key = fmt.Sprintf("%020d/%s", participants, roomId)
+        }
+        items = append(items, apiItem{key: key, value: roomListEntry(room)})
+    }
+    return items, page, nil
+}
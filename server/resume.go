@@ -0,0 +1,331 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "net/http"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Session resume. A client whose connection drops, rather than closing
+// normally, is held for -resume-grace: it is out of the room's fan-out, but
+// nobody is told it left, the room stays open and selective messages for it
+// queue (see offline.go). Reconnecting with the resumeToken of its last
+// welcome,
+//
+//   /ws?room=R1&clientId=U1&resumeToken=TOKEN&lastSeq=41
+//
+// re-attaches it to its place in the room: its metadata, display name and
+// avatar come back, the events after lastSeq are replayed and the queue is
+// delivered, and the room gets session_resumed instead of client_left and
+// client_joined. A resume that beats the old connection's pong timeout
+// replaces that connection. The private channels and consult the client was
+// on end with the connection. A client that doesn't come back in time leaves
+// as usual with the reason it dropped; one that comes back without the token
+// is a duplicate of its held session (see duplicates.go), and its queue is
+// discarded rather than given to a connection that may not be it. A hot
+// upgrade hands every session, held or connected, over to the new process
+// (see upgrade.go), whose room doesn't know the client yet: there the token
+// joins it afresh, still within the grace and with its queue.
+
+// heldSession is a dropped client waiting to resume. Guarded by roomsMu, or
+// handedSessionsMu for the handed ones.
+type heldSession struct {
+    client *Client
+    reason string // Why it dropped, client_left's reason if it never resumes
+    since  time.Time
+    timer  *time.Timer
+    handed bool // From the process before an upgrade, the room has yet to add the client
+}
+
+// handedSessions are the sessions an upgrading process handed over, by room
+// and clientId, each kept until it resumes or its grace runs out.
+var (
+    handedSessions   = make(map[string]map[string]*heldSession)
+    handedSessionsMu sync.Mutex
+)
+
+var sessionResumes = newCounterVec("iva_session_resumes_total", "Dropped connections held for resume, by outcome: held, resumed or expired.", "outcome")
+
+func init() {
+    metricSeries = append(metricSeries, sessionResumes)
+}
+
+// holdSession takes a dropped client out of the room's fan-out without it
+// leaving, reporting false when it should leave now.
+func holdSession(roomId string, client *Client, err error, reason string) bool {
+    if cfg.ResumeGrace <= 0 || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+        return false
+    }
+    client.mu.Lock()
+    resumable := client.resumeToken != ""
+    client.mu.Unlock()
+    if !resumable {
+        return false
+    }
+    
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    room := rooms[roomId]
+    if room == nil || memberOf(room, client.clientId) != client {
+        return false
+    }
+    detachClient(room, client)
+    markDeparted(room, client.clientId)
+    if room.held == nil {
+        room.held = make(map[string]*heldSession)
+    }
+    room.held[client.clientId] = &heldSession{
+        client: client,
+        reason: reason,
+        since:  time.Now(),
+        timer:  time.AfterFunc(cfg.ResumeGrace, func() { expireSession(roomId, client) }),
+    }
+    sessionResumes.inc(client.labels, "held")
+    return true
+}
+
+// expireSession lets a held client leave once its grace is over.
+func expireSession(roomId string, client *Client) {
+    roomsMu.Lock()
+    room := rooms[roomId]
+    var held *heldSession
+    if room != nil && room.held[client.clientId] != nil && room.held[client.clientId].client == client {
+        held = room.held[client.clientId]
+        delete(room.held, client.clientId)
+    }
+    roomsMu.Unlock()
+    if held == nil {
+        return
+    }
+    sessionResumes.inc(client.labels, "expired")
+    removeClientFromRoom(roomId, client)
+    notifyClientLeft(roomId, client, held.reason)
+    logAt("info", roomId, client.clientId, "Client left room (%s), it did not resume", held.reason)
+}
+
+// resumeSession finds the session a reconnecting client's token belongs to:
+// one held after a drop, or a connection not yet noticed dead, which is
+// closed.
+func resumeSession(roomId string, clientId string, clientType ClientType, token string) *heldSession {
+    if token == "" {
+        return nil
+    }
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return takeHandedSession(roomId, clientId, clientType, token)
+    }
+    previous, held := resumableSession(room, clientId, clientType, token)
+    switch {
+    case previous == nil:
+    case held:
+        previous.timer.Stop()
+        delete(room.held, previous.client.clientId)
+    default:
+        // The old connection's read loop finds itself superseded and leaves quietly
+        detachClient(room, previous.client)
+        previous.client.mu.Lock()
+        previous.client.superseded = true
+        previous.client.mu.Unlock()
+    }
+    roomsMu.Unlock()
+    
+    if previous == nil {
+        return takeHandedSession(roomId, clientId, clientType, token)
+    }
+    if !held {
+        previous.client.conn.Close()
+    }
+    sessionResumes.inc(previous.client.labels, "resumed")
+    return previous
+}
+
+// resumableSession looks for the token's session under clientId, or under
+// an ID the room suffixed it to, reporting whether it was held. Callers hold
+// roomsMu.
+func resumableSession(room *RoomInfo, clientId string, clientType ClientType, token string) (*heldSession, bool) {
+    for _, held := range room.held {
+        if sessionOf(held.client, clientId, clientType, token) {
+            return held, true
+        }
+    }
+    for _, members := range []map[string]*Client{room.Users, room.Agents} {
+        for _, live := range members {
+            if sessionOf(live, clientId, clientType, token) {
+                return &heldSession{client: live, since: time.Now()}, false
+            }
+        }
+    }
+    return nil, false
+}
+
+// sessionOf reports whether client is the session a reconnect with clientId
+// and token resumes.
+func sessionOf(client *Client, clientId string, clientType ClientType, token string) bool {
+    return (client.clientId == clientId || client.requestedId == clientId) &&
+        client.clientType == clientType && tokenMatches(client, token)
+}
+
+// holdHandedSession keeps a session the process before an upgrade handed
+// over until the grace it had left there runs out.
+func holdHandedSession(roomId string, client *Client, since time.Time) {
+    grace := cfg.ResumeGrace - time.Since(since)
+    if grace <= 0 {
+        return
+    }
+    handedSessionsMu.Lock()
+    defer handedSessionsMu.Unlock()
+    if handedSessions[roomId] == nil {
+        handedSessions[roomId] = make(map[string]*heldSession)
+    }
+    session := &heldSession{client: client, since: since, handed: true}
+    session.timer = time.AfterFunc(grace, func() {
+        handedSessionsMu.Lock()
+        defer handedSessionsMu.Unlock()
+        if handedSessions[roomId][client.clientId] == session {
+            delete(handedSessions[roomId], client.clientId)
+            if len(handedSessions[roomId]) == 0 {
+                delete(handedSessions, roomId)
+            }
+        }
+    })
+    handedSessions[roomId][client.clientId] = session
+}
+
+// takeHandedSession is the handed over session the token resumes, if any,
+// which it can do once.
+func takeHandedSession(roomId string, clientId string, clientType ClientType, token string) *heldSession {
+    handedSessionsMu.Lock()
+    defer handedSessionsMu.Unlock()
+    for id, session := range handedSessions[roomId] {
+        if sessionOf(session.client, clientId, clientType, token) {
+            session.timer.Stop()
+            delete(handedSessions[roomId], id)
+            if len(handedSessions[roomId]) == 0 {
+                delete(handedSessions, roomId)
+            }
+            sessionResumes.inc(nil, "resumed")
+            return session
+        }
+    }
+    return nil
+}
+
+// tokenMatches checks token against the client's, or against its hash for a
+// session that failed over from a checkpoint.
+func tokenMatches(client *Client, token string) bool {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    if client.resumeToken != "" {
+        return subtle.ConstantTimeCompare([]byte(client.resumeToken), []byte(token)) == 1
+    }
+    return client.resumeTokenHash != "" && subtle.ConstantTimeCompare([]byte(client.resumeTokenHash), []byte(hashResumeToken(token))) == 1
+}
+
+func hashResumeToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// roomParticipant is the connected member an HTTP request to the room's
+// endpoints speaks for: the clientId query param, proven by the resume token
+// of its welcome in X-Resume-Token. Nil when either doesn't match.
+func roomParticipant(r *http.Request, roomId string) *Client {
+    token := r.Header.Get("X-Resume-Token")
+    client := findClient(roomId, r.URL.Query().Get("clientId"))
+    if token == "" || client == nil || !tokenMatches(client, token) {
+        return nil
+    }
+    return client
+}
+
+// restoreSession carries what the room knew of the client over to its new
+// connection, its ID included. Metadata sent on reconnecting wins over the old.
+func restoreSession(client *Client, previous *Client) {
+    client.clientId, client.requestedId = previous.clientId, previous.requestedId
+    previous.mu.Lock()
+    metadata := make(map[string]interface{}, len(previous.metadata))
+    for key, value := range previous.metadata {
+        metadata[key] = value
+    }
+    displayName, avatar := previous.displayName, previous.avatar
+    previous.mu.Unlock()
+    
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    for key, value := range metadata {
+        if _, set := client.metadata[key]; !set {
+            client.metadata[key] = value
+        }
+    }
+    client.metadata["role"] = client.role
+    if client.displayName == "" {
+        client.displayName = displayName
+    }
+    if client.avatar == "" {
+        client.avatar = avatar
+    }
+}
+
+// resumeClientInRoom puts a resumed client back in its room. Its join was
+// never undone, so none of it is recorded again. False when the room closed.
+func resumeClientInRoom(roomId string, client *Client) bool {
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    
+    room := rooms[roomId]
+    if room == nil {
+        return false
+    }
+    if client.clientType == ClientTypeAgent {
+        room.Agents[client.clientId] = client
+    } else {
+        room.Users[client.clientId] = client
+    }
+    delete(room.Departed, client.clientId)
+    client.labels = room.labels
+    connectionsTotal.inc(client.labels, string(client.clientType))
+    return true
+}
+
+func notifySessionResumed(roomId string, client *Client, since time.Time) {
+    data := participantInfo(client)
+    data["awayMs"] = time.Since(since).Milliseconds()
+    broadcastToRoom(roomId, client, &Message{
+        Type:      "session_resumed",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// memberOf is the connected client with the ID. Callers hold roomsMu.
+func memberOf(room *RoomInfo, clientId string) *Client {
+    if client, ok := room.Users[clientId]; ok {
+        return client
+    }
+    return room.Agents[clientId]
+}
+
+// detachClient takes a client out of the room's maps if it is still the one
+// there. Callers hold roomsMu.
+func detachClient(room *RoomInfo, client *Client) {
+    if room.Agents[client.clientId] == client {
+        delete(room.Agents, client.clientId)
+    }
+    if room.Users[client.clientId] == client {
+        delete(room.Users, client.clientId)
+    }
+}
+
+func (c *Client) isSuperseded() bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.superseded
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "bytes"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Run modes. The one binary is every process of a deployment, which -role
+// picks:
+//
+//   all       rooms and the registry in one process, the default and the
+//             dev server
+//   media     rooms: /ws and the REST API of live calls. It registers
+//             -public-address with the registry at -registry-url and
+//             heartbeats while it runs.
+//   registry  the server registry: /register, /heartbeat, /allocate and
+//             /list. It keeps no rooms, so it loads no STT, TTS or storage.
+//   worker    reporting and queues over the storage media servers share:
+//             analytics, search, the audit log, callbacks, phrase hints
+//             and voiceprint consent, knowledge gap curation, and the
+//             history janitor.
+//
+// Every role serves /metrics, /openapi.json (describing only its own routes)
+// and the log stream.
+
+const (
+    RoleAll      = "all"
+    RoleMedia    = "media"
+    RoleRegistry = "registry"
+    RoleWorker   = "worker"
+)
+
+var (
+    registryRoutes = map[string]bool{"/register": true, "/heartbeat": true, "/allocate": true, "/list": true, "/v1/list": true}
+    workerRoutes   = map[string]bool{
+        "/analytics": true, "/analytics/agents": true, "/search": true, "/admin/audit": true,
+        "/admin/callbacks": true, "/admin/callbacks/": true, "/admin/stt/phrases/": true, "/admin/speakers/": true,
+        "/admin/knowledge/": true,
+    }
+    sharedRoutes = map[string]bool{"/metrics": true, "/openapi.json": true, "/admin/logs/stream": true}
+)
+
+// servesRoute reports whether this process's role serves an apiRoutes
+// pattern. Media servers serve everything but the registry.
+func servesRoute(pattern string) bool {
+    switch cfg.Role {
+    case RoleMedia:
+        return !registryRoutes[pattern]
+    case RoleRegistry:
+        return registryRoutes[pattern] || sharedRoutes[pattern]
+    case RoleWorker:
+        return workerRoutes[pattern] || sharedRoutes[pattern]
+    }
+    return true
+}
+
+// checkRole refuses configurations the role cannot honour.
+func checkRole() error {
+    switch cfg.Role {
+    case RoleAll:
+    case RoleMedia:
+        if cfg.RegistryURL == "" || cfg.PublicAddress == "" {
+            return fmt.Errorf("-role media needs -registry-url and the -public-address to register")
+        }
+        if _, _, err := splitPublicAddress(); err != nil {
+            return err
+        }
+        if cfg.RegistryAddr != "" {
+            return fmt.Errorf("-registry-addr is the registry's listener, run it with -role registry")
+        }
+    case RoleRegistry:
+        if cfg.DemoAgent != "" || cfg.DemoPage {
+            return fmt.Errorf("-role registry keeps no rooms, drop -demo-agent and -demo-page")
+        }
+        if cfg.RegistryURL != "" {
+            return fmt.Errorf("-registry-url is for media servers, a registry does not register")
+        }
+    case RoleWorker:
+        if cfg.StorageDSN == "" {
+            return fmt.Errorf("-role worker works on the media servers' storage, set -storage-dsn")
+        }
+        if cfg.DemoAgent != "" || cfg.DemoPage {
+            return fmt.Errorf("-role worker keeps no rooms, drop -demo-agent and -demo-page")
+        }
+    default:
+        return fmt.Errorf("unknown -role %q, use all, media, registry or worker", cfg.Role)
+    }
+    if (cfg.RegistryClientCert == "") != (cfg.RegistryClientKey == "") {
+        return fmt.Errorf("-registry-client-cert and -registry-client-key go together")
+    }
+    return nil
+}
+
+func splitPublicAddress() (string, int, error) {
+    host, port, err := net.SplitHostPort(cfg.PublicAddress)
+    if err != nil {
+        return "", 0, fmt.Errorf("-public-address %q: %v", cfg.PublicAddress, err)
+    }
+    n, err := strconv.Atoi(port)
+    if err != nil {
+        return "", 0, fmt.Errorf("-public-address %q: port must be a number", cfg.PublicAddress)
+    }
+    return host, n, nil
+}
+
+// startWorker starts the storage backed jobs of -role worker.
+func startWorker() {
+    if err := startPersistence(); err != nil {
+        log.Fatalf("Storage: %v", err)
+    }
+    if err := startSearchIndexer(); err != nil {
+        log.Fatalf("Search indexer: %v", err)
+    }
+    loadSpeakers()
+    startHistoryJanitor()
+}
+
+// startRegistration registers this media server with -registry-url, then
+// heartbeats a third of -registry-healthy-window apart, and at once when it
+// starts or stops draining. It registers again whenever the registry has
+// forgotten it, e.g. after a registry restart.
+func startRegistration() {
+    if cfg.RegistryURL == "" {
+        return
+    }
+    host, port, _ := splitPublicAddress()
+    
+    transport := &http.Transport{}
+    if cfg.RegistryClientCert != "" {
+        cert, err := tls.LoadX509KeyPair(cfg.RegistryClientCert, cfg.RegistryClientKey)
+        if err != nil {
+            log.Fatalf("Registry client certificate: %v", err)
+        }
+        transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+    }
+    client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+    base := strings.TrimRight(cfg.RegistryURL, "/")
+    post := func(path string) (int, error) {
+        draining := isDraining()
+        beat := heartbeat{ServerInfo: ServerInfo{Address: host, Port: port, Region: cfg.Region, Draining: draining, TLS: tlsEnabled()}}
+        if cfg.FailoverCheckpoints && path == "/heartbeat" {
+            beat.Rooms = roomCheckpoints()
+        }
+        body, _ := json.Marshal(beat)
+        resp, err := client.Post(base+path, "application/json", bytes.NewReader(body))
+        if err != nil {
+            return 0, err
+        }
+        defer resp.Body.Close()
+        // The registry echoes what it now knows, so a drain is confirmed once it stops allocating us
+        var known ServerInfo
+        if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&known) == nil {
+            noteRegistryDraining(known.Draining && draining)
+        }
+        return resp.StatusCode, nil
+    }
+    
+    interval := cfg.RegistryHealthyWindow / 3
+    if interval <= 0 {
+        interval = 10 * time.Second
+    }
+    go func() {
+        registered := false
+        for ; ; waitForHeartbeat(interval) {
+            if !registered {
+                status, err := post("/register")
+                // 409: still registered from before a restart, so heartbeat it
+                if err == nil && (status == http.StatusCreated || status == http.StatusConflict) {
+                    registered = true
+                    log.Printf("Registered %s with %s", cfg.PublicAddress, base)
+                } else {
+                    log.Printf("level=warn Registering with %s failed: %v", base, describeStatus(status, err))
+                    continue
+                }
+            }
+            status, err := post("/heartbeat")
+            switch {
+            case err == nil && status == http.StatusOK:
+            case err == nil && status == http.StatusNotFound:
+                registered = false
+                log.Printf("level=warn Registry %s forgot %s, registering again", base, cfg.PublicAddress)
+            default:
+                log.Printf("level=warn Heartbeat to %s failed: %v", base, describeStatus(status, err))
+            }
+        }
+    }()
+}
+
+// waitForHeartbeat sleeps until the next heartbeat is due, or a drain
+// starting or stopping needs the registry told now.
+func waitForHeartbeat(interval time.Duration) {
+    select {
+    case <-time.After(interval):
+    case <-registryNudge:
+    }
+}
+
+func describeStatus(status int, err error) interface{} {
+    if err != nil {
+        return err
+    }
+    return http.StatusText(status)
+}
+
+// logRoleEndpoints lists the routes a split role serves.
+func logRoleEndpoints() {
+    log.Println("Endpoints:")
+    for _, route := range apiRoutes() {
+        if !servesRoute(route.Pattern) {
+            continue
+        }
+        for _, op := range route.Operations {
+            log.Printf("  %-4s %s - %s", op.Method, op.Path, op.Summary)
+        }
+    }
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Load-aware routing of waiting rooms to agent workers. A worker is an agent
+// process or person that can be in several rooms at once: an STT worker
+// handling 50, a human handling 1. Workers are registered with a capacity
+// by an admin (/admin/agents, -agent-workers), or declare it themselves by
+// connecting with agentId and capacity query params on /ws. Load is the
+// rooms a worker's agents are in plus assignments on their way.
+//
+// A room whose users wait for an agent goes to the eligible worker with the
+// most headroom, relative to its capacity. Workers with a join URL get
+// {"room_id", "tenant", "flow"} POSTed there, the bot's /join; the others
+// learn of it through an agent_assigned event. Rooms nobody has capacity for
+// wait, oldest first, until an agent leaves or a worker registers.
+
+type agentWorker struct {
+    Id        string   `json:"id"`
+    JoinURL   string   `json:"joinUrl,omitempty"`
+    Capacity  int      `json:"capacity"`            // Rooms at once
+    Tenants   []string `json:"tenants,omitempty"`   // Empty for any
+    Templates []string `json:"templates,omitempty"` // Empty for any
+    Declared  bool     `json:"declared,omitempty"`  // By its connections rather than an admin, forgotten when they close
+    Pooled    bool     `json:"pooled,omitempty"`    // Spawned by the agent pool, see agentpool.go
+}
+
+// assignment is a room given to a worker whose agent has not joined yet.
+type assignment struct {
+    worker  string
+    expires time.Time
+}
+
+var (
+    workers     = make(map[string]*agentWorker)
+    assignments = make(map[string]assignment) // By room ID
+    routerMu    sync.Mutex
+    routerOnce  sync.Once
+    
+    assignmentsTotal = newCounterVec("iva_agent_assignments_total", "Rooms routed to agent workers, by result.", "result")
+)
+
+func init() {
+    metricSeries = append(metricSeries, assignmentsTotal)
+}
+
+// loadWorkers registers -agent-workers, ID=JOIN_URL@CAPACITY entries.
+func loadWorkers() error {
+    for _, entry := range cfg.AgentWorkers {
+        id, rest, ok := strings.Cut(entry, "=")
+        joinURL, capacity, _ := strings.Cut(rest, "@")
+        n, err := strconv.Atoi(capacity)
+        if !ok || id == "" || joinURL == "" || err != nil || n < 1 {
+            return fmt.Errorf("agent worker %q is not ID=JOIN_URL@CAPACITY", entry)
+        }
+        workers[id] = &agentWorker{Id: id, JoinURL: joinURL, Capacity: n}
+    }
+    if len(workers) > 0 {
+        startRouter()
+    }
+    return nil
+}
+
+// startRouter starts the sweep that reroutes rooms still waiting, once the
+// first worker is known.
+func startRouter() {
+    routerOnce.Do(func() {
+        go func() {
+            ticker := time.NewTicker(cfg.RouteInterval)
+            defer ticker.Stop()
+            for range ticker.C {
+                routeWaiting()
+            }
+        }()
+    })
+}
+
+// declareCapacity registers or updates the worker an agent connection says
+// it belongs to.
+func declareCapacity(client *Client, query url.Values) error {
+    if client.clientType != ClientTypeAgent || query.Get("capacity") == "" {
+        return nil
+    }
+    capacity, err := strconv.Atoi(query.Get("capacity"))
+    if err != nil || capacity < 1 {
+        return fmt.Errorf("capacity must be a positive number of rooms")
+    }
+    routerMu.Lock()
+    defer routerMu.Unlock()
+    worker := workers[client.agentId]
+    if worker == nil {
+        worker = &agentWorker{Id: client.agentId, Declared: true}
+        workers[client.agentId] = worker
+    }
+    worker.Capacity = capacity
+    startRouter()
+    return nil
+}
+
+// workerLoads counts the rooms each worker is in, is assigned to or still
+// wraps up. Callers hold routerMu.
+func workerLoads() map[string]int {
+    loads := make(map[string]int)
+    in := make(map[string]bool) // worker/room pairs already counted
+    roomsMu.RLock()
+    for roomId, room := range rooms {
+        for _, agent := range room.Agents {
+            if key := agent.agentId + "/" + roomId; !in[key] {
+                in[key] = true
+                loads[agent.agentId]++
+            }
+        }
+    }
+    roomsMu.RUnlock()
+    for roomId, pending := range assignments {
+        if key := pending.worker + "/" + roomId; !in[key] {
+            in[key] = true
+            loads[pending.worker]++
+        }
+    }
+    // Calls still being wrapped up (wrapup.go)
+    for key := range wrapUpLoads() {
+        if !in[key] {
+            loads[strings.SplitN(key, "/", 2)[0]]++
+        }
+    }
+    return loads
+}
+
+func (w *agentWorker) serves(tenant string, template string) bool {
+    return (len(w.Tenants) == 0 || containsString(w.Tenants, tenant)) &&
+        (len(w.Templates) == 0 || containsString(w.Templates, template))
+}
+
+func containsString(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// pickWorker chooses the eligible worker with the lowest load relative to
+// its capacity. Callers hold routerMu.
+func pickWorker(tenant string, template string) *agentWorker {
+    loads := workerLoads()
+    var best *agentWorker
+    var bestShare float64
+    for _, worker := range workers {
+        load := loads[worker.Id]
+        if load >= worker.Capacity || !worker.serves(tenant, template) {
+            continue
+        }
+        share := float64(load) / float64(worker.Capacity)
+        if best == nil || share < bestShare || (share == bestShare && worker.Id < best.Id) {
+            best, bestShare = worker, share
+        }
+    }
+    return best
+}
+
+func routingEnabled() bool {
+    routerMu.Lock()
+    defer routerMu.Unlock()
+    return len(workers) > 0
+}
+
+// routeWaiting hands waiting rooms to workers with capacity, longest
+// waiting first.
+func routeWaiting() {
+    type waiting struct {
+        roomId, tenant, template string
+        since                    int64
+    }
+    now := time.Now()
+    routerMu.Lock()
+    if len(workers) == 0 {
+        routerMu.Unlock()
+        return
+    }
+    for roomId, pending := range assignments {
+        if now.After(pending.expires) {
+            logAt("warn", roomId, "", "Worker %s did not join in %s, rerouting", pending.worker, cfg.AssignTimeout)
+            delete(assignments, roomId)
+            assignmentsTotal.inc(nil, "timeout")
+        }
+    }
+    routerMu.Unlock()
+    
+    var queue []waiting
+    roomsMu.RLock()
+    for roomId, room := range rooms {
+        if room.waitingSince != 0 && len(room.Agents) == 0 && len(room.Users) > 0 {
+            queue = append(queue, waiting{roomId, room.Tenant, room.Template, room.waitingSince})
+        }
+    }
+    roomsMu.RUnlock()
+    sort.Slice(queue, func(i, j int) bool { return queue[i].since < queue[j].since })
+    
+    for _, room := range queue {
+        assignRoom(room.roomId, room.tenant, room.template, nil)
+    }
+}
+
+// assignRoom routes one room, reporting whether a worker took it. Extra
+// fields go into the join request, e.g. an outbound call's greeting.
+func assignRoom(roomId string, tenant string, template string, extra map[string]interface{}) bool {
+    routerMu.Lock()
+    if _, pending := assignments[roomId]; pending {
+        routerMu.Unlock()
+        return true
+    }
+    // An agent may have joined since the room was found waiting
+    roomsMu.RLock()
+    room := rooms[roomId]
+    taken := room == nil || len(room.Agents) > 0
+    roomsMu.RUnlock()
+    if taken {
+        routerMu.Unlock()
+        return room != nil
+    }
+    worker := pickWorker(tenant, template)
+    if worker == nil {
+        routerMu.Unlock()
+        return false
+    }
+    assignments[roomId] = assignment{worker: worker.Id, expires: time.Now().Add(cfg.AssignTimeout)}
+    chosen := *worker
+    routerMu.Unlock()
+    
+    request := map[string]interface{}{"room_id": roomId, "tenant": tenant, "flow": template}
+    for key, value := range extra {
+        request[key] = value
+    }
+    if chosen.JoinURL != "" {
+        if err := postJoin(chosen.JoinURL, request); err != nil {
+            logAt("warn", roomId, "", "Assigning worker %s failed: %v", chosen.Id, err)
+            routerMu.Lock()
+            delete(assignments, roomId)
+            routerMu.Unlock()
+            assignmentsTotal.inc(metricLabelsFor(tenant, template), "failed")
+            return false
+        }
+    }
+    logAt("info", roomId, "", "Assigned to worker %s", chosen.Id)
+    assignmentsTotal.inc(metricLabelsFor(tenant, template), "assigned")
+    request["worker"] = chosen.Id
+    emitEvent("agent_assigned", &RoomInfo{RoomId: roomId, Tenant: tenant}, request)
+    return true
+}
+
+// postJoin asks an agent worker to join a room.
+func postJoin(joinURL string, request map[string]interface{}) error {
+    body, _ := json.Marshal(request)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, joinURL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s", resp.Status)
+    }
+    return nil
+}
+
+// noteAgentJoin settles a room's assignment once any agent arrives.
+func noteAgentJoin(roomId string) {
+    routerMu.Lock()
+    delete(assignments, roomId)
+    routerMu.Unlock()
+}
+
+// noteAgentLeave frees the capacity an agent held, and forgets a declared
+// worker with no connections left.
+func noteAgentLeave(client *Client) {
+    routerMu.Lock()
+    if worker := workers[client.agentId]; worker != nil && worker.Declared && workerLoads()[client.agentId] == 0 {
+        delete(workers, client.agentId)
+    }
+    routerMu.Unlock()
+    routeWaiting()
+}
+
+// /admin/agents (admin):
+//
+//   GET    /admin/agents       workers with their load
+//   POST   /admin/agents       register or update an agentWorker
+//   DELETE /admin/agents/ID
+func handleAgents(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/agents"), "/")
+    
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        type workerLoad struct {
+            agentWorker
+            Load int `json:"load"`
+        }
+        routerMu.Lock()
+        loads := workerLoads()
+        list := make([]workerLoad, 0, len(workers))
+        for _, worker := range workers {
+            list = append(list, workerLoad{*worker, loads[worker.Id]})
+        }
+        routerMu.Unlock()
+        sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"agents": list})
+    case id == "" && r.Method == http.MethodPost:
+        var worker agentWorker
+        if err := json.NewDecoder(r.Body).Decode(&worker); err != nil {
+            http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        if worker.Id == "" || worker.Capacity < 1 {
+            http.Error(w, "id and a capacity of at least 1 required", http.StatusBadRequest)
+            return
+        }
+        worker.Declared = false
+        routerMu.Lock()
+        if existing := workers[worker.Id]; existing != nil {
+            worker.Pooled = existing.Pooled
+        }
+        workers[worker.Id] = &worker
+        routerMu.Unlock()
+        startRouter()
+        go routeWaiting()
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(worker)
+    case id != "" && r.Method == http.MethodDelete:
+        routerMu.Lock()
+        _, ok := workers[id]
+        delete(workers, id)
+        routerMu.Unlock()
+        if !ok {
+            http.Error(w, "Agent worker not found", http.StatusNotFound)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Secure capture of card numbers and other keyed-in secrets. The IVA, or a
+// human agent, starts one when it is time to collect them:
+//
+//   {"type": "secure_capture_start", "data": {"purpose": "payment", "maxDigits": 16, "terminator": "#"}}
+//
+// Until it ends the caller's audio reaches nobody, not agents, observers,
+// recordings nor transcription, and the recording is paused (recording.go).
+// The caller's keypad, dtmf messages {"digits": "4"} from the voice gateway,
+// goes only to the capture. It ends on maxDigits, the terminator,
+// secure_capture_stop, the caller leaving or -secure-capture-timeout, and the
+// room goes back to normal on its own.
+//
+// Completed digits are POSTed to -secure-capture-webhook, the PCI provider,
+// as {"captureId", "roomId", "tenant", "purpose", "digits"}, and its
+// {"status", "token"} is what the agents get in secure_capture_ended. With no
+// webhook, payment captures are only checked (Luhn) and the last four digits
+// shown. Digits are never kept, traced, audited or sent to an agent.
+
+type secureCapture struct {
+    Id         string
+    RoomId     string
+    Tenant     string
+    Purpose    string
+    StartedBy  string
+    MaxDigits  int
+    Terminator string
+    digits     strings.Builder
+    paused     bool // The capture paused the recording, and resumes it
+    timer      *time.Timer
+}
+
+var (
+    captures  = make(map[string]*secureCapture) // By room ID
+    captureMu sync.Mutex                         // Never held while taking roomsMu
+    
+    capturesTotal = newCounterVec("iva_secure_captures_total", "Secure captures by how they ended.", "result")
+)
+
+func init() {
+    metricSeries = append(metricSeries, capturesTotal)
+}
+
+// capturing reports whether the room's caller audio and keypad belong to a
+// secure capture.
+func capturing(roomId string) bool {
+    captureMu.Lock()
+    defer captureMu.Unlock()
+    return captures[roomId] != nil
+}
+
+// secure_capture_start: {"purpose": TEXT, "maxDigits": N, "terminator": "#"}
+func handleCaptureStart(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    purpose, _ := data["purpose"].(string)
+    if purpose == "" {
+        purpose = "payment"
+    }
+    terminator, ok := data["terminator"].(string)
+    if !ok {
+        terminator = "#"
+    }
+    maxDigits := 0
+    if n, ok := data["maxDigits"].(float64); ok && n > 0 {
+        maxDigits = int(n)
+    }
+    if maxDigits == 0 && terminator == "" {
+        sendError(sender, ErrInvalidMessage, msg, "a secure capture needs maxDigits or a terminator")
+        return
+    }
+    roomsMu.RLock()
+    room := rooms[roomId]
+    tenant := ""
+    if room != nil {
+        tenant = room.Tenant
+    }
+    roomsMu.RUnlock()
+    if room == nil {
+        return
+    }
+    
+    capture := &secureCapture{
+        Id:         newRandomId(),
+        RoomId:     roomId,
+        Tenant:     tenant,
+        Purpose:    purpose,
+        StartedBy:  sender.clientId,
+        MaxDigits:  maxDigits,
+        Terminator: terminator,
+    }
+    // Before anything else is said, so no recording holds the digits read back
+    capture.paused = pauseRecording(roomId, SystemSender, "secure capture "+purpose)
+    captureMu.Lock()
+    if captures[roomId] != nil {
+        captureMu.Unlock()
+        if capture.paused {
+            resumeRecording(roomId, SystemSender, "")
+        }
+        sendError(sender, ErrInvalidMessage, msg, "a secure capture is already running")
+        return
+    }
+    captures[roomId] = capture
+    capture.timer = time.AfterFunc(cfg.SecureCaptureTimeout, func() { endCapture(capture, "timeout") })
+    captureMu.Unlock()
+    
+    audit(room, sender.clientId, "secure_capture_start", capture.Id, "started", map[string]interface{}{"purpose": purpose})
+    emitEvent("secure_capture_started", room, map[string]interface{}{"captureId": capture.Id, "purpose": purpose, "startedBy": sender.clientId})
+    logAt("info", roomId, sender.clientId, "Secure capture %s started for %s", capture.Id, purpose)
+    broadcastToRoom(roomId, nil, &Message{
+        Id:   newMessageId(),
+        Type: "secure_capture_started",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "captureId":  capture.Id,
+            "purpose":    purpose,
+            "maxDigits":  maxDigits,
+            "terminator": terminator,
+            "timeout":    cfg.SecureCaptureTimeout.Milliseconds(),
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// secure_capture_stop: {} cancels the room's capture.
+func handleCaptureStop(roomId string, sender *Client, msg *Message) {
+    captureMu.Lock()
+    capture := captures[roomId]
+    captureMu.Unlock()
+    if capture == nil {
+        sendError(sender, ErrInvalidMessage, msg, "no secure capture is running")
+        return
+    }
+    endCapture(capture, "cancelled")
+}
+
+// dtmf: {"digits": KEYS}, from callers. Keys go to the secure capture while
+// one runs, and to the agents otherwise.
+func handleDTMF(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeUser {
+        sendError(sender, ErrNotPermitted, msg, "only callers send dtmf")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    keys, _ := data["digits"].(string)
+    if keys == "" || strings.Trim(keys, "0123456789*#ABCD") != "" {
+        sendError(sender, ErrInvalidMessage, msg, "dtmf digits must be keypad keys")
+        return
+    }
+    
+    captureMu.Lock()
+    capture := captures[roomId]
+    if capture == nil {
+        captureMu.Unlock()
+        sendToAgents(roomId, sender, msg)
+        return
+    }
+    done := false
+    for _, key := range keys {
+        if capture.Terminator != "" && string(key) == capture.Terminator {
+            done = true
+            break
+        }
+        capture.digits.WriteRune(key)
+        if capture.MaxDigits > 0 && capture.digits.Len() >= capture.MaxDigits {
+            done = true
+            break
+        }
+    }
+    captureMu.Unlock()
+    if done {
+        endCapture(capture, "completed")
+    }
+}
+
+// endCapture hands completed digits to the provider and gives the room its
+// audio back.
+func endCapture(capture *secureCapture, reason string) {
+    captureMu.Lock()
+    if captures[capture.RoomId] != capture {
+        captureMu.Unlock()
+        return
+    }
+    delete(captures, capture.RoomId)
+    capture.timer.Stop()
+    digits := capture.digits.String()
+    capture.digits.Reset()
+    captureMu.Unlock()
+    
+    result := map[string]interface{}{
+        "captureId": capture.Id,
+        "purpose":   capture.Purpose,
+        "reason":    reason,
+        "length":    len(digits),
+    }
+    if reason == "completed" {
+        if cfg.SecureCaptureWebhook != "" {
+            status, token, err := submitCapture(capture, digits)
+            if err != nil {
+                logAt("warn", capture.RoomId, "", "Secure capture %s submission failed: %v", capture.Id, err)
+                status = "failed"
+            }
+            result["status"] = status
+            if token != "" {
+                result["token"] = token
+            }
+        } else if capture.Purpose == "payment" {
+            result["valid"] = luhnValid(digits)
+            if len(digits) >= 4 {
+                result["last4"] = digits[len(digits)-4:]
+            }
+        }
+    }
+    capturesTotal.inc(nil, reason)
+    
+    if capture.paused {
+        resumeRecording(capture.RoomId, SystemSender, "secure capture ended")
+    }
+    room := &RoomInfo{RoomId: capture.RoomId, Tenant: capture.Tenant}
+    roomsMu.RLock()
+    if open := rooms[capture.RoomId]; open != nil {
+        room = open
+    }
+    roomsMu.RUnlock()
+    outcome := reason
+    if status, ok := result["status"].(string); ok {
+        outcome = status
+    }
+    audit(room, capture.StartedBy, "secure_capture_end", capture.Id, outcome, map[string]interface{}{"purpose": capture.Purpose, "reason": reason, "length": result["length"]})
+    emitEvent("secure_capture_ended", room, result)
+    logAt("info", capture.RoomId, "", "Secure capture %s ended: %s", capture.Id, reason)
+    broadcastToRoom(capture.RoomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "secure_capture_ended",
+        From:      SystemSender,
+        Data:      result,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// submitCapture posts the digits to -secure-capture-webhook.
+func submitCapture(capture *secureCapture, digits string) (string, string, error) {
+    body, _ := json.Marshal(map[string]interface{}{
+        "captureId": capture.Id,
+        "roomId":    capture.RoomId,
+        "tenant":    capture.Tenant,
+        "purpose":   capture.Purpose,
+        "digits":    digits,
+    })
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.SecureCaptureWebhook, bytes.NewReader(body))
+    if err != nil {
+        return "", "", err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return "", "", fmt.Errorf("%s", resp.Status)
+    }
+    var reply struct {
+        Status string `json:"status"`
+        Token  string `json:"token"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+        return "", "", err
+    }
+    if reply.Status == "" {
+        reply.Status = "accepted"
+    }
+    return reply.Status, reply.Token, nil
+}
+
+func luhnValid(digits string) bool {
+    if len(digits) < 12 {
+        return false
+    }
+    sum := 0
+    for i := 0; i < len(digits); i++ {
+        d := int(digits[len(digits)-1-i] - '0')
+        if d < 0 || d > 9 {
+            return false
+        }
+        if i%2 == 1 {
+            d *= 2
+            if d > 9 {
+                d -= 9
+            }
+        }
+        sum += d
+    }
+    return sum%10 == 0
+}
+
+// noteCaptureLeave ends a capture whose caller has gone. Callers hold roomsMu.
+func noteCaptureLeave(room *RoomInfo, client *Client) {
+    if client.clientType != ClientTypeUser || len(room.Users) > 0 {
+        return
+    }
+    captureMu.Lock()
+    capture := captures[room.RoomId]
+    captureMu.Unlock()
+    if capture != nil {
+        go endCapture(capture, "left")
+    }
+}
@@ -0,0 +1,102 @@
+package segment
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// OpenAI embeds and labels through an OpenAI compatible API.
+type OpenAI struct {
+    URL            string // Base URL, e.g. https://api.openai.com/v1
+    APIKey         string
+    EmbeddingModel string
+    ChatModel      string
+    Client         *http.Client
+}
+
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+    var response struct {
+        Data []struct {
+            Index     int       `json:"index"`
+            Embedding []float64 `json:"embedding"`
+        } `json:"data"`
+    }
+    if err := o.post(ctx, "/embeddings", map[string]interface{}{"model": o.EmbeddingModel, "input": texts}, &response); err != nil {
+        return nil, fmt.Errorf("embeddings: %v", err)
+    }
+    vectors := make([][]float64, len(texts))
+    for _, item := range response.Data {
+        if item.Index >= 0 && item.Index < len(vectors) {
+            vectors[item.Index] = item.Embedding
+        }
+    }
+    for i, v := range vectors {
+        if v == nil {
+            return nil, fmt.Errorf("embeddings: no vector for input %d", i)
+        }
+    }
+    return vectors, nil
+}
+
+func (o *OpenAI) Label(ctx context.Context, lines []string) (string, error) {
+    // Long sections are labelled from their opening, where the topic is raised
+    if len(lines) > 40 {
+        lines = lines[:40]
+    }
+    request := map[string]interface{}{
+        "model":       o.ChatModel,
+        "temperature": 0,
+        "max_tokens":  12,
+        "messages": []map[string]string{
+            {"role": "system", "content": "Name the topic of this part of a customer service call in two to four lowercase words, e.g. \"billing dispute\". Reply with the topic only."},
+            {"role": "user", "content": strings.Join(lines, "\n")},
+        },
+    }
+    var response struct {
+        Choices []struct {
+            Message struct {
+                Content string `json:"content"`
+            } `json:"message"`
+        } `json:"choices"`
+    }
+    if err := o.post(ctx, "/chat/completions", request, &response); err != nil {
+        return "", fmt.Errorf("labels: %v", err)
+    }
+    if len(response.Choices) == 0 {
+        return "", fmt.Errorf("labels: empty response")
+    }
+    return strings.Trim(strings.TrimSpace(response.Choices[0].Message.Content), `".`), nil
+}
+
+func (o *OpenAI) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+    data, _ := json.Marshal(body)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(o.URL, "/")+path, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if o.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+o.APIKey)
+    }
+    
+    client := o.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,297 @@
+// Package segment splits a long conversation into topical sections. Each gap
+// between utterances is scored by how similar the conversation is on either
+// side of it (TextTiling over embeddings), boundaries go at the deepest dips,
+// and every section gets a short label.
+package segment
+
+import (
+    "context"
+    "math"
+    "sort"
+    "strings"
+    "unicode"
+)
+
+type Utterance struct {
+    Id        string // Transcript message ID
+    Speaker   string
+    Text      string
+    Timestamp int64 // Unix milliseconds
+}
+
+type Segment struct {
+    Index          int      `json:"index"`
+    StartMessageId string   `json:"startMessageId"`
+    EndMessageId   string   `json:"endMessageId"`
+    StartedAt      int64    `json:"startedAt"`
+    EndedAt        int64    `json:"endedAt"`
+    Label          string   `json:"label"`
+    Keywords       []string `json:"keywords,omitempty"`
+}
+
+// Embedder turns texts into vectors, one per text, in order.
+type Embedder interface {
+    Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// Labeler names a section from its utterances, e.g. "billing dispute".
+type Labeler interface {
+    Label(ctx context.Context, lines []string) (string, error)
+}
+
+type Segmenter struct {
+    Embedder   Embedder // Nil uses bag-of-words vectors
+    Labeler    Labeler  // Nil labels sections with their keywords
+    Window     int      // Utterances compared on each side of a gap
+    MinSegment int      // Fewest utterances in a section
+}
+
+// Segment returns the sections of utterances, which must be in order. When
+// the embedder or labeler fail it falls back to the local versions and
+// reports the first error alongside the result.
+func (s *Segmenter) Segment(ctx context.Context, utterances []Utterance) ([]Segment, error) {
+    if len(utterances) == 0 {
+        return nil, nil
+    }
+    window, minSegment := s.Window, s.MinSegment
+    if window <= 0 {
+        window = 3
+    }
+    if minSegment <= 0 {
+        minSegment = 2 * window
+    }
+    
+    texts := make([]string, len(utterances))
+    for i, u := range utterances {
+        texts[i] = u.Text
+    }
+    var firstErr error
+    var vectors [][]float64
+    if s.Embedder != nil {
+        var err error
+        if vectors, err = s.Embedder.Embed(ctx, texts); err != nil || len(vectors) != len(texts) {
+            firstErr, vectors = err, nil
+        }
+    }
+    if vectors == nil {
+        vectors = bagOfWords(texts)
+    }
+    
+    bounds := boundaries(vectors, window, minSegment)
+    document := termCounts(strings.Join(texts, " "))
+    segments := make([]Segment, 0, len(bounds)+1)
+    start := 0
+    for i, end := range append(bounds, len(utterances)) {
+        part := utterances[start:end]
+        seg := Segment{
+            Index:          i,
+            StartMessageId: part[0].Id,
+            EndMessageId:   part[len(part)-1].Id,
+            StartedAt:      part[0].Timestamp,
+            EndedAt:        part[len(part)-1].Timestamp,
+            Keywords:       keywords(texts[start:end], document, 3),
+        }
+        if s.Labeler != nil {
+            lines := make([]string, len(part))
+            for j, u := range part {
+                lines[j] = u.Speaker + ": " + u.Text
+            }
+            label, err := s.Labeler.Label(ctx, lines)
+            if err != nil && firstErr == nil {
+                firstErr = err
+            }
+            seg.Label = label
+        }
+        if seg.Label == "" {
+            seg.Label = strings.Join(seg.Keywords, ", ")
+        }
+        segments = append(segments, seg)
+        start = end
+    }
+    return segments, firstErr
+}
+
+// boundaries returns the utterance indexes sections start at, excluding 0.
+func boundaries(vectors [][]float64, window int, minSegment int) []int {
+    n := len(vectors)
+    if n < 2*minSegment {
+        return nil
+    }
+    
+    // similarity[g] compares the windows before and after gap g, which sits
+    // in front of utterance g+1
+    similarity := make([]float64, n-1)
+    for g := range similarity {
+        from, to := g-window+1, g+1+window
+        if from < 0 {
+            from = 0
+        }
+        if to > n {
+            to = n
+        }
+        left := mean(vectors[from : g+1])
+        right := mean(vectors[g+1 : to])
+        similarity[g] = cosine(left, right)
+    }
+    
+    // Depth: how far the similarity dips below the nearest peaks either side
+    depth := make([]float64, len(similarity))
+    for g, value := range similarity {
+        leftPeak, rightPeak := value, value
+        for i := g - 1; i >= 0 && similarity[i] >= leftPeak; i-- {
+            leftPeak = similarity[i]
+        }
+        for i := g + 1; i < len(similarity) && similarity[i] >= rightPeak; i++ {
+            rightPeak = similarity[i]
+        }
+        depth[g] = (leftPeak - value) + (rightPeak - value)
+    }
+    
+    // The usual TextTiling cutoff: mean depth minus half a standard deviation
+    var sum, squares float64
+    for _, d := range depth {
+        sum += d
+        squares += d * d
+    }
+    avg := sum / float64(len(depth))
+    cutoff := avg - math.Sqrt(math.Max(0, squares/float64(len(depth))-avg*avg))/2
+    
+    gaps := make([]int, 0, len(depth))
+    for g, d := range depth {
+        if d > 0 && d > cutoff {
+            gaps = append(gaps, g)
+        }
+    }
+    sort.Slice(gaps, func(i, j int) bool { return depth[gaps[i]] > depth[gaps[j]] })
+    
+    // Deepest first, skipping any that would leave a section too short
+    var chosen []int
+    for _, g := range gaps {
+        start := g + 1
+        ok := start >= minSegment && n-start >= minSegment
+        for _, other := range chosen {
+            if abs(other-start) < minSegment {
+                ok = false
+            }
+        }
+        if ok {
+            chosen = append(chosen, start)
+        }
+    }
+    sort.Ints(chosen)
+    return chosen
+}
+
+func mean(vectors [][]float64) []float64 {
+    out := make([]float64, len(vectors[0]))
+    for _, v := range vectors {
+        for i, x := range v {
+            out[i] += x
+        }
+    }
+    for i := range out {
+        out[i] /= float64(len(vectors))
+    }
+    return out
+}
+
+func cosine(a, b []float64) float64 {
+    var dot, na, nb float64
+    for i := range a {
+        dot += a[i] * b[i]
+        na += a[i] * a[i]
+        nb += b[i] * b[i]
+    }
+    if na == 0 || nb == 0 {
+        return 0
+    }
+    return dot / math.Sqrt(na*nb)
+}
+
+func abs(x int) int {
+    if x < 0 {
+        return -x
+    }
+    return x
+}
+
+var stopwords = map[string]bool{}
+
+func init() {
+    for _, word := range strings.Fields(`a about after all also am an and any are as at be because been but by can
+        could did do does doing don't for from get got had has have he her here hi him his how i i'm if in into is it
+        it's its just know let like me my no not now of oh ok okay on one or our out please right so some sure than
+        thank thanks that that's the their them then there they this to too um uh up us very was we well were what
+        when where which who why will with would yeah yes you you're your`) {
+        stopwords[word] = true
+    }
+}
+
+func words(text string) []string {
+    fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+    })
+    out := fields[:0]
+    for _, w := range fields {
+        if len(w) > 2 && !stopwords[w] {
+            out = append(out, w)
+        }
+    }
+    return out
+}
+
+func termCounts(text string) map[string]int {
+    counts := make(map[string]int)
+    for _, w := range words(text) {
+        counts[w]++
+    }
+    return counts
+}
+
+// bagOfWords is the embedder of last resort: term counts over the
+// conversation's own vocabulary.
+func bagOfWords(texts []string) [][]float64 {
+    index := make(map[string]int)
+    counts := make([]map[string]int, len(texts))
+    for i, text := range texts {
+        counts[i] = termCounts(text)
+        for w := range counts[i] {
+            if _, ok := index[w]; !ok {
+                index[w] = len(index)
+            }
+        }
+    }
+    vectors := make([][]float64, len(texts))
+    for i := range texts {
+        vectors[i] = make([]float64, len(index)+1)
+        for w, n := range counts[i] {
+            vectors[i][index[w]] = float64(n)
+        }
+    }
+    return vectors
+}
+
+// keywords picks the terms most particular to a section compared to the
+// whole conversation.
+func keywords(texts []string, document map[string]int, n int) []string {
+    section := termCounts(strings.Join(texts, " "))
+    terms := make([]string, 0, len(section))
+    score := make(map[string]float64, len(section))
+    for w, count := range section {
+        if count < 2 && len(section) > n {
+            continue
+        }
+        terms = append(terms, w)
+        score[w] = float64(count) * float64(count) / float64(document[w])
+    }
+    sort.Slice(terms, func(i, j int) bool {
+        if score[terms[i]] != score[terms[j]] {
+            return score[terms[i]] > score[terms[j]]
+        }
+        return terms[i] < terms[j]
+    })
+    if len(terms) > n {
+        terms = terms[:n]
+    }
+    return terms
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    
+    "github.com/yourusername/my-go-project/segment"
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Topic segmentation. When a call with at least -segment-min-utterances
+// closes, its transcript is split into labelled sections and stored beside
+// it, so reviewers can jump to a topic in the transcript and recordings.
+// With -segment-url the boundaries come from embeddings and the labels from
+// an LLM, otherwise both are computed locally from word overlap.
+
+var (
+    segmenter      = &segment.Segmenter{}
+    segmentSource  = "lexical"
+    segmentWorkers = make(chan struct{}, 4) // Concurrent segmentations
+)
+
+// Transcript entry types that carry conversation text.
+var segmentedTypes = map[string]bool{
+    "transcript": true, "broadcast": true, "selective": true,
+    "agent_only": true, "user_only": true, "assistant_final": true,
+}
+
+const maxSegmentedEntries = 5000
+
+func loadSegmenter() {
+    segmenter.Window = cfg.SegmentWindow
+    segmenter.MinSegment = cfg.SegmentMinLength
+    if cfg.SegmentURL != "" {
+        client := &segment.OpenAI{URL: cfg.SegmentURL, APIKey: cfg.SegmentAPIKey, EmbeddingModel: cfg.SegmentEmbeddingModel, ChatModel: cfg.SegmentChatModel}
+        segmenter.Embedder = client
+        segmenter.Labeler = client
+        segmentSource = "embeddings"
+    }
+}
+
+// scheduleSegmentation segments a closed room in the background.
+func scheduleSegmentation(roomId string) {
+    if cfg.SegmentMinUtterances <= 0 {
+        return
+    }
+    go func() {
+        segmentWorkers <- struct{}{}
+        defer func() { <-segmentWorkers }()
+        
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.SegmentTimeout)
+        defer cancel()
+        if _, err := segmentRoom(ctx, roomId, cfg.SegmentMinUtterances); err != nil {
+            log.Printf("Segmenting room %s failed: %v", roomId, err)
+        }
+    }()
+}
+
+// roomTranscript reads up to maxSegmentedEntries of a room's transcript,
+// oldest first.
+func roomTranscript(ctx context.Context, roomId string) ([]storage.TranscriptEntry, error) {
+    var entries []storage.TranscriptEntry
+    cursor := ""
+    for len(entries) < maxSegmentedEntries {
+        batch, err := store.Transcripts().List(ctx, roomId, cursor, 200)
+        if err != nil {
+            return nil, err
+        }
+        if len(batch) == 0 {
+            break
+        }
+        entries = append(entries, batch...)
+        cursor = batch[len(batch)-1].MessageId
+    }
+    for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+        entries[i], entries[j] = entries[j], entries[i]
+    }
+    return entries, nil
+}
+
+// segmentRoom segments and stores a room's transcript when it has at least
+// minUtterances lines, returning the stored segments.
+func segmentRoom(ctx context.Context, roomId string, minUtterances int) ([]storage.Segment, error) {
+    entries, err := roomTranscript(ctx, roomId)
+    if err != nil {
+        return nil, err
+    }
+    var utterances []segment.Utterance
+    for _, entry := range entries {
+        if !segmentedTypes[entry.Type] {
+            continue
+        }
+        text := searchText(entry.Data)
+        if text == "" {
+            continue
+        }
+        speaker := entry.From
+        if entry.Type == "transcript" {
+            var data struct {
+                ClientId string `json:"clientId"`
+            }
+            json.Unmarshal(entry.Data, &data)
+            speaker = data.ClientId
+        }
+        utterances = append(utterances, segment.Utterance{Id: entry.MessageId, Speaker: speaker, Text: text, Timestamp: entry.Timestamp})
+    }
+    if len(utterances) < minUtterances || len(utterances) == 0 {
+        return nil, nil
+    }
+    
+    sections, err := segmenter.Segment(ctx, utterances)
+    source := segmentSource
+    if err != nil {
+        // The local fallback filled in, keep its result but say so
+        log.Printf("Segmenting room %s fell back to local scoring: %v", roomId, err)
+        source = "lexical"
+    }
+    segments := make([]storage.Segment, len(sections))
+    for i, s := range sections {
+        segments[i] = storage.Segment{
+            RoomId:         roomId,
+            Index:          s.Index,
+            StartMessageId: s.StartMessageId,
+            EndMessageId:   s.EndMessageId,
+            StartedAt:      s.StartedAt,
+            EndedAt:        s.EndedAt,
+            Label:          s.Label,
+            Keywords:       s.Keywords,
+            Source:         source,
+        }
+    }
+    if err := store.Segments().Replace(ctx, roomId, segments); err != nil {
+        return nil, err
+    }
+    return segments, nil
+}
+
+// segmentView places a segment in the recordings that cover its start, as
+// offsets reviewers can seek to.
+func segmentView(s storage.Segment, recordings []storage.Recording) map[string]interface{} {
+    inRecordings := make([]map[string]interface{}, 0, 1)
+    for _, rec := range recordings {
+        if s.StartedAt >= rec.StartedAt && (rec.StoppedAt == 0 || s.StartedAt <= rec.StoppedAt) {
+            inRecordings = append(inRecordings, map[string]interface{}{
+                "recordingId": rec.Id,
+                "uri":         rec.URI,
+                "offsetMs":    s.StartedAt - rec.StartedAt,
+            })
+        }
+    }
+    return map[string]interface{}{
+        "index":          s.Index,
+        "label":          s.Label,
+        "keywords":       s.Keywords,
+        "startMessageId": s.StartMessageId,
+        "endMessageId":   s.EndMessageId,
+        "startedAt":      s.StartedAt,
+        "endedAt":        s.EndedAt,
+        "durationMs":     s.EndedAt - s.StartedAt,
+        "source":         s.Source,
+        "recordings":     inRecordings,
+    }
+}
+
+// GET /room/{id}/summary (admin): the latest "summary" message of the call,
+// its topic segments and recordings.
+// POST /room/{id}/segments (admin) re-segments the call now, whatever its length.
+func handleRoomSummary(w http.ResponseWriter, r *http.Request, roomId string, resource string) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    ctx := r.Context()
+    
+    var segments []storage.Segment
+    var err error
+    switch {
+    case resource == "segments" && r.Method == http.MethodPost:
+        ctx, cancel := context.WithTimeout(ctx, cfg.SegmentTimeout)
+        defer cancel()
+        segments, err = segmentRoom(ctx, roomId, 1)
+    case r.Method == http.MethodGet:
+        segments, err = store.Segments().List(ctx, roomId)
+    default:
+        http.Error(w, "Only GET, or POST on segments, allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if err != nil {
+        log.Printf("Reading segments for room %s failed: %v", roomId, err)
+        http.Error(w, "Segments unavailable", http.StatusInternalServerError)
+        return
+    }
+    
+    recordings, err := store.Recordings().ListByRoom(ctx, roomId)
+    if err != nil {
+        http.Error(w, "Recordings unavailable", http.StatusInternalServerError)
+        return
+    }
+    views := make([]map[string]interface{}, len(segments))
+    for i, s := range segments {
+        views[i] = segmentView(s, recordings)
+    }
+    response := map[string]interface{}{
+        "roomId":   roomId,
+        "segments": views,
+    }
+    
+    if resource == "summary" {
+        entries, err := roomTranscript(ctx, roomId)
+        if err != nil {
+            http.Error(w, "History unavailable", http.StatusInternalServerError)
+            return
+        }
+        for i := len(entries) - 1; i >= 0; i-- {
+            if entries[i].Type == "summary" {
+                response["summary"] = historyEntryFrom(entries[i])
+                break
+            }
+        }
+        if recordings == nil {
+            recordings = []storage.Recording{}
+        }
+        response["recordings"] = recordings
+        if room, err := store.Rooms().Get(ctx, roomId); err == nil {
+            response["startedAt"] = room.CreatedAt
+            response["closedAt"] = room.ClosedAt
+        }
+    }
+    
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Priority classes of the per-client send queue. The writer always drains
+// audio first, so media never waits behind a large transcript or KG payload.
+//
+// Each client's writer goroutine is the only one writing data frames, so
+// forwarding never writes to a shared connection inline. A write that takes
+// longer than -write-timeout fails, and the writer then closes the
+// connection: the client is gone or too slow, and its reader cleans up. When
+// the audio queue is full its oldest frame is dropped for the new one, a late
+// frame being worth less than a fresh one. What a full message queue does is
+// -send-queue-overflow: "block" waits up to -send-block-timeout for room,
+// holding up the sender that long, "drop" loses the message, and "close"
+// disconnects the client with slow_consumer, so it resumes with
+// lastSeq and gets its messages replayed instead of missing them. A block
+// that times out closes the client like "close".
+//
+// A client whose queue overflows is lagging: the rest of the room gets
+// slow_consumer with lagging true and the queue that overflowed, then
+// slow_consumer with lagging false and the frames dropped meanwhile once the
+// client has kept up for slowConsumerQuiet, so one hovering at its queue's
+// limit isn't announced at every frame.
+type Priority int
+
+const (
+    PriorityAudio Priority = iota
+    PriorityControl
+    PriorityBulk
+    priorityCount
+)
+
+func (p Priority) String() string {
+    switch p {
+    case PriorityAudio:
+        return "audio"
+    case PriorityControl:
+        return "control"
+    case PriorityBulk:
+        return "bulk"
+    }
+    return "unknown"
+}
+
+var errQueueFull = errors.New("send queue full")
+
+const slowConsumerQuiet = 5 * time.Second
+
+var slowConsumersTotal = newCounterVec("iva_slow_consumers_total", "Times a client started lagging behind its send queue, by the queue that overflowed.", "priority")
+
+func init() {
+    metricSeries = append(metricSeries, slowConsumersTotal)
+}
+
+type outbound struct {
+    messageType int
+    data        []byte
+}
+
+// Send queue overflow policies.
+const (
+    OverflowBlock = "block"
+    OverflowDrop  = "drop"
+    OverflowClose = "close"
+)
+
+type sendQueue struct {
+    queues   [priorityCount]chan outbound
+    done     chan struct{}
+    overflow sync.Once // Closes the client once under OverflowClose and OverflowBlock
+    closing  bool      // Set once overflow closes the client, guarded by the client's mu
+    lagging   atomic.Bool  // A queue overflowed since the writer last emptied them
+    announced atomic.Bool  // The room was told the client is lagging
+    watching  atomic.Bool  // A catch up check is pending
+    dropped   atomic.Int64 // Frames dropped since the client was announced lagging
+}
+
+func checkSendQueue() error {
+    switch cfg.SendQueueOverflow {
+    case OverflowBlock, OverflowDrop, OverflowClose:
+    default:
+        return fmt.Errorf("unknown -send-queue-overflow %q, use block, drop or close", cfg.SendQueueOverflow)
+    }
+    if cfg.SendQueueOverflow == OverflowBlock && cfg.SendBlockTimeout <= 0 {
+        return fmt.Errorf("-send-queue-overflow block needs a positive -send-block-timeout")
+    }
+    return nil
+}
+
+func newSendQueue() *sendQueue {
+    q := &sendQueue{done: make(chan struct{})}
+    q.queues[PriorityAudio] = make(chan outbound, cfg.AudioQueueSize)
+    q.queues[PriorityControl] = make(chan outbound, cfg.ControlQueueSize)
+    q.queues[PriorityBulk] = make(chan outbound, cfg.ControlQueueSize)
+    return q
+}
+
+func (q *sendQueue) next() (outbound, bool) {
+    audio, control, bulk := q.queues[PriorityAudio], q.queues[PriorityControl], q.queues[PriorityBulk]
+    
+    select {
+    case <-q.done:
+        return outbound{}, false
+    case f := <-audio:
+        return f, true
+    default:
+    }
+    
+    select {
+    case f := <-audio:
+        return f, true
+    case f := <-control:
+        return f, true
+    default:
+    }
+    
+    select {
+    case <-q.done:
+        return outbound{}, false
+    case f := <-audio:
+        return f, true
+    case f := <-control:
+        return f, true
+    case f := <-bulk:
+        return f, true
+    }
+}
+
+func (q *sendQueue) empty() bool {
+    for _, queue := range q.queues {
+        if len(queue) > 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// startWriter runs the only goroutine allowed to write data frames to the connection.
+func (c *Client) startWriter() {
+    c.send = newSendQueue()
+    
+    go func() {
+        for {
+            f, ok := c.send.next()
+            if !ok {
+                return
+            }
+            
+            c.conn.EnableWriteCompression(compressFrame(f.messageType, len(f.data)))
+            if cfg.WriteTimeout > 0 {
+                c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+            }
+            if err := c.conn.WriteMessage(f.messageType, f.data); err != nil {
+                // The connection is unusable after a failed write
+                logAt("warn", c.room, c.clientId, "Write error, closing: %v", err)
+                c.conn.Close()
+                return
+            }
+            if c.send.lagging.Load() && c.send.empty() {
+                c.send.lagging.Store(false)
+                c.watchCaughtUp()
+            }
+        }
+    }()
+}
+
+func (c *Client) stopWriter() {
+    if c.setState(ClientClosed) {
+        close(c.send.done)
+    }
+}
+
+// enqueue holds c.mu across the state check and the send so nothing can be
+// queued for a client once it started draining. A block for room lets go of
+// it, as broadcasts, state changes and closing all need it meanwhile, and
+// checks the state again after.
+func (c *Client) enqueue(priority Priority, f outbound) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    if !c.acceptsWrites() {
+        return errClientNotActive
+    }
+    queue := c.send.queues[priority]
+    select {
+    case queue <- f:
+        return nil
+    default:
+    }
+    c.noteLagging(priority)
+    
+    switch {
+    case priority == PriorityAudio:
+        // Only the writer takes frames off, so there is room after this
+        select {
+        case <-queue:
+        default:
+        }
+        c.dropFrame(priority)
+        select {
+        case queue <- f:
+            return nil
+        default:
+            return errQueueFull
+        }
+    case c.send.closing:
+        // Already closing, don't hold up the sender again
+    case cfg.SendQueueOverflow == OverflowBlock:
+        c.mu.Unlock()
+        sent, stopped := c.send.waitForRoom(queue, f)
+        c.mu.Lock()
+        switch {
+        case stopped || !c.acceptsWrites():
+            return errClientNotActive
+        case sent:
+            return nil
+        case c.send.closing:
+            return errQueueFull // Another sender's wait already closed it
+        }
+    }
+    
+    c.dropFrame(priority)
+    if cfg.SendQueueOverflow != OverflowDrop {
+        c.send.closing = true
+        c.send.overflow.Do(func() { go c.closeOverflowed() })
+    }
+    return errQueueFull
+}
+
+// waitForRoom sends f once queue has room, giving up after
+// -send-block-timeout or when the writer stops.
+func (q *sendQueue) waitForRoom(queue chan outbound, f outbound) (sent bool, stopped bool) {
+    timer := time.NewTimer(cfg.SendBlockTimeout)
+    defer timer.Stop()
+    select {
+    case queue <- f:
+        return true, false
+    case <-q.done:
+        return false, true
+    case <-timer.C:
+        return false, false
+    }
+}
+
+func (c *Client) dropFrame(priority Priority) {
+    queueDropsTotal.inc(c.labels, priority.String())
+    c.send.dropped.Add(1)
+}
+
+// noteLagging tells the room the client started lagging. Callers hold c.mu.
+func (c *Client) noteLagging(priority Priority) {
+    if c.send.lagging.CompareAndSwap(false, true) && c.send.announced.CompareAndSwap(false, true) {
+        slowConsumersTotal.inc(c.labels, priority.String())
+        go notifySlowConsumer(c, priority.String(), true, 0)
+    }
+}
+
+// watchCaughtUp tells the room a lagging client caught up if it hasn't
+// lagged again within slowConsumerQuiet. Lagging again, its next emptied
+// queues watch anew.
+func (c *Client) watchCaughtUp() {
+    if !c.send.announced.Load() || !c.send.watching.CompareAndSwap(false, true) {
+        return
+    }
+    time.AfterFunc(slowConsumerQuiet, func() {
+        c.send.watching.Store(false)
+        if c.send.lagging.Load() || c.currentState() != ClientActive {
+            return
+        }
+        if c.send.announced.CompareAndSwap(true, false) {
+            notifySlowConsumer(c, "", false, c.send.dropped.Swap(0))
+        }
+    })
+}
+
+// notifySlowConsumer tells the rest of the room a client started lagging
+// behind its queue, or caught up with dropped frames lost meanwhile.
+func notifySlowConsumer(c *Client, queue string, lagging bool, dropped int64) {
+    data := map[string]interface{}{
+        "clientId":   c.clientId,
+        "clientType": c.clientType,
+        "lagging":    lagging,
+    }
+    if lagging {
+        data["queue"] = queue
+        logAt("warn", c.room, c.clientId, "Slow consumer, %s queue full", queue)
+    } else {
+        data["dropped"] = dropped
+        logAt("info", c.room, c.clientId, "Slow consumer caught up, %d frames dropped", dropped)
+    }
+    broadcastToRoom(c.room, c, &Message{
+        Type:      "slow_consumer",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// closeOverflowed disconnects a client too slow for its send queue.
+func (c *Client) closeOverflowed() {
+    logAt("warn", c.room, c.clientId, "Send queue full, closing the connection")
+    closeConnection(c.conn, CloseSlowConsumer, "")
+}
+
+func writeJSON(client *Client, msg *Message) error {
+    data, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+    
+    priority := PriorityControl
+    if len(data) > cfg.BulkMessageBytes {
+        priority = PriorityBulk
+    }
+    return client.enqueue(priority, outbound{messageType: websocket.TextMessage, data: data})
+}
+
+func writeAudio(client *Client, audioData []byte) error {
+    return client.enqueue(PriorityAudio, outbound{messageType: websocket.BinaryMessage, data: audioData})
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Shadow agents, for trying a candidate prompt or model on live calls
+// without callers hearing it. A shadow connects like an agent with
+// role=shadow, which needs admin credentials or a ticket claiming the role,
+// and joins a room that is already live:
+//
+//   /ws?room=ID&clientId=ID&type=agent&role=shadow&candidate=prompt-v7
+//
+// It gets the welcome and everything the room's agents get, transcripts and
+// chat included, but nobody sees it join and nothing it sends leaves the
+// server. The text of its chat, assistant_final, speak and finished
+// speak_stream is kept as its answer to the caller's current turn, its audio
+// is dropped, and answer_lookup always misses so it answers for itself. When
+// the caller next speaks, or the call ends, the turn is logged with the
+// production agents' answers beside the shadows':
+//
+//   {"id", "roomId", "tenant", "template", "question", "askedAt",
+//    "production": [{"clientId", "agentId", "type", "text", "latencyMs"}],
+//    "shadow": [{..., "candidate"}]}
+//
+// as JSON lines to -shadow-log, and into the last -shadow-max-turns kept for
+// GET /admin/shadow?tenant=&roomId=&candidate=&limit=.
+
+type ShadowAnswer struct {
+    ClientId  string `json:"clientId"`
+    AgentId   string `json:"agentId,omitempty"`
+    Candidate string `json:"candidate,omitempty"` // Shadows only, what they were started with
+    Type      string `json:"type"`
+    Text      string `json:"text"`
+    LatencyMs int64  `json:"latencyMs"` // Since the question
+}
+
+type ShadowTurn struct {
+    Id         string            `json:"id"`
+    RoomId     string            `json:"roomId"`
+    Tenant     string            `json:"tenant,omitempty"`
+    Template   string            `json:"template,omitempty"`
+    Variants   map[string]string `json:"variants,omitempty"`
+    Question   string            `json:"question"`
+    AskedAt    int64             `json:"askedAt"`
+    Production []ShadowAnswer    `json:"production"`
+    Shadow     []ShadowAnswer    `json:"shadow"`
+    labels     []string
+}
+
+var (
+    shadowTurns []*ShadowTurn // The last -shadow-max-turns, oldest first
+    shadowLog   *os.File
+    shadowMu    sync.Mutex // Never held while taking roomsMu
+    
+    shadowTurnsTotal = newCounterVec("iva_shadow_turns_total", "Caller turns of rooms with shadow agents, by who answered them.", "answered")
+)
+
+func init() {
+    metricSeries = append(metricSeries, shadowTurnsTotal)
+}
+
+func openShadowLog() error {
+    if cfg.ShadowLog == "" {
+        return nil
+    }
+    file, err := os.OpenFile(cfg.ShadowLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    shadowLog = file
+    return nil
+}
+
+// addShadowToRoom returns false when the room is not live.
+func addShadowToRoom(roomId string, client *Client) bool {
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    room := rooms[roomId]
+    if room == nil {
+        return false
+    }
+    if room.Shadows == nil {
+        room.Shadows = make(map[string]*Client)
+    }
+    room.Shadows[client.clientId] = client
+    client.labels = room.labels
+    return true
+}
+
+func removeShadowFromRoom(roomId string, client *Client) {
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    if room := rooms[roomId]; room != nil && room.Shadows[client.clientId] == client {
+        delete(room.Shadows, client.clientId)
+    }
+}
+
+// roomShadows snapshots the room's shadows for fan-out.
+func roomShadows(roomId string) []*Client {
+    roomsMu.RLock()
+    defer roomsMu.RUnlock()
+    room := rooms[roomId]
+    if room == nil || len(room.Shadows) == 0 {
+        return nil
+    }
+    shadows := make([]*Client, 0, len(room.Shadows))
+    for _, client := range room.Shadows {
+        shadows = append(shadows, client)
+    }
+    return shadows
+}
+
+// serveShadow runs a shadow's connection in place of the room member's.
+func serveShadow(client *Client) {
+    if !addShadowToRoom(client.room, client) {
+        client.stopWriter()
+        rejectConnection(client.conn, ErrTargetNotFound, "room is not live")
+        return
+    }
+    client.setState(ClientActive)
+    logAt("info", client.room, client.clientId, "Shadow agent joined room, candidate %q", client.candidate)
+    sendWelcomeMessage(client, 0)
+    
+    for {
+        messageType, data, err := client.conn.ReadMessage()
+        if err != nil {
+            logAt("info", client.room, client.clientId, "Read error: %v", err)
+            break
+        }
+        client.extendReadDeadline()
+        // Shadows are never heard
+        if messageType != websocket.TextMessage {
+            continue
+        }
+        var msg Message
+        if err := json.Unmarshal(data, &msg); err != nil {
+            sendError(client, ErrInvalidMessage, nil, "message is not valid JSON")
+            continue
+        }
+        msg.Id = newMessageId()
+        msg.From = client.clientId
+        msg.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+        handleShadowMessage(client, &msg)
+    }
+    
+    client.setState(ClientDraining)
+    removeShadowFromRoom(client.room, client)
+    logAt("info", client.room, client.clientId, "Shadow agent left room")
+    client.stopWriter()
+    client.conn.Close()
+}
+
+// handleShadowMessage keeps a shadow's answers; nothing is delivered.
+func handleShadowMessage(client *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    text, _ := data["text"].(string)
+    switch msg.Type {
+    case "answer_lookup":
+        question, _ := data["question"].(string)
+        sendMessageToClient(client, &Message{
+            Id:        newMessageId(),
+            Type:      "answer_cached",
+            From:      SystemSender,
+            Data:      map[string]interface{}{"hit": false, "question": normalizeQuestion(question)},
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+        return
+    case "speak_stream":
+        // Only read-loop state, so no lock
+        streamId, _ := data["streamId"].(string)
+        if client.shadowStreams == nil {
+            client.shadowStreams = make(map[string]string)
+        }
+        client.shadowStreams[streamId] += text
+        if final, _ := data["final"].(bool); !final {
+            return
+        }
+        text = client.shadowStreams[streamId]
+        delete(client.shadowStreams, streamId)
+    case "typing", "reaction", "read", "speak_stop":
+        return
+    }
+    if text = strings.TrimSpace(text); text == "" {
+        return
+    }
+    
+    roomsMu.Lock()
+    defer roomsMu.Unlock()
+    room := rooms[client.room]
+    if room == nil || room.shadowTurn == nil {
+        return
+    }
+    turn := room.shadowTurn
+    turn.Shadow = appendShadowAnswer(turn.Shadow, ShadowAnswer{
+        ClientId:  client.clientId,
+        AgentId:   client.agentId,
+        Candidate: client.candidate,
+        Type:      msg.Type,
+        Text:      text,
+        LatencyMs: msg.Timestamp - turn.AskedAt,
+    })
+}
+
+// observeShadowTurn follows the turns of a room with shadows: a caller's
+// text starts the next, an agent's is the production answer to the current.
+// Callers hold roomsMu; the finished turn, if any, is logged.
+func observeShadowTurn(room *RoomInfo, sender *Client, msgType string, text string, at int64) {
+    if len(room.Shadows) == 0 && room.shadowTurn == nil {
+        return
+    }
+    if text = strings.TrimSpace(text); text == "" {
+        return
+    }
+    if sender.clientType == ClientTypeUser {
+        finished := room.shadowTurn
+        room.shadowTurn = nil
+        if len(room.Shadows) > 0 {
+            room.shadowTurn = &ShadowTurn{
+                Id:       newMessageId(),
+                RoomId:   room.RoomId,
+                Tenant:   room.Tenant,
+                Template: room.Template,
+                Variants: room.Variants,
+                Question: text,
+                AskedAt:  at,
+                labels:   room.labels,
+            }
+        }
+        if finished != nil {
+            go logShadowTurn(finished)
+        }
+        return
+    }
+    if turn := room.shadowTurn; turn != nil {
+        turn.Production = appendShadowAnswer(turn.Production, ShadowAnswer{
+            ClientId:  sender.clientId,
+            AgentId:   sender.agentId,
+            Type:      msgType,
+            Text:      text,
+            LatencyMs: at - turn.AskedAt,
+        })
+    }
+}
+
+// appendShadowAnswer skips an agent repeating itself, as an assistant_final
+// and the speak of it.
+func appendShadowAnswer(answers []ShadowAnswer, answer ShadowAnswer) []ShadowAnswer {
+    for _, previous := range answers {
+        if previous.ClientId == answer.ClientId && previous.Text == answer.Text {
+            return answers
+        }
+    }
+    return append(answers, answer)
+}
+
+// takeShadows logs the closing room's last turn and returns its shadows for
+// closeShadows. Callers hold roomsMu.
+func takeShadows(room *RoomInfo) []*Client {
+    if room.shadowTurn != nil {
+        go logShadowTurn(room.shadowTurn)
+        room.shadowTurn = nil
+    }
+    shadows := make([]*Client, 0, len(room.Shadows))
+    for _, shadow := range room.Shadows {
+        shadows = append(shadows, shadow)
+    }
+    return shadows
+}
+
+// closeShadows disconnects a closed room's shadows, without roomsMu as it
+// writes to them.
+func closeShadows(shadows []*Client) {
+    for _, shadow := range shadows {
+        closeConnection(shadow.conn, CloseRoomClosed, "")
+    }
+}
+
+func logShadowTurn(turn *ShadowTurn) {
+    answered := "neither"
+    switch {
+    case len(turn.Production) > 0 && len(turn.Shadow) > 0:
+        answered = "both"
+    case len(turn.Production) > 0:
+        answered = "production"
+    case len(turn.Shadow) > 0:
+        answered = "shadow"
+    }
+    shadowTurnsTotal.inc(turn.labels, answered)
+    
+    shadowMu.Lock()
+    defer shadowMu.Unlock()
+    if cfg.ShadowMaxTurns > 0 {
+        shadowTurns = append(shadowTurns, turn)
+        if len(shadowTurns) > cfg.ShadowMaxTurns {
+            shadowTurns = append([]*ShadowTurn(nil), shadowTurns[len(shadowTurns)-cfg.ShadowMaxTurns:]...)
+        }
+    }
+    if shadowLog != nil {
+        line, _ := json.Marshal(turn)
+        if _, err := shadowLog.Write(append(line, '\n')); err != nil {
+            logAt("warn", turn.RoomId, "", "Shadow log write failed: %v", err)
+        }
+    }
+}
+
+// GET /admin/shadow?tenant=&roomId=&candidate=&limit= (admin), newest first
+func handleShadowTurns(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    query := r.URL.Query()
+    limit := 100
+    if value := query.Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n <= 0 {
+            http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+    tenant, roomId, candidate := query.Get("tenant"), query.Get("roomId"), query.Get("candidate")
+    
+    turns := []*ShadowTurn{}
+    shadowMu.Lock()
+    for i := len(shadowTurns) - 1; i >= 0 && len(turns) < limit; i-- {
+        turn := shadowTurns[i]
+        if (tenant != "" && turn.Tenant != tenant) || (roomId != "" && turn.RoomId != roomId) {
+            continue
+        }
+        if candidate != "" && !shadowAnswered(turn, candidate) {
+            continue
+        }
+        turns = append(turns, turn)
+    }
+    shadowMu.Unlock()
+    json.NewEncoder(w).Encode(map[string]interface{}{"turns": turns})
+}
+
+func shadowAnswered(turn *ShadowTurn, candidate string) bool {
+    for _, answer := range turn.Shadow {
+        if answer.Candidate == candidate {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/speaker"
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Speaker recognition. With -speaker-url set the server keeps the last
+// -speaker-sample-seconds of each caller's pcm16 audio. After
+// -speaker-identify-after seconds it scores the caller against the tenant's
+// enrolled voiceprints, and a match above -speaker-match-threshold reaches
+// the agents as speaker_recognized so the IVA can load what it knows about
+// the customer. Recognition is a hint, not verification: sensitive tools
+// still need verify_start.
+//
+// Voiceprints need the customer's consent, recorded by the caller or by an
+// agent on their behalf:
+//
+//   {"type": "speaker_consent", "data": {"granted": true, "customerId": "C-1042"}}
+//
+// An agent enrolls one with speaker_enroll once the caller has passed
+// verification, or -speaker-auto-enroll does it on passing. Withdrawing
+// consent deletes the voiceprint. Consent is kept in storage and every
+// change goes to the audit log.
+
+const voiceprintPurpose = "voiceprint"
+
+var speakers speaker.Provider // Nil unless -speaker-url is set
+
+var speakerOperations = newCounterVec("iva_speaker_operations_total", "Speaker recognition calls by operation and outcome.", "operation", "outcome")
+
+func init() {
+    metricSeries = append(metricSeries, speakerOperations)
+}
+
+func loadSpeakers() {
+    if cfg.SpeakerURL == "" {
+        return
+    }
+    speakers = &speaker.HTTP{
+        URL:    cfg.SpeakerURL,
+        APIKey: cfg.SpeakerAPIKey,
+        Client: &http.Client{Timeout: cfg.VerifyTimeout},
+    }
+}
+
+// startVoiceSample keeps the caller's recent mono pcm16 audio for voice
+// matching.
+func startVoiceSample(client *Client, query url.Values) {
+    if speakers == nil || client.clientType != ClientTypeUser || query.Get("audioFormat") != "pcm16" {
+        return
+    }
+    rate, err := strconv.Atoi(query.Get("sampleRate"))
+    if err != nil || rate <= 0 {
+        return
+    }
+    if channels := query.Get("channels"); channels != "" && channels != "1" {
+        return
+    }
+    client.mu.Lock()
+    client.voiceRate = rate
+    client.mu.Unlock()
+}
+
+func sampleVoice(client *Client, data []byte) {
+    client.mu.Lock()
+    if client.voiceRate == 0 {
+        client.mu.Unlock()
+        return
+    }
+    
+    client.voiceSample = append(client.voiceSample, data...)
+    client.voiceHeard += len(data)
+    limit := client.voiceRate * 2 * cfg.SpeakerSampleSeconds
+    if excess := len(client.voiceSample) - limit; excess > 0 {
+        excess += excess & 1 // Stay on a sample boundary
+        n := copy(client.voiceSample, client.voiceSample[excess:])
+        client.voiceSample = client.voiceSample[:n]
+    }
+    
+    identify := !client.speakerChecked && cfg.SpeakerIdentifyAfter > 0 && client.voiceHeard >= client.voiceRate*2*cfg.SpeakerIdentifyAfter
+    if identify {
+        client.speakerChecked = true
+    }
+    client.mu.Unlock()
+    if identify {
+        go identifySpeaker(client, voiceSampleOf(client))
+    }
+}
+
+func voiceSampleOf(client *Client) speaker.Audio {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    return speaker.Audio{PCM: append([]byte(nil), client.voiceSample...), SampleRate: client.voiceRate}
+}
+
+func voiceprintConsent(ctx context.Context, tenant string, customerId string) bool {
+    consent, err := store.Consents().Get(ctx, tenant, customerId, voiceprintPurpose)
+    return err == nil && consent.Granted
+}
+
+// identifySpeaker looks for the caller among the tenant's enrolled voices.
+func identifySpeaker(client *Client, audio speaker.Audio) {
+    room, _, _ := roomMembers(client.room)
+    if room == nil {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+    defer cancel()
+    
+    matches, err := speakers.Score(ctx, room.Tenant, audio)
+    if err != nil {
+        speakerOperations.inc(client.labels, "score", "error")
+        logAt("warn", client.room, client.clientId, "Speaker recognition failed: %v", err)
+        return
+    }
+    var best speaker.Match
+    for _, m := range matches {
+        if m.Score > best.Score {
+            best = m
+        }
+    }
+    if best.SpeakerId == "" || best.Score < cfg.SpeakerMatchThreshold {
+        speakerOperations.inc(client.labels, "score", "unknown")
+        return
+    }
+    // The voiceprint may outlive a withdrawal if its deletion failed
+    if !voiceprintConsent(ctx, room.Tenant, best.SpeakerId) {
+        speakerOperations.inc(client.labels, "score", "no_consent")
+        logAt("warn", client.room, client.clientId, "Recognized %s without voiceprint consent, ignoring", best.SpeakerId)
+        return
+    }
+    
+    roomsMu.Lock()
+    state := verificationFor(room, client.clientId)
+    state.RecognizedAs = best.SpeakerId
+    state.RecognitionScore = best.Score
+    roomsMu.Unlock()
+    
+    data := map[string]interface{}{
+        "userId":     client.clientId,
+        "customerId": best.SpeakerId,
+        "score":      best.Score,
+    }
+    sendToAgents(room.RoomId, nil, &Message{
+        Id:        newMessageId(),
+        Type:      "speaker_recognized",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    speakerOperations.inc(client.labels, "score", "recognized")
+    logAt("info", client.room, client.clientId, "Recognized as %s (%.2f)", best.SpeakerId, best.Score)
+    emitEvent("speaker_recognized", room, data)
+    audit(room, SystemSender, "speaker_recognized", client.clientId, "recognized", map[string]interface{}{
+        "customerId": best.SpeakerId,
+        "score":      best.Score,
+    })
+}
+
+// speakerCustomer is the customer a speaker message is about: the one named,
+// else the caller's.
+func speakerCustomer(room *RoomInfo, user *Client, data map[string]interface{}) string {
+    if customerId, _ := data["customerId"].(string); customerId != "" {
+        return customerId
+    }
+    return customerOf(room, user)
+}
+
+// customerOf is who a caller is: the customer they verified or were
+// recognized as, then the one in their metadata.
+func customerOf(room *RoomInfo, user *Client) string {
+    roomsMu.RLock()
+    state := room.verifications[user.clientId]
+    roomsMu.RUnlock()
+    if state != nil && state.CustomerId != "" {
+        return state.CustomerId
+    }
+    if state != nil && state.RecognizedAs != "" {
+        return state.RecognizedAs
+    }
+    user.mu.Lock()
+    defer user.mu.Unlock()
+    customerId, _ := user.metadata["customerId"].(string)
+    return customerId
+}
+
+func handleSpeakerMessage(roomId string, sender *Client, msg *Message) {
+    switch msg.Type {
+    case "speaker_consent":
+        handleSpeakerConsent(roomId, sender, msg)
+    case "speaker_enroll":
+        handleSpeakerEnroll(roomId, sender, msg)
+    }
+}
+
+func isSpeakerMessage(msgType string) bool {
+    return msgType == "speaker_consent" || msgType == "speaker_enroll"
+}
+
+// speaker_consent: {"granted": BOOL, "customerId": ID, "userId": ID}
+func handleSpeakerConsent(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    granted, ok := data["granted"].(bool)
+    if !ok {
+        sendError(sender, ErrInvalidMessage, msg, "speaker_consent needs granted")
+        return
+    }
+    room, user, err := verificationTarget(roomId, data)
+    if err == nil && sender.clientType == ClientTypeUser && user != sender {
+        err = newCodedError(ErrNotPermitted, "callers can only give their own consent")
+    }
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    customerId := speakerCustomer(room, user, data)
+    if customerId == "" {
+        sendError(sender, ErrInvalidMessage, msg, "customerId required")
+        return
+    }
+    
+    consent := storage.Consent{
+        Tenant:     room.Tenant,
+        CustomerId: customerId,
+        Purpose:    voiceprintPurpose,
+        Granted:    granted,
+        UpdatedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+        RoomId:     roomId,
+        RecordedBy: sender.clientId,
+    }
+    // Written before answering, so an enroll that follows sees it
+    go func() {
+        if err := recordVoiceprintConsent(room, consent, user.clientId); err != nil {
+            sendError(sender, ErrUnavailable, msg, "consent not recorded: %v", err)
+            return
+        }
+        notice := &Message{
+            Id:   newMessageId(),
+            Type: "speaker_consent_updated",
+            From: SystemSender,
+            Data: map[string]interface{}{
+                "userId":     user.clientId,
+                "customerId": customerId,
+                "granted":    granted,
+            },
+            Timestamp: consent.UpdatedAt,
+        }
+        sendToAgents(roomId, nil, notice)
+        sendMessageToClient(user, notice)
+    }()
+}
+
+// recordVoiceprintConsent stores a consent change, deleting the voiceprint
+// when it is withdrawn. room is nil for admin changes.
+func recordVoiceprintConsent(room *RoomInfo, consent storage.Consent, subject string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+    defer cancel()
+    if err := store.Consents().Put(ctx, consent); err != nil {
+        log.Printf("Saving consent for %s failed: %v", consent.CustomerId, err)
+        return err
+    }
+    
+    action := "consent_granted"
+    detail := map[string]interface{}{
+        "customerId": consent.CustomerId,
+        "purpose":    consent.Purpose,
+    }
+    if !consent.Granted {
+        action = "consent_withdrawn"
+        if speakers != nil {
+            err := speakers.Delete(ctx, consent.Tenant, consent.CustomerId)
+            detail["voiceprintDeleted"] = err == nil
+            if err != nil {
+                speakerOperations.inc(nil, "delete", "error")
+                log.Printf("Deleting voiceprint of %s failed: %v", consent.CustomerId, err)
+            }
+        }
+    }
+    if room == nil {
+        room = &RoomInfo{Tenant: consent.Tenant}
+    }
+    audit(room, consent.RecordedBy, action, subject, "recorded", detail)
+    return nil
+}
+
+// speaker_enroll: {"userId": ID}, for a caller verified on this call
+func handleSpeakerEnroll(roomId string, sender *Client, msg *Message) {
+    if speakers == nil {
+        sendError(sender, ErrUnavailable, msg, "speaker recognition is not configured")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    room, user, err := verificationTarget(roomId, data)
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    go func() {
+        if err := enrollSpeaker(room, user, sender.clientId); err != nil {
+            sendError(sender, errorCode(err, ErrUnavailable), msg, "%v", err)
+        }
+    }()
+}
+
+// enrollSpeaker enrolls the caller's recent audio as the voiceprint of the
+// customer they verified as, provided the customer consented.
+func enrollSpeaker(room *RoomInfo, user *Client, actor string) error {
+    roomsMu.RLock()
+    var state callerVerification
+    if room.verifications[user.clientId] != nil {
+        state = *room.verifications[user.clientId]
+    }
+    roomsMu.RUnlock()
+    if !state.current() || state.CustomerId == "" {
+        return newCodedError(ErrNotPermitted, "%s must pass verification before enrolling a voiceprint", user.clientId)
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+    defer cancel()
+    if !voiceprintConsent(ctx, room.Tenant, state.CustomerId) {
+        return newCodedError(ErrNotPermitted, "%s has not consented to a voiceprint", state.CustomerId)
+    }
+    audio := voiceSampleOf(user)
+    if audio.Seconds() < float64(cfg.SpeakerEnrollSeconds) {
+        return newCodedError(ErrUnavailable, "%.1fs of caller audio heard, %ds needed to enroll", audio.Seconds(), cfg.SpeakerEnrollSeconds)
+    }
+    if err := speakers.Enroll(ctx, room.Tenant, state.CustomerId, audio); err != nil {
+        speakerOperations.inc(room.labels, "enroll", "error")
+        return newCodedError(ErrUnavailable, "enrollment failed: %v", err)
+    }
+    
+    speakerOperations.inc(room.labels, "enroll", "ok")
+    logAt("info", room.RoomId, user.clientId, "Enrolled voiceprint for %s", state.CustomerId)
+    audit(room, actor, "voiceprint_enrolled", user.clientId, "enrolled", map[string]interface{}{
+        "customerId": state.CustomerId,
+        "seconds":    audio.Seconds(),
+    })
+    sendToAgents(room.RoomId, nil, &Message{
+        Id:   newMessageId(),
+        Type: "speaker_enrolled",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "userId":     user.clientId,
+            "customerId": state.CustomerId,
+        },
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+    return nil
+}
+
+// GET|DELETE /admin/speakers/TENANT/CUSTOMER_ID (admin): a customer's
+// voiceprint consent. DELETE withdraws it and deletes the voiceprint.
+// _default is the tenant of rooms that have none.
+func handleSpeakers(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    tenant, customerId, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/speakers/"), "/")
+    if tenant == "" || customerId == "" {
+        http.Error(w, "Tenant and customer ID required", http.StatusBadRequest)
+        return
+    }
+    if tenant == defaultPhraseTenant {
+        tenant = ""
+    }
+    
+    switch r.Method {
+    case http.MethodGet:
+        consent, err := store.Consents().Get(r.Context(), tenant, customerId, voiceprintPurpose)
+        if err == storage.ErrNotFound {
+            http.Error(w, "No consent recorded", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(consent)
+    
+    case http.MethodDelete:
+        consent := storage.Consent{
+            Tenant:     tenant,
+            CustomerId: customerId,
+            Purpose:    voiceprintPurpose,
+            UpdatedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+            RecordedBy: "admin",
+        }
+        if err := recordVoiceprintConsent(nil, consent, customerId); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    
+    default:
+        http.Error(w, "Only GET and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
@@ -0,0 +1,112 @@
+package speaker
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// HTTP talks to a speaker recognition service over a small REST API:
+//
+//   POST /enroll  {"tenant", "speakerId", "encoding": "pcm16", "sampleRate", "audio": BASE64}
+//   POST /verify  same body, answers {"score": 0.93}, 404 when not enrolled
+//   POST /score   without speakerId, answers {"matches": [{"speakerId", "score"}, ...]}
+//   POST /delete  {"tenant", "speakerId"}
+//
+// Vendor SDKs sit behind a thin adapter exposing these four calls.
+type HTTP struct {
+    URL    string
+    APIKey string
+    Client *http.Client
+}
+
+func (h *HTTP) Name() string { return "http" }
+
+func body(tenant string, speakerId string, audio Audio) map[string]interface{} {
+    request := map[string]interface{}{
+        "tenant":     tenant,
+        "encoding":   "pcm16",
+        "sampleRate": audio.SampleRate,
+        "audio":      base64.StdEncoding.EncodeToString(audio.PCM),
+    }
+    if speakerId != "" {
+        request["speakerId"] = speakerId
+    }
+    return request
+}
+
+func (h *HTTP) Enroll(ctx context.Context, tenant string, speakerId string, audio Audio) error {
+    if err := h.post(ctx, "/enroll", body(tenant, speakerId, audio), nil); err != nil {
+        return fmt.Errorf("enroll: %v", err)
+    }
+    return nil
+}
+
+func (h *HTTP) Verify(ctx context.Context, tenant string, speakerId string, audio Audio) (float64, error) {
+    var response struct {
+        Score float64 `json:"score"`
+    }
+    if err := h.post(ctx, "/verify", body(tenant, speakerId, audio), &response); err != nil {
+        if err == ErrNotEnrolled {
+            return 0, err
+        }
+        return 0, fmt.Errorf("verify: %v", err)
+    }
+    return response.Score, nil
+}
+
+func (h *HTTP) Score(ctx context.Context, tenant string, audio Audio) ([]Match, error) {
+    var response struct {
+        Matches []Match `json:"matches"`
+    }
+    if err := h.post(ctx, "/score", body(tenant, "", audio), &response); err != nil {
+        return nil, fmt.Errorf("score: %v", err)
+    }
+    return response.Matches, nil
+}
+
+func (h *HTTP) Delete(ctx context.Context, tenant string, speakerId string) error {
+    err := h.post(ctx, "/delete", map[string]string{"tenant": tenant, "speakerId": speakerId}, nil)
+    if err != nil && err != ErrNotEnrolled {
+        return fmt.Errorf("delete: %v", err)
+    }
+    return nil
+}
+
+func (h *HTTP) post(ctx context.Context, path string, request interface{}, out interface{}) error {
+    data, _ := json.Marshal(request)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(h.URL, "/")+path, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if h.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+h.APIKey)
+    }
+    client := h.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    payload, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if resp.StatusCode == http.StatusNotFound {
+        return ErrNotEnrolled
+    }
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(payload)))
+    }
+    if out == nil {
+        return nil
+    }
+    return json.Unmarshal(payload, out)
+}
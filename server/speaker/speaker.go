@@ -0,0 +1,42 @@
+// Package speaker keeps voiceprints: enrolling a caller's voice, checking a
+// voice against the identity it claims and scoring it against everyone
+// enrolled, so returning callers can be recognized.
+package speaker
+
+import (
+    "context"
+    "errors"
+)
+
+var ErrNotEnrolled = errors.New("speaker: no voiceprint enrolled")
+
+// Audio is 16-bit mono PCM.
+type Audio struct {
+    PCM        []byte
+    SampleRate int
+}
+
+func (a Audio) Seconds() float64 {
+    if a.SampleRate <= 0 {
+        return 0
+    }
+    return float64(len(a.PCM)) / float64(a.SampleRate*2)
+}
+
+type Match struct {
+    SpeakerId string  `json:"speakerId"`
+    Score     float64 `json:"score"` // 0 to 1
+}
+
+// Provider is a speaker recognition service. Speaker IDs are scoped to a
+// tenant; the server uses the caller's customer ID.
+type Provider interface {
+    Name() string
+    Enroll(ctx context.Context, tenant string, speakerId string, audio Audio) error
+    // Verify scores audio against one speaker's voiceprint, ErrNotEnrolled
+    // when there is none.
+    Verify(ctx context.Context, tenant string, speakerId string, audio Audio) (float64, error)
+    // Score ranks the tenant's enrolled speakers against audio, best first.
+    Score(ctx context.Context, tenant string, audio Audio) ([]Match, error)
+    Delete(ctx context.Context, tenant string, speakerId string) error
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/tts"
+    "github.com/yourusername/my-go-project/watermark"
+)
+
+// Server side speech synthesis. An agent sends "speak" with text or an SSML
+// <speak> document, checked by tts.ValidateSSML and spoken as plain text by
+// providers without SSML, and the server synthesizes it with the room's
+// provider, voice, style and speed
+// and streams the pcm16 to the room's users as that agent's audio, framed
+// and paced like a live source. tts_started and tts_finished bracket each
+// utterance; a new speak or speak_stop interrupts the current one. Agents
+// streaming an LLM's reply use speak_stream instead (speechstream.go).
+
+var ttsProviders = make(map[string]tts.Provider)
+
+var (
+    ttsCharactersTotal = newCounterVec("iva_tts_characters_total", "Characters sent to text-to-speech providers.", "provider")
+    ttsCostTotal       = newCounterVec("iva_tts_cost_usd_total", "Estimated text-to-speech spend from -tts-prices.", "provider")
+    ttsErrorsTotal     = newCounterVec("iva_tts_errors_total", "Text-to-speech synthesis errors.", "provider")
+    ttsCacheHits       = newCounterVec("iva_tts_cache_hits_total", "Speak requests served from the TTS cache.", "provider")
+    ttsCacheMisses     = newCounterVec("iva_tts_cache_misses_total", "Speak requests synthesized by the provider.", "provider")
+    ttsLatency         = newHistogramVec("iva_tts_latency_seconds", "Time from a speak request to its first audio frame.",
+        []float64{0.1, 0.25, 0.5, 1, 2, 5}, "provider")
+)
+
+func init() {
+    metricSeries = append(metricSeries, ttsCharactersTotal, ttsCostTotal, ttsErrorsTotal, ttsCacheHits, ttsCacheMisses)
+}
+
+// checkPromptSSML validates a server prompt that is an SSML document.
+func checkPromptSSML(text string) error {
+    if tts.IsSSML(text) {
+        return tts.ValidateSSML(text)
+    }
+    return nil
+}
+
+// promptText is a server prompt as text clients show it.
+func promptText(text string) string {
+    if tts.IsSSML(text) {
+        return tts.StripSSML(text)
+    }
+    return text
+}
+
+// TTSSettings is how a room speaks, announced in the room config.
+type TTSSettings struct {
+    Provider string  `json:"provider"`
+    Voice    string  `json:"voice"`
+    Style    string  `json:"style,omitempty"`
+    Speed    float64 `json:"speed"`
+}
+
+func loadTTSProviders() error {
+    if cfg.AzureSpeechKey != "" {
+        ttsProviders["azure"] = &tts.Azure{Region: cfg.AzureSpeechRegion, APIKey: cfg.AzureSpeechKey}
+    }
+    if cfg.GoogleTTSAPIKey != "" {
+        ttsProviders["google"] = &tts.Google{APIKey: cfg.GoogleTTSAPIKey}
+    }
+    if cfg.PiperVoicesDir != "" {
+        ttsProviders["piper"] = &tts.Piper{Command: cfg.PiperCommand, VoicesDir: cfg.PiperVoicesDir}
+    }
+    
+    // Repeated prompts and stock phrases are synthesized once
+    var store tts.CacheStore
+    switch {
+    case cfg.TTSCache == "":
+        return nil
+    case cfg.TTSCache == "memory":
+        store = tts.NewMemoryCache(cfg.TTSCacheBytes)
+    case strings.HasPrefix(cfg.TTSCache, "redis://"):
+        redis, err := tts.NewRedisCache(cfg.TTSCache, cfg.TTSCacheTTL)
+        if err != nil {
+            return err
+        }
+        store = redis
+    default:
+        return fmt.Errorf("unknown -tts-cache %q", cfg.TTSCache)
+    }
+    for name, provider := range ttsProviders {
+        ttsProviders[name] = tts.Cached(provider, store, cfg.TTSCacheMaxEntry)
+    }
+    return nil
+}
+
+// ttsSettingsFor resolves the room template's overrides over the defaults.
+func ttsSettingsFor(room *RoomInfo) TTSSettings {
+    settings := TTSSettings{Provider: cfg.TTSProvider, Voice: ttsVoiceFor(room), Speed: 1}
+    if value, ok := lookupOverride(cfg.TTSTemplateProviders, room.Template); ok {
+        settings.Provider = value
+    }
+    if value, ok := lookupOverride(cfg.TTSStyles, room.Template); ok {
+        settings.Style = value
+    }
+    if value, ok := lookupOverride(cfg.TTSSpeeds, room.Template); ok {
+        if speed, err := strconv.ParseFloat(value, 64); err == nil && speed > 0 {
+            settings.Speed = speed
+        }
+    }
+    // An experiment's voice, see experiments.go
+    if voice := experimentVoice(room); voice != "" {
+        settings.Voice = voice
+    }
+    // On a latency budget, see latency.go
+    if overBudget(room) {
+        if cfg.LatencyTTSProvider != "" {
+            settings.Provider = cfg.LatencyTTSProvider
+        }
+        if cfg.LatencyTTSVoice != "" {
+            settings.Voice = cfg.LatencyTTSVoice
+        }
+    }
+    return settings
+}
+
+// ttsPrice is the configured USD per million characters for a provider.
+func ttsPrice(provider string) float64 {
+    value, _ := lookupOverride(cfg.TTSPrices, provider)
+    price, _ := strconv.ParseFloat(value, 64)
+    return price
+}
+
+func handleSpeak(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents can speak")
+        return
+    }
+    
+    // Interrupt whatever the agent is saying
+    sender.mu.Lock()
+    stopSpeaking(sender, nil)
+    sender.mu.Unlock()
+    if msg.Type == "speak_stop" {
+        return
+    }
+    
+    data, _ := msg.Data.(map[string]interface{})
+    req := tts.Request{}
+    if ssml, _ := data["ssml"].(string); ssml != "" {
+        req.Text, req.SSML = ssml, true
+    } else {
+        req.Text, _ = data["text"].(string)
+    }
+    if strings.TrimSpace(req.Text) == "" {
+        sendError(sender, ErrInvalidMessage, msg, "speak needs text or ssml")
+        return
+    }
+    if req.SSML {
+        if err := tts.ValidateSSML(req.Text); err != nil {
+            sendError(sender, ErrInvalidMessage, msg, "%v", err)
+            return
+        }
+    }
+    provider, req, ok := speechRequest(roomId, sender, msg, data, req)
+    if !ok {
+        return
+    }
+    
+    ctx, cancel := context.WithCancelCause(context.Background())
+    sender.mu.Lock()
+    sender.stopSpeech = cancel
+    sender.mu.Unlock()
+    go speak(ctx, roomId, sender, msg, provider, req)
+}
+
+// stopSpeaking interrupts the agent's speech, cause nil or errBargeIn.
+// Callers hold sender.mu.
+func stopSpeaking(sender *Client, cause error) {
+    if sender.stopSpeech != nil {
+        sender.stopSpeech(cause)
+        sender.stopSpeech = nil
+    }
+}
+
+// speechRequest fills in the room's provider, voice, style and speed and the
+// message's overrides of them, reporting errors to the sender.
+func speechRequest(roomId string, sender *Client, msg *Message, data map[string]interface{}, req tts.Request) (tts.Provider, tts.Request, bool) {
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return nil, req, false
+    }
+    settings := ttsSettingsFor(room)
+    if voice, _ := data["voice"].(string); voice != "" {
+        settings.Voice = voice
+    }
+    if style, _ := data["style"].(string); style != "" {
+        settings.Style = style
+    }
+    if speed, _ := data["speed"].(float64); speed > 0 {
+        settings.Speed = speed
+    }
+    provider := ttsProviders[settings.Provider]
+    if provider == nil {
+        sendError(sender, ErrUnavailable, msg, "no text-to-speech provider configured for this room")
+        return nil, req, false
+    }
+    req.SampleRate = cfg.TTSSampleRate
+    req.Voice, req.Style, req.Speed = settings.Voice, settings.Style, settings.Speed
+    if circuit := breakerFor("tts", provider.Name()); !circuit.Allow() {
+        sendError(sender, ErrUnavailable, msg, "text-to-speech is unavailable")
+        integrationFailed(room, "tts", circuit.Name+" circuit open")
+        return nil, req, false
+    }
+    return provider, req, true
+}
+
+func speak(ctx context.Context, roomId string, sender *Client, msg *Message, provider tts.Provider, req tts.Request) {
+    speech := synthesize(ctx, roomId, sender, msg, provider, req)
+    if speech == nil {
+        return
+    }
+    playSpeech(ctx, roomId, sender, msg, speech, req.Voice)
+}
+
+// playSpeech plays one utterance between its tts_started and tts_finished.
+func playSpeech(ctx context.Context, roomId string, sender *Client, msg *Message, speech *utterance, voice string) {
+    defer speech.Body.Close()
+    announce := speechAnnouncer(roomId, sender, msg, speech.provider, voice)
+    announce("tts_started", map[string]interface{}{"format": "pcm16", "sampleRate": speech.SampleRate, "cached": speech.Cached})
+    player := newSpeechPlayer(roomId)
+    interrupted := player.play(ctx, roomId, sender, speech)
+    announce("tts_finished", player.finished(ctx, interrupted))
+}
+
+// utterance is synthesized audio on its way to the room.
+type utterance struct {
+    *tts.Audio
+    provider  string
+    requested time.Time
+}
+
+// synthesize asks the provider for the speech and keeps the TTS metrics, nil
+// when it failed or was interrupted.
+func synthesize(ctx context.Context, roomId string, sender *Client, msg *Message, provider tts.Provider, req tts.Request) *utterance {
+    name := provider.Name()
+    circuit := breakerFor("tts", name)
+    requested := time.Now()
+    if room, _, _ := roomMembers(roomId); room != nil {
+        req = pronounced(room.Tenant, provider, req)
+    }
+    audio, err := provider.Synthesize(ctx, req)
+    if err != nil {
+        if ctx.Err() == nil {
+            ttsErrorsTotal.inc(sender.labels, name)
+            logAt("warn", roomId, sender.clientId, "Speech synthesis failed: %v", err)
+            sendError(sender, ErrUnavailable, msg, "speech synthesis failed")
+            if room, _, _ := roomMembers(roomId); room != nil {
+                integrationError(room, "tts", circuit, err, false)
+            }
+        }
+        return nil
+    }
+    
+    // Cache hits cost nothing, and say nothing about the provider
+    if audio.Cached {
+        ttsCacheHits.inc(sender.labels, name)
+    } else {
+        ttsCacheMisses.inc(sender.labels, name)
+        circuit.Success()
+        ttsCharactersTotal.add(float64(len(req.Text)), sender.labels, name)
+        if price := ttsPrice(name); price > 0 {
+            ttsCostTotal.add(float64(len(req.Text))/1e6*price, sender.labels, name)
+            addSpend(roomId, spendTTS, float64(len(req.Text))/1e6*price)
+        }
+    }
+    return &utterance{Audio: audio, provider: name, requested: requested}
+}
+
+// speechAnnouncer sends the room an utterance's tts_started and tts_finished.
+func speechAnnouncer(roomId string, sender *Client, msg *Message, provider string, voice string) func(string, map[string]interface{}) {
+    return func(msgType string, extra map[string]interface{}) {
+        data := map[string]interface{}{
+            "requestId": msg.Id,
+            "clientId":  sender.clientId,
+            "provider":  provider,
+            "voice":     voice,
+        }
+        for k, v := range extra {
+            data[k] = v
+        }
+        broadcastToRoom(roomId, nil, &Message{
+            Type:      msgType,
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+    }
+}
+
+// speechPlayer streams synthesized speech to the room's users as the agent's
+// audio, one utterance or several back to back.
+type speechPlayer struct {
+    frameMs int
+    sent    int // Frames played, over every utterance
+    mark    *watermark.Embedder
+}
+
+func newSpeechPlayer(roomId string) *speechPlayer {
+    frameMs := cfg.AudioFrameMs
+    if frameMs <= 0 {
+        frameMs = 20
+    }
+    return &speechPlayer{frameMs: frameMs, mark: callWatermark(roomId, true)}
+}
+
+// play streams one utterance, reporting whether it was interrupted.
+func (p *speechPlayer) play(ctx context.Context, roomId string, sender *Client, speech *utterance) bool {
+    frame := make([]byte, speech.SampleRate*2*p.frameMs/1000)
+    interval := time.Duration(p.frameMs) * time.Millisecond
+    start := time.Time{}
+    sent := 0
+    
+    for {
+        n, err := io.ReadFull(speech.Body, frame)
+        if n > 0 {
+            if start.IsZero() {
+                start = time.Now()
+                ttsLatency.observe(start.Sub(speech.requested).Seconds(), sender.labels, speech.provider)
+            }
+            // Real time pace from the first frame, so slow reads don't drift
+            if wait := time.Until(start.Add(time.Duration(sent) * interval)); wait > 0 {
+                select {
+                case <-ctx.Done():
+                case <-time.After(wait):
+                }
+            }
+            if ctx.Err() != nil {
+                return true
+            }
+            chunk := append([]byte(nil), frame[:n]...)
+            if p.mark != nil {
+                chunk = p.mark.Embed(chunk)
+            }
+            noteAgentAudio(roomId)
+            forwardAudioToUsers(roomId, sender.clientId, chunk, false)
+            sent++
+            p.sent++
+        }
+        if err != nil {
+            if err != io.EOF && err != io.ErrUnexpectedEOF {
+                if ctx.Err() != nil {
+                    return true
+                }
+                ttsErrorsTotal.inc(sender.labels, speech.provider)
+                logAt("warn", roomId, sender.clientId, "Speech stream failed: %v", err)
+                if room, _, _ := roomMembers(roomId); room != nil {
+                    integrationError(room, "tts", breakerFor("tts", speech.provider), err, false)
+                }
+            }
+            return false
+        }
+    }
+}
+
+// finished is tts_finished's account of what was played.
+func (p *speechPlayer) finished(ctx context.Context, interrupted bool) map[string]interface{} {
+    detail := map[string]interface{}{
+        "interrupted": interrupted,
+        "durationMs":  p.sent * p.frameMs,
+    }
+    if interrupted && context.Cause(ctx) == errBargeIn {
+        detail["reason"] = "barge_in"
+    }
+    return detail
+}
+
+type voiceCatalog struct {
+    fetched time.Time
+    voices  []tts.Voice
+}
+
+var (
+    voiceCatalogs   = make(map[string]voiceCatalog)
+    voiceCatalogsMu sync.Mutex
+)
+
+// voicesFor returns a provider's catalog, cached for -tts-voices-ttl.
+func voicesFor(ctx context.Context, provider tts.Provider) ([]tts.Voice, error) {
+    voiceCatalogsMu.Lock()
+    catalog, ok := voiceCatalogs[provider.Name()]
+    voiceCatalogsMu.Unlock()
+    if ok && time.Since(catalog.fetched) < cfg.TTSVoicesTTL {
+        return catalog.voices, nil
+    }
+    
+    voices, err := provider.Voices(ctx)
+    if err != nil {
+        return nil, err
+    }
+    voiceCatalogsMu.Lock()
+    voiceCatalogs[provider.Name()] = voiceCatalog{fetched: time.Now(), voices: voices}
+    voiceCatalogsMu.Unlock()
+    return voices, nil
+}
+
+// GET /voices[?provider=NAME&language=PREFIX]
+func handleVoices(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    
+    only := r.URL.Query().Get("provider")
+    language := strings.ToLower(r.URL.Query().Get("language"))
+    voices := make([]tts.Voice, 0)
+    failed := make(map[string]string)
+    for name, provider := range ttsProviders {
+        if only != "" && name != only {
+            continue
+        }
+        list, err := voicesFor(r.Context(), provider)
+        if err != nil {
+            failed[name] = err.Error()
+            continue
+        }
+        for _, voice := range list {
+            if language == "" || strings.HasPrefix(strings.ToLower(voice.Language), language) {
+                voices = append(voices, voice)
+            }
+        }
+    }
+    
+    response := map[string]interface{}{"voices": voices}
+    if len(failed) > 0 {
+        response["errors"] = failed
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/tts"
+)
+
+// Streamed speech, for agents whose LLM streams its answer. Rather than wait
+// for the whole reply and speak it, the agent forwards the text as it comes:
+//
+//   {"type": "speak_stream", "data": {"streamId": "r-17", "text": "Sure. Your balance", "final": false}}
+//
+// and the server speaks it a sentence at a time, the first as soon as it is
+// complete, synthesizing each next sentence while the one before plays. The
+// room hears one tts_started and tts_finished for the stream, with its
+// streamId. The first chunk of a new streamId interrupts the agent's current
+// speech like speak does, and the stream ends at final, speak_stop, or when
+// nothing arrives for streamIdle. speak's voice, style and speed go on the
+// first chunk.
+//
+// With -barge-in-time set, a caller speaking over the agent for that long
+// interrupts it as well, and tts_finished says "reason": "barge_in". Chunks
+// the agent streams after an interruption are dropped.
+
+// The cause of speech the caller talked over.
+var errBargeIn = errors.New("barge-in")
+
+// A stream the agent stops feeding is spoken up to here after this long.
+const streamIdle = 30 * time.Second
+
+// Sentences are cut at a comma or space past this many bytes, so a run-on
+// reply does not hold up the audio.
+const maxSentence = 200
+
+// A quiet spell this long between caller frames starts a new barge-in run.
+const bargeInGapMs = 100
+
+type speechStream struct {
+    id        string
+    msg       *Message // First chunk, the stream's requestId
+    mu        sync.Mutex
+    text      strings.Builder // All of it, for history
+    pending   string          // Text short of a whole sentence
+    sentences []string        // Whole sentences not yet synthesized
+    final     bool
+    wake      chan struct{}
+}
+
+// speak_stream: {"streamId": ID, "text": DELTA, "final": BOOL}
+func handleSpeakStream(roomId string, sender *Client, msg *Message) {
+    if sender.clientType != ClientTypeAgent {
+        sendError(sender, ErrNotPermitted, msg, "only agents can speak")
+        return
+    }
+    data, _ := msg.Data.(map[string]interface{})
+    streamId, _ := data["streamId"].(string)
+    text, _ := data["text"].(string)
+    final, _ := data["final"].(bool)
+    if streamId == "" {
+        sendError(sender, ErrInvalidMessage, msg, "speak_stream needs a streamId")
+        return
+    }
+    
+    sender.mu.Lock()
+    stream := sender.speechStream
+    sender.mu.Unlock()
+    if stream != nil && stream.id == streamId {
+        if !stream.add(text, final) {
+            sendError(sender, ErrInvalidMessage, msg, "speak_stream %s has ended", streamId)
+        }
+        return
+    }
+    
+    provider, req, ok := speechRequest(roomId, sender, msg, data, tts.Request{})
+    if !ok {
+        return
+    }
+    stream = &speechStream{id: streamId, msg: msg, wake: make(chan struct{}, 1)}
+    stream.add(text, final)
+    ctx, cancel := context.WithCancelCause(context.Background())
+    sender.mu.Lock()
+    stopSpeaking(sender, nil)
+    sender.stopSpeech = cancel
+    sender.speechStream = stream
+    sender.mu.Unlock()
+    go stream.speak(ctx, roomId, sender, provider, req)
+}
+
+// add takes the next chunk of text, false if the stream had already ended.
+func (s *speechStream) add(text string, final bool) bool {
+    s.mu.Lock()
+    if s.final {
+        s.mu.Unlock()
+        return false
+    }
+    s.text.WriteString(text)
+    s.pending += text
+    for {
+        end := sentenceEnd(s.pending)
+        if end == 0 {
+            break
+        }
+        s.queue(s.pending[:end])
+        s.pending = s.pending[end:]
+    }
+    if final {
+        s.queue(s.pending)
+        s.pending = ""
+        s.final = true
+    }
+    s.mu.Unlock()
+    
+    select {
+    case s.wake <- struct{}{}:
+    default:
+    }
+    return true
+}
+
+// queue adds a sentence to synthesize. Callers hold s.mu.
+func (s *speechStream) queue(sentence string) {
+    if sentence = strings.TrimSpace(sentence); sentence != "" {
+        s.sentences = append(s.sentences, sentence)
+    }
+}
+
+// next waits for the next whole sentence, false once there are no more.
+func (s *speechStream) next(ctx context.Context) (string, bool) {
+    for {
+        s.mu.Lock()
+        if len(s.sentences) > 0 {
+            sentence := s.sentences[0]
+            s.sentences = s.sentences[1:]
+            s.mu.Unlock()
+            return sentence, true
+        }
+        if s.final {
+            s.mu.Unlock()
+            return "", false
+        }
+        s.mu.Unlock()
+        
+        select {
+        case <-s.wake:
+        case <-ctx.Done():
+            return "", false
+        case <-time.After(streamIdle):
+            s.mu.Lock()
+            if !s.final {
+                s.queue(s.pending)
+                s.pending = ""
+                s.final = true
+            }
+            s.mu.Unlock()
+        }
+    }
+}
+
+// speak plays the stream's sentences as they are synthesized, one sentence
+// ahead of what the room hears.
+func (s *speechStream) speak(ctx context.Context, roomId string, sender *Client, provider tts.Provider, req tts.Request) {
+    utterances := make(chan *utterance) // Unbuffered, so only one sentence waits
+    go func() {
+        defer close(utterances)
+        for {
+            text, ok := s.next(ctx)
+            if !ok {
+                return
+            }
+            sentence := req
+            sentence.Text = text
+            speech := synthesize(ctx, roomId, sender, s.msg, provider, sentence)
+            if speech == nil {
+                if ctx.Err() != nil {
+                    return
+                }
+                continue
+            }
+            select {
+            case utterances <- speech:
+            case <-ctx.Done():
+                speech.Body.Close()
+                return
+            }
+        }
+    }()
+    
+    announce := speechAnnouncer(roomId, sender, s.msg, provider.Name(), req.Voice)
+    player := newSpeechPlayer(roomId)
+    started, interrupted := false, false
+    for speech := range utterances {
+        if !started {
+            announce("tts_started", map[string]interface{}{"format": "pcm16", "sampleRate": speech.SampleRate, "cached": speech.Cached, "streamId": s.id})
+            started = true
+        }
+        if !interrupted {
+            interrupted = player.play(ctx, roomId, sender, speech)
+        }
+        speech.Body.Close()
+    }
+    if started {
+        finished := player.finished(ctx, interrupted)
+        finished["streamId"] = s.id
+        announce("tts_finished", finished)
+    }
+    
+    // History has the reply once, as if it had been a speak
+    s.mu.Lock()
+    text := s.text.String()
+    s.mu.Unlock()
+    if strings.TrimSpace(text) == "" {
+        return
+    }
+    spoken := &Message{
+        Id:        s.msg.Id,
+        Type:      "speak",
+        From:      sender.clientId,
+        Data:      map[string]interface{}{"text": text, "streamId": s.id, "interrupted": interrupted},
+        Timestamp: s.msg.Timestamp,
+    }
+    recordHistory(roomId, spoken)
+    observeForAnalytics(roomId, sender, spoken)
+}
+
+// Words ending in a period that rarely end a sentence.
+var abbreviations = map[string]bool{
+    "mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "jr": true, "sr": true,
+    "vs": true, "etc": true, "e.g": true, "i.e": true, "no": true, "approx": true,
+}
+
+// sentenceEnd is where text's first whole sentence ends, 0 if it has none
+// yet. A sentence needs the space after its final punctuation, as the next
+// chunk may carry on "3." into "3.5".
+func sentenceEnd(text string) int {
+    for i := 0; i < len(text); i++ {
+        switch text[i] {
+        case '\n':
+            return i + 1
+        case '.', '!', '?':
+            end := i + 1
+            for end < len(text) && strings.IndexByte(`"')]`, text[end]) >= 0 {
+                end++
+            }
+            if end == len(text) || (text[end] != ' ' && text[end] != '\t' && text[end] != '\n') {
+                continue
+            }
+            if text[i] == '.' {
+                word := text[:i]
+                if space := strings.LastIndexAny(word, " \t\n"); space >= 0 {
+                    word = word[space+1:]
+                }
+                if abbreviations[strings.ToLower(word)] {
+                    continue
+                }
+            }
+            return end
+        }
+        if i >= maxSentence {
+            cut := strings.LastIndexAny(text[:i], ",;")
+            if cut < maxSentence/2 {
+                cut = strings.LastIndexByte(text[:i], ' ')
+            }
+            if cut <= 0 {
+                return i
+            }
+            return cut + 1
+        }
+    }
+    return 0
+}
+
+// bargeIn interrupts the speech of the room's agents, the caller having
+// spoken over it.
+func bargeIn(roomId string, agents []*Client) {
+    for _, agent := range agents {
+        agent.mu.Lock()
+        speaking := agent.stopSpeech != nil
+        stopSpeaking(agent, errBargeIn)
+        agent.mu.Unlock()
+        if speaking {
+            logAt("info", roomId, agent.clientId, "Caller barged in, speech interrupted")
+        }
+    }
+}
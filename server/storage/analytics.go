@@ -0,0 +1,110 @@
+package storage
+
+import "context"
+
+// Rollup is one row of the hourly or daily analytics tables: the calls of a
+// tenant, template and experiment variants that started within the bucket.
+// Counters are additive so partial rollups merge by summing.
+type Rollup struct {
+    Granularity     string           `json:"granularity"` // hour or day
+    BucketStart     int64            `json:"bucketStart"` // Unix milliseconds, UTC aligned
+    Tenant          string           `json:"tenant"`
+    Template        string           `json:"template"`
+    Variant         string           `json:"variant,omitempty"` // The calls' experiment variants, "experiment=variant,..." in order
+    Calls           int64            `json:"calls"`
+    DurationMs      int64            `json:"durationMs"`
+    MaxDurationMs   int64            `json:"maxDurationMs"`
+    Intents         map[string]int64 `json:"intents,omitempty"`
+    SentimentSum    float64          `json:"sentimentSum"`
+    SentimentCount  int64            `json:"sentimentCount"`
+    FirstResponseMs int64            `json:"firstResponseMs"` // Summed over Responded calls
+    Responded       int64            `json:"responded"`
+    SLAMet          int64            `json:"slaMet"`
+    SLAMissed       int64            `json:"slaMissed"`
+    Profanity       int64            `json:"profanity"`    // Profane words in chat and transcripts
+    ProfaneCalls    int64            `json:"profaneCalls"` // Calls with any
+    Scored          int64            `json:"scored"`       // Calls graded after they ended, see CDRQuality
+    ResolutionSum   float64          `json:"resolutionSum"`
+    AccuracySum     float64          `json:"accuracySum"`
+    AccuracyCount   int64            `json:"accuracyCount"` // Scored calls that cited the knowledge graph
+    ToneSum         float64          `json:"toneSum"`
+    OverallSum      float64          `json:"overallSum"`
+    LowQuality      int64            `json:"lowQuality"` // Scored under the low quality threshold
+}
+
+// Merge adds other's counters into r.
+func (r *Rollup) Merge(other Rollup) {
+    r.Calls += other.Calls
+    r.DurationMs += other.DurationMs
+    if other.MaxDurationMs > r.MaxDurationMs {
+        r.MaxDurationMs = other.MaxDurationMs
+    }
+    for intent, n := range other.Intents {
+        if r.Intents == nil {
+            r.Intents = make(map[string]int64)
+        }
+        r.Intents[intent] += n
+    }
+    r.SentimentSum += other.SentimentSum
+    r.SentimentCount += other.SentimentCount
+    r.FirstResponseMs += other.FirstResponseMs
+    r.Responded += other.Responded
+    r.SLAMet += other.SLAMet
+    r.SLAMissed += other.SLAMissed
+    r.Profanity += other.Profanity
+    r.ProfaneCalls += other.ProfaneCalls
+    r.Scored += other.Scored
+    r.ResolutionSum += other.ResolutionSum
+    r.AccuracySum += other.AccuracySum
+    r.AccuracyCount += other.AccuracyCount
+    r.ToneSum += other.ToneSum
+    r.OverallSum += other.OverallSum
+    r.LowQuality += other.LowQuality
+}
+
+// AgentRollup is one agent's share of a bucket: the calls they took that
+// started within it. Wrap-up time arrives after the call and merges in on
+// its own.
+type AgentRollup struct {
+    Granularity    string  `json:"granularity"`
+    BucketStart    int64   `json:"bucketStart"`
+    Tenant         string  `json:"tenant"`
+    AgentId        string  `json:"agentId"`
+    Calls          int64   `json:"calls"`
+    HandleMs       int64   `json:"handleMs"`  // Time in the calls
+    WrapUpMs       int64   `json:"wrapUpMs"`  // Time after them until the disposition
+    Words          int64   `json:"words"`     // Said or written by the agent
+    CallWords      int64   `json:"callWords"` // By everyone in those calls
+    SentimentSum   float64 `json:"sentimentSum"`
+    SentimentCount int64   `json:"sentimentCount"`
+    HandedOff      int64   `json:"handedOff"` // Calls another agent took over
+    TookOver       int64   `json:"tookOver"`  // Calls taken over from another agent
+}
+
+// Merge adds other's counters into r.
+func (r *AgentRollup) Merge(other AgentRollup) {
+    r.Calls += other.Calls
+    r.HandleMs += other.HandleMs
+    r.WrapUpMs += other.WrapUpMs
+    r.Words += other.Words
+    r.CallWords += other.CallWords
+    r.SentimentSum += other.SentimentSum
+    r.SentimentCount += other.SentimentCount
+    r.HandedOff += other.HandedOff
+    r.TookOver += other.TookOver
+}
+
+type AnalyticsStore interface {
+    // Add merges delta into the stored row for its bucket, tenant, template
+    // and variant.
+    Add(ctx context.Context, delta Rollup) error
+    // Query returns rows of one granularity with buckets in [from, to),
+    // limited to a tenant unless it is empty.
+    Query(ctx context.Context, granularity string, from int64, to int64, tenant string) ([]Rollup, error)
+    // AddAgent merges delta into the stored row for its bucket, tenant and
+    // agent.
+    AddAgent(ctx context.Context, delta AgentRollup) error
+    // QueryAgents is Query for agent rows, limited to an agent unless it is
+    // empty.
+    QueryAgents(ctx context.Context, granularity string, from int64, to int64, tenant string, agentId string) ([]AgentRollup, error)
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+)
+
+// AuditEntry records a security relevant decision: who did what to whom and
+// how it turned out, e.g. a caller verification or a gated tool call.
+type AuditEntry struct {
+    Id      string          `json:"id"`
+    At      int64           `json:"at"` // Unix milliseconds
+    Tenant  string          `json:"tenant,omitempty"`
+    RoomId  string          `json:"roomId,omitempty"`
+    Actor   string          `json:"actor"`             // Client ID that asked, or "system"
+    Action  string          `json:"action"`            // e.g. verification_passed, tool_denied
+    Subject string          `json:"subject,omitempty"` // Client or customer acted on
+    Outcome string          `json:"outcome"`
+    Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// AuditFilter narrows List. Empty fields match everything; Until zero means
+// no upper bound.
+type AuditFilter struct {
+    Tenant  string
+    RoomId  string
+    Action  string
+    Subject string
+    Since   int64
+    Until   int64
+    Limit   int
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+    return (f.Tenant == "" || e.Tenant == f.Tenant) &&
+        (f.RoomId == "" || e.RoomId == f.RoomId) &&
+        (f.Action == "" || e.Action == f.Action) &&
+        (f.Subject == "" || e.Subject == f.Subject) &&
+        e.At >= f.Since && (f.Until == 0 || e.At < f.Until)
+}
+
+// AuditStore is append only.
+type AuditStore interface {
+    Add(ctx context.Context, entry AuditEntry) error
+    // List returns matching entries oldest first, at most Limit of them.
+    List(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
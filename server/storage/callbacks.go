@@ -0,0 +1,72 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+)
+
+// Callback statuses. A pending callback is claimed by an agent or dialer,
+// which reports it completed, or failed to be retried until it runs out of
+// attempts.
+const (
+    CallbackPending   = "pending"
+    CallbackClaimed   = "claimed"
+    CallbackCompleted = "completed"
+    CallbackFailed    = "failed"
+    CallbackCancelled = "cancelled"
+)
+
+// Callback is a promise to call a customer back, captured when they hung up
+// waiting or an outage ended their call.
+type Callback struct {
+    Id           string          `json:"id"`
+    Tenant       string          `json:"tenant,omitempty"`
+    RoomId       string          `json:"roomId,omitempty"`
+    UserId       string          `json:"userId,omitempty"`
+    CustomerId   string          `json:"customerId,omitempty"`
+    Phone        string          `json:"phone,omitempty"`
+    Reason       string          `json:"reason"`            // abandoned, outage or requested
+    Detail       string          `json:"detail,omitempty"`  // e.g. the failed integration
+    Context      json.RawMessage `json:"context,omitempty"` // Snapshot of the call when it was captured
+    Status       string          `json:"status"`
+    Attempts     int             `json:"attempts"`
+    ClaimedBy    string          `json:"claimedBy,omitempty"`
+    ClaimedUntil int64           `json:"claimedUntil,omitempty"` // Lease, the callback is claimable again after
+    NotBefore    int64           `json:"notBefore,omitempty"`    // Not claimable earlier, e.g. a retry
+    Outcome      string          `json:"outcome,omitempty"`
+    CreatedAt    int64           `json:"createdAt"` // Unix milliseconds
+    UpdatedAt    int64           `json:"updatedAt"`
+}
+
+// claimable reports whether a callback can be handed out at now.
+func (c Callback) claimable(now int64) bool {
+    return (c.Status == CallbackPending && c.NotBefore <= now) ||
+        (c.Status == CallbackClaimed && c.ClaimedUntil < now)
+}
+
+// CallbackFilter narrows List. Empty fields match everything.
+type CallbackFilter struct {
+    Tenant     string
+    Status     string
+    CustomerId string
+    Limit      int
+}
+
+func (f CallbackFilter) match(c Callback) bool {
+    return (f.Tenant == "" || c.Tenant == f.Tenant) &&
+        (f.Status == "" || c.Status == f.Status) &&
+        (f.CustomerId == "" || c.CustomerId == f.CustomerId)
+}
+
+type CallbackStore interface {
+    Add(ctx context.Context, callback Callback) error
+    Get(ctx context.Context, id string) (Callback, error)
+    // List returns matching callbacks oldest first, at most Limit of them.
+    List(ctx context.Context, filter CallbackFilter) ([]Callback, error)
+    // Claim leases the oldest claimable callback of a tenant ("" for any) to
+    // worker until leaseUntil, counting an attempt. ErrNotFound when none is
+    // due.
+    Claim(ctx context.Context, tenant string, worker string, now int64, leaseUntil int64) (Callback, error)
+    // Update writes the status, claim, schedule and outcome fields.
+    Update(ctx context.Context, callback Callback) error
+}
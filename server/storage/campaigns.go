@@ -0,0 +1,88 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+)
+
+// Campaign statuses. Only running campaigns dial.
+const (
+    CampaignDraft     = "draft"
+    CampaignRunning   = "running"
+    CampaignPaused    = "paused"
+    CampaignCompleted = "completed"
+    CampaignCancelled = "cancelled"
+)
+
+// Target statuses. A pending target is dialled, connected once answered and
+// done when its call ended for good; Outcome says how.
+const (
+    TargetPending   = "pending"
+    TargetDialing   = "dialing"
+    TargetConnected = "connected"
+    TargetDone      = "done"
+)
+
+// Campaign is an outbound dialing run over a list of targets.
+type Campaign struct {
+    Id                string  `json:"id"`
+    Tenant            string  `json:"tenant,omitempty"`
+    Name              string  `json:"name"`
+    Template          string  `json:"template,omitempty"` // Flow the virtual agent runs
+    Greeting          string  `json:"greeting,omitempty"` // Opening line, instead of the agent's usual greeting
+    From              string  `json:"from,omitempty"`     // Caller ID
+    CallsPerMinute    float64 `json:"callsPerMinute"`
+    MaxConcurrent     int     `json:"maxConcurrent"`
+    MaxAttempts       int     `json:"maxAttempts"`
+    RetryDelaySeconds int     `json:"retryDelaySeconds"`
+    OnMachine         string  `json:"onMachine"`           // hangup or message
+    Message           string  `json:"message,omitempty"`   // Left on answering machines
+    Callbacks         bool    `json:"callbacks,omitempty"` // Also work through the tenant's callback queue
+    Status            string  `json:"status"`
+    CreatedAt         int64   `json:"createdAt"` // Unix milliseconds
+    UpdatedAt         int64   `json:"updatedAt"`
+}
+
+type CampaignTarget struct {
+    Id         string          `json:"id"`
+    CampaignId string          `json:"campaignId"`
+    Phone      string          `json:"phone"`
+    CustomerId string          `json:"customerId,omitempty"`
+    Metadata   json.RawMessage `json:"metadata,omitempty"`   // Passed to the call's room
+    CallbackId string          `json:"callbackId,omitempty"` // Set when the target came from the callback queue
+    Status     string          `json:"status"`
+    Attempts   int             `json:"attempts"`
+    NotBefore  int64           `json:"notBefore,omitempty"` // Not dialled earlier, e.g. a retry
+    RoomId     string          `json:"roomId,omitempty"`    // Of the last attempt
+    Outcome    string          `json:"outcome,omitempty"`   // Of the last attempt: answered, machine, busy, no_answer or failed
+    Detail     string          `json:"detail,omitempty"`
+    Duration   int64           `json:"duration,omitempty"` // Milliseconds connected
+    CreatedAt  int64           `json:"createdAt"`
+    UpdatedAt  int64           `json:"updatedAt"`
+}
+
+// due reports whether a target can be dialled at now.
+func (t CampaignTarget) due(now int64) bool {
+    return t.Status == TargetPending && t.NotBefore <= now
+}
+
+type CampaignStore interface {
+    Add(ctx context.Context, campaign Campaign) error
+    Get(ctx context.Context, id string) (Campaign, error)
+    // List returns a tenant's campaigns ("" for all), newest first.
+    List(ctx context.Context, tenant string) ([]Campaign, error)
+    // Update writes the status and settings, not the targets.
+    Update(ctx context.Context, campaign Campaign) error
+    
+    AddTargets(ctx context.Context, targets []CampaignTarget) error
+    // Targets lists a campaign's targets in order, optionally of one status,
+    // at most limit (0 for all).
+    Targets(ctx context.Context, campaignId string, status string, limit int) ([]CampaignTarget, error)
+    // Tally counts a campaign's targets by status, done ones by outcome.
+    Tally(ctx context.Context, campaignId string) (map[string]int, error)
+    // NextTarget marks the first due target dialing, counting an attempt.
+    // ErrNotFound when none is due.
+    NextTarget(ctx context.Context, campaignId string, now int64) (CampaignTarget, error)
+    // UpdateTarget writes the status, schedule and outcome fields.
+    UpdateTarget(ctx context.Context, target CampaignTarget) error
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "context"
+
+// Consent is a customer's answer to one use of their data, e.g. "voiceprint"
+// for speaker recognition. Withdrawn consent is kept, not deleted, so it
+// shows when and how it was withdrawn.
+type Consent struct {
+    Tenant     string `json:"tenant"`
+    CustomerId string `json:"customerId"`
+    Purpose    string `json:"purpose"`
+    Granted    bool   `json:"granted"`
+    UpdatedAt  int64  `json:"updatedAt"` // Unix milliseconds
+    RoomId     string `json:"roomId,omitempty"`     // Call it was given or withdrawn on
+    RecordedBy string `json:"recordedBy,omitempty"` // Client ID, or "admin"
+}
+
+type ConsentStore interface {
+    // Get returns ErrNotFound when the customer was never asked.
+    Get(ctx context.Context, tenant string, customerId string, purpose string) (Consent, error)
+    Put(ctx context.Context, consent Consent) error
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+)
+
+// Knowledge gap statuses. An open gap is reviewed by a curator, who approves
+// it with an answer that becomes an FAQ entry, or dismisses it.
+const (
+    GapOpen      = "open"
+    GapApproved  = "approved"
+    GapDismissed = "dismissed"
+)
+
+// KnowledgeGap is a question the virtual agent could not ground in the
+// knowledge graph or FAQ. Asking it again adds an occurrence to the open gap
+// rather than a new one.
+type KnowledgeGap struct {
+    Id          string          `json:"id"`
+    Tenant      string          `json:"tenant,omitempty"`
+    Template    string          `json:"template,omitempty"`
+    Key         string          `json:"key"`               // Normalized question, unique among a tenant's open gaps
+    Question    string          `json:"question"`          // As first asked
+    Answer      string          `json:"answer,omitempty"`  // What the agent said instead
+    RoomId      string          `json:"roomId,omitempty"`  // Where it was last asked
+    MessageId   string          `json:"messageId,omitempty"`
+    Context     json.RawMessage `json:"context,omitempty"` // The conversation leading up to it
+    Occurrences int             `json:"occurrences"`
+    Status      string          `json:"status"`
+    ReviewedBy  string          `json:"reviewedBy,omitempty"`
+    Note        string          `json:"note,omitempty"`
+    FAQId       string          `json:"faqId,omitempty"` // The entry an approval created
+    CreatedAt   int64           `json:"createdAt"`       // Unix milliseconds
+    UpdatedAt   int64           `json:"updatedAt"`
+}
+
+// GapFilter narrows ListGaps. Empty fields match everything.
+type GapFilter struct {
+    Tenant string
+    Status string
+    Limit  int
+}
+
+func (f GapFilter) match(g KnowledgeGap) bool {
+    return (f.Tenant == "" || g.Tenant == f.Tenant) && (f.Status == "" || g.Status == f.Status)
+}
+
+// FAQEntry is a curated question and answer the agent can ground answers in,
+// cited as {"type": "faq", "id": ID}.
+type FAQEntry struct {
+    Id        string `json:"id"`
+    Tenant    string `json:"tenant,omitempty"`
+    Question  string `json:"question"`
+    Answer    string `json:"answer"`
+    GapId     string `json:"gapId,omitempty"` // The gap it closed
+    CreatedBy string `json:"createdBy,omitempty"`
+    CreatedAt int64  `json:"createdAt"`
+}
+
+// Fact statuses. Facts extracted from conversations that are confident and
+// low impact are approved on arrival, the rest wait in the pending set for a
+// reviewer.
+const (
+    FactPending  = "pending"
+    FactApproved = "approved"
+    FactRejected = "rejected"
+)
+
+// KnowledgeFact is a change to the knowledge graph extracted from a
+// conversation: Subject's Predicate becomes Object, replacing Previous when
+// the graph had a value.
+type KnowledgeFact struct {
+    Id         string  `json:"id"`
+    Tenant     string  `json:"tenant,omitempty"`
+    RoomId     string  `json:"roomId,omitempty"`
+    MessageId  string  `json:"messageId,omitempty"` // The message it was extracted from
+    Source     string  `json:"source"`              // Agent or pipeline that extracted it
+    Subject    string  `json:"subject"`
+    Predicate  string  `json:"predicate"`
+    Object     string  `json:"object"`
+    Previous   string  `json:"previous,omitempty"`
+    Confidence float64 `json:"confidence"`
+    Category   string  `json:"category,omitempty"`  // e.g. price or policy
+    Partition  string  `json:"partition,omitempty"` // Graph partition of the room it came from
+    Status     string  `json:"status"`
+    Reason     string  `json:"reason,omitempty"` // Why it needs review
+    ReviewedBy string  `json:"reviewedBy,omitempty"`
+    Note       string  `json:"note,omitempty"`
+    CreatedAt  int64   `json:"createdAt"` // Unix milliseconds
+    UpdatedAt  int64   `json:"updatedAt"`
+}
+
+// FactFilter narrows ListFacts. Empty fields match everything.
+type FactFilter struct {
+    Tenant string
+    Status string
+    RoomId string
+    Limit  int
+}
+
+func (f FactFilter) match(fact KnowledgeFact) bool {
+    return (f.Tenant == "" || fact.Tenant == f.Tenant) && (f.Status == "" || fact.Status == f.Status) &&
+        (f.RoomId == "" || fact.RoomId == f.RoomId)
+}
+
+type KnowledgeStore interface {
+    // RecordGap adds gap, or counts an occurrence on the tenant's open gap
+    // with the same key, updating where it was last asked. It returns the
+    // stored gap.
+    RecordGap(ctx context.Context, gap KnowledgeGap) (KnowledgeGap, error)
+    GetGap(ctx context.Context, id string) (KnowledgeGap, error)
+    // ListGaps returns matching gaps most asked first, at most Limit of them.
+    ListGaps(ctx context.Context, filter GapFilter) ([]KnowledgeGap, error)
+    // ReviewGap writes the status, reviewer, note and FAQ fields.
+    ReviewGap(ctx context.Context, gap KnowledgeGap) error
+    AddFAQ(ctx context.Context, entry FAQEntry) error
+    // ListFAQ returns a tenant's entries ("" for all) oldest first.
+    ListFAQ(ctx context.Context, tenant string) ([]FAQEntry, error)
+    DeleteFAQ(ctx context.Context, id string) error
+    AddFact(ctx context.Context, fact KnowledgeFact) error
+    GetFact(ctx context.Context, id string) (KnowledgeFact, error)
+    // ListFacts returns matching facts oldest first, at most Limit of them.
+    ListFacts(ctx context.Context, filter FactFilter) ([]KnowledgeFact, error)
+    // ReviewFact settles a pending fact, writing the status, reviewer and
+    // note. ErrNotFound when the fact is missing or no longer pending, so
+    // two reviewers can't both settle it.
+    ReviewFact(ctx context.Context, fact KnowledgeFact) error
+}
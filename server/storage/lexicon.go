@@ -0,0 +1,27 @@
+package storage
+
+import "context"
+
+// Pronunciation tells speech synthesis how a tenant's written form is said:
+// brand and product names, people, places. Phoneme is in Alphabet, "ipa" or
+// "x-sampa", for engines with SSML; Alias respells the word ("zeffer") for
+// engines without. Written and SoundsLike, its known mishearings, also feed
+// speech recognition's post-correction.
+type Pronunciation struct {
+    Tenant     string   `json:"tenant"`
+    Written    string   `json:"written"`
+    Phoneme    string   `json:"phoneme,omitempty"`
+    Alphabet   string   `json:"alphabet,omitempty"`
+    Alias      string   `json:"alias,omitempty"`
+    SoundsLike []string `json:"soundsLike,omitempty"`
+    UpdatedAt  int64    `json:"updatedAt"` // Unix milliseconds
+}
+
+type LexiconStore interface {
+    // List returns a tenant's pronunciations ordered by written form.
+    List(ctx context.Context, tenant string) ([]Pronunciation, error)
+    // Put adds the pronunciation or replaces the tenant's one for the same
+    // written form.
+    Put(ctx context.Context, p Pronunciation) error
+    Delete(ctx context.Context, tenant string, written string) error
+}
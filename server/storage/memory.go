@@ -0,0 +1,805 @@
+package storage
+
+import (
+    "context"
+    "sort"
+    "sync"
+)
+
+// Memory keeps everything in process. It is the default when no database is
+// configured and what tests run against.
+type Memory struct {
+    mu           sync.Mutex
+    rooms        map[string]Room
+    transcripts  map[string][]TranscriptEntry // Per room, oldest first
+    cdrs         map[string]CDR
+    recordings   map[string]Recording
+    provenance   map[string][]Provenance // Per room
+    rollups      map[rollupKey]Rollup
+    agentRollups map[rollupKey]AgentRollup // The key's template holds the agent ID
+    phrases      map[string]map[string]Phrase // Per tenant, by term
+    segments     map[string][]Segment         // Per room, in order
+    audit        []AuditEntry                 // Oldest first
+    consents     map[consentKey]Consent
+    callbacks    []Callback // Oldest first
+    campaigns    map[string]Campaign
+    targets      map[string][]CampaignTarget         // Per campaign, in order
+    gaps         []KnowledgeGap                      // Oldest first
+    faq          []FAQEntry                          // Oldest first
+    facts        []KnowledgeFact                     // Oldest first
+    lexicon      map[string]map[string]Pronunciation // Per tenant, by written form
+}
+
+type consentKey struct {
+    tenant     string
+    customerId string
+    purpose    string
+}
+
+type rollupKey struct {
+    granularity string
+    bucket      int64
+    tenant      string
+    template    string
+    variant     string
+}
+
+func NewMemory() *Memory {
+    return &Memory{
+        rooms:        make(map[string]Room),
+        transcripts:  make(map[string][]TranscriptEntry),
+        cdrs:         make(map[string]CDR),
+        recordings:   make(map[string]Recording),
+        provenance:   make(map[string][]Provenance),
+        rollups:      make(map[rollupKey]Rollup),
+        agentRollups: make(map[rollupKey]AgentRollup),
+        phrases:      make(map[string]map[string]Phrase),
+        lexicon:      make(map[string]map[string]Pronunciation),
+        segments:     make(map[string][]Segment),
+        consents:     make(map[consentKey]Consent),
+        campaigns:    make(map[string]Campaign),
+        targets:      make(map[string][]CampaignTarget),
+    }
+}
+
+func (m *Memory) Rooms() RoomStore             { return memoryRooms{m} }
+func (m *Memory) Transcripts() TranscriptStore { return memoryTranscripts{m} }
+func (m *Memory) CDRs() CDRStore               { return memoryCDRs{m} }
+func (m *Memory) Recordings() RecordingStore   { return memoryRecordings{m} }
+func (m *Memory) Provenance() ProvenanceStore  { return memoryProvenance{m} }
+func (m *Memory) Analytics() AnalyticsStore    { return memoryAnalytics{m} }
+func (m *Memory) Phrases() PhraseStore         { return memoryPhrases{m} }
+func (m *Memory) Segments() SegmentStore       { return memorySegments{m} }
+func (m *Memory) Audit() AuditStore            { return memoryAudit{m} }
+func (m *Memory) Consents() ConsentStore       { return memoryConsents{m} }
+func (m *Memory) Callbacks() CallbackStore     { return memoryCallbacks{m} }
+func (m *Memory) Campaigns() CampaignStore     { return memoryCampaigns{m} }
+func (m *Memory) Knowledge() KnowledgeStore    { return memoryKnowledge{m} }
+func (m *Memory) Lexicon() LexiconStore        { return memoryLexicon{m} }
+func (m *Memory) Close() error                 { return nil }
+
+type memoryRooms struct{ *Memory }
+
+func (m memoryRooms) Create(ctx context.Context, room Room) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.rooms[room.Id] = room
+    return nil
+}
+
+func (m memoryRooms) Close(ctx context.Context, roomId string, closedAt int64) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    room, ok := m.rooms[roomId]
+    if !ok {
+        return ErrNotFound
+    }
+    room.ClosedAt = closedAt
+    m.rooms[roomId] = room
+    return nil
+}
+
+func (m memoryRooms) Get(ctx context.Context, roomId string) (Room, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    room, ok := m.rooms[roomId]
+    if !ok {
+        return Room{}, ErrNotFound
+    }
+    return room, nil
+}
+
+type memoryTranscripts struct{ *Memory }
+
+func (m memoryTranscripts) Append(ctx context.Context, entry TranscriptEntry, maxEntries int) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    entries := append(m.transcripts[entry.RoomId], entry)
+    if over := len(entries) - maxEntries; over > 0 {
+        entries = append([]TranscriptEntry(nil), entries[over:]...)
+    }
+    m.transcripts[entry.RoomId] = entries
+    return nil
+}
+
+func (m memoryTranscripts) List(ctx context.Context, roomId string, before string, limit int) ([]TranscriptEntry, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    entries := m.transcripts[roomId]
+    page := make([]TranscriptEntry, 0, limit)
+    for i := len(entries) - 1; i >= 0 && len(page) < limit; i-- {
+        if before != "" && entries[i].MessageId >= before {
+            continue
+        }
+        page = append(page, entries[i])
+    }
+    return page, nil
+}
+
+func (m memoryTranscripts) Prune(ctx context.Context, retention RetentionFunc) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    for roomId, entries := range m.transcripts {
+        if len(entries) == 0 {
+            delete(m.transcripts, roomId)
+            continue
+        }
+        maxEntries, cutoff := retention(entries[0].Tenant)
+        
+        keep := 0
+        for keep < len(entries) && entries[keep].Timestamp < cutoff {
+            keep++
+        }
+        if over := len(entries) - keep - maxEntries; over > 0 {
+            keep += over
+        }
+        if entries = entries[keep:]; len(entries) == 0 {
+            delete(m.transcripts, roomId)
+        } else {
+            m.transcripts[roomId] = entries
+        }
+    }
+    return nil
+}
+
+type memoryCDRs struct{ *Memory }
+
+func (m memoryCDRs) Save(ctx context.Context, cdr CDR) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.cdrs[cdr.Id] = cdr
+    return nil
+}
+
+func (m memoryCDRs) Get(ctx context.Context, id string) (CDR, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    cdr, ok := m.cdrs[id]
+    if !ok {
+        return CDR{}, ErrNotFound
+    }
+    return cdr, nil
+}
+
+func (m memoryCDRs) Dispose(ctx context.Context, id string, disposition CDRDisposition) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    cdr, ok := m.cdrs[id]
+    if !ok {
+        return ErrNotFound
+    }
+    cdr.Dispositions = append(append([]CDRDisposition(nil), cdr.Dispositions...), disposition)
+    m.cdrs[id] = cdr
+    return nil
+}
+
+func (m memoryCDRs) Score(ctx context.Context, id string, quality CDRQuality) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    cdr, ok := m.cdrs[id]
+    if !ok {
+        return ErrNotFound
+    }
+    cdr.Quality = &quality
+    m.cdrs[id] = cdr
+    return nil
+}
+
+func (m memoryCDRs) List(ctx context.Context, tenant string, since int64, until int64) ([]CDR, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    var list []CDR
+    for _, cdr := range m.cdrs {
+        if cdr.Tenant == tenant && cdr.StartedAt >= since && cdr.StartedAt < until {
+            list = append(list, cdr)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+    return list, nil
+}
+
+type memoryRecordings struct{ *Memory }
+
+func (m memoryRecordings) Start(ctx context.Context, rec Recording) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.recordings[rec.Id] = rec
+    return nil
+}
+
+func (m memoryRecordings) Stop(ctx context.Context, id string, stoppedAt int64) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    rec, ok := m.recordings[id]
+    if !ok {
+        return ErrNotFound
+    }
+    rec.StoppedAt = stoppedAt
+    m.recordings[id] = rec
+    return nil
+}
+
+func (m memoryRecordings) ListByRoom(ctx context.Context, roomId string) ([]Recording, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    var list []Recording
+    for _, rec := range m.recordings {
+        if rec.RoomId == roomId {
+            list = append(list, rec)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+    return list, nil
+}
+
+type memoryProvenance struct{ *Memory }
+
+func (m memoryProvenance) Add(ctx context.Context, p Provenance) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.provenance[p.RoomId] = append(m.provenance[p.RoomId], p)
+    return nil
+}
+
+func (m memoryProvenance) ListByRoom(ctx context.Context, roomId string) ([]Provenance, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]Provenance(nil), m.provenance[roomId]...), nil
+}
+
+type memoryAnalytics struct{ *Memory }
+
+func (m memoryAnalytics) Add(ctx context.Context, delta Rollup) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    key := rollupKey{delta.Granularity, delta.BucketStart, delta.Tenant, delta.Template, delta.Variant}
+    row, ok := m.rollups[key]
+    if !ok {
+        row = Rollup{Granularity: delta.Granularity, BucketStart: delta.BucketStart, Tenant: delta.Tenant, Template: delta.Template, Variant: delta.Variant}
+    }
+    row.Merge(delta)
+    m.rollups[key] = row
+    return nil
+}
+
+func (m memoryAnalytics) Query(ctx context.Context, granularity string, from int64, to int64, tenant string) ([]Rollup, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    var rows []Rollup
+    for key, row := range m.rollups {
+        if key.granularity != granularity || key.bucket < from || key.bucket >= to {
+            continue
+        }
+        if tenant != "" && key.tenant != tenant {
+            continue
+        }
+        row.Intents = copyCounts(row.Intents)
+        rows = append(rows, row)
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].BucketStart < rows[j].BucketStart })
+    return rows, nil
+}
+
+func (m memoryAnalytics) AddAgent(ctx context.Context, delta AgentRollup) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    key := rollupKey{delta.Granularity, delta.BucketStart, delta.Tenant, delta.AgentId, ""}
+    row, ok := m.agentRollups[key]
+    if !ok {
+        row = AgentRollup{Granularity: delta.Granularity, BucketStart: delta.BucketStart, Tenant: delta.Tenant, AgentId: delta.AgentId}
+    }
+    row.Merge(delta)
+    m.agentRollups[key] = row
+    return nil
+}
+
+func (m memoryAnalytics) QueryAgents(ctx context.Context, granularity string, from int64, to int64, tenant string, agentId string) ([]AgentRollup, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    var rows []AgentRollup
+    for key, row := range m.agentRollups {
+        if key.granularity != granularity || key.bucket < from || key.bucket >= to {
+            continue
+        }
+        if (tenant != "" && key.tenant != tenant) || (agentId != "" && key.template != agentId) {
+            continue
+        }
+        rows = append(rows, row)
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].BucketStart < rows[j].BucketStart })
+    return rows, nil
+}
+
+type memoryPhrases struct{ *Memory }
+
+func (m memoryPhrases) List(ctx context.Context, tenant string) ([]Phrase, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]Phrase, 0, len(m.phrases[tenant]))
+    for _, p := range m.phrases[tenant] {
+        p.SoundsLike = append([]string(nil), p.SoundsLike...)
+        list = append(list, p)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Term < list[j].Term })
+    return list, nil
+}
+
+func (m memoryPhrases) Put(ctx context.Context, p Phrase) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.phrases[p.Tenant] == nil {
+        m.phrases[p.Tenant] = make(map[string]Phrase)
+    }
+    p.SoundsLike = append([]string(nil), p.SoundsLike...)
+    m.phrases[p.Tenant][p.Term] = p
+    return nil
+}
+
+func (m memoryPhrases) Delete(ctx context.Context, tenant string, term string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.phrases[tenant][term]; !ok {
+        return ErrNotFound
+    }
+    delete(m.phrases[tenant], term)
+    return nil
+}
+
+type memoryLexicon struct{ *Memory }
+
+func (m memoryLexicon) List(ctx context.Context, tenant string) ([]Pronunciation, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]Pronunciation, 0, len(m.lexicon[tenant]))
+    for _, p := range m.lexicon[tenant] {
+        p.SoundsLike = append([]string(nil), p.SoundsLike...)
+        list = append(list, p)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Written < list[j].Written })
+    return list, nil
+}
+
+func (m memoryLexicon) Put(ctx context.Context, p Pronunciation) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.lexicon[p.Tenant] == nil {
+        m.lexicon[p.Tenant] = make(map[string]Pronunciation)
+    }
+    p.SoundsLike = append([]string(nil), p.SoundsLike...)
+    m.lexicon[p.Tenant][p.Written] = p
+    return nil
+}
+
+func (m memoryLexicon) Delete(ctx context.Context, tenant string, written string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.lexicon[tenant][written]; !ok {
+        return ErrNotFound
+    }
+    delete(m.lexicon[tenant], written)
+    return nil
+}
+
+type memorySegments struct{ *Memory }
+
+func (m memorySegments) Replace(ctx context.Context, roomId string, segments []Segment) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    copied := make([]Segment, len(segments))
+    for i, s := range segments {
+        s.Keywords = append([]string(nil), s.Keywords...)
+        copied[i] = s
+    }
+    m.segments[roomId] = copied
+    return nil
+}
+
+func (m memorySegments) List(ctx context.Context, roomId string) ([]Segment, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]Segment{}, m.segments[roomId]...), nil
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+    if counts == nil {
+        return nil
+    }
+    copied := make(map[string]int64, len(counts))
+    for k, v := range counts {
+        copied[k] = v
+    }
+    return copied
+}
+
+type memoryAudit struct{ *Memory }
+
+func (m memoryAudit) Add(ctx context.Context, entry AuditEntry) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.audit = append(m.audit, entry)
+    return nil
+}
+
+func (m memoryAudit) List(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]AuditEntry, 0)
+    for _, e := range m.audit {
+        if filter.Limit > 0 && len(list) == filter.Limit {
+            break
+        }
+        if filter.match(e) {
+            list = append(list, e)
+        }
+    }
+    return list, nil
+}
+
+type memoryConsents struct{ *Memory }
+
+func (m memoryConsents) Get(ctx context.Context, tenant string, customerId string, purpose string) (Consent, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    consent, ok := m.consents[consentKey{tenant, customerId, purpose}]
+    if !ok {
+        return Consent{}, ErrNotFound
+    }
+    return consent, nil
+}
+
+func (m memoryConsents) Put(ctx context.Context, consent Consent) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.consents[consentKey{consent.Tenant, consent.CustomerId, consent.Purpose}] = consent
+    return nil
+}
+
+type memoryCallbacks struct{ *Memory }
+
+func (m memoryCallbacks) Add(ctx context.Context, callback Callback) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.callbacks = append(m.callbacks, callback)
+    return nil
+}
+
+func (m memoryCallbacks) Get(ctx context.Context, id string) (Callback, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, c := range m.callbacks {
+        if c.Id == id {
+            return c, nil
+        }
+    }
+    return Callback{}, ErrNotFound
+}
+
+func (m memoryCallbacks) List(ctx context.Context, filter CallbackFilter) ([]Callback, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]Callback, 0)
+    for _, c := range m.callbacks {
+        if filter.Limit > 0 && len(list) == filter.Limit {
+            break
+        }
+        if filter.match(c) {
+            list = append(list, c)
+        }
+    }
+    return list, nil
+}
+
+func (m memoryCallbacks) Claim(ctx context.Context, tenant string, worker string, now int64, leaseUntil int64) (Callback, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.callbacks {
+        c := &m.callbacks[i]
+        if (tenant == "" || c.Tenant == tenant) && c.claimable(now) {
+            c.Status, c.ClaimedBy, c.ClaimedUntil, c.UpdatedAt = CallbackClaimed, worker, leaseUntil, now
+            c.Attempts++
+            return *c, nil
+        }
+    }
+    return Callback{}, ErrNotFound
+}
+
+func (m memoryCallbacks) Update(ctx context.Context, callback Callback) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.callbacks {
+        if c := &m.callbacks[i]; c.Id == callback.Id {
+            c.Status, c.ClaimedBy, c.ClaimedUntil, c.NotBefore = callback.Status, callback.ClaimedBy, callback.ClaimedUntil, callback.NotBefore
+            c.Outcome, c.UpdatedAt = callback.Outcome, callback.UpdatedAt
+            return nil
+        }
+    }
+    return ErrNotFound
+}
+
+type memoryCampaigns struct{ *Memory }
+
+func (m memoryCampaigns) Add(ctx context.Context, campaign Campaign) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.campaigns[campaign.Id] = campaign
+    return nil
+}
+
+func (m memoryCampaigns) Get(ctx context.Context, id string) (Campaign, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    campaign, ok := m.campaigns[id]
+    if !ok {
+        return Campaign{}, ErrNotFound
+    }
+    return campaign, nil
+}
+
+func (m memoryCampaigns) List(ctx context.Context, tenant string) ([]Campaign, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]Campaign, 0)
+    for _, campaign := range m.campaigns {
+        if tenant == "" || campaign.Tenant == tenant {
+            list = append(list, campaign)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].CreatedAt != list[j].CreatedAt {
+            return list[i].CreatedAt > list[j].CreatedAt
+        }
+        return list[i].Id < list[j].Id
+    })
+    return list, nil
+}
+
+func (m memoryCampaigns) Update(ctx context.Context, campaign Campaign) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.campaigns[campaign.Id]; !ok {
+        return ErrNotFound
+    }
+    m.campaigns[campaign.Id] = campaign
+    return nil
+}
+
+func (m memoryCampaigns) AddTargets(ctx context.Context, targets []CampaignTarget) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, target := range targets {
+        if _, ok := m.campaigns[target.CampaignId]; !ok {
+            return ErrNotFound
+        }
+    }
+    for _, target := range targets {
+        m.targets[target.CampaignId] = append(m.targets[target.CampaignId], target)
+    }
+    return nil
+}
+
+func (m memoryCampaigns) Targets(ctx context.Context, campaignId string, status string, limit int) ([]CampaignTarget, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]CampaignTarget, 0)
+    for _, target := range m.targets[campaignId] {
+        if limit > 0 && len(list) == limit {
+            break
+        }
+        if status == "" || target.Status == status {
+            list = append(list, target)
+        }
+    }
+    return list, nil
+}
+
+func (m memoryCampaigns) Tally(ctx context.Context, campaignId string) (map[string]int, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    tally := make(map[string]int)
+    for _, target := range m.targets[campaignId] {
+        if target.Status == TargetDone {
+            tally[target.Outcome]++
+        } else {
+            tally[target.Status]++
+        }
+    }
+    return tally, nil
+}
+
+func (m memoryCampaigns) NextTarget(ctx context.Context, campaignId string, now int64) (CampaignTarget, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    targets := m.targets[campaignId]
+    for i := range targets {
+        if t := &targets[i]; t.due(now) {
+            t.Status, t.UpdatedAt = TargetDialing, now
+            t.Attempts++
+            return *t, nil
+        }
+    }
+    return CampaignTarget{}, ErrNotFound
+}
+
+func (m memoryCampaigns) UpdateTarget(ctx context.Context, target CampaignTarget) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    targets := m.targets[target.CampaignId]
+    for i := range targets {
+        if t := &targets[i]; t.Id == target.Id {
+            t.Status, t.NotBefore, t.RoomId = target.Status, target.NotBefore, target.RoomId
+            t.Outcome, t.Detail, t.Duration, t.UpdatedAt = target.Outcome, target.Detail, target.Duration, target.UpdatedAt
+            return nil
+        }
+    }
+    return ErrNotFound
+}
+
+type memoryKnowledge struct{ *Memory }
+
+func (m memoryKnowledge) RecordGap(ctx context.Context, gap KnowledgeGap) (KnowledgeGap, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.gaps {
+        if g := &m.gaps[i]; g.Tenant == gap.Tenant && g.Key == gap.Key && g.Status == GapOpen {
+            g.Occurrences++
+            g.RoomId, g.MessageId, g.Context, g.UpdatedAt = gap.RoomId, gap.MessageId, gap.Context, gap.UpdatedAt
+            return *g, nil
+        }
+    }
+    m.gaps = append(m.gaps, gap)
+    return gap, nil
+}
+
+func (m memoryKnowledge) GetGap(ctx context.Context, id string) (KnowledgeGap, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, g := range m.gaps {
+        if g.Id == id {
+            return g, nil
+        }
+    }
+    return KnowledgeGap{}, ErrNotFound
+}
+
+func (m memoryKnowledge) ListGaps(ctx context.Context, filter GapFilter) ([]KnowledgeGap, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]KnowledgeGap, 0)
+    for _, g := range m.gaps {
+        if filter.match(g) {
+            list = append(list, g)
+        }
+    }
+    sort.SliceStable(list, func(i, j int) bool { return list[i].Occurrences > list[j].Occurrences })
+    if filter.Limit > 0 && len(list) > filter.Limit {
+        list = list[:filter.Limit]
+    }
+    return list, nil
+}
+
+func (m memoryKnowledge) ReviewGap(ctx context.Context, gap KnowledgeGap) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.gaps {
+        if g := &m.gaps[i]; g.Id == gap.Id {
+            g.Status, g.ReviewedBy, g.Note, g.FAQId, g.UpdatedAt = gap.Status, gap.ReviewedBy, gap.Note, gap.FAQId, gap.UpdatedAt
+            return nil
+        }
+    }
+    return ErrNotFound
+}
+
+func (m memoryKnowledge) AddFAQ(ctx context.Context, entry FAQEntry) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.faq = append(m.faq, entry)
+    return nil
+}
+
+func (m memoryKnowledge) ListFAQ(ctx context.Context, tenant string) ([]FAQEntry, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]FAQEntry, 0)
+    for _, entry := range m.faq {
+        if tenant == "" || entry.Tenant == tenant {
+            list = append(list, entry)
+        }
+    }
+    return list, nil
+}
+
+func (m memoryKnowledge) DeleteFAQ(ctx context.Context, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i, entry := range m.faq {
+        if entry.Id == id {
+            m.faq = append(m.faq[:i], m.faq[i+1:]...)
+            return nil
+        }
+    }
+    return ErrNotFound
+}
+
+func (m memoryKnowledge) AddFact(ctx context.Context, fact KnowledgeFact) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.facts = append(m.facts, fact)
+    return nil
+}
+
+func (m memoryKnowledge) GetFact(ctx context.Context, id string) (KnowledgeFact, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, f := range m.facts {
+        if f.Id == id {
+            return f, nil
+        }
+    }
+    return KnowledgeFact{}, ErrNotFound
+}
+
+func (m memoryKnowledge) ListFacts(ctx context.Context, filter FactFilter) ([]KnowledgeFact, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    list := make([]KnowledgeFact, 0)
+    for _, f := range m.facts {
+        if filter.Limit > 0 && len(list) == filter.Limit {
+            break
+        }
+        if filter.match(f) {
+            list = append(list, f)
+        }
+    }
+    return list, nil
+}
+
+func (m memoryKnowledge) ReviewFact(ctx context.Context, fact KnowledgeFact) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.facts {
+        if f := &m.facts[i]; f.Id == fact.Id && f.Status == FactPending {
+            f.Status, f.ReviewedBy, f.Note, f.UpdatedAt = fact.Status, fact.ReviewedBy, fact.Note, fact.UpdatedAt
+            return nil
+        }
+    }
+    return ErrNotFound
+}
@@ -0,0 +1,54 @@
+CREATE TABLE rooms (
+    id         TEXT PRIMARY KEY,
+    tenant     TEXT NOT NULL DEFAULT '',
+    template   TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL,
+    closed_at  BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE transcripts (
+    room_id    TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    tenant     TEXT NOT NULL DEFAULT '',
+    type       TEXT NOT NULL,
+    sender     TEXT NOT NULL,
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    audience   TEXT NOT NULL,
+    data       JSONB,
+    metadata   JSONB,
+    ts         BIGINT NOT NULL,
+    PRIMARY KEY (room_id, message_id)
+);
+CREATE INDEX transcripts_tenant_ts ON transcripts (tenant, ts);
+
+CREATE TABLE cdrs (
+    id           TEXT PRIMARY KEY,
+    room_id      TEXT NOT NULL,
+    tenant       TEXT NOT NULL DEFAULT '',
+    template     TEXT NOT NULL DEFAULT '',
+    started_at   BIGINT NOT NULL,
+    ended_at     BIGINT NOT NULL,
+    participants JSONB NOT NULL
+);
+CREATE INDEX cdrs_tenant_started ON cdrs (tenant, started_at);
+
+CREATE TABLE recordings (
+    id         TEXT PRIMARY KEY,
+    room_id    TEXT NOT NULL,
+    tenant     TEXT NOT NULL DEFAULT '',
+    started_by TEXT NOT NULL,
+    started_at BIGINT NOT NULL,
+    stopped_at BIGINT NOT NULL DEFAULT 0,
+    uri        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX recordings_room ON recordings (room_id);
+
+CREATE TABLE kg_provenance (
+    id         TEXT PRIMARY KEY,
+    room_id    TEXT NOT NULL,
+    message_id TEXT NOT NULL DEFAULT '',
+    source     TEXT NOT NULL,
+    node_ids   TEXT[] NOT NULL DEFAULT '{}',
+    created_at BIGINT NOT NULL
+);
+CREATE INDEX kg_provenance_room ON kg_provenance (room_id);
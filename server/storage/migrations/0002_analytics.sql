@@ -0,0 +1,17 @@
+CREATE TABLE analytics_rollups (
+    granularity       TEXT NOT NULL,
+    bucket_start      BIGINT NOT NULL,
+    tenant            TEXT NOT NULL DEFAULT '',
+    template          TEXT NOT NULL DEFAULT '',
+    calls             BIGINT NOT NULL DEFAULT 0,
+    duration_ms       BIGINT NOT NULL DEFAULT 0,
+    max_duration_ms   BIGINT NOT NULL DEFAULT 0,
+    intents           JSONB NOT NULL DEFAULT '{}',
+    sentiment_sum     DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sentiment_count   BIGINT NOT NULL DEFAULT 0,
+    first_response_ms BIGINT NOT NULL DEFAULT 0,
+    responded         BIGINT NOT NULL DEFAULT 0,
+    sla_met           BIGINT NOT NULL DEFAULT 0,
+    sla_missed        BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (granularity, bucket_start, tenant, template)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE stt_phrases (
+    tenant      TEXT NOT NULL,
+    term        TEXT NOT NULL,
+    boost       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sounds_like TEXT[] NOT NULL DEFAULT '{}',
+    updated_at  BIGINT NOT NULL,
+    PRIMARY KEY (tenant, term)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE transcripts ADD COLUMN citations JSONB;
+CREATE INDEX transcripts_citations ON transcripts USING GIN (citations) WHERE citations IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE analytics_rollups ADD COLUMN profanity BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE analytics_rollups ADD COLUMN profane_calls BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+CREATE TABLE transcript_segments (
+    room_id          TEXT NOT NULL,
+    idx              INTEGER NOT NULL,
+    start_message_id TEXT NOT NULL,
+    end_message_id   TEXT NOT NULL,
+    started_at       BIGINT NOT NULL,
+    ended_at         BIGINT NOT NULL,
+    label            TEXT NOT NULL,
+    keywords         TEXT[] NOT NULL DEFAULT '{}',
+    source           TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (room_id, idx)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE audit_log (
+    id       TEXT PRIMARY KEY,
+    at       BIGINT NOT NULL,
+    tenant   TEXT NOT NULL DEFAULT '',
+    room_id  TEXT NOT NULL DEFAULT '',
+    actor    TEXT NOT NULL,
+    action   TEXT NOT NULL,
+    subject  TEXT NOT NULL DEFAULT '',
+    outcome  TEXT NOT NULL,
+    detail   JSONB
+);
+
+CREATE INDEX audit_log_tenant_at ON audit_log (tenant, at);
+CREATE INDEX audit_log_room ON audit_log (room_id);
@@ -0,0 +1,10 @@
+CREATE TABLE consents (
+    tenant      TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    purpose     TEXT NOT NULL,
+    granted     BOOLEAN NOT NULL,
+    updated_at  BIGINT NOT NULL,
+    room_id     TEXT NOT NULL DEFAULT '',
+    recorded_by TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (tenant, customer_id, purpose)
+);
@@ -0,0 +1,22 @@
+CREATE TABLE callbacks (
+    id            TEXT PRIMARY KEY,
+    tenant        TEXT NOT NULL DEFAULT '',
+    room_id       TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL DEFAULT '',
+    customer_id   TEXT NOT NULL DEFAULT '',
+    phone         TEXT NOT NULL DEFAULT '',
+    reason        TEXT NOT NULL,
+    detail        TEXT NOT NULL DEFAULT '',
+    context       JSONB,
+    status        TEXT NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    claimed_by    TEXT NOT NULL DEFAULT '',
+    claimed_until BIGINT NOT NULL DEFAULT 0,
+    not_before    BIGINT NOT NULL DEFAULT 0,
+    outcome       TEXT NOT NULL DEFAULT '',
+    created_at    BIGINT NOT NULL,
+    updated_at    BIGINT NOT NULL
+);
+
+CREATE INDEX callbacks_queue ON callbacks (tenant, status, created_at);
+CREATE INDEX callbacks_customer ON callbacks (customer_id);
@@ -0,0 +1,41 @@
+CREATE TABLE campaigns (
+    id                  TEXT PRIMARY KEY,
+    tenant              TEXT NOT NULL DEFAULT '',
+    name                TEXT NOT NULL,
+    template            TEXT NOT NULL DEFAULT '',
+    greeting            TEXT NOT NULL DEFAULT '',
+    caller_id           TEXT NOT NULL DEFAULT '',
+    calls_per_minute    DOUBLE PRECISION NOT NULL,
+    max_concurrent      INTEGER NOT NULL,
+    max_attempts        INTEGER NOT NULL,
+    retry_delay_seconds INTEGER NOT NULL,
+    on_machine          TEXT NOT NULL,
+    message             TEXT NOT NULL DEFAULT '',
+    callbacks           BOOLEAN NOT NULL DEFAULT FALSE,
+    status              TEXT NOT NULL,
+    created_at          BIGINT NOT NULL,
+    updated_at          BIGINT NOT NULL
+);
+
+CREATE INDEX campaigns_tenant ON campaigns (tenant, created_at);
+
+CREATE TABLE campaign_targets (
+    seq         BIGSERIAL,
+    id          TEXT PRIMARY KEY,
+    campaign_id TEXT NOT NULL REFERENCES campaigns (id) ON DELETE CASCADE,
+    phone       TEXT NOT NULL,
+    customer_id TEXT NOT NULL DEFAULT '',
+    metadata    JSONB,
+    callback_id TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL,
+    attempts    INTEGER NOT NULL DEFAULT 0,
+    not_before  BIGINT NOT NULL DEFAULT 0,
+    room_id     TEXT NOT NULL DEFAULT '',
+    outcome     TEXT NOT NULL DEFAULT '',
+    detail      TEXT NOT NULL DEFAULT '',
+    duration    BIGINT NOT NULL DEFAULT 0,
+    created_at  BIGINT NOT NULL,
+    updated_at  BIGINT NOT NULL
+);
+
+CREATE INDEX campaign_targets_queue ON campaign_targets (campaign_id, status, seq);
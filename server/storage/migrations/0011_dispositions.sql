@@ -0,0 +1 @@
+ALTER TABLE cdrs ADD COLUMN dispositions JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,16 @@
+CREATE TABLE agent_rollups (
+    granularity     TEXT NOT NULL,
+    bucket_start    BIGINT NOT NULL,
+    tenant          TEXT NOT NULL DEFAULT '',
+    agent_id        TEXT NOT NULL,
+    calls           BIGINT NOT NULL DEFAULT 0,
+    handle_ms       BIGINT NOT NULL DEFAULT 0,
+    wrap_up_ms      BIGINT NOT NULL DEFAULT 0,
+    words           BIGINT NOT NULL DEFAULT 0,
+    call_words      BIGINT NOT NULL DEFAULT 0,
+    sentiment_sum   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sentiment_count BIGINT NOT NULL DEFAULT 0,
+    handed_off      BIGINT NOT NULL DEFAULT 0,
+    took_over       BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (granularity, bucket_start, tenant, agent_id)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE analytics_rollups ADD COLUMN variant TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics_rollups DROP CONSTRAINT analytics_rollups_pkey;
+ALTER TABLE analytics_rollups ADD PRIMARY KEY (granularity, bucket_start, tenant, template, variant);
+
+ALTER TABLE cdrs ADD COLUMN variants JSONB NOT NULL DEFAULT '{}';
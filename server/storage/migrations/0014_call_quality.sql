@@ -0,0 +1,10 @@
+ALTER TABLE cdrs ADD COLUMN quality JSONB;
+
+ALTER TABLE analytics_rollups
+    ADD COLUMN scored         BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN resolution_sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN accuracy_sum   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN accuracy_count BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN tone_sum       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN overall_sum    DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN low_quality    BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE cdrs ADD COLUMN cost JSONB;
@@ -0,0 +1,33 @@
+CREATE TABLE knowledge_gaps (
+    id          TEXT PRIMARY KEY,
+    tenant      TEXT NOT NULL DEFAULT '',
+    template    TEXT NOT NULL DEFAULT '',
+    key         TEXT NOT NULL,
+    question    TEXT NOT NULL,
+    answer      TEXT NOT NULL DEFAULT '',
+    room_id     TEXT NOT NULL DEFAULT '',
+    message_id  TEXT NOT NULL DEFAULT '',
+    context     JSONB,
+    occurrences INTEGER NOT NULL DEFAULT 1,
+    status      TEXT NOT NULL,
+    reviewed_by TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    faq_id      TEXT NOT NULL DEFAULT '',
+    created_at  BIGINT NOT NULL,
+    updated_at  BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX knowledge_gaps_open ON knowledge_gaps (tenant, key) WHERE status = 'open';
+CREATE INDEX knowledge_gaps_queue ON knowledge_gaps (tenant, status, occurrences);
+
+CREATE TABLE faq_entries (
+    id         TEXT PRIMARY KEY,
+    tenant     TEXT NOT NULL DEFAULT '',
+    question   TEXT NOT NULL,
+    answer     TEXT NOT NULL,
+    gap_id     TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL
+);
+
+CREATE INDEX faq_entries_tenant ON faq_entries (tenant, created_at);
@@ -0,0 +1,22 @@
+CREATE TABLE knowledge_facts (
+    id          TEXT PRIMARY KEY,
+    tenant      TEXT NOT NULL DEFAULT '',
+    room_id     TEXT NOT NULL DEFAULT '',
+    message_id  TEXT NOT NULL DEFAULT '',
+    source      TEXT NOT NULL DEFAULT '',
+    subject     TEXT NOT NULL,
+    predicate   TEXT NOT NULL,
+    object      TEXT NOT NULL,
+    previous    TEXT NOT NULL DEFAULT '',
+    confidence  DOUBLE PRECISION NOT NULL DEFAULT 0,
+    category    TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    reviewed_by TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    created_at  BIGINT NOT NULL,
+    updated_at  BIGINT NOT NULL
+);
+
+CREATE INDEX knowledge_facts_queue ON knowledge_facts (tenant, status, created_at);
+CREATE INDEX knowledge_facts_room ON knowledge_facts (room_id);
@@ -0,0 +1 @@
+ALTER TABLE knowledge_facts ADD COLUMN partition TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE cdrs ADD COLUMN kg_snapshot TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE tts_lexicon (
+    tenant      TEXT NOT NULL,
+    written     TEXT NOT NULL,
+    phoneme     TEXT NOT NULL DEFAULT '',
+    alphabet    TEXT NOT NULL DEFAULT '',
+    alias       TEXT NOT NULL DEFAULT '',
+    sounds_like TEXT[] NOT NULL DEFAULT '{}',
+    updated_at  BIGINT NOT NULL,
+    PRIMARY KEY (tenant, written)
+);
@@ -0,0 +1,22 @@
+package storage
+
+import "context"
+
+// Phrase is a domain term a tenant wants recognized exactly: product names,
+// jargon, people. SoundsLike lists how recognizers tend to mishear it, for
+// post-correction; Boost is passed to providers that weight hints.
+type Phrase struct {
+    Tenant     string   `json:"tenant"`
+    Term       string   `json:"term"`
+    Boost      float64  `json:"boost,omitempty"`
+    SoundsLike []string `json:"soundsLike,omitempty"`
+    UpdatedAt  int64    `json:"updatedAt"` // Unix milliseconds
+}
+
+type PhraseStore interface {
+    // List returns a tenant's phrases ordered by term.
+    List(ctx context.Context, tenant string) ([]Phrase, error)
+    // Put adds the phrase or replaces the tenant's phrase with the same term.
+    Put(ctx context.Context, p Phrase) error
+    Delete(ctx context.Context, tenant string, term string) error
+}
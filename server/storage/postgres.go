@@ -0,0 +1,1123 @@
+package storage
+
+import (
+    "context"
+    "database/sql"
+    "embed"
+    "encoding/json"
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    
+    "github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Postgres stores everything in a Postgres database. Schema changes live in
+// migrations/ as numbered files and are applied in order on open.
+type Postgres struct {
+    db *sql.DB
+}
+
+func OpenPostgres(dsn string) (*Postgres, error) {
+    db, err := sql.Open("postgres", dsn)
+    if err != nil {
+        return nil, err
+    }
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, err
+    }
+    
+    p := &Postgres{db: db}
+    if err := p.migrate(context.Background()); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return p, nil
+}
+
+// migrateLock is the advisory lock key migrations run under.
+const migrateLock = 0x49564131 // "IVA1"
+
+// migrate applies every migration newer than the recorded schema version,
+// each in its own transaction. Processes starting together, an upgrade's or
+// a failover's, take turns on an advisory lock, and the later ones find the
+// versions applied.
+func (p *Postgres) migrate(ctx context.Context) error {
+    conn, err := p.db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrateLock); err != nil {
+        return err
+    }
+    defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrateLock)
+    
+    if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version TEXT PRIMARY KEY)`); err != nil {
+        return err
+    }
+    
+    names, err := migrations.ReadDir("migrations")
+    if err != nil {
+        return err
+    }
+    files := make([]string, 0, len(names))
+    for _, entry := range names {
+        files = append(files, entry.Name())
+    }
+    sort.Strings(files)
+    
+    for _, name := range files {
+        version := strings.TrimSuffix(name, ".sql")
+        var applied bool
+        if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+            return err
+        }
+        if applied {
+            continue
+        }
+        
+        script, err := migrations.ReadFile("migrations/" + name)
+        if err != nil {
+            return err
+        }
+        tx, err := conn.BeginTx(ctx, nil)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %s: %v", name, err)
+        }
+        if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+            tx.Rollback()
+            return err
+        }
+        if err := tx.Commit(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (p *Postgres) Rooms() RoomStore             { return postgresRooms{p} }
+func (p *Postgres) Transcripts() TranscriptStore { return postgresTranscripts{p} }
+func (p *Postgres) CDRs() CDRStore               { return postgresCDRs{p} }
+func (p *Postgres) Recordings() RecordingStore   { return postgresRecordings{p} }
+func (p *Postgres) Provenance() ProvenanceStore  { return postgresProvenance{p} }
+func (p *Postgres) Analytics() AnalyticsStore    { return postgresAnalytics{p} }
+func (p *Postgres) Phrases() PhraseStore         { return postgresPhrases{p} }
+func (p *Postgres) Segments() SegmentStore       { return postgresSegments{p} }
+func (p *Postgres) Audit() AuditStore            { return postgresAudit{p} }
+func (p *Postgres) Consents() ConsentStore       { return postgresConsents{p} }
+func (p *Postgres) Callbacks() CallbackStore     { return postgresCallbacks{p} }
+func (p *Postgres) Campaigns() CampaignStore     { return postgresCampaigns{p} }
+func (p *Postgres) Knowledge() KnowledgeStore    { return postgresKnowledge{p} }
+func (p *Postgres) Lexicon() LexiconStore        { return postgresLexicon{p} }
+func (p *Postgres) Close() error                 { return p.db.Close() }
+
+// nullJSON keeps absent payloads NULL rather than the JSON literal null.
+func nullJSON(data json.RawMessage) interface{} {
+    if len(data) == 0 {
+        return nil
+    }
+    return []byte(data)
+}
+
+func expectRow(res sql.Result, err error) error {
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+type postgresRooms struct{ *Postgres }
+
+func (p postgresRooms) Create(ctx context.Context, room Room) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO rooms (id, tenant, template, created_at, closed_at) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (id) DO UPDATE SET tenant = $2, template = $3, created_at = $4, closed_at = $5`,
+        room.Id, room.Tenant, room.Template, room.CreatedAt, room.ClosedAt)
+    return err
+}
+
+func (p postgresRooms) Close(ctx context.Context, roomId string, closedAt int64) error {
+    return expectRow(p.db.ExecContext(ctx, `UPDATE rooms SET closed_at = $2 WHERE id = $1`, roomId, closedAt))
+}
+
+func (p postgresRooms) Get(ctx context.Context, roomId string) (Room, error) {
+    room := Room{Id: roomId}
+    err := p.db.QueryRowContext(ctx, `SELECT tenant, template, created_at, closed_at FROM rooms WHERE id = $1`, roomId).
+        Scan(&room.Tenant, &room.Template, &room.CreatedAt, &room.ClosedAt)
+    if err == sql.ErrNoRows {
+        return Room{}, ErrNotFound
+    }
+    return room, err
+}
+
+type postgresTranscripts struct{ *Postgres }
+
+// Append only inserts: trimming on every message would rank the room's
+// history each time, so rooms are trimmed together by Prune, which the
+// history janitor runs every minute.
+func (p postgresTranscripts) Append(ctx context.Context, e TranscriptEntry, maxEntries int) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO transcripts (room_id, message_id, tenant, type, sender, recipients, audience, data, metadata, citations, ts)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        ON CONFLICT (room_id, message_id) DO NOTHING`,
+        e.RoomId, e.MessageId, e.Tenant, e.Type, e.From, pq.Array(e.To), e.Audience, nullJSON(e.Data), nullJSON(e.Metadata), nullJSON(e.Citations), e.Timestamp)
+    return err
+}
+
+func (p postgresTranscripts) List(ctx context.Context, roomId string, before string, limit int) ([]TranscriptEntry, error) {
+    query := `SELECT message_id, tenant, type, sender, recipients, audience, data, metadata, citations, ts FROM transcripts WHERE room_id = $1`
+    args := []interface{}{roomId}
+    if before != "" {
+        query += ` AND message_id < $3`
+        args = append(args, limit, before)
+    } else {
+        args = append(args, limit)
+    }
+    query += ` ORDER BY message_id DESC LIMIT $2`
+    
+    rows, err := p.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var page []TranscriptEntry
+    for rows.Next() {
+        e := TranscriptEntry{RoomId: roomId}
+        var data, metadata, citations []byte
+        if err := rows.Scan(&e.MessageId, &e.Tenant, &e.Type, &e.From, pq.Array(&e.To), &e.Audience, &data, &metadata, &citations, &e.Timestamp); err != nil {
+            return nil, err
+        }
+        e.Data, e.Metadata, e.Citations = data, metadata, citations
+        page = append(page, e)
+    }
+    return page, rows.Err()
+}
+
+func (p postgresTranscripts) Prune(ctx context.Context, retention RetentionFunc) error {
+    rows, err := p.db.QueryContext(ctx, `SELECT DISTINCT tenant FROM transcripts`)
+    if err != nil {
+        return err
+    }
+    var tenants []string
+    for rows.Next() {
+        var tenant string
+        if err := rows.Scan(&tenant); err != nil {
+            rows.Close()
+            return err
+        }
+        tenants = append(tenants, tenant)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    
+    for _, tenant := range tenants {
+        maxEntries, cutoff := retention(tenant)
+        if _, err := p.db.ExecContext(ctx, `DELETE FROM transcripts WHERE tenant = $1 AND ts < $2`, tenant, cutoff); err != nil {
+            return err
+        }
+        if _, err := p.db.ExecContext(ctx, `
+            DELETE FROM transcripts t USING (
+                SELECT room_id, message_id, row_number() OVER (PARTITION BY room_id ORDER BY message_id DESC) AS n
+                FROM transcripts WHERE tenant = $1
+            ) ranked
+            WHERE t.room_id = ranked.room_id AND t.message_id = ranked.message_id AND ranked.n > $2`,
+            tenant, maxEntries); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type postgresCDRs struct{ *Postgres }
+
+func (p postgresCDRs) Save(ctx context.Context, cdr CDR) error {
+    participants, err := json.Marshal(cdr.Participants)
+    if err != nil {
+        return err
+    }
+    dispositions, err := json.Marshal(cdr.Dispositions)
+    if err != nil {
+        return err
+    }
+    if cdr.Dispositions == nil {
+        dispositions = []byte("[]")
+    }
+    variants, err := json.Marshal(cdr.Variants)
+    if err != nil {
+        return err
+    }
+    if cdr.Variants == nil {
+        variants = []byte("{}")
+    }
+    var cost []byte
+    if cdr.Cost != nil {
+        if cost, err = json.Marshal(cdr.Cost); err != nil {
+            return err
+        }
+    }
+    _, err = p.db.ExecContext(ctx, `
+        INSERT INTO cdrs (id, room_id, tenant, template, started_at, ended_at, participants, dispositions, variants, cost, kg_snapshot) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        ON CONFLICT (id) DO UPDATE SET ended_at = $6, participants = $7, dispositions = $8, cost = $10`,
+        cdr.Id, cdr.RoomId, cdr.Tenant, cdr.Template, cdr.StartedAt, cdr.EndedAt, participants, dispositions, variants, cost, cdr.KGSnapshot)
+    return err
+}
+
+func (p postgresCDRs) Dispose(ctx context.Context, id string, disposition CDRDisposition) error {
+    entry, err := json.Marshal([]CDRDisposition{disposition})
+    if err != nil {
+        return err
+    }
+    result, err := p.db.ExecContext(ctx, `UPDATE cdrs SET dispositions = dispositions || $2::jsonb WHERE id = $1`, id, entry)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func (p postgresCDRs) Score(ctx context.Context, id string, quality CDRQuality) error {
+    entry, err := json.Marshal(quality)
+    if err != nil {
+        return err
+    }
+    result, err := p.db.ExecContext(ctx, `UPDATE cdrs SET quality = $2 WHERE id = $1`, id, entry)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func scanCDR(row interface{ Scan(...interface{}) error }) (CDR, error) {
+    var cdr CDR
+    var participants, dispositions, variants, quality, cost []byte
+    if err := row.Scan(&cdr.Id, &cdr.RoomId, &cdr.Tenant, &cdr.Template, &cdr.StartedAt, &cdr.EndedAt, &participants, &dispositions, &variants, &quality, &cost, &cdr.KGSnapshot); err != nil {
+        return CDR{}, err
+    }
+    if err := json.Unmarshal(dispositions, &cdr.Dispositions); err != nil {
+        return CDR{}, err
+    }
+    if err := json.Unmarshal(variants, &cdr.Variants); err != nil {
+        return CDR{}, err
+    }
+    if quality != nil {
+        cdr.Quality = &CDRQuality{}
+        if err := json.Unmarshal(quality, cdr.Quality); err != nil {
+            return CDR{}, err
+        }
+    }
+    if cost != nil {
+        cdr.Cost = &CDRCost{}
+        if err := json.Unmarshal(cost, cdr.Cost); err != nil {
+            return CDR{}, err
+        }
+    }
+    return cdr, json.Unmarshal(participants, &cdr.Participants)
+}
+
+const cdrColumns = `id, room_id, tenant, template, started_at, ended_at, participants, dispositions, variants, quality, cost, kg_snapshot`
+
+func (p postgresCDRs) Get(ctx context.Context, id string) (CDR, error) {
+    cdr, err := scanCDR(p.db.QueryRowContext(ctx, `SELECT `+cdrColumns+` FROM cdrs WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return CDR{}, ErrNotFound
+    }
+    return cdr, err
+}
+
+func (p postgresCDRs) List(ctx context.Context, tenant string, since int64, until int64) ([]CDR, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT `+cdrColumns+` FROM cdrs
+        WHERE tenant = $1 AND started_at >= $2 AND started_at < $3 ORDER BY started_at`, tenant, since, until)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var list []CDR
+    for rows.Next() {
+        cdr, err := scanCDR(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, cdr)
+    }
+    return list, rows.Err()
+}
+
+type postgresRecordings struct{ *Postgres }
+
+func (p postgresRecordings) Start(ctx context.Context, rec Recording) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO recordings (id, room_id, tenant, started_by, started_at, stopped_at, uri) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+        rec.Id, rec.RoomId, rec.Tenant, rec.StartedBy, rec.StartedAt, rec.StoppedAt, rec.URI)
+    return err
+}
+
+func (p postgresRecordings) Stop(ctx context.Context, id string, stoppedAt int64) error {
+    return expectRow(p.db.ExecContext(ctx, `UPDATE recordings SET stopped_at = $2 WHERE id = $1`, id, stoppedAt))
+}
+
+func (p postgresRecordings) ListByRoom(ctx context.Context, roomId string) ([]Recording, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id, tenant, started_by, started_at, stopped_at, uri
+        FROM recordings WHERE room_id = $1 ORDER BY started_at`, roomId)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var list []Recording
+    for rows.Next() {
+        rec := Recording{RoomId: roomId}
+        if err := rows.Scan(&rec.Id, &rec.Tenant, &rec.StartedBy, &rec.StartedAt, &rec.StoppedAt, &rec.URI); err != nil {
+            return nil, err
+        }
+        list = append(list, rec)
+    }
+    return list, rows.Err()
+}
+
+type postgresProvenance struct{ *Postgres }
+
+func (p postgresProvenance) Add(ctx context.Context, prov Provenance) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO kg_provenance (id, room_id, message_id, source, node_ids, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+        prov.Id, prov.RoomId, prov.MessageId, prov.Source, pq.Array(prov.NodeIds), prov.CreatedAt)
+    return err
+}
+
+func (p postgresProvenance) ListByRoom(ctx context.Context, roomId string) ([]Provenance, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id, message_id, source, node_ids, created_at
+        FROM kg_provenance WHERE room_id = $1 ORDER BY created_at`, roomId)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var list []Provenance
+    for rows.Next() {
+        prov := Provenance{RoomId: roomId}
+        if err := rows.Scan(&prov.Id, &prov.MessageId, &prov.Source, pq.Array(&prov.NodeIds), &prov.CreatedAt); err != nil {
+            return nil, err
+        }
+        list = append(list, prov)
+    }
+    return list, rows.Err()
+}
+
+type postgresAnalytics struct{ *Postgres }
+
+const rollupColumns = `granularity, bucket_start, tenant, template, variant, calls, duration_ms, max_duration_ms, intents,
+    sentiment_sum, sentiment_count, first_response_ms, responded, sla_met, sla_missed, profanity, profane_calls,
+    scored, resolution_sum, accuracy_sum, accuracy_count, tone_sum, overall_sum, low_quality`
+
+func scanRollup(row interface{ Scan(...interface{}) error }) (Rollup, error) {
+    var r Rollup
+    var intents []byte
+    err := row.Scan(&r.Granularity, &r.BucketStart, &r.Tenant, &r.Template, &r.Variant, &r.Calls, &r.DurationMs, &r.MaxDurationMs, &intents,
+        &r.SentimentSum, &r.SentimentCount, &r.FirstResponseMs, &r.Responded, &r.SLAMet, &r.SLAMissed, &r.Profanity, &r.ProfaneCalls,
+        &r.Scored, &r.ResolutionSum, &r.AccuracySum, &r.AccuracyCount, &r.ToneSum, &r.OverallSum, &r.LowQuality)
+    if err != nil {
+        return Rollup{}, err
+    }
+    return r, json.Unmarshal(intents, &r.Intents)
+}
+
+// Add merges in Go rather than SQL so intent counts combine the same way as
+// the in-memory store. The row lock serialises concurrent flushes.
+func (p postgresAnalytics) Add(ctx context.Context, delta Rollup) error {
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    
+    if _, err := tx.ExecContext(ctx, `
+        INSERT INTO analytics_rollups (granularity, bucket_start, tenant, template, variant) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT DO NOTHING`, delta.Granularity, delta.BucketStart, delta.Tenant, delta.Template, delta.Variant); err != nil {
+        return err
+    }
+    row, err := scanRollup(tx.QueryRowContext(ctx, `SELECT `+rollupColumns+` FROM analytics_rollups
+        WHERE granularity = $1 AND bucket_start = $2 AND tenant = $3 AND template = $4 AND variant = $5 FOR UPDATE`,
+        delta.Granularity, delta.BucketStart, delta.Tenant, delta.Template, delta.Variant))
+    if err != nil {
+        return err
+    }
+    row.Merge(delta)
+    
+    intents, err := json.Marshal(row.Intents)
+    if err != nil {
+        return err
+    }
+    if row.Intents == nil {
+        intents = []byte("{}")
+    }
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE analytics_rollups SET calls = $6, duration_ms = $7, max_duration_ms = $8, intents = $9,
+            sentiment_sum = $10, sentiment_count = $11, first_response_ms = $12, responded = $13, sla_met = $14, sla_missed = $15,
+            profanity = $16, profane_calls = $17, scored = $18, resolution_sum = $19, accuracy_sum = $20, accuracy_count = $21,
+            tone_sum = $22, overall_sum = $23, low_quality = $24
+        WHERE granularity = $1 AND bucket_start = $2 AND tenant = $3 AND template = $4 AND variant = $5`,
+        row.Granularity, row.BucketStart, row.Tenant, row.Template, row.Variant, row.Calls, row.DurationMs, row.MaxDurationMs, intents,
+        row.SentimentSum, row.SentimentCount, row.FirstResponseMs, row.Responded, row.SLAMet, row.SLAMissed,
+        row.Profanity, row.ProfaneCalls, row.Scored, row.ResolutionSum, row.AccuracySum, row.AccuracyCount,
+        row.ToneSum, row.OverallSum, row.LowQuality); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (p postgresAnalytics) Query(ctx context.Context, granularity string, from int64, to int64, tenant string) ([]Rollup, error) {
+    query := `SELECT ` + rollupColumns + ` FROM analytics_rollups WHERE granularity = $1 AND bucket_start >= $2 AND bucket_start < $3`
+    args := []interface{}{granularity, from, to}
+    if tenant != "" {
+        query += ` AND tenant = $4`
+        args = append(args, tenant)
+    }
+    rows, err := p.db.QueryContext(ctx, query+` ORDER BY bucket_start`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var list []Rollup
+    for rows.Next() {
+        r, err := scanRollup(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, r)
+    }
+    return list, rows.Err()
+}
+
+const agentRollupColumns = `granularity, bucket_start, tenant, agent_id, calls, handle_ms, wrap_up_ms, words, call_words,
+    sentiment_sum, sentiment_count, handed_off, took_over`
+
+// AddAgent has no JSON to merge, so it adds in SQL.
+func (p postgresAnalytics) AddAgent(ctx context.Context, delta AgentRollup) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO agent_rollups (`+agentRollupColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+        ON CONFLICT (granularity, bucket_start, tenant, agent_id) DO UPDATE SET
+            calls = agent_rollups.calls + $5, handle_ms = agent_rollups.handle_ms + $6, wrap_up_ms = agent_rollups.wrap_up_ms + $7,
+            words = agent_rollups.words + $8, call_words = agent_rollups.call_words + $9,
+            sentiment_sum = agent_rollups.sentiment_sum + $10, sentiment_count = agent_rollups.sentiment_count + $11,
+            handed_off = agent_rollups.handed_off + $12, took_over = agent_rollups.took_over + $13`,
+        delta.Granularity, delta.BucketStart, delta.Tenant, delta.AgentId, delta.Calls, delta.HandleMs, delta.WrapUpMs,
+        delta.Words, delta.CallWords, delta.SentimentSum, delta.SentimentCount, delta.HandedOff, delta.TookOver)
+    return err
+}
+
+func (p postgresAnalytics) QueryAgents(ctx context.Context, granularity string, from int64, to int64, tenant string, agentId string) ([]AgentRollup, error) {
+    query := `SELECT ` + agentRollupColumns + ` FROM agent_rollups WHERE granularity = $1 AND bucket_start >= $2 AND bucket_start < $3`
+    args := []interface{}{granularity, from, to}
+    if tenant != "" {
+        args = append(args, tenant)
+        query += fmt.Sprintf(` AND tenant = $%d`, len(args))
+    }
+    if agentId != "" {
+        args = append(args, agentId)
+        query += fmt.Sprintf(` AND agent_id = $%d`, len(args))
+    }
+    rows, err := p.db.QueryContext(ctx, query+` ORDER BY bucket_start`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var list []AgentRollup
+    for rows.Next() {
+        var r AgentRollup
+        if err := rows.Scan(&r.Granularity, &r.BucketStart, &r.Tenant, &r.AgentId, &r.Calls, &r.HandleMs, &r.WrapUpMs, &r.Words, &r.CallWords,
+            &r.SentimentSum, &r.SentimentCount, &r.HandedOff, &r.TookOver); err != nil {
+            return nil, err
+        }
+        list = append(list, r)
+    }
+    return list, rows.Err()
+}
+
+type postgresPhrases struct{ *Postgres }
+
+func (p postgresPhrases) List(ctx context.Context, tenant string) ([]Phrase, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT term, boost, sounds_like, updated_at
+        FROM stt_phrases WHERE tenant = $1 ORDER BY term`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]Phrase, 0)
+    for rows.Next() {
+        phrase := Phrase{Tenant: tenant}
+        if err := rows.Scan(&phrase.Term, &phrase.Boost, pq.Array(&phrase.SoundsLike), &phrase.UpdatedAt); err != nil {
+            return nil, err
+        }
+        list = append(list, phrase)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresPhrases) Put(ctx context.Context, phrase Phrase) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO stt_phrases (tenant, term, boost, sounds_like, updated_at) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (tenant, term) DO UPDATE SET boost = $3, sounds_like = $4, updated_at = $5`,
+        phrase.Tenant, phrase.Term, phrase.Boost, pq.Array(phrase.SoundsLike), phrase.UpdatedAt)
+    return err
+}
+
+func (p postgresPhrases) Delete(ctx context.Context, tenant string, term string) error {
+    return expectRow(p.db.ExecContext(ctx, `DELETE FROM stt_phrases WHERE tenant = $1 AND term = $2`, tenant, term))
+}
+
+type postgresLexicon struct{ *Postgres }
+
+func (p postgresLexicon) List(ctx context.Context, tenant string) ([]Pronunciation, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT written, phoneme, alphabet, alias, sounds_like, updated_at
+        FROM tts_lexicon WHERE tenant = $1 ORDER BY written`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]Pronunciation, 0)
+    for rows.Next() {
+        entry := Pronunciation{Tenant: tenant}
+        if err := rows.Scan(&entry.Written, &entry.Phoneme, &entry.Alphabet, &entry.Alias, pq.Array(&entry.SoundsLike), &entry.UpdatedAt); err != nil {
+            return nil, err
+        }
+        list = append(list, entry)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresLexicon) Put(ctx context.Context, entry Pronunciation) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO tts_lexicon (tenant, written, phoneme, alphabet, alias, sounds_like, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (tenant, written) DO UPDATE SET phoneme = $3, alphabet = $4, alias = $5, sounds_like = $6, updated_at = $7`,
+        entry.Tenant, entry.Written, entry.Phoneme, entry.Alphabet, entry.Alias, pq.Array(entry.SoundsLike), entry.UpdatedAt)
+    return err
+}
+
+func (p postgresLexicon) Delete(ctx context.Context, tenant string, written string) error {
+    return expectRow(p.db.ExecContext(ctx, `DELETE FROM tts_lexicon WHERE tenant = $1 AND written = $2`, tenant, written))
+}
+
+type postgresSegments struct{ *Postgres }
+
+func (p postgresSegments) Replace(ctx context.Context, roomId string, segments []Segment) error {
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    
+    if _, err := tx.ExecContext(ctx, `DELETE FROM transcript_segments WHERE room_id = $1`, roomId); err != nil {
+        return err
+    }
+    for _, s := range segments {
+        if _, err := tx.ExecContext(ctx, `
+            INSERT INTO transcript_segments (room_id, idx, start_message_id, end_message_id, started_at, ended_at, label, keywords, source)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+            roomId, s.Index, s.StartMessageId, s.EndMessageId, s.StartedAt, s.EndedAt, s.Label, pq.Array(s.Keywords), s.Source); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (p postgresSegments) List(ctx context.Context, roomId string) ([]Segment, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT idx, start_message_id, end_message_id, started_at, ended_at, label, keywords, source
+        FROM transcript_segments WHERE room_id = $1 ORDER BY idx`, roomId)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]Segment, 0)
+    for rows.Next() {
+        s := Segment{RoomId: roomId}
+        if err := rows.Scan(&s.Index, &s.StartMessageId, &s.EndMessageId, &s.StartedAt, &s.EndedAt, &s.Label, pq.Array(&s.Keywords), &s.Source); err != nil {
+            return nil, err
+        }
+        list = append(list, s)
+    }
+    return list, rows.Err()
+}
+
+type postgresAudit struct{ *Postgres }
+
+func (p postgresAudit) Add(ctx context.Context, e AuditEntry) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO audit_log (id, at, tenant, room_id, actor, action, subject, outcome, detail) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+        e.Id, e.At, e.Tenant, e.RoomId, e.Actor, e.Action, e.Subject, e.Outcome, nullJSON(e.Detail))
+    return err
+}
+
+func (p postgresAudit) List(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+    until := f.Until
+    if until == 0 {
+        until = math.MaxInt64
+    }
+    limit := f.Limit
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := p.db.QueryContext(ctx, `SELECT id, at, tenant, room_id, actor, action, subject, outcome, detail FROM audit_log
+        WHERE ($1 = '' OR tenant = $1) AND ($2 = '' OR room_id = $2) AND ($3 = '' OR action = $3) AND ($4 = '' OR subject = $4)
+        AND at >= $5 AND at < $6 ORDER BY at LIMIT $7`, f.Tenant, f.RoomId, f.Action, f.Subject, f.Since, until, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]AuditEntry, 0)
+    for rows.Next() {
+        var e AuditEntry
+        var detail []byte
+        if err := rows.Scan(&e.Id, &e.At, &e.Tenant, &e.RoomId, &e.Actor, &e.Action, &e.Subject, &e.Outcome, &detail); err != nil {
+            return nil, err
+        }
+        e.Detail = detail
+        list = append(list, e)
+    }
+    return list, rows.Err()
+}
+
+type postgresConsents struct{ *Postgres }
+
+func (p postgresConsents) Get(ctx context.Context, tenant string, customerId string, purpose string) (Consent, error) {
+    c := Consent{Tenant: tenant, CustomerId: customerId, Purpose: purpose}
+    err := p.db.QueryRowContext(ctx, `SELECT granted, updated_at, room_id, recorded_by FROM consents
+        WHERE tenant = $1 AND customer_id = $2 AND purpose = $3`, tenant, customerId, purpose).Scan(&c.Granted, &c.UpdatedAt, &c.RoomId, &c.RecordedBy)
+    if err == sql.ErrNoRows {
+        return Consent{}, ErrNotFound
+    }
+    return c, err
+}
+
+func (p postgresConsents) Put(ctx context.Context, c Consent) error {
+    _, err := p.db.ExecContext(ctx, `
+        INSERT INTO consents (tenant, customer_id, purpose, granted, updated_at, room_id, recorded_by) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (tenant, customer_id, purpose) DO UPDATE SET granted = $4, updated_at = $5, room_id = $6, recorded_by = $7`,
+        c.Tenant, c.CustomerId, c.Purpose, c.Granted, c.UpdatedAt, c.RoomId, c.RecordedBy)
+    return err
+}
+
+type postgresCallbacks struct{ *Postgres }
+
+const callbackColumns = `id, tenant, room_id, user_id, customer_id, phone, reason, detail, context, status, attempts, claimed_by, claimed_until, not_before, outcome, created_at, updated_at`
+
+func scanCallback(row interface{ Scan(...interface{}) error }) (Callback, error) {
+    var c Callback
+    var snapshot []byte
+    err := row.Scan(&c.Id, &c.Tenant, &c.RoomId, &c.UserId, &c.CustomerId, &c.Phone, &c.Reason, &c.Detail, &snapshot, &c.Status,
+        &c.Attempts, &c.ClaimedBy, &c.ClaimedUntil, &c.NotBefore, &c.Outcome, &c.CreatedAt, &c.UpdatedAt)
+    c.Context = snapshot
+    return c, err
+}
+
+func (p postgresCallbacks) Add(ctx context.Context, c Callback) error {
+    _, err := p.db.ExecContext(ctx, `INSERT INTO callbacks (`+callbackColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+        c.Id, c.Tenant, c.RoomId, c.UserId, c.CustomerId, c.Phone, c.Reason, c.Detail, nullJSON(c.Context), c.Status,
+        c.Attempts, c.ClaimedBy, c.ClaimedUntil, c.NotBefore, c.Outcome, c.CreatedAt, c.UpdatedAt)
+    return err
+}
+
+func (p postgresCallbacks) Get(ctx context.Context, id string) (Callback, error) {
+    c, err := scanCallback(p.db.QueryRowContext(ctx, `SELECT `+callbackColumns+` FROM callbacks WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return Callback{}, ErrNotFound
+    }
+    return c, err
+}
+
+func (p postgresCallbacks) List(ctx context.Context, f CallbackFilter) ([]Callback, error) {
+    limit := f.Limit
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := p.db.QueryContext(ctx, `SELECT `+callbackColumns+` FROM callbacks
+        WHERE ($1 = '' OR tenant = $1) AND ($2 = '' OR status = $2) AND ($3 = '' OR customer_id = $3)
+        ORDER BY created_at LIMIT $4`, f.Tenant, f.Status, f.CustomerId, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]Callback, 0)
+    for rows.Next() {
+        c, err := scanCallback(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, c)
+    }
+    return list, rows.Err()
+}
+
+// Claim skips rows other workers hold locked, so dialers can claim in parallel.
+func (p postgresCallbacks) Claim(ctx context.Context, tenant string, worker string, now int64, leaseUntil int64) (Callback, error) {
+    c, err := scanCallback(p.db.QueryRowContext(ctx, `UPDATE callbacks
+        SET status = 'claimed', claimed_by = $2, claimed_until = $4, attempts = attempts + 1, updated_at = $3
+        WHERE id = (
+            SELECT id FROM callbacks
+            WHERE ($1 = '' OR tenant = $1)
+                AND ((status = 'pending' AND not_before <= $3) OR (status = 'claimed' AND claimed_until < $3))
+            ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+callbackColumns, tenant, worker, now, leaseUntil))
+    if err == sql.ErrNoRows {
+        return Callback{}, ErrNotFound
+    }
+    return c, err
+}
+
+func (p postgresCallbacks) Update(ctx context.Context, c Callback) error {
+    result, err := p.db.ExecContext(ctx, `UPDATE callbacks
+        SET status = $2, claimed_by = $3, claimed_until = $4, not_before = $5, outcome = $6, updated_at = $7 WHERE id = $1`,
+        c.Id, c.Status, c.ClaimedBy, c.ClaimedUntil, c.NotBefore, c.Outcome, c.UpdatedAt)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+type postgresCampaigns struct{ *Postgres }
+
+const campaignColumns = `id, tenant, name, template, greeting, caller_id, calls_per_minute, max_concurrent, max_attempts, retry_delay_seconds, on_machine, message, callbacks, status, created_at, updated_at`
+
+func scanCampaign(row interface{ Scan(...interface{}) error }) (Campaign, error) {
+    var c Campaign
+    err := row.Scan(&c.Id, &c.Tenant, &c.Name, &c.Template, &c.Greeting, &c.From, &c.CallsPerMinute, &c.MaxConcurrent, &c.MaxAttempts,
+        &c.RetryDelaySeconds, &c.OnMachine, &c.Message, &c.Callbacks, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+    return c, err
+}
+
+func (p postgresCampaigns) Add(ctx context.Context, c Campaign) error {
+    _, err := p.db.ExecContext(ctx, `INSERT INTO campaigns (`+campaignColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+        c.Id, c.Tenant, c.Name, c.Template, c.Greeting, c.From, c.CallsPerMinute, c.MaxConcurrent, c.MaxAttempts,
+        c.RetryDelaySeconds, c.OnMachine, c.Message, c.Callbacks, c.Status, c.CreatedAt, c.UpdatedAt)
+    return err
+}
+
+func (p postgresCampaigns) Get(ctx context.Context, id string) (Campaign, error) {
+    c, err := scanCampaign(p.db.QueryRowContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return Campaign{}, ErrNotFound
+    }
+    return c, err
+}
+
+func (p postgresCampaigns) List(ctx context.Context, tenant string) ([]Campaign, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT `+campaignColumns+` FROM campaigns
+        WHERE $1 = '' OR tenant = $1 ORDER BY created_at DESC, id`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]Campaign, 0)
+    for rows.Next() {
+        c, err := scanCampaign(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, c)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresCampaigns) Update(ctx context.Context, c Campaign) error {
+    return expectRow(p.db.ExecContext(ctx, `UPDATE campaigns
+        SET name = $2, template = $3, greeting = $4, caller_id = $5, calls_per_minute = $6, max_concurrent = $7, max_attempts = $8,
+            retry_delay_seconds = $9, on_machine = $10, message = $11, callbacks = $12, status = $13, updated_at = $14
+        WHERE id = $1`,
+        c.Id, c.Name, c.Template, c.Greeting, c.From, c.CallsPerMinute, c.MaxConcurrent, c.MaxAttempts,
+        c.RetryDelaySeconds, c.OnMachine, c.Message, c.Callbacks, c.Status, c.UpdatedAt))
+}
+
+const targetColumns = `id, campaign_id, phone, customer_id, metadata, callback_id, status, attempts, not_before, room_id, outcome, detail, duration, created_at, updated_at`
+
+func scanTarget(row interface{ Scan(...interface{}) error }) (CampaignTarget, error) {
+    var t CampaignTarget
+    var metadata []byte
+    err := row.Scan(&t.Id, &t.CampaignId, &t.Phone, &t.CustomerId, &metadata, &t.CallbackId, &t.Status, &t.Attempts,
+        &t.NotBefore, &t.RoomId, &t.Outcome, &t.Detail, &t.Duration, &t.CreatedAt, &t.UpdatedAt)
+    t.Metadata = metadata
+    return t, err
+}
+
+// AddTargets inserts in one transaction so seq keeps the given order.
+func (p postgresCampaigns) AddTargets(ctx context.Context, targets []CampaignTarget) error {
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    for _, t := range targets {
+        _, err := tx.ExecContext(ctx, `INSERT INTO campaign_targets (`+targetColumns+`)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+            t.Id, t.CampaignId, t.Phone, t.CustomerId, nullJSON(t.Metadata), t.CallbackId, t.Status, t.Attempts,
+            t.NotBefore, t.RoomId, t.Outcome, t.Detail, t.Duration, t.CreatedAt, t.UpdatedAt)
+        if err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (p postgresCampaigns) Targets(ctx context.Context, campaignId string, status string, limit int) ([]CampaignTarget, error) {
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := p.db.QueryContext(ctx, `SELECT `+targetColumns+` FROM campaign_targets
+        WHERE campaign_id = $1 AND ($2 = '' OR status = $2) ORDER BY seq LIMIT $3`, campaignId, status, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]CampaignTarget, 0)
+    for rows.Next() {
+        t, err := scanTarget(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, t)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresCampaigns) Tally(ctx context.Context, campaignId string) (map[string]int, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT CASE WHEN status = 'done' THEN outcome ELSE status END, COUNT(*)
+        FROM campaign_targets WHERE campaign_id = $1 GROUP BY 1`, campaignId)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    tally := make(map[string]int)
+    for rows.Next() {
+        var key string
+        var count int
+        if err := rows.Scan(&key, &count); err != nil {
+            return nil, err
+        }
+        tally[key] = count
+    }
+    return tally, rows.Err()
+}
+
+func (p postgresCampaigns) NextTarget(ctx context.Context, campaignId string, now int64) (CampaignTarget, error) {
+    t, err := scanTarget(p.db.QueryRowContext(ctx, `UPDATE campaign_targets
+        SET status = 'dialing', attempts = attempts + 1, updated_at = $2
+        WHERE id = (
+            SELECT id FROM campaign_targets
+            WHERE campaign_id = $1 AND status = 'pending' AND not_before <= $2
+            ORDER BY seq LIMIT 1 FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+targetColumns, campaignId, now))
+    if err == sql.ErrNoRows {
+        return CampaignTarget{}, ErrNotFound
+    }
+    return t, err
+}
+
+func (p postgresCampaigns) UpdateTarget(ctx context.Context, t CampaignTarget) error {
+    return expectRow(p.db.ExecContext(ctx, `UPDATE campaign_targets
+        SET status = $2, not_before = $3, room_id = $4, outcome = $5, detail = $6, duration = $7, updated_at = $8 WHERE id = $1`,
+        t.Id, t.Status, t.NotBefore, t.RoomId, t.Outcome, t.Detail, t.Duration, t.UpdatedAt))
+}
+
+type postgresKnowledge struct{ *Postgres }
+
+const gapColumns = `id, tenant, template, key, question, answer, room_id, message_id, context, occurrences, status, reviewed_by, note, faq_id, created_at, updated_at`
+
+func scanGap(row interface{ Scan(...interface{}) error }) (KnowledgeGap, error) {
+    var g KnowledgeGap
+    var snapshot []byte
+    err := row.Scan(&g.Id, &g.Tenant, &g.Template, &g.Key, &g.Question, &g.Answer, &g.RoomId, &g.MessageId, &snapshot,
+        &g.Occurrences, &g.Status, &g.ReviewedBy, &g.Note, &g.FAQId, &g.CreatedAt, &g.UpdatedAt)
+    g.Context = snapshot
+    return g, err
+}
+
+// RecordGap relies on the partial unique index over open gaps, so servers
+// racing on the same question count it once each.
+func (p postgresKnowledge) RecordGap(ctx context.Context, g KnowledgeGap) (KnowledgeGap, error) {
+    return scanGap(p.db.QueryRowContext(ctx, `INSERT INTO knowledge_gaps (`+gapColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+        ON CONFLICT (tenant, key) WHERE status = 'open' DO UPDATE
+        SET occurrences = knowledge_gaps.occurrences + 1, room_id = $7, message_id = $8, context = $9, updated_at = $16
+        RETURNING `+gapColumns,
+        g.Id, g.Tenant, g.Template, g.Key, g.Question, g.Answer, g.RoomId, g.MessageId, nullJSON(g.Context),
+        g.Occurrences, g.Status, g.ReviewedBy, g.Note, g.FAQId, g.CreatedAt, g.UpdatedAt))
+}
+
+func (p postgresKnowledge) GetGap(ctx context.Context, id string) (KnowledgeGap, error) {
+    g, err := scanGap(p.db.QueryRowContext(ctx, `SELECT `+gapColumns+` FROM knowledge_gaps WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return KnowledgeGap{}, ErrNotFound
+    }
+    return g, err
+}
+
+func (p postgresKnowledge) ListGaps(ctx context.Context, f GapFilter) ([]KnowledgeGap, error) {
+    limit := f.Limit
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := p.db.QueryContext(ctx, `SELECT `+gapColumns+` FROM knowledge_gaps
+        WHERE ($1 = '' OR tenant = $1) AND ($2 = '' OR status = $2)
+        ORDER BY occurrences DESC, created_at LIMIT $3`, f.Tenant, f.Status, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]KnowledgeGap, 0)
+    for rows.Next() {
+        g, err := scanGap(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, g)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresKnowledge) ReviewGap(ctx context.Context, g KnowledgeGap) error {
+    result, err := p.db.ExecContext(ctx, `UPDATE knowledge_gaps
+        SET status = $2, reviewed_by = $3, note = $4, faq_id = $5, updated_at = $6 WHERE id = $1`,
+        g.Id, g.Status, g.ReviewedBy, g.Note, g.FAQId, g.UpdatedAt)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func (p postgresKnowledge) AddFAQ(ctx context.Context, e FAQEntry) error {
+    _, err := p.db.ExecContext(ctx, `INSERT INTO faq_entries (id, tenant, question, answer, gap_id, created_by, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`, e.Id, e.Tenant, e.Question, e.Answer, e.GapId, e.CreatedBy, e.CreatedAt)
+    return err
+}
+
+func (p postgresKnowledge) ListFAQ(ctx context.Context, tenant string) ([]FAQEntry, error) {
+    rows, err := p.db.QueryContext(ctx, `SELECT id, tenant, question, answer, gap_id, created_by, created_at FROM faq_entries
+        WHERE ($1 = '' OR tenant = $1) ORDER BY created_at`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]FAQEntry, 0)
+    for rows.Next() {
+        var e FAQEntry
+        if err := rows.Scan(&e.Id, &e.Tenant, &e.Question, &e.Answer, &e.GapId, &e.CreatedBy, &e.CreatedAt); err != nil {
+            return nil, err
+        }
+        list = append(list, e)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresKnowledge) DeleteFAQ(ctx context.Context, id string) error {
+    result, err := p.db.ExecContext(ctx, `DELETE FROM faq_entries WHERE id = $1`, id)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+const factColumns = `id, tenant, room_id, message_id, source, subject, predicate, object, previous, confidence, category, partition, status, reason, reviewed_by, note, created_at, updated_at`
+
+func scanFact(row interface{ Scan(...interface{}) error }) (KnowledgeFact, error) {
+    var f KnowledgeFact
+    err := row.Scan(&f.Id, &f.Tenant, &f.RoomId, &f.MessageId, &f.Source, &f.Subject, &f.Predicate, &f.Object, &f.Previous,
+        &f.Confidence, &f.Category, &f.Partition, &f.Status, &f.Reason, &f.ReviewedBy, &f.Note, &f.CreatedAt, &f.UpdatedAt)
+    return f, err
+}
+
+func (p postgresKnowledge) AddFact(ctx context.Context, f KnowledgeFact) error {
+    _, err := p.db.ExecContext(ctx, `INSERT INTO knowledge_facts (`+factColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+        f.Id, f.Tenant, f.RoomId, f.MessageId, f.Source, f.Subject, f.Predicate, f.Object, f.Previous,
+        f.Confidence, f.Category, f.Partition, f.Status, f.Reason, f.ReviewedBy, f.Note, f.CreatedAt, f.UpdatedAt)
+    return err
+}
+
+func (p postgresKnowledge) GetFact(ctx context.Context, id string) (KnowledgeFact, error) {
+    f, err := scanFact(p.db.QueryRowContext(ctx, `SELECT `+factColumns+` FROM knowledge_facts WHERE id = $1`, id))
+    if err == sql.ErrNoRows {
+        return KnowledgeFact{}, ErrNotFound
+    }
+    return f, err
+}
+
+func (p postgresKnowledge) ListFacts(ctx context.Context, f FactFilter) ([]KnowledgeFact, error) {
+    limit := f.Limit
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := p.db.QueryContext(ctx, `SELECT `+factColumns+` FROM knowledge_facts
+        WHERE ($1 = '' OR tenant = $1) AND ($2 = '' OR status = $2) AND ($3 = '' OR room_id = $3)
+        ORDER BY created_at LIMIT $4`, f.Tenant, f.Status, f.RoomId, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    list := make([]KnowledgeFact, 0)
+    for rows.Next() {
+        fact, err := scanFact(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, fact)
+    }
+    return list, rows.Err()
+}
+
+func (p postgresKnowledge) ReviewFact(ctx context.Context, f KnowledgeFact) error {
+    result, err := p.db.ExecContext(ctx, `UPDATE knowledge_facts
+        SET status = $2, reviewed_by = $3, note = $4, updated_at = $5 WHERE id = $1 AND status = 'pending'`,
+        f.Id, f.Status, f.ReviewedBy, f.Note, f.UpdatedAt)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
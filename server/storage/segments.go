@@ -0,0 +1,24 @@
+package storage
+
+import "context"
+
+// Segment is a topical section of a call's transcript, from StartMessageId
+// to EndMessageId inclusive, labelled for reviewers ("billing dispute").
+type Segment struct {
+    RoomId         string   `json:"roomId"`
+    Index          int      `json:"index"`
+    StartMessageId string   `json:"startMessageId"`
+    EndMessageId   string   `json:"endMessageId"`
+    StartedAt      int64    `json:"startedAt"` // Unix milliseconds
+    EndedAt        int64    `json:"endedAt"`
+    Label          string   `json:"label"`
+    Keywords       []string `json:"keywords,omitempty"`
+    Source         string   `json:"source"` // What produced the boundaries and labels
+}
+
+type SegmentStore interface {
+    // Replace swaps a room's segments for a new segmentation.
+    Replace(ctx context.Context, roomId string, segments []Segment) error
+    // List returns a room's segments in order.
+    List(ctx context.Context, roomId string) ([]Segment, error)
+}
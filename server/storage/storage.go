@@ -0,0 +1,188 @@
+// Package storage holds the server's durable data: room records, chat
+// transcripts, call detail records, recording metadata, knowledge graph
+// provenance, analytics rollups, speech recognition phrase hints, topic
+// segments, the audit log, customer consents, the callback queue, outbound
+// campaigns, knowledge gaps with the FAQ entries curated from them, and the
+// knowledge graph facts extracted from calls. Live connection state stays in
+// memory in package main.
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "strings"
+)
+
+var ErrNotFound = errors.New("storage: not found")
+
+type Room struct {
+    Id        string `json:"id"`
+    Tenant    string `json:"tenant,omitempty"`
+    Template  string `json:"template,omitempty"`
+    CreatedAt int64  `json:"createdAt"`          // Unix milliseconds
+    ClosedAt  int64  `json:"closedAt,omitempty"` // Zero while the room is open
+}
+
+// TranscriptEntry is one chat message as the room saw it. Audience is all,
+// agents, users or direct (only From and To).
+type TranscriptEntry struct {
+    RoomId    string          `json:"roomId"`
+    Tenant    string          `json:"tenant,omitempty"`
+    MessageId string          `json:"id"`
+    Type      string          `json:"type"`
+    From      string          `json:"from"`
+    To        []string        `json:"to,omitempty"`
+    Audience  string          `json:"audience"`
+    Data      json.RawMessage `json:"data,omitempty"`
+    Metadata  json.RawMessage `json:"metadata,omitempty"`
+    Citations json.RawMessage `json:"citations,omitempty"` // Sources of an assistant_final answer
+    Timestamp int64           `json:"timestamp"`
+}
+
+type CDRParticipant struct {
+    ClientId   string `json:"clientId"`
+    ClientType string `json:"clientType"`
+    Role       string `json:"role,omitempty"`
+    JoinedAt   int64  `json:"joinedAt"`
+    LeftAt     int64  `json:"leftAt,omitempty"`
+}
+
+// CDRDisposition is an agent's wrap-up of a call: what came of it and any
+// notes.
+type CDRDisposition struct {
+    ClientId    string `json:"clientId"`
+    AgentId     string `json:"agentId,omitempty"`
+    Code        string `json:"code"`
+    Notes       string `json:"notes,omitempty"`
+    SubmittedAt int64  `json:"submittedAt"`
+}
+
+// CDR is the call detail record written when a room closes.
+type CDR struct {
+    Id           string           `json:"id"`
+    RoomId       string           `json:"roomId"`
+    Tenant       string           `json:"tenant,omitempty"`
+    Template     string           `json:"template,omitempty"`
+    StartedAt    int64            `json:"startedAt"`
+    EndedAt      int64            `json:"endedAt"`
+    Participants []CDRParticipant `json:"participants"`
+    Dispositions []CDRDisposition `json:"dispositions,omitempty"`
+    Variants     map[string]string `json:"variants,omitempty"` // Experiment to the variant the call was in
+    Quality      *CDRQuality       `json:"quality,omitempty"`  // Set once the call has been scored
+    Cost         *CDRCost          `json:"cost,omitempty"`     // Estimated spend, set when the call ends
+    KGSnapshot   string            `json:"kgSnapshot,omitempty"` // Knowledge graph snapshot the call was pinned to
+}
+
+// CDRCost is a call's estimated spend in USD, from the configured prices.
+type CDRCost struct {
+    STT       float64 `json:"stt"`
+    TTS       float64 `json:"tts"`
+    LLM       float64 `json:"llm"`
+    Telephony float64 `json:"telephony"`
+    Total     float64 `json:"total"`
+}
+
+// CDRQuality is the post-call grade of a call, each score 1 to 5. Accuracy is
+// 0 when no answer cited the knowledge graph.
+type CDRQuality struct {
+    Resolution float64 `json:"resolution"`
+    Accuracy   float64 `json:"accuracy"`
+    Tone       float64 `json:"tone"`
+    Overall    float64 `json:"overall"`
+    Notes      string  `json:"notes,omitempty"`
+    Model      string  `json:"model"`
+    ScoredAt   int64   `json:"scoredAt"`
+}
+
+type Recording struct {
+    Id        string `json:"id"`
+    RoomId    string `json:"roomId"`
+    Tenant    string `json:"tenant,omitempty"`
+    StartedBy string `json:"startedBy"`
+    StartedAt int64  `json:"startedAt"`
+    StoppedAt int64  `json:"stoppedAt,omitempty"`
+    URI       string `json:"uri,omitempty"` // Where the recording agent stored the media
+}
+
+// Provenance ties knowledge graph facts to the conversation they came from.
+type Provenance struct {
+    Id        string   `json:"id"`
+    RoomId    string   `json:"roomId"`
+    MessageId string   `json:"messageId,omitempty"`
+    Source    string   `json:"source"`  // Agent or pipeline that produced the facts
+    NodeIds   []string `json:"nodeIds"` // Graph nodes or edges created or touched
+    CreatedAt int64    `json:"createdAt"`
+}
+
+type RoomStore interface {
+    Create(ctx context.Context, room Room) error
+    Close(ctx context.Context, roomId string, closedAt int64) error
+    Get(ctx context.Context, roomId string) (Room, error)
+}
+
+// RetentionFunc returns how many entries a tenant keeps per room and the
+// Unix millisecond timestamp older entries are dropped at (zero keeps all).
+type RetentionFunc func(tenant string) (maxEntries int, cutoff int64)
+
+type TranscriptStore interface {
+    // Append stores entry. The room is trimmed to the newest maxEntries
+    // right away or, where that costs a query per message, by Prune.
+    Append(ctx context.Context, entry TranscriptEntry, maxEntries int) error
+    // List returns up to limit entries newest first, only those with IDs
+    // before the given cursor when it isn't empty.
+    List(ctx context.Context, roomId string, before string, limit int) ([]TranscriptEntry, error)
+    Prune(ctx context.Context, retention RetentionFunc) error
+}
+
+type CDRStore interface {
+    Save(ctx context.Context, cdr CDR) error
+    Get(ctx context.Context, id string) (CDR, error)
+    // List returns a tenant's records that started within [since, until).
+    List(ctx context.Context, tenant string, since int64, until int64) ([]CDR, error)
+    // Dispose adds a disposition to a saved record.
+    Dispose(ctx context.Context, id string, disposition CDRDisposition) error
+    // Score sets a saved record's quality, replacing any earlier score.
+    Score(ctx context.Context, id string, quality CDRQuality) error
+}
+
+type RecordingStore interface {
+    Start(ctx context.Context, rec Recording) error
+    Stop(ctx context.Context, id string, stoppedAt int64) error
+    ListByRoom(ctx context.Context, roomId string) ([]Recording, error)
+}
+
+type ProvenanceStore interface {
+    Add(ctx context.Context, p Provenance) error
+    ListByRoom(ctx context.Context, roomId string) ([]Provenance, error)
+}
+
+type Store interface {
+    Rooms() RoomStore
+    Transcripts() TranscriptStore
+    CDRs() CDRStore
+    Recordings() RecordingStore
+    Provenance() ProvenanceStore
+    Analytics() AnalyticsStore
+    Phrases() PhraseStore
+    Segments() SegmentStore
+    Audit() AuditStore
+    Consents() ConsentStore
+    Callbacks() CallbackStore
+    Campaigns() CampaignStore
+    Knowledge() KnowledgeStore
+    Lexicon() LexiconStore
+    Close() error
+}
+
+// Open picks the implementation from the DSN: empty for in-memory,
+// postgres:// or postgresql:// for Postgres.
+func Open(dsn string) (Store, error) {
+    if dsn == "" {
+        return NewMemory(), nil
+    }
+    if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+        return OpenPostgres(dsn)
+    }
+    return nil, errors.New("storage: unsupported DSN, expected postgres://")
+}
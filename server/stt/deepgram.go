@@ -0,0 +1,209 @@
+package stt
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+)
+
+// Deepgram streams audio over Deepgram's live WebSocket API and relays its
+// interim and final results as they arrive.
+type Deepgram struct {
+    URL    string // Defaults to wss://api.deepgram.com/v1/listen
+    APIKey string
+    Model  string // e.g. nova-2; empty uses the account default
+}
+
+func (d *Deepgram) Name() string {
+    return "deepgram"
+}
+
+func (d *Deepgram) NewSession(ctx context.Context, opts Options) (Session, error) {
+    query := url.Values{
+        "encoding":        {"linear16"},
+        "sample_rate":     {strconv.Itoa(opts.SampleRate)},
+        "channels":        {strconv.Itoa(opts.Channels)},
+        "interim_results": {"true"},
+        "punctuate":       {"true"},
+    }
+    if d.Model != "" {
+        query.Set("model", d.Model)
+    }
+    if opts.Language != "" {
+        query.Set("language", opts.Language)
+    }
+    for _, hint := range opts.Hints {
+        if hint.Boost != 0 {
+            query.Add("keywords", hint.Phrase+":"+strconv.FormatFloat(hint.Boost, 'g', -1, 64))
+        } else {
+            query.Add("keywords", hint.Phrase)
+        }
+    }
+    
+    endpoint := d.URL
+    if endpoint == "" {
+        endpoint = "wss://api.deepgram.com/v1/listen"
+    }
+    header := http.Header{"Authorization": {"Token " + d.APIKey}}
+    conn, resp, err := websocket.DefaultDialer.DialContext(ctx, endpoint+"?"+query.Encode(), header)
+    if err != nil {
+        if resp != nil {
+            return nil, fmt.Errorf("deepgram: %v (%s)", err, resp.Status)
+        }
+        return nil, fmt.Errorf("deepgram: %v", err)
+    }
+    
+    s := &deepgramSession{
+        conn:  conn,
+        opts:  opts,
+        audio: make(chan []byte, 256),
+        read:  make(chan struct{}),
+        wrote: make(chan struct{}),
+    }
+    go s.writeLoop()
+    go s.readLoop()
+    return s, nil
+}
+
+type deepgramSession struct {
+    conn   *websocket.Conn
+    opts   Options
+    mu     sync.Mutex // Guards closed and sends on audio
+    closed bool
+    audio  chan []byte
+    read   chan struct{} // Closed when the reader exits
+    wrote  chan struct{} // Closed when the writer exits
+}
+
+func (s *deepgramSession) Write(pcm []byte) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.closed {
+        return ErrClosed
+    }
+    select {
+    case s.audio <- append([]byte(nil), pcm...):
+        return nil
+    default:
+        return ErrBehind
+    }
+}
+
+// writeLoop owns writes on the connection. Deepgram hangs up after about ten
+// seconds without data, so quiet stretches are bridged with KeepAlive.
+func (s *deepgramSession) writeLoop() {
+    defer close(s.wrote)
+    keepAlive := time.NewTicker(5 * time.Second)
+    defer keepAlive.Stop()
+    
+    for {
+        select {
+        case pcm, ok := <-s.audio:
+            if !ok {
+                s.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"CloseStream"}`))
+                return
+            }
+            if err := s.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+                s.fail(err)
+                return
+            }
+            keepAlive.Reset(5 * time.Second)
+        case <-keepAlive.C:
+            s.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"KeepAlive"}`))
+        case <-s.read:
+            return
+        }
+    }
+}
+
+func (s *deepgramSession) readLoop() {
+    defer close(s.read)
+    for {
+        _, data, err := s.conn.ReadMessage()
+        if err != nil {
+            if !websocket.IsCloseError(err, websocket.CloseNormalClosure) && !s.isClosed() {
+                s.fail(err)
+            }
+            return
+        }
+        
+        var msg struct {
+            Type     string  `json:"type"`
+            IsFinal  bool    `json:"is_final"`
+            Start    float64 `json:"start"`
+            Duration float64 `json:"duration"`
+            Channel  struct {
+                Alternatives []struct {
+                    Transcript string   `json:"transcript"`
+                    Confidence float64  `json:"confidence"`
+                    Languages  []string `json:"languages"`
+                } `json:"alternatives"`
+            } `json:"channel"`
+        }
+        if json.Unmarshal(data, &msg) != nil || msg.Type != "Results" || len(msg.Channel.Alternatives) == 0 {
+            continue
+        }
+        best := msg.Channel.Alternatives[0]
+        if strings.TrimSpace(best.Transcript) == "" {
+            continue
+        }
+        
+        result := Result{
+            Text:       strings.TrimSpace(best.Transcript),
+            Final:      msg.IsFinal,
+            Confidence: best.Confidence,
+            Language:   s.opts.Language,
+            Start:      seconds(msg.Start),
+            End:        seconds(msg.Start + msg.Duration),
+        }
+        if len(best.Languages) > 0 {
+            result.Language = best.Languages[0]
+        }
+        if s.opts.OnResult != nil {
+            s.opts.OnResult(result)
+        }
+    }
+}
+
+func (s *deepgramSession) isClosed() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.closed
+}
+
+func (s *deepgramSession) fail(err error) {
+    if s.opts.OnError != nil {
+        s.opts.OnError(fmt.Errorf("deepgram: %v", err))
+    }
+}
+
+// Close asks Deepgram to flush and waits briefly for the final results.
+func (s *deepgramSession) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    close(s.audio)
+    s.mu.Unlock()
+    
+    <-s.wrote
+    select {
+    case <-s.read:
+    case <-time.After(5 * time.Second):
+    }
+    return s.conn.Close()
+}
+
+func seconds(f float64) time.Duration {
+    return time.Duration(f * float64(time.Second))
+}
@@ -0,0 +1,113 @@
+package stt
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// Google uses the Cloud Speech-to-Text REST recognize method. Streaming
+// recognition is gRPC only, so utterances are endpointed locally like Whisper.
+type Google struct {
+    URL         string // Defaults to https://speech.googleapis.com/v1p1beta1
+    APIKey      string
+    Model       string // e.g. latest_short, phone_call; empty uses the default
+    Endpointing Endpointing
+    Client      *http.Client
+}
+
+func (g *Google) Name() string {
+    return "google"
+}
+
+func (g *Google) NewSession(ctx context.Context, opts Options) (Session, error) {
+    if opts.Language == "" {
+        opts.Language = "en-US" // Required by the API
+    }
+    return Utterances(ctx, g.recognize, g.Endpointing, opts), nil
+}
+
+func (g *Google) recognize(ctx context.Context, pcm []byte, opts Options) (Result, error) {
+    config := map[string]interface{}{
+        "encoding":                   "LINEAR16",
+        "sampleRateHertz":            opts.SampleRate,
+        "audioChannelCount":          opts.Channels,
+        "languageCode":               opts.Language,
+        "enableAutomaticPunctuation": true,
+    }
+    if len(opts.Alternates) > 0 {
+        config["alternativeLanguageCodes"] = opts.Alternates
+    }
+    if len(opts.Hints) > 0 {
+        // Boost is per context, so group phrases sharing one
+        var contexts []map[string]interface{}
+        byBoost := make(map[float64]int)
+        for _, hint := range opts.Hints {
+            i, ok := byBoost[hint.Boost]
+            if !ok {
+                i = len(contexts)
+                byBoost[hint.Boost] = i
+                contexts = append(contexts, map[string]interface{}{"phrases": []string{}})
+                if hint.Boost != 0 {
+                    contexts[i]["boost"] = hint.Boost
+                }
+            }
+            contexts[i]["phrases"] = append(contexts[i]["phrases"].([]string), hint.Phrase)
+        }
+        config["speechContexts"] = contexts
+    }
+    if g.Model != "" {
+        config["model"] = g.Model
+    }
+    body, _ := json.Marshal(map[string]interface{}{
+        "config": config,
+        "audio":  map[string]string{"content": base64.StdEncoding.EncodeToString(pcm)},
+    })
+    
+    base := g.URL
+    if base == "" {
+        base = "https://speech.googleapis.com/v1p1beta1"
+    }
+    endpoint := strings.TrimRight(base, "/") + "/speech:recognize?key=" + url.QueryEscape(g.APIKey)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+    if err != nil {
+        return Result{}, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    
+    var response struct {
+        Results []struct {
+            Alternatives []struct {
+                Transcript string  `json:"transcript"`
+                Confidence float64 `json:"confidence"`
+            } `json:"alternatives"`
+            LanguageCode string `json:"languageCode"`
+        } `json:"results"`
+    }
+    if err := doJSON(g.Client, req, &response); err != nil {
+        return Result{}, fmt.Errorf("google: %v", err)
+    }
+    
+    // Long utterances come back as several consecutive results
+    var result Result
+    var texts []string
+    for _, r := range response.Results {
+        if len(r.Alternatives) == 0 {
+            continue
+        }
+        texts = append(texts, strings.TrimSpace(r.Alternatives[0].Transcript))
+        if r.Alternatives[0].Confidence > result.Confidence {
+            result.Confidence = r.Alternatives[0].Confidence
+        }
+        if r.LanguageCode != "" {
+            result.Language = r.LanguageCode
+        }
+    }
+    result.Text = strings.Join(texts, " ")
+    return result, nil
+}
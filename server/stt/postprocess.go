@@ -0,0 +1,157 @@
+package stt
+
+import (
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// Post-processing applied to every provider's output before it reaches
+// agents: partial stabilization, punctuation and casing for unpunctuated
+// output, and custom vocabulary correction.
+
+// Stabilizer turns a stream of partial hypotheses into edits, so clients
+// redraw only the part of the line that changed. Use one per speaker.
+type Stabilizer struct {
+    last []string // Words of the last emitted partial
+}
+
+// Edit says how to turn the previous partial into the new one:
+// previous[:ReplaceFrom] + Suffix.
+type Edit struct {
+    ReplaceFrom int    // Byte offset into the previous partial's text
+    Suffix      string // Replaces everything from ReplaceFrom
+    Stable      int    // Leading words unchanged since the previous partial
+}
+
+// Partial returns the edit for a new partial, or false when nothing changed.
+func (s *Stabilizer) Partial(text string) (Edit, bool) {
+    words := strings.Fields(text)
+    common := 0
+    for common < len(words) && common < len(s.last) && words[common] == s.last[common] {
+        common++
+    }
+    if common == len(words) && common == len(s.last) {
+        return Edit{}, false
+    }
+    
+    edit := Edit{Stable: common}
+    if common > 0 {
+        edit.ReplaceFrom = len(strings.Join(s.last[:common], " "))
+        if common < len(words) {
+            edit.Suffix = " "
+        }
+    }
+    edit.Suffix += strings.Join(words[common:], " ")
+    s.last = words
+    return edit, true
+}
+
+// Final ends the utterance; the next partial starts a new line.
+func (s *Stabilizer) Final() {
+    s.last = nil
+}
+
+var (
+    questionStarts = map[string]bool{
+        "who": true, "what": true, "when": true, "where": true, "why": true, "how": true, "which": true,
+        "is": true, "are": true, "can": true, "could": true, "do": true, "does": true, "did": true,
+        "will": true, "would": true, "should": true, "may": true, "have": true, "has": true,
+    }
+    standaloneI = regexp.MustCompile(`\bi\b('[a-z]+)?`)
+)
+
+// Punctuate adds sentence casing and a terminal mark to text a provider
+// returned without any punctuation. Already punctuated text is only trimmed,
+// vendor punctuation always beats this heuristic.
+func Punctuate(text string) string {
+    text = strings.TrimSpace(text)
+    if text == "" || strings.ContainsAny(text, ".,?!;:") {
+        return text
+    }
+    
+    text = standaloneI.ReplaceAllStringFunc(text, func(match string) string {
+        return "I" + match[1:]
+    })
+    runes := []rune(text)
+    runes[0] = unicode.ToUpper(runes[0])
+    text = string(runes)
+    
+    first := strings.ToLower(strings.Fields(text)[0])
+    if questionStarts[first] {
+        return text + "?"
+    }
+    return text + "."
+}
+
+// Term is one custom vocabulary entry. SoundsLike lists known mishearings
+// ("zeffer pro" for "Zephyr Pro") that post-correction rewrites to Text.
+type Term struct {
+    Text       string
+    Boost      float64
+    SoundsLike []string
+}
+
+// Vocabulary corrects transcripts towards a tenant's product and domain
+// terms, however the recognizer split or cased them ("acme cloud",
+// "Acme-Cloud" and "acmecloud" all become "AcmeCloud"), and rewrites their
+// known mishearings. The terms are also passed to providers as recognition
+// hints; correction still runs for every provider, which is what makes the
+// vocabulary work with vendors that ignore hints.
+type Vocabulary struct {
+    hints    []Hint
+    terms    []string // Replacement for each pattern
+    patterns []*regexp.Regexp
+}
+
+func NewVocabulary(terms []Term) *Vocabulary {
+    v := &Vocabulary{}
+    for _, term := range terms {
+        pattern := vocabularyPattern(term.Text)
+        if pattern == nil {
+            continue
+        }
+        v.hints = append(v.hints, Hint{Phrase: term.Text, Boost: term.Boost})
+        v.terms = append(v.terms, term.Text)
+        v.patterns = append(v.patterns, pattern)
+        for _, alias := range term.SoundsLike {
+            if pattern := vocabularyPattern(alias); pattern != nil {
+                v.terms = append(v.terms, term.Text)
+                v.patterns = append(v.patterns, pattern)
+            }
+        }
+    }
+    return v
+}
+
+// vocabularyPattern matches the term's letters in order, allowing spaces or
+// hyphens between any of them, case insensitively and on word boundaries.
+func vocabularyPattern(term string) *regexp.Regexp {
+    var letters []string
+    for _, r := range term {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            letters = append(letters, regexp.QuoteMeta(string(r)))
+        }
+    }
+    if len(letters) < 2 {
+        return nil
+    }
+    return regexp.MustCompile(`(?i)\b` + strings.Join(letters, `[\s-]?`) + `\b`)
+}
+
+func (v *Vocabulary) Hints() []Hint {
+    if v == nil {
+        return nil
+    }
+    return v.hints
+}
+
+func (v *Vocabulary) Apply(text string) string {
+    if v == nil {
+        return text
+    }
+    for i, pattern := range v.patterns {
+        text = pattern.ReplaceAllLiteralString(text, v.terms[i])
+    }
+    return text
+}
@@ -0,0 +1,43 @@
+package stt
+
+import (
+    "context"
+    "sync"
+)
+
+// Script stands in for a vendor in tests and evaluation runs. Utterances are
+// endpointed like Whisper's, and each is recognized as the next line queued
+// with Say, so a test decides what the server hears without any recognition
+// happening. An utterance with nothing queued is recognized as "".
+type Script struct {
+    Endpointing Endpointing // Partials must stay zero, every recognition takes a line
+    
+    mu    sync.Mutex
+    lines []string
+}
+
+func (s *Script) Name() string {
+    return "script"
+}
+
+// Say queues the text of the next utterance, from any session.
+func (s *Script) Say(text string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.lines = append(s.lines, text)
+}
+
+func (s *Script) NewSession(ctx context.Context, opts Options) (Session, error) {
+    return Utterances(ctx, s.recognize, s.Endpointing, opts), nil
+}
+
+func (s *Script) recognize(ctx context.Context, pcm []byte, opts Options) (Result, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    result := Result{Confidence: 1, Language: opts.Language}
+    if len(s.lines) > 0 {
+        result.Text = s.lines[0]
+        s.lines = s.lines[1:]
+    }
+    return result, nil
+}
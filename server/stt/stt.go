@@ -0,0 +1,136 @@
+// Package stt abstracts speech-to-text vendors behind one streaming session
+// interface. Audio goes in as pcm16 and partial and final results come back
+// through callbacks, whether the vendor streams natively (Deepgram) or
+// recognizes whole utterances (Whisper, Google), which Utterances adapts.
+package stt
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+var (
+    ErrClosed = errors.New("stt: session closed")
+    ErrBehind = errors.New("stt: provider fell behind, audio dropped")
+)
+
+// Result is one hypothesis for a stretch of the session's audio. Partials may
+// be revised by later results; a final is never revised.
+type Result struct {
+    Text       string
+    Final      bool
+    Confidence float64       // 0..1, zero when the vendor doesn't report it
+    Language   string        // Detected or hinted BCP-47 tag
+    Start      time.Duration // Offsets within the session's audio
+    End        time.Duration
+    Latency    time.Duration // From writing the audio at End to the result, set by Open
+}
+
+// Hint is a phrase recognition should favour. Boost is vendor scaled and
+// ignored by vendors without weighting; zero leaves the vendor default.
+type Hint struct {
+    Phrase string
+    Boost  float64
+}
+
+type Options struct {
+    SampleRate int
+    Channels   int
+    Language   string   // BCP-47 hint, empty lets the vendor detect
+    Alternates []string // Other languages the speaker may use
+    Hints      []Hint   // Phrases to bias recognition towards, where supported
+    
+    // Callbacks run on the session's own goroutine, in order, and must not block.
+    OnResult func(Result)
+    OnError  func(error)
+}
+
+// Session accepts a stream of pcm16 audio. Write must not block on the
+// network; Close flushes pending audio and returns once every result has
+// been delivered.
+type Session interface {
+    Write(pcm []byte) error
+    Close() error
+}
+
+type Provider interface {
+    Name() string
+    NewSession(ctx context.Context, opts Options) (Session, error)
+}
+
+// Open starts a session on p and fills in Result.Latency, measured from when
+// the audio a result ends at was written.
+func Open(ctx context.Context, p Provider, opts Options) (Session, error) {
+    t := &timeline{format: opts}
+    onResult := opts.OnResult
+    opts.OnResult = func(r Result) {
+        r.Latency = t.since(r.End)
+        if onResult != nil {
+            onResult(r)
+        }
+    }
+    
+    session, err := p.NewSession(ctx, opts)
+    if err != nil {
+        return nil, err
+    }
+    return &timedSession{Session: session, timeline: t}, nil
+}
+
+// timeline remembers when each stretch of audio was written.
+type timeline struct {
+    format  Options
+    mu      sync.Mutex
+    written time.Duration
+    marks   []mark
+}
+
+type mark struct {
+    offset time.Duration
+    at     time.Time
+}
+
+func (t *timeline) add(n int) {
+    bytesPerSecond := t.format.SampleRate * t.format.Channels * 2
+    if bytesPerSecond == 0 {
+        return
+    }
+    
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.written += time.Duration(int64(n) * int64(time.Second) / int64(bytesPerSecond))
+    t.marks = append(t.marks, mark{offset: t.written, at: time.Now()})
+    // Results never lag by minutes, so drop marks older than that
+    for len(t.marks) > 1 && time.Since(t.marks[0].at) > 2*time.Minute {
+        t.marks = t.marks[1:]
+    }
+}
+
+func (t *timeline) since(offset time.Duration) time.Duration {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, m := range t.marks {
+        if m.offset >= offset {
+            return time.Since(m.at)
+        }
+    }
+    if len(t.marks) > 0 {
+        return time.Since(t.marks[len(t.marks)-1].at)
+    }
+    return 0
+}
+
+type timedSession struct {
+    Session
+    timeline *timeline
+}
+
+func (s *timedSession) Write(pcm []byte) error {
+    if err := s.Session.Write(pcm); err != nil {
+        return err
+    }
+    s.timeline.add(len(pcm))
+    return nil
+}
@@ -0,0 +1,200 @@
+package stt
+
+import (
+    "context"
+    "encoding/binary"
+    "math"
+    "sync"
+    "time"
+)
+
+// Recognizer transcribes one complete utterance of pcm16 audio.
+type Recognizer func(ctx context.Context, pcm []byte, opts Options) (Result, error)
+
+// Endpointing decides where utterances start and end for vendors without a
+// streaming API. Speech is any 20ms frame louder than Threshold RMS (0..1).
+type Endpointing struct {
+    Threshold float64
+    Silence   time.Duration // Quiet that ends an utterance
+    MaxLength time.Duration // Longest utterance before it is cut anyway
+    Partials  time.Duration // Re-recognize the growing utterance this often, zero disables partials
+}
+
+var DefaultEndpointing = Endpointing{
+    Threshold: 0.01,
+    Silence:   700 * time.Millisecond,
+    MaxLength: 30 * time.Second,
+}
+
+// Utterances turns a Recognizer into a streaming Session: audio is buffered
+// until the speaker pauses, then the utterance is recognized on the session
+// goroutine. Writes never wait for the vendor; when it falls behind by more
+// than a few utterances the oldest pending audio is dropped and reported.
+func Utterances(ctx context.Context, recognize Recognizer, e Endpointing, opts Options) Session {
+    ctx, cancel := context.WithCancel(ctx)
+    s := &utteranceSession{
+        ctx:       ctx,
+        cancel:    cancel,
+        recognize: recognize,
+        e:         e,
+        opts:      opts,
+        jobs:      make(chan utteranceJob, 4),
+        done:      make(chan struct{}),
+    }
+    go s.run()
+    return s
+}
+
+type utteranceJob struct {
+    pcm   []byte
+    start time.Duration
+    final bool
+}
+
+type utteranceSession struct {
+    ctx       context.Context
+    cancel    context.CancelFunc
+    recognize Recognizer
+    e         Endpointing
+    opts      Options
+    
+    mu          sync.Mutex // Guards the fields below
+    closed      bool
+    buf         []byte        // Current utterance
+    start       time.Duration // Offset of buf in the session's audio
+    offset      time.Duration // Audio written so far
+    quiet       time.Duration // Trailing silence in buf
+    lastPartial time.Duration
+    
+    jobs chan utteranceJob
+    done chan struct{}
+}
+
+func (s *utteranceSession) bytesPerSecond() int {
+    return s.opts.SampleRate * s.opts.Channels * 2
+}
+
+func (s *utteranceSession) duration(n int) time.Duration {
+    return time.Duration(int64(n) * int64(time.Second) / int64(s.bytesPerSecond()))
+}
+
+func (s *utteranceSession) Write(pcm []byte) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.closed {
+        return ErrClosed
+    }
+    
+    d := s.duration(len(pcm))
+    s.offset += d
+    speech := rms(pcm) >= s.e.Threshold
+    if len(s.buf) == 0 && !speech {
+        return nil // Not in an utterance yet
+    }
+    if len(s.buf) == 0 {
+        s.start = s.offset - d
+        s.lastPartial = 0
+    }
+    s.buf = append(s.buf, pcm...)
+    if speech {
+        s.quiet = 0
+    } else {
+        s.quiet += d
+    }
+    
+    length := s.duration(len(s.buf))
+    switch {
+    case s.quiet >= s.e.Silence || (s.e.MaxLength > 0 && length >= s.e.MaxLength):
+        s.flush(true)
+    case s.e.Partials > 0 && length-s.lastPartial >= s.e.Partials:
+        s.lastPartial = length
+        s.flush(false)
+    }
+    return nil
+}
+
+// flush must be called with mu held. Finals own the buffer; partials get a copy.
+func (s *utteranceSession) flush(final bool) {
+    job := utteranceJob{pcm: s.buf, start: s.start, final: final}
+    if final {
+        s.buf, s.quiet = nil, 0
+    } else {
+        job.pcm = append([]byte(nil), s.buf...)
+    }
+    
+    select {
+    case s.jobs <- job:
+    default:
+        if final {
+            // Make room by discarding the oldest job, keeping finals in order
+            select {
+            case <-s.jobs:
+            default:
+            }
+            s.jobs <- job
+            s.report(ErrBehind)
+        }
+    }
+}
+
+func (s *utteranceSession) report(err error) {
+    if s.opts.OnError != nil {
+        go s.opts.OnError(err)
+    }
+}
+
+func (s *utteranceSession) run() {
+    defer close(s.done)
+    for job := range s.jobs {
+        result, err := s.recognize(s.ctx, job.pcm, s.opts)
+        if err != nil {
+            if s.opts.OnError != nil {
+                s.opts.OnError(err)
+            }
+            continue
+        }
+        if result.Text == "" && !job.final {
+            continue
+        }
+        result.Final = job.final
+        result.Start = job.start
+        result.End = job.start + s.duration(len(job.pcm))
+        if result.Language == "" {
+            result.Language = s.opts.Language
+        }
+        if s.opts.OnResult != nil && result.Text != "" {
+            s.opts.OnResult(result)
+        }
+    }
+}
+
+func (s *utteranceSession) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    if len(s.buf) > 0 {
+        s.flush(true)
+    }
+    close(s.jobs)
+    s.mu.Unlock()
+    
+    <-s.done
+    s.cancel()
+    return nil
+}
+
+func rms(pcm []byte) float64 {
+    samples := len(pcm) / 2
+    if samples == 0 {
+        return 0
+    }
+    sum := 0.0
+    for i := 0; i < samples; i++ {
+        v := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / math.MaxInt16
+        sum += v * v
+    }
+    return math.Sqrt(sum / float64(samples))
+}
@@ -0,0 +1,108 @@
+package stt
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "strings"
+)
+
+// Whisper talks to an OpenAI compatible /audio/transcriptions endpoint, the
+// hosted API or a self-hosted whisper server. It has no streaming mode, so
+// utterances are endpointed locally and partials come from re-recognizing
+// the growing utterance when Endpointing.Partials is set.
+type Whisper struct {
+    URL         string // Base URL, e.g. https://api.openai.com/v1
+    APIKey      string
+    Model       string
+    Endpointing Endpointing
+    Client      *http.Client
+}
+
+func (w *Whisper) Name() string {
+    return "whisper"
+}
+
+func (w *Whisper) NewSession(ctx context.Context, opts Options) (Session, error) {
+    return Utterances(ctx, w.recognize, w.Endpointing, opts), nil
+}
+
+func (w *Whisper) recognize(ctx context.Context, pcm []byte, opts Options) (Result, error) {
+    var body bytes.Buffer
+    form := multipart.NewWriter(&body)
+    file, _ := form.CreateFormFile("file", "audio.wav")
+    file.Write(wavFile(pcm, opts))
+    form.WriteField("model", w.Model)
+    form.WriteField("response_format", "json")
+    if opts.Language != "" {
+        // Whisper takes ISO 639-1 codes, not full tags
+        language, _, _ := strings.Cut(opts.Language, "-")
+        form.WriteField("language", strings.ToLower(language))
+    }
+    if len(opts.Hints) > 0 {
+        // The prompt only nudges Whisper, it has no real boosting
+        phrases := make([]string, len(opts.Hints))
+        for i, hint := range opts.Hints {
+            phrases[i] = hint.Phrase
+        }
+        form.WriteField("prompt", strings.Join(phrases, ", "))
+    }
+    form.Close()
+    
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(w.URL, "/")+"/audio/transcriptions", &body)
+    if err != nil {
+        return Result{}, err
+    }
+    req.Header.Set("Content-Type", form.FormDataContentType())
+    if w.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+w.APIKey)
+    }
+    
+    var response struct {
+        Text string `json:"text"`
+    }
+    if err := doJSON(w.Client, req, &response); err != nil {
+        return Result{}, fmt.Errorf("whisper: %v", err)
+    }
+    return Result{Text: strings.TrimSpace(response.Text)}, nil
+}
+
+// doJSON sends req and decodes a 2xx JSON response into out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func wavFile(pcm []byte, opts Options) []byte {
+    header := make([]byte, 44)
+    copy(header[0:], "RIFF")
+    binary.LittleEndian.PutUint32(header[4:], uint32(36+len(pcm)))
+    copy(header[8:], "WAVEfmt ")
+    binary.LittleEndian.PutUint32(header[16:], 16)
+    binary.LittleEndian.PutUint16(header[20:], 1)
+    binary.LittleEndian.PutUint16(header[22:], uint16(opts.Channels))
+    binary.LittleEndian.PutUint32(header[24:], uint32(opts.SampleRate))
+    binary.LittleEndian.PutUint32(header[28:], uint32(opts.SampleRate*opts.Channels*2))
+    binary.LittleEndian.PutUint16(header[32:], uint16(opts.Channels*2))
+    binary.LittleEndian.PutUint16(header[34:], 16)
+    copy(header[36:], "data")
+    binary.LittleEndian.PutUint32(header[40:], uint32(len(pcm)))
+    return append(header, pcm...)
+}
@@ -0,0 +1,77 @@
+package telephony
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// HTTP talks to a voice gateway over a small REST API:
+//
+//   POST /calls              a Call, answers {"callId": ID}
+//   POST /calls/ID/hangup
+//
+// SIP trunks and CPaaS vendors sit behind a thin adapter exposing these two
+// calls and POSTing a Status to each call's statusUrl.
+type HTTP struct {
+    URL    string
+    APIKey string
+    Client *http.Client
+}
+
+func (h *HTTP) Name() string { return "http" }
+
+func (h *HTTP) Dial(ctx context.Context, call Call) (string, error) {
+    var response struct {
+        CallId string `json:"callId"`
+    }
+    if err := h.post(ctx, "/calls", call, &response); err != nil {
+        return "", fmt.Errorf("dial: %v", err)
+    }
+    if response.CallId == "" {
+        return "", fmt.Errorf("dial: gateway returned no callId")
+    }
+    return response.CallId, nil
+}
+
+func (h *HTTP) Hangup(ctx context.Context, callId string) error {
+    if err := h.post(ctx, "/calls/"+url.PathEscape(callId)+"/hangup", map[string]string{}, nil); err != nil {
+        return fmt.Errorf("hangup: %v", err)
+    }
+    return nil
+}
+
+func (h *HTTP) post(ctx context.Context, path string, request interface{}, out interface{}) error {
+    data, _ := json.Marshal(request)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(h.URL, "/")+path, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if h.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+h.APIKey)
+    }
+    client := h.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    payload, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(payload)))
+    }
+    if out == nil {
+        return nil
+    }
+    return json.Unmarshal(payload, out)
+}
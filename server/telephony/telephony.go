@@ -0,0 +1,57 @@
+// Package telephony places outbound phone calls through a voice gateway. The
+// gateway dials the number, connects the answered call to a room over the
+// WebSocket URL it is given, as a user client like any other, and reports
+// the call's progress to a status URL.
+package telephony
+
+import "context"
+
+// Call progress reported to the status URL. Busy, no_answer, failed and
+// completed are final.
+const (
+    Ringing   = "ringing"
+    Answered  = "answered"
+    Completed = "completed"
+    Busy      = "busy"
+    NoAnswer  = "no_answer"
+    Failed    = "failed"
+)
+
+// Who answered, when the gateway detects answering machines.
+const (
+    Human   = "human"
+    Machine = "machine"
+    Unknown = "unknown"
+)
+
+type Call struct {
+    To            string `json:"to"`   // E.164
+    From          string `json:"from"` // Caller ID
+    JoinURL       string `json:"joinUrl"`
+    StatusURL     string `json:"statusUrl"`
+    DetectMachine bool   `json:"detectMachine,omitempty"`
+}
+
+// Status is a progress report from the gateway.
+type Status struct {
+    CallId     string `json:"callId"`
+    Status     string `json:"status"`
+    AnsweredBy string `json:"answeredBy,omitempty"`
+    Error      string `json:"error,omitempty"`
+}
+
+func Final(status string) bool {
+    switch status {
+    case Completed, Busy, NoAnswer, Failed:
+        return true
+    }
+    return false
+}
+
+type Provider interface {
+    Name() string
+    // Dial starts a call and returns the gateway's ID for it. Progress
+    // arrives later at the call's StatusURL.
+    Dial(ctx context.Context, call Call) (string, error)
+    Hangup(ctx context.Context, callId string) error
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Ticket binds a client to the media server /allocate picked for it. It is
+// encoded as base64url(JSON) "." base64url(HMAC-SHA256).
+type Ticket struct {
+    RoomId     string     `json:"roomId"`
+    ClientId   string     `json:"clientId"`
+    ClientType ClientType `json:"clientType"`
+    Tenant     string     `json:"tenant,omitempty"`
+    Role       string     `json:"role,omitempty"`
+    Server     string     `json:"server"` // host:port of the allocated media server
+    Expiry     int64      `json:"exp"`    // Unix seconds
+}
+
+type Allocation struct {
+    ServerInfo
+    Ticket    string `json:"ticket,omitempty"`
+    ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+func ticketsEnabled() bool {
+    return cfg.TicketSecret != ""
+}
+
+func ticketMAC(payload string) string {
+    mac := hmac.New(sha256.New, []byte(cfg.TicketSecret))
+    mac.Write([]byte(payload))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signTicket(t Ticket) string {
+    data, _ := json.Marshal(t)
+    payload := base64.RawURLEncoding.EncodeToString(data)
+    return payload + "." + ticketMAC(payload)
+}
+
+func verifyTicket(token string) (*Ticket, error) {
+    payload, sig, ok := strings.Cut(token, ".")
+    if !ok {
+        return nil, fmt.Errorf("malformed ticket")
+    }
+    if !hmac.Equal([]byte(sig), []byte(ticketMAC(payload))) {
+        return nil, fmt.Errorf("bad ticket signature")
+    }
+    
+    data, err := base64.RawURLEncoding.DecodeString(payload)
+    if err != nil {
+        return nil, fmt.Errorf("malformed ticket")
+    }
+    var t Ticket
+    if err := json.Unmarshal(data, &t); err != nil {
+        return nil, fmt.Errorf("malformed ticket")
+    }
+    
+    if time.Now().Unix() > t.Expiry {
+        return nil, fmt.Errorf("ticket expired")
+    }
+    if cfg.PublicAddress != "" && t.Server != cfg.PublicAddress {
+        return nil, fmt.Errorf("ticket issued for another server")
+    }
+    return &t, nil
+}
+
+// ticketForConnection checks the ticket presented on /ws against the
+// requested room, client and client type.
+func ticketForConnection(token string, roomId string, clientId string, clientType ClientType) (*Ticket, error) {
+    if token == "" {
+        return nil, fmt.Errorf("ticket query param required")
+    }
+    t, err := verifyTicket(token)
+    if err != nil {
+        return nil, err
+    }
+    if t.RoomId != roomId || t.ClientId != clientId || t.ClientType != clientType {
+        return nil, fmt.Errorf("ticket not valid for this room, client or type")
+    }
+    return t, nil
+}
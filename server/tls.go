@@ -0,0 +1,197 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+    
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// Native TLS, so a deployment serves wss:// and https:// without a reverse
+// proxy in front. -addr takes TLS with either
+//
+//   -tls-cert FILE -tls-key FILE   a certificate from files, checked for
+//                                  changes every minute and reloaded, so
+//                                  renewals by certbot or cert-manager need no
+//                                  restart
+//   -tls-autocert-domains LIST     certificates from Let's Encrypt, or the
+//                                  ACME CA at -tls-acme-directory, obtained
+//                                  on the first connection for each domain,
+//                                  renewed ahead of expiry and kept in
+//                                  -tls-autocert-cache
+//
+// ACME validates a domain over TLS-ALPN-01 on -addr, which then has to be
+// reachable on port 443, or over HTTP-01 on -tls-http-addr (":80"). That
+// listener redirects every other plain request to https. A hot upgrade hands
+// the TCP listener over and the new process loads its certificates afresh,
+// autocert's from the cache; -tls-http-addr moves over once the old process
+// lets go of it. Media servers tell the registry they take TLS, so
+// allocations and failovers use https:// and wss://.
+
+const certReloadInterval = time.Minute
+
+var tlsHTTPServer *http.Server // -tls-http-addr's, closed on a hot upgrade
+
+func tlsEnabled() bool {
+    return cfg.TLSCert != "" || len(cfg.TLSAutocertDomains) > 0
+}
+
+// serverTLSConfig is -addr's TLS config, nil without TLS. It starts the
+// certificate reloads and -tls-http-addr.
+func serverTLSConfig() (*tls.Config, error) {
+    switch {
+    case cfg.TLSCert != "" && len(cfg.TLSAutocertDomains) > 0:
+        return nil, fmt.Errorf("use -tls-cert and -tls-key or -tls-autocert-domains, not both")
+    case (cfg.TLSCert == "") != (cfg.TLSKey == ""):
+        return nil, fmt.Errorf("-tls-cert and -tls-key go together")
+    case cfg.TLSHTTPAddr != "" && !tlsEnabled():
+        return nil, fmt.Errorf("-tls-http-addr needs -tls-cert or -tls-autocert-domains")
+    case !tlsEnabled():
+        return nil, nil
+    }
+    
+    // WebSockets upgrade over HTTP/1.1 only, so no h2
+    config := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"http/1.1"}}
+    var plain http.Handler = http.HandlerFunc(redirectToHTTPS)
+    if cfg.TLSCert != "" {
+        certs := &certFiles{certFile: cfg.TLSCert, keyFile: cfg.TLSKey}
+        if err := certs.load(); err != nil {
+            return nil, err
+        }
+        go certs.watch()
+        config.GetCertificate = certs.get
+    } else {
+        manager := &autocert.Manager{
+            Prompt:     autocert.AcceptTOS,
+            HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+            Cache:      autocert.DirCache(cfg.TLSAutocertCache),
+            Email:      cfg.TLSAutocertEmail,
+        }
+        if cfg.TLSACMEDirectory != "" {
+            manager.Client = &acme.Client{DirectoryURL: cfg.TLSACMEDirectory}
+        }
+        config.GetCertificate = manager.GetCertificate
+        config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+        plain = manager.HTTPHandler(plain)
+        log.Printf("TLS certificates for %v from ACME, cached in %s", cfg.TLSAutocertDomains, cfg.TLSAutocertCache)
+    }
+    if cfg.TLSHTTPAddr != "" {
+        tlsHTTPServer = &http.Server{Addr: cfg.TLSHTTPAddr, Handler: plain, ReadHeaderTimeout: 10 * time.Second}
+        go serveTLSHTTP(tlsHTTPServer)
+    }
+    return config, nil
+}
+
+// serveTLSHTTP retries binding until an upgrading process lets go of the
+// address.
+func serveTLSHTTP(server *http.Server) {
+    for attempt := 0; ; attempt++ {
+        ln, err := net.Listen("tcp", server.Addr)
+        if err != nil {
+            if attempt == 0 {
+                log.Printf("level=warn -tls-http-addr %s: %v, retrying", server.Addr, err)
+            }
+            time.Sleep(time.Second)
+            continue
+        }
+        log.Printf("HTTP listener for ACME challenges and https redirects on %s", server.Addr)
+        if err := server.Serve(ln); err != http.ErrServerClosed {
+            log.Printf("level=error -tls-http-addr %s: %v", server.Addr, err)
+        }
+        return
+    }
+}
+
+// redirectToHTTPS sends plain requests to the same URL on -addr.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    if _, port, err := net.SplitHostPort(cfg.Addr); err == nil && port != "443" {
+        host = net.JoinHostPort(host, port)
+    }
+    http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// certFiles is the certificate from -tls-cert and -tls-key, reloaded when
+// either file changes. A renewal caught halfway, the key not yet matching,
+// is retried at the next check.
+type certFiles struct {
+    certFile string
+    keyFile  string
+    mu       sync.RWMutex
+    cert     *tls.Certificate
+    modified time.Time // The later of the files' modification times
+}
+
+func (c *certFiles) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.cert, nil
+}
+
+func (c *certFiles) modTime() (time.Time, error) {
+    var latest time.Time
+    for _, path := range []string{c.certFile, c.keyFile} {
+        info, err := os.Stat(path)
+        if err != nil {
+            return time.Time{}, err
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
+
+func (c *certFiles) load() error {
+    modified, err := c.modTime()
+    if err != nil {
+        return err
+    }
+    cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return err
+    }
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return err
+    }
+    c.mu.Lock()
+    c.cert, c.modified = &cert, modified
+    c.mu.Unlock()
+    log.Printf("TLS certificate for %v from %s, expires %s", leaf.DNSNames, c.certFile, leaf.NotAfter.Format(time.RFC3339))
+    return nil
+}
+
+func (c *certFiles) watch() {
+    for range time.Tick(certReloadInterval) {
+        modified, err := c.modTime()
+        c.mu.RLock()
+        changed := err == nil && modified.After(c.modified)
+        c.mu.RUnlock()
+        if !changed {
+            continue
+        }
+        if err := c.load(); err != nil {
+            log.Printf("level=error Reloading the TLS certificate: %v, keeping the current one", err)
+        }
+    }
+}
+
+// serverURL is the base URL the registry reaches a media server on.
+func serverURL(server ServerInfo) string {
+    if server.TLS {
+        return "https://" + serverKey(server)
+    }
+    return "http://" + serverKey(server)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// A room trace captures everything one room does for a few minutes, inbound
+// messages, fan-out, audio frame metadata, errors and room scoped log lines,
+// so a single call can be debugged without turning on global debug logging.
+
+type TraceEvent struct {
+    Time   int64                  `json:"time"` // Unix milliseconds
+    Kind   string                 `json:"kind"` // message_in, fanout, audio, error or log
+    Client string                 `json:"clientId,omitempty"`
+    Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+type roomTrace struct {
+    mu        sync.Mutex
+    roomId    string
+    startedBy string
+    startedAt time.Time
+    until     time.Time
+    events    []TraceEvent
+    dropped   int // Events past -trace-max-events
+}
+
+var (
+    traces       = make(map[string]*roomTrace)
+    tracesMu     sync.RWMutex
+    activeTraces int32 // Lets the hot paths skip the map when nothing is traced
+)
+
+// activeTrace returns the room's trace while it is still capturing.
+func activeTrace(roomId string) *roomTrace {
+    if atomic.LoadInt32(&activeTraces) == 0 {
+        return nil
+    }
+    tracesMu.RLock()
+    t := traces[roomId]
+    tracesMu.RUnlock()
+    if t == nil || time.Now().After(t.until) {
+        return nil
+    }
+    return t
+}
+
+func traceEvent(roomId string, kind string, clientId string, data map[string]interface{}) {
+    t := activeTrace(roomId)
+    if t == nil {
+        return
+    }
+    
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if len(t.events) >= cfg.TraceMaxEvents {
+        t.dropped++
+        return
+    }
+    t.events = append(t.events, TraceEvent{
+        Time:   time.Now().UnixNano() / int64(time.Millisecond),
+        Kind:   kind,
+        Client: clientId,
+        Data:   data,
+    })
+}
+
+func traceMessage(roomId string, sender *Client, msg *Message) {
+    if activeTrace(roomId) == nil {
+        return
+    }
+    traced := *msg
+    if msg.Type == "verify_answer" || msg.Type == "dtmf" {
+        traced.Data = "[redacted]" // Security answers, passcodes and keypad input
+    }
+    traceEvent(roomId, "message_in", sender.clientId, map[string]interface{}{"message": traced})
+}
+
+func traceFanout(roomId string, sender *Client, msg *Message, audience ClientType) {
+    if activeTrace(roomId) == nil {
+        return
+    }
+    from := ""
+    if sender != nil {
+        from = sender.clientId
+    }
+    traceEvent(roomId, "fanout", from, map[string]interface{}{
+        "id":       msg.Id,
+        "type":     msg.Type,
+        "to":       msg.To,
+        "audience": audience,
+    })
+}
+
+// traceAudio records frame metadata only, never the samples.
+func traceAudio(roomId string, client *Client, data []byte, paced bool, route string) {
+    if activeTrace(roomId) == nil {
+        return
+    }
+    traceEvent(roomId, "audio", client.clientId, map[string]interface{}{
+        "bytes": len(data),
+        "paced": paced,
+        "route": route,
+    })
+}
+
+// pruneTraces forgets traces that finished longer than -trace-retention ago.
+func pruneTraces() {
+    tracesMu.Lock()
+    defer tracesMu.Unlock()
+    
+    active := int32(0)
+    for roomId, t := range traces {
+        if time.Since(t.until) > cfg.TraceRetention {
+            delete(traces, roomId)
+            continue
+        }
+        if time.Now().Before(t.until) {
+            active++
+        }
+    }
+    atomic.StoreInt32(&activeTraces, active)
+}
+
+func startTraceJanitor() {
+    go func() {
+        for range time.Tick(time.Minute) {
+            pruneTraces()
+        }
+    }()
+}
+
+// Admin API: /admin/room/ROOM_ID/trace
+//   POST   ?minutes=N starts capturing, replacing any earlier trace
+//   GET    downloads the bundle (zip of manifest.json and events.jsonl)
+//   DELETE stops capturing, leaving the bundle downloadable
+func handleAdminRoom(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    
+    roomId, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/room/"), "/")
+    if roomId == "" {
+        http.Error(w, "Room ID required", http.StatusBadRequest)
+        return
+    }
+    if resource != "trace" {
+        http.NotFound(w, r)
+        return
+    }
+    
+    switch r.Method {
+    case http.MethodPost:
+        minutes := 5
+        if value := r.URL.Query().Get("minutes"); value != "" {
+            n, err := strconv.Atoi(value)
+            if err != nil || n <= 0 || time.Duration(n)*time.Minute > cfg.TraceMaxDuration {
+                http.Error(w, fmt.Sprintf("minutes must be between 1 and %d", int(cfg.TraceMaxDuration/time.Minute)), http.StatusBadRequest)
+                return
+            }
+            minutes = n
+        }
+        
+        now := time.Now()
+        t := &roomTrace{
+            roomId:    roomId,
+            startedBy: clientIP(r),
+            startedAt: now,
+            until:     now.Add(time.Duration(minutes) * time.Minute),
+        }
+        tracesMu.Lock()
+        traces[roomId] = t
+        tracesMu.Unlock()
+        pruneTraces()
+        
+        logAt("info", roomId, "", "Tracing room for %d minutes", minutes)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "roomId":    roomId,
+            "startedAt": t.startedAt.UnixNano() / int64(time.Millisecond),
+            "until":     t.until.UnixNano() / int64(time.Millisecond),
+        })
+        
+    case http.MethodGet:
+        tracesMu.RLock()
+        t := traces[roomId]
+        tracesMu.RUnlock()
+        if t == nil {
+            http.Error(w, "No trace for room", http.StatusNotFound)
+            return
+        }
+        writeTraceBundle(w, t)
+        
+    case http.MethodDelete:
+        tracesMu.Lock()
+        t := traces[roomId]
+        if t != nil && time.Now().Before(t.until) {
+            t.until = time.Now()
+        }
+        tracesMu.Unlock()
+        if t == nil {
+            http.Error(w, "No trace for room", http.StatusNotFound)
+            return
+        }
+        pruneTraces()
+        w.WriteHeader(http.StatusNoContent)
+        
+    default:
+        http.Error(w, "Only GET, POST and DELETE allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func writeTraceBundle(w http.ResponseWriter, t *roomTrace) {
+    t.mu.Lock()
+    events := append([]TraceEvent(nil), t.events...)
+    dropped := t.dropped
+    t.mu.Unlock()
+    
+    tracesMu.RLock()
+    until := t.until
+    tracesMu.RUnlock()
+    
+    manifest := map[string]interface{}{
+        "roomId":    t.roomId,
+        "startedBy": t.startedBy,
+        "startedAt": t.startedAt.UnixNano() / int64(time.Millisecond),
+        "until":     until.UnixNano() / int64(time.Millisecond),
+        "complete":  time.Now().After(until),
+        "events":    len(events),
+        "dropped":   dropped,
+    }
+    if room, users, agents := roomMembers(t.roomId); room != nil {
+        participants := make([]map[string]interface{}, 0, len(users)+len(agents))
+        for _, client := range append(users, agents...) {
+            info := participantInfo(client)
+            info["state"] = client.currentState().String()
+            participants = append(participants, info)
+        }
+        manifest["room"] = map[string]interface{}{
+            "config":       roomConfig(room),
+            "recording":    recordingStatus(room),
+            "participants": participants,
+        }
+    }
+    
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trace-%s.zip"`, t.roomId))
+    bundle := zip.NewWriter(w)
+    
+    if f, err := bundle.Create("manifest.json"); err == nil {
+        encoder := json.NewEncoder(f)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(manifest)
+    }
+    if f, err := bundle.Create("events.jsonl"); err == nil {
+        encoder := json.NewEncoder(f)
+        for _, event := range events {
+            encoder.Encode(event)
+        }
+    }
+    bundle.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+    "strconv"
+    "time"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+)
+
+// Transcript fan-out. publishTranscript does what decides a transcript, the
+// vocabulary, moderation, stabilizing and the shadow turn, and then hands it
+// to every consumer at once instead of running them in turn:
+//
+//   captions  transcript and transcript_partial to the room's agents, where
+//             intent classification, knowledge graph lookups and agent
+//             assist take them up
+//   history   finals into the transcript store and the search index
+//   clips     finals' audio to -clips-dir
+//
+// Each consumer has -transcript-workers goroutines, a room always on the same
+// one so its transcripts keep their order, each with a queue of
+// -transcript-queue-size. A slow store only backs up history: a consumer
+// whose queue is full drops partials, a newer one follows, and waits up to
+// transcriptFinalWait for room for a final before dropping that too. Drops
+// are counted in iva_transcript_drops_total.
+
+const transcriptFinalWait = time.Second
+
+var transcriptDropsTotal = newCounterVec("iva_transcript_drops_total", "Transcripts a consumer dropped because its queue was full.", "consumer", "final")
+
+func init() {
+    metricSeries = append(metricSeries, transcriptDropsTotal)
+}
+
+// transcriptEvent is one transcript on its way to the consumers, which must
+// not change msg.
+type transcriptEvent struct {
+    client *Client
+    msg    *Message
+    final  bool
+    clip   *clipAudio // Final only, nil without a clip to save
+}
+
+type transcriptConsumer struct {
+    name   string
+    finals bool // Only final transcripts
+    handle func(transcriptEvent)
+    queues []chan transcriptEvent
+}
+
+var transcriptConsumers = []*transcriptConsumer{
+    {name: "captions", handle: func(e transcriptEvent) { sendToAgents(e.client.room, nil, e.msg) }},
+    {name: "history", finals: true, handle: func(e transcriptEvent) { recordHistory(e.client.room, e.msg) }},
+    {name: "clips", finals: true, handle: func(e transcriptEvent) { writeClip(e.client, e.clip) }},
+}
+
+// checkTranscriptBus validates -transcript-workers and -transcript-queue-size.
+func checkTranscriptBus() error {
+    if cfg.TranscriptWorkers < 1 || cfg.TranscriptQueueSize < 1 {
+        return fmt.Errorf("-transcript-workers and -transcript-queue-size must be at least 1")
+    }
+    return nil
+}
+
+// startTranscriptBus starts every consumer's workers.
+func startTranscriptBus() {
+    for _, consumer := range transcriptConsumers {
+        consumer.queues = make([]chan transcriptEvent, cfg.TranscriptWorkers)
+        for i := range consumer.queues {
+            queue := make(chan transcriptEvent, cfg.TranscriptQueueSize)
+            consumer.queues[i] = queue
+            go func(handle func(transcriptEvent)) {
+                for event := range queue {
+                    handle(event)
+                }
+            }(consumer.handle)
+        }
+    }
+}
+
+// fanOutTranscript queues the event for every consumer that takes it.
+func fanOutTranscript(event transcriptEvent) {
+    h := fnv.New32a()
+    h.Write([]byte(event.client.room))
+    shard := h.Sum32()
+    for _, consumer := range transcriptConsumers {
+        if consumer.finals && !event.final {
+            continue
+        }
+        if len(consumer.queues) == 0 {
+            consumer.handle(event) // Before startTranscriptBus
+            continue
+        }
+        consumer.offer(consumer.queues[shard%uint32(len(consumer.queues))], event)
+    }
+}
+
+func (c *transcriptConsumer) offer(queue chan transcriptEvent, event transcriptEvent) {
+    select {
+    case queue <- event:
+        return
+    default:
+    }
+    if event.final {
+        timer := time.NewTimer(transcriptFinalWait)
+        defer timer.Stop()
+        select {
+        case queue <- event:
+            return
+        case <-timer.C:
+        }
+    }
+    transcriptDropsTotal.inc(event.client.labels, c.name, strconv.FormatBool(event.final))
+    if event.final {
+        logAt("warn", event.client.room, event.client.clientId, "Transcript consumer %s is behind, dropped final %s", c.name, event.msg.Id)
+    }
+}
+
+// clipAudio is a final transcript's audio, cut from the caller's clip buffer
+// when the transcript is published and written by the clips consumer.
+type clipAudio struct {
+    path   string
+    audio  []byte
+    format audiogen.Format
+}
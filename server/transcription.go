@@ -0,0 +1,284 @@
+package main
+
+import (
+    "context"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+    "github.com/yourusername/my-go-project/moderation"
+    "github.com/yourusername/my-go-project/stt"
+)
+
+// Server side transcription. When a room resolves to an STT provider, every
+// user who declares audioFormat=pcm16 gets a session; partials go to the
+// room's agents as transcript_partial and finals as transcript, which is also
+// kept in history. The provider is picked per room template, then per
+// tenant, then -stt-provider.
+//
+// Results are post-processed first: partials become edits against the
+// previous partial (replaceFrom/suffix) and unchanged ones are dropped,
+// finals get punctuation and casing when the vendor gave none, and both are
+// corrected towards the tenant's custom vocabulary. They then fan out to
+// captions, history and clips independently, see transcriptbus.go.
+
+var sttProviders = make(map[string]stt.Provider)
+
+var (
+    sttAudioSeconds = newCounterVec("iva_stt_audio_seconds_total", "Audio sent to speech-to-text providers.", "provider")
+    sttCostTotal    = newCounterVec("iva_stt_cost_usd_total", "Estimated speech-to-text spend from -stt-prices.", "provider")
+    sttErrorsTotal  = newCounterVec("iva_stt_errors_total", "Speech-to-text session and recognition errors.", "provider")
+    sttLatency      = newHistogramVec("iva_stt_latency_seconds", "Time from the end of an utterance's audio to its final transcript.",
+        []float64{0.1, 0.25, 0.5, 1, 2, 5, 10}, "provider")
+)
+
+func init() {
+    metricSeries = append(metricSeries, sttAudioSeconds, sttCostTotal, sttErrorsTotal)
+}
+
+// loadSTTProviders registers every provider that has credentials or a URL.
+func loadSTTProviders() {
+    endpointing := stt.DefaultEndpointing
+    endpointing.Partials = cfg.STTPartialInterval
+    
+    if cfg.WhisperURL != "" {
+        sttProviders["whisper"] = &stt.Whisper{URL: cfg.WhisperURL, APIKey: cfg.WhisperAPIKey, Model: cfg.WhisperModel, Endpointing: endpointing}
+    }
+    if cfg.DeepgramAPIKey != "" {
+        sttProviders["deepgram"] = &stt.Deepgram{URL: cfg.DeepgramURL, APIKey: cfg.DeepgramAPIKey, Model: cfg.DeepgramModel}
+    }
+    if cfg.GoogleSTTAPIKey != "" {
+        sttProviders["google"] = &stt.Google{APIKey: cfg.GoogleSTTAPIKey, Model: cfg.GoogleSTTModel, Endpointing: endpointing}
+    }
+}
+
+// lookupOverride returns VALUE for the first KEY=VALUE entry matching key.
+func lookupOverride(entries []string, key string) (string, bool) {
+    for _, entry := range entries {
+        k, value, ok := strings.Cut(entry, "=")
+        if ok && k == key {
+            return value, true
+        }
+    }
+    return "", false
+}
+
+func sttProviderFor(room *RoomInfo) stt.Provider {
+    name := cfg.STTProvider
+    if value, ok := lookupOverride(cfg.STTTenantProviders, room.Tenant); ok {
+        name = value
+    }
+    if value, ok := lookupOverride(cfg.STTTemplateProviders, room.Template); ok {
+        name = value
+    }
+    return sttProviders[name] // nil for "" or "none"
+}
+
+// sttNameFor is advertised in the room config so clients know whether to
+// expect transcripts from the server.
+func sttNameFor(room *RoomInfo) string {
+    if provider := sttProviderFor(room); provider != nil {
+        return provider.Name()
+    }
+    return ""
+}
+
+// sttPrice is the configured USD per minute of audio for a provider.
+func sttPrice(provider string) float64 {
+    value, _ := lookupOverride(cfg.STTPrices, provider)
+    price, _ := strconv.ParseFloat(value, 64)
+    return price
+}
+
+// startTranscription opens a session for a user that just joined. It runs
+// on a goroutine of its own, audio before the session is open going
+// untranscribed.
+func startTranscription(client *Client, query url.Values) {
+    if client.clientType != ClientTypeUser || query.Get("audioFormat") != "pcm16" {
+        return
+    }
+    rate, err := strconv.Atoi(query.Get("sampleRate"))
+    if err != nil || rate <= 0 {
+        return
+    }
+    channels, err := strconv.Atoi(query.Get("channels"))
+    if err != nil || channels <= 0 {
+        channels = 1
+    }
+    
+    room, _, _ := roomMembers(client.room)
+    if room == nil {
+        return
+    }
+    provider := sttProviderFor(room)
+    if provider == nil {
+        return
+    }
+    
+    language := query.Get("language")
+    if language == "" {
+        client.mu.Lock()
+        language, _ = client.metadata["language"].(string)
+        client.mu.Unlock()
+    }
+    if language == "" && room.Locale != cfg.DefaultLocale {
+        language = room.Locale // See locale.go
+    }
+    if language == "" {
+        language = cfg.STTLanguage
+    }
+    
+    name := provider.Name()
+    circuit := breakerFor("stt", name)
+    if !circuit.Allow() {
+        logAt("warn", client.room, client.clientId, "Transcription skipped, %s circuit open", name)
+        integrationFailed(room, "stt", circuit.Name+" circuit open")
+        return
+    }
+    vocabulary := sttVocabularyFor(room.Tenant)
+    stabilizer := &stt.Stabilizer{} // Results arrive in order on one goroutine
+    session, err := stt.Open(context.Background(), provider, stt.Options{
+        SampleRate: rate,
+        Channels:   channels,
+        Language:   language,
+        Alternates: splitList(query.Get("altLanguages")),
+        Hints:      vocabulary.Hints(),
+        OnResult: func(result stt.Result) {
+            if result.Final {
+                circuit.Success()
+            }
+            publishTranscript(client, name, result, stabilizer, vocabulary)
+        },
+        OnError: func(err error) {
+            sttErrorsTotal.inc(client.labels, name)
+            logAt("warn", client.room, client.clientId, "Transcription error: %v", err)
+            integrationError(room, "stt", circuit, err, false)
+        },
+    })
+    if err != nil {
+        sttErrorsTotal.inc(client.labels, name)
+        logAt("warn", client.room, client.clientId, "Transcription unavailable: %v", err)
+        // Without a session nobody hears this caller
+        integrationError(room, "stt", circuit, err, true)
+        return
+    }
+    
+    client.mu.Lock()
+    if client.sttStopped {
+        client.mu.Unlock()
+        go session.Close() // The client left while the session opened
+        return
+    }
+    client.stt = session
+    client.sttProvider = name
+    client.sttBytesPerSecond = rate * channels * 2
+    if cfg.ClipsDir != "" {
+        client.clip = newClipBuffer(audiogen.Format{SampleRate: rate, Channels: channels})
+    }
+    client.mu.Unlock()
+    logAt("info", client.room, client.clientId, "Transcribing with %s (%s)", name, language)
+}
+
+// transcribe feeds a user's audio into their session, if they have one.
+func transcribe(client *Client, data []byte) {
+    client.mu.Lock()
+    session, provider, bytesPerSecond := client.stt, client.sttProvider, client.sttBytesPerSecond
+    client.mu.Unlock()
+    if session == nil {
+        return
+    }
+    
+    if err := session.Write(data); err != nil {
+        sttErrorsTotal.inc(client.labels, provider)
+        if room, _, _ := roomMembers(client.room); room != nil {
+            integrationError(room, "stt", breakerFor("stt", provider), err, false)
+        }
+        return
+    }
+    client.mu.Lock()
+    if client.clip != nil {
+        client.clip.write(data)
+    }
+    client.mu.Unlock()
+    seconds := float64(len(data)) / float64(bytesPerSecond)
+    sttAudioSeconds.add(seconds, client.labels, provider)
+    if price := sttPrice(provider); price > 0 {
+        sttCostTotal.add(seconds/60*price, client.labels, provider)
+        addSpend(client.room, spendSTT, seconds/60*price)
+    }
+}
+
+// stopTranscription flushes the session in the background; the last finals
+// still reach the agents even though the user has gone.
+func stopTranscription(client *Client) {
+    client.mu.Lock()
+    session := client.stt
+    client.stt, client.sttStopped = nil, true
+    client.mu.Unlock()
+    if session != nil {
+        go session.Close()
+    }
+}
+
+func publishTranscript(client *Client, provider string, result stt.Result, stabilizer *stt.Stabilizer, vocabulary *stt.Vocabulary) {
+    text := strings.Join(strings.Fields(vocabulary.Apply(result.Text)), " ")
+    messageId := newMessageId()
+    if cfg.ModerationTranscripts && text != "" {
+        // Partials only get the local rules, providers see each final once
+        verdict := moderate(client.room, client, "transcript", messageId, text, !result.Final)
+        if verdict.Action == moderation.Block {
+            if result.Final {
+                stabilizer.Final()
+            }
+            return
+        }
+        text = verdict.Text
+    }
+    text = filterProfanity(client.room, client, "transcript", messageId, text, result.Final)
+    data := map[string]interface{}{
+        "clientId":   client.clientId,
+        "final":      result.Final,
+        "confidence": result.Confidence,
+        "language":   result.Language,
+        "provider":   provider,
+        "startMs":    result.Start.Milliseconds(),
+        "endMs":      result.End.Milliseconds(),
+    }
+    msg := &Message{
+        Id:        messageId,
+        Type:      "transcript",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    
+    event := transcriptEvent{client: client, msg: msg, final: result.Final}
+    if result.Final {
+        stabilizer.Final()
+        data["text"] = stt.Punctuate(text)
+        noteTranscriptWords(client, text)
+        sttLatency.observe(result.Latency.Seconds(), client.labels, provider)
+        var url string
+        if event.clip, url = cutClip(client, messageId, result); url != "" {
+            data["clipUrl"] = url
+        }
+        roomsMu.Lock()
+        if room := rooms[client.room]; room != nil {
+            observeShadowTurn(room, client, "transcript", data["text"].(string), msg.Timestamp)
+        }
+        roomsMu.Unlock()
+    } else {
+        edit, changed := stabilizer.Partial(text)
+        if !changed {
+            return
+        }
+        msg.Type = "transcript_partial"
+        data["text"] = text
+        data["replaceFrom"] = edit.ReplaceFrom
+        data["suffix"] = edit.Suffix
+        data["stableWords"] = edit.Stable
+    }
+    fanOutTranscript(event)
+}
@@ -0,0 +1,134 @@
+package tts
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// Azure uses the Azure AI Speech REST API, the same neural voices
+// (en-US-AriaNeural and friends) the bot gets through edge-tts. Text, or what
+// an SSML document's <speak> holds, is wrapped in SSML here with the voice,
+// style and speed.
+type Azure struct {
+    Region string // e.g. westeurope
+    APIKey string
+    Client *http.Client
+}
+
+func (a *Azure) Name() string {
+    return "azure"
+}
+
+func (a *Azure) endpoint(path string) string {
+    return fmt.Sprintf("https://%s.tts.speech.microsoft.com%s", a.Region, path)
+}
+
+func (a *Azure) client() *http.Client {
+    if a.Client != nil {
+        return a.Client
+    }
+    return http.DefaultClient
+}
+
+func (a *Azure) Voices(ctx context.Context) ([]Voice, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.endpoint("/cognitiveservices/voices/list"), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Ocp-Apim-Subscription-Key", a.APIKey)
+    
+    var list []struct {
+        ShortName   string   `json:"ShortName"`
+        DisplayName string   `json:"DisplayName"`
+        Locale      string   `json:"Locale"`
+        Gender      string   `json:"Gender"`
+        StyleList   []string `json:"StyleList"`
+    }
+    if err := getJSON(a.client(), req, &list); err != nil {
+        return nil, fmt.Errorf("azure: %v", err)
+    }
+    
+    voices := make([]Voice, 0, len(list))
+    for _, v := range list {
+        voices = append(voices, Voice{
+            Id:       v.ShortName,
+            Name:     v.DisplayName,
+            Provider: a.Name(),
+            Language: v.Locale,
+            Gender:   strings.ToLower(v.Gender),
+            Styles:   v.StyleList,
+            SSML:     true,
+        })
+    }
+    return voices, nil
+}
+
+// Raw PCM output formats the service offers
+var azureRates = []int{8000, 16000, 24000, 48000}
+
+func (a *Azure) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+    if req.Voice == "" {
+        return nil, ErrNoVoice
+    }
+    rate := azureRates[len(azureRates)-1]
+    for _, r := range azureRates {
+        if r >= req.SampleRate {
+            rate = r
+            break
+        }
+    }
+    
+    ssml := a.ssml(req)
+    httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint("/cognitiveservices/v1"), strings.NewReader(ssml))
+    if err != nil {
+        return nil, err
+    }
+    httpReq.Header.Set("Ocp-Apim-Subscription-Key", a.APIKey)
+    httpReq.Header.Set("Content-Type", "application/ssml+xml")
+    httpReq.Header.Set("X-Microsoft-OutputFormat", fmt.Sprintf("raw-%dkhz-16bit-mono-pcm", rate/1000))
+    httpReq.Header.Set("User-Agent", "iva-server")
+    
+    resp, err := a.client().Do(httpReq)
+    if err != nil {
+        return nil, fmt.Errorf("azure: %v", err)
+    }
+    if resp.StatusCode/100 != 2 {
+        defer resp.Body.Close()
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return nil, fmt.Errorf("azure: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return resampled(resp.Body, rate, req.SampleRate)
+}
+
+func (a *Azure) ssml(req Request) string {
+    language := req.Language
+    if language == "" {
+        language = voiceLanguage(req.Voice)
+    }
+    
+    // A document's own <speak> gives way to the voice's
+    body := escapeXML(req.Text)
+    if req.SSML {
+        body = SSMLBody(req.Text)
+    }
+    if s := speed(req); s != 1 {
+        body = fmt.Sprintf(`<prosody rate="%+.0f%%">%s</prosody>`, (s-1)*100, body)
+    }
+    if req.Style != "" {
+        body = fmt.Sprintf(`<mstts:express-as style="%s">%s</mstts:express-as>`, escapeXML(req.Style), body)
+    }
+    return fmt.Sprintf(`<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xmlns:mstts="https://www.w3.org/2001/mstts" xml:lang="%s"><voice name="%s">%s</voice></speak>`,
+        escapeXML(language), escapeXML(req.Voice), body)
+}
+
+// voiceLanguage takes the locale prefix of names like en-US-AriaNeural.
+func voiceLanguage(voice string) string {
+    parts := strings.SplitN(voice, "-", 3)
+    if len(parts) < 3 {
+        return "en-US"
+    }
+    return parts[0] + "-" + parts[1]
+}
@@ -0,0 +1,153 @@
+package tts
+
+import (
+    "bytes"
+    "container/list"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "sync"
+)
+
+// CacheStore holds synthesized pcm16 by key. Implementations evict by size
+// on their own and must be safe for concurrent use.
+type CacheStore interface {
+    Get(ctx context.Context, key string) (pcm []byte, sampleRate int, ok bool)
+    Put(ctx context.Context, key string, pcm []byte, sampleRate int)
+}
+
+// Cached wraps a provider so identical requests (text, voice, style, speed,
+// rate) are synthesized once. Audio is cached as it streams and only when
+// it was read to the end, so interrupted utterances never leave a
+// truncated entry behind. Entries over maxEntry bytes are not cached.
+func Cached(p Provider, store CacheStore, maxEntry int) Provider {
+    return &cachedProvider{Provider: p, store: store, maxEntry: maxEntry}
+}
+
+type cachedProvider struct {
+    Provider
+    store    CacheStore
+    maxEntry int
+}
+
+func CacheKey(provider string, req Request) string {
+    h := sha256.New()
+    fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%g\x00%t\x00%d\x00%s",
+        provider, req.Voice, req.Language, req.Style, speed(req), req.SSML, req.SampleRate, req.Text)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cachedProvider) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+    key := CacheKey(c.Name(), req)
+    if pcm, rate, ok := c.store.Get(ctx, key); ok {
+        return &Audio{SampleRate: rate, Body: io.NopCloser(bytes.NewReader(pcm)), Cached: true}, nil
+    }
+    
+    audio, err := c.Provider.Synthesize(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    audio.Body = &teeBody{
+        ReadCloser: audio.Body,
+        limit:      c.maxEntry,
+        done: func(pcm []byte) {
+            c.store.Put(context.Background(), key, pcm, audio.SampleRate)
+        },
+    }
+    return audio, nil
+}
+
+// teeBody copies what is read and hands it to done on EOF.
+type teeBody struct {
+    io.ReadCloser
+    buf      bytes.Buffer
+    limit    int
+    overflow bool
+    done     func(pcm []byte)
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+    n, err := t.ReadCloser.Read(p)
+    if !t.overflow {
+        if t.buf.Len()+n > t.limit {
+            t.overflow = true
+            t.buf = bytes.Buffer{}
+        } else {
+            t.buf.Write(p[:n])
+        }
+    }
+    if err == io.EOF && !t.overflow && t.done != nil {
+        t.done(t.buf.Bytes())
+        t.done = nil
+    }
+    return n, err
+}
+
+// MemoryCache is an LRU bounded by the total bytes of audio it holds.
+type MemoryCache struct {
+    mu       sync.Mutex
+    maxBytes int
+    bytes    int
+    order    *list.List // Front is most recently used
+    entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+    key  string
+    pcm  []byte
+    rate int
+}
+
+func NewMemoryCache(maxBytes int) *MemoryCache {
+    return &MemoryCache{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string) ([]byte, int, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    element, ok := m.entries[key]
+    if !ok {
+        return nil, 0, false
+    }
+    m.order.MoveToFront(element)
+    entry := element.Value.(*memoryEntry)
+    return entry.pcm, entry.rate, true
+}
+
+func (m *MemoryCache) Put(ctx context.Context, key string, pcm []byte, sampleRate int) {
+    if len(pcm) > m.maxBytes {
+        return
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    if element, ok := m.entries[key]; ok {
+        m.bytes -= len(element.Value.(*memoryEntry).pcm)
+        m.order.Remove(element)
+    }
+    m.entries[key] = m.order.PushFront(&memoryEntry{key: key, pcm: append([]byte(nil), pcm...), rate: sampleRate})
+    m.bytes += len(pcm)
+    
+    for m.bytes > m.maxBytes {
+        oldest := m.order.Back()
+        entry := oldest.Value.(*memoryEntry)
+        m.order.Remove(oldest)
+        delete(m.entries, entry.key)
+        m.bytes -= len(entry.pcm)
+    }
+}
+
+// Len and Bytes report the cache's size for metrics.
+func (m *MemoryCache) Len() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.entries)
+}
+
+func (m *MemoryCache) Bytes() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.bytes
+}
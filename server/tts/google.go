@@ -0,0 +1,134 @@
+package tts
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// Google uses the Cloud Text-to-Speech REST API, which synthesizes at any
+// requested rate and accepts SSML. Styles are not supported and are ignored.
+type Google struct {
+    APIKey string
+    Client *http.Client
+}
+
+func (g *Google) Name() string {
+    return "google"
+}
+
+func (g *Google) client() *http.Client {
+    if g.Client != nil {
+        return g.Client
+    }
+    return http.DefaultClient
+}
+
+func (g *Google) endpoint(path string) string {
+    return "https://texttospeech.googleapis.com/v1" + path + "?key=" + url.QueryEscape(g.APIKey)
+}
+
+func (g *Google) Voices(ctx context.Context) ([]Voice, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.endpoint("/voices"), nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    var response struct {
+        Voices []struct {
+            Name                   string   `json:"name"`
+            LanguageCodes          []string `json:"languageCodes"`
+            SsmlGender             string   `json:"ssmlGender"`
+            NaturalSampleRateHertz int      `json:"naturalSampleRateHertz"`
+        } `json:"voices"`
+    }
+    if err := getJSON(g.client(), req, &response); err != nil {
+        return nil, fmt.Errorf("google: %v", err)
+    }
+    
+    voices := make([]Voice, 0, len(response.Voices))
+    for _, v := range response.Voices {
+        voice := Voice{
+            Id:         v.Name,
+            Name:       v.Name,
+            Provider:   g.Name(),
+            Gender:     strings.ToLower(v.SsmlGender),
+            SampleRate: v.NaturalSampleRateHertz,
+            SSML:       true,
+        }
+        if len(v.LanguageCodes) > 0 {
+            voice.Language = v.LanguageCodes[0]
+        }
+        voices = append(voices, voice)
+    }
+    return voices, nil
+}
+
+func (g *Google) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+    if req.Voice == "" {
+        return nil, ErrNoVoice
+    }
+    input := map[string]string{"text": req.Text}
+    if req.SSML {
+        input = map[string]string{"ssml": req.Text}
+    }
+    language := req.Language
+    if language == "" {
+        language = voiceLanguage(req.Voice)
+    }
+    audioConfig := map[string]interface{}{
+        "audioEncoding": "LINEAR16",
+        "speakingRate":  speed(req),
+    }
+    if req.SampleRate > 0 {
+        audioConfig["sampleRateHertz"] = req.SampleRate
+    }
+    body, _ := json.Marshal(map[string]interface{}{
+        "input":       input,
+        "voice":       map[string]string{"languageCode": language, "name": req.Voice},
+        "audioConfig": audioConfig,
+    })
+    
+    httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint("/text:synthesize"), bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+    
+    var response struct {
+        AudioContent string `json:"audioContent"`
+    }
+    if err := getJSON(g.client(), httpReq, &response); err != nil {
+        return nil, fmt.Errorf("google: %v", err)
+    }
+    wav, err := base64.StdEncoding.DecodeString(response.AudioContent)
+    if err != nil {
+        return nil, fmt.Errorf("google: %v", err)
+    }
+    // LINEAR16 comes back as a WAV file
+    pcm, rate, err := wavData(wav)
+    if err != nil {
+        return nil, err
+    }
+    return resampled(io.NopCloser(bytes.NewReader(pcm)), rate, req.SampleRate)
+}
+
+func getJSON(client *http.Client, req *http.Request, out interface{}) error {
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,144 @@
+package tts
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Pronunciation is one lexicon entry: a written form and how to say it,
+// Phoneme in Alphabet ("ipa" when empty, or "x-sampa") and Alias, a
+// respelling for engines that don't honour SSML.
+type Pronunciation struct {
+    Written  string
+    Phoneme  string
+    Alphabet string
+    Alias    string
+}
+
+// Lexicon marks up a tenant's words in requests before they reach a
+// provider: engines with SSML get <phoneme>, or <sub> for entries with only
+// an alias, and engines without get the alias through StripSSML. Matching
+// ignores case and takes the longest written form, on word boundaries.
+// Text already inside phoneme, sub or say-as is left alone.
+type Lexicon struct {
+    entries map[string]Pronunciation // By lower case escaped written form
+    pattern *regexp.Regexp
+}
+
+var lexiconSkip = map[string]bool{"phoneme": true, "sub": true, "say-as": true}
+
+func NewLexicon(entries []Pronunciation) *Lexicon {
+    l := &Lexicon{entries: make(map[string]Pronunciation)}
+    var forms []string
+    for _, entry := range entries {
+        written := escapeXML(strings.TrimSpace(entry.Written))
+        if written == "" || (entry.Phoneme == "" && entry.Alias == "") {
+            continue
+        }
+        key := strings.ToLower(written)
+        if _, ok := l.entries[key]; !ok {
+            forms = append(forms, written)
+        }
+        l.entries[key] = entry
+    }
+    if len(forms) == 0 {
+        return l
+    }
+    sort.Slice(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+    alternatives := make([]string, len(forms))
+    for i, form := range forms {
+        alternatives[i] = wordBoundary(form, false) + regexp.QuoteMeta(form) + wordBoundary(form, true)
+    }
+    l.pattern = regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+    return l
+}
+
+// wordBoundary is \b at an edge of the form that is a letter or digit, so
+// "C++" still matches before a space.
+func wordBoundary(form string, end bool) string {
+    r, _ := utf8.DecodeRuneInString(form)
+    if end {
+        r, _ = utf8.DecodeLastRuneInString(form)
+    }
+    if unicode.IsLetter(r) || unicode.IsDigit(r) {
+        return `\b`
+    }
+    return ""
+}
+
+// Apply returns req with the lexicon's words marked up, as an SSML document
+// when anything was. ssml tells whether the provider honours SSML, see
+// HonoursSSML.
+func (l *Lexicon) Apply(req Request, ssml bool) Request {
+    if l == nil || l.pattern == nil {
+        return req
+    }
+    if !req.SSML {
+        if text, changed := l.markUp(escapeXML(req.Text), ssml); changed {
+            req.Text, req.SSML = "<speak>"+text+"</speak>", true
+        }
+        return req
+    }
+    
+    // Mark up the text between tags, outside elements that already say how
+    var doc strings.Builder
+    skip, last, changed := 0, 0, false
+    for _, tag := range ssmlTag.FindAllStringIndex(req.Text, -1) {
+        text := req.Text[last:tag[0]]
+        if skip == 0 {
+            var marked bool
+            text, marked = l.markUp(text, ssml)
+            changed = changed || marked
+        }
+        doc.WriteString(text)
+        doc.WriteString(req.Text[tag[0]:tag[1]])
+        skip += skipDepth(req.Text[tag[0]:tag[1]])
+        last = tag[1]
+    }
+    doc.WriteString(req.Text[last:])
+    if changed {
+        req.Text = doc.String()
+    }
+    return req
+}
+
+// skipDepth is how a tag changes the depth inside lexiconSkip elements.
+func skipDepth(tag string) int {
+    name := strings.TrimLeft(strings.Trim(tag, "<>"), "/")
+    if i := strings.IndexAny(name, " \t\r\n/"); i >= 0 {
+        name = name[:i]
+    }
+    switch {
+    case !lexiconSkip[name] || strings.HasSuffix(tag, "/>"):
+        return 0
+    case strings.HasPrefix(tag, "</"):
+        return -1
+    }
+    return 1
+}
+
+// markUp wraps the lexicon's words in escaped text.
+func (l *Lexicon) markUp(text string, ssml bool) (string, bool) {
+    changed := false
+    text = l.pattern.ReplaceAllStringFunc(text, func(word string) string {
+        entry := l.entries[strings.ToLower(word)]
+        switch {
+        case ssml && entry.Phoneme != "":
+            alphabet := entry.Alphabet
+            if alphabet == "" {
+                alphabet = "ipa"
+            }
+            changed = true
+            return fmt.Sprintf(`<phoneme alphabet="%s" ph="%s">%s</phoneme>`, escapeXML(alphabet), escapeXML(entry.Phoneme), word)
+        case entry.Alias != "":
+            changed = true
+            return fmt.Sprintf(`<sub alias="%s">%s</sub>`, escapeXML(entry.Alias), word)
+        }
+        return word
+    })
+    return text, changed
+}
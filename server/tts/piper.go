@@ -0,0 +1,112 @@
+package tts
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// Piper runs the local piper engine, one process per utterance, so rooms can
+// speak without any cloud vendor. Voices are the *.onnx models in VoicesDir,
+// each with the .onnx.json config piper ships next to it. SSML is stripped.
+type Piper struct {
+    Command   string // Path to the piper binary, defaults to "piper"
+    VoicesDir string
+}
+
+type piperConfig struct {
+    Audio struct {
+        SampleRate int `json:"sample_rate"`
+    } `json:"audio"`
+    Language struct {
+        Code string `json:"code"` // e.g. en_US
+    } `json:"language"`
+}
+
+func (p *Piper) Name() string {
+    return "piper"
+}
+
+func (p *Piper) config(voice string) (piperConfig, error) {
+    var config piperConfig
+    data, err := os.ReadFile(filepath.Join(p.VoicesDir, voice+".onnx.json"))
+    if err != nil {
+        return config, err
+    }
+    err = json.Unmarshal(data, &config)
+    return config, err
+}
+
+func (p *Piper) Voices(ctx context.Context) ([]Voice, error) {
+    models, err := filepath.Glob(filepath.Join(p.VoicesDir, "*.onnx"))
+    if err != nil {
+        return nil, err
+    }
+    
+    voices := make([]Voice, 0, len(models))
+    for _, model := range models {
+        id := strings.TrimSuffix(filepath.Base(model), ".onnx")
+        config, err := p.config(id)
+        if err != nil {
+            continue // Not usable without its config
+        }
+        voices = append(voices, Voice{
+            Id:         id,
+            Name:       id,
+            Provider:   p.Name(),
+            Language:   strings.Replace(config.Language.Code, "_", "-", 1),
+            SampleRate: config.Audio.SampleRate,
+        })
+    }
+    return voices, nil
+}
+
+func (p *Piper) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+    // Voice IDs are file names, never paths
+    if req.Voice == "" || strings.ContainsAny(req.Voice, `/\`) {
+        return nil, ErrNoVoice
+    }
+    config, err := p.config(req.Voice)
+    if err != nil {
+        return nil, ErrNoVoice
+    }
+    
+    text := req.Text
+    if req.SSML {
+        text = StripSSML(text)
+    }
+    command := p.Command
+    if command == "" {
+        command = "piper"
+    }
+    cmd := exec.CommandContext(ctx, command,
+        "--model", filepath.Join(p.VoicesDir, req.Voice+".onnx"),
+        "--output_raw",
+        "--length_scale", strconv.FormatFloat(1/speed(req), 'f', 3, 64))
+    cmd.Stdin = strings.NewReader(text)
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, fmt.Errorf("piper: %v", err)
+    }
+    return resampled(&piperOutput{ReadCloser: stdout, cmd: cmd}, config.Audio.SampleRate, req.SampleRate)
+}
+
+// piperOutput reaps the process when the audio is closed.
+type piperOutput struct {
+    io.ReadCloser
+    cmd *exec.Cmd
+}
+
+func (o *piperOutput) Close() error {
+    o.ReadCloser.Close()
+    return o.cmd.Wait()
+}
@@ -0,0 +1,162 @@
+package tts
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// RedisCache shares the cache between server replicas. Entries expire after
+// the TTL and Redis' own maxmemory policy (allkeys-lru is a good fit) does
+// the size based eviction. It speaks just enough RESP for GET and SET, over
+// one connection that is redialled after any error; a cache that is down
+// only costs a synthesis, so errors are treated as misses.
+type RedisCache struct {
+    addr     string
+    password string
+    db       int
+    prefix   string
+    ttl      time.Duration
+    
+    mu   sync.Mutex
+    conn net.Conn
+    r    *bufio.Reader
+}
+
+// NewRedisCache takes a redis://[:password@]host:port[/db] URL.
+func NewRedisCache(rawURL string, ttl time.Duration) (*RedisCache, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil || u.Scheme != "redis" || u.Host == "" {
+        return nil, fmt.Errorf("tts: bad redis URL %q", rawURL)
+    }
+    c := &RedisCache{addr: u.Host, prefix: "iva:tts:", ttl: ttl}
+    if password, ok := u.User.Password(); ok {
+        c.password = password
+    }
+    if db := strings.Trim(u.Path, "/"); db != "" {
+        if c.db, err = strconv.Atoi(db); err != nil {
+            return nil, fmt.Errorf("tts: bad redis database %q", db)
+        }
+    }
+    return c, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, int, bool) {
+    value, err := c.do(ctx, "GET", c.prefix+key)
+    if err != nil || len(value) < 4 {
+        return nil, 0, false
+    }
+    return value[4:], int(binary.BigEndian.Uint32(value)), true
+}
+
+func (c *RedisCache) Put(ctx context.Context, key string, pcm []byte, sampleRate int) {
+    value := make([]byte, 4+len(pcm))
+    binary.BigEndian.PutUint32(value, uint32(sampleRate))
+    copy(value[4:], pcm)
+    c.do(ctx, "SET", c.prefix+key, string(value), "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+}
+
+func (c *RedisCache) do(ctx context.Context, args ...string) ([]byte, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    if c.conn == nil {
+        if err := c.dial(ctx); err != nil {
+            return nil, err
+        }
+    }
+    value, err := c.command(ctx, args...)
+    if err != nil {
+        if _, isReply := err.(redisError); !isReply {
+            c.conn.Close()
+            c.conn = nil
+        }
+    }
+    return value, err
+}
+
+func (c *RedisCache) dial(ctx context.Context) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", c.addr)
+    if err != nil {
+        return err
+    }
+    c.conn, c.r = conn, bufio.NewReader(conn)
+    
+    if c.password != "" {
+        if _, err := c.command(ctx, "AUTH", c.password); err != nil {
+            conn.Close()
+            c.conn = nil
+            return err
+        }
+    }
+    if c.db != 0 {
+        if _, err := c.command(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+            conn.Close()
+            c.conn = nil
+            return err
+        }
+    }
+    return nil
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+    return "redis: " + string(e)
+}
+
+// command must be called with mu held and a live connection.
+func (c *RedisCache) command(ctx context.Context, args ...string) ([]byte, error) {
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(2 * time.Second)
+    }
+    c.conn.SetDeadline(deadline)
+    
+    var b strings.Builder
+    fmt.Fprintf(&b, "*%d\r\n", len(args))
+    for _, arg := range args {
+        fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+    }
+    if _, err := c.conn.Write([]byte(b.String())); err != nil {
+        return nil, err
+    }
+    
+    line, err := c.r.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if line == "" {
+        return nil, fmt.Errorf("redis: empty reply")
+    }
+    switch line[0] {
+    case '+', ':':
+        return []byte(line[1:]), nil
+    case '-':
+        return nil, redisError(line[1:])
+    case '$':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil {
+            return nil, err
+        }
+        if n < 0 {
+            return nil, redisError("nil")
+        }
+        value := make([]byte, n+2)
+        if _, err := io.ReadFull(c.r, value); err != nil {
+            return nil, err
+        }
+        return value[:n], nil
+    }
+    return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,194 @@
+package tts
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// SSML documents are checked before they reach a provider, so a typo is an
+// error for the author rather than a vendor's 400 or a voice reading tags.
+// Only elements Azure and Google both honour are accepted; voices are the
+// room's, so <voice> isn't, nor <audio>, which would have the vendor fetch
+// URLs. Engines without SSML speak StripSSML's text, sub aliases included.
+
+var ssmlElements = map[string]bool{
+    "speak": true, "p": true, "s": true, "break": true, "emphasis": true, "prosody": true,
+    "say-as": true, "phoneme": true, "sub": true, "lang": true, "mark": true,
+}
+
+var breakTime = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ms|s)$`)
+
+var spaceBeforePunctuation = regexp.MustCompile(` ([,.;:!?])`)
+
+// MaxBreak is the longest <break time> accepted.
+const MaxBreak = 10.0 // Seconds
+
+// HonoursSSML tells whether a provider speaks SSML, rather than StripSSML's
+// text of it.
+func HonoursSSML(provider string) bool {
+    return provider != "piper"
+}
+
+// IsSSML tells an SSML document from plain text.
+func IsSSML(text string) bool {
+    return strings.HasPrefix(strings.TrimSpace(text), "<speak")
+}
+
+// ValidateSSML checks a document is well formed, rooted in <speak> and uses
+// only the elements above with sensible breaks and emphasis.
+func ValidateSSML(doc string) error {
+    decoder := xml.NewDecoder(strings.NewReader(doc))
+    depth := 0
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return fmt.Errorf("malformed SSML: %v", err)
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            name := t.Name.Local
+            switch {
+            case depth == 0 && name != "speak":
+                return fmt.Errorf("SSML must be a <speak> document, not <%s>", name)
+            case depth > 0 && name == "speak":
+                return fmt.Errorf("<speak> can't be nested")
+            case !ssmlElements[name]:
+                return fmt.Errorf("<%s> is not supported, use speak, p, s, break, emphasis, prosody, say-as, phoneme, sub, lang or mark", name)
+            }
+            if err := checkSSMLAttributes(t); err != nil {
+                return err
+            }
+            depth++
+        case xml.EndElement:
+            depth--
+        case xml.CharData:
+            if depth == 0 && strings.TrimSpace(string(t)) != "" {
+                return fmt.Errorf("text outside <speak>")
+            }
+        }
+    }
+    if depth != 0 {
+        return fmt.Errorf("malformed SSML: unclosed elements")
+    }
+    return nil
+}
+
+func checkSSMLAttributes(element xml.StartElement) error {
+    switch element.Name.Local {
+    case "break":
+        if time := attr(element, "time"); time != "" {
+            m := breakTime.FindStringSubmatch(time)
+            if m == nil {
+                return fmt.Errorf(`<break time=%q>: use a time like "500ms" or "2s"`, time)
+            }
+            seconds, _ := strconv.ParseFloat(m[1], 64)
+            if m[2] == "ms" {
+                seconds /= 1000
+            }
+            if seconds > MaxBreak {
+                return fmt.Errorf("<break time=%q> is over %gs", time, MaxBreak)
+            }
+        }
+    case "emphasis":
+        switch level := attr(element, "level"); level {
+        case "", "strong", "moderate", "reduced", "none":
+        default:
+            return fmt.Errorf("<emphasis level=%q>: use strong, moderate, reduced or none", level)
+        }
+    case "sub":
+        if attr(element, "alias") == "" {
+            return fmt.Errorf("<sub> needs an alias")
+        }
+    case "phoneme":
+        if attr(element, "ph") == "" {
+            return fmt.Errorf("<phoneme> needs ph")
+        }
+    case "say-as":
+        if attr(element, "interpret-as") == "" {
+            return fmt.Errorf("<say-as> needs interpret-as")
+        }
+    }
+    return nil
+}
+
+func attr(element xml.StartElement, name string) string {
+    for _, a := range element.Attr {
+        if a.Name.Local == name {
+            return a.Value
+        }
+    }
+    return ""
+}
+
+// SSMLBody is what a document's <speak> holds, for vendors that wrap it in
+// their own <speak> and <voice>.
+func SSMLBody(doc string) string {
+    decoder := xml.NewDecoder(strings.NewReader(doc))
+    for {
+        token, err := decoder.Token()
+        if err != nil {
+            return escapeXML(StripSSML(doc))
+        }
+        if start, ok := token.(xml.StartElement); ok && start.Name.Local == "speak" {
+            break
+        }
+    }
+    body := doc[decoder.InputOffset():]
+    if end := strings.LastIndex(body, "</speak>"); end >= 0 {
+        return body[:end]
+    }
+    return "" // <speak/>
+}
+
+// StripSSML reduces an SSML document to its spoken text for engines without
+// SSML support: tags go, a sub's alias replaces what it stands for.
+func StripSSML(ssml string) string {
+    decoder := xml.NewDecoder(strings.NewReader(ssml))
+    var text strings.Builder
+    skip := 0 // Depth inside a sub whose alias was spoken instead
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return stripTags(ssml)
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            if skip > 0 {
+                skip++
+                continue
+            }
+            text.WriteString(" ")
+            if alias := attr(t, "alias"); t.Name.Local == "sub" && alias != "" {
+                text.WriteString(alias)
+                skip = 1
+            }
+        case xml.EndElement:
+            if skip > 0 {
+                skip--
+            }
+            text.WriteString(" ")
+        case xml.CharData:
+            if skip == 0 {
+                text.Write(t)
+            }
+        }
+    }
+    return spaceBeforePunctuation.ReplaceAllString(strings.Join(strings.Fields(text.String()), " "), "$1")
+}
+
+// stripTags is StripSSML for documents that don't parse.
+func stripTags(ssml string) string {
+    text := ssmlTag.ReplaceAllString(ssml, " ")
+    text = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&amp;", "&").Replace(text)
+    return strings.Join(strings.Fields(text), " ")
+}
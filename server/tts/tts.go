@@ -0,0 +1,133 @@
+// Package tts mirrors package stt for speech synthesis: one Provider
+// interface over cloud vendors and a local engine, a voice catalog, and pcm16
+// output at whatever sample rate the caller asks for.
+package tts
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "io"
+    "math"
+    "regexp"
+    "strings"
+)
+
+var ErrNoVoice = errors.New("tts: voice not available")
+
+type Voice struct {
+    Id         string   `json:"id"` // What Request.Voice takes
+    Name       string   `json:"name"`
+    Provider   string   `json:"provider"`
+    Language   string   `json:"language"` // BCP-47
+    Gender     string   `json:"gender,omitempty"`
+    Styles     []string `json:"styles,omitempty"`
+    SampleRate int      `json:"sampleRate,omitempty"` // Native rate, zero if any
+    SSML       bool     `json:"ssml"`                 // Whether SSML input is honoured rather than stripped
+}
+
+type Request struct {
+    Text       string  // Plain text, or an SSML document when SSML is set, see ValidateSSML
+    SSML       bool
+    Voice      string
+    Language   string  // Needed by vendors that can't infer it from the voice
+    Style      string  // Speaking style, where the voice supports one
+    Speed      float64 // 1 is normal, zero means 1
+    SampleRate int     // Rate of the returned pcm16
+}
+
+// Audio is mono pcm16 at Request.SampleRate. Body may still be streaming
+// from the vendor and must be closed.
+type Audio struct {
+    SampleRate int
+    Body       io.ReadCloser
+    Cached     bool // Served from a CacheStore without calling the vendor
+}
+
+type Provider interface {
+    Name() string
+    Voices(ctx context.Context) ([]Voice, error)
+    Synthesize(ctx context.Context, req Request) (*Audio, error)
+}
+
+func speed(req Request) float64 {
+    if req.Speed <= 0 {
+        return 1
+    }
+    return req.Speed
+}
+
+var ssmlTag = regexp.MustCompile(`<[^>]*>`) // See ssml.go
+
+func escapeXML(text string) string {
+    return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;").Replace(text)
+}
+
+// Resample converts mono pcm16 between sample rates with linear interpolation.
+func Resample(pcm []byte, from int, to int) []byte {
+    if from == to || from <= 0 || to <= 0 {
+        return pcm
+    }
+    samples := len(pcm) / 2
+    if samples == 0 {
+        return nil
+    }
+    at := func(i int) float64 {
+        return float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+    }
+    
+    ratio := float64(from) / float64(to)
+    out := make([]byte, int(float64(samples)/ratio)*2)
+    for i := 0; i < len(out)/2; i++ {
+        pos := float64(i) * ratio
+        j := int(pos)
+        v := at(samples - 1)
+        if j+1 < samples {
+            frac := pos - float64(j)
+            v = at(j)*(1-frac) + at(j+1)*frac
+        }
+        binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(math.Round(v))))
+    }
+    return out
+}
+
+// resampled wraps a vendor body, converting it in one go when its rate
+// differs from the one requested; matching bodies stay streaming.
+func resampled(body io.ReadCloser, rate int, want int) (*Audio, error) {
+    if want == 0 || rate == want {
+        return &Audio{SampleRate: rate, Body: body}, nil
+    }
+    defer body.Close()
+    pcm, err := io.ReadAll(body)
+    if err != nil {
+        return nil, err
+    }
+    return &Audio{SampleRate: want, Body: io.NopCloser(bytes.NewReader(Resample(pcm, rate, want)))}, nil
+}
+
+// wavData returns the data chunk of a RIFF WAV file and its sample rate.
+func wavData(wav []byte) ([]byte, int, error) {
+    if len(wav) < 12 || string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" {
+        return nil, 0, errors.New("tts: not a WAV file")
+    }
+    rate := 0
+    for offset := 12; offset+8 <= len(wav); {
+        id := string(wav[offset : offset+4])
+        size := int(binary.LittleEndian.Uint32(wav[offset+4:]))
+        body := wav[offset+8:]
+        if size > len(body) {
+            size = len(body)
+        }
+        switch id {
+        case "fmt ":
+            if size >= 8 {
+                rate = int(binary.LittleEndian.Uint32(body[4:]))
+            }
+        case "data":
+            return body[:size], rate, nil
+        }
+        offset += 8 + size + size%2
+    }
+    return nil, 0, errors.New("tts: WAV has no data chunk")
+}
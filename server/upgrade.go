@@ -0,0 +1,375 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "os/signal"
+    "strconv"
+    "syscall"
+    "time"
+)
+
+// Hot upgrades. SIGUSR2 starts the binary on disk as a new process that
+// inherits the listening socket, so no connection is ever refused:
+//
+//   1. The new process starts with the listener as fd 3 and loads its
+//      config, then reports ready. If it fails or takes longer than
+//      -upgrade-timeout the upgrade is abandoned and the old process carries
+//      on as if nothing happened.
+//   2. The old process stops accepting, connections queue in the kernel's
+//      backlog, and it hands over what resuming clients need: the rooms'
+//      journals, the offline queues, every session's resume token and the
+//      server registry. It syncs and closes its outbox log first, writing
+//      and publishing no more events, and the new process loads the log
+//      once the state has arrived.
+//   3. It closes every WebSocket with server_shutdown. Clients
+//      reconnect with lastSeq, land on the new process, which only starts
+//      accepting once it has the journals, and get what they missed
+//      replayed. The old process exits once its HTTP requests have finished.
+//
+// Live calls thus see a reconnect of a few hundred milliseconds rather than
+// dropping. State not listed above, e.g. an STT stream or a speech in
+// flight, restarts with the connection, and the old process closes each
+// room's CDR as its participants leave. With -pid-file the new process
+// writes its PID, for supervisors that track it. SIGTERM and SIGINT shut the
+// server down instead, see drain.go.
+
+const (
+    upgradeEnv      = "IVA_UPGRADE"
+    upgradeListenFd = 3
+    upgradeStateFd  = 4
+    upgradeReadyFd  = 5
+)
+
+// handoffState is what the old process passes the new one, as JSON.
+type handoffState struct {
+    Journals map[string]handoffJournal `json:"journals"`
+    Offline  map[string][]handoffQueued `json:"offline"`
+    Sessions []handoffSession           `json:"sessions"`
+    Servers  []ServerInfo               `json:"servers"`
+}
+
+type handoffJournal struct {
+    Seq     uint64         `json:"seq"`
+    Events  []handoffEntry `json:"events"`
+    Updated time.Time      `json:"updated"`
+}
+
+type handoffEntry struct {
+    Msg      Message    `json:"msg"`
+    Exclude  string     `json:"exclude,omitempty"`
+    Audience ClientType `json:"audience,omitempty"`
+}
+
+type handoffQueued struct {
+    Msg      *Message  `json:"msg"`
+    QueuedAt time.Time `json:"queuedAt"`
+}
+
+// handoffSession is what resuming a connected or held client restores.
+type handoffSession struct {
+    RoomId      string                 `json:"roomId"`
+    ClientId    string                 `json:"clientId"`
+    RequestedId string                 `json:"requestedId,omitempty"`
+    ClientType  ClientType             `json:"clientType"`
+    Token       string                 `json:"token,omitempty"`
+    TokenHash   string                 `json:"tokenHash,omitempty"` // A failed-over session's, see failover.go
+    DisplayName string                 `json:"displayName,omitempty"`
+    Avatar      string                 `json:"avatar,omitempty"`
+    Metadata    map[string]interface{} `json:"metadata,omitempty"`
+    Since       time.Time              `json:"since"` // When it dropped, or the handoff for a connected one
+}
+
+// serve accepts on -addr, or on the listener an upgrading process passed in,
+// until an upgrade hands it over.
+func serve() error {
+    upgraded := os.Getenv(upgradeEnv) != ""
+    var ln net.Listener
+    var err error
+    if upgraded {
+        ln, err = net.FileListener(os.NewFile(upgradeListenFd, "listener"))
+        os.Unsetenv(upgradeEnv)
+    } else {
+        ln, err = net.Listen("tcp", cfg.Addr)
+    }
+    if err != nil {
+        return err
+    }
+    tlsConfig, err := serverTLSConfig() // See tls.go
+    if err != nil {
+        return err
+    }
+    if upgraded {
+        // Ready, then take over: the old process stops accepting and sends
+        // its journals, which must be in place before the first reconnect
+        ready := os.NewFile(upgradeReadyFd, "ready")
+        ready.Write([]byte{1})
+        ready.Close()
+        restoreHandoff(os.NewFile(upgradeStateFd, "state"))
+    }
+    if cfg.PidFile != "" {
+        if err := os.WriteFile(cfg.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+            log.Printf("level=warn Writing -pid-file: %v", err)
+        }
+    }
+    
+    server := &http.Server{}
+    go watchSignals(server, ln)
+    served := ln
+    if tlsConfig != nil {
+        served = tls.NewListener(ln, tlsConfig)
+    }
+    if err := server.Serve(served); err != http.ErrServerClosed {
+        return err
+    }
+    select {} // Handed over or shutting down, which exits once requests finish
+}
+
+func watchSignals(server *http.Server, ln net.Listener) {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
+    for sig := range signals {
+        if sig != syscall.SIGUSR2 {
+            shutdown(server)
+        }
+        if err := upgrade(server, ln); err != nil {
+            log.Printf("level=error Upgrade abandoned: %v", err)
+        }
+    }
+}
+
+func upgrade(server *http.Server, ln net.Listener) error {
+    if cfg.RegistryAddr != "" {
+        return fmt.Errorf("the -registry-addr listener can't be handed over, restart instead")
+    }
+    tcp, ok := ln.(*net.TCPListener)
+    if !ok {
+        return fmt.Errorf("listener is not TCP")
+    }
+    listenFile, err := tcp.File()
+    if err != nil {
+        return err
+    }
+    defer listenFile.Close()
+    executable, err := os.Executable()
+    if err != nil {
+        return err
+    }
+    stateRead, stateWrite, err := os.Pipe()
+    if err != nil {
+        return err
+    }
+    defer stateWrite.Close()
+    readyRead, readyWrite, err := os.Pipe()
+    if err != nil {
+        stateRead.Close()
+        return err
+    }
+    defer readyRead.Close()
+    
+    cmd := exec.Command(executable, os.Args[1:]...)
+    cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+    cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+    cmd.ExtraFiles = []*os.File{listenFile, stateRead, readyWrite} // fds 3, 4 and 5
+    err = cmd.Start()
+    stateRead.Close()
+    readyWrite.Close()
+    if err != nil {
+        return err
+    }
+    log.Printf("Upgrading: started %s as PID %d", executable, cmd.Process.Pid)
+    
+    ready := make(chan error, 1)
+    go func() {
+        _, err := readyRead.Read(make([]byte, 1))
+        ready <- err
+    }()
+    select {
+    case err := <-ready:
+        if err != nil {
+            cmd.Wait()
+            return fmt.Errorf("the new process exited before it was ready")
+        }
+    case <-time.After(cfg.UpgradeTimeout):
+        cmd.Process.Kill()
+        cmd.Wait()
+        return fmt.Errorf("the new process wasn't ready within %s", cfg.UpgradeTimeout)
+    }
+    
+    // Stop accepting, then everything clients resume from goes over before
+    // they are told to reconnect
+    shutdown := make(chan struct{})
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.UpgradeTimeout)
+        defer cancel()
+        server.Shutdown(ctx)
+        close(shutdown)
+    }()
+    if tlsHTTPServer != nil {
+        tlsHTTPServer.Close() // The new process is waiting for the address
+    }
+    state := snapshotHandoff()
+    if events != nil {
+        events.handOver() // Closed before the state goes, which is the new process's cue to load it
+    }
+    if err := json.NewEncoder(stateWrite).Encode(state); err != nil {
+        log.Printf("level=error Handing over state failed, clients resume without replay: %v", err)
+    }
+    stateWrite.Close()
+    closed := closeForUpgrade()
+    log.Printf("Upgrade: handed over %d journals and %d sessions, asked %d clients to reconnect", len(state.Journals), len(state.Sessions), closed)
+    
+    <-shutdown
+    os.Exit(0)
+    return nil
+}
+
+func snapshotHandoff() handoffState {
+    state := handoffState{Journals: make(map[string]handoffJournal), Offline: make(map[string][]handoffQueued)}
+    
+    journalsMu.Lock()
+    for roomId, j := range journals {
+        j.mu.Lock()
+        events := make([]handoffEntry, 0, len(j.events))
+        for _, entry := range j.events {
+            events = append(events, handoffEntry{Msg: entry.msg, Exclude: entry.exclude, Audience: entry.audience})
+        }
+        state.Journals[roomId] = handoffJournal{Seq: j.seq, Events: events, Updated: j.updated}
+        j.mu.Unlock()
+    }
+    journalsMu.Unlock()
+    
+    offlineQueuesMu.Lock()
+    for key, queue := range offlineQueues {
+        for _, queued := range queue {
+            state.Offline[key] = append(state.Offline[key], handoffQueued{Msg: queued.msg, QueuedAt: queued.queuedAt})
+        }
+    }
+    offlineQueuesMu.Unlock()
+    
+    if cfg.ResumeGrace > 0 {
+        roomsMu.RLock()
+        for roomId, room := range rooms {
+            for _, held := range room.held {
+                state.Sessions = appendHandoffSession(state.Sessions, roomId, held.client, held.since)
+            }
+            for _, members := range []map[string]*Client{room.Users, room.Agents} {
+                for _, client := range members {
+                    state.Sessions = appendHandoffSession(state.Sessions, roomId, client, time.Now())
+                }
+            }
+        }
+        roomsMu.RUnlock()
+    }
+    
+    serversMu.Lock()
+    state.Servers = append([]ServerInfo(nil), servers...)
+    serversMu.Unlock()
+    return state
+}
+
+// appendHandoffSession adds client's session unless it has no token to resume with.
+func appendHandoffSession(sessions []handoffSession, roomId string, client *Client, since time.Time) []handoffSession {
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    if client.resumeToken == "" && client.resumeTokenHash == "" {
+        return sessions
+    }
+    metadata := make(map[string]interface{}, len(client.metadata))
+    for key, value := range client.metadata {
+        metadata[key] = value // Copied, it's encoded after the lock is gone
+    }
+    return append(sessions, handoffSession{
+        RoomId:      roomId,
+        ClientId:    client.clientId,
+        RequestedId: client.requestedId,
+        ClientType:  client.clientType,
+        Token:       client.resumeToken,
+        TokenHash:   client.resumeTokenHash,
+        DisplayName: client.displayName,
+        Avatar:      client.avatar,
+        Metadata:    metadata,
+        Since:       since,
+    })
+}
+
+// restoreHandoff loads the old process's state, starting empty when it
+// sends none, then the outbox log it has stopped writing.
+func restoreHandoff(from *os.File) {
+    defer from.Close()
+    defer takeOverOutbox()
+    var state handoffState
+    if err := json.NewDecoder(from).Decode(&state); err != nil {
+        log.Printf("level=warn No state from the old process, clients resume without replay: %v", err)
+        return
+    }
+    
+    journalsMu.Lock()
+    for roomId, saved := range state.Journals {
+        j := &roomJournal{seq: saved.Seq, updated: saved.Updated}
+        for _, entry := range saved.Events {
+            j.events = append(j.events, journalEntry{msg: entry.Msg, exclude: entry.Exclude, audience: entry.Audience})
+        }
+        journals[roomId] = j
+    }
+    journalsMu.Unlock()
+    
+    offlineQueuesMu.Lock()
+    for key, queue := range state.Offline {
+        for _, queued := range queue {
+            offlineQueues[key] = append(offlineQueues[key], queuedMessage{msg: queued.Msg, queuedAt: queued.QueuedAt})
+        }
+    }
+    offlineQueuesMu.Unlock()
+    
+    // Held before the server accepts, so no reconnect beats its session
+    for _, saved := range state.Sessions {
+        metadata := saved.Metadata
+        if metadata == nil {
+            metadata = make(map[string]interface{})
+        }
+        holdHandedSession(saved.RoomId, &Client{
+            room:            saved.RoomId,
+            clientId:        saved.ClientId,
+            requestedId:     saved.RequestedId,
+            clientType:      saved.ClientType,
+            resumeToken:     saved.Token,
+            resumeTokenHash: saved.TokenHash,
+            displayName:     saved.DisplayName,
+            avatar:          saved.Avatar,
+            metadata:        metadata,
+        }, saved.Since)
+    }
+    
+    serversMu.Lock()
+    servers = append(servers, state.Servers...)
+    serversMu.Unlock()
+    log.Printf("Upgrade: took over %d journals, %d sessions and %d registered servers", len(state.Journals), len(state.Sessions), len(state.Servers))
+}
+
+// closeForUpgrade asks every connected client to reconnect.
+func closeForUpgrade() int {
+    roomsMu.RLock()
+    var members []*Client
+    for _, room := range rooms {
+        for _, client := range room.Users {
+            members = append(members, client)
+        }
+        for _, client := range room.Agents {
+            members = append(members, client)
+        }
+    }
+    roomsMu.RUnlock()
+    
+    for _, client := range members {
+        closeConnection(client.conn, CloseServerShutdown, "")
+    }
+    return len(members)
+}
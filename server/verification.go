@@ -0,0 +1,494 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+    
+    "github.com/yourusername/my-go-project/verify"
+)
+
+// Caller identity verification. The IVA, or a human agent, starts one
+// mid-call:
+//
+//   {"type": "verify_start", "data": {"method": "kba", "userId": "caller-1", "customerId": "C-1042"}}
+//
+// otp also takes "phone"; both fall back to the caller's customerId and phone
+// metadata. The agents and the caller get a verify_challenge with the prompts
+// to put to the caller, and either of them answers with
+//
+//   {"type": "verify_answer", "data": {"verificationId": ID, "answers": {"q1": "...", "q2": "..."}}}
+//
+// voice needs no answer, the caller's recent audio is scored right away.
+// Outcomes go to the agents and the caller as verify_result, to the event
+// outbox and to the audit log. Answers are never kept in history or traces.
+//
+// Tools named in -sensitive-tools are gated on the result: an agent asks
+// with tool_authorize {"tool": "refund", "userId": "caller-1"} before running
+// one and gets a tool_authorization back.
+
+// callerVerification is where a caller stands on this call. Guarded by roomsMu.
+type callerVerification struct {
+    Method     string  `json:"method,omitempty"` // Of the last passed verification
+    CustomerId string  `json:"customerId,omitempty"`
+    Verified   bool    `json:"verified"`
+    Score      float64 `json:"score,omitempty"`
+    VerifiedAt int64   `json:"verifiedAt,omitempty"`
+    Failures   int     `json:"failures"`
+    Pending    string  `json:"pending,omitempty"` // Method of the open challenge
+    pending    *pendingVerification
+    
+    RecognizedAs     string  `json:"recognizedAs,omitempty"` // Customer whose voiceprint matched, see speaker.go
+    RecognitionScore float64 `json:"recognitionScore,omitempty"`
+}
+
+type pendingVerification struct {
+    id        string
+    method    verify.Method
+    subject   verify.Subject
+    challenge *verify.Challenge // Nil until the method has issued it
+}
+
+const minVoiceSeconds = 3
+
+var verificationMethods = make(map[string]verify.Method)
+
+var (
+    verificationsTotal      = newCounterVec("iva_verifications_total", "Caller verifications by method and outcome.", "method", "outcome")
+    toolAuthorizationsTotal = newCounterVec("iva_tool_authorizations_total", "Sensitive tool authorizations by outcome.", "outcome")
+)
+
+func init() {
+    metricSeries = append(metricSeries, verificationsTotal, toolAuthorizationsTotal)
+}
+
+// loadVerification enables each method whose service is configured.
+func loadVerification() {
+    client := &http.Client{Timeout: cfg.VerifyTimeout}
+    if cfg.VerifyKBAURL != "" {
+        verificationMethods["kba"] = &verify.KBA{
+            URL:        cfg.VerifyKBAURL,
+            APIKey:     cfg.VerifyAPIKey,
+            Questions:  cfg.VerifyKBAQuestions,
+            MinCorrect: cfg.VerifyKBAMinCorrect,
+            Client:     client,
+        }
+    }
+    if cfg.VerifySMSWebhook != "" {
+        verificationMethods["otp"] = &verify.OTP{
+            WebhookURL: cfg.VerifySMSWebhook,
+            APIKey:     cfg.VerifyAPIKey,
+            Length:     cfg.VerifyOTPLength,
+            TTL:        cfg.VerifyOTPTTL,
+            Message:    cfg.VerifyOTPMessage,
+            Client:     client,
+        }
+    }
+    if speakers != nil {
+        verificationMethods["voice"] = &verify.Voice{
+            Speakers:   speakers,
+            Threshold:  cfg.VerifyVoiceThreshold,
+            MinSeconds: minVoiceSeconds,
+        }
+    }
+}
+
+func verificationMethodNames() []string {
+    names := make([]string, 0, len(verificationMethods))
+    for name := range verificationMethods {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func isSensitiveTool(tool string) bool {
+    for _, t := range cfg.SensitiveTools {
+        if t == tool {
+            return true
+        }
+    }
+    return false
+}
+
+// verificationFor returns the caller's state, creating it. Callers hold roomsMu.
+func verificationFor(room *RoomInfo, userId string) *callerVerification {
+    if room.verifications == nil {
+        room.verifications = make(map[string]*callerVerification)
+    }
+    state := room.verifications[userId]
+    if state == nil {
+        state = &callerVerification{}
+        room.verifications[userId] = state
+    }
+    return state
+}
+
+// current reports whether a passed verification still holds, see -verify-ttl.
+func (v *callerVerification) current() bool {
+    if !v.Verified {
+        return false
+    }
+    return cfg.VerifyTTL == 0 || time.Now().UnixNano()/int64(time.Millisecond)-v.VerifiedAt < cfg.VerifyTTL.Milliseconds()
+}
+
+// verificationTarget finds the caller a message is about, by default the
+// room's only user.
+func verificationTarget(roomId string, data map[string]interface{}) (*RoomInfo, *Client, error) {
+    room, users, _ := roomMembers(roomId)
+    if room == nil {
+        return nil, nil, newCodedError(ErrTargetNotFound, "room is closed")
+    }
+    userId, _ := data["userId"].(string)
+    if userId == "" {
+        if len(users) != 1 {
+            return nil, nil, newCodedError(ErrInvalidMessage, "userId required in a room with %d users", len(users))
+        }
+        return room, users[0], nil
+    }
+    for _, user := range users {
+        if user.clientId == userId {
+            return room, user, nil
+        }
+    }
+    return nil, nil, newCodedError(ErrTargetNotFound, "user %q is not in the room", userId)
+}
+
+func handleVerification(roomId string, sender *Client, msg *Message) {
+    switch msg.Type {
+    case "verify_start":
+        handleVerifyStart(roomId, sender, msg)
+    case "verify_answer":
+        handleVerifyAnswer(roomId, sender, msg)
+    case "tool_authorize":
+        handleToolAuthorize(roomId, sender, msg)
+    }
+}
+
+func isVerificationMessage(msgType string) bool {
+    return msgType == "verify_start" || msgType == "verify_answer" || msgType == "tool_authorize"
+}
+
+func handleVerifyStart(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    name, _ := data["method"].(string)
+    method := verificationMethods[name]
+    if method == nil {
+        sendError(sender, ErrInvalidMessage, msg, "verification method %q is not configured (available: %s)", name, strings.Join(verificationMethodNames(), ", "))
+        return
+    }
+    room, user, err := verificationTarget(roomId, data)
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    
+    subject := verify.Subject{Tenant: room.Tenant, RoomId: roomId}
+    subject.CustomerId, _ = data["customerId"].(string)
+    subject.Phone, _ = data["phone"].(string)
+    user.mu.Lock()
+    if subject.CustomerId == "" {
+        subject.CustomerId, _ = user.metadata["customerId"].(string)
+    }
+    if subject.Phone == "" {
+        subject.Phone, _ = user.metadata["phone"].(string)
+    }
+    user.mu.Unlock()
+    audio := voiceSampleOf(user)
+    subject.Audio, subject.SampleRate = audio.PCM, audio.SampleRate
+    
+    roomsMu.Lock()
+    state := verificationFor(room, user.clientId)
+    if failures := state.Failures; failures >= cfg.VerifyMaxAttempts {
+        roomsMu.Unlock()
+        sendError(sender, ErrNotPermitted, msg, "%s has failed verification %d times on this call", user.clientId, failures)
+        return
+    }
+    // A new start replaces any challenge still waiting for an answer
+    p := &pendingVerification{id: newRandomId(), method: method, subject: subject}
+    state.pending = p
+    state.Pending = name
+    roomsMu.Unlock()
+    
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+        defer cancel()
+        challenge, err := method.Start(ctx, subject)
+        if err != nil {
+            roomsMu.Lock()
+            if state.pending == p {
+                state.pending = nil
+                state.Pending = ""
+            }
+            roomsMu.Unlock()
+            logAt("warn", roomId, user.clientId, "Verification (%s) unavailable: %v", name, err)
+            verificationsTotal.inc(room.labels, name, "error")
+            audit(room, sender.clientId, "verification_error", user.clientId, "error", map[string]interface{}{
+                "method":     name,
+                "customerId": subject.CustomerId,
+                "error":      err.Error(),
+            })
+            sendError(sender, ErrUnavailable, msg, "verification unavailable: %v", err)
+            return
+        }
+        
+        roomsMu.Lock()
+        superseded := state.pending != p
+        if !superseded {
+            p.challenge = challenge
+        }
+        roomsMu.Unlock()
+        if superseded {
+            return
+        }
+        if len(challenge.Prompts) == 0 {
+            checkVerification(room, user.clientId, sender.clientId, p, nil)
+            return
+        }
+        
+        challengeData := map[string]interface{}{
+            "verificationId": p.id,
+            "userId":         user.clientId,
+            "method":         name,
+            "prompts":        challenge.Prompts,
+        }
+        if !challenge.ExpiresAt.IsZero() {
+            challengeData["expiresAt"] = challenge.ExpiresAt.UnixNano() / int64(time.Millisecond)
+        }
+        notice := &Message{
+            Id:        newMessageId(),
+            Type:      "verify_challenge",
+            From:      SystemSender,
+            Data:      challengeData,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        }
+        sendToAgents(roomId, nil, notice)
+        sendMessageToClient(user, notice)
+        logAt("info", roomId, user.clientId, "Verification (%s) started by %s", name, sender.clientId)
+        audit(room, sender.clientId, "verification_started", user.clientId, "challenged", map[string]interface{}{
+            "method":     name,
+            "customerId": subject.CustomerId,
+            "prompts":    len(challenge.Prompts),
+        })
+    }()
+}
+
+func handleVerifyAnswer(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    id, _ := data["verificationId"].(string)
+    raw, _ := data["answers"].(map[string]interface{})
+    answers := make(map[string]string, len(raw))
+    for key, value := range raw {
+        if text, ok := value.(string); ok {
+            answers[key] = text
+        }
+    }
+    
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        return
+    }
+    var userId string
+    var p *pendingVerification
+    roomsMu.Lock()
+    for uid, state := range room.verifications {
+        if state.pending == nil || state.pending.id != id || state.pending.challenge == nil {
+            continue
+        }
+        // Callers may answer their own challenge, agents anyone's
+        if sender.clientType == ClientTypeUser && sender.clientId != uid {
+            break
+        }
+        userId, p = uid, state.pending
+        state.pending = nil
+        state.Pending = ""
+    }
+    roomsMu.Unlock()
+    if p == nil {
+        sendError(sender, ErrTargetNotFound, msg, "no open verification %q", id)
+        return
+    }
+    go checkVerification(room, userId, sender.clientId, p, answers)
+}
+
+// checkVerification scores the answers and reports the outcome everywhere
+// it needs to go. A service failure doesn't count against the caller.
+func checkVerification(room *RoomInfo, userId string, actor string, p *pendingVerification, answers map[string]string) {
+    name := p.method.Name()
+    var result verify.Result
+    var err error
+    if !p.challenge.ExpiresAt.IsZero() && time.Now().After(p.challenge.ExpiresAt) {
+        result.Reason = "challenge expired"
+    } else {
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+        result, err = p.method.Check(ctx, p.subject, p.challenge, answers)
+        cancel()
+        if err != nil {
+            logAt("warn", room.RoomId, userId, "Verification (%s) check failed: %v", name, err)
+            result = verify.Result{Reason: "verification service unavailable"}
+        }
+    }
+    
+    now := time.Now().UnixNano() / int64(time.Millisecond)
+    roomsMu.Lock()
+    state := verificationFor(room, userId)
+    if result.Verified {
+        state.Verified = true
+        state.Method = name
+        state.CustomerId = p.subject.CustomerId
+        state.Score = result.Score
+        state.VerifiedAt = now
+    } else if err == nil {
+        state.Failures++
+    }
+    attemptsLeft := cfg.VerifyMaxAttempts - state.Failures
+    roomsMu.Unlock()
+    if attemptsLeft < 0 {
+        attemptsLeft = 0
+    }
+    
+    outcome, action := "passed", "verification_passed"
+    if err != nil {
+        outcome, action = "error", "verification_error"
+    } else if !result.Verified {
+        outcome, action = "failed", "verification_failed"
+    }
+    data := map[string]interface{}{
+        "verificationId": p.id,
+        "userId":         userId,
+        "method":         name,
+        "verified":       result.Verified,
+        "score":          result.Score,
+        "attemptsLeft":   attemptsLeft,
+    }
+    if result.Reason != "" {
+        data["reason"] = result.Reason
+    }
+    notice := &Message{
+        Id:        newMessageId(),
+        Type:      "verify_result",
+        From:      SystemSender,
+        Data:      data,
+        Timestamp: now,
+    }
+    sendToAgents(room.RoomId, nil, notice)
+    if user := findClient(room.RoomId, userId); user != nil {
+        sendMessageToClient(user, notice)
+    }
+    
+    logAt("info", room.RoomId, userId, "Verification (%s) %s: %s", name, outcome, result.Reason)
+    verificationsTotal.inc(room.labels, name, outcome)
+    emitEvent("verification", room, data)
+    detail := map[string]interface{}{
+        "method":     name,
+        "customerId": p.subject.CustomerId,
+        "score":      result.Score,
+    }
+    if result.Reason != "" {
+        detail["reason"] = result.Reason
+    }
+    audit(room, actor, action, userId, outcome, detail)
+    if result.Verified && cfg.SpeakerAutoEnroll && speakers != nil && name != "voice" {
+        if user := findClient(room.RoomId, userId); user != nil {
+            if err := enrollSpeaker(room, user, SystemSender); err != nil {
+                logAt("info", room.RoomId, userId, "Voiceprint not enrolled: %v", err)
+            }
+        }
+    }
+}
+
+// tool_authorize: {"tool": NAME, "userId": ID, "requestId": ID}
+func handleToolAuthorize(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    tool, _ := data["tool"].(string)
+    if tool == "" {
+        sendError(sender, ErrInvalidMessage, msg, "tool_authorize needs a tool")
+        return
+    }
+    room, user, err := verificationTarget(roomId, data)
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+        return
+    }
+    
+    response := map[string]interface{}{
+        "tool":      tool,
+        "userId":    user.clientId,
+        "sensitive": isSensitiveTool(tool),
+        "allowed":   true,
+    }
+    if requestId, ok := data["requestId"].(string); ok {
+        response["requestId"] = requestId
+    }
+    if isSensitiveTool(tool) {
+        roomsMu.RLock()
+        var state callerVerification
+        if room.verifications[user.clientId] != nil {
+            state = *room.verifications[user.clientId]
+        }
+        roomsMu.RUnlock()
+        
+        outcome, action := "allowed", "tool_authorized"
+        if state.current() {
+            response["method"] = state.Method
+            response["verifiedAt"] = state.VerifiedAt
+        } else {
+            outcome, action = "denied", "tool_denied"
+            response["allowed"] = false
+            response["reason"] = "caller not verified"
+            if state.Verified {
+                response["reason"] = "verification expired"
+            }
+        }
+        toolAuthorizationsTotal.inc(room.labels, outcome)
+        detail := map[string]interface{}{"tool": tool}
+        if state.Verified {
+            detail["method"] = state.Method
+            detail["customerId"] = state.CustomerId
+        }
+        if requestId, ok := response["requestId"]; ok {
+            detail["requestId"] = requestId
+        }
+        audit(room, sender.clientId, action, user.clientId, outcome, detail)
+    }
+    
+    sendMessageToClient(sender, &Message{
+        Id:        newMessageId(),
+        Type:      "tool_authorization",
+        From:      SystemSender,
+        Data:      response,
+        Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+    })
+}
+
+// GET /room/{id}/verification (admin): each caller's verification state.
+func handleRoomVerification(w http.ResponseWriter, r *http.Request, roomId string) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    room, _, _ := roomMembers(roomId)
+    if room == nil {
+        http.Error(w, "Room not found", http.StatusNotFound)
+        return
+    }
+    
+    roomsMu.RLock()
+    callers := make(map[string]callerVerification, len(room.verifications))
+    for userId, state := range room.verifications {
+        callers[userId] = *state
+    }
+    roomsMu.RUnlock()
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "roomId":         roomId,
+        "callers":        callers,
+        "methods":        verificationMethodNames(),
+        "sensitiveTools": cfg.SensitiveTools,
+    })
+}
@@ -0,0 +1,74 @@
+package verify
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+)
+
+// KBA asks questions about the account, fetched from a CRM or knowledge
+// graph lookup service. The service is posted
+//
+//   {"tenant": "...", "customerId": "...", "roomId": "..."}
+//
+// and answers {"questions": [{"id": "q1", "text": "What is your date of birth?", "answer": "1980-04-02"}, ...]}.
+// Only the questions reach the call; the answers are kept hashed.
+type KBA struct {
+    URL        string
+    APIKey     string
+    Questions  int // How many to ask, the service's first ones
+    MinCorrect int
+    Client     *http.Client
+}
+
+func (k *KBA) Name() string { return "kba" }
+
+func (k *KBA) Start(ctx context.Context, subject Subject) (*Challenge, error) {
+    if subject.CustomerId == "" {
+        return nil, fmt.Errorf("kba needs a customer ID")
+    }
+    var response struct {
+        Questions []struct {
+            Id     string `json:"id"`
+            Text   string `json:"text"`
+            Answer string `json:"answer"`
+        } `json:"questions"`
+    }
+    request := map[string]string{"tenant": subject.Tenant, "customerId": subject.CustomerId, "roomId": subject.RoomId}
+    if err := postJSON(ctx, k.Client, k.URL, k.APIKey, request, &response); err != nil {
+        return nil, fmt.Errorf("kba questions: %v", err)
+    }
+    
+    challenge := &Challenge{Secret: make(map[string]string)}
+    for _, q := range response.Questions {
+        if len(challenge.Prompts) == k.Questions {
+            break
+        }
+        if q.Id == "" || q.Text == "" || normalize(q.Answer) == "" {
+            continue
+        }
+        challenge.Prompts = append(challenge.Prompts, Prompt{Id: q.Id, Text: q.Text})
+        challenge.Secret[q.Id] = hash(q.Answer)
+    }
+    if len(challenge.Prompts) < k.MinCorrect || len(challenge.Prompts) == 0 {
+        return nil, fmt.Errorf("kba: only %d usable questions for customer", len(challenge.Prompts))
+    }
+    return challenge, nil
+}
+
+func (k *KBA) Check(ctx context.Context, subject Subject, challenge *Challenge, answers map[string]string) (Result, error) {
+    correct := 0
+    for _, p := range challenge.Prompts {
+        if matches(answers[p.Id], challenge.Secret[p.Id]) {
+            correct++
+        }
+    }
+    result := Result{
+        Verified: correct >= k.MinCorrect,
+        Score:    float64(correct) / float64(len(challenge.Prompts)),
+    }
+    if !result.Verified {
+        result.Reason = fmt.Sprintf("%d of %d answers correct", correct, len(challenge.Prompts))
+    }
+    return result, nil
+}
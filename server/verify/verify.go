@@ -0,0 +1,107 @@
+// Package verify confirms a caller is who they claim to be before the IVA
+// touches their account: knowledge-based questions, one-time passcodes and
+// voice biometrics, behind one Method interface.
+package verify
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// Subject is the caller being verified and the account they claim.
+type Subject struct {
+    Tenant     string
+    RoomId     string
+    CustomerId string
+    Phone      string // Where a passcode goes, E.164
+    Audio      []byte // Recent caller speech, 16-bit mono PCM
+    SampleRate int
+}
+
+// Prompt is one thing the caller is asked. Its Id keys the answer.
+type Prompt struct {
+    Id   string `json:"id"`
+    Text string `json:"text"`
+}
+
+// Challenge is what a Method expects back. Secret holds hashes of the
+// expected answers and never leaves the server.
+type Challenge struct {
+    Prompts   []Prompt
+    Secret    map[string]string
+    ExpiresAt time.Time // Zero for no expiry
+}
+
+type Result struct {
+    Verified bool
+    Score    float64 // 0 to 1, how sure the method is
+    Reason   string  // Why it failed, for agents and the audit log
+}
+
+type Method interface {
+    Name() string
+    // Start issues the challenge. Methods that need nothing from the caller
+    // return one without prompts and go straight to Check.
+    Start(ctx context.Context, subject Subject) (*Challenge, error)
+    Check(ctx context.Context, subject Subject, challenge *Challenge, answers map[string]string) (Result, error)
+}
+
+// normalize folds an answer to lowercase letters and digits, so "12 Oak St."
+// matches "12 oak st".
+func normalize(answer string) string {
+    var b strings.Builder
+    for _, r := range strings.ToLower(answer) {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            b.WriteRune(r)
+        }
+    }
+    return b.String()
+}
+
+func hash(answer string) string {
+    sum := sha256.Sum256([]byte(normalize(answer)))
+    return hex.EncodeToString(sum[:])
+}
+
+func matches(answer string, secret string) bool {
+    return normalize(answer) != "" && subtle.ConstantTimeCompare([]byte(hash(answer)), []byte(secret)) == 1
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, apiKey string, body interface{}, out interface{}) error {
+    data, _ := json.Marshal(body)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if apiKey != "" {
+        req.Header.Set("Authorization", "Bearer "+apiKey)
+    }
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    
+    payload, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(payload)))
+    }
+    if out == nil {
+        return nil
+    }
+    return json.Unmarshal(payload, out)
+}
@@ -0,0 +1,44 @@
+package verify
+
+import (
+    "context"
+    "fmt"
+    
+    "github.com/yourusername/my-go-project/speaker"
+)
+
+// Voice matches the caller's recent speech against the voiceprint enrolled
+// for the account they claim.
+type Voice struct {
+    Speakers   speaker.Provider
+    Threshold  float64
+    MinSeconds float64 // Less caller audio than this is not worth scoring
+}
+
+func (v *Voice) Name() string { return "voice" }
+
+func (v *Voice) Start(ctx context.Context, subject Subject) (*Challenge, error) {
+    if subject.CustomerId == "" {
+        return nil, fmt.Errorf("voice needs a customer ID")
+    }
+    return &Challenge{}, nil
+}
+
+func (v *Voice) Check(ctx context.Context, subject Subject, challenge *Challenge, answers map[string]string) (Result, error) {
+    audio := speaker.Audio{PCM: subject.Audio, SampleRate: subject.SampleRate}
+    if audio.Seconds() < v.MinSeconds {
+        return Result{Reason: "not enough caller audio"}, nil
+    }
+    score, err := v.Speakers.Verify(ctx, subject.Tenant, subject.CustomerId, audio)
+    if err == speaker.ErrNotEnrolled {
+        return Result{Reason: "no voiceprint enrolled"}, nil
+    }
+    if err != nil {
+        return Result{}, fmt.Errorf("voice match: %v", err)
+    }
+    result := Result{Verified: score >= v.Threshold, Score: score}
+    if !result.Verified {
+        result.Reason = "voice did not match"
+    }
+    return result, nil
+}
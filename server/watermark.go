@@ -0,0 +1,126 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    
+    "github.com/yourusername/my-go-project/audiogen"
+    "github.com/yourusername/my-go-project/storage"
+    "github.com/yourusername/my-go-project/watermark"
+)
+
+// Audio watermarking, on when -watermark-key is set. What callers hear from
+// the room carries an inaudible mark (package watermark) of a token standing
+// for the tenant and call: synthesized speech and fallback prompts marked as
+// machine-generated, and agents' own audio, machine-generated too for agent
+// IDs in -watermark-machine-agents, such as the IVA streaming its own TTS.
+// The token is written to the audit log as the call first plays marked
+// audio, so a leaked recording leads back to it:
+//
+//   POST /admin/watermark/detect   WAV, or raw pcm16 audio
+//
+// answers {"found", "payload": {"token", "machine"}, "confidence", "calls"},
+// calls being the audit entries of the token. It takes 8 seconds of audio at
+// 16 kHz, more is better.
+
+func watermarkEnabled() bool {
+    return cfg.WatermarkKey != ""
+}
+
+// callWatermark starts marking one stream of audio played into the room, nil
+// when watermarking is off.
+func callWatermark(roomId string, machine bool) *watermark.Embedder {
+    if !watermarkEnabled() {
+        return nil
+    }
+    roomsMu.Lock()
+    room := rooms[roomId]
+    if room == nil {
+        roomsMu.Unlock()
+        return nil
+    }
+    callId := room.RoomId
+    if room.cdr != nil {
+        callId = room.cdr.Id
+    }
+    token := watermark.Token([]byte(cfg.WatermarkKey), room.Tenant, callId)
+    if room.watermark == "" {
+        room.watermark = fmt.Sprintf("%08x", token)
+        audit(room, SystemSender, "watermark", room.watermark, "embedded", map[string]interface{}{"callId": callId, "template": room.Template})
+    }
+    roomsMu.Unlock()
+    return watermark.New([]byte(cfg.WatermarkKey), watermark.Payload{Token: token, Machine: machine}, cfg.WatermarkStrength)
+}
+
+// markAgentAudio watermarks an agent's frame for the callers. Only called
+// from the agent's read loop.
+func markAgentAudio(client *Client, data []byte) []byte {
+    if !watermarkEnabled() {
+        return data
+    }
+    if client.watermark == nil {
+        client.watermark = callWatermark(client.room, containsString(cfg.WatermarkMachineAgents, client.agentId))
+        if client.watermark == nil {
+            return data
+        }
+    }
+    return client.watermark.Embed(data)
+}
+
+// POST /admin/watermark/detect (admin)
+func handleWatermarkDetect(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    if r.Method != http.MethodPost {
+        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if !watermarkEnabled() {
+        http.Error(w, "Watermarking is not configured", http.StatusNotFound)
+        return
+    }
+    body, err := io.ReadAll(io.LimitReader(r.Body, cfg.MaxFileBytes+1))
+    if err != nil {
+        http.Error(w, "Could not read audio", http.StatusBadRequest)
+        return
+    }
+    if int64(len(body)) > cfg.MaxFileBytes {
+        http.Error(w, "Audio too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    pcm := body
+    if len(body) >= 4 && string(body[:4]) == "RIFF" {
+        samples, format, err := audiogen.ReadWAV(body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        // Mixed down but never resampled, that would smear the mark
+        pcm = audiogen.Convert(samples, format, audiogen.Format{SampleRate: format.SampleRate, Channels: 1})
+    }
+    if len(pcm)/2 < watermark.MinSamples {
+        http.Error(w, fmt.Sprintf("Need at least %d samples of audio", watermark.MinSamples), http.StatusBadRequest)
+        return
+    }
+    
+    result := watermark.Detect([]byte(cfg.WatermarkKey), pcm)
+    response := map[string]interface{}{
+        "found":      result.Found,
+        "confidence": result.Confidence,
+    }
+    if result.Found {
+        token := fmt.Sprintf("%08x", result.Payload.Token)
+        response["payload"] = map[string]interface{}{"token": token, "machine": result.Payload.Machine}
+        calls, err := store.Audit().List(r.Context(), storage.AuditFilter{Action: "watermark", Subject: token, Limit: maxAuditPage})
+        if err != nil {
+            log.Printf("Audit query for watermark %s failed: %v", token, err)
+        }
+        response["calls"] = calls
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
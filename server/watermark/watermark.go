@@ -0,0 +1,256 @@
+// Package watermark hides a 32-bit token and a machine-generated flag in
+// pcm16 audio, below the noise floor of a phone call, and finds them again
+// in a copy of it.
+//
+// The mark is spread spectrum: a keyed pseudo-random ±1 chip sequence a
+// Period samples long, added at a low fixed amplitude, each Chip-long stretch
+// of it flipped by one bit of the payload. It repeats for as long as the
+// audio runs, so any Period or more of it carries the whole payload, and
+// Detect lines the sequence up itself, wherever the copy was cut. It does not
+// survive resampling or lossy codecs that reshape the noise floor; it is meant
+// for recordings and clips taken off the call as played.
+package watermark
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "math"
+    "math/cmplx"
+)
+
+const (
+    Chip   = 2048           // Samples per payload bit
+    Bits   = 64             // Sync, flags, token, checksum
+    Period = Chip * Bits    // Samples per repetition, a power of two for the FFT
+    sync   = uint64(0xB38F) // First 16 bits of every payload
+)
+
+// DefaultStrength is the mark's amplitude as a share of full scale, about
+// -50 dBFS: under line noise, and found in one Period of speech, 8 seconds
+// at 16 kHz.
+const DefaultStrength = 0.003
+
+type Payload struct {
+    Token   uint32 `json:"token"`
+    Machine bool   `json:"machine"` // The audio was synthesized, not a person speaking
+}
+
+func (p Payload) bits() uint64 {
+    v := sync << 48
+    if p.Machine {
+        v |= 1 << 47
+    }
+    v |= uint64(p.Token) << 8
+    return v | uint64(checksum(p.Token, p.Machine))
+}
+
+func checksum(token uint32, machine bool) uint8 {
+    var b [4]byte
+    binary.BigEndian.PutUint32(b[:], token)
+    sum := b[0] ^ b[1]<<1 ^ b[2]<<2 ^ b[3]<<3
+    if machine {
+        sum ^= 0x5A
+    }
+    return sum
+}
+
+// Token derives a call's token from the key and what identifies the call,
+// e.g. tenant and call ID, so it says nothing without the key.
+func Token(key []byte, parts ...string) uint32 {
+    mac := hmac.New(sha256.New, key)
+    for _, part := range parts {
+        mac.Write([]byte(part))
+        mac.Write([]byte{0})
+    }
+    return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// chips expands the key into the Period long ±1 sequence.
+func chips(key []byte) []int8 {
+    out := make([]int8, Period)
+    mac := hmac.New(sha256.New, key)
+    var block []byte
+    var counter [8]byte
+    for i := range out {
+        if i%256 == 0 {
+            binary.BigEndian.PutUint64(counter[:], uint64(i/256))
+            mac.Reset()
+            mac.Write([]byte("watermark chips"))
+            mac.Write(counter[:])
+            block = mac.Sum(nil)
+        }
+        if block[(i%256)/8]>>(uint(i)%8)&1 == 1 {
+            out[i] = 1
+        } else {
+            out[i] = -1
+        }
+    }
+    return out
+}
+
+// Embedder marks one continuous stream. It is not safe for concurrent use.
+type Embedder struct {
+    chips     []int8
+    signs     [Bits]int8
+    amplitude float64
+    pos       int // Samples marked so far, mod Period
+}
+
+func New(key []byte, payload Payload, strength float64) *Embedder {
+    e := &Embedder{chips: chips(key), amplitude: strength * math.MaxInt16}
+    bits := payload.bits()
+    for k := 0; k < Bits; k++ {
+        if bits>>(Bits-1-k)&1 == 1 {
+            e.signs[k] = 1
+        } else {
+            e.signs[k] = -1
+        }
+    }
+    return e
+}
+
+// Embed returns a marked copy of mono pcm16 frames, carrying on from the
+// previous call.
+func (e *Embedder) Embed(pcm []byte) []byte {
+    out := make([]byte, len(pcm))
+    copy(out, pcm)
+    for i := 0; i+1 < len(out); i += 2 {
+        sample := float64(int16(binary.LittleEndian.Uint16(out[i:])))
+        sample += e.amplitude * float64(e.chips[e.pos]*e.signs[e.pos/Chip])
+        sample = math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(sample)))
+        binary.LittleEndian.PutUint16(out[i:], uint16(int16(sample)))
+        e.pos = (e.pos + 1) % Period
+    }
+    return out
+}
+
+// Result is what Detect found. Confidence is how far the best alignment
+// stands out from the rest, in standard deviations; payloads are only
+// reported past 8.
+type Result struct {
+    Found      bool    `json:"found"`
+    Payload    Payload `json:"payload"`
+    Confidence float64 `json:"confidence"`
+}
+
+// MinSamples is the least audio Detect looks at.
+const MinSamples = Period
+
+// Detect looks for a mark made with key in mono pcm16 audio.
+func Detect(key []byte, pcm []byte) Result {
+    samples := len(pcm) / 2
+    if samples < MinSamples {
+        return Result{}
+    }
+    // Fold every repetition onto one, then whiten: speech is mostly low
+    // frequencies, the chips are not
+    folded := make([]float64, Period)
+    for n := 0; n < samples; n++ {
+        folded[n%Period] += float64(int16(binary.LittleEndian.Uint16(pcm[2*n:])))
+    }
+    signal := make([]complex128, Period)
+    previous := folded[Period-1]
+    for j, v := range folded {
+        signal[j] = complex(v-previous, 0)
+        previous = v
+    }
+    fft(signal, false)
+    
+    c := chips(key)
+    whitened := make([]float64, Period)
+    for j := range c {
+        whitened[j] = float64(c[j] - c[(j+Period-1)%Period])
+    }
+    
+    // For every alignment, how strongly each bit's chips show up, summed
+    score := make([]float64, Period)
+    spectrum := make([]complex128, Period)
+    for k := 0; k < Bits; k++ {
+        for j := range spectrum {
+            spectrum[j] = 0
+        }
+        for j := k * Chip; j < (k+1)*Chip; j++ {
+            spectrum[j] = complex(whitened[j], 0)
+        }
+        fft(spectrum, false)
+        for j := range spectrum {
+            spectrum[j] *= cmplx.Conj(signal[j])
+        }
+        fft(spectrum, true)
+        for o := range score {
+            score[o] += math.Abs(real(spectrum[o]))
+        }
+    }
+    best, mean := 0, 0.0
+    for o, s := range score {
+        mean += s
+        if s > score[best] {
+            best = o
+        }
+    }
+    mean /= Period
+    variance := 0.0
+    for _, s := range score {
+        variance += (s - mean) * (s - mean)
+    }
+    result := Result{}
+    if deviation := math.Sqrt(variance / Period); deviation > 0 {
+        result.Confidence = (score[best] - mean) / deviation
+    }
+    
+    // Read the bits at the best alignment
+    var bits uint64
+    for k := 0; k < Bits; k++ {
+        sum := 0.0
+        for m := k * Chip; m < (k+1)*Chip; m++ {
+            j := (m - best + Period) % Period
+            sum += whitened[m] * (folded[j] - folded[(j+Period-1)%Period])
+        }
+        bits <<= 1
+        if sum > 0 {
+            bits |= 1
+        }
+    }
+    payload := Payload{Token: uint32(bits >> 8), Machine: bits>>47&1 == 1}
+    if bits>>48 == sync && bits>>40&0x7F == 0 && uint8(bits) == checksum(payload.Token, payload.Machine) && result.Confidence > 8 {
+        result.Found = true
+        result.Payload = payload
+    }
+    return result
+}
+
+// fft transforms x in place, len(x) a power of two. The inverse is scaled.
+func fft(x []complex128, inverse bool) {
+    n := len(x)
+    for i, j := 1, 0; i < n; i++ {
+        bit := n >> 1
+        for ; j&bit != 0; bit >>= 1 {
+            j ^= bit
+        }
+        j ^= bit
+        if i < j {
+            x[i], x[j] = x[j], x[i]
+        }
+    }
+    for size := 2; size <= n; size <<= 1 {
+        angle := -2 * math.Pi / float64(size)
+        if inverse {
+            angle = -angle
+        }
+        step := cmplx.Rect(1, angle)
+        for start := 0; start < n; start += size {
+            w := complex(1, 0)
+            for k := 0; k < size/2; k++ {
+                a, b := x[start+k], w*x[start+k+size/2]
+                x[start+k], x[start+k+size/2] = a+b, a-b
+                w *= step
+            }
+        }
+    }
+    if inverse {
+        for i := range x {
+            x[i] /= complex(float64(n), 0)
+        }
+    }
+}
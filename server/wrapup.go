@@ -0,0 +1,281 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/yourusername/my-go-project/storage"
+)
+
+// Wrap-up: once a call ends for an agent, the last caller having left or the
+// agent having left the callers, the agent has -wrap-up-time to say what came
+// of it. The disposition (a code, one of -disposition-codes when set, and
+// notes) arrives as a disposition message or through /admin/wrapups and is
+// kept with the room's CDR. Until then the wrap-up holds one of the agent
+// worker's rooms, so the router (routing.go) assigns it nothing new. Wrap-ups
+// nobody disposes of lapse at the deadline.
+
+// wrapUp is an agent's pending disposition of one call.
+type wrapUp struct {
+    Id        string `json:"id"`
+    RoomId    string `json:"roomId"`
+    CDRId     string `json:"cdrId"`
+    ClientId  string `json:"clientId"`
+    AgentId   string `json:"agentId,omitempty"`
+    Tenant    string `json:"tenant,omitempty"`
+    Template  string `json:"template,omitempty"`
+    StartedAt int64  `json:"startedAt"`
+    Deadline  int64  `json:"deadline"`
+    agent     *Client
+    timer     *time.Timer
+    callStart int64  // The call's analytics bucket
+}
+
+var (
+    wrapUps  = make(map[string]*wrapUp) // By ID
+    wrapUpMu sync.Mutex                  // Never held while taking roomsMu or routerMu
+    
+    dispositionsTotal = newCounterVec("iva_dispositions_total", "Wrap-ups by disposition code, lapsed for those never disposed of.", "code")
+)
+
+func init() {
+    metricSeries = append(metricSeries, dispositionsTotal)
+}
+
+// noteCallEnded starts wrap-ups as a client leaves: for every agent left in
+// the room once the last caller goes, or for an agent leaving callers behind.
+// Callers hold roomsMu.
+func noteCallEnded(room *RoomInfo, client *Client) {
+    if cfg.WrapUpTime <= 0 {
+        return
+    }
+    if client.clientType == ClientTypeAgent {
+        if len(room.Users) > 0 {
+            startWrapUp(room, client)
+        }
+        return
+    }
+    if len(room.Users) == 0 {
+        for _, agent := range room.Agents {
+            startWrapUp(room, agent)
+        }
+    }
+}
+
+// startWrapUp opens a wrap-up unless the agent already has one for the
+// room. Callers hold roomsMu.
+func startWrapUp(room *RoomInfo, agent *Client) {
+    now := time.Now()
+    wrap := &wrapUp{
+        Id:        newRandomId(),
+        RoomId:    room.RoomId,
+        CDRId:     room.cdr.Id,
+        ClientId:  agent.clientId,
+        AgentId:   agent.agentId,
+        Tenant:    room.Tenant,
+        Template:  room.Template,
+        StartedAt: now.UnixNano() / int64(time.Millisecond),
+        Deadline:  now.Add(cfg.WrapUpTime).UnixNano() / int64(time.Millisecond),
+        agent:     agent,
+        callStart: room.CreatedAt,
+    }
+    wrapUpMu.Lock()
+    for _, open := range wrapUps {
+        if open.ClientId == agent.clientId && open.CDRId == wrap.CDRId {
+            wrapUpMu.Unlock()
+            return
+        }
+    }
+    wrapUps[wrap.Id] = wrap
+    wrap.timer = time.AfterFunc(cfg.WrapUpTime, func() { lapseWrapUp(wrap.Id) })
+    wrapUpMu.Unlock()
+    
+    emitEvent("wrap_up_started", room, map[string]interface{}{"wrapUpId": wrap.Id, "clientId": wrap.ClientId, "agentId": wrap.AgentId, "deadline": wrap.Deadline})
+    if agent.currentState() != ClientActive {
+        // Left with the call, the wrap-up comes through /admin/wrapups
+        return
+    }
+    sendMessageToClient(agent, &Message{
+        Id:   newMessageId(),
+        Type: "wrap_up_started",
+        From: SystemSender,
+        Data: map[string]interface{}{
+            "wrapUpId": wrap.Id,
+            "roomId":   wrap.RoomId,
+            "deadline": wrap.Deadline,
+            "codes":    cfg.DispositionCodes,
+        },
+        Timestamp: wrap.StartedAt,
+    })
+}
+
+// wrapUpLoads counts each agent worker's open wrap-ups by room, keyed like
+// workerLoads.
+func wrapUpLoads() map[string]bool {
+    wrapUpMu.Lock()
+    defer wrapUpMu.Unlock()
+    open := make(map[string]bool, len(wrapUps))
+    for _, wrap := range wrapUps {
+        if wrap.AgentId != "" {
+            open[wrap.AgentId+"/"+wrap.RoomId] = true
+        }
+    }
+    return open
+}
+
+func lapseWrapUp(id string) {
+    wrapUpMu.Lock()
+    wrap := wrapUps[id]
+    delete(wrapUps, id)
+    wrapUpMu.Unlock()
+    if wrap == nil {
+        return
+    }
+    logAt("info", wrap.RoomId, wrap.ClientId, "Wrap-up lapsed without a disposition")
+    dispositionsTotal.inc(metricLabelsFor(wrap.Tenant, wrap.Template), "lapsed")
+    endWrapUp(wrap, nil)
+}
+
+// dispose records a disposition and ends the wrap-up.
+func dispose(id string, code string, notes string) (*wrapUp, error) {
+    code = strings.TrimSpace(code)
+    if code == "" {
+        return nil, newCodedError(ErrInvalidMessage, "a disposition code is required")
+    }
+    if len(cfg.DispositionCodes) > 0 && !containsString(cfg.DispositionCodes, code) {
+        return nil, newCodedError(ErrInvalidMessage, "unknown disposition code %q", code)
+    }
+    wrapUpMu.Lock()
+    wrap := wrapUps[id]
+    delete(wrapUps, id)
+    wrapUpMu.Unlock()
+    if wrap == nil {
+        return nil, newCodedError(ErrTargetNotFound, "no open wrap-up %q", id)
+    }
+    wrap.timer.Stop()
+    
+    disposition := storage.CDRDisposition{
+        ClientId:    wrap.ClientId,
+        AgentId:     wrap.AgentId,
+        Code:        code,
+        Notes:       notes,
+        SubmittedAt: time.Now().UnixNano() / int64(time.Millisecond),
+    }
+    // Kept with the open room's CDR, or added to the saved one
+    roomsMu.Lock()
+    if room := rooms[wrap.RoomId]; room != nil && room.cdr.Id == wrap.CDRId {
+        room.cdr.Dispositions = append(room.cdr.Dispositions, disposition)
+    } else {
+        persist(wrap.RoomId, func(ctx context.Context) error {
+            return store.CDRs().Dispose(ctx, wrap.CDRId, disposition)
+        })
+    }
+    roomsMu.Unlock()
+    
+    logAt("info", wrap.RoomId, wrap.ClientId, "Disposition %s", code)
+    dispositionsTotal.inc(metricLabelsFor(wrap.Tenant, wrap.Template), code)
+    endWrapUp(wrap, &disposition)
+    return wrap, nil
+}
+
+// endWrapUp tells the agent and hands the freed capacity to waiting rooms.
+func endWrapUp(wrap *wrapUp, disposition *storage.CDRDisposition) {
+    data := map[string]interface{}{"wrapUpId": wrap.Id, "clientId": wrap.ClientId, "agentId": wrap.AgentId, "lapsed": disposition == nil}
+    if disposition != nil {
+        data["code"] = disposition.Code
+        data["notes"] = disposition.Notes
+    }
+    emitEvent("wrap_up_ended", &RoomInfo{RoomId: wrap.RoomId, Tenant: wrap.Tenant}, data)
+    rollupWrapUp(wrap, time.Now().UnixNano()/int64(time.Millisecond))
+    if wrap.agent.currentState() == ClientActive {
+        sendMessageToClient(wrap.agent, &Message{
+            Id:        newMessageId(),
+            Type:      "wrap_up_ended",
+            From:      SystemSender,
+            Data:      data,
+            Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+        })
+    }
+    go routeWaiting()
+}
+
+// disposition: {"code": CODE, "notes": TEXT, "wrapUpId": ID}, the wrap-up
+// defaulting to the sender's for this room.
+func handleDisposition(roomId string, sender *Client, msg *Message) {
+    data, _ := msg.Data.(map[string]interface{})
+    id, _ := data["wrapUpId"].(string)
+    code, _ := data["code"].(string)
+    notes, _ := data["notes"].(string)
+    wrapUpMu.Lock()
+    for _, wrap := range wrapUps {
+        if id == "" && wrap.ClientId == sender.clientId && wrap.RoomId == roomId {
+            id = wrap.Id
+        }
+    }
+    wrap := wrapUps[id]
+    wrapUpMu.Unlock()
+    var err error
+    if wrap != nil && wrap.ClientId != sender.clientId {
+        // Somebody else's, only an admin may close it
+        err = newCodedError(ErrNotPermitted, "wrap-up %q belongs to %s", id, wrap.ClientId)
+    } else {
+        _, err = dispose(id, code, notes)
+    }
+    if err != nil {
+        sendError(sender, errorCode(err, ErrInvalidMessage), msg, "%v", err)
+    }
+}
+
+// /admin/wrapups (admin):
+//
+//   GET  /admin/wrapups[?agentId=]  open wrap-ups, oldest first
+//   POST /admin/wrapups/ID          {"code", "notes"}
+func handleWrapUps(w http.ResponseWriter, r *http.Request) {
+    if !requireAdmin(w, r) {
+        return
+    }
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/wrapups"), "/")
+    
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        agentId := r.URL.Query().Get("agentId")
+        wrapUpMu.Lock()
+        list := make([]wrapUp, 0, len(wrapUps))
+        for _, wrap := range wrapUps {
+            if agentId == "" || wrap.AgentId == agentId {
+                list = append(list, *wrap)
+            }
+        }
+        wrapUpMu.Unlock()
+        sort.Slice(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"wrapUps": list})
+    case id != "" && r.Method == http.MethodPost:
+        var body struct {
+            Code  string `json:"code"`
+            Notes string `json:"notes"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        wrap, err := dispose(id, body.Code, body.Notes)
+        if err != nil {
+            status := http.StatusBadRequest
+            if errorCode(err, "") == ErrTargetNotFound {
+                status = http.StatusNotFound
+            }
+            http.Error(w, err.Error(), status)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(wrap)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}